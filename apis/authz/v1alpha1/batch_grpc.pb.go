@@ -0,0 +1,121 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: apis/authz/v1alpha1/batch.proto
+
+package authzv1alpha1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	BatchAuthorization_BatchCheck_FullMethodName = "/kyverno.authz.v1alpha1.BatchAuthorization/BatchCheck"
+)
+
+// BatchAuthorizationClient is the client API for BatchAuthorization service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type BatchAuthorizationClient interface {
+	BatchCheck(ctx context.Context, in *BatchCheckRequest, opts ...grpc.CallOption) (*BatchCheckResponse, error)
+}
+
+type batchAuthorizationClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewBatchAuthorizationClient(cc grpc.ClientConnInterface) BatchAuthorizationClient {
+	return &batchAuthorizationClient{cc}
+}
+
+func (c *batchAuthorizationClient) BatchCheck(ctx context.Context, in *BatchCheckRequest, opts ...grpc.CallOption) (*BatchCheckResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchCheckResponse)
+	err := c.cc.Invoke(ctx, BatchAuthorization_BatchCheck_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// BatchAuthorizationServer is the server API for BatchAuthorization service.
+// All implementations must embed UnimplementedBatchAuthorizationServer
+// for forward compatibility.
+type BatchAuthorizationServer interface {
+	BatchCheck(context.Context, *BatchCheckRequest) (*BatchCheckResponse, error)
+	mustEmbedUnimplementedBatchAuthorizationServer()
+}
+
+// UnimplementedBatchAuthorizationServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedBatchAuthorizationServer struct{}
+
+func (UnimplementedBatchAuthorizationServer) BatchCheck(context.Context, *BatchCheckRequest) (*BatchCheckResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchCheck not implemented")
+}
+func (UnimplementedBatchAuthorizationServer) mustEmbedUnimplementedBatchAuthorizationServer() {}
+func (UnimplementedBatchAuthorizationServer) testEmbeddedByValue()                            {}
+
+// UnsafeBatchAuthorizationServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to BatchAuthorizationServer will
+// result in compilation errors.
+type UnsafeBatchAuthorizationServer interface {
+	mustEmbedUnimplementedBatchAuthorizationServer()
+}
+
+func RegisterBatchAuthorizationServer(s grpc.ServiceRegistrar, srv BatchAuthorizationServer) {
+	// If the following call pancis, it indicates UnimplementedBatchAuthorizationServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&BatchAuthorization_ServiceDesc, srv)
+}
+
+func _BatchAuthorization_BatchCheck_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchCheckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BatchAuthorizationServer).BatchCheck(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BatchAuthorization_BatchCheck_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BatchAuthorizationServer).BatchCheck(ctx, req.(*BatchCheckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// BatchAuthorization_ServiceDesc is the grpc.ServiceDesc for BatchAuthorization service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var BatchAuthorization_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "kyverno.authz.v1alpha1.BatchAuthorization",
+	HandlerType: (*BatchAuthorizationServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "BatchCheck",
+			Handler:    _BatchAuthorization_BatchCheck_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "apis/authz/v1alpha1/batch.proto",
+}
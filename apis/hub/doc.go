@@ -0,0 +1,9 @@
+// Package hub contains the internal version of the envoy.kyverno.io types.
+//
+// The hub version is never served nor stored, every served version converts to and from the hub and
+// the compiler, the reconciler and the policy providers only operate on hub types. Adding a served version
+// only requires conversion functions between the new version and the hub, served versions never convert
+// to each other directly.
+//
+// +kubebuilder:object:generate=true
+package hub
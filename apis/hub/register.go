@@ -0,0 +1,25 @@
+package hub
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GroupName is the group of the hub types, it is the group of the served versions.
+const GroupName = "envoy.kyverno.io"
+
+// SchemeGroupVersion is the internal version of the group.
+var SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: runtime.APIVersionInternal}
+
+var (
+	// SchemeBuilder registers the hub types, a scheme knowing the hub and the served versions
+	// is what the conversion webhook needs to convert between served versions.
+	SchemeBuilder = runtime.NewSchemeBuilder(addKnownTypes)
+	// Install is a function which adds the hub types to a scheme.
+	Install = SchemeBuilder.AddToScheme
+)
+
+func addKnownTypes(scheme *runtime.Scheme) error {
+	scheme.AddKnownTypes(SchemeGroupVersion, &AuthorizationPolicy{})
+	return nil
+}
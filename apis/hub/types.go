@@ -0,0 +1,201 @@
+package hub
+
+import (
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:object:root=true
+
+// AuthorizationPolicy is the internal version of an authorization policy, see the served versions for the fields documentation
+type AuthorizationPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              AuthorizationPolicySpec   `json:"spec"`
+	Status            AuthorizationPolicyStatus `json:"status,omitempty"`
+}
+
+// AuthorizationPolicySpec is the internal version of an authorization policy spec
+type AuthorizationPolicySpec struct {
+	Priority          int32                                      `json:"priority,omitempty"`
+	FailurePolicy     *admissionregistrationv1.FailurePolicyType `json:"failurePolicy,omitempty"`
+	EnforcementMode   EnforcementMode                            `json:"enforcementMode,omitempty"`
+	Sequential        bool                                       `json:"sequential,omitempty"`
+	Override          bool                                       `json:"override,omitempty"`
+	Disabled          bool                                       `json:"disabled,omitempty"`
+	Activation        *Activation                                `json:"activation,omitempty"`
+	Scope             []string                                   `json:"scope,omitempty"`
+	Phase             Phase                                      `json:"phase,omitempty"`
+	TargetConditions  []admissionregistrationv1.MatchCondition   `json:"targetConditions,omitempty"`
+	MatchConditions   []admissionregistrationv1.MatchCondition   `json:"matchConditions,omitempty"`
+	ExcludeConditions []admissionregistrationv1.MatchCondition   `json:"excludeConditions,omitempty"`
+	Variables         []admissionregistrationv1.Variable         `json:"variables,omitempty"`
+	Data              []DataSource                               `json:"data,omitempty"`
+	Combine           Combine                                    `json:"combine,omitempty"`
+	Authorizations    []Authorization                            `json:"authorizations,omitempty"`
+	Headers           *Headers                                   `json:"headers,omitempty"`
+	DenyResponse      *DenyResponse                              `json:"denyResponse,omitempty"`
+	Reason            string                                     `json:"reason,omitempty"`
+	Cache             *DecisionCache                             `json:"cache,omitempty"`
+	Rollout           *Rollout                                   `json:"rollout,omitempty"`
+	RateLimit         *RateLimit                                 `json:"rateLimit,omitempty"`
+}
+
+// Rollout defines the fraction of the requests a policy applies to
+type Rollout struct {
+	Percentage int32  `json:"percentage"`
+	Key        string `json:"key"`
+}
+
+// Activation defines when a policy is evaluated
+type Activation struct {
+	From     *metav1.Time       `json:"from,omitempty"`
+	Until    *metav1.Time       `json:"until,omitempty"`
+	TimeZone string             `json:"timeZone,omitempty"`
+	Windows  []ActivationWindow `json:"windows,omitempty"`
+}
+
+// ActivationWindow is a time window recurring on some days of the week
+type ActivationWindow struct {
+	Days  []Weekday `json:"days,omitempty"`
+	Start string    `json:"start"`
+	End   string    `json:"end"`
+}
+
+// Weekday is a day of the week
+type Weekday string
+
+// RateLimit defines the rate limit descriptor entries of a policy
+type RateLimit struct {
+	Entries string `json:"entries"`
+}
+
+// DecisionCache defines how the decisions of a policy are cached
+type DecisionCache struct {
+	Key string          `json:"key"`
+	TTL metav1.Duration `json:"ttl"`
+}
+
+// DataSource references a ConfigMap or a Secret whose entries are available to the policy expressions
+type DataSource struct {
+	Name      string               `json:"name"`
+	ConfigMap *DataSourceReference `json:"configMap,omitempty"`
+	Secret    *DataSourceReference `json:"secret,omitempty"`
+}
+
+// DataSourceReference references a namespaced resource
+type DataSourceReference struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// Headers defines header mutations
+type Headers struct {
+	Request     []HeaderMutation `json:"request,omitempty"`
+	Response    []HeaderMutation `json:"response,omitempty"`
+	RequestMap  string           `json:"requestMap,omitempty"`
+	ResponseMap string           `json:"responseMap,omitempty"`
+}
+
+// DenyResponse defines the response returned to the client when a policy denies a request
+type DenyResponse struct {
+	Status      string           `json:"status,omitempty"`
+	Headers     []HeaderMutation `json:"headers,omitempty"`
+	Body        string           `json:"body,omitempty"`
+	Location    string           `json:"location,omitempty"`
+	ContentType string           `json:"contentType,omitempty"`
+	GrpcStatus  string           `json:"grpcStatus,omitempty"`
+	GrpcMessage string           `json:"grpcMessage,omitempty"`
+}
+
+// HeaderAction defines the action of a header mutation
+type HeaderAction string
+
+const (
+	HeaderActionSet    HeaderAction = "Set"
+	HeaderActionAppend HeaderAction = "Append"
+	HeaderActionRemove HeaderAction = "Remove"
+)
+
+// HeaderMutation defines a header mutation
+type HeaderMutation struct {
+	Name       string       `json:"name"`
+	Action     HeaderAction `json:"action,omitempty"`
+	Expression string       `json:"expression,omitempty"`
+}
+
+func (m *HeaderMutation) GetAction() HeaderAction {
+	if m.Action == "" {
+		return HeaderActionSet
+	}
+	return m.Action
+}
+
+func (s *AuthorizationPolicySpec) GetFailurePolicy() admissionregistrationv1.FailurePolicyType {
+	if s.FailurePolicy == nil {
+		return admissionregistrationv1.Fail
+	}
+	return *s.FailurePolicy
+}
+
+func (s *AuthorizationPolicySpec) GetEnforcementMode() EnforcementMode {
+	if s.EnforcementMode == "" {
+		return EnforcementModeEnforce
+	}
+	return s.EnforcementMode
+}
+
+// EnforcementMode defines how a policy decision is enforced
+type EnforcementMode string
+
+const (
+	EnforcementModeEnforce EnforcementMode = "Enforce"
+	EnforcementModeAudit   EnforcementMode = "Audit"
+)
+
+func (s *AuthorizationPolicySpec) GetPhase() Phase {
+	if s.Phase == "" {
+		return PhaseRequest
+	}
+	return s.Phase
+}
+
+// Phase defines the phase of the checks a policy is evaluated in
+type Phase string
+
+const (
+	PhaseRequest  Phase = "Request"
+	PhaseResponse Phase = "Response"
+)
+
+func (s *AuthorizationPolicySpec) GetCombine() Combine {
+	if s.Combine == "" {
+		return CombineFirstMatch
+	}
+	return s.Combine
+}
+
+// Combine defines how the decisions of the authorizations of a policy are combined
+type Combine string
+
+const (
+	CombineFirstMatch   Combine = "FirstMatch"
+	CombineAnyDeny      Combine = "AnyDeny"
+	CombineAllMustAllow Combine = "AllMustAllow"
+)
+
+// Authorization defines an authorization policy rule
+type Authorization struct {
+	Name       string `json:"name,omitempty"`
+	Match      string `json:"match,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	Expression string `json:"expression"`
+}
+
+// AuthorizationPolicyStatus is the internal version of an authorization policy status
+type AuthorizationPolicyStatus struct {
+	Conditions    []metav1.Condition `json:"conditions,omitempty"`
+	EstimatedCost int64              `json:"estimatedCost,omitempty"`
+	Hash          string             `json:"hash,omitempty"`
+}
@@ -0,0 +1,259 @@
+package v1alpha1
+
+import (
+	"slices"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func init() {
+	localSchemeBuilder.Register(RegisterConversions)
+}
+
+// RegisterConversions registers the conversions between v1alpha1 and the hub version
+func RegisterConversions(s *runtime.Scheme) error {
+	if err := s.AddConversionFunc((*AuthorizationPolicy)(nil), (*hub.AuthorizationPolicy)(nil), func(a, b any, scope conversion.Scope) error {
+		return Convert_v1alpha1_AuthorizationPolicy_To_hub_AuthorizationPolicy(a.(*AuthorizationPolicy), b.(*hub.AuthorizationPolicy), scope)
+	}); err != nil {
+		return err
+	}
+	return s.AddConversionFunc((*hub.AuthorizationPolicy)(nil), (*AuthorizationPolicy)(nil), func(a, b any, scope conversion.Scope) error {
+		return Convert_hub_AuthorizationPolicy_To_v1alpha1_AuthorizationPolicy(a.(*hub.AuthorizationPolicy), b.(*AuthorizationPolicy), scope)
+	})
+}
+
+// Convert_v1alpha1_AuthorizationPolicy_To_hub_AuthorizationPolicy converts a policy to the hub version,
+// the type meta is not converted and the hub shares no memory with the policy
+func Convert_v1alpha1_AuthorizationPolicy_To_hub_AuthorizationPolicy(in *AuthorizationPolicy, out *hub.AuthorizationPolicy, _ conversion.Scope) error {
+	out.ObjectMeta = *in.ObjectMeta.DeepCopy()
+	out.Spec = hub.AuthorizationPolicySpec{
+		Priority:          in.Spec.Priority,
+		FailurePolicy:     clonePointer(in.Spec.FailurePolicy),
+		EnforcementMode:   hub.EnforcementMode(in.Spec.EnforcementMode),
+		Sequential:        in.Spec.Sequential,
+		Override:          in.Spec.Override,
+		Disabled:          in.Spec.Disabled,
+		Activation:        convertActivationToHub(in.Spec.Activation),
+		Scope:             slices.Clone(in.Spec.Scope),
+		Phase:             hub.Phase(in.Spec.Phase),
+		TargetConditions:  slices.Clone(in.Spec.TargetConditions),
+		MatchConditions:   slices.Clone(in.Spec.MatchConditions),
+		ExcludeConditions: slices.Clone(in.Spec.ExcludeConditions),
+		Variables:         slices.Clone(in.Spec.Variables),
+		Data:              convertSlice(in.Spec.Data, convertDataSourceToHub),
+		Combine:           hub.Combine(in.Spec.Combine),
+		Authorizations:    convertSlice(in.Spec.Authorizations, func(in Authorization) hub.Authorization { return hub.Authorization(in) }),
+		Headers:           convertHeadersToHub(in.Spec.Headers),
+		DenyResponse:      convertDenyResponseToHub(in.Spec.DenyResponse),
+		Reason:            in.Spec.Reason,
+		Cache:             convertDecisionCacheToHub(in.Spec.Cache),
+		Rollout:           (*hub.Rollout)(clonePointer(in.Spec.Rollout)),
+		RateLimit:         (*hub.RateLimit)(clonePointer(in.Spec.RateLimit)),
+	}
+	out.Status = hub.AuthorizationPolicyStatus{
+		Conditions:    slices.Clone(in.Status.Conditions),
+		EstimatedCost: in.Status.EstimatedCost,
+		Hash:          in.Status.Hash,
+	}
+	return nil
+}
+
+// Convert_hub_AuthorizationPolicy_To_v1alpha1_AuthorizationPolicy converts a policy from the hub version,
+// the type meta is not converted and the policy shares no memory with the hub
+func Convert_hub_AuthorizationPolicy_To_v1alpha1_AuthorizationPolicy(in *hub.AuthorizationPolicy, out *AuthorizationPolicy, _ conversion.Scope) error {
+	out.ObjectMeta = *in.ObjectMeta.DeepCopy()
+	out.Spec = AuthorizationPolicySpec{
+		Priority:          in.Spec.Priority,
+		FailurePolicy:     clonePointer(in.Spec.FailurePolicy),
+		EnforcementMode:   EnforcementMode(in.Spec.EnforcementMode),
+		Sequential:        in.Spec.Sequential,
+		Override:          in.Spec.Override,
+		Disabled:          in.Spec.Disabled,
+		Activation:        convertActivationFromHub(in.Spec.Activation),
+		Scope:             slices.Clone(in.Spec.Scope),
+		Phase:             Phase(in.Spec.Phase),
+		TargetConditions:  slices.Clone(in.Spec.TargetConditions),
+		MatchConditions:   slices.Clone(in.Spec.MatchConditions),
+		ExcludeConditions: slices.Clone(in.Spec.ExcludeConditions),
+		Variables:         slices.Clone(in.Spec.Variables),
+		Data:              convertSlice(in.Spec.Data, convertDataSourceFromHub),
+		Combine:           Combine(in.Spec.Combine),
+		Authorizations:    convertSlice(in.Spec.Authorizations, func(in hub.Authorization) Authorization { return Authorization(in) }),
+		Headers:           convertHeadersFromHub(in.Spec.Headers),
+		DenyResponse:      convertDenyResponseFromHub(in.Spec.DenyResponse),
+		Reason:            in.Spec.Reason,
+		Cache:             convertDecisionCacheFromHub(in.Spec.Cache),
+		Rollout:           (*Rollout)(clonePointer(in.Spec.Rollout)),
+		RateLimit:         (*RateLimit)(clonePointer(in.Spec.RateLimit)),
+	}
+	out.Status = AuthorizationPolicyStatus{
+		Conditions:    slices.Clone(in.Status.Conditions),
+		EstimatedCost: in.Status.EstimatedCost,
+		Hash:          in.Status.Hash,
+	}
+	return nil
+}
+
+func convertDataSourceToHub(in DataSource) hub.DataSource {
+	return hub.DataSource{
+		Name:      in.Name,
+		ConfigMap: (*hub.DataSourceReference)(clonePointer(in.ConfigMap)),
+		Secret:    (*hub.DataSourceReference)(clonePointer(in.Secret)),
+	}
+}
+
+func convertDataSourceFromHub(in hub.DataSource) DataSource {
+	return DataSource{
+		Name:      in.Name,
+		ConfigMap: (*DataSourceReference)(clonePointer(in.ConfigMap)),
+		Secret:    (*DataSourceReference)(clonePointer(in.Secret)),
+	}
+}
+
+func convertHeadersToHub(in *Headers) *hub.Headers {
+	if in == nil {
+		return nil
+	}
+	return &hub.Headers{
+		Request:     convertSlice(in.Request, convertHeaderMutationToHub),
+		Response:    convertSlice(in.Response, convertHeaderMutationToHub),
+		RequestMap:  in.RequestMap,
+		ResponseMap: in.ResponseMap,
+	}
+}
+
+func convertHeadersFromHub(in *hub.Headers) *Headers {
+	if in == nil {
+		return nil
+	}
+	return &Headers{
+		Request:     convertSlice(in.Request, convertHeaderMutationFromHub),
+		Response:    convertSlice(in.Response, convertHeaderMutationFromHub),
+		RequestMap:  in.RequestMap,
+		ResponseMap: in.ResponseMap,
+	}
+}
+
+func convertDenyResponseToHub(in *DenyResponse) *hub.DenyResponse {
+	if in == nil {
+		return nil
+	}
+	return &hub.DenyResponse{
+		Status:      in.Status,
+		Headers:     convertSlice(in.Headers, convertHeaderMutationToHub),
+		Body:        in.Body,
+		Location:    in.Location,
+		ContentType: in.ContentType,
+		GrpcStatus:  in.GrpcStatus,
+		GrpcMessage: in.GrpcMessage,
+	}
+}
+
+func convertDenyResponseFromHub(in *hub.DenyResponse) *DenyResponse {
+	if in == nil {
+		return nil
+	}
+	return &DenyResponse{
+		Status:      in.Status,
+		Headers:     convertSlice(in.Headers, convertHeaderMutationFromHub),
+		Body:        in.Body,
+		Location:    in.Location,
+		ContentType: in.ContentType,
+		GrpcStatus:  in.GrpcStatus,
+		GrpcMessage: in.GrpcMessage,
+	}
+}
+
+func convertActivationToHub(in *Activation) *hub.Activation {
+	if in == nil {
+		return nil
+	}
+	return &hub.Activation{
+		From:     in.From.DeepCopy(),
+		Until:    in.Until.DeepCopy(),
+		TimeZone: in.TimeZone,
+		Windows: convertSlice(in.Windows, func(in ActivationWindow) hub.ActivationWindow {
+			return hub.ActivationWindow{
+				Days:  convertSlice(in.Days, func(in Weekday) hub.Weekday { return hub.Weekday(in) }),
+				Start: in.Start,
+				End:   in.End,
+			}
+		}),
+	}
+}
+
+func convertActivationFromHub(in *hub.Activation) *Activation {
+	if in == nil {
+		return nil
+	}
+	return &Activation{
+		From:     in.From.DeepCopy(),
+		Until:    in.Until.DeepCopy(),
+		TimeZone: in.TimeZone,
+		Windows: convertSlice(in.Windows, func(in hub.ActivationWindow) ActivationWindow {
+			return ActivationWindow{
+				Days:  convertSlice(in.Days, func(in hub.Weekday) Weekday { return Weekday(in) }),
+				Start: in.Start,
+				End:   in.End,
+			}
+		}),
+	}
+}
+
+func convertDecisionCacheToHub(in *DecisionCache) *hub.DecisionCache {
+	if in == nil {
+		return nil
+	}
+	return &hub.DecisionCache{
+		Key: in.Key,
+		TTL: in.TTL,
+	}
+}
+
+func convertDecisionCacheFromHub(in *hub.DecisionCache) *DecisionCache {
+	if in == nil {
+		return nil
+	}
+	return &DecisionCache{
+		Key: in.Key,
+		TTL: in.TTL,
+	}
+}
+
+func convertHeaderMutationToHub(in HeaderMutation) hub.HeaderMutation {
+	return hub.HeaderMutation{
+		Name:       in.Name,
+		Action:     hub.HeaderAction(in.Action),
+		Expression: in.Expression,
+	}
+}
+
+func convertHeaderMutationFromHub(in hub.HeaderMutation) HeaderMutation {
+	return HeaderMutation{
+		Name:       in.Name,
+		Action:     HeaderAction(in.Action),
+		Expression: in.Expression,
+	}
+}
+
+// convertSlice converts every element of a slice, a nil slice stays nil so that round trips are lossless
+func convertSlice[I any, O any](in []I, convert func(I) O) []O {
+	if in == nil {
+		return nil
+	}
+	out := make([]O, 0, len(in))
+	for _, i := range in {
+		out = append(out, convert(i))
+	}
+	return out
+}
+
+func clonePointer[T any](in *T) *T {
+	if in == nil {
+		return nil
+	}
+	out := *in
+	return &out
+}
@@ -0,0 +1,87 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	fuzz "github.com/google/gofuzz"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newConversionScheme(t testing.TB) *runtime.Scheme {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	require.NoError(t, hub.Install(scheme))
+	require.NoError(t, Install(scheme))
+	return scheme
+}
+
+func roundTripToHub(t *testing.T, scheme *runtime.Scheme, fuzzer *fuzz.Fuzzer) {
+	t.Helper()
+	var policy AuthorizationPolicy
+	fuzzer.Fuzz(&policy)
+	// conversions don't set the type meta, the api server sets it
+	policy.TypeMeta = metav1.TypeMeta{}
+	original := policy.DeepCopy()
+	var converted hub.AuthorizationPolicy
+	require.NoError(t, scheme.Convert(&policy, &converted, nil))
+	var out AuthorizationPolicy
+	require.NoError(t, scheme.Convert(&converted, &out, nil))
+	assert.Equal(t, original, &out)
+	// the conversion doesn't modify the source
+	assert.Equal(t, original, &policy)
+}
+
+func roundTripFromHub(t *testing.T, scheme *runtime.Scheme, fuzzer *fuzz.Fuzzer) {
+	t.Helper()
+	var policy hub.AuthorizationPolicy
+	fuzzer.Fuzz(&policy)
+	// conversions don't set the type meta, the api server sets it
+	policy.TypeMeta = metav1.TypeMeta{}
+	original := policy.DeepCopy()
+	var converted AuthorizationPolicy
+	require.NoError(t, scheme.Convert(&policy, &converted, nil))
+	var out hub.AuthorizationPolicy
+	require.NoError(t, scheme.Convert(&converted, &out, nil))
+	assert.Equal(t, original, &out)
+	assert.Equal(t, original, &policy)
+}
+
+func TestAuthorizationPolicy_roundTrip(t *testing.T) {
+	scheme := newConversionScheme(t)
+	for seed := range int64(200) {
+		fuzzer := fuzz.NewWithSeed(seed).NilChance(0.3)
+		roundTripToHub(t, scheme, fuzzer)
+		roundTripFromHub(t, scheme, fuzzer)
+	}
+}
+
+func TestConvert_v1alpha1_AuthorizationPolicy_To_hub_AuthorizationPolicy(t *testing.T) {
+	policy := AuthorizationPolicy{
+		Spec: AuthorizationPolicySpec{
+			Authorizations: []Authorization{{Expression: "a"}},
+			Headers:        &Headers{Request: []HeaderMutation{{Name: "x-a"}}},
+		},
+	}
+	var converted hub.AuthorizationPolicy
+	require.NoError(t, Convert_v1alpha1_AuthorizationPolicy_To_hub_AuthorizationPolicy(&policy, &converted, nil))
+	converted.Spec.Authorizations[0].Expression = "b"
+	converted.Spec.Headers.Request[0].Name = "x-b"
+	assert.Equal(t, "a", policy.Spec.Authorizations[0].Expression)
+	assert.Equal(t, "x-a", policy.Spec.Headers.Request[0].Name)
+}
+
+func FuzzAuthorizationPolicy_roundTrip(f *testing.F) {
+	scheme := newConversionScheme(f)
+	for seed := range int64(10) {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, seed int64) {
+		fuzzer := fuzz.NewWithSeed(seed).NilChance(0.3)
+		roundTripToHub(t, scheme, fuzzer)
+		roundTripFromHub(t, scheme, fuzzer)
+	})
+}
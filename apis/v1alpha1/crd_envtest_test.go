@@ -0,0 +1,43 @@
+package v1alpha1
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+// TestCRDValidation_apiServer creates the policies in a real api server, it needs the envtest binaries
+func TestCRDValidation_apiServer(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set")
+	}
+	environment := &envtest.Environment{
+		CRDDirectoryPaths:     []string{"../../.crds"},
+		ErrorIfCRDPathMissing: true,
+	}
+	config, err := environment.Start()
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, environment.Stop())
+	}()
+	c, err := client.New(config, client.Options{})
+	require.NoError(t, err)
+	for _, tt := range specs {
+		t.Run(tt.name, func(t *testing.T) {
+			object := &unstructured.Unstructured{Object: policyObject(t, tt.spec)}
+			err := c.Create(context.Background(), object)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.NoError(t, c.Delete(context.Background(), object))
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,389 @@
+package v1alpha1
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	crdvalidation "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/validation"
+	structuralschema "k8s.io/apiextensions-apiserver/pkg/apiserver/schema"
+	"k8s.io/apiextensions-apiserver/pkg/apiserver/schema/cel"
+	"k8s.io/apiextensions-apiserver/pkg/apiserver/schema/defaulting"
+	"k8s.io/apiextensions-apiserver/pkg/apiserver/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	celconfig "k8s.io/apiserver/pkg/apis/cel"
+	"sigs.k8s.io/yaml"
+)
+
+const crdPath = "../../.crds/envoy.kyverno.io_authorizationpolicies.yaml"
+
+// specs are policy specs and the error the api server rejects them with, valid specs have no error
+var specs = []struct {
+	name    string
+	spec    string
+	wantErr string
+}{{
+	name: "valid",
+	spec: `
+authorizations:
+- expression: envoy.Allowed().Response()
+headers:
+  request:
+  - name: x-removed
+    action: Remove
+  response:
+  - name: x-reason
+    expression: '"denied"'
+cache:
+  key: object.attributes.request.http.path
+  ttl: 1m30s
+rollout:
+  percentage: 25
+  key: object.attributes.request.http.headers[?"x-user"].orValue("")
+data:
+- name: allowlist
+  configMap:
+    namespace: authz
+    name: allowlist
+- name: keys
+  secret:
+    namespace: authz
+    name: keys
+`,
+}, {
+	name:    "no authorizations",
+	spec:    `authorizations: []`,
+	wantErr: "spec.authorizations: Invalid value: 0: spec.authorizations in body should have at least 1 items",
+}, {
+	name:    "missing authorizations",
+	spec:    `priority: 1`,
+	wantErr: `spec.authorizations: Required value`,
+}, {
+	name: "empty expression",
+	spec: `
+authorizations:
+- expression: ""
+`,
+	wantErr: "spec.authorizations[0].expression: Invalid value: \"\": spec.authorizations[0].expression in body should be at least 1 chars long",
+}, {
+	name: "invalid failure policy",
+	spec: `
+failurePolicy: Retry
+authorizations:
+- expression: envoy.Allowed().Response()
+`,
+	wantErr: `spec.failurePolicy: Unsupported value: "Retry": supported values: "Ignore", "Fail"`,
+}, {
+	name: "combined authorizations",
+	spec: `
+combine: AllMustAllow
+authorizations:
+- name: method
+  match: request.method != "OPTIONS"
+  reason: '"read only"'
+  expression: 'request.method == "GET" ? envoy.Allowed().Response() : envoy.Denied(405).Response()'
+- name: tenant
+  expression: envoy.Allowed().Response()
+`,
+}, {
+	name: "invalid combine",
+	spec: `
+combine: AllowAll
+authorizations:
+- expression: envoy.Allowed().Response()
+`,
+	wantErr: `spec.combine: Unsupported value: "AllowAll": supported values: "FirstMatch", "AnyDeny", "AllMustAllow"`,
+}, {
+	name: "invalid enforcement mode",
+	spec: `
+enforcementMode: DryRun
+authorizations:
+- expression: envoy.Allowed().Response()
+`,
+	wantErr: `spec.enforcementMode: Unsupported value: "DryRun": supported values: "Enforce", "Audit"`,
+}, {
+	name: "header without expression",
+	spec: `
+authorizations:
+- expression: envoy.Allowed().Response()
+headers:
+  request:
+  - name: x-tenant
+`,
+	wantErr: "spec.headers.request[0]: Invalid value: \"object\": expression is required unless the action is Remove",
+}, {
+	name: "removed response header",
+	spec: `
+authorizations:
+- expression: envoy.Allowed().Response()
+headers:
+  response:
+  - name: x-tenant
+    action: Remove
+`,
+	wantErr: "spec.headers.response: Invalid value: \"array\": the Remove action is not supported for response headers",
+}, {
+	name: "removed deny response header",
+	spec: `
+authorizations:
+- expression: envoy.Allowed().Response()
+denyResponse:
+  headers:
+  - name: x-tenant
+    action: Remove
+`,
+	wantErr: "spec.denyResponse.headers: Invalid value: \"array\": the Remove action is not supported for deny response headers",
+}, {
+	name: "zero cache ttl",
+	spec: `
+authorizations:
+- expression: envoy.Allowed().Response()
+cache:
+  key: object.attributes.request.http.path
+  ttl: 0s
+`,
+	wantErr: "spec.cache.ttl: Invalid value: \"string\": ttl must be positive",
+}, {
+	name: "rollout percentage above 100",
+	spec: `
+authorizations:
+- expression: envoy.Allowed().Response()
+rollout:
+  percentage: 101
+  key: request.path
+`,
+	wantErr: "spec.rollout.percentage: Invalid value: 101: spec.rollout.percentage in body should be less than or equal to 100",
+}, {
+	name: "rollout without key",
+	spec: `
+authorizations:
+- expression: envoy.Allowed().Response()
+rollout:
+  percentage: 10
+`,
+	wantErr: "spec.rollout.key: Required value",
+}, {
+	name: "rate limit",
+	spec: `
+authorizations:
+- expression: envoy.Allowed().Response()
+rateLimit:
+  entries: '[{"key": "subject", "value": object.attributes.request.http.headers[?"x-user"].orValue("")}]'
+`,
+}, {
+	name: "rate limit without entries",
+	spec: `
+authorizations:
+- expression: envoy.Allowed().Response()
+rateLimit: {}
+`,
+	wantErr: "spec.rateLimit.entries: Required value",
+}, {
+	name: "scope",
+	spec: `
+authorizations:
+- expression: envoy.Allowed().Response()
+scope:
+- public
+- internal
+`,
+}, {
+	name: "empty scope value",
+	spec: `
+authorizations:
+- expression: envoy.Allowed().Response()
+scope:
+- ""
+`,
+	wantErr: "spec.scope[0]: Invalid value: \"\": spec.scope[0] in body should be at least 1 chars long",
+}, {
+	name: "response phase",
+	spec: `
+authorizations:
+- expression: envoy.Allowed().Response()
+phase: Response
+`,
+}, {
+	name: "disabled",
+	spec: `
+authorizations:
+- expression: envoy.Allowed().Response()
+disabled: true
+`,
+}, {
+	name: "activation",
+	spec: `
+authorizations:
+- expression: envoy.Allowed().Response()
+activation:
+  from: "2026-01-01T00:00:00Z"
+  until: "2026-07-01T00:00:00Z"
+  timeZone: Europe/Paris
+  windows:
+  - days: [Saturday, Sunday]
+    start: "22:00"
+    end: "06:00"
+  - start: "12:00"
+    end: "24:00"
+`,
+}, {
+	name: "activation until before from",
+	spec: `
+authorizations:
+- expression: envoy.Allowed().Response()
+activation:
+  from: "2026-07-01T00:00:00Z"
+  until: "2026-01-01T00:00:00Z"
+`,
+	wantErr: "spec.activation: Invalid value: \"object\": until must be after from",
+}, {
+	name: "invalid activation window",
+	spec: `
+authorizations:
+- expression: envoy.Allowed().Response()
+activation:
+  windows:
+  - start: "9:00"
+    end: "17:00"
+`,
+	wantErr: "spec.activation.windows[0].start: Invalid value: \"9:00\"",
+}, {
+	name: "invalid activation day",
+	spec: `
+authorizations:
+- expression: envoy.Allowed().Response()
+activation:
+  windows:
+  - days: [Mon]
+    start: "09:00"
+    end: "17:00"
+`,
+	wantErr: "spec.activation.windows[0].days[0]: Unsupported value: \"Mon\"",
+}, {
+	name: "invalid phase",
+	spec: `
+authorizations:
+- expression: envoy.Allowed().Response()
+phase: Upstream
+`,
+	wantErr: "spec.phase: Unsupported value: \"Upstream\": supported values: \"Request\", \"Response\"",
+}, {
+	name: "data source without reference",
+	spec: `
+authorizations:
+- expression: envoy.Allowed().Response()
+data:
+- name: allowlist
+`,
+	wantErr: "spec.data[0]: Invalid value: \"object\": exactly one of configMap or secret is required",
+}, {
+	name: "data source with both references",
+	spec: `
+authorizations:
+- expression: envoy.Allowed().Response()
+data:
+- name: allowlist
+  configMap:
+    namespace: authz
+    name: allowlist
+  secret:
+    namespace: authz
+    name: allowlist
+`,
+	wantErr: "spec.data[0]: Invalid value: \"object\": exactly one of configMap or secret is required",
+}, {
+	name: "invalid data source name",
+	spec: `
+authorizations:
+- expression: envoy.Allowed().Response()
+data:
+- name: allow-list
+  configMap:
+    namespace: authz
+    name: allowlist
+`,
+	wantErr: "spec.data[0].name: Invalid value: \"allow-list\"",
+}}
+
+// policyObject returns the unstructured content of a policy with the spec
+func policyObject(t *testing.T, spec string) map[string]any {
+	t.Helper()
+	var parsed map[string]any
+	require.NoError(t, yaml.Unmarshal([]byte(spec), &parsed))
+	return map[string]any{
+		"apiVersion": SchemeGroupVersion.String(),
+		"kind":       "AuthorizationPolicy",
+		"metadata":   map[string]any{"name": "policy"},
+		"spec":       parsed,
+	}
+}
+
+// loadSchema returns the schema of the served version of the generated crd, it fails if the api server would reject the crd
+func loadSchema(t *testing.T) (*apiextensions.JSONSchemaProps, *structuralschema.Structural) {
+	t.Helper()
+	data, err := os.ReadFile(crdPath)
+	require.NoError(t, err)
+	var crd apiextensionsv1.CustomResourceDefinition
+	require.NoError(t, yaml.Unmarshal(data, &crd))
+	var internal apiextensions.CustomResourceDefinition
+	require.NoError(t, apiextensionsv1.Convert_v1_CustomResourceDefinition_To_apiextensions_CustomResourceDefinition(&crd, &internal, nil))
+	// the api server records the storage version when the crd is created
+	internal.Status.StoredVersions = []string{SchemeGroupVersion.Version}
+	// the api server checks the validation rules compile and fit in the cost budget
+	require.Empty(t, crdvalidation.ValidateCustomResourceDefinition(context.Background(), &internal))
+	// the conversion hoists the schema of a single version to the top level
+	openapi := internal.Spec.Validation.OpenAPIV3Schema
+	structural, err := structuralschema.NewStructural(openapi)
+	require.NoError(t, err)
+	return openapi, structural
+}
+
+// validate defaults and validates the policy like the api server does on create
+func validate(t *testing.T, openapi *apiextensions.JSONSchemaProps, structural *structuralschema.Structural, object map[string]any) field.ErrorList {
+	t.Helper()
+	defaulting.Default(object, structural)
+	validator, _, err := validation.NewSchemaValidator(openapi)
+	require.NoError(t, err)
+	errs := validation.ValidateCustomResource(nil, object, validator)
+	// validation rules only run on objects valid against the schema
+	if len(errs) > 0 {
+		return errs
+	}
+	errs, _ = cel.NewValidator(structural, true, celconfig.PerCallLimit).Validate(context.Background(), nil, structural, object, nil, celconfig.RuntimeCELCostBudget)
+	return errs
+}
+
+func TestCRDValidation(t *testing.T) {
+	openapi, structural := loadSchema(t)
+	for _, tt := range specs {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := validate(t, openapi, structural, policyObject(t, tt.spec))
+			if tt.wantErr == "" {
+				assert.Empty(t, errs)
+			} else {
+				assert.ErrorContains(t, errs.ToAggregate(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCRDDefaulting(t *testing.T) {
+	openapi, structural := loadSchema(t)
+	object := policyObject(t, `
+authorizations:
+- expression: envoy.Allowed().Response()
+headers:
+  request:
+  - name: x-tenant
+    expression: '"acme"'
+`)
+	assert.Empty(t, validate(t, openapi, structural, object))
+	spec := object["spec"].(map[string]any)
+	assert.Equal(t, "Fail", spec["failurePolicy"])
+	assert.Equal(t, "Enforce", spec["enforcementMode"])
+	assert.Equal(t, "Set", spec["headers"].(map[string]any)["request"].([]any)[0].(map[string]any)["action"])
+}
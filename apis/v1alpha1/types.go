@@ -10,16 +10,29 @@ import (
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 // +kubebuilder:object:root=true
 // +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="READY",type=string,JSONPath=`.status.conditions[?(@.type == "Ready")].status`
+// +kubebuilder:printcolumn:name="REASON",type=string,JSONPath=`.status.conditions[?(@.type == "Ready")].reason`
+// +kubebuilder:printcolumn:name="AGE",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // AuthorizationPolicy defines an authorization policy resource
 type AuthorizationPolicy struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 	Spec              AuthorizationPolicySpec `json:"spec"`
+	// +optional
+	Status AuthorizationPolicyStatus `json:"status,omitempty"`
 }
 
 // AuthorizationPolicySpec defines the spec of an authorization policy
 type AuthorizationPolicySpec struct {
+	// Priority defines the order in which policies are evaluated.
+	// Policies with a higher priority are evaluated first, policies with the same priority
+	// are evaluated in alphabetical order of their names.
+	// Defaults to 0.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+
 	// FailurePolicy defines how to handle failures for the policy. Failures can
 	// occur from CEL expression parse errors, type check errors, runtime errors and invalid
 	// or mis-configured policy definitions.
@@ -27,9 +40,83 @@ type AuthorizationPolicySpec struct {
 	// FailurePolicy does not define how validations that evaluate to false are handled.
 	//
 	// Allowed values are Ignore or Fail. Defaults to Fail.
+	// +kubebuilder:validation:Enum=Ignore;Fail
+	// +kubebuilder:default=Fail
 	// +optional
 	FailurePolicy *admissionregistrationv1.FailurePolicyType `json:"failurePolicy,omitempty"`
 
+	// EnforcementMode defines how the policy decision is enforced.
+	// In Audit mode the policy is evaluated and its decision is logged and recorded in metrics,
+	// but it never affects the response returned to Envoy.
+	// Allowed values are Enforce or Audit. Defaults to Enforce.
+	// +kubebuilder:default=Enforce
+	// +optional
+	EnforcementMode EnforcementMode `json:"enforcementMode,omitempty"`
+
+	// Sequential forces the policy to be evaluated on its own, in priority order,
+	// when the server evaluates policies concurrently.
+	// Policies declaring header mutations are always evaluated sequentially.
+	// +optional
+	Sequential bool `json:"sequential,omitempty"`
+
+	// Override makes the response of the policy final.
+	// By default a deny returned by any policy overrides the allows returned by other policies, whatever their priority.
+	// The response of the first override policy (in priority order) returning a response wins over the responses
+	// of all other policies, denies included.
+	// +optional
+	Override bool `json:"override,omitempty"`
+
+	// Disabled excludes the policy from evaluation without deleting it.
+	// A disabled policy is still compiled and its status reports compilation errors, it is evaluated again
+	// once it is enabled.
+	// +optional
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Activation restricts the evaluation of the policy to a period of time and to recurring time windows.
+	// Outside of its activation the policy is skipped, it takes no decision.
+	// +optional
+	Activation *Activation `json:"activation,omitempty"`
+
+	// Scope restricts the policy to the requests of some Envoy listeners or filter chains, identified by the
+	// value of a context extension set in the ext_authz filter configuration.
+	// The context extension key is configured on the server and defaults to `listener`.
+	// The policy is skipped for requests whose context extension value is not listed, or that don't have
+	// the context extension. An empty scope applies the policy to every request.
+	// Scope is checked before the TargetConditions.
+	// +kubebuilder:validation:MaxItems=64
+	// +kubebuilder:validation:items:MinLength=1
+	// +listType=set
+	// +optional
+	Scope []string `json:"scope,omitempty"`
+
+	// Phase is the phase of the ext_authz checks the policy is evaluated in.
+	// Request policies are evaluated when Envoy checks a request before forwarding it upstream.
+	// Response policies are evaluated when the upstream response is checked, the `response` variable holds the
+	// status and headers of the upstream response. A policy is skipped for the checks of the other phase.
+	// Allowed values are Request or Response. Defaults to Request.
+	// +kubebuilder:default=Request
+	// +optional
+	Phase Phase `json:"phase,omitempty"`
+
+	// TargetConditions is a list of conditions on the destination workload that must be met for a request to be validated.
+	// TargetConditions are evaluated before MatchConditions, the `destination` variable describes the workload
+	// Envoy forwards the request to and the `spiffe` library parses its principal.
+	// An empty list of targetConditions targets all workloads.
+	//
+	// The exact matching logic is (in order):
+	//   1. If ANY targetCondition evaluates to FALSE, the policy is skipped.
+	//   2. If ALL targetConditions evaluate to TRUE, the policy is evaluated.
+	//   3. If any targetCondition evaluates to an error (but none are FALSE):
+	//      - If failurePolicy=Fail, reject the request
+	//      - If failurePolicy=Ignore, the policy is skipped
+	//
+	// +patchMergeKey=name
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	TargetConditions []admissionregistrationv1.MatchCondition `json:"targetConditions,omitempty" patchStrategy:"merge" patchMergeKey:"name"`
+
 	// MatchConditions is a list of conditions that must be met for a request to be validated.
 	// An empty list of matchConditions matches all requests.
 	//
@@ -47,10 +134,28 @@ type AuthorizationPolicySpec struct {
 	// +optional
 	MatchConditions []admissionregistrationv1.MatchCondition `json:"matchConditions,omitempty" patchStrategy:"merge" patchMergeKey:"name"`
 
+	// ExcludeConditions is a list of conditions that exclude requests from the policy.
+	// ExcludeConditions are evaluated after MatchConditions and before the rest of the policy.
+	// An empty list of excludeConditions excludes no requests.
+	//
+	// The exact matching logic is (in order):
+	//   1. If ANY excludeCondition evaluates to TRUE, the policy is skipped.
+	//   2. If ALL excludeConditions evaluate to FALSE, the policy is evaluated.
+	//   3. If any excludeCondition evaluates to an error (but none are TRUE):
+	//      - If failurePolicy=Fail, reject the request
+	//      - If failurePolicy=Ignore, the policy is skipped
+	//
+	// +patchMergeKey=name
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	ExcludeConditions []admissionregistrationv1.MatchCondition `json:"excludeConditions,omitempty" patchStrategy:"merge" patchMergeKey:"name"`
+
 	// Variables contain definitions of variables that can be used in composition of other expressions.
 	// Each variable is defined as a named CEL expression.
 	// The variables defined here will be available under `variables` in other expressions of the policy
-	// except MatchConditions because MatchConditions are evaluated before the rest of the policy.
+	// except TargetConditions, MatchConditions and ExcludeConditions because they are evaluated before the rest of the policy.
 	//
 	// The expression of a variable can refer to other variables defined earlier in the list but not those after.
 	// Thus, Variables must be sorted by the order of first appearance and acyclic.
@@ -61,10 +166,308 @@ type AuthorizationPolicySpec struct {
 	// +optional
 	Variables []admissionregistrationv1.Variable `json:"variables,omitempty" patchStrategy:"merge" patchMergeKey:"name"`
 
+	// Data references ConfigMaps and Secrets whose entries are available to the policy expressions
+	// under `data.<name>`, a map of the keys of the referenced resource to their values.
+	// The policy is evaluated with the current entries of the resources, a change applies without editing the policy.
+	// Reading the entries of a resource that doesn't exist fails the evaluation and the failure policy applies.
+	// Data sources are resolved by the Kubernetes provider when they are enabled.
+	// +patchMergeKey=name
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Data []DataSource `json:"data,omitempty" patchStrategy:"merge" patchMergeKey:"name"`
+
+	// Combine defines how the decisions of the authorizations are combined into the policy decision.
+	//
+	//   - FirstMatch: the first authorization (in order) returning a response takes the decision.
+	//   - AnyDeny: every authorization is evaluated until one denies, the first deny takes the decision.
+	//     When no authorization denies, the first allow takes the decision.
+	//   - AllMustAllow: the policy allows a request only if every matching authorization allows it.
+	//     The first deny takes the decision, the policy takes no decision if a matching authorization returns none.
+	//     When every matching authorization allows, the first allow takes the decision.
+	//
+	// Authorizations whose match condition is false take no part in the decision.
+	// Allowed values are FirstMatch, AnyDeny or AllMustAllow. Defaults to FirstMatch.
+	// +kubebuilder:default=FirstMatch
+	// +optional
+	Combine Combine `json:"combine,omitempty"`
+
 	// Authorizations contain CEL expressions which is used to apply the authorization.
+	// At least one authorization is required.
 	// +listType=atomic
-	// +optional
+	// +kubebuilder:validation:MinItems=1
+	// +required
 	Authorizations []Authorization `json:"authorizations,omitempty"`
+
+	// Headers defines header mutations applied to the response returned by the policy.
+	// +optional
+	Headers *Headers `json:"headers,omitempty"`
+
+	// DenyResponse defines the response returned to the client when the policy denies a request.
+	// +optional
+	DenyResponse *DenyResponse `json:"denyResponse,omitempty"`
+
+	// Reason is a CEL expression computing the reason of the decision returned by the policy, it must return a string.
+	// The policy name and reason are returned to Envoy in the response dynamic metadata, under the `kyverno` key.
+	// CEL expressions have access to the same variables as authorization expressions.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Cache caches the decisions of the policy, requests with the same cache key get the cached decision
+	// without evaluating the policy again.
+	// +optional
+	Cache *DecisionCache `json:"cache,omitempty"`
+
+	// Rollout applies the policy to a percentage of the requests, to ramp up a new policy progressively.
+	// Requests out of the rollout are not matched by the policy, it takes no decision for them.
+	// Unlike the Audit mode, the decisions taken for the requests in the rollout are enforced.
+	// +optional
+	Rollout *Rollout `json:"rollout,omitempty"`
+
+	// RateLimit defines the rate limit descriptor entries returned to Envoy with the requests allowed by the policy,
+	// so that the rate limit filter can limit requests by dimensions computed by the policy.
+	// +optional
+	RateLimit *RateLimit `json:"rateLimit,omitempty"`
+}
+
+// Rollout defines the fraction of the requests a policy applies to
+type Rollout struct {
+	// Percentage is the percentage of the requests the policy applies to, from 0 to 100.
+	// Raising the percentage keeps the requests already in the rollout.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	Percentage int32 `json:"percentage"`
+
+	// Key is a CEL expression computing the key requests are sampled by, it must return a string.
+	// Requests with the same key are either all in or all out of the rollout, a stable key like the subject
+	// of a token or a header identifying the client makes the policy apply consistently to the same clients.
+	// CEL expressions have access to the same variables as authorization expressions.
+	// A cached policy must capture the key in its cache key.
+	// +kubebuilder:validation:MinLength=1
+	Key string `json:"key"`
+}
+
+// Activation defines when a policy is evaluated
+// +kubebuilder:validation:XValidation:rule="!has(self.from) || !has(self.until) || self.until > self.from",message="until must be after from"
+type Activation struct {
+	// From is the time the policy is evaluated from, the policy is evaluated as soon as it is loaded when empty.
+	// +optional
+	From *metav1.Time `json:"from,omitempty"`
+
+	// Until is the time the policy stops being evaluated at, it must be after From.
+	// The policy is evaluated indefinitely when empty.
+	// +optional
+	Until *metav1.Time `json:"until,omitempty"`
+
+	// TimeZone is the IANA name of the time zone the windows are expressed in, like `Europe/Paris`.
+	// Windows follow the local time of the zone, daylight saving time changes included. Defaults to UTC.
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// Windows are the recurring time windows the policy is evaluated in, between From and Until.
+	// The policy is evaluated when the time falls in any of the windows, overlapping windows add up.
+	// The policy is evaluated at any time when empty.
+	// +kubebuilder:validation:MaxItems=32
+	// +listType=atomic
+	// +optional
+	Windows []ActivationWindow `json:"windows,omitempty"`
+}
+
+// ActivationWindow is a time window recurring on some days of the week
+type ActivationWindow struct {
+	// Days are the days of the week the window starts on. Defaults to every day.
+	// +kubebuilder:validation:MaxItems=7
+	// +listType=set
+	// +optional
+	Days []Weekday `json:"days,omitempty"`
+
+	// Start is the local time the window starts at, in the HH:MM format.
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	Start string `json:"start"`
+
+	// End is the local time the window ends at, excluded, in the HH:MM format.
+	// `24:00` ends the window at midnight, an end before the start ends the window on the next day.
+	// It must differ from Start.
+	// +kubebuilder:validation:Pattern=`^(([01][0-9]|2[0-3]):[0-5][0-9]|24:00)$`
+	End string `json:"end"`
+}
+
+// Weekday is a day of the week
+// +kubebuilder:validation:Enum=Monday;Tuesday;Wednesday;Thursday;Friday;Saturday;Sunday
+type Weekday string
+
+// RateLimit defines the rate limit descriptor entries of a policy
+type RateLimit struct {
+	// Entries is a CEL expression computing the rate limit descriptor entries of an allowed request, it must return
+	// a list of maps with a `key` and a `value`, like `[{"key": "subject", "value": variables.subject}]`.
+	// The entries are added to the dynamic metadata of the response under `kyverno.rateLimit`, the value of every
+	// entry under its key, where the `metadata` actions of the Envoy rate limits read them. Keys must be unique,
+	// entries with an empty value are left out.
+	// CEL expressions have access to the same variables as authorization expressions.
+	// A cached policy must capture the entries in its cache key.
+	// +kubebuilder:validation:MinLength=1
+	Entries string `json:"entries"`
+}
+
+// DecisionCache defines how the decisions of a policy are cached
+type DecisionCache struct {
+	// Key is a CEL expression computing the cache key of a request, it must return a string.
+	// The key must capture every input the decision depends on, requests with the same key get the same decision.
+	// CEL expressions have access to the same variables as authorization expressions.
+	// A policy calling functions that depend on external or time varying state (the http and k8s libraries,
+	// jwt.Decode and jwt.Verify) can only be cached if the key calls the same functions.
+	// A policy reading the now variable can only be cached if the key reads it too.
+	// +kubebuilder:validation:MinLength=1
+	Key string `json:"key"`
+
+	// TTL is the duration a decision stays cached, it must be positive.
+	// +kubebuilder:validation:XValidation:rule="duration(self) > duration('0s')",message="ttl must be positive"
+	TTL metav1.Duration `json:"ttl"`
+}
+
+// DataSource references a ConfigMap or a Secret whose entries are available to the policy expressions
+// +kubebuilder:validation:XValidation:rule="has(self.configMap) != has(self.secret)",message="exactly one of configMap or secret is required"
+type DataSource struct {
+	// Name is the name the entries are available under, `data.<name>`. It must be a C identifier.
+	// +kubebuilder:validation:Pattern=`^[A-Za-z_][A-Za-z0-9_]*$`
+	// +required
+	Name string `json:"name"`
+
+	// ConfigMap references a ConfigMap, the entries are its data and binary data.
+	// +optional
+	ConfigMap *DataSourceReference `json:"configMap,omitempty"`
+
+	// Secret references a Secret, the entries are its decoded data.
+	// +optional
+	Secret *DataSourceReference `json:"secret,omitempty"`
+}
+
+// DataSourceReference references a namespaced resource
+type DataSourceReference struct {
+	// Namespace is the namespace of the resource.
+	// +kubebuilder:validation:MinLength=1
+	// +required
+	Namespace string `json:"namespace"`
+
+	// Name is the name of the resource.
+	// +kubebuilder:validation:MinLength=1
+	// +required
+	Name string `json:"name"`
+}
+
+// Headers defines header mutations
+type Headers struct {
+	// Request contains mutations applied to the upstream request headers when the policy allows a request.
+	// +listType=atomic
+	// +optional
+	Request []HeaderMutation `json:"request,omitempty"`
+
+	// Response contains mutations applied to the client response headers when the policy denies a request.
+	// The Remove action is not supported for response headers.
+	// +listType=atomic
+	// +kubebuilder:validation:XValidation:rule="self.all(m, m.action != 'Remove')",message="the Remove action is not supported for response headers"
+	// +optional
+	Response []HeaderMutation `json:"response,omitempty"`
+
+	// RequestMap is a CEL expression computing upstream request headers set when the policy allows a request,
+	// it must return a map(string, string) of header names to values, header names can be computed.
+	// The mutations of Request take precedence over the headers of the map with the same name.
+	// +optional
+	RequestMap string `json:"requestMap,omitempty"`
+
+	// ResponseMap is a CEL expression computing client response headers set when the policy denies a request,
+	// it must return a map(string, string) of header names to values, header names can be computed.
+	// The mutations of Response take precedence over the headers of the map with the same name.
+	// +optional
+	ResponseMap string `json:"responseMap,omitempty"`
+}
+
+// DenyResponse defines the response returned to the client when a policy denies a request
+type DenyResponse struct {
+	// Status is a CEL expression computing the HTTP status code, it must return an int.
+	// A literal status code like `429` is a valid expression.
+	// Defaults to the status code set by the authorization rule, or 403 if the rule didn't set one.
+	// +optional
+	Status string `json:"status,omitempty"`
+
+	// Headers contains mutations applied to the client response headers.
+	// The Remove action is not supported.
+	// +listType=atomic
+	// +kubebuilder:validation:XValidation:rule="self.all(m, m.action != 'Remove')",message="the Remove action is not supported for deny response headers"
+	// +optional
+	Headers []HeaderMutation `json:"headers,omitempty"`
+
+	// Body is a CEL expression computing the response body.
+	// A string is returned as is, any other value (a map for example) is serialized to JSON.
+	// Defaults to the body set by the authorization rule, or an empty body if the rule didn't set one.
+	// +optional
+	Body string `json:"body,omitempty"`
+
+	// Location is a CEL expression computing the URL the client is redirected to, it must return a string.
+	// A literal location like `'https://login.example.com'` is a valid expression.
+	// The status code must be a redirect (301, 302, 303, 307 or 308) and defaults to 302 when a location is set.
+	// +optional
+	Location string `json:"location,omitempty"`
+
+	// ContentType is a CEL expression computing the content type of the body, it must return a string.
+	// A literal content type like `'application/problem+json'` is a valid expression.
+	// It overwrites a content-type header set by the headers.
+	// Defaults to `application/json` when the body is serialized to JSON and no content-type header is set.
+	// +optional
+	ContentType string `json:"contentType,omitempty"`
+
+	// GrpcStatus is a CEL expression computing the gRPC status code of the response, it must return a string.
+	// A literal code name like `'PERMISSION_DENIED'` is a valid expression, `OK` is not.
+	// It only applies to gRPC requests, other requests are denied with the HTTP status.
+	// The HTTP status defaults to the status matching the code, when there is one.
+	// +optional
+	GrpcStatus string `json:"grpcStatus,omitempty"`
+
+	// GrpcMessage is a CEL expression computing the gRPC status message of the response, it must return a string.
+	// It only applies to gRPC requests and replaces the body, envoy sends the body as the gRPC message.
+	// +optional
+	GrpcMessage string `json:"grpcMessage,omitempty"`
+}
+
+// HeaderAction defines the action of a header mutation
+// +kubebuilder:validation:Enum=Set;Append;Remove
+type HeaderAction string
+
+const (
+	// HeaderActionSet sets the header, overwriting any existing value.
+	HeaderActionSet HeaderAction = "Set"
+	// HeaderActionAppend appends the value to the existing header values.
+	HeaderActionAppend HeaderAction = "Append"
+	// HeaderActionRemove removes the header.
+	HeaderActionRemove HeaderAction = "Remove"
+)
+
+// HeaderMutation defines a header mutation
+// +kubebuilder:validation:XValidation:rule="self.action == 'Remove' || (has(self.expression) && size(self.expression) > 0)",message="expression is required unless the action is Remove"
+type HeaderMutation struct {
+	// Name is the header name.
+	// +kubebuilder:validation:MinLength=1
+	// +required
+	Name string `json:"name"`
+
+	// Action is the mutation action, Set, Append or Remove. Defaults to Set.
+	// +kubebuilder:default=Set
+	// +optional
+	Action HeaderAction `json:"action,omitempty"`
+
+	// Expression is a CEL expression computing the header value, it must return a string.
+	// CEL expressions have access to the same variables as authorization expressions.
+	// Expression is required unless the action is Remove.
+	// +optional
+	Expression string `json:"expression,omitempty"`
+}
+
+func (m *HeaderMutation) GetAction() HeaderAction {
+	if m.Action == "" {
+		return HeaderActionSet
+	}
+	return m.Action
 }
 
 func (s *AuthorizationPolicySpec) GetFailurePolicy() admissionregistrationv1.FailurePolicyType {
@@ -74,8 +477,81 @@ func (s *AuthorizationPolicySpec) GetFailurePolicy() admissionregistrationv1.Fai
 	return *s.FailurePolicy
 }
 
+func (s *AuthorizationPolicySpec) GetEnforcementMode() EnforcementMode {
+	if s.EnforcementMode == "" {
+		return EnforcementModeEnforce
+	}
+	return s.EnforcementMode
+}
+
+// EnforcementMode defines how a policy decision is enforced
+// +kubebuilder:validation:Enum=Enforce;Audit
+type EnforcementMode string
+
+const (
+	// EnforcementModeEnforce returns the policy decision to Envoy.
+	EnforcementModeEnforce EnforcementMode = "Enforce"
+	// EnforcementModeAudit records the policy decision without enforcing it.
+	EnforcementModeAudit EnforcementMode = "Audit"
+)
+
+func (s *AuthorizationPolicySpec) GetPhase() Phase {
+	if s.Phase == "" {
+		return PhaseRequest
+	}
+	return s.Phase
+}
+
+// Phase defines the phase of the checks a policy is evaluated in
+// +kubebuilder:validation:Enum=Request;Response
+type Phase string
+
+const (
+	// PhaseRequest evaluates the policy when the request is checked.
+	PhaseRequest Phase = "Request"
+	// PhaseResponse evaluates the policy when the upstream response is checked.
+	PhaseResponse Phase = "Response"
+)
+
+func (s *AuthorizationPolicySpec) GetCombine() Combine {
+	if s.Combine == "" {
+		return CombineFirstMatch
+	}
+	return s.Combine
+}
+
+// Combine defines how the decisions of the authorizations of a policy are combined
+// +kubebuilder:validation:Enum=FirstMatch;AnyDeny;AllMustAllow
+type Combine string
+
+const (
+	// CombineFirstMatch returns the response of the first authorization returning one.
+	CombineFirstMatch Combine = "FirstMatch"
+	// CombineAnyDeny returns the first deny, or the first allow when no authorization denies.
+	CombineAnyDeny Combine = "AnyDeny"
+	// CombineAllMustAllow allows a request only if every matching authorization allows it.
+	CombineAllMustAllow Combine = "AllMustAllow"
+)
+
 // Authorization defines an authorization policy rule
 type Authorization struct {
+	// Name identifies the authorization in the decision attribution and in the explanations of a check.
+	// Names must be unique within a policy.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Match is a CEL expression deciding whether the authorization applies to a request, it must return a bool.
+	// An authorization whose match condition is false is skipped and takes no part in the policy decision.
+	// CEL expressions have access to the same variables as authorization expressions.
+	// +optional
+	Match string `json:"match,omitempty"`
+
+	// Reason is a CEL expression computing the reason of the decisions taken by the authorization, it must return a string.
+	// It replaces the reason of the policy.
+	// CEL expressions have access to the same variables as authorization expressions.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
 	// Expression represents the expression which will be evaluated by CEL.
 	// ref: https://github.com/google/cel-spec
 	// CEL expressions have access to CEL variables as well as some other useful variables:
@@ -83,10 +559,52 @@ type Authorization struct {
 	// - 'object' - The object from the incoming request. (https://www.envoyproxy.io/docs/envoy/latest/api-v3/service/auth/v3/external_auth.proto#service-auth-v3-checkrequest)
 	//
 	// CEL expressions are expected to return an envoy CheckResponse (https://www.envoyproxy.io/docs/envoy/latest/api-v3/service/auth/v3/external_auth.proto#service-auth-v3-checkresponse).
+	// +kubebuilder:validation:MinLength=1
 	// +required
 	Expression string `json:"expression"`
 }
 
+const (
+	// ConditionReady indicates whether the policy has been compiled and is ready to be enforced.
+	ConditionReady = "Ready"
+	// ReasonCompiled is used when the policy compiled successfully.
+	ReasonCompiled = "Compiled"
+	// ReasonCompilationFailed is used when the policy failed to compile.
+	ReasonCompilationFailed = "CompilationFailed"
+	// ReasonQuotaExceeded is used when the policy was not loaded because it exceeds a policy quota.
+	ReasonQuotaExceeded = "QuotaExceeded"
+	// ReasonDisabled is used when the policy compiled successfully and is disabled, it is not enforced.
+	ReasonDisabled = "Disabled"
+	// ReasonMalformed is used when the policy was not compiled because it misses fields the compiler relies on, the
+	// CRD installed in the cluster may not match the server version.
+	ReasonMalformed = "Malformed"
+	// ConditionUnknownFields indicates whether the last applied configuration of the policy has fields unknown to its version,
+	// they are pruned by the API server and ignored.
+	ConditionUnknownFields = "UnknownFields"
+	// ReasonUnknownFieldsFound is used when the last applied configuration has fields unknown to the policy version.
+	ReasonUnknownFieldsFound = "UnknownFieldsFound"
+	// ReasonNoUnknownFields is used when the last applied configuration has no field unknown to the policy version.
+	ReasonNoUnknownFields = "NoUnknownFields"
+)
+
+// AuthorizationPolicyStatus defines the observed state of an authorization policy
+type AuthorizationPolicyStatus struct {
+	// Conditions represent the latest available observations of the policy state.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// EstimatedCost is the worst case CEL cost of evaluating every expression of the evaluated spec once.
+	// +optional
+	EstimatedCost int64 `json:"estimatedCost,omitempty"`
+
+	// Hash identifies the behavior of the evaluated spec, it changes when the spec changes but not with the
+	// metadata of the policy or the formatting of its expressions.
+	// +optional
+	Hash string `json:"hash,omitempty"`
+}
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // AuthorizationPolicyList defines a list of authorization policies
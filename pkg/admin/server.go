@@ -0,0 +1,144 @@
+package admin
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/server"
+)
+
+// PoliciesResponse is the response of the policies endpoint
+type PoliciesResponse struct {
+	Count    int                   `json:"count"`
+	Policies []policy.PolicyStatus `json:"policies"`
+	// OldestReconcile is the last reconciliation time of the least recently reconciled policy, if any
+	OldestReconcile *time.Time `json:"oldestReconcile,omitempty"`
+	// Watch describes the errors watching policies, nil when the provider doesn't watch policies
+	Watch *policy.WatchStatus `json:"watch,omitempty"`
+}
+
+// NewServer returns a read-only server describing the policies loaded by the provider,
+// every request must carry the token in an `Authorization: Bearer` header
+func NewServer(addr string, provider policy.Provider, token string) (server.ServerFunc, error) {
+	if token == "" {
+		return nil, errors.New("admin server token is required")
+	}
+	return func(ctx context.Context) error {
+		// create server
+		s := &http.Server{
+			Addr:    addr,
+			Handler: newHandler(provider, token),
+		}
+		// run server
+		return server.RunHttp(ctx, s, "", "")
+	}, nil
+}
+
+func newHandler(provider policy.Provider, token string) http.Handler {
+	// create mux
+	mux := http.NewServeMux()
+	// register policies handlers
+	mux.HandleFunc("GET /admin/policies", func(w http.ResponseWriter, r *http.Request) {
+		policies, err := inspect(r.Context(), provider)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJson(w, summarize(provider, policies))
+	})
+	mux.HandleFunc("GET /admin/policies/{name}", func(w http.ResponseWriter, r *http.Request) {
+		policies, err := inspect(r.Context(), provider)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, policy := range policies {
+			if policy.Name == r.PathValue("name") {
+				writeJson(w, policy)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	})
+	// register headers handler
+	mux.HandleFunc("GET /admin/headers", func(w http.ResponseWriter, r *http.Request) {
+		policies, err := provider.CompiledPolicies(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJson(w, policy.MergeHeaderUsage(policies...))
+	})
+	return authenticate(mux, token)
+}
+
+// inspect describes the provider policies, providers that don't observe policies
+// failing to compile are described from their compiled policies
+func inspect(ctx context.Context, provider policy.Provider) ([]policy.PolicyStatus, error) {
+	if inspector, ok := provider.(policy.Inspector); ok {
+		return inspector.Inspect(), nil
+	}
+	policies, err := provider.CompiledPolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]policy.PolicyStatus, 0, len(policies))
+	for _, compiled := range policies {
+		out = append(out, policy.PolicyStatus{
+			Name:          compiled.Name,
+			Priority:      compiled.Priority,
+			Mode:          compiled.Mode,
+			Active:        true,
+			Compiled:      true,
+			EstimatedCost: compiled.EstimatedCost,
+			Hash:          compiled.Hash,
+		})
+	}
+	return out, nil
+}
+
+// summarize returns the policies with how up to date they are
+func summarize(provider policy.Provider, policies []policy.PolicyStatus) PoliciesResponse {
+	response := PoliciesResponse{
+		Count:    len(policies),
+		Policies: policies,
+	}
+	for _, policy := range policies {
+		if policy.LastReconciled != nil && (response.OldestReconcile == nil || policy.LastReconciled.Before(*response.OldestReconcile)) {
+			response.OldestReconcile = policy.LastReconciled
+		}
+	}
+	if reporter, ok := provider.(policy.WatchReporter); ok {
+		status := reporter.WatchStatus()
+		response.Watch = &status
+	}
+	return response
+}
+
+// authenticate rejects requests without the expected bearer token
+func authenticate(next http.Handler, token string) http.Handler {
+	// compare digests, the comparison time doesn't depend on the token length
+	want := sha256.Sum256([]byte(token))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		got := sha256.Sum256([]byte(given))
+		if !ok || subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="kyverno-authz-server"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJson(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
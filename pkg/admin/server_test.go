@@ -0,0 +1,161 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/stretchr/testify/assert"
+)
+
+type staticProvider []policy.CompiledPolicy
+
+func (p staticProvider) CompiledPolicies(context.Context) ([]policy.CompiledPolicy, error) {
+	return p, nil
+}
+
+func (p staticProvider) HasSynced() bool {
+	return true
+}
+
+type inspectingProvider struct {
+	staticProvider
+	lock     sync.Mutex
+	statuses []policy.PolicyStatus
+}
+
+func (p *inspectingProvider) Inspect() []policy.PolicyStatus {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.statuses
+}
+
+func (p *inspectingProvider) set(statuses ...policy.PolicyStatus) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.statuses = statuses
+}
+
+func get(t *testing.T, handler http.Handler, path, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func Test_handler_authentication(t *testing.T) {
+	handler := newHandler(staticProvider{}, "secret")
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{{
+		name: "no token",
+		want: http.StatusUnauthorized,
+	}, {
+		name:  "wrong token",
+		token: "guess",
+		want:  http.StatusUnauthorized,
+	}, {
+		name:  "valid token",
+		token: "secret",
+		want:  http.StatusOK,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, get(t, handler, "/admin/policies", tt.token).Code)
+		})
+	}
+}
+
+func Test_handler_policies(t *testing.T) {
+	provider := &inspectingProvider{statuses: []policy.PolicyStatus{}}
+	handler := newHandler(provider, "secret")
+	list := func() PoliciesResponse {
+		recorder := get(t, handler, "/admin/policies", "secret")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		var response PoliciesResponse
+		assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+		return response
+	}
+	assert.Equal(t, PoliciesResponse{Count: 0, Policies: []policy.PolicyStatus{}}, list())
+	// the response follows the provider state
+	compiled := policy.PolicyStatus{Name: "a", Priority: 10, Mode: hub.EnforcementModeEnforce, Active: true, Compiled: true, Generation: 1, ResourceVersion: "42"}
+	failed := policy.PolicyStatus{Name: "b", Mode: hub.EnforcementModeAudit, Error: "invalid expression", Generation: 3, ResourceVersion: "43"}
+	provider.set(compiled, failed)
+	assert.Equal(t, PoliciesResponse{Count: 2, Policies: []policy.PolicyStatus{compiled, failed}}, list())
+	// single policy
+	recorder := get(t, handler, "/admin/policies/b", "secret")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var status policy.PolicyStatus
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&status))
+	assert.Equal(t, failed, status)
+	assert.Equal(t, http.StatusNotFound, get(t, handler, "/admin/policies/missing", "secret").Code)
+}
+
+func Test_handler_policies_compiled(t *testing.T) {
+	// providers that are not inspectors are described from their compiled policies
+	handler := newHandler(staticProvider{{Name: "a", Priority: 1, Mode: hub.EnforcementModeAudit}}, "secret")
+	recorder := get(t, handler, "/admin/policies", "secret")
+	var response PoliciesResponse
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+	assert.Equal(t, PoliciesResponse{Count: 1, Policies: []policy.PolicyStatus{{Name: "a", Priority: 1, Mode: hub.EnforcementModeAudit, Active: true, Compiled: true}}}, response)
+}
+
+// watchingProvider reports the errors watching policies
+type watchingProvider struct {
+	*inspectingProvider
+	watch policy.WatchStatus
+}
+
+func (p watchingProvider) WatchStatus() policy.WatchStatus {
+	return p.watch
+}
+
+func Test_handler_policies_freshness(t *testing.T) {
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Minute)
+	watchError := newer.Add(time.Minute)
+	provider := watchingProvider{
+		inspectingProvider: &inspectingProvider{statuses: []policy.PolicyStatus{
+			{Name: "a", LastReconciled: &newer},
+			{Name: "b", LastReconciled: &older},
+		}},
+		watch: policy.WatchStatus{Errors: 2, LastError: "connection refused", LastErrorTime: &watchError},
+	}
+	recorder := get(t, newHandler(provider, "secret"), "/admin/policies", "secret")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var response PoliciesResponse
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+	// the summary reports the least recently reconciled policy
+	assert.Equal(t, &older, response.OldestReconcile)
+	assert.Equal(t, &provider.watch, response.Watch)
+}
+
+func TestNewServer_token(t *testing.T) {
+	_, err := NewServer(":9084", staticProvider{}, "")
+	assert.Error(t, err)
+	_, err = NewServer(":9084", staticProvider{}, "secret")
+	assert.NoError(t, err)
+}
+
+func Test_handler_headers(t *testing.T) {
+	handler := newHandler(staticProvider{
+		{Name: "a", RequestHeaders: policy.HeaderUsage{Names: []string{"authorization", "x-tenant"}}},
+		{Name: "b", RequestHeaders: policy.HeaderUsage{Names: []string{"authorization"}}},
+	}, "secret")
+	recorder := get(t, handler, "/admin/headers", "secret")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"all":false,"headers":["authorization","x-tenant"]}`, recorder.Body.String())
+}
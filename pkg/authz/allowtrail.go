@@ -0,0 +1,105 @@
+package authz
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type allowTrailKey struct{}
+
+// allowTrail collects the enforced policies evaluated for a check and the ones allowing it, audit policies
+// never affect the decision and are not part of the trail
+type allowTrail struct {
+	lock sync.Mutex
+	// order is the evaluation order of the policies, the concurrent evaluations complete in any order
+	order     map[string]int
+	evaluated int
+	allowed   []allowingPolicy
+}
+
+type allowingPolicy struct {
+	order  int
+	fields map[string]*structpb.Value
+}
+
+// withAllowTrail returns a context collecting the allow trail of a check evaluating the policies
+func withAllowTrail(ctx context.Context, policies []policy.CompiledPolicy) (context.Context, *allowTrail) {
+	trail := &allowTrail{order: make(map[string]int, len(policies))}
+	for i, policy := range policies {
+		trail.order[policy.Name] = i
+	}
+	return context.WithValue(ctx, allowTrailKey{}, trail), trail
+}
+
+// allowTrailFromContext returns the allow trail of the context, nil if the check doesn't collect one
+func allowTrailFromContext(ctx context.Context) *allowTrail {
+	trail, _ := ctx.Value(allowTrailKey{}).(*allowTrail)
+	return trail
+}
+
+// record records the response of an enforced policy, the rule and reason of an allow are read from its attribution
+func (t *allowTrail) record(policy policy.CompiledPolicy, response *authv3.CheckResponse) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.evaluated++
+	if response == nil || response.GetStatus().GetCode() != int32(codes.OK) {
+		return
+	}
+	fields := map[string]*structpb.Value{
+		core.MetadataPolicyKey: structpb.NewStringValue(policy.Name),
+	}
+	attribution := response.GetDynamicMetadata().GetFields()[core.MetadataKey].GetStructValue().GetFields()
+	for _, key := range []string{core.MetadataRuleKey, core.MetadataReasonKey} {
+		if value, ok := attribution[key]; ok {
+			fields[key] = value
+		}
+	}
+	t.allowed = append(t.allowed, allowingPolicy{order: t.order[policy.Name], fields: fields})
+}
+
+// apply adds the trail to the attribution of an allow decision, other decisions are returned as is. The response
+// is copied, policy responses can be shared by the decision cache.
+func (t *allowTrail) apply(response *authv3.CheckResponse) *authv3.CheckResponse {
+	if t == nil || response == nil || response.GetStatus().GetCode() != int32(codes.OK) {
+		return response
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	slices.SortStableFunc(t.allowed, func(a, b allowingPolicy) int { return a.order - b.order })
+	allowed := make([]*structpb.Value, 0, len(t.allowed))
+	for _, policy := range t.allowed {
+		allowed = append(allowed, structpb.NewStructValue(&structpb.Struct{Fields: policy.fields}))
+	}
+	response = proto.Clone(response).(*authv3.CheckResponse)
+	if response.DynamicMetadata == nil {
+		response.DynamicMetadata = &structpb.Struct{}
+	}
+	if response.DynamicMetadata.Fields == nil {
+		response.DynamicMetadata.Fields = map[string]*structpb.Value{}
+	}
+	// the default decision has no attribution
+	attribution := response.DynamicMetadata.Fields[core.MetadataKey].GetStructValue()
+	if attribution == nil {
+		attribution = &structpb.Struct{}
+		response.DynamicMetadata.Fields[core.MetadataKey] = structpb.NewStructValue(attribution)
+	}
+	if attribution.Fields == nil {
+		attribution.Fields = map[string]*structpb.Value{}
+	}
+	attribution.Fields[core.MetadataAllowTrailKey] = structpb.NewStructValue(&structpb.Struct{Fields: map[string]*structpb.Value{
+		core.MetadataAllowTrailPoliciesKey:  structpb.NewListValue(&structpb.ListValue{Values: allowed}),
+		core.MetadataAllowTrailEvaluatedKey: structpb.NewNumberValue(float64(t.evaluated)),
+	}})
+	return response
+}
@@ -0,0 +1,117 @@
+package authz
+
+import (
+	"context"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// allowTrailOf returns the allow trail of a response as a plain value, nil if it has none
+func allowTrailOf(response *authv3.CheckResponse) any {
+	trail, ok := response.GetDynamicMetadata().GetFields()[core.MetadataKey].GetStructValue().GetFields()[core.MetadataAllowTrailKey]
+	if !ok {
+		return nil
+	}
+	return trail.AsInterface()
+}
+
+func Test_service_Check_allowTrail(t *testing.T) {
+	named, errs := policy.NewCompiler().Compile(&hub.AuthorizationPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "allow-read"},
+		Spec: hub.AuthorizationPolicySpec{
+			Authorizations: []hub.Authorization{{
+				Name:       "read",
+				Reason:     `"reading is allowed"`,
+				Expression: `envoy.Allowed().Response()`,
+			}},
+		},
+	})
+	require.Empty(t, errs)
+	audit := compile(t, "audit", admissionregistrationv1.Fail, `envoy.Allowed().Response()`)
+	audit.Mode = hub.EnforcementModeAudit
+	skip := compile(t, "skip", admissionregistrationv1.Fail, `false ? envoy.Allowed().Response() : null`)
+	allow := compile(t, "allow-all", admissionregistrationv1.Fail, `envoy.Allowed().Response()`)
+	deny := compile(t, "deny", admissionregistrationv1.Fail, `envoy.Denied(403).Response()`)
+	tests := []struct {
+		name     string
+		policies staticProvider
+		defaults DefaultDecision
+		wantCode codes.Code
+		want     any
+	}{{
+		name:     "allowing policies",
+		policies: staticProvider{skip, named, audit, allow},
+		wantCode: codes.OK,
+		want: map[string]any{
+			"evaluated": float64(3),
+			"policies": []any{
+				map[string]any{"policy": "allow-read", "rule": "read", "reason": "reading is allowed"},
+				map[string]any{"policy": "allow-all"},
+			},
+		},
+	}, {
+		name:     "default decision",
+		policies: staticProvider{skip, audit},
+		defaults: DefaultDecision{Decision: DecisionAllow},
+		wantCode: codes.OK,
+		want: map[string]any{
+			"evaluated": float64(1),
+			"policies":  []any{},
+		},
+	}, {
+		name:     "denied",
+		policies: staticProvider{allow, deny},
+		wantCode: codes.PermissionDenied,
+	}}
+	for _, tt := range tests {
+		for _, concurrency := range []int{0, 4} {
+			t.Run(tt.name, func(t *testing.T) {
+				s := &service{
+					provider:        tt.policies,
+					defaultDecision: tt.defaults,
+					concurrency:     concurrency,
+					allowTrail:      true,
+				}
+				// the trail of a check doesn't leak into the next one
+				for range 2 {
+					response, err := s.Check(context.Background(), &authv3.CheckRequest{})
+					assert.NoError(t, err)
+					assert.Equal(t, int32(tt.wantCode), response.GetStatus().GetCode())
+					assert.Equal(t, tt.want, allowTrailOf(response))
+				}
+			})
+		}
+	}
+}
+
+func Test_service_Check_allowTrailDisabled(t *testing.T) {
+	allow := compile(t, "allow", admissionregistrationv1.Fail, `envoy.Allowed().Response()`)
+	s := &service{provider: staticProvider{allow}}
+	response, err := s.Check(context.Background(), &authv3.CheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "allow", response.GetDynamicMetadata().GetFields()[core.MetadataKey].GetStructValue().GetFields()[core.MetadataPolicyKey].GetStringValue())
+	assert.Nil(t, allowTrailOf(response))
+}
+
+func Test_allowTrail_apply(t *testing.T) {
+	// a shared response is not modified
+	shared := &authv3.CheckResponse{DynamicMetadata: &structpb.Struct{}}
+	_, trail := withAllowTrail(context.Background(), nil)
+	response := trail.apply(shared)
+	assert.NotNil(t, allowTrailOf(response))
+	assert.Nil(t, allowTrailOf(shared))
+	// nil trails and responses are returned as is
+	var none *allowTrail
+	assert.Same(t, shared, none.apply(shared))
+	assert.Nil(t, trail.apply(nil))
+}
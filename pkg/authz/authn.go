@@ -0,0 +1,104 @@
+package authz
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Authenticator restricts the callers of the grpc server to envoy, a caller is authenticated by a verified client
+// certificate with an allowed subject alternative name or by the bearer token. It is independent of the policies.
+type Authenticator struct {
+	// SANs are the subject alternative names accepted in verified client certificates (dns names, uris, emails or ip addresses)
+	SANs []string
+	// Token is the bearer token accepted in the authorization metadata
+	Token string
+}
+
+// unauthenticatedServices can be called without authenticating, the kubelet probes the health service
+var unauthenticatedServices = []string{healthpb.Health_ServiceDesc.ServiceName}
+
+func (a *Authenticator) serverOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if err := a.authenticate(ctx, info.FullMethod); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := a.authenticate(ss.Context(), info.FullMethod); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	}
+}
+
+// authenticate returns an Unauthenticated error unless the caller of the method is authenticated
+func (a *Authenticator) authenticate(ctx context.Context, method string) error {
+	// methods are named /<service>/<method>
+	service, _, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if slices.Contains(unauthenticatedServices, service) {
+		return nil
+	}
+	if a.Token != "" && a.validToken(ctx) {
+		return nil
+	}
+	if len(a.SANs) != 0 && a.validCertificate(ctx) {
+		return nil
+	}
+	return status.Error(codes.Unauthenticated, "the caller is not authenticated")
+}
+
+func (a *Authenticator) validToken(ctx context.Context) bool {
+	md, _ := metadata.FromIncomingContext(ctx)
+	// compare digests, the comparison time doesn't depend on the token length
+	want := sha256.Sum256([]byte(a.Token))
+	for _, value := range md.Get("authorization") {
+		given, ok := strings.CutPrefix(value, "Bearer ")
+		got := sha256.Sum256([]byte(given))
+		if ok && subtle.ConstantTimeCompare(got[:], want[:]) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Authenticator) validCertificate(ctx context.Context) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	// only certificates verified against the client ca are trusted
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return false
+	}
+	return slices.ContainsFunc(subjectAltNames(info.State.VerifiedChains[0][0]), func(name string) bool {
+		return slices.Contains(a.SANs, name)
+	})
+}
+
+func subjectAltNames(cert *x509.Certificate) []string {
+	names := slices.Clone(cert.DNSNames)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
@@ -0,0 +1,214 @@
+package authz
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+)
+
+// issuer signs certificates, it is its own ca
+type issuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newIssuer(t *testing.T) *issuer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &issuer{cert: cert, key: key}
+}
+
+// issue returns a certificate with the subject alternative names for the usage
+func (i *issuer) issue(t *testing.T, usage x509.ExtKeyUsage, dnsNames []string, uris ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     dnsNames,
+	}
+	for _, uri := range uris {
+		parsed, err := url.Parse(uri)
+		require.NoError(t, err)
+		template.URIs = append(template.URIs, parsed)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, i.cert, &key.PublicKey, i.key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func (i *issuer) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(i.cert)
+	return pool
+}
+
+func TestNewServer_authenticator(t *testing.T) {
+	ca := newIssuer(t)
+	// a certificate signed by another ca isn't trusted
+	untrusted := newIssuer(t)
+	const envoy = "spiffe://cluster.local/ns/istio-system/sa/envoy"
+	tests := []struct {
+		name          string
+		authenticator *Authenticator
+		cert          *tls.Certificate
+		token         string
+		health        bool
+		wantCode      codes.Code
+	}{{
+		name:     "disabled",
+		wantCode: codes.OK,
+	}, {
+		name:          "allowed certificate",
+		authenticator: &Authenticator{SANs: []string{envoy}},
+		cert:          ptr(ca.issue(t, x509.ExtKeyUsageClientAuth, nil, envoy)),
+		wantCode:      codes.OK,
+	}, {
+		name:          "allowed dns name",
+		authenticator: &Authenticator{SANs: []string{"envoy.istio-system.svc"}},
+		cert:          ptr(ca.issue(t, x509.ExtKeyUsageClientAuth, []string{"envoy.istio-system.svc"})),
+		wantCode:      codes.OK,
+	}, {
+		name:          "certificate not allowed",
+		authenticator: &Authenticator{SANs: []string{envoy}},
+		cert:          ptr(ca.issue(t, x509.ExtKeyUsageClientAuth, nil, "spiffe://cluster.local/ns/default/sa/attacker")),
+		wantCode:      codes.Unauthenticated,
+	}, {
+		name:          "no certificate",
+		authenticator: &Authenticator{SANs: []string{envoy}},
+		wantCode:      codes.Unauthenticated,
+	}, {
+		name:          "allowed token",
+		authenticator: &Authenticator{Token: "secret"},
+		token:         "secret",
+		wantCode:      codes.OK,
+	}, {
+		name:          "wrong token",
+		authenticator: &Authenticator{Token: "secret"},
+		token:         "guessed",
+		wantCode:      codes.Unauthenticated,
+	}, {
+		name:          "no token",
+		authenticator: &Authenticator{Token: "secret"},
+		wantCode:      codes.Unauthenticated,
+	}, {
+		name:          "token or certificate",
+		authenticator: &Authenticator{SANs: []string{envoy}, Token: "secret"},
+		cert:          ptr(ca.issue(t, x509.ExtKeyUsageClientAuth, nil, envoy)),
+		token:         "guessed",
+		wantCode:      codes.OK,
+	}, {
+		name:          "health",
+		authenticator: &Authenticator{Token: "secret"},
+		health:        true,
+		wantCode:      codes.OK,
+	}}
+	// the untrusted certificate is rejected during the handshake
+	t.Run("untrusted certificate", func(t *testing.T) {
+		cert := untrusted.issue(t, x509.ExtKeyUsageClientAuth, nil, envoy)
+		err := callServer(t, ca, &Authenticator{SANs: []string{envoy}}, &cert, "", false)
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := callServer(t, ca, tt.authenticator, tt.cert, tt.token, tt.health)
+			assert.Equal(t, tt.wantCode, status.Code(err), err)
+		})
+	}
+}
+
+// callServer runs a tls server verifying client certificates against the ca and calls it once
+func callServer(t *testing.T, ca *issuer, authenticator *Authenticator, cert *tls.Certificate, token string, health bool) error {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "authz.sock")
+	serverCert := ca.issue(t, x509.ExtKeyUsageServerAuth, []string{"authz"})
+	serverTLS := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    ca.pool(),
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}
+	allow := compile(t, "allow", admissionregistrationv1.Fail, `envoy.Allowed().Response()`)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// run server
+	serverErr := make(chan error)
+	go func() {
+		serverErr <- NewServer("unix", socket, serverTLS, staticProvider{allow}, nil, nil, nil, nil, nil, nil, DefaultDecision{}, DefaultDecision{}, "", 0, 0, 5*time.Second, false, authenticator, nil, nil, nil, nil, false).Run(ctx)
+	}()
+	clientTLS := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    ca.pool(),
+		ServerName: "authz",
+	}
+	if cert != nil {
+		clientTLS.Certificates = []tls.Certificate{*cert}
+	}
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(credentials.NewTLS(clientTLS)))
+	require.NoError(t, err)
+	defer conn.Close()
+	callCtx, callCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer callCancel()
+	if token != "" {
+		callCtx = metadata.AppendToOutgoingContext(callCtx, "authorization", "Bearer "+token)
+	}
+	// wait for the server to listen, a rejected handshake fails the call
+	waitForSocket(t, socket)
+	if health {
+		_, err = healthpb.NewHealthClient(conn).Check(callCtx, &healthpb.HealthCheckRequest{})
+	} else {
+		_, err = authv3.NewAuthorizationClient(conn).Check(callCtx, &authv3.CheckRequest{})
+	}
+	cancel()
+	assert.NoError(t, <-serverErr)
+	return err
+}
+
+func waitForSocket(t *testing.T, socket string) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		matches, _ := filepath.Glob(socket)
+		return len(matches) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
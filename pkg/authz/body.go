@@ -0,0 +1,81 @@
+package authz
+
+import (
+	"unicode/utf8"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/envoy"
+	"google.golang.org/protobuf/proto"
+)
+
+// BodyLimit bounds the size of the request bodies the policies are evaluated against, envoy can buffer and forward
+// large bodies the policies parse. A body exceeding the limit is truncated to it and the request is marked as
+// truncated, like envoy marks the bodies it truncates, or the request is rejected without evaluating the policies.
+// A nil BodyLimit doesn't bound bodies.
+type BodyLimit struct {
+	maxSize int64
+	// reject is the decision taken for the requests whose body exceeds the limit, they are truncated when it is nil
+	reject *DefaultDecision
+}
+
+// NewBodyLimit returns a limit of the given number of body bytes, requests exceeding it take the reject decision
+// if any, their body is truncated otherwise
+func NewBodyLimit(maxSize int64, reject *DefaultDecision) *BodyLimit {
+	return &BodyLimit{
+		maxSize: maxSize,
+		reject:  reject,
+	}
+}
+
+// readLimit returns the number of body bytes the http server reads, one more byte than the limit tells it is
+// exceeded, the http server truncates the bodies exceeding its own maximum size
+func (l *BodyLimit) readLimit(maxBodySize int64) int64 {
+	if l == nil || l.maxSize >= maxBodySize {
+		return maxBodySize
+	}
+	return l.maxSize + 1
+}
+
+// apply returns the request to evaluate the policies against, or the response rejecting it. The request is returned
+// as is when its body doesn't exceed the limit, it is copied when its body is truncated.
+func (l *BodyLimit) apply(r *authv3.CheckRequest) (*authv3.CheckRequest, *authv3.CheckResponse) {
+	if l == nil {
+		return r, nil
+	}
+	http := r.GetAttributes().GetRequest().GetHttp()
+	if int64(len(http.GetBody())) <= l.maxSize && int64(len(http.GetRawBody())) <= l.maxSize {
+		return r, nil
+	}
+	if l.reject != nil {
+		return r, l.reject.response()
+	}
+	r = proto.Clone(r).(*authv3.CheckRequest)
+	http = r.Attributes.Request.Http
+	http.Body = truncateUTF8(http.Body, l.maxSize)
+	if int64(len(http.RawBody)) > l.maxSize {
+		http.RawBody = http.RawBody[:l.maxSize]
+	}
+	// the request is marked with the header envoy sets, in the encoding envoy sent the headers with
+	if http.Headers == nil {
+		http.Headers = map[string]string{}
+	}
+	http.Headers[envoy.PartialBodyHeader] = "true"
+	if len(http.GetHeaderMap().GetHeaders()) > 0 {
+		http.HeaderMap.Headers = append(http.HeaderMap.Headers, &corev3.HeaderValue{Key: envoy.PartialBodyHeader, RawValue: []byte("true")})
+	}
+	return r, nil
+}
+
+// truncateUTF8 truncates a string to at most size bytes without splitting a character, protobuf strings are valid
+// UTF-8
+func truncateUTF8(s string, size int64) string {
+	if int64(len(s)) <= size {
+		return s
+	}
+	end := int(size)
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	return s[:end]
+}
@@ -0,0 +1,135 @@
+package authz
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	authzv1alpha1 "github.com/kyverno/kyverno-envoy-plugin/apis/authz/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+)
+
+func Test_BodyLimit(t *testing.T) {
+	// the policy denies with a teapot status, telling the body it was evaluated against and whether it was truncated
+	expression := `envoy.Denied(418).WithBody(string(request.bodyTruncated) + ":" + object.attributes.request.http.body).Response()`
+	reject := &DefaultDecision{Decision: DecisionDeny, DenyStatus: http.StatusRequestEntityTooLarge}
+	tests := []struct {
+		name     string
+		reject   *DefaultDecision
+		body     string
+		wantCode int
+		wantBody string
+	}{{
+		name:     "truncate below the limit",
+		body:     "0123456",
+		wantCode: http.StatusTeapot,
+		wantBody: "false:0123456",
+	}, {
+		name:     "truncate at the limit",
+		body:     "01234567",
+		wantCode: http.StatusTeapot,
+		wantBody: "false:01234567",
+	}, {
+		name:     "truncate above the limit",
+		body:     "0123456789",
+		wantCode: http.StatusTeapot,
+		wantBody: "true:01234567",
+	}, {
+		name:     "truncate without splitting a character",
+		body:     "0123456é",
+		wantCode: http.StatusTeapot,
+		wantBody: "true:0123456",
+	}, {
+		name:     "reject below the limit",
+		reject:   reject,
+		body:     "0123456",
+		wantCode: http.StatusTeapot,
+		wantBody: "false:0123456",
+	}, {
+		name:     "reject at the limit",
+		reject:   reject,
+		body:     "01234567",
+		wantCode: http.StatusTeapot,
+		wantBody: "false:01234567",
+	}, {
+		name:     "reject above the limit",
+		reject:   reject,
+		body:     "0123456789",
+		wantCode: http.StatusRequestEntityTooLarge,
+	}, {
+		name:     "reject above the limit with an allow",
+		reject:   &DefaultDecision{Decision: DecisionAllow},
+		body:     "0123456789",
+		wantCode: http.StatusOK,
+	}}
+	for _, tt := range tests {
+		newService := func(t *testing.T) *service {
+			return &service{
+				provider:  staticProvider{compile(t, "policy", admissionregistrationv1.Fail, expression)},
+				bodyLimit: NewBodyLimit(8, tt.reject),
+			}
+		}
+		t.Run(tt.name+" over grpc", func(t *testing.T) {
+			request := &authv3.CheckRequest{Attributes: &authv3.AttributeContext{Request: &authv3.AttributeContext_Request{Http: &authv3.AttributeContext_HttpRequest{
+				Method:  http.MethodPost,
+				Path:    "/foo",
+				Headers: map[string]string{":path": "/foo"},
+				Body:    tt.body,
+			}}}}
+			response, err := newService(t).Check(context.Background(), request)
+			require.NoError(t, err)
+			if tt.wantCode == http.StatusOK {
+				assert.NotNil(t, response.GetOkResponse())
+			} else {
+				assert.Equal(t, tt.wantCode, int(response.GetDeniedResponse().GetStatus().GetCode()))
+				assert.Equal(t, tt.wantBody, response.GetDeniedResponse().GetBody())
+			}
+			// the request sent by envoy is left untouched
+			assert.Equal(t, tt.body, request.GetAttributes().GetRequest().GetHttp().GetBody())
+		})
+		t.Run(tt.name+" over http", func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/foo", strings.NewReader(tt.body))
+			recorder := httptest.NewRecorder()
+			// the http server reads more bytes than the limit
+			newHttpHandler(newService(t), 100).ServeHTTP(recorder, request)
+			assert.Equal(t, tt.wantCode, recorder.Code)
+			assert.Equal(t, tt.wantBody, recorder.Body.String())
+		})
+	}
+}
+
+func Test_BodyLimit_batch(t *testing.T) {
+	expression := `envoy.Denied(418).WithBody(string(request.bodyTruncated) + ":" + object.attributes.request.http.body).Response()`
+	newRequest := func(body string) *authv3.CheckRequest {
+		return &authv3.CheckRequest{Attributes: &authv3.AttributeContext{Request: &authv3.AttributeContext_Request{Http: &authv3.AttributeContext_HttpRequest{
+			Method: http.MethodPost,
+			Path:   "/foo",
+			Body:   body,
+		}}}}
+	}
+	requests := []*authv3.CheckRequest{newRequest("0123456"), newRequest("0123456789")}
+	// the requests of a batch are truncated like the ones of a unary check
+	s := &service{
+		provider:  staticProvider{compile(t, "policy", admissionregistrationv1.Fail, expression)},
+		bodyLimit: NewBodyLimit(8, nil),
+	}
+	response, err := s.BatchCheck(context.Background(), &authzv1alpha1.BatchCheckRequest{Requests: requests})
+	require.NoError(t, err)
+	require.Len(t, response.GetResponses(), 2)
+	assert.Equal(t, "false:0123456", response.GetResponses()[0].GetDeniedResponse().GetBody())
+	assert.Equal(t, "true:01234567", response.GetResponses()[1].GetDeniedResponse().GetBody())
+	// and rejected, the other requests of the batch are still evaluated
+	s.bodyLimit = NewBodyLimit(8, &DefaultDecision{Decision: DecisionDeny, DenyStatus: http.StatusRequestEntityTooLarge})
+	response, err = s.BatchCheck(context.Background(), &authzv1alpha1.BatchCheckRequest{Requests: requests})
+	require.NoError(t, err)
+	require.Len(t, response.GetResponses(), 2)
+	assert.Equal(t, http.StatusTeapot, int(response.GetResponses()[0].GetDeniedResponse().GetStatus().GetCode()))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, int(response.GetResponses()[1].GetDeniedResponse().GetStatus().GetCode()))
+	// the requests sent by envoy are left untouched
+	assert.Equal(t, "0123456789", requests[1].GetAttributes().GetRequest().GetHttp().GetBody())
+}
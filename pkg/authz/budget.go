@@ -0,0 +1,73 @@
+package authz
+
+import (
+	"context"
+	"sync/atomic"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+)
+
+// CostBudget bounds the CEL cost spent evaluating the policies of a check. Policies are evaluated in priority order
+// and a policy is skipped when the budget left by the policies evaluated before it doesn't cover its estimated cost,
+// the most expensive policies are shed instead of letting the latency of every check grow with the number of heavy
+// policies. A nil CostBudget doesn't bound checks.
+type CostBudget struct {
+	budget uint64
+	// decision is the decision taken by the skipped policies, they take no decision when it is nil
+	decision *DefaultDecision
+}
+
+// NewCostBudget returns a budget of the given CEL cost per check, skipped policies take the decision if any
+func NewCostBudget(budget uint64, decision *DefaultDecision) *CostBudget {
+	return &CostBudget{
+		budget:   budget,
+		decision: decision,
+	}
+}
+
+type spentCostKey struct{}
+
+// spentCost accumulates the actual cost of the policies evaluated for a check, concurrently evaluated policies share it
+type spentCost struct {
+	total atomic.Uint64
+}
+
+// start returns a context tracking the cost spent by the policies evaluated for a check
+func (b *CostBudget) start(ctx context.Context) context.Context {
+	if b == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spentCostKey{}, &spentCost{})
+}
+
+// allows returns true if the budget left covers the estimated cost of the policy
+func (b *CostBudget) allows(ctx context.Context, policy policy.CompiledPolicy) bool {
+	if b == nil {
+		return true
+	}
+	spent, ok := ctx.Value(spentCostKey{}).(*spentCost)
+	if !ok {
+		return true
+	}
+	total := spent.total.Load()
+	return total <= b.budget && policy.EstimatedCost <= b.budget-total
+}
+
+// spend records the actual cost of a policy evaluated for the check
+func (b *CostBudget) spend(ctx context.Context, cost uint64) {
+	if b == nil {
+		return
+	}
+	if spent, ok := ctx.Value(spentCostKey{}).(*spentCost); ok {
+		spent.total.Add(cost)
+	}
+}
+
+// skipped returns the response of a skipped policy, nil when skipped policies take no decision
+func (b *CostBudget) skipped() *authv3.CheckResponse {
+	if b.decision == nil {
+		return nil
+	}
+	return b.decision.response()
+}
@@ -0,0 +1,130 @@
+package authz
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+)
+
+func TestCostBudget_allows(t *testing.T) {
+	cheap := policy.CompiledPolicy{EstimatedCost: 10}
+	expensive := policy.CompiledPolicy{EstimatedCost: 1000}
+	budget := NewCostBudget(100, nil)
+	ctx := budget.start(context.Background())
+	assert.True(t, budget.allows(ctx, cheap))
+	assert.False(t, budget.allows(ctx, expensive))
+	// the budget left shrinks with the actual cost of the evaluated policies
+	budget.spend(ctx, 85)
+	assert.True(t, budget.allows(ctx, cheap))
+	budget.spend(ctx, 10)
+	assert.False(t, budget.allows(ctx, cheap))
+	// the actual cost can exceed the budget, with a cost limit above it for example
+	budget.spend(ctx, 100)
+	assert.False(t, budget.allows(ctx, policy.CompiledPolicy{}))
+	// checks have their own budget
+	assert.True(t, budget.allows(budget.start(context.Background()), cheap))
+	// a nil budget allows everything
+	var none *CostBudget
+	assert.True(t, none.allows(none.start(context.Background()), expensive))
+}
+
+func Test_service_Check_costBudget(t *testing.T) {
+	// the comprehension over the request headers is much more expensive than the other policies
+	const expensive = `object.attributes.request.http.headers.all(k, object.attributes.request.http.headers[k].contains("x")) ? envoy.Denied(403).Response() : null`
+	newPolicies := func(t *testing.T, mode hub.EnforcementMode) staticProvider {
+		heavy := compile(t, "expensive", admissionregistrationv1.Fail, expensive)
+		heavy.Mode = mode
+		return staticProvider{
+			compile(t, "cheap", admissionregistrationv1.Fail, `false ? envoy.Allowed().Response() : null`),
+			heavy,
+			compile(t, "allow", admissionregistrationv1.Fail, `envoy.Allowed().Response()`),
+		}
+	}
+	request := &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{Headers: map[string]string{"x-foo": "x"}},
+			},
+		},
+	}
+	policies := newPolicies(t, hub.EnforcementModeEnforce)
+	// the budget covers the cheap policies, not the expensive one
+	tight := 2 * max(policies[0].EstimatedCost, policies[2].EstimatedCost)
+	assert.Less(t, tight, policies[1].EstimatedCost)
+	tests := []struct {
+		name        string
+		mode        hub.EnforcementMode
+		budget      *CostBudget
+		wantCode    codes.Code
+		wantStatus  typev3.StatusCode
+		wantSkipped bool
+	}{{
+		name:     "no budget",
+		wantCode: codes.PermissionDenied,
+	}, {
+		name:     "large budget",
+		budget:   NewCostBudget(10*policies[1].EstimatedCost, nil),
+		wantCode: codes.PermissionDenied,
+	}, {
+		name:        "skipped policies take no decision",
+		budget:      NewCostBudget(tight, nil),
+		wantCode:    codes.OK,
+		wantSkipped: true,
+	}, {
+		name:        "skipped policies deny",
+		budget:      NewCostBudget(tight, &DefaultDecision{Decision: DecisionDeny, DenyStatus: 503}),
+		wantCode:    codes.PermissionDenied,
+		wantStatus:  typev3.StatusCode_ServiceUnavailable,
+		wantSkipped: true,
+	}, {
+		name:        "skipped audit policies never affect the response",
+		mode:        hub.EnforcementModeAudit,
+		budget:      NewCostBudget(tight, &DefaultDecision{Decision: DecisionDeny}),
+		wantCode:    codes.OK,
+		wantSkipped: true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+			m, err := metrics.New(registry)
+			assert.NoError(t, err)
+			mode := tt.mode
+			if mode == "" {
+				mode = hub.EnforcementModeEnforce
+			}
+			s := &service{
+				provider:   newPolicies(t, mode),
+				metrics:    m,
+				costBudget: tt.budget,
+			}
+			for range 2 {
+				response, err := s.Check(context.Background(), request)
+				assert.NoError(t, err)
+				assert.Equal(t, int32(tt.wantCode), response.GetStatus().GetCode())
+				if tt.wantStatus != 0 {
+					assert.Equal(t, tt.wantStatus, response.GetDeniedResponse().GetStatus().GetCode())
+				}
+			}
+			expected := ""
+			if tt.wantSkipped {
+				expected = `
+# HELP policy_skipped_cost_budget_total Number of policy evaluations skipped because the cost budget left for the check didn't cover the policy estimated cost, partitioned by policy.
+# TYPE policy_skipped_cost_budget_total counter
+policy_skipped_cost_budget_total{policy="expensive"} 2
+`
+			}
+			assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "policy_skipped_cost_budget_total"))
+		})
+	}
+}
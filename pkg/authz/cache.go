@@ -0,0 +1,91 @@
+package authz
+
+import (
+	"context"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/apimachinery/pkg/util/cache"
+)
+
+// DecisionCache is a bounded LRU of the decisions taken by the policies declaring a cache key,
+// it is shared by the servers and safe for concurrent use. A nil DecisionCache caches nothing.
+type DecisionCache struct {
+	cache *cache.LRUExpireCache
+}
+
+// NewDecisionCache returns a cache holding at most size decisions, the least recently used decision is
+// evicted when the cache is full
+func NewDecisionCache(size int) *DecisionCache {
+	return newDecisionCache(cache.NewLRUExpireCache(size))
+}
+
+func newDecisionCache(cache *cache.LRUExpireCache) *DecisionCache {
+	return &DecisionCache{cache: cache}
+}
+
+// decisionKey identifies a cached decision, the compiled policy changes every time the policy
+// is compiled so decisions taken by a previous version of the policy are never returned
+type decisionKey struct {
+	policy *core.DecisionCache
+	key    string
+}
+
+// cachedDecision is a cached policy response, a nil response means the policy didn't take a decision
+type cachedDecision struct {
+	response *authv3.CheckResponse
+}
+
+func (c *DecisionCache) get(policy *core.DecisionCache, key string) (*authv3.CheckResponse, bool) {
+	if c == nil {
+		return nil, false
+	}
+	value, ok := c.cache.Get(decisionKey{policy: policy, key: key})
+	if !ok {
+		return nil, false
+	}
+	// responses are mutated downstream, every hit gets its own copy
+	return clone(value.(cachedDecision).response), true
+}
+
+func (c *DecisionCache) add(policy *core.DecisionCache, key string, response *authv3.CheckResponse) {
+	if c == nil {
+		return
+	}
+	c.cache.Add(decisionKey{policy: policy, key: key}, cachedDecision{response: clone(response)}, policy.TTL)
+}
+
+func clone(response *authv3.CheckResponse) *authv3.CheckResponse {
+	if response == nil {
+		return nil
+	}
+	return proto.Clone(response).(*authv3.CheckResponse)
+}
+
+// evaluatePolicy evaluates the policy, unless its decision for the request is cached
+func (s *service) evaluatePolicy(ctx context.Context, r *authv3.CheckRequest, policy policy.CompiledPolicy) (*authv3.CheckResponse, error) {
+	// the decisions taken without the body must not be returned once envoy forwards it
+	if policy.Cache == nil || s.decisionCache == nil || (s.missingBody != nil && policy.ReadsBody && core.BodyMissing(r)) {
+		return policy.Evaluate(ctx, r)
+	}
+	// a key that can't be computed bypasses the cache, the evaluation obeys the failure policy
+	key, err := policy.Cache.Key(ctx, r)
+	if err != nil {
+		s.metrics.RecordDecisionCache(policy.Name, false)
+		return policy.Evaluate(ctx, r)
+	}
+	if response, ok := s.decisionCache.get(policy.Cache, key); ok {
+		s.metrics.RecordDecisionCache(policy.Name, true)
+		return response, nil
+	}
+	s.metrics.RecordDecisionCache(policy.Name, false)
+	ctx, failure := core.WithEvaluationFailure(ctx)
+	response, err := policy.Evaluate(ctx, r)
+	// failures can be transient, including the ones ignored by the failure policy, they are never cached
+	if err == nil && !failure.Failed() {
+		s.decisionCache.add(policy.Cache, key, response)
+	}
+	return response, err
+}
@@ -0,0 +1,196 @@
+package authz
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/cache"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+// userKey keys decisions by the x-user request header
+func userKey(ttl time.Duration) *core.DecisionCache {
+	return &core.DecisionCache{
+		Key: func(_ context.Context, r *authv3.CheckRequest) (string, error) {
+			return r.GetAttributes().GetRequest().GetHttp().GetHeaders()["x-user"], nil
+		},
+		TTL: ttl,
+	}
+}
+
+func userRequest(user string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Headers: map[string]string{"x-user": user},
+				},
+			},
+		},
+	}
+}
+
+func TestDecisionCache(t *testing.T) {
+	clock := testingclock.NewFakeClock(time.Now())
+	c := newDecisionCache(cache.NewLRUExpireCacheWithClock(2, clock))
+	policy := userKey(time.Minute)
+	// miss
+	_, ok := c.get(policy, "alice")
+	assert.False(t, ok)
+	// hit, the cached response is a copy
+	response := allowed("alice")
+	c.add(policy, "alice", response)
+	got, ok := c.get(policy, "alice")
+	assert.True(t, ok)
+	assert.Equal(t, "alice", got.GetStatus().GetMessage())
+	assert.NotSame(t, response, got)
+	got.Status.Message = "mutated"
+	got, _ = c.get(policy, "alice")
+	assert.Equal(t, "alice", got.GetStatus().GetMessage())
+	// no decision is cached too
+	c.add(policy, "nobody", nil)
+	got, ok = c.get(policy, "nobody")
+	assert.True(t, ok)
+	assert.Nil(t, got)
+	// another compilation of the policy doesn't see the decisions
+	_, ok = c.get(userKey(time.Minute), "alice")
+	assert.False(t, ok)
+	// the least recently used decision is evicted
+	c.add(policy, "bob", allowed("bob"))
+	_, ok = c.get(policy, "alice")
+	assert.False(t, ok)
+	_, ok = c.get(policy, "bob")
+	assert.True(t, ok)
+	// decisions expire after the ttl
+	clock.Step(time.Minute + time.Second)
+	_, ok = c.get(policy, "bob")
+	assert.False(t, ok)
+	// a nil cache caches nothing
+	var disabled *DecisionCache
+	disabled.add(policy, "alice", allowed("alice"))
+	_, ok = disabled.get(policy, "alice")
+	assert.False(t, ok)
+}
+
+func Test_service_Check_decisionCache(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := metrics.New(registry)
+	assert.NoError(t, err)
+	var calls atomic.Int32
+	cached := staticPolicy("cached", allowed("cached"), 0, &calls)
+	cached.Cache = userKey(time.Minute)
+	s := &service{
+		provider:      staticProvider{cached},
+		metrics:       m,
+		decisionCache: NewDecisionCache(10),
+	}
+	for _, user := range []string{"alice", "alice", "bob", "alice"} {
+		response, err := s.Check(context.Background(), userRequest(user))
+		assert.NoError(t, err)
+		assert.Equal(t, "cached", response.GetStatus().GetMessage())
+	}
+	assert.Equal(t, int32(2), calls.Load())
+	expected := `
+# HELP policy_decision_cache_requests_total Number of decision cache lookups, partitioned by policy and result (hit or miss).
+# TYPE policy_decision_cache_requests_total counter
+policy_decision_cache_requests_total{policy="cached",result="hit"} 2
+policy_decision_cache_requests_total{policy="cached",result="miss"} 2
+# HELP policy_evaluations_total Number of policy evaluations, partitioned by policy, enforcement mode and decision.
+# TYPE policy_evaluations_total counter
+policy_evaluations_total{decision="allow",mode="",policy="cached"} 4
+`
+	// cache hits still count as evaluations
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "policy_decision_cache_requests_total", "policy_evaluations_total"))
+}
+
+func Test_service_Check_decisionCacheConcurrent(t *testing.T) {
+	var calls atomic.Int32
+	cached := staticPolicy("cached", allowed("cached"), time.Millisecond, &calls)
+	cached.Cache = userKey(time.Minute)
+	s := &service{
+		provider:      staticProvider{cached},
+		decisionCache: NewDecisionCache(10),
+	}
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := s.Check(context.Background(), userRequest([]string{"alice", "bob"}[i%2]))
+			assert.NoError(t, err)
+			assert.Equal(t, "cached", response.GetStatus().GetMessage())
+		}()
+	}
+	wg.Wait()
+	// concurrent misses evaluate the policy, everything else is served from the cache
+	assert.LessOrEqual(t, calls.Load(), int32(50))
+	calls.Store(0)
+	_, err := s.Check(context.Background(), userRequest("alice"))
+	assert.NoError(t, err)
+	assert.Zero(t, calls.Load())
+}
+
+func Test_service_Check_decisionCacheFailures(t *testing.T) {
+	ignore := admissionregistrationv1.Ignore
+	compile := func(key string) policy.CompiledPolicy {
+		compiled, errs := policy.NewCompiler().Compile(&hub.AuthorizationPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "failing"},
+			Spec: hub.AuthorizationPolicySpec{
+				FailurePolicy:  &ignore,
+				Authorizations: []hub.Authorization{{Expression: `object.attributes.request.http.headers["missing"] == "foo" ? envoy.Allowed().Response() : null`}},
+				Cache:          &hub.DecisionCache{Key: key, TTL: metav1.Duration{Duration: time.Minute}},
+			},
+		})
+		assert.Empty(t, errs)
+		return compiled
+	}
+	tests := []struct {
+		name string
+		key  string
+	}{{
+		name: "ignored evaluation failure",
+		key:  `object.attributes.request.http.headers["x-user"]`,
+	}, {
+		name: "key failure",
+		key:  `object.attributes.request.http.headers["missing"]`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+			m, err := metrics.New(registry)
+			assert.NoError(t, err)
+			s := &service{
+				provider:        staticProvider{compile(tt.key)},
+				metrics:         m,
+				decisionCache:   NewDecisionCache(10),
+				defaultDecision: DefaultDecision{Decision: DecisionAllow},
+			}
+			for range 2 {
+				response, err := s.Check(context.Background(), userRequest("alice"))
+				assert.NoError(t, err)
+				assert.NotNil(t, response)
+			}
+			// failures are never cached
+			expected := `
+# HELP policy_decision_cache_requests_total Number of decision cache lookups, partitioned by policy and result (hit or miss).
+# TYPE policy_decision_cache_requests_total counter
+policy_decision_cache_requests_total{policy="failing",result="miss"} 2
+`
+			assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "policy_decision_cache_requests_total"))
+		})
+	}
+}
@@ -4,18 +4,16 @@ import (
 	"github.com/google/cel-go/cel"
 	"github.com/google/cel-go/ext"
 	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/envoy"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/ip"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/json"
 	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/jwt"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/spiffe"
 	"k8s.io/apiserver/pkg/cel/library"
 )
 
-func NewEnv() (*cel.Env, error) {
-	// create new cel env
-	return cel.NewEnv(
-		// configure env
-		cel.HomogeneousAggregateLiterals(),
-		cel.EagerlyValidateDeclarations(true),
-		cel.DefaultUTCTimeZone(true),
-		cel.CrossTypeNumericComparisons(true),
+// Libraries returns the libraries registered in every environment
+func Libraries() []cel.EnvOption {
+	return []cel.EnvOption{
 		// register common libs
 		cel.OptionalTypes(),
 		ext.Bindings(),
@@ -34,6 +32,24 @@ func NewEnv() (*cel.Env, error) {
 		library.URLs(),
 		// register our libs
 		envoy.Lib(),
+		json.Lib(),
 		jwt.Lib(),
-	)
+		ip.Lib(),
+		spiffe.Lib(),
+	}
+}
+
+// NewEnv creates a new cel env, the given libraries are registered in order after the built-in ones
+func NewEnv(libraries ...cel.EnvOption) (*cel.Env, error) {
+	options := []cel.EnvOption{
+		// configure env
+		cel.HomogeneousAggregateLiterals(),
+		cel.EagerlyValidateDeclarations(true),
+		cel.DefaultUTCTimeZone(true),
+		cel.CrossTypeNumericComparisons(true),
+	}
+	options = append(options, Libraries()...)
+	options = append(options, libraries...)
+	// create new cel env
+	return cel.NewEnv(options...)
 }
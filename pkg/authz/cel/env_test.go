@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/google/cel-go/cel"
 	"github.com/google/cel-go/interpreter"
 	"github.com/stretchr/testify/assert"
 )
@@ -33,3 +34,50 @@ envoy
 	assert.NoError(t, err)
 	assert.NotNil(t, a)
 }
+
+func TestNewEnv_requestBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		body    string
+		want    bool
+	}{{
+		name: "delete",
+		body: `{"action": "delete"}`,
+		want: true,
+	}, {
+		name: "other action",
+		body: `{"action": "get"}`,
+	}, {
+		name:    "truncated",
+		headers: map[string]string{"x-envoy-auth-partial-body": "true"},
+		body:    `{"action": "del`,
+	}}
+	const source = `!object.BodyTruncated() && json.Parse(object.attributes.request.http.body).action == "delete"`
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := NewEnv()
+			assert.NoError(t, err)
+			env, err = env.Extend(cel.Variable("object", cel.ObjectType("envoy.service.auth.v3.CheckRequest")))
+			assert.NoError(t, err)
+			ast, issues := env.Compile(source)
+			assert.Nil(t, issues)
+			prog, err := env.Program(ast)
+			assert.NoError(t, err)
+			out, _, err := prog.Eval(map[string]any{
+				"object": &authv3.CheckRequest{
+					Attributes: &authv3.AttributeContext{
+						Request: &authv3.AttributeContext_Request{
+							Http: &authv3.AttributeContext_HttpRequest{
+								Headers: tt.headers,
+								Body:    tt.body,
+							},
+						},
+					},
+				},
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, out.Value())
+		})
+	}
+}
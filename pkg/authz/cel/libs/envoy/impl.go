@@ -246,3 +246,11 @@ func (c *impl) response_with_metadata(response ref.Val, metadata ref.Val) ref.Va
 		return c.NativeToValue(response)
 	}
 }
+
+func (c *impl) request_body_truncated(request ref.Val) ref.Val {
+	if request, err := utils.ConvertToNative[*authv3.CheckRequest](request); err != nil {
+		return types.WrapErr(err)
+	} else {
+		return types.Bool(request.GetAttributes().GetRequest().GetHttp().GetHeaders()[PartialBodyHeader] == "true")
+	}
+}
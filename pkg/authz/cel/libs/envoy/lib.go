@@ -18,6 +18,9 @@ var (
 	QueryParameter     = types.NewObjectType("envoy.config.core.v3.QueryParameter")
 )
 
+// PartialBodyHeader is the header envoy sets when the request body was truncated
+const PartialBodyHeader = "x-envoy-auth-partial-body"
+
 type lib struct{}
 
 func Lib() cel.EnvOption {
@@ -99,6 +102,9 @@ func (*lib) extendEnv(env *cel.Env) (*cel.Env, error) {
 		"WithMetadata": {
 			cel.MemberOverload("response_with_metadata", []*cel.Type{CheckResponse, Metadata}, CheckResponse, cel.BinaryBinding(impl.response_with_metadata)),
 		},
+		"BodyTruncated": {
+			cel.MemberOverload("request_body_truncated", []*cel.Type{CheckRequest}, types.BoolType, cel.UnaryBinding(impl.request_body_truncated)),
+		},
 	}
 	// create env options corresponding to our function overloads
 	options := []cel.EnvOption{}
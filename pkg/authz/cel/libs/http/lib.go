@@ -0,0 +1,276 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	nethttp "net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+	"k8s.io/apimachinery/pkg/util/cache"
+)
+
+const (
+	// defaultTimeout bounds the time spent calling a service, the evaluation timeout applies too
+	defaultTimeout = 2 * time.Second
+	// defaultCacheTTL is the time a response is reused for the same call
+	defaultCacheTTL = 30 * time.Second
+	// maxResponseSize is the maximum size of a response body
+	maxResponseSize = 1 << 20
+	// maxRedirects is the maximum number of redirects followed by a call
+	maxRedirects = 10
+)
+
+type lib struct {
+	allowedHosts []string
+	client       *nethttp.Client
+	timeout      time.Duration
+	cacheTTL     time.Duration
+	cache        *cache.Expiring
+}
+
+type Option func(*lib)
+
+// WithTimeout sets the maximum duration of a call, it defaults to 2 seconds
+func WithTimeout(timeout time.Duration) Option {
+	return func(l *lib) {
+		l.timeout = timeout
+	}
+}
+
+// WithCacheTTL sets the time a successful response is reused for the same call, zero disables caching.
+// It defaults to 30 seconds.
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(l *lib) {
+		l.cacheTTL = ttl
+	}
+}
+
+// WithClient sets the client used to call services, the redirects it follows are checked against the allowed hosts
+func WithClient(client *nethttp.Client) Option {
+	return func(l *lib) {
+		l.client = client
+	}
+}
+
+// Lib returns the http library, only the given hosts can be called. A host is either a host name,
+// optionally with a port, or a wildcard like *.example.com matching its sub domains.
+// The library must be created once and shared by the compiled policies so that they share the cache.
+func Lib(allowedHosts []string, opts ...Option) cel.EnvOption {
+	l := &lib{
+		allowedHosts: allowedHosts,
+		client:       nethttp.DefaultClient,
+		timeout:      defaultTimeout,
+		cacheTTL:     defaultCacheTTL,
+		cache:        cache.NewExpiring(),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	// a redirect must target an allowed host too, the client is copied so that the client of the caller is not
+	// changed
+	client := *l.client
+	client.CheckRedirect = l.checkRedirect
+	l.client = &client
+	// create the cel lib env option
+	return cel.Lib(l)
+}
+
+func (*lib) LibraryName() string {
+	return "kyverno.http"
+}
+
+func (c *lib) CompileOptions() []cel.EnvOption {
+	return []cel.EnvOption{
+		// extend environment with function overloads
+		c.extendEnv,
+	}
+}
+
+func (c *lib) ProgramOptions() []cel.ProgramOption {
+	return []cel.ProgramOption{
+		// calls need the evaluation context, bindings don't have access to it
+		cel.CustomDecorator(c.decorate),
+	}
+}
+
+func (c *lib) extendEnv(env *cel.Env) (*cel.Env, error) {
+	// build our function overloads, bindings are only used when the evaluation context is unknown
+	libraryDecls := map[string][]cel.FunctionOpt{
+		"http.Get": {
+			cel.Overload("http_get_string", []*cel.Type{types.StringType}, types.DynType, cel.FunctionBinding(func(args ...ref.Val) ref.Val {
+				return c.get(context.Background(), args...)
+			})),
+		},
+		"http.Post": {
+			cel.Overload("http_post_string_dyn", []*cel.Type{types.StringType, types.DynType}, types.DynType, cel.FunctionBinding(func(args ...ref.Val) ref.Val {
+				return c.post(context.Background(), args...)
+			})),
+		},
+	}
+	// create env options corresponding to our function overloads
+	options := []cel.EnvOption{}
+	for name, overloads := range libraryDecls {
+		options = append(options, cel.Function(name, overloads...))
+	}
+	// extend environment with our function overloads
+	return env.Extend(options...)
+}
+
+// decorate replaces calls to our functions with calls receiving the evaluation context
+func (c *lib) decorate(i interpreter.Interpretable) (interpreter.Interpretable, error) {
+	call, ok := i.(interpreter.InterpretableCall)
+	if !ok {
+		return i, nil
+	}
+	switch call.OverloadID() {
+	case "http_get_string":
+		return &contextCall{InterpretableCall: call, call: c.get}, nil
+	case "http_post_string_dyn":
+		return &contextCall{InterpretableCall: call, call: c.post}, nil
+	default:
+		return i, nil
+	}
+}
+
+// contextCall evaluates a function call with the context of the activation
+type contextCall struct {
+	interpreter.InterpretableCall
+	call func(context.Context, ...ref.Val) ref.Val
+}
+
+func (c *contextCall) Eval(activation interpreter.Activation) ref.Val {
+	args := make([]ref.Val, 0, len(c.Args()))
+	for _, arg := range c.Args() {
+		value := arg.Eval(activation)
+		if types.IsUnknownOrError(value) {
+			return value
+		}
+		args = append(args, value)
+	}
+	return c.call(utils.ContextFrom(activation), args...)
+}
+
+func (c *lib) get(ctx context.Context, args ...ref.Val) ref.Val {
+	if len(args) != 1 {
+		return types.NewErr("no such overload")
+	}
+	rawURL, ok := args[0].(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(args[0])
+	}
+	return c.do(ctx, nethttp.MethodGet, string(rawURL), nil)
+}
+
+func (c *lib) post(ctx context.Context, args ...ref.Val) ref.Val {
+	if len(args) != 2 {
+		return types.NewErr("no such overload")
+	}
+	rawURL, ok := args[0].(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(args[0])
+	}
+	value, err := utils.ConvertToNative[*structpb.Value](args[1])
+	if err != nil {
+		return types.NewErr("invalid request body: %s", err)
+	}
+	body, err := protojson.Marshal(value)
+	if err != nil {
+		return types.NewErr("invalid request body: %s", err)
+	}
+	return c.do(ctx, nethttp.MethodPost, string(rawURL), body)
+}
+
+// do calls the service and returns the parsed json response, successful responses are cached
+func (c *lib) do(ctx context.Context, method string, rawURL string, body []byte) ref.Val {
+	if err := c.allowed(rawURL); err != nil {
+		return types.WrapErr(err)
+	}
+	key := method + " " + rawURL + "\n" + string(body)
+	if value, ok := c.cache.Get(key); ok {
+		return types.DefaultTypeAdapter.NativeToValue(value)
+	}
+	value, err := c.call(ctx, method, rawURL, body)
+	if err != nil {
+		return types.WrapErr(fmt.Errorf("failed to call %s %s: %w", method, rawURL, err))
+	}
+	if c.cacheTTL > 0 {
+		c.cache.Set(key, value, c.cacheTTL)
+	}
+	return types.DefaultTypeAdapter.NativeToValue(value)
+}
+
+func (c *lib) call(ctx context.Context, method string, rawURL string, body []byte) (*structpb.Value, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	request, err := nethttp.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxResponseSize {
+		return nil, fmt.Errorf("response exceeds %d bytes", maxResponseSize)
+	}
+	var value structpb.Value
+	if err := protojson.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("failed to parse json: %w", err)
+	}
+	return &value, nil
+}
+
+// checkRedirect follows the redirects to allowed hosts only
+func (c *lib) checkRedirect(request *nethttp.Request, via []*nethttp.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	return c.allowed(request.URL.String())
+}
+
+// allowed checks the url targets an allowed host over http or https
+func (c *lib) allowed(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid url %q: %w", rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid url %q: scheme must be http or https", rawURL)
+	}
+	host := strings.ToLower(u.Host)
+	hostname := strings.ToLower(u.Hostname())
+	if slices.ContainsFunc(c.allowedHosts, func(allowed string) bool {
+		allowed = strings.ToLower(allowed)
+		if suffix, ok := strings.CutPrefix(allowed, "*"); ok {
+			return strings.HasPrefix(suffix, ".") && strings.HasSuffix(hostname, suffix)
+		}
+		return allowed == host || allowed == hostname
+	}) {
+		return nil
+	}
+	return fmt.Errorf("host %q is not allowed", u.Host)
+}
@@ -0,0 +1,212 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	nethttp "net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// newServer returns a stub entitlements service, it counts the calls it receives
+func newServer(t *testing.T) (*httptest.Server, *atomic.Int64) {
+	var calls atomic.Int64
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		calls.Add(1)
+		switch r.URL.Path {
+		case "/entitlements":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"user":"` + r.URL.Query().Get("user") + `","roles":["admin"]}`))
+		case "/check":
+			var body map[string]any
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || r.Method != nethttp.MethodPost {
+				w.WriteHeader(nethttp.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"allowed":` + map[bool]string{true: "true", false: "false"}[body["user"] == "alice"] + `}`))
+		case "/slow":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			_, _ = w.Write([]byte(`{}`))
+		case "/redirect":
+			nethttp.Redirect(w, r, r.URL.Query().Get("to"), nethttp.StatusFound)
+		case "/invalid":
+			_, _ = w.Write([]byte(`not json`))
+		default:
+			w.WriteHeader(nethttp.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func eval(t *testing.T, ctx context.Context, lib cel.EnvOption, expression string) (any, error) {
+	t.Helper()
+	env, err := cel.NewEnv(lib)
+	assert.NoError(t, err)
+	ast, issues := env.Compile(expression)
+	assert.Nil(t, issues)
+	prog, err := env.Program(ast)
+	assert.NoError(t, err)
+	out, _, err := prog.ContextEval(ctx, map[string]any{utils.EvaluationContextKey: ctx})
+	if err != nil {
+		return nil, err
+	}
+	return out.Value(), nil
+}
+
+func TestLib(t *testing.T) {
+	server, _ := newServer(t)
+	host := server.Listener.Addr().String()
+	tests := []struct {
+		name         string
+		allowedHosts []string
+		expression   string
+		want         any
+		wantErr      string
+	}{{
+		name:         "get",
+		allowedHosts: []string{host},
+		expression:   `http.Get("` + server.URL + `/entitlements?user=alice").roles[0]`,
+		want:         "admin",
+	}, {
+		name:         "host without port",
+		allowedHosts: []string{"127.0.0.1"},
+		expression:   `http.Get("` + server.URL + `/entitlements?user=alice").user`,
+		want:         "alice",
+	}, {
+		name:         "post",
+		allowedHosts: []string{host},
+		expression:   `http.Post("` + server.URL + `/check", {"user": "alice"}).allowed`,
+		want:         true,
+	}, {
+		name:         "post denied",
+		allowedHosts: []string{host},
+		expression:   `http.Post("` + server.URL + `/check", {"user": "bob"}).allowed`,
+		want:         false,
+	}, {
+		name:         "disallowed host",
+		allowedHosts: []string{"entitlements.example.com"},
+		expression:   `http.Get("` + server.URL + `/entitlements")`,
+		wantErr:      "is not allowed",
+	}, {
+		name:       "no allowed host",
+		expression: `http.Get("` + server.URL + `/entitlements")`,
+		wantErr:    "is not allowed",
+	}, {
+		name:         "wildcard doesn't match the parent domain",
+		allowedHosts: []string{"*.example.com"},
+		expression:   `http.Get("https://example.com/entitlements")`,
+		wantErr:      "is not allowed",
+	}, {
+		name:         "invalid scheme",
+		allowedHosts: []string{host},
+		expression:   `http.Get("file:///etc/passwd")`,
+		wantErr:      "scheme must be http or https",
+	}, {
+		name:         "unexpected status",
+		allowedHosts: []string{host},
+		expression:   `http.Get("` + server.URL + `/missing")`,
+		wantErr:      "unexpected status 404",
+	}, {
+		name:         "invalid json",
+		allowedHosts: []string{host},
+		expression:   `http.Get("` + server.URL + `/invalid")`,
+		wantErr:      "failed to parse json",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := eval(t, context.Background(), Lib(tt.allowedHosts), tt.expression)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLib_allowedWildcard(t *testing.T) {
+	l := &lib{allowedHosts: []string{"*.example.com"}}
+	assert.NoError(t, l.allowed("https://entitlements.example.com/roles"))
+	assert.NoError(t, l.allowed("https://a.b.EXAMPLE.com:8443/roles"))
+	assert.Error(t, l.allowed("https://example.com/roles"))
+	assert.Error(t, l.allowed("https://entitlements.example.com.evil.io/roles"))
+}
+
+func TestLib_redirect(t *testing.T) {
+	server, _ := newServer(t)
+	other, otherCalls := newServer(t)
+	lib := Lib([]string{server.Listener.Addr().String()})
+	// a redirect to the allowed host is followed
+	got, err := eval(t, context.Background(), lib, `http.Get("`+server.URL+`/redirect?to=/entitlements%3Fuser%3Dalice").user`)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", got)
+	// a redirect to a host that isn't allowed is not followed
+	_, err = eval(t, context.Background(), lib, `http.Get("`+server.URL+`/redirect?to=`+other.URL+`/entitlements").user`)
+	assert.ErrorContains(t, err, "is not allowed")
+	assert.Zero(t, otherCalls.Load())
+}
+
+func TestLib_cache(t *testing.T) {
+	server, calls := newServer(t)
+	ttl := 200 * time.Millisecond
+	lib := Lib([]string{server.Listener.Addr().String()}, WithCacheTTL(ttl))
+	get := func(user string) any {
+		got, err := eval(t, context.Background(), lib, `http.Get("`+server.URL+`/entitlements?user=`+user+`").user`)
+		assert.NoError(t, err)
+		return got
+	}
+	assert.Equal(t, "alice", get("alice"))
+	assert.Equal(t, "alice", get("alice"))
+	assert.Equal(t, int64(1), calls.Load())
+	// the cache is keyed by url
+	assert.Equal(t, "bob", get("bob"))
+	assert.Equal(t, int64(2), calls.Load())
+	// responses expire
+	time.Sleep(2 * ttl)
+	assert.Equal(t, "alice", get("alice"))
+	assert.Equal(t, int64(3), calls.Load())
+	// errors are not cached
+	for range 2 {
+		_, err := eval(t, context.Background(), lib, `http.Get("`+server.URL+`/missing")`)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, int64(5), calls.Load())
+}
+
+func TestLib_cacheDisabled(t *testing.T) {
+	server, calls := newServer(t)
+	lib := Lib([]string{server.Listener.Addr().String()}, WithCacheTTL(0))
+	for range 2 {
+		_, err := eval(t, context.Background(), lib, `http.Get("`+server.URL+`/entitlements")`)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int64(2), calls.Load())
+}
+
+func TestLib_timeout(t *testing.T) {
+	server, _ := newServer(t)
+	expression := `http.Get("` + server.URL + `/slow")`
+	// the call timeout
+	start := time.Now()
+	_, err := eval(t, context.Background(), Lib([]string{server.Listener.Addr().String()}, WithTimeout(50*time.Millisecond)), expression)
+	assert.ErrorContains(t, err, "context deadline exceeded")
+	assert.Less(t, time.Since(start), 2*time.Second)
+	// the evaluation context
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = eval(t, ctx, Lib([]string{server.Listener.Addr().String()}), expression)
+	assert.ErrorContains(t, err, "context deadline exceeded")
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
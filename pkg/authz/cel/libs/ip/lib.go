@@ -0,0 +1,153 @@
+package ip
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/envoy"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
+)
+
+// ForwardedForHeader is the header read by ip.ClientIP
+const ForwardedForHeader = "x-forwarded-for"
+
+type lib struct{}
+
+func Lib() cel.EnvOption {
+	// create the cel lib env option
+	return cel.Lib(&lib{})
+}
+
+func (*lib) LibraryName() string {
+	return "kyverno.ip"
+}
+
+func (c *lib) CompileOptions() []cel.EnvOption {
+	return []cel.EnvOption{
+		// extend environment with function overloads
+		c.extendEnv,
+	}
+}
+
+func (*lib) ProgramOptions() []cel.ProgramOption {
+	return []cel.ProgramOption{}
+}
+
+func (*lib) extendEnv(env *cel.Env) (*cel.Env, error) {
+	// build our function overloads
+	libraryDecls := map[string][]cel.FunctionOpt{
+		"ip.InCIDR": {
+			cel.Overload("ip_in_cidr_string_string", []*cel.Type{types.StringType, types.StringType}, types.BoolType, cel.BinaryBinding(inCIDR)),
+		},
+		"ip.IsPrivate": {
+			cel.Overload("ip_is_private_string", []*cel.Type{types.StringType}, types.BoolType, cel.UnaryBinding(isPrivate)),
+		},
+		"ip.SourceIP": {
+			cel.Overload("ip_source_ip_request", []*cel.Type{envoy.CheckRequest}, types.StringType, cel.UnaryBinding(sourceIP)),
+		},
+		"ip.ClientIP": {
+			cel.Overload("ip_client_ip_request_int", []*cel.Type{envoy.CheckRequest, types.IntType}, types.StringType, cel.BinaryBinding(clientIP)),
+		},
+	}
+	// create env options corresponding to our function overloads
+	options := []cel.EnvOption{}
+	for name, overloads := range libraryDecls {
+		options = append(options, cel.Function(name, overloads...))
+	}
+	// extend environment with our function overloads
+	return env.Extend(options...)
+}
+
+// parseAddr parses an ip address, with or without a port, ipv4 mapped ipv6 addresses are unmapped
+func parseAddr(value string) (netip.Addr, error) {
+	value = strings.TrimSpace(value)
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		addrPort, portErr := netip.ParseAddrPort(value)
+		if portErr != nil {
+			return netip.Addr{}, err
+		}
+		addr = addrPort.Addr()
+	}
+	return addr.WithZone("").Unmap(), nil
+}
+
+func inCIDR(addr ref.Val, cidr ref.Val) ref.Val {
+	if addr, err := utils.ConvertToNative[string](addr); err != nil {
+		return types.WrapErr(err)
+	} else if cidr, err := utils.ConvertToNative[string](cidr); err != nil {
+		return types.WrapErr(err)
+	} else if addr, err := parseAddr(addr); err != nil {
+		return types.NewErr("invalid ip address: %s", err)
+	} else if prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr)); err != nil {
+		return types.NewErr("invalid cidr: %s", err)
+	} else {
+		// an address never belongs to a cidr of the other family
+		return types.Bool(prefix.Masked().Contains(addr))
+	}
+}
+
+func isPrivate(addr ref.Val) ref.Val {
+	if addr, err := utils.ConvertToNative[string](addr); err != nil {
+		return types.WrapErr(err)
+	} else if addr, err := parseAddr(addr); err != nil {
+		return types.NewErr("invalid ip address: %s", err)
+	} else {
+		return types.Bool(addr.IsPrivate())
+	}
+}
+
+func source(request *authv3.CheckRequest) (netip.Addr, error) {
+	socket := request.GetAttributes().GetSource().GetAddress().GetSocketAddress()
+	if socket == nil {
+		return netip.Addr{}, errors.New("not a socket address")
+	}
+	return parseAddr(socket.GetAddress())
+}
+
+func sourceIP(request ref.Val) ref.Val {
+	if request, err := utils.ConvertToNative[*authv3.CheckRequest](request); err != nil {
+		return types.WrapErr(err)
+	} else if addr, err := source(request); err != nil {
+		return types.NewErr("invalid source address: %s", err)
+	} else {
+		return types.String(addr.String())
+	}
+}
+
+func clientIP(request ref.Val, trustedHops ref.Val) ref.Val {
+	if request, err := utils.ConvertToNative[*authv3.CheckRequest](request); err != nil {
+		return types.WrapErr(err)
+	} else if trustedHops, err := utils.ConvertToNative[int64](trustedHops); err != nil {
+		return types.WrapErr(err)
+	} else if trustedHops < 0 {
+		return types.NewErr("trusted hops must not be negative: %d", trustedHops)
+	} else {
+		// without trusted proxies, the client is the peer of envoy
+		var forwarded []string
+		if header := request.GetAttributes().GetRequest().GetHttp().GetHeaders()[ForwardedForHeader]; header != "" && trustedHops > 0 {
+			// envoy joins multiple headers with a comma
+			forwarded = strings.Split(header, ",")
+		}
+		if len(forwarded) == 0 {
+			if addr, err := source(request); err != nil {
+				return types.NewErr("invalid source address: %s", err)
+			} else {
+				return types.String(addr.String())
+			}
+		}
+		// every trusted proxy appended the address of its peer, addresses on the left
+		// of the ones appended by trusted proxies can be forged by the client
+		index := len(forwarded) - int(min(trustedHops, int64(len(forwarded))))
+		if addr, err := parseAddr(forwarded[index]); err != nil {
+			return types.NewErr("invalid %s address: %s", ForwardedForHeader, err)
+		} else {
+			return types.String(addr.String())
+		}
+	}
+}
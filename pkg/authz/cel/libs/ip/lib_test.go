@@ -0,0 +1,255 @@
+package ip
+
+import (
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/google/cel-go/cel"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/envoy"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRequest(source string, forwardedFor string) *authv3.CheckRequest {
+	request := &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Source: &authv3.AttributeContext_Peer{
+				Address: &corev3.Address{
+					Address: &corev3.Address_SocketAddress{
+						SocketAddress: &corev3.SocketAddress{Address: source, PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: 51234}},
+					},
+				},
+			},
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Headers: map[string]string{},
+				},
+			},
+		},
+	}
+	if forwardedFor != "" {
+		request.Attributes.Request.Http.Headers[ForwardedForHeader] = forwardedFor
+	}
+	return request
+}
+
+func eval(t *testing.T, expression string, request *authv3.CheckRequest) (any, error) {
+	t.Helper()
+	env, err := cel.NewEnv(envoy.Lib(), Lib(), cel.Variable("object", envoy.CheckRequest))
+	assert.NoError(t, err)
+	ast, issues := env.Compile(expression)
+	assert.Nil(t, issues)
+	prog, err := env.Program(ast)
+	assert.NoError(t, err)
+	out, _, err := prog.Eval(map[string]any{"object": request})
+	if err != nil {
+		return nil, err
+	}
+	return out.Value(), nil
+}
+
+func Test_inCIDR(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		want       any
+		wantErr    bool
+	}{{
+		name:       "ipv4 inside",
+		expression: `ip.InCIDR("10.1.2.3", "10.0.0.0/8")`,
+		want:       true,
+	}, {
+		name:       "ipv4 outside",
+		expression: `ip.InCIDR("11.0.0.1", "10.0.0.0/8")`,
+		want:       false,
+	}, {
+		name:       "ipv4 first address",
+		expression: `ip.InCIDR("192.168.1.0", "192.168.1.0/24")`,
+		want:       true,
+	}, {
+		name:       "ipv4 last address",
+		expression: `ip.InCIDR("192.168.1.255", "192.168.1.0/24")`,
+		want:       true,
+	}, {
+		name:       "ipv4 next to last address",
+		expression: `ip.InCIDR("192.168.2.0", "192.168.1.0/24")`,
+		want:       false,
+	}, {
+		name:       "ipv4 single address",
+		expression: `ip.InCIDR("192.168.1.1", "192.168.1.1/32")`,
+		want:       true,
+	}, {
+		name:       "ipv4 any",
+		expression: `ip.InCIDR("203.0.113.7", "0.0.0.0/0")`,
+		want:       true,
+	}, {
+		name:       "non canonical cidr",
+		expression: `ip.InCIDR("192.168.1.42", "192.168.1.1/24")`,
+		want:       true,
+	}, {
+		name:       "ipv6 inside",
+		expression: `ip.InCIDR("2001:db8::1", "2001:db8::/32")`,
+		want:       true,
+	}, {
+		name:       "ipv6 last address",
+		expression: `ip.InCIDR("2001:db8:ffff:ffff:ffff:ffff:ffff:ffff", "2001:db8::/32")`,
+		want:       true,
+	}, {
+		name:       "ipv6 outside",
+		expression: `ip.InCIDR("2001:db9::", "2001:db8::/32")`,
+		want:       false,
+	}, {
+		name:       "ipv4 in ipv6 cidr",
+		expression: `ip.InCIDR("10.0.0.1", "::/0")`,
+		want:       false,
+	}, {
+		name:       "ipv6 in ipv4 cidr",
+		expression: `ip.InCIDR("2001:db8::1", "0.0.0.0/0")`,
+		want:       false,
+	}, {
+		name:       "ipv4 mapped ipv6 in ipv4 cidr",
+		expression: `ip.InCIDR("::ffff:10.0.0.1", "10.0.0.0/8")`,
+		want:       true,
+	}, {
+		name:       "address with port",
+		expression: `ip.InCIDR("[2001:db8::1]:8080", "2001:db8::/32")`,
+		want:       true,
+	}, {
+		name:       "invalid address",
+		expression: `ip.InCIDR("10.0.0.256", "10.0.0.0/8")`,
+		wantErr:    true,
+	}, {
+		name:       "invalid cidr",
+		expression: `ip.InCIDR("10.0.0.1", "10.0.0.0/33")`,
+		wantErr:    true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := eval(t, tt.expression, nil)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_isPrivate(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		want    any
+		wantErr bool
+	}{
+		{name: "10/8", addr: "10.255.255.255", want: true},
+		{name: "172.16/12 first", addr: "172.16.0.0", want: true},
+		{name: "172.16/12 last", addr: "172.31.255.255", want: true},
+		{name: "172.16/12 next", addr: "172.32.0.0", want: false},
+		{name: "192.168/16", addr: "192.168.0.1", want: true},
+		{name: "public ipv4", addr: "8.8.8.8", want: false},
+		{name: "unique local ipv6", addr: "fd00::1", want: true},
+		{name: "public ipv6", addr: "2001:4860:4860::8888", want: false},
+		{name: "ipv4 mapped", addr: "::ffff:192.168.0.1", want: true},
+		{name: "invalid", addr: "localhost", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := eval(t, `ip.IsPrivate("`+tt.addr+`")`, nil)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_clientIP(t *testing.T) {
+	tests := []struct {
+		name         string
+		source       string
+		forwardedFor string
+		trustedHops  string
+		want         any
+		wantErr      bool
+	}{{
+		name:        "no trusted hops",
+		source:      "10.0.0.1",
+		trustedHops: "0",
+		want:        "10.0.0.1",
+	}, {
+		name:         "no trusted hops ignores the header",
+		source:       "10.0.0.1",
+		forwardedFor: "203.0.113.7",
+		trustedHops:  "0",
+		want:         "10.0.0.1",
+	}, {
+		name:        "no header",
+		source:      "2001:db8::1",
+		trustedHops: "1",
+		want:        "2001:db8::1",
+	}, {
+		name:         "one trusted hop",
+		source:       "10.0.0.1",
+		forwardedFor: "198.51.100.1, 203.0.113.7",
+		trustedHops:  "1",
+		want:         "203.0.113.7",
+	}, {
+		name:         "two trusted hops",
+		source:       "10.0.0.1",
+		forwardedFor: "198.51.100.1,203.0.113.7, 10.0.0.2",
+		trustedHops:  "2",
+		want:         "203.0.113.7",
+	}, {
+		name:         "less addresses than trusted hops",
+		source:       "10.0.0.1",
+		forwardedFor: "203.0.113.7",
+		trustedHops:  "3",
+		want:         "203.0.113.7",
+	}, {
+		name:         "ipv6",
+		source:       "10.0.0.1",
+		forwardedFor: "2001:db8::7",
+		trustedHops:  "1",
+		want:         "2001:db8::7",
+	}, {
+		name:         "invalid header",
+		source:       "10.0.0.1",
+		forwardedFor: "unknown",
+		trustedHops:  "1",
+		wantErr:      true,
+	}, {
+		name:        "negative trusted hops",
+		source:      "10.0.0.1",
+		trustedHops: "-1",
+		wantErr:     true,
+	}, {
+		name:        "invalid source",
+		source:      "",
+		trustedHops: "0",
+		wantErr:     true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := eval(t, `ip.ClientIP(object, `+tt.trustedHops+`)`, newRequest(tt.source, tt.forwardedFor))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_sourceIP(t *testing.T) {
+	got, err := eval(t, `ip.SourceIP(object)`, newRequest("::ffff:10.0.0.1", "203.0.113.7"))
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", got)
+	// pipe addresses don't have an ip
+	_, err = eval(t, `ip.SourceIP(object)`, &authv3.CheckRequest{})
+	assert.Error(t, err)
+}
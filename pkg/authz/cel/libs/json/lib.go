@@ -0,0 +1,94 @@
+package json
+
+import (
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type lib struct {
+	// memoized are the overloads sharing their results between the expressions evaluated for a request
+	memoized map[string]func(...ref.Val) ref.Val
+}
+
+func Lib() cel.EnvOption {
+	// create the cel lib env option
+	return cel.Lib(&lib{})
+}
+
+func (*lib) LibraryName() string {
+	return "kyverno.json"
+}
+
+func (c *lib) CompileOptions() []cel.EnvOption {
+	return []cel.EnvOption{
+		// extend environment with function overloads
+		c.extendEnv,
+	}
+}
+
+func (c *lib) ProgramOptions() []cel.ProgramOption {
+	return []cel.ProgramOption{
+		// policies parsing the same document share the parsed value
+		cel.CustomDecorator(c.decorate),
+	}
+}
+
+func (c *lib) extendEnv(env *cel.Env) (*cel.Env, error) {
+	// get env type adapter
+	adapter := env.CELTypeAdapter()
+	parse := parse(adapter)
+	c.memoized = map[string]func(...ref.Val) ref.Val{
+		"parse_string": func(args ...ref.Val) ref.Val { return parse(args[0]) },
+		"parse_bytes":  func(args ...ref.Val) ref.Val { return parse(args[0]) },
+	}
+	// build our function overloads
+	libraryDecls := map[string][]cel.FunctionOpt{
+		"json.Parse": {
+			cel.Overload("parse_string", []*cel.Type{types.StringType}, types.DynType, cel.UnaryBinding(parse)),
+			cel.Overload("parse_bytes", []*cel.Type{types.BytesType}, types.DynType, cel.UnaryBinding(parse)),
+		},
+	}
+	// create env options corresponding to our function overloads
+	options := []cel.EnvOption{}
+	for name, overloads := range libraryDecls {
+		options = append(options, cel.Function(name, overloads...))
+	}
+	// extend environment with our function overloads
+	return env.Extend(options...)
+}
+
+// decorate replaces calls to the memoized overloads with calls sharing their results
+func (c *lib) decorate(i interpreter.Interpretable) (interpreter.Interpretable, error) {
+	call, ok := i.(interpreter.InterpretableCall)
+	if !ok {
+		return i, nil
+	}
+	if function, ok := c.memoized[call.OverloadID()]; ok {
+		return utils.Memoized(call, function), nil
+	}
+	return i, nil
+}
+
+func parse(adapter types.Adapter) func(document ref.Val) ref.Val {
+	return func(document ref.Val) ref.Val {
+		var data []byte
+		switch document := document.(type) {
+		case types.String:
+			data = []byte(document)
+		case types.Bytes:
+			data = []byte(document)
+		default:
+			return types.MaybeNoSuchOverloadErr(document)
+		}
+		var value structpb.Value
+		if err := protojson.Unmarshal(data, &value); err != nil {
+			return types.NewErr("failed to parse json: %s", err)
+		}
+		return adapter.NativeToValue(&value)
+	}
+}
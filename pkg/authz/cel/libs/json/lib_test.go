@@ -0,0 +1,82 @@
+package json
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parse(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		want       any
+		wantErr    bool
+	}{{
+		name:       "object field",
+		expression: `json.Parse('{"action": "delete"}').action`,
+		want:       "delete",
+	}, {
+		name:       "bytes",
+		expression: `json.Parse(b'{"action": "delete"}').action == "delete"`,
+		want:       true,
+	}, {
+		name:       "nested",
+		expression: `json.Parse('{"items": [{"id": 1}, {"id": 2}]}').items.map(i, i.id) == [1.0, 2.0]`,
+		want:       true,
+	}, {
+		name:       "scalar",
+		expression: `json.Parse('42') == 42`,
+		want:       true,
+	}, {
+		name:       "missing field",
+		expression: `json.Parse('{}').action`,
+		wantErr:    true,
+	}, {
+		name:       "optional field",
+		expression: `json.Parse('{}').?action.orValue("none")`,
+		want:       "none",
+	}, {
+		name:       "invalid document",
+		expression: `json.Parse('{"action":')`,
+		wantErr:    true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := cel.NewEnv(cel.OptionalTypes(), Lib())
+			assert.NoError(t, err)
+			ast, issues := env.Compile(tt.expression)
+			assert.Nil(t, issues)
+			prog, err := env.Program(ast)
+			assert.NoError(t, err)
+			out, _, err := prog.Eval(map[string]any{})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, out.Value())
+		})
+	}
+}
+
+func Test_parse_memoized(t *testing.T) {
+	env, err := cel.NewEnv(Lib(), cel.Variable("document", types.StringType), cel.Variable("body", types.BytesType))
+	assert.NoError(t, err)
+	ast, issues := env.Compile(`json.Parse(document).action == json.Parse(body).action`)
+	assert.Nil(t, issues)
+	prog, err := env.Program(ast)
+	assert.NoError(t, err)
+	ctx, memo := utils.WithMemo(context.Background())
+	for _, document := range []string{`{"action": "delete"}`, `{"action": "delete"}`, `{"action": "get"}`} {
+		out, _, err := prog.Eval(map[string]any{utils.EvaluationContextKey: ctx, "document": document, "body": []byte(`{"action": "get"}`)})
+		assert.NoError(t, err)
+		assert.Equal(t, document == `{"action": "get"}`, out.Value())
+	}
+	// strings and bytes with the same content are parsed separately, identical documents are parsed once
+	assert.Equal(t, 3, memo.Len())
+}
@@ -0,0 +1,398 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	nethttp "net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/jitter"
+	"k8s.io/utils/clock"
+)
+
+const (
+	// defaultKeySetTTL is the time fetched keys are used before being fetched again
+	defaultKeySetTTL = 5 * time.Minute
+	// defaultKeySetTimeout bounds the time spent fetching a key set
+	defaultKeySetTimeout = 5 * time.Second
+	// minRefreshInterval is the minimum time between two fetches of a key set, tokens with
+	// unknown key ids can't make the server fetch key sets more often than that
+	minRefreshInterval = 10 * time.Second
+	// maxKeySetSize is the maximum size of a key set or discovery document
+	maxKeySetSize = 1 << 20
+	// discoveryPath is where the discovery document of an issuer is served, relative to the issuer url
+	discoveryPath = "/.well-known/openid-configuration"
+)
+
+var (
+	errUntrustedIssuer = errors.New("token issuer is not trusted")
+	errUnknownKey      = errors.New("token key is unknown")
+)
+
+// fetchError is returned when the key set of an issuer can't be fetched, verification fails closed
+type fetchError struct {
+	issuer string
+	err    error
+}
+
+func (e *fetchError) Error() string {
+	return fmt.Sprintf("failed to fetch the key set of issuer %q: %s", e.issuer, e.err)
+}
+
+func (e *fetchError) Unwrap() error {
+	return e.err
+}
+
+// Issuer is a trusted token issuer
+type Issuer struct {
+	// URL is the issuer, it must be equal to the iss claim of the tokens
+	URL string
+	// JWKSURL is the url of the issuer key set, it is discovered from the
+	// issuer openid configuration when empty
+	JWKSURL string
+}
+
+// KeySet fetches and caches the key sets (JWKS) of the trusted issuers
+type KeySet struct {
+	client  *nethttp.Client
+	ttl     time.Duration
+	jitter  jitter.Factor
+	timeout time.Duration
+	clock   clock.PassiveClock
+	issuers map[string]*issuerKeys
+}
+
+// issuerKeys are the cached keys of an issuer, the lock is held while fetching
+// so that concurrent verifications wait for a single fetch
+type issuerKeys struct {
+	sync.Mutex
+	issuer  Issuer
+	jwksURL string
+	keys    map[string]publicKey
+	// expires is the time the fetched keys expire, the ttl moved by the jitter
+	expires time.Time
+	// attempted is the time of the last fetch, successful or not, err is the error of the last fetch
+	attempted time.Time
+	err       error
+}
+
+type publicKey struct {
+	alg string
+	key any
+}
+
+type KeySetOption func(*KeySet)
+
+// WithKeySetTTL sets the time fetched keys are used before being fetched again, it defaults to 5 minutes
+func WithKeySetTTL(ttl time.Duration) KeySetOption {
+	return func(k *KeySet) {
+		k.ttl = ttl
+	}
+}
+
+// WithKeySetJitter spreads the fetches of replicas started together, the ttl of every fetch is moved
+// by up to factor times the ttl, it defaults to no jitter
+func WithKeySetJitter(factor jitter.Factor) KeySetOption {
+	return func(k *KeySet) {
+		k.jitter = factor
+	}
+}
+
+// WithKeySetTimeout sets the maximum duration of a fetch, it defaults to 5 seconds
+func WithKeySetTimeout(timeout time.Duration) KeySetOption {
+	return func(k *KeySet) {
+		k.timeout = timeout
+	}
+}
+
+// WithKeySetClient sets the client used to fetch key sets
+func WithKeySetClient(client *nethttp.Client) KeySetOption {
+	return func(k *KeySet) {
+		k.client = client
+	}
+}
+
+// NewKeySet returns a key set verifying the tokens of the given issuers. Keys are fetched on first use,
+// then again when they expired or when a token refers to an unknown key id.
+// The key set must be created once and shared by the compiled policies so that they share the keys.
+func NewKeySet(issuers []Issuer, opts ...KeySetOption) (*KeySet, error) {
+	k := &KeySet{
+		client:  nethttp.DefaultClient,
+		ttl:     defaultKeySetTTL,
+		timeout: defaultKeySetTimeout,
+		clock:   clock.RealClock{},
+		issuers: map[string]*issuerKeys{},
+	}
+	for _, opt := range opts {
+		opt(k)
+	}
+	if err := k.jitter.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid key set jitter: %w", err)
+	}
+	for _, issuer := range issuers {
+		if err := validateURL(issuer.URL); err != nil {
+			return nil, fmt.Errorf("invalid issuer: %w", err)
+		}
+		if issuer.JWKSURL != "" {
+			if err := validateURL(issuer.JWKSURL); err != nil {
+				return nil, fmt.Errorf("invalid key set of issuer %q: %w", issuer.URL, err)
+			}
+		}
+		if _, ok := k.issuers[issuer.URL]; ok {
+			return nil, fmt.Errorf("duplicate issuer %q", issuer.URL)
+		}
+		k.issuers[issuer.URL] = &issuerKeys{issuer: issuer, jwksURL: issuer.JWKSURL}
+	}
+	return k, nil
+}
+
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid url %q: %w", rawURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url %q: expected an absolute http or https url", rawURL)
+	}
+	return nil
+}
+
+// keyfunc returns the key verifying the token, from the key set of the token issuer
+func (k *KeySet) keyfunc(token *jwt.Token) (any, error) {
+	claims, _ := token.Claims.(jwt.MapClaims)
+	issuer, _ := claims["iss"].(string)
+	keys, ok := k.issuers[issuer]
+	if !ok {
+		return nil, errUntrustedIssuer
+	}
+	kid, _ := token.Header["kid"].(string)
+	key, err := keys.get(k, kid)
+	if err != nil {
+		return nil, err
+	}
+	// the key type must match the signing method, hmac is never accepted so that
+	// a public key can't be used as a secret
+	switch token.Method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		if _, ok := key.key.(*rsa.PublicKey); !ok {
+			return nil, fmt.Errorf("key %q can't verify %s signatures", kid, token.Method.Alg())
+		}
+	case *jwt.SigningMethodECDSA:
+		if _, ok := key.key.(*ecdsa.PublicKey); !ok {
+			return nil, fmt.Errorf("key %q can't verify %s signatures", kid, token.Method.Alg())
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signing method: %s", token.Method.Alg())
+	}
+	if key.alg != "" && key.alg != token.Method.Alg() {
+		return nil, fmt.Errorf("key %q can't verify %s signatures", kid, token.Method.Alg())
+	}
+	return key.key, nil
+}
+
+// get returns the key with the given id, a token without key id can only be verified by a key set of a single key
+func (i *issuerKeys) get(k *KeySet, kid string) (publicKey, error) {
+	i.Lock()
+	defer i.Unlock()
+	now := k.clock.Now()
+	// fetch the keys the first time and once they expired, fail closed if they can't be fetched
+	if i.keys == nil || !now.Before(i.expires) {
+		// a failed fetch isn't retried before the minimum refresh interval, verifications fail fast meanwhile
+		if i.err != nil && now.Sub(i.attempted) < minRefreshInterval {
+			return publicKey{}, i.err
+		}
+		if err := i.refresh(k, now); err != nil {
+			return publicKey{}, err
+		}
+	}
+	key, ok := i.lookup(kid)
+	// the issuer may have rotated its keys
+	if !ok && now.Sub(i.attempted) >= minRefreshInterval {
+		if err := i.refresh(k, now); err != nil {
+			return publicKey{}, err
+		}
+		key, ok = i.lookup(kid)
+	}
+	if !ok {
+		return publicKey{}, errUnknownKey
+	}
+	return key, nil
+}
+
+func (i *issuerKeys) lookup(kid string) (publicKey, bool) {
+	if kid == "" {
+		if len(i.keys) != 1 {
+			return publicKey{}, false
+		}
+		for _, key := range i.keys {
+			return key, true
+		}
+	}
+	key, ok := i.keys[kid]
+	return key, ok
+}
+
+func (i *issuerKeys) refresh(k *KeySet, now time.Time) error {
+	i.attempted = now
+	i.err = i.fetch(k)
+	if i.err == nil {
+		i.expires = now.Add(k.jitter.Interval(k.ttl))
+	}
+	return i.err
+}
+
+// fetch replaces the keys with the key set of the issuer
+func (i *issuerKeys) fetch(k *KeySet) error {
+	// fetches are shared by the verifications waiting for the keys, they don't use the context of one of them
+	ctx, cancel := context.WithTimeout(context.Background(), k.timeout)
+	defer cancel()
+	if i.jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := k.fetch(ctx, strings.TrimSuffix(i.issuer.URL, "/")+discoveryPath, &discovery); err != nil {
+			return &fetchError{issuer: i.issuer.URL, err: err}
+		}
+		if err := validateURL(discovery.JWKSURI); err != nil {
+			return &fetchError{issuer: i.issuer.URL, err: fmt.Errorf("invalid jwks_uri: %w", err)}
+		}
+		i.jwksURL = discovery.JWKSURI
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := k.fetch(ctx, i.jwksURL, &set); err != nil {
+		return &fetchError{issuer: i.issuer.URL, err: err}
+	}
+	keys := map[string]publicKey{}
+	for _, jwk := range set.Keys {
+		// keys that are not meant for signatures, or of unsupported types, are ignored
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = publicKey{alg: jwk.Alg, key: key}
+	}
+	i.keys = keys
+	return nil
+}
+
+// fetch gets the json document at the url
+func (k *KeySet) fetch(ctx context.Context, rawURL string, value any) error {
+	request, err := nethttp.NewRequestWithContext(ctx, nethttp.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	response, err := k.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d from %s", response.StatusCode, rawURL)
+	}
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxKeySetSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxKeySetSize {
+		return fmt.Errorf("response from %s exceeds %d bytes", rawURL, maxKeySetSize)
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return fmt.Errorf("failed to parse json from %s: %w", rawURL, err)
+	}
+	return nil
+}
+
+// jwk is a json web key, see https://www.rfc-editor.org/rfc/rfc7517
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// rsa keys
+	N string `json:"n"`
+	E string `json:"e"`
+	// elliptic curve keys
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 2 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		var ecdhCurve ecdh.Curve
+		switch k.Crv {
+		case "P-256":
+			curve, ecdhCurve = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, ecdhCurve = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, ecdhCurve = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, fmt.Errorf("invalid coordinates size")
+		}
+		// the point must be on the curve
+		if _, err := ecdhCurve.NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,357 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	nethttp "net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/jitter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+// idp serves the discovery document and the key set of an issuer, keys can be rotated
+type idp struct {
+	*httptest.Server
+	lock    sync.Mutex
+	keys    []jwk
+	fetches atomic.Int32
+	failing atomic.Bool
+}
+
+func newIdp(t *testing.T) *idp {
+	t.Helper()
+	i := &idp{}
+	mux := nethttp.NewServeMux()
+	mux.HandleFunc(discoveryPath, func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"issuer": i.URL, "jwks_uri": i.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		i.fetches.Add(1)
+		if i.failing.Load() {
+			w.WriteHeader(nethttp.StatusInternalServerError)
+			return
+		}
+		i.lock.Lock()
+		defer i.lock.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string][]jwk{"keys": i.keys})
+	})
+	i.Server = httptest.NewServer(mux)
+	t.Cleanup(i.Close)
+	return i
+}
+
+func (i *idp) serve(keys ...jwk) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.keys = keys
+}
+
+func rsaJWK(t *testing.T, kid string) (*rsa.PrivateKey, jwk) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key, jwk{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(t *testing.T, kid string) (*ecdsa.PrivateKey, jwk) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return key, jwk{
+		Kty: "EC",
+		Kid: kid,
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func newTestKeySet(t *testing.T, issuers ...Issuer) (*KeySet, *testingclock.FakeClock) {
+	t.Helper()
+	keys, err := NewKeySet(issuers)
+	require.NoError(t, err)
+	clock := testingclock.NewFakeClock(time.Now())
+	keys.clock = clock
+	return keys, clock
+}
+
+func verifyToken(t *testing.T, keys *KeySet, token string) (Token, bool) {
+	t.Helper()
+	env, err := cel.NewEnv(KeySetLib(keys))
+	require.NoError(t, err)
+	out := verifyKeySet(env.CELTypeAdapter(), keys)(types.String(token))
+	if types.IsError(out) {
+		return Token{}, false
+	}
+	got, err := utils.ConvertToNative[Token](out)
+	require.NoError(t, err)
+	return got, true
+}
+
+func Test_verifyKeySet(t *testing.T) {
+	server := newIdp(t)
+	rsaKey, rsaPublic := rsaJWK(t, "rsa")
+	ecKey, ecPublic := ecJWK(t, "ec")
+	server.serve(rsaPublic, ecPublic)
+	other, _ := rsaJWK(t, "rsa")
+	keys, _ := newTestKeySet(t, Issuer{URL: server.URL, JWKSURL: server.URL + "/keys"})
+	tests := []struct {
+		name      string
+		token     string
+		wantErr   bool
+		wantValid bool
+	}{{
+		name:      "rs256",
+		token:     sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, jwt.MapClaims{"iss": server.URL, "sub": "alice"}),
+		wantValid: true,
+	}, {
+		name:      "es256",
+		token:     sign(t, jwt.SigningMethodES256, "ec", ecKey, jwt.MapClaims{"iss": server.URL, "sub": "alice"}),
+		wantValid: true,
+	}, {
+		name:  "wrong key",
+		token: sign(t, jwt.SigningMethodRS256, "rsa", other, jwt.MapClaims{"iss": server.URL}),
+	}, {
+		name:  "untrusted issuer",
+		token: sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, jwt.MapClaims{"iss": "https://attacker.example.com"}),
+	}, {
+		name:  "no issuer",
+		token: sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, jwt.MapClaims{}),
+	}, {
+		name:  "unknown key",
+		token: sign(t, jwt.SigningMethodRS256, "unknown", rsaKey, jwt.MapClaims{"iss": server.URL}),
+	}, {
+		name:  "no key id with several keys",
+		token: sign(t, jwt.SigningMethodRS256, "", rsaKey, jwt.MapClaims{"iss": server.URL}),
+	}, {
+		name:  "key of another type",
+		token: sign(t, jwt.SigningMethodES256, "rsa", ecKey, jwt.MapClaims{"iss": server.URL}),
+	}, {
+		name:  "key of another algorithm",
+		token: sign(t, jwt.SigningMethodRS512, "rsa", rsaKey, jwt.MapClaims{"iss": server.URL}),
+	}, {
+		// the public key must never be used as an hmac secret
+		name:  "hmac",
+		token: sign(t, jwt.SigningMethodHS256, "rsa", []byte(rsaPublic.N), jwt.MapClaims{"iss": server.URL}),
+	}, {
+		name:  "expired",
+		token: sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, jwt.MapClaims{"iss": server.URL, "exp": time.Now().Add(-time.Hour).Unix()}),
+	}, {
+		name:    "malformed",
+		token:   "not-a-token",
+		wantErr: true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := verifyToken(t, keys, tt.token)
+			assert.Equal(t, tt.wantErr, !ok)
+			assert.Equal(t, tt.wantValid, got.Valid)
+			if tt.wantValid {
+				assert.Equal(t, "alice", got.Claims.AsMap()["sub"])
+			}
+		})
+	}
+}
+
+func Test_verifyKeySet_rotation(t *testing.T) {
+	server := newIdp(t)
+	oldKey, oldPublic := rsaJWK(t, "old")
+	newKey, newPublic := rsaJWK(t, "new")
+	server.serve(oldPublic)
+	keys, clock := newTestKeySet(t, Issuer{URL: server.URL, JWKSURL: server.URL + "/keys"})
+	got, ok := verifyToken(t, keys, sign(t, jwt.SigningMethodRS256, "old", oldKey, jwt.MapClaims{"iss": server.URL}))
+	assert.True(t, ok)
+	assert.True(t, got.Valid)
+	assert.Equal(t, int32(1), server.fetches.Load())
+	// the issuer rotates its keys, a token signed with the new key triggers a refresh
+	server.serve(newPublic)
+	newToken := sign(t, jwt.SigningMethodRS256, "new", newKey, jwt.MapClaims{"iss": server.URL})
+	clock.Step(minRefreshInterval)
+	got, ok = verifyToken(t, keys, newToken)
+	assert.True(t, ok)
+	assert.True(t, got.Valid)
+	assert.Equal(t, int32(2), server.fetches.Load())
+	// unknown key ids don't refresh the keys more than once per interval
+	unknown := sign(t, jwt.SigningMethodRS256, "unknown", newKey, jwt.MapClaims{"iss": server.URL})
+	for range 5 {
+		got, ok = verifyToken(t, keys, unknown)
+		assert.True(t, ok)
+		assert.False(t, got.Valid)
+	}
+	assert.Equal(t, int32(2), server.fetches.Load())
+	// the old key is gone
+	got, ok = verifyToken(t, keys, sign(t, jwt.SigningMethodRS256, "old", oldKey, jwt.MapClaims{"iss": server.URL}))
+	assert.True(t, ok)
+	assert.False(t, got.Valid)
+	// keys are fetched again once expired
+	clock.Step(defaultKeySetTTL)
+	got, ok = verifyToken(t, keys, newToken)
+	assert.True(t, ok)
+	assert.True(t, got.Valid)
+	assert.Equal(t, int32(3), server.fetches.Load())
+}
+
+func Test_verifyKeySet_fetchFailure(t *testing.T) {
+	server := newIdp(t)
+	key, public := rsaJWK(t, "rsa")
+	server.serve(public)
+	server.failing.Store(true)
+	keys, clock := newTestKeySet(t, Issuer{URL: server.URL, JWKSURL: server.URL + "/keys"})
+	token := sign(t, jwt.SigningMethodRS256, "rsa", key, jwt.MapClaims{"iss": server.URL})
+	// verification fails closed with an error when the keys can't be fetched
+	_, ok := verifyToken(t, keys, token)
+	assert.False(t, ok)
+	// the failed fetch isn't retried immediately
+	_, ok = verifyToken(t, keys, token)
+	assert.False(t, ok)
+	assert.Equal(t, int32(1), server.fetches.Load())
+	// the keys are fetched once the issuer recovered
+	server.failing.Store(false)
+	clock.Step(minRefreshInterval)
+	got, ok := verifyToken(t, keys, token)
+	assert.True(t, ok)
+	assert.True(t, got.Valid)
+	// expired keys are not used when they can't be fetched again
+	server.failing.Store(true)
+	clock.Step(defaultKeySetTTL)
+	_, ok = verifyToken(t, keys, token)
+	assert.False(t, ok)
+}
+
+func Test_verifyKeySet_jitter(t *testing.T) {
+	server := newIdp(t)
+	key, public := rsaJWK(t, "rsa")
+	server.serve(public)
+	keys, err := NewKeySet([]Issuer{{URL: server.URL, JWKSURL: server.URL + "/keys"}}, WithKeySetJitter(0.5))
+	require.NoError(t, err)
+	clock := testingclock.NewFakeClock(time.Now())
+	keys.clock = clock
+	token := sign(t, jwt.SigningMethodRS256, "rsa", key, jwt.MapClaims{"iss": server.URL})
+	_, ok := verifyToken(t, keys, token)
+	assert.True(t, ok)
+	// the keys don't expire before the lower bound of the ttl
+	clock.Step(defaultKeySetTTL/2 - time.Second)
+	_, ok = verifyToken(t, keys, token)
+	assert.True(t, ok)
+	assert.Equal(t, int32(1), server.fetches.Load())
+	// and are fetched again after the upper bound
+	clock.Step(defaultKeySetTTL + time.Second)
+	_, ok = verifyToken(t, keys, token)
+	assert.True(t, ok)
+	assert.Equal(t, int32(2), server.fetches.Load())
+}
+
+func Test_verifyKeySet_discovery(t *testing.T) {
+	first := newIdp(t)
+	second := newIdp(t)
+	firstKey, firstPublic := rsaJWK(t, "rsa")
+	secondKey, secondPublic := ecJWK(t, "ec")
+	first.serve(firstPublic)
+	second.serve(secondPublic)
+	// the key set of an issuer is discovered from its openid configuration
+	keys, _ := newTestKeySet(t, Issuer{URL: first.URL}, Issuer{URL: second.URL + "/"})
+	got, ok := verifyToken(t, keys, sign(t, jwt.SigningMethodRS256, "rsa", firstKey, jwt.MapClaims{"iss": first.URL}))
+	assert.True(t, ok)
+	assert.True(t, got.Valid)
+	got, ok = verifyToken(t, keys, sign(t, jwt.SigningMethodES256, "", secondKey, jwt.MapClaims{"iss": second.URL + "/"}))
+	assert.True(t, ok)
+	assert.True(t, got.Valid)
+	// a token can't be verified with the keys of another trusted issuer
+	got, ok = verifyToken(t, keys, sign(t, jwt.SigningMethodES256, "ec", secondKey, jwt.MapClaims{"iss": first.URL}))
+	assert.True(t, ok)
+	assert.False(t, got.Valid)
+}
+
+func TestNewKeySet(t *testing.T) {
+	tests := []struct {
+		name    string
+		issuers []Issuer
+		jitter  jitter.Factor
+		wantErr bool
+	}{{
+		name:    "valid",
+		issuers: []Issuer{{URL: "https://idp.example.com"}, {URL: "https://other.example.com", JWKSURL: "https://other.example.com/keys"}},
+	}, {
+		name:    "relative issuer",
+		issuers: []Issuer{{URL: "idp.example.com"}},
+		wantErr: true,
+	}, {
+		name:    "invalid key set url",
+		issuers: []Issuer{{URL: "https://idp.example.com", JWKSURL: "file:///keys"}},
+		wantErr: true,
+	}, {
+		name:    "duplicate issuer",
+		issuers: []Issuer{{URL: "https://idp.example.com"}, {URL: "https://idp.example.com"}},
+		wantErr: true,
+	}, {
+		name:    "invalid jitter",
+		issuers: []Issuer{{URL: "https://idp.example.com"}},
+		jitter:  1,
+		wantErr: true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewKeySet(tt.issuers, WithKeySetJitter(tt.jitter))
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestKeySetLib(t *testing.T) {
+	server := newIdp(t)
+	key, public := rsaJWK(t, "rsa")
+	server.serve(public)
+	keys, _ := newTestKeySet(t, Issuer{URL: server.URL})
+	env, err := cel.NewEnv(Lib(), KeySetLib(keys), cel.Variable("token", types.StringType))
+	require.NoError(t, err)
+	ast, issues := env.Compile(`jwt.Verify(token).Valid && jwt.Verify(token).Claims.sub == "alice"`)
+	require.NoError(t, issues.Err())
+	program, err := env.Program(ast)
+	require.NoError(t, err)
+	out, _, err := program.Eval(map[string]any{"token": sign(t, jwt.SigningMethodRS256, "rsa", key, jwt.MapClaims{"iss": server.URL, "sub": "alice"})})
+	require.NoError(t, err)
+	assert.Equal(t, types.True, out)
+	// the overload is not available without key set
+	env, err = cel.NewEnv(Lib(), cel.Variable("token", types.StringType))
+	require.NoError(t, err)
+	_, issues = env.Compile(`jwt.Verify(token)`)
+	assert.Error(t, issues.Err())
+}
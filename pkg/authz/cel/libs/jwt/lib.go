@@ -1,6 +1,10 @@
 package jwt
 
 import (
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"reflect"
 
 	"github.com/golang-jwt/jwt"
@@ -8,10 +12,18 @@ import (
 	"github.com/google/cel-go/common/types"
 	"github.com/google/cel-go/common/types/ref"
 	"github.com/google/cel-go/ext"
+	"github.com/google/cel-go/interpreter"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
 	"google.golang.org/protobuf/types/known/structpb"
 )
 
-type lib struct{}
+// errSigningMethod is returned when the key can't verify the token signing method
+var errSigningMethod = errors.New("key can't verify the signing method")
+
+type lib struct {
+	// memoized are the overloads sharing their results between the expressions evaluated for a request
+	memoized map[string]func(...ref.Val) ref.Val
+}
 
 func Lib() cel.EnvOption {
 	// create the cel lib env option
@@ -31,17 +43,30 @@ func (c *lib) CompileOptions() []cel.EnvOption {
 	}
 }
 
-func (*lib) ProgramOptions() []cel.ProgramOption {
-	return []cel.ProgramOption{}
+func (c *lib) ProgramOptions() []cel.ProgramOption {
+	return []cel.ProgramOption{
+		// policies decoding the same token share the decoded token
+		cel.CustomDecorator(c.decorate),
+	}
 }
 
-func (*lib) extendEnv(env *cel.Env) (*cel.Env, error) {
+func (c *lib) extendEnv(env *cel.Env) (*cel.Env, error) {
 	// get env type adapter
 	adapter := env.CELTypeAdapter()
+	decodeUnverified, decode, verify := decodeUnverified(adapter), decode(adapter), verify(adapter)
+	c.memoized = map[string]func(...ref.Val) ref.Val{
+		"decode_string":        func(args ...ref.Val) ref.Val { return decodeUnverified(args[0]) },
+		"decode_string_string": func(args ...ref.Val) ref.Val { return decode(args[0], args[1]) },
+		"verify_string_string": func(args ...ref.Val) ref.Val { return verify(args[0], args[1]) },
+	}
 	// build our function overloads
 	libraryDecls := map[string][]cel.FunctionOpt{
 		"jwt.Decode": {
-			cel.Overload("decode_string_string", []*cel.Type{types.StringType, types.StringType}, TokenType, cel.BinaryBinding(decode(adapter))),
+			cel.Overload("decode_string", []*cel.Type{types.StringType}, TokenType, cel.UnaryBinding(decodeUnverified)),
+			cel.Overload("decode_string_string", []*cel.Type{types.StringType, types.StringType}, TokenType, cel.BinaryBinding(decode)),
+		},
+		"jwt.Verify": {
+			cel.Overload("verify_string_string", []*cel.Type{types.StringType, types.StringType}, TokenType, cel.BinaryBinding(verify)),
 		},
 	}
 	// create env options corresponding to our function overloads
@@ -53,6 +78,18 @@ func (*lib) extendEnv(env *cel.Env) (*cel.Env, error) {
 	return env.Extend(options...)
 }
 
+// decorate replaces calls to the memoized overloads with calls sharing their results
+func (c *lib) decorate(i interpreter.Interpretable) (interpreter.Interpretable, error) {
+	call, ok := i.(interpreter.InterpretableCall)
+	if !ok {
+		return i, nil
+	}
+	if function, ok := c.memoized[call.OverloadID()]; ok {
+		return utils.Memoized(call, function), nil
+	}
+	return i, nil
+}
+
 func decode(adapter types.Adapter) func(token ref.Val, key ref.Val) ref.Val {
 	return func(token ref.Val, key ref.Val) ref.Val {
 		t, ok := token.(types.String)
@@ -70,20 +107,167 @@ func decode(adapter types.Adapter) func(token ref.Val, key ref.Val) ref.Val {
 		if err != nil {
 			return adapter.NativeToValue(nil)
 		}
-		header, err := structpb.NewStruct(parsed.Header)
+		return newToken(adapter, parsed, claimsMap)
+	}
+}
+
+func decodeUnverified(adapter types.Adapter) func(token ref.Val) ref.Val {
+	return func(token ref.Val) ref.Val {
+		t, ok := token.(types.String)
+		if !ok {
+			return types.MaybeNoSuchOverloadErr(token)
+		}
+		claimsMap := jwt.MapClaims{}
+		parsed, _, err := new(jwt.Parser).ParseUnverified(string(t), claimsMap)
+		if err != nil {
+			return types.NewErr("failed to decode token: %s", err)
+		}
+		return newToken(adapter, parsed, claimsMap)
+	}
+}
+
+func verify(adapter types.Adapter) func(token ref.Val, key ref.Val) ref.Val {
+	return func(token ref.Val, key ref.Val) ref.Val {
+		t, ok := token.(types.String)
+		if !ok {
+			return types.MaybeNoSuchOverloadErr(token)
+		}
+		k, ok := key.(types.String)
+		if !ok {
+			return types.MaybeNoSuchOverloadErr(key)
+		}
+		// the key decides the signing method and not the token, a pem public key only verifies rsa
+		// signatures and anything else is an hmac secret so that a public key can't be used as a secret
+		var verifyKey any = []byte(k)
+		if block, _ := pem.Decode([]byte(k)); block != nil {
+			publicKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(k))
+			if err != nil {
+				return types.NewErr("failed to verify token: %s", err)
+			}
+			verifyKey = publicKey
+		}
+		claimsMap := jwt.MapClaims{}
+		parsed, err := jwt.ParseWithClaims(string(t), claimsMap, func(token *jwt.Token) (any, error) {
+			switch token.Method.(type) {
+			case *jwt.SigningMethodHMAC:
+				if _, ok := verifyKey.([]byte); ok {
+					return verifyKey, nil
+				}
+			case *jwt.SigningMethodRSA:
+				if _, ok := verifyKey.(*rsa.PublicKey); ok {
+					return verifyKey, nil
+				}
+			default:
+				return nil, fmt.Errorf("unsupported signing method: %s", token.Method.Alg())
+			}
+			return nil, fmt.Errorf("%w: %s", errSigningMethod, token.Method.Alg())
+		})
+		// a token that fails validation or is signed with a method the key can't verify is returned
+		// as invalid, malformed tokens and unsupported signing methods are errors
 		if err != nil {
-			return types.WrapErr(err)
+			var validationErr *jwt.ValidationError
+			if !errors.As(err, &validationErr) || validationErr.Errors&jwt.ValidationErrorMalformed != 0 {
+				return types.NewErr("failed to verify token: %s", err)
+			}
+			if validationErr.Errors&jwt.ValidationErrorUnverifiable != 0 && !errors.Is(validationErr.Inner, errSigningMethod) {
+				return types.NewErr("failed to verify token: %s", err)
+			}
 		}
-		claims, err := structpb.NewStruct(claimsMap)
+		return newToken(adapter, parsed, claimsMap)
+	}
+}
+
+func newToken(adapter types.Adapter, parsed *jwt.Token, claimsMap jwt.MapClaims) ref.Val {
+	header, err := structpb.NewStruct(parsed.Header)
+	if err != nil {
+		return types.WrapErr(err)
+	}
+	claims, err := structpb.NewStruct(claimsMap)
+	if err != nil {
+		return types.WrapErr(err)
+	}
+	return adapter.NativeToValue(
+		Token{
+			Header: header,
+			Claims: claims,
+			Valid:  parsed.Valid,
+		},
+	)
+}
+
+type keySetLib struct {
+	keys   *KeySet
+	verify func(ref.Val) ref.Val
+}
+
+// KeySetLib registers the jwt.Verify overload verifying tokens with the keys of their trusted issuer.
+// The key set must be shared by the compiled policies so that they share the fetched keys.
+func KeySetLib(keys *KeySet) cel.EnvOption {
+	// create the cel lib env option
+	return cel.Lib(&keySetLib{keys: keys})
+}
+
+func (*keySetLib) LibraryName() string {
+	return "kyverno.jwt.keyset"
+}
+
+func (c *keySetLib) CompileOptions() []cel.EnvOption {
+	return []cel.EnvOption{
+		// the token type is registered by the jwt lib
+		Lib(),
+		// extend environment with function overloads
+		c.extendEnv,
+	}
+}
+
+func (c *keySetLib) ProgramOptions() []cel.ProgramOption {
+	return []cel.ProgramOption{
+		// policies verifying the same token share the verified token
+		cel.CustomDecorator(c.decorate),
+	}
+}
+
+func (c *keySetLib) extendEnv(env *cel.Env) (*cel.Env, error) {
+	// get env type adapter
+	adapter := env.CELTypeAdapter()
+	c.verify = verifyKeySet(adapter, c.keys)
+	// extend environment with our function overloads
+	return env.Extend(
+		cel.Function("jwt.Verify",
+			cel.Overload("verify_string", []*cel.Type{types.StringType}, TokenType, cel.UnaryBinding(c.verify)),
+		),
+	)
+}
+
+// decorate replaces calls to the verify overload with calls sharing their results
+func (c *keySetLib) decorate(i interpreter.Interpretable) (interpreter.Interpretable, error) {
+	call, ok := i.(interpreter.InterpretableCall)
+	if !ok || call.OverloadID() != "verify_string" {
+		return i, nil
+	}
+	return utils.Memoized(call, func(args ...ref.Val) ref.Val { return c.verify(args[0]) }), nil
+}
+
+func verifyKeySet(adapter types.Adapter, keys *KeySet) func(token ref.Val) ref.Val {
+	return func(token ref.Val) ref.Val {
+		t, ok := token.(types.String)
+		if !ok {
+			return types.MaybeNoSuchOverloadErr(token)
+		}
+		claimsMap := jwt.MapClaims{}
+		parsed, err := jwt.ParseWithClaims(string(t), claimsMap, keys.keyfunc)
+		// a token that fails validation is returned as invalid, including tokens of untrusted
+		// issuers or unknown keys, malformed tokens and key sets that can't be fetched are errors
 		if err != nil {
-			return types.WrapErr(err)
+			var validationErr *jwt.ValidationError
+			if !errors.As(err, &validationErr) || validationErr.Errors&jwt.ValidationErrorMalformed != 0 || parsed == nil {
+				return types.NewErr("failed to verify token: %s", err)
+			}
+			var fetchErr *fetchError
+			if errors.As(validationErr.Inner, &fetchErr) {
+				return types.NewErr("failed to verify token: %s", fetchErr)
+			}
 		}
-		return adapter.NativeToValue(
-			Token{
-				Header: header,
-				Claims: claims,
-				Valid:  parsed.Valid,
-			},
-		)
+		return newToken(adapter, parsed, claimsMap)
 	}
 }
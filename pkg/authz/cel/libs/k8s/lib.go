@@ -0,0 +1,99 @@
+package k8s
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// getTimeout bounds the time spent reading a resource, the first read of a kind waits for its cache to sync
+const getTimeout = 5 * time.Second
+
+type lib struct {
+	reader client.Reader
+}
+
+// Lib returns the k8s library, resources are read from the given reader
+// which is expected to be backed by a cache
+func Lib(reader client.Reader) cel.EnvOption {
+	// create the cel lib env option
+	return cel.Lib(&lib{
+		reader: reader,
+	})
+}
+
+func (*lib) LibraryName() string {
+	return "kyverno.k8s"
+}
+
+func (c *lib) CompileOptions() []cel.EnvOption {
+	return []cel.EnvOption{
+		// extend environment with function overloads
+		c.extendEnv,
+	}
+}
+
+func (*lib) ProgramOptions() []cel.ProgramOption {
+	return []cel.ProgramOption{}
+}
+
+func (c *lib) extendEnv(env *cel.Env) (*cel.Env, error) {
+	// get env type adapter
+	adapter := env.CELTypeAdapter()
+	// build our function overloads
+	libraryDecls := map[string][]cel.FunctionOpt{
+		"k8s.Get": {
+			cel.Overload(
+				"k8s_get_string_string_string_string",
+				[]*cel.Type{types.StringType, types.StringType, types.StringType, types.StringType},
+				types.DynType,
+				cel.FunctionBinding(get(adapter, c.reader)),
+			),
+		},
+	}
+	// create env options corresponding to our function overloads
+	options := []cel.EnvOption{}
+	for name, overloads := range libraryDecls {
+		options = append(options, cel.Function(name, overloads...))
+	}
+	// extend environment with our function overloads
+	return env.Extend(options...)
+}
+
+// get returns the resource as a map, or null if it doesn't exist
+func get(adapter types.Adapter, reader client.Reader) func(args ...ref.Val) ref.Val {
+	return func(args ...ref.Val) ref.Val {
+		var values [4]string
+		for i, arg := range args {
+			value, ok := arg.(types.String)
+			if !ok {
+				return types.MaybeNoSuchOverloadErr(arg)
+			}
+			values[i] = string(value)
+		}
+		apiVersion, kind, namespace, name := values[0], values[1], values[2], values[3]
+		gv, err := schema.ParseGroupVersion(apiVersion)
+		if err != nil {
+			return types.NewErr("invalid api version %q: %s", apiVersion, err)
+		}
+		var obj unstructured.Unstructured
+		obj.SetGroupVersionKind(gv.WithKind(kind))
+		ctx, cancel := context.WithTimeout(context.Background(), getTimeout)
+		defer cancel()
+		if err := reader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &obj); err != nil {
+			// a missing resource is not an error, the policy decides what it means
+			if errors.IsNotFound(err) {
+				return types.NullValue
+			}
+			return types.NewErr("failed to get %s %s/%s: %s", kind, namespace, name, err)
+		}
+		return adapter.NativeToValue(obj.Object)
+	}
+}
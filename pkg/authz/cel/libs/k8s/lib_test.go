@@ -0,0 +1,74 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/google/cel-go/cel"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_get(t *testing.T) {
+	reader := fake.NewClientBuilder().WithObjects(
+		&corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "api",
+				Annotations: map[string]string{
+					"envoy.kyverno.io/allowed": "true",
+				},
+			},
+		},
+		&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "default",
+				Labels: map[string]string{"team": "platform"},
+			},
+		},
+	).Build()
+	tests := []struct {
+		name       string
+		expression string
+		want       any
+		wantErr    bool
+	}{{
+		name:       "namespaced resource",
+		expression: `k8s.Get('v1', 'ServiceAccount', 'default', 'api').metadata.annotations['envoy.kyverno.io/allowed'] == 'true'`,
+		want:       true,
+	}, {
+		name:       "cluster resource",
+		expression: `k8s.Get('v1', 'Namespace', '', 'default').metadata.labels.team`,
+		want:       "platform",
+	}, {
+		name:       "not found",
+		expression: `k8s.Get('v1', 'ServiceAccount', 'default', 'missing') == null`,
+		want:       true,
+	}, {
+		name:       "field of a missing resource",
+		expression: `k8s.Get('v1', 'ServiceAccount', 'default', 'missing').metadata`,
+		wantErr:    true,
+	}, {
+		name:       "invalid api version",
+		expression: `k8s.Get('a/b/c', 'ServiceAccount', 'default', 'api')`,
+		wantErr:    true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := cel.NewEnv(Lib(reader))
+			assert.NoError(t, err)
+			ast, issues := env.Compile(tt.expression)
+			assert.Nil(t, issues)
+			prog, err := env.Program(ast)
+			assert.NoError(t, err)
+			out, _, err := prog.Eval(map[string]any{})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, out.Value())
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package spiffe
+
+import (
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
+)
+
+const scheme = "spiffe://"
+
+type lib struct{}
+
+func Lib() cel.EnvOption {
+	// create the cel lib env option
+	return cel.Lib(&lib{})
+}
+
+func (*lib) LibraryName() string {
+	return "kyverno.spiffe"
+}
+
+func (c *lib) CompileOptions() []cel.EnvOption {
+	return []cel.EnvOption{
+		// extend environment with function overloads
+		c.extendEnv,
+	}
+}
+
+func (*lib) ProgramOptions() []cel.ProgramOption {
+	return []cel.ProgramOption{}
+}
+
+func (*lib) extendEnv(env *cel.Env) (*cel.Env, error) {
+	// build our function overloads
+	libraryDecls := map[string][]cel.FunctionOpt{
+		"spiffe.IsValid": {
+			cel.Overload("spiffe_is_valid_string", []*cel.Type{types.StringType}, types.BoolType, cel.UnaryBinding(isValid)),
+		},
+		"spiffe.TrustDomain": {
+			cel.Overload("spiffe_trust_domain_string", []*cel.Type{types.StringType}, types.StringType, cel.UnaryBinding(trustDomain)),
+		},
+		"spiffe.Path": {
+			cel.Overload("spiffe_path_string", []*cel.Type{types.StringType}, types.StringType, cel.UnaryBinding(path)),
+		},
+	}
+	// create env options corresponding to our function overloads
+	options := []cel.EnvOption{}
+	for name, overloads := range libraryDecls {
+		options = append(options, cel.Function(name, overloads...))
+	}
+	// extend environment with our function overloads
+	return env.Extend(options...)
+}
+
+// parse splits a SPIFFE id into its trust domain and path, it returns false if the id is not a valid SPIFFE id.
+// See https://github.com/spiffe/spiffe/blob/main/standards/SPIFFE-ID.md for the format.
+func parse(id string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(id, scheme)
+	if !ok {
+		return "", "", false
+	}
+	domain, path, _ := strings.Cut(rest, "/")
+	if domain == "" || strings.IndexFunc(domain, func(r rune) bool { return !isTrustDomainChar(r) }) != -1 {
+		return "", "", false
+	}
+	// the path is optional, it has no trailing slash and no empty, `.` or `..` segments
+	if path == "" {
+		if strings.HasSuffix(rest, "/") {
+			return "", "", false
+		}
+		return domain, "", true
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.IndexFunc(segment, func(r rune) bool { return !isPathChar(r) }) != -1 {
+			return "", "", false
+		}
+	}
+	return domain, "/" + path, true
+}
+
+func isTrustDomainChar(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '.' || r == '-' || r == '_'
+}
+
+func isPathChar(r rune) bool {
+	return isTrustDomainChar(r) || (r >= 'A' && r <= 'Z')
+}
+
+func isValid(id ref.Val) ref.Val {
+	if id, err := utils.ConvertToNative[string](id); err != nil {
+		return types.WrapErr(err)
+	} else {
+		_, _, ok := parse(id)
+		return types.Bool(ok)
+	}
+}
+
+func trustDomain(id ref.Val) ref.Val {
+	if id, err := utils.ConvertToNative[string](id); err != nil {
+		return types.WrapErr(err)
+	} else {
+		domain, _, _ := parse(id)
+		return types.String(domain)
+	}
+}
+
+func path(id ref.Val) ref.Val {
+	if id, err := utils.ConvertToNative[string](id); err != nil {
+		return types.WrapErr(err)
+	} else {
+		_, path, _ := parse(id)
+		return types.String(path)
+	}
+}
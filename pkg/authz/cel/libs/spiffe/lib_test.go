@@ -0,0 +1,131 @@
+package spiffe
+
+import (
+	"testing"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+	"github.com/stretchr/testify/assert"
+)
+
+func eval(t *testing.T, expression string) any {
+	t.Helper()
+	env, err := cel.NewEnv(ext.Strings(), Lib())
+	assert.NoError(t, err)
+	ast, issues := env.Compile(expression)
+	assert.Nil(t, issues)
+	prog, err := env.Program(ast)
+	assert.NoError(t, err)
+	out, _, err := prog.Eval(map[string]any{})
+	assert.NoError(t, err)
+	return out.Value()
+}
+
+func Test_parse(t *testing.T) {
+	tests := []struct {
+		name       string
+		id         string
+		wantDomain string
+		wantPath   string
+		wantOk     bool
+	}{{
+		name:       "workload",
+		id:         "spiffe://cluster.local/ns/default/sa/frontend",
+		wantDomain: "cluster.local",
+		wantPath:   "/ns/default/sa/frontend",
+		wantOk:     true,
+	}, {
+		name:       "trust domain only",
+		id:         "spiffe://example.org",
+		wantDomain: "example.org",
+		wantOk:     true,
+	}, {
+		name:       "path characters",
+		id:         "spiffe://example.org/Payments_v2/web-1.0",
+		wantDomain: "example.org",
+		wantPath:   "/Payments_v2/web-1.0",
+		wantOk:     true,
+	}, {
+		name: "empty",
+		id:   "",
+	}, {
+		name: "not a spiffe id",
+		id:   "CN=frontend,O=example",
+	}, {
+		name: "other scheme",
+		id:   "https://example.org/ns/default",
+	}, {
+		name: "empty trust domain",
+		id:   "spiffe:///ns/default",
+	}, {
+		name: "uppercase trust domain",
+		id:   "spiffe://Example.org/ns/default",
+	}, {
+		name: "port",
+		id:   "spiffe://example.org:8080/ns/default",
+	}, {
+		name: "trailing slash",
+		id:   "spiffe://example.org/ns/default/",
+	}, {
+		name: "trailing slash without path",
+		id:   "spiffe://example.org/",
+	}, {
+		name: "empty segment",
+		id:   "spiffe://example.org/ns//default",
+	}, {
+		name: "dot segment",
+		id:   "spiffe://example.org/ns/../default",
+	}, {
+		name: "query",
+		id:   "spiffe://example.org/ns/default?x=1",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domain, path, ok := parse(tt.id)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.wantDomain, domain)
+			assert.Equal(t, tt.wantPath, path)
+		})
+	}
+}
+
+func Test_functions(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		want       any
+	}{{
+		name:       "is valid",
+		expression: `spiffe.IsValid("spiffe://cluster.local/ns/default/sa/frontend")`,
+		want:       true,
+	}, {
+		name:       "is not valid",
+		expression: `spiffe.IsValid("frontend")`,
+		want:       false,
+	}, {
+		name:       "trust domain",
+		expression: `spiffe.TrustDomain("spiffe://cluster.local/ns/default/sa/frontend")`,
+		want:       "cluster.local",
+	}, {
+		name:       "trust domain of an invalid id",
+		expression: `spiffe.TrustDomain("")`,
+		want:       "",
+	}, {
+		name:       "path",
+		expression: `spiffe.Path("spiffe://cluster.local/ns/default/sa/frontend")`,
+		want:       "/ns/default/sa/frontend",
+	}, {
+		name:       "path segments",
+		expression: `spiffe.Path("spiffe://cluster.local/ns/default/sa/frontend").split("/")[2]`,
+		want:       "default",
+	}, {
+		name:       "path of an invalid id",
+		expression: `spiffe.Path("spiffe://cluster.local/ns/")`,
+		want:       "",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, eval(t, tt.expression))
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"context"
+
+	"github.com/google/cel-go/interpreter"
+)
+
+// EvaluationContextKey is the activation entry holding the evaluation context, it is not a valid CEL identifier
+// and can't be referenced by expressions. Functions calling external services read it to stop when
+// the evaluation context is done.
+const EvaluationContextKey = "#context"
+
+// ContextFrom returns the evaluation context stored in the activation, or a background context when missing
+func ContextFrom(activation interpreter.Activation) context.Context {
+	if value, ok := activation.ResolveName(EvaluationContextKey); ok {
+		if ctx, ok := value.(context.Context); ok {
+			return ctx
+		}
+	}
+	return context.Background()
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter"
+)
+
+type memoKey struct{}
+
+// Memo shares the results of function calls between the expressions evaluated with a context, the policies
+// evaluating the same request compute an expensive value (a parsed token or document) once.
+// Only the results of functions returning the same value for the same arguments can be shared.
+type Memo struct {
+	lock   sync.Mutex
+	values map[string]ref.Val
+}
+
+// WithMemo returns a context sharing the results of memoized function calls between the expressions evaluated with it
+func WithMemo(ctx context.Context) (context.Context, *Memo) {
+	memo := &Memo{values: map[string]ref.Val{}}
+	return context.WithValue(ctx, memoKey{}, memo), memo
+}
+
+// Len returns the number of results stored so far, a nil Memo returns zero
+func (m *Memo) Len() int {
+	if m == nil {
+		return 0
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.values)
+}
+
+// get returns the stored result or computes it, concurrent evaluations computing the same result keep the first one
+func (m *Memo) get(key string, compute func() ref.Val) ref.Val {
+	m.lock.Lock()
+	value, ok := m.values[key]
+	m.lock.Unlock()
+	if ok {
+		return value
+	}
+	value = compute()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if stored, ok := m.values[key]; ok {
+		return stored
+	}
+	m.values[key] = value
+	return value
+}
+
+// MemoizedValue returns the value stored under the key in the memo of the context or computes it, the value
+// must only depend on the request. Contexts without a memo compute the value every time.
+func MemoizedValue(ctx context.Context, key string, compute func() ref.Val) ref.Val {
+	memo, _ := ctx.Value(memoKey{}).(*Memo)
+	if memo == nil {
+		return compute()
+	}
+	return memo.get(key, compute)
+}
+
+// Memoized returns a call sharing its results through the memo of the evaluation context, the function must
+// return the same value for the same arguments. Calls evaluated without a memo call the function every time.
+func Memoized(call interpreter.InterpretableCall, function func(...ref.Val) ref.Val) interpreter.Interpretable {
+	return &memoizedCall{InterpretableCall: call, function: function}
+}
+
+type memoizedCall struct {
+	interpreter.InterpretableCall
+	function func(...ref.Val) ref.Val
+}
+
+func (c *memoizedCall) Eval(activation interpreter.Activation) ref.Val {
+	args := make([]ref.Val, 0, len(c.Args()))
+	for _, arg := range c.Args() {
+		value := arg.Eval(activation)
+		if types.IsUnknownOrError(value) {
+			return value
+		}
+		args = append(args, value)
+	}
+	memo, _ := ContextFrom(activation).Value(memoKey{}).(*Memo)
+	key, ok := callKey(c.OverloadID(), args)
+	if memo == nil || !ok {
+		return c.function(args...)
+	}
+	return memo.get(key, func() ref.Val {
+		return c.function(args...)
+	})
+}
+
+// callKey identifies a call from its overload and arguments, only string and bytes arguments are supported.
+// Arguments are prefixed with their type and length, the encoding is unambiguous.
+func callKey(overload string, args []ref.Val) (string, bool) {
+	var key strings.Builder
+	key.WriteString(overload)
+	for _, arg := range args {
+		var kind, value string
+		switch arg := arg.(type) {
+		case types.String:
+			kind, value = "s", string(arg)
+		case types.Bytes:
+			kind, value = "b", string(arg)
+		default:
+			return "", false
+		}
+		key.WriteString("\x00" + kind + strconv.Itoa(len(value)) + ":" + value)
+	}
+	return key.String(), true
+}
@@ -0,0 +1,32 @@
+package authz
+
+import (
+	"context"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// requestIDHeader is the header envoy propagates the request id in
+const requestIDHeader = "x-request-id"
+
+// withDecisionID returns a context carrying the id of the decision taken for the check, the envoy request id is
+// reused when envoy sent one so that the decision can be correlated with the envoy access logs
+func withDecisionID(ctx context.Context, r *authv3.CheckRequest) (context.Context, string) {
+	http := r.GetAttributes().GetRequest().GetHttp()
+	id := http.GetId()
+	if id == "" {
+		id = http.GetHeaders()[requestIDHeader]
+	}
+	if id == "" {
+		id = string(uuid.NewUUID())
+	}
+	return core.WithDecisionID(ctx, id), id
+}
+
+// DecisionID returns the id of the decision being taken, middlewares use it to correlate the decision with its
+// log record and trace. It is empty outside of a check.
+func DecisionID(ctx context.Context) string {
+	return core.DecisionID(ctx)
+}
@@ -0,0 +1,128 @@
+package authz
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/decisionlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+)
+
+// recordSink keeps the decision records written by a decision logger
+type recordSink struct {
+	sync.Mutex
+	records []decisionlog.Record
+}
+
+func (s *recordSink) Name() string {
+	return "records"
+}
+
+func (s *recordSink) Write(record decisionlog.Record) error {
+	s.Lock()
+	defer s.Unlock()
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *recordSink) Close() error {
+	return nil
+}
+
+func Test_service_Check_decisionID(t *testing.T) {
+	tests := []struct {
+		name      string
+		requestID string
+		headers   map[string]string
+		// want is the expected decision id, a generated id is expected when empty
+		want string
+	}{{
+		name:      "envoy request id",
+		requestID: "7849271920472734638",
+		headers:   map[string]string{"x-request-id": "ignored"},
+		want:      "7849271920472734638",
+	}, {
+		name:    "request id header",
+		headers: map[string]string{"x-request-id": "d2c9e3a4"},
+		want:    "d2c9e3a4",
+	}, {
+		name: "generated",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			sink := &recordSink{}
+			logger := decisionlog.NewLogger(nil, nil, nil, decisionlog.DefaultBufferSize, nil, sink)
+			s := &service{
+				provider: staticProvider{
+					compile(t, "deny", admissionregistrationv1.Fail, `envoy.Denied(403).Response()`),
+				},
+				tracer:         newTracer(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))),
+				decisionLogger: logger,
+				middlewares:    NewDecisionChain().Use("decision-id-header", DecisionIDHeaderMiddleware("x-decision-id")),
+			}
+			request := &authv3.CheckRequest{
+				Attributes: &authv3.AttributeContext{
+					Request: &authv3.AttributeContext_Request{
+						Http: &authv3.AttributeContext_HttpRequest{Id: tt.requestID, Headers: tt.headers},
+					},
+				},
+			}
+			response, err := s.Check(context.Background(), request)
+			require.NoError(t, err)
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			require.NoError(t, logger.Run(ctx))
+			// the decision record, the check span and the response carry the same id
+			require.Len(t, sink.records, 1)
+			id := sink.records[0].DecisionID
+			if tt.want != "" {
+				assert.Equal(t, tt.want, id)
+			} else {
+				assert.NotEmpty(t, id)
+			}
+			spans := recorder.Ended()
+			check := spans[len(spans)-1]
+			assert.Equal(t, "Check", check.Name())
+			assert.Contains(t, check.Attributes(), attribute.String(decisionIDAttribute, id))
+			headers := response.GetDeniedResponse().GetHeaders()
+			require.Len(t, headers, 1)
+			assert.Equal(t, "x-decision-id", headers[0].GetHeader().GetKey())
+			assert.Equal(t, id, headers[0].GetHeader().GetValue())
+		})
+	}
+}
+
+func Test_service_Check_generatedDecisionIDs(t *testing.T) {
+	recorder := &decisionIDRecorder{}
+	s := &service{
+		provider: staticProvider{
+			compile(t, "allow", admissionregistrationv1.Fail, `envoy.Allowed().Response()`),
+		},
+		decisionLogger: recorder,
+	}
+	for range 2 {
+		_, err := s.Check(context.Background(), &authv3.CheckRequest{})
+		require.NoError(t, err)
+	}
+	// every check without request id gets its own id
+	require.Len(t, recorder.ids, 2)
+	assert.NotEmpty(t, recorder.ids[0])
+	assert.NotEqual(t, recorder.ids[0], recorder.ids[1])
+}
+
+// decisionIDRecorder records the decision ids of the logged decisions
+type decisionIDRecorder struct {
+	ids []string
+}
+
+func (r *decisionIDRecorder) Log(ctx context.Context, _ *authv3.CheckRequest, _ *authv3.CheckResponse, _ error) {
+	r.ids = append(r.ids, DecisionID(ctx))
+}
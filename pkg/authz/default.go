@@ -0,0 +1,68 @@
+package authz
+
+import (
+	"fmt"
+	"net/http"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+)
+
+type Decision string
+
+const (
+	DecisionAllow Decision = "Allow"
+	DecisionDeny  Decision = "Deny"
+)
+
+// DefaultDecision is the decision taken when no policy returned a response,
+// the zero value denies requests with a 403 status code
+type DefaultDecision struct {
+	Decision Decision
+	// DenyStatus is the http status code returned when denying requests, defaults to 403
+	DenyStatus int32
+	// DenyBody is the http body returned when denying requests
+	DenyBody string
+}
+
+func (d DefaultDecision) Validate() error {
+	switch d.Decision {
+	case "", DecisionAllow, DecisionDeny:
+	default:
+		return fmt.Errorf("invalid default decision %q, expected %q or %q", d.Decision, DecisionAllow, DecisionDeny)
+	}
+	if d.DenyStatus != 0 && (d.DenyStatus < 100 || d.DenyStatus > 599) {
+		return fmt.Errorf("invalid default deny status %d", d.DenyStatus)
+	}
+	return nil
+}
+
+func (d DefaultDecision) response() *authv3.CheckResponse {
+	if d.Decision == DecisionAllow {
+		return &authv3.CheckResponse{
+			Status: &status.Status{
+				Code: int32(codes.OK),
+			},
+			HttpResponse: &authv3.CheckResponse_OkResponse{
+				OkResponse: &authv3.OkHttpResponse{},
+			},
+		}
+	}
+	code := d.DenyStatus
+	if code == 0 {
+		code = http.StatusForbidden
+	}
+	return &authv3.CheckResponse{
+		Status: &status.Status{
+			Code: int32(codes.PermissionDenied),
+		},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status: &typev3.HttpStatus{Code: typev3.StatusCode(code)},
+				Body:   d.DenyBody,
+			},
+		},
+	}
+}
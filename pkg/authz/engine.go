@@ -0,0 +1,222 @@
+package authz
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Input is a request checked without envoy, the fields are mapped to the attributes of the check request
+// envoy would send so policies are evaluated the same way
+type Input struct {
+	// Method is the http method
+	Method string
+	// Path is the request path, including the query string
+	Path string
+	// Host is the http host, it may include a port
+	Host string
+	// Scheme is the url scheme, http if empty
+	Scheme string
+	// Protocol is the http protocol, HTTP/1.1 for example
+	Protocol string
+	// Headers are the request headers, the names are lowercased and the values of a header are joined with a comma
+	Headers http.Header
+	// Body is the request body
+	Body []byte
+	// Source is the peer sending the request
+	Source Peer
+	// Destination is the peer receiving the request
+	Destination Peer
+	// ContextExtensions are the context extensions, the context.extensions variable
+	ContextExtensions map[string]string
+	// Metadata is the filter metadata keyed by filter name, the metadata variable, values must be
+	// convertible to a google.protobuf.Struct
+	Metadata map[string]map[string]any
+}
+
+// Peer is a source or destination of a request
+type Peer struct {
+	// Address is the ip address of the peer
+	Address string
+	// Port is the port of the peer
+	Port uint32
+	// Principal is the identity of the peer, a spiffe id for example
+	Principal string
+	// Service is the name of the peer service
+	Service string
+}
+
+// Result is the decision taken for an input, as envoy would apply it
+type Result struct {
+	// Allowed is true if the request is allowed
+	Allowed bool
+	// Mutated is true if the request is allowed with mutations, the headers or query parameters of the request
+	// sent upstream or the headers of the response returned to the client are changed
+	Mutated bool
+	// Status is the http status returned to the client when the request is denied,
+	// 200 when it is allowed
+	Status int
+	// Headers are the headers added to the request sent upstream when the request is allowed
+	Headers http.Header
+	// HeadersToRemove are the headers removed from the request sent upstream when the request is allowed
+	HeadersToRemove []string
+	// ResponseHeaders are the headers of the response returned to the client
+	ResponseHeaders http.Header
+	// Body is the body returned to the client when the request is denied
+	Body string
+	// Message is the message of the decision status
+	Message string
+	// Policy is the name of the policy responsible for the decision, empty for the default decisions
+	Policy string
+	// Reason is the reason of the decision, as computed by the policy
+	Reason string
+}
+
+// Engine evaluates the policies of a provider for requests that don't come from envoy,
+// without grpc nor envoy types
+type Engine struct {
+	service *service
+}
+
+// NewEngine returns an engine evaluating the policies of the provider sequentially like the service
+// returned by NewService
+func NewEngine(provider policy.Provider, defaultDecision DefaultDecision, notReadyDecision DefaultDecision) *Engine {
+	return &Engine{
+		service: &service{
+			provider:         provider,
+			defaultDecision:  defaultDecision,
+			notReadyDecision: notReadyDecision,
+		},
+	}
+}
+
+// Evaluate takes the decision for an input, it is the decision the authorization servers take for the same request
+func (e *Engine) Evaluate(ctx context.Context, input Input) (Result, error) {
+	request, err := input.checkRequest()
+	if err != nil {
+		return Result{}, err
+	}
+	response, err := e.service.check(ctx, request)
+	if err != nil {
+		return Result{}, err
+	}
+	return newResult(response), nil
+}
+
+// checkRequest converts the input into the check request envoy would send over grpc
+func (i Input) checkRequest() (*authv3.CheckRequest, error) {
+	scheme := i.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	// envoy uses lower case header names and joins multiple values with a comma
+	headers := make(map[string]string, len(i.Headers)+4)
+	for name, values := range i.Headers {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	headers[":method"] = i.Method
+	headers[":path"] = i.Path
+	headers[":authority"] = i.Host
+	headers[":scheme"] = scheme
+	_, query, _ := strings.Cut(i.Path, "?")
+	var metadata *corev3.Metadata
+	if len(i.Metadata) != 0 {
+		metadata = &corev3.Metadata{FilterMetadata: make(map[string]*structpb.Struct, len(i.Metadata))}
+		for filter, fields := range i.Metadata {
+			value, err := structpb.NewStruct(fields)
+			if err != nil {
+				return nil, err
+			}
+			metadata.FilterMetadata[filter] = value
+		}
+	}
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Source:            i.Source.peer(),
+			Destination:       i.Destination.peer(),
+			ContextExtensions: i.ContextExtensions,
+			MetadataContext:   metadata,
+			Request: &authv3.AttributeContext_Request{
+				Time: timestamppb.Now(),
+				Http: &authv3.AttributeContext_HttpRequest{
+					Id:       headers["x-request-id"],
+					Method:   i.Method,
+					Headers:  headers,
+					Path:     i.Path,
+					Host:     i.Host,
+					Scheme:   scheme,
+					Query:    query,
+					Size:     int64(len(i.Body)),
+					Protocol: i.Protocol,
+					Body:     string(i.Body),
+					RawBody:  i.Body,
+				},
+			},
+		},
+	}, nil
+}
+
+// peer returns the peer attributes of the check request, nil for a zero peer
+func (p Peer) peer() *authv3.AttributeContext_Peer {
+	if p == (Peer{}) {
+		return nil
+	}
+	out := &authv3.AttributeContext_Peer{
+		Principal: p.Principal,
+		Service:   p.Service,
+	}
+	if p.Address != "" {
+		out.Address = &corev3.Address{
+			Address: &corev3.Address_SocketAddress{
+				SocketAddress: &corev3.SocketAddress{
+					Address:       p.Address,
+					PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: p.Port},
+				},
+			},
+		}
+	}
+	return out
+}
+
+// newResult converts a check response, the headers follow the envoy semantics like the http server
+func newResult(response *authv3.CheckResponse) Result {
+	out := Result{
+		Headers:         http.Header{},
+		ResponseHeaders: http.Header{},
+		Message:         response.GetStatus().GetMessage(),
+	}
+	// no policy took a decision
+	if response == nil {
+		out.Status = http.StatusForbidden
+		return out
+	}
+	attribution := response.GetDynamicMetadata().GetFields()[core.MetadataKey].GetStructValue().GetFields()
+	out.Policy = attribution[core.MetadataPolicyKey].GetStringValue()
+	out.Reason = attribution[core.MetadataReasonKey].GetStringValue()
+	if response.GetStatus().GetCode() == int32(codes.OK) {
+		ok := response.GetOkResponse()
+		out.Allowed = true
+		out.Mutated = core.Mutates(ok)
+		out.Status = http.StatusOK
+		setHeaders(out.Headers, ok.GetHeaders())
+		out.HeadersToRemove = ok.GetHeadersToRemove()
+		setHeaders(out.ResponseHeaders, ok.GetResponseHeadersToAdd())
+		return out
+	}
+	denied := response.GetDeniedResponse()
+	out.Status = http.StatusForbidden
+	if status := denied.GetStatus().GetCode(); status != 0 {
+		out.Status = int(status)
+	}
+	setHeaders(out.ResponseHeaders, denied.GetHeaders())
+	out.Body = denied.GetBody()
+	return out
+}
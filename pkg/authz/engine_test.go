@@ -0,0 +1,93 @@
+package authz
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+)
+
+func TestEngine_Evaluate(t *testing.T) {
+	provider := staticProvider{
+		compile(t, "engine", admissionregistrationv1.Fail, `
+			request.method == "DELETE"
+				? envoy.Denied(405).WithBody("read only").WithHeader("x-reason", "read-only").Response()
+				: object.attributes.request.http.headers[?"x-team"].orValue("") == "foo" &&
+				  request.query[?"page"].orValue([]) == ["1"] &&
+				  source.principal == "spiffe://cluster.local/ns/default/sa/frontend" &&
+				  object.attributes.source.address.socket_address.address == "10.0.0.1" &&
+				  destination.service == "backend" &&
+				  context.extensions[?"tier"].orValue("") == "gold" &&
+				  metadata[?"envoy.filters.http.jwt_authn"].?payload.sub.orValue("") == "alice" &&
+				  object.attributes.request.http.body == "{}"
+				? envoy.Allowed().WithHeader("x-user", "alice").WithResponseHeader("x-checked", "true").Response()
+				: null
+		`),
+	}
+	tests := []struct {
+		name  string
+		input Input
+		want  Result
+	}{{
+		name: "allowed",
+		input: Input{
+			Method:            "POST",
+			Path:              "/api?page=1",
+			Host:              "api.example.com",
+			Headers:           http.Header{"X-Team": {"foo"}},
+			Body:              []byte("{}"),
+			Source:            Peer{Address: "10.0.0.1", Port: 43210, Principal: "spiffe://cluster.local/ns/default/sa/frontend"},
+			Destination:       Peer{Service: "backend"},
+			ContextExtensions: map[string]string{"tier": "gold"},
+			Metadata:          map[string]map[string]any{"envoy.filters.http.jwt_authn": {"payload": map[string]any{"sub": "alice"}}},
+		},
+		want: Result{
+			Allowed:         true,
+			Mutated:         true,
+			Status:          http.StatusOK,
+			Headers:         http.Header{"X-User": {"alice"}},
+			ResponseHeaders: http.Header{"X-Checked": {"true"}},
+			Policy:          "engine",
+		},
+	}, {
+		name:  "denied",
+		input: Input{Method: "DELETE", Path: "/api"},
+		want: Result{
+			Status:          http.StatusMethodNotAllowed,
+			Headers:         http.Header{},
+			ResponseHeaders: http.Header{"X-Reason": {"read-only"}},
+			Body:            "read only",
+			Policy:          "engine",
+		},
+	}, {
+		name:  "default decision",
+		input: Input{Method: "GET", Path: "/api"},
+		want: Result{
+			Status:          http.StatusTeapot,
+			Headers:         http.Header{},
+			ResponseHeaders: http.Header{},
+			Body:            "no policy",
+		},
+	}}
+	engine := NewEngine(provider, DefaultDecision{Decision: DecisionDeny, DenyStatus: http.StatusTeapot, DenyBody: "no policy"}, DefaultDecision{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := engine.Evaluate(context.Background(), tt.input)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+	// metadata must be convertible to structs
+	_, err := engine.Evaluate(context.Background(), Input{Metadata: map[string]map[string]any{"filter": {"invalid": make(chan int)}}})
+	assert.Error(t, err)
+}
+
+func TestEngine_Evaluate_notReady(t *testing.T) {
+	engine := NewEngine(syncingProvider{}, DefaultDecision{}, DefaultDecision{Decision: DecisionDeny, DenyStatus: http.StatusServiceUnavailable})
+	got, err := engine.Evaluate(context.Background(), Input{Method: "GET", Path: "/"})
+	assert.NoError(t, err)
+	assert.False(t, got.Allowed)
+	assert.Equal(t, http.StatusServiceUnavailable, got.Status)
+}
@@ -0,0 +1,91 @@
+package authz
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+)
+
+type explanationKey struct{}
+
+// PolicyExplanation describes the evaluation of a policy
+type PolicyExplanation struct {
+	// Policy is the policy name
+	Policy string
+	// Mode is the policy enforcement mode, audit policies never affect the response
+	Mode hub.EnforcementMode
+	// Decision is allow, deny, error or none, as recorded by the metrics, errors ignored by the failure policy are not errors
+	Decision string
+	// Skipped is the path of the condition that skipped the policy, if any
+	Skipped string
+	// Authorization is the path of the authorization that returned the response, if any
+	Authorization string
+	// Response is the response returned by the policy, if any
+	Response *authv3.CheckResponse
+	// Err is the evaluation error, including errors ignored by the failure policy
+	Err error
+	// Duration is the evaluation time
+	Duration time.Duration
+}
+
+// Explanation records the evaluation of the policies of a check, policies that were not evaluated
+// because the decision was already taken are not recorded
+type Explanation struct {
+	lock     sync.Mutex
+	policies []PolicyExplanation
+}
+
+// WithExplanation returns a context explaining the checks done with it
+func WithExplanation(ctx context.Context) (context.Context, *Explanation) {
+	explanation := &Explanation{}
+	return context.WithValue(ctx, explanationKey{}, explanation), explanation
+}
+
+// Policies returns the policies evaluated so far, in the order their evaluation completed
+func (e *Explanation) Policies() []PolicyExplanation {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]PolicyExplanation(nil), e.policies...)
+}
+
+func (e *Explanation) record(policy PolicyExplanation) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.policies = append(e.policies, policy)
+}
+
+// explanationFromContext returns the explanation of the context, nil if the check is not explained
+func explanationFromContext(ctx context.Context) *Explanation {
+	explanation, _ := ctx.Value(explanationKey{}).(*Explanation)
+	return explanation
+}
+
+// explainPolicy returns a context tracing the evaluation of the policy and a function recording it,
+// it returns the context as is and a no-op when the check is not explained
+func explainPolicy(ctx context.Context, policy core.CompiledPolicy) (context.Context, func(string, *authv3.CheckResponse, error, time.Duration)) {
+	explanation := explanationFromContext(ctx)
+	if explanation == nil {
+		return ctx, func(string, *authv3.CheckResponse, error, time.Duration) {}
+	}
+	ctx, trace := core.WithEvaluationTrace(ctx)
+	return ctx, func(outcome string, response *authv3.CheckResponse, err error, duration time.Duration) {
+		// the trace error includes the errors ignored by the failure policy
+		if err == nil {
+			err = trace.Err
+		}
+		explanation.record(PolicyExplanation{
+			Policy:        policy.Name,
+			Mode:          policy.Mode,
+			Decision:      outcome,
+			Skipped:       trace.Skipped,
+			Authorization: trace.Authorization,
+			Response:      response,
+			Err:           err,
+			Duration:      duration,
+		})
+	}
+}
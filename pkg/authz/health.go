@@ -0,0 +1,37 @@
+package authz
+
+import (
+	"context"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// services reported by the health server, the empty name stands for the overall server health
+var healthServices = []string{"", authv3.Authorization_ServiceDesc.ServiceName}
+
+// watchHealth reports NOT_SERVING until the provider is ready, then SERVING until the context is cancelled
+func watchHealth(ctx context.Context, server *health.Server, provider policy.Provider, interval time.Duration) {
+	setServingStatus(server, healthpb.HealthCheckResponse_NOT_SERVING)
+	err := wait.PollUntilContextCancel(ctx, interval, true, func(ctx context.Context) (bool, error) {
+		return policy.Ready(ctx, provider), nil
+	})
+	// context was cancelled before the provider became ready
+	if err != nil {
+		return
+	}
+	setServingStatus(server, healthpb.HealthCheckResponse_SERVING)
+	// report NOT_SERVING while shutting down
+	<-ctx.Done()
+	server.Shutdown()
+}
+
+func setServingStatus(server *health.Server, status healthpb.HealthCheckResponse_ServingStatus) {
+	for _, service := range healthServices {
+		server.SetServingStatus(service, status)
+	}
+}
@@ -0,0 +1,58 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type warmingProvider struct {
+	ready atomic.Bool
+}
+
+func (p *warmingProvider) CompiledPolicies(context.Context) ([]policy.CompiledPolicy, error) {
+	if !p.ready.Load() {
+		return nil, errors.New("not ready")
+	}
+	return nil, nil
+}
+
+func (p *warmingProvider) HasSynced() bool {
+	return p.ready.Load()
+}
+
+func Test_watchHealth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	provider := &warmingProvider{}
+	server := health.NewServer()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watchHealth(ctx, server, provider, 10*time.Millisecond)
+	}()
+	status := func() healthpb.HealthCheckResponse_ServingStatus {
+		response, err := server.Check(ctx, &healthpb.HealthCheckRequest{})
+		assert.NoError(t, err)
+		return response.GetStatus()
+	}
+	assert.Eventually(t, func() bool {
+		return status() == healthpb.HealthCheckResponse_NOT_SERVING
+	}, time.Second, 10*time.Millisecond)
+	// the provider produces policies, the server becomes ready
+	provider.ready.Store(true)
+	assert.Eventually(t, func() bool {
+		return status() == healthpb.HealthCheckResponse_SERVING
+	}, time.Second, 10*time.Millisecond)
+	// shutting down
+	cancel()
+	<-done
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status())
+}
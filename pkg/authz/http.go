@@ -0,0 +1,187 @@
+package authz
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/envoy"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/redact"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/server"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func NewHttpServer(addr string, provider policy.Provider, metrics *metrics.Metrics, tracerProvider trace.TracerProvider, decisionLogger DecisionLogger, redactor *redact.Redactor, decisionCache *DecisionCache, middlewares *DecisionChain, defaultDecision DefaultDecision, notReadyDecision DefaultDecision, evaluationMode EvaluationMode, concurrency int, policyTimeout time.Duration, maxBodySize int64, checkPool *CheckPool, costBudget *CostBudget, bodyLimit *BodyLimit, missingBody *DefaultDecision, allowTrail bool) server.ServerFunc {
+	return func(ctx context.Context) error {
+		// setup our authorization service
+		svc := &service{
+			provider:         provider,
+			metrics:          metrics,
+			tracer:           newTracer(tracerProvider),
+			decisionLogger:   decisionLogger,
+			redactor:         redactor,
+			defaultDecision:  defaultDecision,
+			notReadyDecision: notReadyDecision,
+			evaluationMode:   evaluationMode,
+			concurrency:      concurrency,
+			policyTimeout:    policyTimeout,
+			decisionCache:    decisionCache,
+			checkPool:        checkPool,
+			middlewares:      middlewares,
+			costBudget:       costBudget,
+			bodyLimit:        bodyLimit,
+			missingBody:      missingBody,
+			allowTrail:       allowTrail,
+		}
+		// create server
+		s := &http.Server{
+			Addr:    addr,
+			Handler: newHttpHandler(svc, maxBodySize),
+		}
+		// run server
+		return server.RunHttp(ctx, s, "", "")
+	}
+}
+
+func newHttpHandler(svc *service, maxBodySize int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// wait for a worker before reading the body
+		release, err := svc.checkPool.acquire(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+		// build check request
+		request, err := checkRequest(r, svc.bodyLimit.readLimit(maxBodySize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// execute check
+		response, err := svc.check(r.Context(), request)
+		if err != nil {
+			err = svc.redactor.Error(request, err)
+			log.FromContext(r.Context()).Error(err, "failed to check request")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// write response
+		writeCheckResponse(w, response)
+	}
+}
+
+// checkRequest converts an http request into the check request envoy would send over grpc
+func checkRequest(r *http.Request, maxBodySize int64) (*authv3.CheckRequest, error) {
+	// read body up to the limit, one more byte tells us if it was truncated
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	// envoy uses lower case header names and joins multiple values with a comma
+	headers := make(map[string]string, len(r.Header)+4)
+	for name, values := range r.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	headers[":method"] = r.Method
+	headers[":path"] = r.URL.RequestURI()
+	headers[":authority"] = r.Host
+	headers[":scheme"] = scheme
+	if int64(len(body)) > maxBodySize {
+		body = body[:maxBodySize]
+		headers[envoy.PartialBodyHeader] = "true"
+	}
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Time: timestamppb.Now(),
+				Http: &authv3.AttributeContext_HttpRequest{
+					Id:       headers["x-request-id"],
+					Method:   r.Method,
+					Headers:  headers,
+					Path:     r.URL.RequestURI(),
+					Host:     r.Host,
+					Scheme:   scheme,
+					Query:    r.URL.RawQuery,
+					Fragment: r.URL.Fragment,
+					Size:     r.ContentLength,
+					Protocol: r.Proto,
+					Body:     string(body),
+					RawBody:  body,
+				},
+			},
+		},
+	}, nil
+}
+
+// writeCheckResponse writes the check response the way envoy interprets http authorization responses,
+// a 200 allows the request, any other status denies it and is returned to the client
+func writeCheckResponse(w http.ResponseWriter, response *authv3.CheckResponse) {
+	// no policy took a decision
+	if response == nil {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if response.GetStatus().GetCode() == int32(codes.OK) {
+		ok := response.GetOkResponse()
+		setHeaders(w.Header(), ok.GetHeaders())
+		setHeaders(w.Header(), ok.GetResponseHeadersToAdd())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	denied := response.GetDeniedResponse()
+	setHeaders(w.Header(), denied.GetHeaders())
+	code := http.StatusForbidden
+	if status := denied.GetStatus().GetCode(); status != 0 {
+		code = int(status)
+	}
+	w.WriteHeader(code)
+	if body := denied.GetBody(); body != "" {
+		_, _ = io.WriteString(w, body)
+	}
+}
+
+// setHeaders follows envoy semantics, the deprecated append field takes precedence over the append action
+func setHeaders(header http.Header, options []*corev3.HeaderValueOption) {
+	for _, option := range options {
+		name, value := option.GetHeader().GetKey(), option.GetHeader().GetValue()
+		if name == "" {
+			continue
+		}
+		if option.GetAppend() != nil {
+			if option.GetAppend().GetValue() {
+				header.Add(name, value)
+			} else {
+				header.Set(name, value)
+			}
+			continue
+		}
+		switch option.GetAppendAction() {
+		case corev3.HeaderValueOption_ADD_IF_ABSENT:
+			if header.Get(name) == "" {
+				header.Set(name, value)
+			}
+		case corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD:
+			header.Set(name, value)
+		case corev3.HeaderValueOption_OVERWRITE_IF_EXISTS:
+			if header.Get(name) != "" {
+				header.Set(name, value)
+			}
+		default:
+			header.Add(name, value)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package authz
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+)
+
+func Test_httpHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		method     string
+		body       string
+		headers    map[string]string
+		wantCode   int
+		wantHeader map[string]string
+		wantBody   string
+	}{{
+		name:       "allowed with headers",
+		expression: `envoy.Allowed().WithHeader("x-user", "alice").Response()`,
+		method:     http.MethodGet,
+		wantCode:   http.StatusOK,
+		wantHeader: map[string]string{"x-user": "alice"},
+	}, {
+		name:       "denied with status and body",
+		expression: `envoy.Denied(401).WithBody("unauthorized").WithHeader("www-authenticate", "Bearer").Response()`,
+		method:     http.MethodGet,
+		wantCode:   http.StatusUnauthorized,
+		wantHeader: map[string]string{"www-authenticate": "Bearer"},
+		wantBody:   "unauthorized",
+	}, {
+		name:       "no decision",
+		expression: `false ? envoy.Allowed().Response() : null`,
+		method:     http.MethodGet,
+		wantCode:   http.StatusForbidden,
+	}, {
+		name:       "headers are lower case",
+		expression: `object.attributes.request.http.headers["x-team"] == "foo" && object.attributes.request.http.headers[":method"] == "POST" ? envoy.Allowed().Response() : envoy.Denied(403).Response()`,
+		method:     http.MethodPost,
+		headers:    map[string]string{"X-Team": "foo"},
+		wantCode:   http.StatusOK,
+	}, {
+		name:       "body is forwarded",
+		expression: `object.attributes.request.http.body == "hello" ? envoy.Allowed().Response() : envoy.Denied(403).Response()`,
+		method:     http.MethodPost,
+		body:       "hello",
+		wantCode:   http.StatusOK,
+	}, {
+		name:       "body is truncated",
+		expression: `object.attributes.request.http.body == "0123456789" && object.attributes.request.http.headers["x-envoy-auth-partial-body"] == "true" ? envoy.Allowed().Response() : envoy.Denied(403).Response()`,
+		method:     http.MethodPost,
+		body:       "0123456789abcdef",
+		wantCode:   http.StatusOK,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &service{
+				provider: staticProvider{
+					compile(t, "policy", admissionregistrationv1.Fail, tt.expression),
+				},
+			}
+			request := httptest.NewRequest(tt.method, "/foo?bar=baz", strings.NewReader(tt.body))
+			for name, value := range tt.headers {
+				request.Header.Set(name, value)
+			}
+			recorder := httptest.NewRecorder()
+			newHttpHandler(svc, 10).ServeHTTP(recorder, request)
+			assert.Equal(t, tt.wantCode, recorder.Code)
+			for name, value := range tt.wantHeader {
+				assert.Equal(t, value, recorder.Header().Get(name))
+			}
+			assert.Equal(t, tt.wantBody, recorder.Body.String())
+		})
+	}
+}
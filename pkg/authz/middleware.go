@@ -0,0 +1,152 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var errDenyOverridden = errors.New("decision middleware turned a denied request into an allowed one")
+
+// DecisionMiddleware processes the decision taken by the policies before it is returned to envoy,
+// to inject a correlation header or enrich logs for example
+type DecisionMiddleware interface {
+	// Process returns the decision sent back to envoy, it can modify the decision or return another one.
+	// Returning nil keeps the decision, including the changes made to it.
+	Process(ctx context.Context, r *authv3.CheckRequest, decision *authv3.CheckResponse) *authv3.CheckResponse
+}
+
+// DecisionMiddlewareFunc adapts a function to a DecisionMiddleware
+type DecisionMiddlewareFunc func(context.Context, *authv3.CheckRequest, *authv3.CheckResponse) *authv3.CheckResponse
+
+func (f DecisionMiddlewareFunc) Process(ctx context.Context, r *authv3.CheckRequest, decision *authv3.CheckResponse) *authv3.CheckResponse {
+	return f(ctx, r, decision)
+}
+
+type chainedMiddleware struct {
+	name       string
+	middleware DecisionMiddleware
+	// mayAllow permits the middleware to turn a denied request into an allowed one
+	mayAllow bool
+}
+
+// DecisionChain runs decision middlewares in the order they were added, a nil chain runs none
+type DecisionChain struct {
+	middlewares []chainedMiddleware
+}
+
+// NewDecisionChain returns an empty chain, it is configured at startup and must not change once the servers run
+func NewDecisionChain() *DecisionChain {
+	return &DecisionChain{}
+}
+
+// Use appends a middleware to the chain, a denied request it allows stays denied
+func (c *DecisionChain) Use(name string, middleware DecisionMiddleware) *DecisionChain {
+	c.middlewares = append(c.middlewares, chainedMiddleware{name: name, middleware: middleware})
+	return c
+}
+
+// UseAllowing appends a middleware permitted to turn a denied request into an allowed one
+func (c *DecisionChain) UseAllowing(name string, middleware DecisionMiddleware) *DecisionChain {
+	c.middlewares = append(c.middlewares, chainedMiddleware{name: name, middleware: middleware, mayAllow: true})
+	return c
+}
+
+// process runs the middlewares in order, every middleware gets the decision returned by the previous one.
+// A middleware allowing a denied request without permission is ignored, the next one gets the denied decision.
+func (c *DecisionChain) process(ctx context.Context, r *authv3.CheckRequest, decision *authv3.CheckResponse) *authv3.CheckResponse {
+	if c == nil {
+		return decision
+	}
+	for _, m := range c.middlewares {
+		// the middleware may change the decision in place, keep a copy to restore
+		var denied *authv3.CheckResponse
+		if !m.mayAllow && !isAllowed(decision) {
+			denied = proto.Clone(decision).(*authv3.CheckResponse)
+		}
+		processed := m.middleware.Process(ctx, r, decision)
+		if processed == nil {
+			processed = decision
+		}
+		if denied != nil && isAllowed(processed) {
+			log.FromContext(ctx).Error(errDenyOverridden, "ignoring the decision of the middleware", "middleware", m.name)
+			processed = denied
+		}
+		decision = processed
+	}
+	return decision
+}
+
+func isAllowed(response *authv3.CheckResponse) bool {
+	return response != nil && response.GetStatus().GetCode() == int32(codes.OK)
+}
+
+// ResponseHeaderMiddleware returns a middleware adding a header to the response sent by envoy to the client,
+// whether the request is allowed or denied. The value is computed from the request, an empty value adds no header.
+func ResponseHeaderMiddleware(key string, value func(*authv3.CheckRequest) string) DecisionMiddleware {
+	return responseHeaderMiddleware(key, func(_ context.Context, r *authv3.CheckRequest) string {
+		return value(r)
+	})
+}
+
+func responseHeaderMiddleware(key string, value func(context.Context, *authv3.CheckRequest) string) DecisionMiddleware {
+	return DecisionMiddlewareFunc(func(ctx context.Context, r *authv3.CheckRequest, decision *authv3.CheckResponse) *authv3.CheckResponse {
+		value := value(ctx, r)
+		if value == "" || decision == nil {
+			return decision
+		}
+		header := &corev3.HeaderValueOption{
+			Header:       &corev3.HeaderValue{Key: key, Value: value},
+			AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+		}
+		if isAllowed(decision) {
+			ok := decision.GetOkResponse()
+			if ok == nil {
+				ok = &authv3.OkHttpResponse{}
+				decision.HttpResponse = &authv3.CheckResponse_OkResponse{OkResponse: ok}
+			}
+			ok.ResponseHeadersToAdd = append(ok.ResponseHeadersToAdd, header)
+		} else {
+			denied := decision.GetDeniedResponse()
+			if denied == nil {
+				denied = &authv3.DeniedHttpResponse{}
+				decision.HttpResponse = &authv3.CheckResponse_DeniedResponse{DeniedResponse: denied}
+			}
+			denied.Headers = append(denied.Headers, header)
+		}
+		return decision
+	})
+}
+
+// CorrelationHeaderMiddleware returns a middleware copying a request header, x-request-id for example,
+// to the response sent by envoy to the client
+func CorrelationHeaderMiddleware(key string) DecisionMiddleware {
+	// envoy lowercases the request header names
+	name := strings.ToLower(key)
+	return ResponseHeaderMiddleware(key, func(r *authv3.CheckRequest) string {
+		return r.GetAttributes().GetRequest().GetHttp().GetHeaders()[name]
+	})
+}
+
+// DecisionIDHeaderMiddleware returns a middleware adding the decision id to the response sent by envoy to the
+// client, see DecisionID
+func DecisionIDHeaderMiddleware(key string) DecisionMiddleware {
+	return responseHeaderMiddleware(key, func(ctx context.Context, _ *authv3.CheckRequest) string {
+		return DecisionID(ctx)
+	})
+}
+
+// DecisionLoggerMiddleware returns a middleware recording the decisions with a logger, it records the decision
+// processed by the middlewares before it in the chain
+func DecisionLoggerMiddleware(logger DecisionLogger) DecisionMiddleware {
+	return DecisionMiddlewareFunc(func(ctx context.Context, r *authv3.CheckRequest, decision *authv3.CheckResponse) *authv3.CheckResponse {
+		logger.Log(ctx, r, decision, nil)
+		return decision
+	})
+}
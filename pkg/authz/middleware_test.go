@@ -0,0 +1,151 @@
+package authz
+
+import (
+	"context"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	authzv1alpha1 "github.com/kyverno/kyverno-envoy-plugin/apis/authz/v1alpha1"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+)
+
+// tagMiddleware appends its tag to the decision message
+func tagMiddleware(tag string) DecisionMiddleware {
+	return DecisionMiddlewareFunc(func(_ context.Context, _ *authv3.CheckRequest, decision *authv3.CheckResponse) *authv3.CheckResponse {
+		decision.Status.Message += tag
+		return decision
+	})
+}
+
+// allowMiddleware allows every request
+var allowMiddleware = DecisionMiddlewareFunc(func(context.Context, *authv3.CheckRequest, *authv3.CheckResponse) *authv3.CheckResponse {
+	return allowed("middleware")
+})
+
+func TestDecisionChain_process(t *testing.T) {
+	tests := []struct {
+		name     string
+		chain    *DecisionChain
+		decision *authv3.CheckResponse
+		wantCode codes.Code
+		wantMsg  string
+	}{{
+		name:     "nil chain",
+		decision: denied("policy"),
+		wantCode: codes.PermissionDenied,
+		wantMsg:  "policy",
+	}, {
+		name:     "in order",
+		chain:    NewDecisionChain().Use("a", tagMiddleware("-a")).Use("b", tagMiddleware("-b")).Use("c", tagMiddleware("-c")),
+		decision: allowed("policy"),
+		wantCode: codes.OK,
+		wantMsg:  "policy-a-b-c",
+	}, {
+		name: "nil keeps the decision",
+		chain: NewDecisionChain().Use("nil", DecisionMiddlewareFunc(func(_ context.Context, _ *authv3.CheckRequest, decision *authv3.CheckResponse) *authv3.CheckResponse {
+			decision.Status.Message += "-nil"
+			return nil
+		})).Use("a", tagMiddleware("-a")),
+		decision: denied("policy"),
+		wantCode: codes.PermissionDenied,
+		wantMsg:  "policy-nil-a",
+	}, {
+		name:     "deny can't be allowed",
+		chain:    NewDecisionChain().Use("a", tagMiddleware("-a")).Use("allow", allowMiddleware).Use("b", tagMiddleware("-b")),
+		decision: denied("policy"),
+		wantCode: codes.PermissionDenied,
+		wantMsg:  "policy-a-b",
+	}, {
+		name: "deny can't be allowed in place",
+		chain: NewDecisionChain().Use("allow", DecisionMiddlewareFunc(func(_ context.Context, _ *authv3.CheckRequest, decision *authv3.CheckResponse) *authv3.CheckResponse {
+			decision.Status.Code = int32(codes.OK)
+			return decision
+		})),
+		decision: denied("policy"),
+		wantCode: codes.PermissionDenied,
+		wantMsg:  "policy",
+	}, {
+		name:     "deny allowed with permission",
+		chain:    NewDecisionChain().UseAllowing("allow", allowMiddleware).Use("a", tagMiddleware("-a")),
+		decision: denied("policy"),
+		wantCode: codes.OK,
+		wantMsg:  "middleware-a",
+	}, {
+		name: "allow can be denied",
+		chain: NewDecisionChain().Use("deny", DecisionMiddlewareFunc(func(context.Context, *authv3.CheckRequest, *authv3.CheckResponse) *authv3.CheckResponse {
+			return denied("middleware")
+		})),
+		decision: allowed("policy"),
+		wantCode: codes.PermissionDenied,
+		wantMsg:  "middleware",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.chain.process(context.Background(), &authv3.CheckRequest{}, tt.decision)
+			assert.Equal(t, int32(tt.wantCode), got.GetStatus().GetCode())
+			assert.Equal(t, tt.wantMsg, got.GetStatus().GetMessage())
+		})
+	}
+}
+
+func TestCorrelationHeaderMiddleware(t *testing.T) {
+	request := &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{Headers: map[string]string{"x-request-id": "42"}},
+			},
+		},
+	}
+	want := []*corev3.HeaderValueOption{{
+		Header:       &corev3.HeaderValue{Key: "X-Request-Id", Value: "42"},
+		AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+	}}
+	middleware := CorrelationHeaderMiddleware("X-Request-Id")
+	// allowed requests get a response header
+	response := middleware.Process(context.Background(), request, allowed("policy"))
+	assert.Equal(t, want, response.GetOkResponse().GetResponseHeadersToAdd())
+	// denied requests get the header on the denied response
+	response = middleware.Process(context.Background(), request, denied("policy"))
+	assert.Equal(t, want, response.GetDeniedResponse().GetHeaders())
+	assert.Equal(t, int32(codes.PermissionDenied), response.GetStatus().GetCode())
+	// no header without request header
+	response = middleware.Process(context.Background(), &authv3.CheckRequest{}, allowed("policy"))
+	assert.Nil(t, response.GetOkResponse())
+}
+
+func Test_service_Check_middlewares(t *testing.T) {
+	recorder := &decisionRecorder{}
+	s := &service{
+		provider: staticProvider{
+			compile(t, "deny-bar", admissionregistrationv1.Fail, `object.attributes.request.http.headers[?"x-team"].orValue("") == "bar" ? envoy.Denied(403).Response() : envoy.Allowed().Response()`),
+		},
+		decisionLogger: recorder,
+		middlewares: NewDecisionChain().
+			Use("correlation-header", CorrelationHeaderMiddleware("x-request-id")).
+			Use("allow", allowMiddleware),
+	}
+	newRequest := func(team string) *authv3.CheckRequest {
+		return &authv3.CheckRequest{
+			Attributes: &authv3.AttributeContext{
+				Request: &authv3.AttributeContext_Request{
+					Http: &authv3.AttributeContext_HttpRequest{Headers: map[string]string{"x-team": team, "x-request-id": team + "-id"}},
+				},
+			},
+		}
+	}
+	// the denied request stays denied and gets the correlation header
+	response, err := s.Check(context.Background(), newRequest("bar"))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(codes.PermissionDenied), response.GetStatus().GetCode())
+	assert.Equal(t, "bar-id", response.GetDeniedResponse().GetHeaders()[0].GetHeader().GetValue())
+	// the batches are processed too
+	batch, err := s.BatchCheck(context.Background(), &authzv1alpha1.BatchCheckRequest{Requests: []*authv3.CheckRequest{newRequest("foo")}})
+	assert.NoError(t, err)
+	assert.Equal(t, "middleware", batch.GetResponses()[0].GetStatus().GetMessage())
+	// the processed decisions are logged
+	assert.Equal(t, []string{metrics.DecisionDeny, metrics.DecisionAllow}, recorder.decisions)
+}
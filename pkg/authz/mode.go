@@ -0,0 +1,41 @@
+package authz
+
+import (
+	"context"
+	"fmt"
+)
+
+// EvaluationMode defines how the responses of the policies are combined into a decision
+type EvaluationMode string
+
+const (
+	// EvaluationModeEvaluateAll evaluates the policies until one denies the request, a deny overrides any allow
+	EvaluationModeEvaluateAll EvaluationMode = "EvaluateAll"
+	// EvaluationModeFirstMatch stops at the first policy returning a response and returns it, like a firewall ruleset
+	EvaluationModeFirstMatch EvaluationMode = "FirstMatch"
+)
+
+// Validate fails if the mode is unknown, the zero value evaluates all policies
+func (m EvaluationMode) Validate() error {
+	switch m {
+	case "", EvaluationModeEvaluateAll, EvaluationModeFirstMatch:
+		return nil
+	default:
+		return fmt.Errorf("invalid evaluation mode %q, expected %q or %q", m, EvaluationModeEvaluateAll, EvaluationModeFirstMatch)
+	}
+}
+
+type evaluationModeKey struct{}
+
+// WithEvaluationMode returns a context overriding the evaluation mode of the server for the checks done with it
+func WithEvaluationMode(ctx context.Context, mode EvaluationMode) context.Context {
+	return context.WithValue(ctx, evaluationModeKey{}, mode)
+}
+
+// evaluationMode returns the mode of the context if any, the given mode otherwise
+func evaluationMode(ctx context.Context, mode EvaluationMode) EvaluationMode {
+	if override, ok := ctx.Value(evaluationModeKey{}).(EvaluationMode); ok && override != "" {
+		return override
+	}
+	return mode
+}
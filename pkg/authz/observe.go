@@ -0,0 +1,50 @@
+package authz
+
+import (
+	"context"
+	"strings"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// ObserveMiddleware returns a middleware allowing every request, the decision taken by the policies is logged
+// instead of being enforced. It is meant to compare the decisions of the policies with the ones of another
+// authorization system before enforcing them, externalHeader is the request header carrying the decision of the
+// other system (allow or deny). A decision disagreeing with the external decision is logged as a disagreement,
+// requests without the header are only logged. The middleware must be added with UseAllowing.
+func ObserveMiddleware(externalHeader string) DecisionMiddleware {
+	// envoy lowercases the request header names
+	name := strings.ToLower(externalHeader)
+	return DecisionMiddlewareFunc(func(ctx context.Context, r *authv3.CheckRequest, response *authv3.CheckResponse) *authv3.CheckResponse {
+		logger := log.FromContext(ctx)
+		observed := decision(response, nil)
+		logger.Info("observed decision", "decision", observed, "code", codes.Code(response.GetStatus().GetCode()).String())
+		if name != "" {
+			if external, ok := r.GetAttributes().GetRequest().GetHttp().GetHeaders()[name]; ok {
+				switch external = strings.ToLower(external); external {
+				case metrics.DecisionAllow, metrics.DecisionDeny:
+					// a policy taking no decision is compared with the default decision applied to the response
+					if external != observed {
+						logger.Info("observed decision disagrees with the external decision", "decision", observed, "external", external)
+					}
+				default:
+					logger.Info("ignoring invalid external decision, expected allow or deny", "header", externalHeader)
+				}
+			}
+		}
+		// allowed requests are forwarded as the policies allowed them, with their header mutations
+		if isAllowed(response) {
+			return response
+		}
+		// denied requests are allowed without mutation, the metadata still tells which policy denied them
+		return &authv3.CheckResponse{
+			Status:          &status.Status{Code: int32(codes.OK)},
+			HttpResponse:    &authv3.CheckResponse_OkResponse{OkResponse: &authv3.OkHttpResponse{}},
+			DynamicMetadata: response.GetDynamicMetadata(),
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package authz
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/go-logr/logr/funcr"
+	authzv1alpha1 "github.com/kyverno/kyverno-envoy-plugin/apis/authz/v1alpha1"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func Test_service_Check_observe(t *testing.T) {
+	newRequest := func(team, external string) *authv3.CheckRequest {
+		headers := map[string]string{"x-team": team}
+		if external != "" {
+			headers["x-legacy-decision"] = external
+		}
+		return &authv3.CheckRequest{
+			Attributes: &authv3.AttributeContext{
+				Request: &authv3.AttributeContext_Request{
+					Http: &authv3.AttributeContext_HttpRequest{Headers: headers},
+				},
+			},
+		}
+	}
+	tests := []struct {
+		name         string
+		request      *authv3.CheckRequest
+		wantDecision string
+		wantLogs     []string
+	}{{
+		name:         "denied",
+		request:      newRequest("bar", ""),
+		wantDecision: metrics.DecisionDeny,
+		wantLogs:     []string{`"level"=0 "msg"="observed decision" "decision"="deny" "code"="PermissionDenied"`},
+	}, {
+		name:         "allowed",
+		request:      newRequest("foo", ""),
+		wantDecision: metrics.DecisionAllow,
+		wantLogs:     []string{`"level"=0 "msg"="observed decision" "decision"="allow" "code"="OK"`},
+	}, {
+		name:         "disagreement",
+		request:      newRequest("bar", "Allow"),
+		wantDecision: metrics.DecisionDeny,
+		wantLogs: []string{
+			`"level"=0 "msg"="observed decision" "decision"="deny" "code"="PermissionDenied"`,
+			`"level"=0 "msg"="observed decision disagrees with the external decision" "decision"="deny" "external"="allow"`,
+		},
+	}, {
+		name:         "agreement",
+		request:      newRequest("foo", "allow"),
+		wantDecision: metrics.DecisionAllow,
+		wantLogs:     []string{`"level"=0 "msg"="observed decision" "decision"="allow" "code"="OK"`},
+	}, {
+		name:         "invalid external decision",
+		request:      newRequest("foo", "maybe"),
+		wantDecision: metrics.DecisionAllow,
+		wantLogs: []string{
+			`"level"=0 "msg"="observed decision" "decision"="allow" "code"="OK"`,
+			`"level"=0 "msg"="ignoring invalid external decision, expected allow or deny" "header"="X-Legacy-Decision"`,
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+			m, err := metrics.New(registry)
+			assert.NoError(t, err)
+			recorder := &decisionRecorder{}
+			s := &service{
+				provider: staticProvider{
+					compile(t, "deny-bar", admissionregistrationv1.Fail, `object.attributes.request.http.headers[?"x-team"].orValue("") == "bar" ? envoy.Denied(403).Response() : envoy.Allowed().WithHeader("x-team-checked", "true").Response()`),
+				},
+				metrics:        m,
+				decisionLogger: recorder,
+				middlewares:    NewDecisionChain().UseAllowing("observe", ObserveMiddleware("X-Legacy-Decision")),
+			}
+			var logs []string
+			ctx := log.IntoContext(context.Background(), funcr.New(func(_, args string) {
+				logs = append(logs, args)
+			}, funcr.Options{}))
+			response, err := s.Check(ctx, tt.request)
+			assert.NoError(t, err)
+			// the decision is never enforced
+			assert.Equal(t, int32(codes.OK), response.GetStatus().GetCode())
+			assert.Equal(t, tt.wantLogs, logs)
+			assert.Equal(t, []string{metrics.DecisionAllow}, recorder.decisions)
+			// but the policy decision is computed and recorded
+			expected := `
+# HELP policy_evaluations_total Number of policy evaluations, partitioned by policy, enforcement mode and decision.
+# TYPE policy_evaluations_total counter
+policy_evaluations_total{decision="` + tt.wantDecision + `",mode="Enforce",policy="deny-bar"} 1
+`
+			assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "policy_evaluations_total"))
+			// the mutations of allowed requests are kept
+			if tt.wantDecision == metrics.DecisionAllow {
+				assert.Equal(t, []*corev3.HeaderValueOption{{Header: &corev3.HeaderValue{Key: "x-team-checked", Value: "true"}}}, response.GetOkResponse().GetHeaders())
+			} else {
+				assert.Empty(t, response.GetOkResponse().GetHeaders())
+			}
+		})
+	}
+}
+
+func Test_service_Check_observeBodyLimit(t *testing.T) {
+	var logs []string
+	ctx := log.IntoContext(context.Background(), funcr.New(func(_, args string) {
+		logs = append(logs, args)
+	}, funcr.Options{}))
+	s := &service{
+		provider:    staticProvider{compile(t, "allow", admissionregistrationv1.Fail, `envoy.Allowed().Response()`)},
+		bodyLimit:   NewBodyLimit(8, &DefaultDecision{Decision: DecisionDeny, DenyStatus: 413}),
+		middlewares: NewDecisionChain().UseAllowing("observe", ObserveMiddleware("")),
+	}
+	request := &authv3.CheckRequest{Attributes: &authv3.AttributeContext{Request: &authv3.AttributeContext_Request{Http: &authv3.AttributeContext_HttpRequest{
+		Body: "0123456789",
+	}}}}
+	// the rejection of an oversized body is observed like any other deny
+	response, err := s.Check(ctx, request)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(codes.OK), response.GetStatus().GetCode())
+	batch, err := s.BatchCheck(ctx, &authzv1alpha1.BatchCheckRequest{Requests: []*authv3.CheckRequest{request}})
+	assert.NoError(t, err)
+	if assert.Len(t, batch.GetResponses(), 1) {
+		assert.Equal(t, int32(codes.OK), batch.GetResponses()[0].GetStatus().GetCode())
+	}
+	assert.Equal(t, []string{
+		`"level"=0 "msg"="observed decision" "decision"="deny" "code"="PermissionDenied"`,
+		`"level"=0 "msg"="observed decision" "decision"="deny" "code"="PermissionDenied"`,
+	}, logs)
+}
@@ -0,0 +1,67 @@
+package authz
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errCheckPoolFull is returned when a check can't be queued, envoy applies the failure mode of its ext_authz filter
+var errCheckPoolFull = status.Error(codes.ResourceExhausted, "too many checks in flight, the check queue is full")
+
+// CheckPool bounds the number of checks processed concurrently by the servers it is shared by. A check waits in a
+// bounded queue for a worker and is rejected when the queue is full, instead of letting memory and latency grow
+// with the load. A nil CheckPool doesn't bound checks.
+type CheckPool struct {
+	// workers holds a token per check being processed
+	workers chan struct{}
+	// pending counts the checks processed or waiting for a worker
+	pending atomic.Int64
+	// capacity is the maximum number of pending checks
+	capacity int64
+	metrics  *metrics.Metrics
+}
+
+// NewCheckPool returns a pool processing up to workers checks concurrently, up to queueSize checks wait for a worker
+func NewCheckPool(workers, queueSize int, metrics *metrics.Metrics) *CheckPool {
+	return &CheckPool{
+		workers:  make(chan struct{}, workers),
+		capacity: int64(workers + queueSize),
+		metrics:  metrics,
+	}
+}
+
+// acquire waits for a worker and returns a function releasing it, it fails if the queue is full or the context ends first
+func (p *CheckPool) acquire(ctx context.Context) (func(), error) {
+	if p == nil {
+		return func() {}, nil
+	}
+	// reserve a place before waiting, the queue never grows beyond its size
+	if p.pending.Add(1) > p.capacity {
+		p.pending.Add(-1)
+		p.metrics.RecordCheckRejection()
+		return nil, errCheckPoolFull
+	}
+	release := func() {
+		<-p.workers
+		p.pending.Add(-1)
+	}
+	// take a free worker without queueing
+	select {
+	case p.workers <- struct{}{}:
+		return release, nil
+	default:
+	}
+	p.metrics.RecordCheckQueued(true)
+	defer p.metrics.RecordCheckQueued(false)
+	select {
+	case p.workers <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		p.pending.Add(-1)
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
@@ -0,0 +1,157 @@
+package authz
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+)
+
+// blockingProvider returns a provider whose policy blocks until released, started receives a value per evaluation
+func blockingProvider(t testing.TB, started chan<- struct{}, release <-chan struct{}) staticProvider {
+	allow := compile(t, "allow", admissionregistrationv1.Fail, `envoy.Allowed().Response()`)
+	return staticProvider{{
+		Name: "blocking",
+		Evaluate: func(ctx context.Context, r *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+			started <- struct{}{}
+			<-release
+			return allow.Evaluate(ctx, r)
+		},
+	}}
+}
+
+func TestCheckPool_saturated(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := metrics.New(registry)
+	require.NoError(t, err)
+	started := make(chan struct{}, 4)
+	release := make(chan struct{})
+	svc := &service{
+		provider:  blockingProvider(t, started, release),
+		checkPool: NewCheckPool(2, 2, m),
+	}
+	// two checks hold the workers and two wait in the queue
+	var group sync.WaitGroup
+	results := make(chan codes.Code, 4)
+	for range 4 {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			_, err := svc.Check(context.Background(), &authv3.CheckRequest{})
+			results <- status.Code(err)
+		}()
+	}
+	<-started
+	<-started
+	assert.Eventually(t, func() bool {
+		return svc.checkPool.pending.Load() == 4
+	}, 5*time.Second, 10*time.Millisecond)
+	// the queue is full, the check is rejected without waiting
+	_, err = svc.Check(context.Background(), &authv3.CheckRequest{})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	expected := `
+# HELP check_queue_depth Number of checks waiting for a worker of the check pool.
+# TYPE check_queue_depth gauge
+check_queue_depth 2
+# HELP check_rejections_total Number of checks rejected because the check queue was full.
+# TYPE check_rejections_total counter
+check_rejections_total 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "check_queue_depth", "check_rejections_total"))
+	// the queued checks are processed once the workers are released
+	close(release)
+	group.Wait()
+	close(results)
+	for code := range results {
+		assert.Equal(t, codes.OK, code)
+	}
+	expected = `
+# HELP check_queue_depth Number of checks waiting for a worker of the check pool.
+# TYPE check_queue_depth gauge
+check_queue_depth 0
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "check_queue_depth"))
+	assert.Equal(t, int64(0), svc.checkPool.pending.Load())
+}
+
+func TestCheckPool_cancelled(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+	svc := &service{
+		provider:  blockingProvider(t, started, release),
+		checkPool: NewCheckPool(1, 1, nil),
+	}
+	go func() {
+		_, _ = svc.Check(context.Background(), &authv3.CheckRequest{})
+	}()
+	<-started
+	// a queued check gives up its place when its context ends
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := svc.Check(ctx, &authv3.CheckRequest{})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.Equal(t, int64(1), svc.checkPool.pending.Load())
+}
+
+func TestCheckPool_nil(t *testing.T) {
+	var pool *CheckPool
+	release, err := pool.acquire(context.Background())
+	assert.NoError(t, err)
+	release()
+}
+
+// BenchmarkCheckPool_burst sends a burst of checks to slow policies, the checks beyond the pool capacity are
+// rejected and the heap stays bounded by the capacity instead of growing with the burst
+func BenchmarkCheckPool_burst(b *testing.B) {
+	const burst = 10000
+	pool := NewCheckPool(runtime.GOMAXPROCS(0), 100, nil)
+	var rejected int64
+	var heap uint64
+	for range b.N {
+		started := make(chan struct{}, burst)
+		release := make(chan struct{})
+		svc := &service{
+			provider:  blockingProvider(b, started, release),
+			checkPool: pool,
+		}
+		var before, during runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		var burstRejected atomic.Int64
+		var group sync.WaitGroup
+		for range burst {
+			group.Add(1)
+			go func() {
+				defer group.Done()
+				if _, err := svc.Check(context.Background(), &authv3.CheckRequest{}); status.Code(err) == codes.ResourceExhausted {
+					burstRejected.Add(1)
+				}
+			}()
+		}
+		// every check of the burst is either pending or rejected
+		for pool.pending.Load()+burstRejected.Load() != burst {
+			runtime.Gosched()
+		}
+		runtime.ReadMemStats(&during)
+		close(release)
+		group.Wait()
+		rejected += burstRejected.Load()
+		heap += during.HeapInuse - min(before.HeapInuse, during.HeapInuse)
+	}
+	b.ReportMetric(float64(rejected)/float64(b.N), "rejected/burst")
+	b.ReportMetric(float64(heap)/float64(b.N)/1024, "heap-KiB/burst")
+}
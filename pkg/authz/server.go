@@ -2,30 +2,85 @@ package authz
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
+	"time"
 
 	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	authzv1alpha1 "github.com/kyverno/kyverno-envoy-plugin/apis/authz/v1alpha1"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
 	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/redact"
 	"github.com/kyverno/kyverno-envoy-plugin/pkg/server"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	grpcreflection "google.golang.org/grpc/reflection"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
-func NewServer(network, addr string, provider policy.Provider) server.ServerFunc {
+func NewServer(network, addr string, tlsConfig *tls.Config, provider policy.Provider, metrics *metrics.Metrics, tracerProvider trace.TracerProvider, decisionLogger DecisionLogger, redactor *redact.Redactor, decisionCache *DecisionCache, middlewares *DecisionChain, defaultDecision DefaultDecision, notReadyDecision DefaultDecision, evaluationMode EvaluationMode, concurrency int, policyTimeout time.Duration, shutdownTimeout time.Duration, reflection bool, authenticator *Authenticator, checkPool *CheckPool, costBudget *CostBudget, bodyLimit *BodyLimit, missingBody *DefaultDecision, allowTrail bool) server.ServerFunc {
 	return func(ctx context.Context) error {
-		// create a server
-		s := grpc.NewServer()
+		// create a server, serving tls when configured
+		var opts []grpc.ServerOption
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		// authenticate the callers when configured
+		if authenticator != nil {
+			opts = append(opts, authenticator.serverOptions()...)
+		}
+		s := grpc.NewServer(opts...)
 		// setup our authorization service
 		svc := &service{
-			provider: provider,
+			provider:         provider,
+			metrics:          metrics,
+			tracer:           newTracer(tracerProvider),
+			decisionLogger:   decisionLogger,
+			redactor:         redactor,
+			defaultDecision:  defaultDecision,
+			notReadyDecision: notReadyDecision,
+			evaluationMode:   evaluationMode,
+			concurrency:      concurrency,
+			policyTimeout:    policyTimeout,
+			decisionCache:    decisionCache,
+			checkPool:        checkPool,
+			middlewares:      middlewares,
+			costBudget:       costBudget,
+			bodyLimit:        bodyLimit,
+			missingBody:      missingBody,
+			allowTrail:       allowTrail,
 		}
 		// register our authorization service
 		authv3.RegisterAuthorizationServer(s, svc)
+		authzv1alpha1.RegisterBatchAuthorizationServer(s, svc)
+		// register health service
+		hs := health.NewServer()
+		healthpb.RegisterHealthServer(s, hs)
+		// register reflection service, it lets clients enumerate the services
+		if reflection {
+			grpcreflection.Register(s)
+		}
 		// create a listener
 		l, err := net.Listen(network, addr)
 		if err != nil {
 			return err
 		}
+		// create a wait group
+		var group wait.Group
+		// wait all tasks in the group are over
+		defer group.Wait()
+		// create a cancellable context
+		ctx, cancel := context.WithCancel(ctx)
+		// cancel context at the end
+		defer cancel()
+		// report health from the provider
+		group.StartWithContext(ctx, func(ctx context.Context) {
+			watchHealth(ctx, hs, provider, time.Second)
+		})
 		// run server
-		return server.RunGrpc(ctx, s, l)
+		return server.RunGrpc(ctx, s, l, shutdownTimeout)
 	}
 }
@@ -0,0 +1,209 @@
+package authz
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	authzv1alpha1 "github.com/kyverno/kyverno-envoy-plugin/apis/authz/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+)
+
+func TestNewServer_shutdown(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "authz.sock")
+	started := make(chan struct{})
+	release := make(chan struct{})
+	allow := compile(t, "allow", admissionregistrationv1.Fail, `envoy.Allowed().Response()`)
+	provider := staticProvider{{
+		Name: "slow",
+		Evaluate: func(ctx context.Context, r *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+			close(started)
+			<-release
+			return allow.Evaluate(ctx, r)
+		},
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// run server
+	serverErr := make(chan error)
+	go func() {
+		serverErr <- NewServer("unix", socket, nil, provider, nil, nil, nil, nil, nil, nil, DefaultDecision{}, DefaultDecision{}, "", 0, 0, 5*time.Second, false, nil, nil, nil, nil, nil, false).Run(ctx)
+	}()
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer conn.Close()
+	// start a request
+	type result struct {
+		response *authv3.CheckResponse
+		err      error
+	}
+	results := make(chan result)
+	go func() {
+		response, err := authv3.NewAuthorizationClient(conn).Check(context.Background(), &authv3.CheckRequest{}, grpc.WaitForReady(true))
+		results <- result{response, err}
+	}()
+	<-started
+	// shutdown while the request is in flight
+	cancel()
+	// give the server some time to start shutting down
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	got := <-results
+	assert.NoError(t, got.err)
+	assert.Equal(t, int32(codes.OK), got.response.GetStatus().GetCode())
+	assert.NoError(t, <-serverErr)
+}
+
+func TestNewServer_reflection(t *testing.T) {
+	tests := []struct {
+		name       string
+		reflection bool
+		want       []string
+		wantCode   codes.Code
+	}{{
+		name:       "enabled",
+		reflection: true,
+		want: []string{
+			"envoy.service.auth.v3.Authorization",
+			"kyverno.authz.v1alpha1.BatchAuthorization",
+			"grpc.health.v1.Health",
+			"grpc.reflection.v1.ServerReflection",
+			"grpc.reflection.v1alpha.ServerReflection",
+		},
+		wantCode: codes.OK,
+	}, {
+		name:       "disabled",
+		reflection: false,
+		wantCode:   codes.Unimplemented,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			socket := filepath.Join(t.TempDir(), "authz.sock")
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			// run server
+			serverErr := make(chan error)
+			go func() {
+				serverErr <- NewServer("unix", socket, nil, staticProvider{}, nil, nil, nil, nil, nil, nil, DefaultDecision{}, DefaultDecision{}, "", 0, 0, 5*time.Second, tt.reflection, nil, nil, nil, nil, nil, false).Run(ctx)
+			}()
+			conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			assert.NoError(t, err)
+			defer conn.Close()
+			// list services
+			stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx, grpc.WaitForReady(true))
+			assert.NoError(t, err)
+			assert.NoError(t, stream.Send(&reflectionpb.ServerReflectionRequest{
+				MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+			}))
+			response, err := stream.Recv()
+			assert.Equal(t, tt.wantCode, status.Code(err))
+			var got []string
+			for _, service := range response.GetListServicesResponse().GetService() {
+				got = append(got, service.GetName())
+			}
+			assert.ElementsMatch(t, tt.want, got)
+			cancel()
+			assert.NoError(t, <-serverErr)
+		})
+	}
+}
+
+func BenchmarkNewServer_batch(b *testing.B) {
+	const size = 100
+	deny := compile(b, "deny", admissionregistrationv1.Fail, `object.attributes.request.http.headers[?"x-team"].orValue("") == "bar" ? envoy.Denied(403).Response() : null`)
+	allow := compile(b, "allow", admissionregistrationv1.Fail, `envoy.Allowed().Response()`)
+	socket := filepath.Join(b.TempDir(), "authz.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// run server
+	serverErr := make(chan error)
+	go func() {
+		serverErr <- NewServer("unix", socket, nil, staticProvider{deny, allow}, nil, nil, nil, nil, nil, nil, DefaultDecision{}, DefaultDecision{}, "", 0, 0, 5*time.Second, false, nil, nil, nil, nil, nil, false).Run(ctx)
+	}()
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	requests := make([]*authv3.CheckRequest, size)
+	for i := range requests {
+		requests[i] = &authv3.CheckRequest{
+			Attributes: &authv3.AttributeContext{
+				Request: &authv3.AttributeContext_Request{
+					Http: &authv3.AttributeContext_HttpRequest{
+						Method:  "GET",
+						Path:    fmt.Sprintf("/orders/%d", i),
+						Headers: map[string]string{"x-team": "foo"},
+					},
+				},
+			},
+		}
+	}
+	// every iteration checks the same number of requests
+	b.Run(fmt.Sprintf("unary/requests=%d", size), func(b *testing.B) {
+		client := authv3.NewAuthorizationClient(conn)
+		for range b.N {
+			for _, request := range requests {
+				if _, err := client.Check(ctx, request, grpc.WaitForReady(true)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run(fmt.Sprintf("batch/requests=%d", size), func(b *testing.B) {
+		client := authzv1alpha1.NewBatchAuthorizationClient(conn)
+		for range b.N {
+			if _, err := client.BatchCheck(ctx, &authzv1alpha1.BatchCheckRequest{Requests: requests}, grpc.WaitForReady(true)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	cancel()
+	if err := <-serverErr; err != nil {
+		b.Fatal(err)
+	}
+}
+
+func TestNewServer_batch(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "authz.sock")
+	deny := compile(t, "deny", admissionregistrationv1.Fail, `object.attributes.request.http.headers[?"x-team"].orValue("") == "bar" ? envoy.Denied(403).Response() : null`)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// run server
+	serverErr := make(chan error)
+	go func() {
+		serverErr <- NewServer("unix", socket, nil, staticProvider{deny}, nil, nil, nil, nil, nil, nil, DefaultDecision{Decision: DecisionAllow}, DefaultDecision{}, "", 0, 0, 5*time.Second, false, nil, nil, nil, nil, nil, false).Run(ctx)
+	}()
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer conn.Close()
+	newRequest := func(team string) *authv3.CheckRequest {
+		return &authv3.CheckRequest{
+			Attributes: &authv3.AttributeContext{
+				Request: &authv3.AttributeContext_Request{
+					Http: &authv3.AttributeContext_HttpRequest{
+						Headers: map[string]string{"x-team": team},
+					},
+				},
+			},
+		}
+	}
+	response, err := authzv1alpha1.NewBatchAuthorizationClient(conn).BatchCheck(ctx, &authzv1alpha1.BatchCheckRequest{
+		Requests: []*authv3.CheckRequest{newRequest("foo"), newRequest("bar")},
+	}, grpc.WaitForReady(true))
+	assert.NoError(t, err)
+	assert.Len(t, response.GetResponses(), 2)
+	assert.Equal(t, int32(codes.OK), response.GetResponses()[0].GetStatus().GetCode())
+	assert.Equal(t, int32(codes.PermissionDenied), response.GetResponses()[1].GetStatus().GetCode())
+	cancel()
+	assert.NoError(t, <-serverErr)
+}
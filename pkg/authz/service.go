@@ -2,46 +2,456 @@ package authz
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync/atomic"
+	"time"
 
 	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	authzv1alpha1 "github.com/kyverno/kyverno-envoy-plugin/apis/authz/v1alpha1"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
 	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/redact"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 type service struct {
+	authzv1alpha1.UnimplementedBatchAuthorizationServer
 	provider policy.Provider
+	metrics  *metrics.Metrics
+	tracer   trace.Tracer
+	// decisionLogger records every decision, it is optional
+	decisionLogger DecisionLogger
+	// redactor redacts the request values found in the errors logged and recorded in spans, it is optional
+	redactor *redact.Redactor
+	// defaultDecision is used when no policy returned a response
+	defaultDecision DefaultDecision
+	// notReadyDecision is used until the provider synced
+	notReadyDecision DefaultDecision
+	// evaluationMode defines how the policy responses are combined, the context can override it
+	evaluationMode EvaluationMode
+	// concurrency is the maximum number of policies evaluated concurrently,
+	// policies are evaluated sequentially when it is lower than 2
+	concurrency int
+	// policyTimeout bounds the evaluation time of every policy, zero means no timeout
+	policyTimeout time.Duration
+	// decisionCache caches the decisions of the policies declaring a cache key, it is optional
+	decisionCache *DecisionCache
+	// checkPool bounds the number of checks processed concurrently, it is optional
+	checkPool *CheckPool
+	// middlewares process the decisions taken by the policies, they are optional
+	middlewares *DecisionChain
+	// costBudget bounds the cost of the policies evaluated for a check, it is optional
+	costBudget *CostBudget
+	// bodyLimit bounds the size of the request bodies, it is optional
+	bodyLimit *BodyLimit
+	// missingBody is the decision of the policies reading the body of a request envoy didn't forward, they are
+	// evaluated against the forwarded body when it is nil
+	missingBody *DefaultDecision
+	// allowTrail adds the enforced policies allowing a check to the attribution of allow decisions
+	allowTrail bool
+}
+
+// NewService returns the authorization service used by the servers, evaluating policies sequentially
+// without metrics nor tracing. It is meant to evaluate policies outside of a server, in tests for example.
+func NewService(provider policy.Provider, defaultDecision DefaultDecision, notReadyDecision DefaultDecision) authv3.AuthorizationServer {
+	return &service{
+		provider:         provider,
+		defaultDecision:  defaultDecision,
+		notReadyDecision: notReadyDecision,
+	}
+}
+
+// DecisionLogger records the decision taken for every checked request, it must not block. The context carries
+// the decision id, see DecisionID.
+type DecisionLogger interface {
+	Log(context.Context, *authv3.CheckRequest, *authv3.CheckResponse, error)
 }
 
 func (s *service) Check(ctx context.Context, r *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	// wait for a worker, rejected checks are not logged, they would flood the logs under load
+	release, err := s.checkPool.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 	// execute check
 	response, err := s.check(ctx, r)
 	// log error if any
 	if err != nil {
-		fmt.Println(err)
+		log.FromContext(ctx).Error(s.redactor.Error(r, err), "failed to check request")
+	}
+	// return response and error
+	return response, err
+}
+
+// BatchCheck checks every request of the batch against the same policies snapshot
+func (s *service) BatchCheck(ctx context.Context, r *authzv1alpha1.BatchCheckRequest) (*authzv1alpha1.BatchCheckResponse, error) {
+	// the requests of a batch are checked sequentially by a single worker
+	release, err := s.checkPool.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	// execute batch check
+	response, err := s.batchCheck(ctx, r)
+	// log error if any
+	if err != nil {
+		// the error can carry the values of any request of the batch
+		logged := err
+		for _, request := range r.GetRequests() {
+			logged = s.redactor.Error(request, logged)
+		}
+		log.FromContext(ctx).Error(logged, "failed to check batch")
 	}
 	// return response and error
 	return response, err
 }
 
-func (s *service) check(ctx context.Context, r *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+func (s *service) batchCheck(ctx context.Context, r *authzv1alpha1.BatchCheckRequest) (*authzv1alpha1.BatchCheckResponse, error) {
+	tracer := s.getTracer()
+	// readiness and policies are the same for every request of the batch
+	synced := s.provider.HasSynced()
+	var policies []policy.CompiledPolicy
+	if synced {
+		var err error
+		if policies, err = s.provider.CompiledPolicies(ctx); err != nil {
+			return nil, err
+		}
+	}
+	out := &authzv1alpha1.BatchCheckResponse{
+		Responses: make([]*authv3.CheckResponse, 0, len(r.GetRequests())),
+	}
+	for _, request := range r.GetRequests() {
+		// every request has its own decision id and span, continuing the trace of the request if any
+		ctx, id := withDecisionID(ctx, request)
+		ctx, span := tracer.Start(extractTraceContext(ctx, request), "Check", trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attribute.String(decisionIDAttribute, id)))
+		// like a unary check, the body is truncated before anything reads the request, or the request is rejected
+		request, rejected := s.bodyLimit.apply(request)
+		response := s.notReadyDecision.response()
+		switch {
+		case rejected != nil:
+			response = s.middlewares.process(ctx, request, rejected)
+		case synced:
+			response = s.middlewares.process(ctx, request, s.decide(ctx, tracer, request, policies))
+		}
+		// the remaining requests of a cancelled batch are not checked
+		if err := ctx.Err(); err != nil {
+			err = status.FromContextError(err).Err()
+			endSpan(span, decision(nil, err), err)
+			return nil, err
+		}
+		endSpan(span, decision(response, nil), nil)
+		s.logDecision(ctx, request, response, nil)
+		out.Responses = append(out.Responses, response)
+	}
+	return out, nil
+}
+
+func (s *service) getTracer() trace.Tracer {
+	if s.tracer == nil {
+		return noopTracer
+	}
+	return s.tracer
+}
+
+func (s *service) check(ctx context.Context, r *authv3.CheckRequest) (response *authv3.CheckResponse, err error) {
+	tracer := s.getTracer()
+	// the decision id is shared by the span, the decision record and the middlewares
+	ctx, id := withDecisionID(ctx, r)
+	// start a span, continuing the trace propagated by envoy if any
+	ctx, span := tracer.Start(extractTraceContext(ctx, r), "Check", trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attribute.String(decisionIDAttribute, id)))
+	// always end the span, whatever the outcome
+	defer func() {
+		endSpan(span, decision(response, err), s.redactor.Error(r, err))
+		s.logDecision(ctx, r, response, err)
+	}()
+	// the body is truncated before anything reads the request, or the request is rejected
+	r, rejected := s.bodyLimit.apply(r)
+	if rejected != nil {
+		// the middlewares process the rejection like any other decision, observe mode allows the request
+		return s.middlewares.process(ctx, r, rejected), nil
+	}
+	// the provider didn't load its policies yet, they may be incomplete so the default decision can't apply
+	if !s.provider.HasSynced() {
+		return s.notReadyDecision.response(), nil
+	}
 	// fetch compiled policies
 	policies, err := s.provider.CompiledPolicies(ctx)
 	if err != nil {
 		return nil, err
 	}
-	// iterate over policies
-	for _, policy := range policies {
-		// execute policy
-		response, err := policy(r)
-		// return error if any
-		if err != nil {
-			return nil, err
+	response = s.decide(ctx, tracer, r, policies)
+	// the middlewares process the decision before it is logged and sent back
+	response = s.middlewares.process(ctx, r, response)
+	// envoy cancelled the check or its deadline expired, the decision may be incomplete and nobody waits for it
+	if err := ctx.Err(); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	return response, nil
+}
+
+func (s *service) logDecision(ctx context.Context, r *authv3.CheckRequest, response *authv3.CheckResponse, err error) {
+	if s.decisionLogger != nil {
+		s.decisionLogger.Log(ctx, r, response, err)
+	}
+}
+
+// decide evaluates the policies in order and returns the response to send back to envoy
+func (s *service) decide(ctx context.Context, tracer trace.Tracer, r *authv3.CheckRequest, policies []policy.CompiledPolicy) *authv3.CheckResponse {
+	// the policies share the tokens and documents parsed from the request
+	ctx, _ = utils.WithMemo(ctx)
+	// and the cost budget of the check
+	ctx = s.costBudget.start(ctx)
+	if s.missingBody != nil {
+		ctx = core.WithBodyRequired(ctx, s.missingBody.response())
+	}
+	if !s.allowTrail {
+		return s.combine(ctx, tracer, r, policies)
+	}
+	ctx, trail := withAllowTrail(ctx, policies)
+	return trail.apply(s.combine(ctx, tracer, r, policies))
+}
+
+// combine evaluates the policies in order and combines their responses according to the evaluation mode
+func (s *service) combine(ctx context.Context, tracer trace.Tracer, r *authv3.CheckRequest, policies []policy.CompiledPolicy) *authv3.CheckResponse {
+	if evaluationMode(ctx, s.evaluationMode) == EvaluationModeFirstMatch {
+		return s.firstMatch(ctx, tracer, r, policies)
+	}
+	// a deny overrides any allow whatever the priority, the first deny and every allow are kept
+	var allowed []*authv3.CheckResponse
+	var denied *authv3.CheckResponse
+	resolve := func(responses ...*authv3.CheckResponse) {
+		for _, response := range responses {
+			if response == nil {
+				continue
+			}
+			if response.GetStatus().GetCode() != int32(codes.OK) {
+				if denied == nil {
+					denied = response
+				}
+			} else {
+				allowed = append(allowed, response)
+			}
+		}
+	}
+	// iterate over policies, until the check is cancelled
+	for i := 0; i < len(policies) && ctx.Err() == nil; {
+		switch {
+		// the first override policy returning a response decides, even if the request was denied
+		case policies[i].Override:
+			if response := s.evaluate(ctx, tracer, r, policies[i]); response != nil {
+				return response
+			}
+			i++
+		// once denied, only override policies can change the decision
+		case denied != nil:
+			i++
+		// evaluate a single policy when concurrency is disabled or the policy is sequential
+		case s.concurrency <= 1 || policies[i].Sequential:
+			resolve(s.evaluate(ctx, tracer, r, policies[i]))
+			i++
+		// evaluate the following non sequential policies concurrently
+		default:
+			batch := i + 1
+			for batch < len(policies) && !policies[batch].Sequential && !policies[batch].Override {
+				batch++
+			}
+			resolve(s.evaluateConcurrently(ctx, tracer, r, policies[i:batch], isDenied)...)
+			i = batch
+		}
+	}
+	if denied != nil {
+		return denied
+	}
+	// the header mutations of the allows are merged once every policy was evaluated
+	if len(allowed) > 0 {
+		return core.MergeAllows(allowed)
+	}
+	// we didn't have a response, use the default decision
+	return s.defaultDecision.response()
+}
+
+// firstMatch evaluates the policies in order and returns the first response, allow or deny.
+// Override policies are not special, the first policy returning a response always decides.
+func (s *service) firstMatch(ctx context.Context, tracer trace.Tracer, r *authv3.CheckRequest, policies []policy.CompiledPolicy) *authv3.CheckResponse {
+	for i := 0; i < len(policies) && ctx.Err() == nil; {
+		var response *authv3.CheckResponse
+		if s.concurrency <= 1 || policies[i].Sequential {
+			response = s.evaluate(ctx, tracer, r, policies[i])
+			i++
+		} else {
+			// evaluate the following non sequential policies concurrently, the first response among them wins
+			batch := i + 1
+			for batch < len(policies) && !policies[batch].Sequential {
+				batch++
+			}
+			for _, batched := range s.evaluateConcurrently(ctx, tracer, r, policies[i:batch], isResponse) {
+				if batched != nil {
+					response = batched
+					break
+				}
+			}
+			i = batch
 		}
-		// if the reponse returned by the policy evaluation was not nil, return
 		if response != nil {
-			return response, nil
+			return response
+		}
+	}
+	// we didn't have a response, use the default decision
+	return s.defaultDecision.response()
+}
+
+func isDenied(response *authv3.CheckResponse) bool {
+	return response != nil && response.GetStatus().GetCode() != int32(codes.OK)
+}
+
+func isResponse(response *authv3.CheckResponse) bool {
+	return response != nil
+}
+
+// evaluate evaluates a single policy and returns the response to send back to envoy, if any
+func (s *service) evaluate(ctx context.Context, tracer trace.Tracer, r *authv3.CheckRequest, policy policy.CompiledPolicy) *authv3.CheckResponse {
+	// policies outside of their activation take no decision
+	if !policy.Activation.Active() {
+		s.metrics.RecordPolicyDecision(policy.Namespace, policy.Name, metrics.DecisionSkip)
+		return nil
+	}
+	// shed the policy when the budget left doesn't cover its estimated cost
+	if !s.costBudget.allows(ctx, policy) {
+		s.metrics.RecordCostBudgetSkip(policy.Name)
+		s.metrics.RecordPolicyDecision(policy.Namespace, policy.Name, metrics.DecisionSkip)
+		log.FromContext(ctx).V(1).Info("policy skipped, cost budget exceeded", "policy", policy.Name, "estimatedCost", policy.EstimatedCost)
+		// audit policies never affect the response
+		if policy.Mode == hub.EnforcementModeAudit {
+			return nil
 		}
+		return s.costBudget.skipped()
+	}
+	// execute policy
+	evalCtx, span := tracer.Start(ctx, "Evaluate", trace.WithAttributes(
+		attribute.String("policy.name", policy.Name),
+		attribute.String("policy.mode", string(policy.Mode)),
+	))
+	// bound the evaluation time
+	if s.policyTimeout > 0 {
+		var cancel context.CancelFunc
+		evalCtx, cancel = context.WithTimeout(evalCtx, s.policyTimeout)
+		defer cancel()
+	}
+	// accumulate the actual cost of the policy expressions
+	evalCtx, cost := core.WithEvaluationCost(evalCtx)
+	// trace the evaluation when the check is explained
+	evalCtx, explain := explainPolicy(evalCtx, policy)
+	start := time.Now()
+	response, err := s.evaluatePolicy(evalCtx, r, policy)
+	// the policy obeyed its failure policy, an error is only returned with failurePolicy=Fail
+	if s.policyTimeout > 0 && ctx.Err() == nil && errors.Is(evalCtx.Err(), context.DeadlineExceeded) {
+		s.metrics.RecordEvaluationTimeout(policy.Name)
+		if err != nil {
+			err = fmt.Errorf("policy evaluation timed out after %s: %w", s.policyTimeout, err)
+		}
+	}
+	// the error is logged, traced and can be returned in the response, it must not leak request values
+	err = s.redactor.Error(r, err)
+	// record evaluation metrics and end the policy span
+	outcome, duration := decision(response, err), time.Since(start)
+	s.metrics.RecordEvaluation(evalCtx, policy.Name, string(policy.Mode), outcome, duration)
+	s.metrics.RecordPolicyDecision(policy.Namespace, policy.Name, outcome)
+	explain(outcome, response, err, duration)
+	s.metrics.RecordEvaluationCost(policy.Name, cost.Total())
+	s.costBudget.spend(ctx, cost.Total())
+	endSpan(span, outcome, err)
+	// audit policies never affect the response
+	if policy.Mode == hub.EnforcementModeAudit {
+		if outcome != metrics.DecisionNone {
+			log.FromContext(ctx).Info("audit policy decision", "policy", policy.Name, "decision", outcome, "error", err)
+		}
+		return nil
+	}
+	// policies with failurePolicy=Ignore don't return errors,
+	// an error means failurePolicy=Fail so we deny the request
+	if err != nil {
+		// the check was cancelled, the failure is not the policy's fault
+		if ctx.Err() != nil {
+			return core.Failed(err)
+		}
+		logger := log.FromContext(ctx).WithValues("policy", policy.Name)
+		// report the failing expression, other errors come from alternative compilers
+		var evalErr *core.EvaluationError
+		if errors.As(err, &evalErr) {
+			logger = logger.WithValues("field", evalErr.Field)
+		}
+		logger.Error(err, "policy evaluation failed")
+		return core.Failed(err)
+	}
+	allowTrailFromContext(ctx).record(policy, response)
+	return response
+}
+
+// evaluateConcurrently evaluates policies with a bounded number of workers and returns their responses in order, up
+// to the first response stopping the evaluation (a deny when evaluating all policies). Policies are not started
+// anymore once a response stopped the evaluation, but policies that come before it are still awaited so that the
+// responses are the ones a sequential evaluation returns.
+func (s *service) evaluateConcurrently(ctx context.Context, tracer trace.Tracer, r *authv3.CheckRequest, policies []policy.CompiledPolicy, stops func(*authv3.CheckResponse) bool) []*authv3.CheckResponse {
+	responses := make([]*authv3.CheckResponse, len(policies))
+	// index of the first policy that stopped the evaluation
+	var firstStop atomic.Int64
+	firstStop.Store(int64(len(policies)))
+	// feed the workers with policy indices
+	indices := make(chan int, len(policies))
+	for i := range policies {
+		indices <- i
+	}
+	close(indices)
+	// create a wait group
+	var group wait.Group
+	for range min(s.concurrency, len(policies)) {
+		group.Start(func() {
+			for i := range indices {
+				// skip policies after the first stop, and everything once the request is cancelled
+				if ctx.Err() != nil || int64(i) > firstStop.Load() {
+					continue
+				}
+				response := s.evaluate(ctx, tracer, r, policies[i])
+				responses[i] = response
+				if stops(response) {
+					for {
+						current := firstStop.Load()
+						if int64(i) >= current || firstStop.CompareAndSwap(current, int64(i)) {
+							break
+						}
+					}
+				}
+			}
+		})
+	}
+	// wait all workers are over
+	group.Wait()
+	// the responses of the policies evaluated after the first stop are dropped
+	if i := firstStop.Load(); i < int64(len(policies)) {
+		return responses[:i+1]
+	}
+	return responses
+}
+
+func decision(response *authv3.CheckResponse, err error) string {
+	if err != nil {
+		return metrics.DecisionError
+	}
+	if response == nil {
+		return metrics.DecisionNone
+	}
+	if response.GetStatus().GetCode() == int32(codes.OK) {
+		return metrics.DecisionAllow
 	}
-	// we didn't have a response
-	return nil, nil
+	return metrics.DecisionDeny
 }
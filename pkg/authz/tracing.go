@@ -0,0 +1,50 @@
+package authz
+
+import (
+	"context"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const tracerName = "github.com/kyverno/kyverno-envoy-plugin/pkg/authz"
+
+// decisionIDAttribute is the check span attribute carrying the decision id
+const decisionIDAttribute = "decision.id"
+
+// propagator extracts the trace context envoy forwards in the request headers
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// noopTracer is used when no tracer provider was configured
+var noopTracer = noop.NewTracerProvider().Tracer(tracerName)
+
+func newTracer(provider trace.TracerProvider) trace.Tracer {
+	if provider == nil {
+		return noopTracer
+	}
+	return provider.Tracer(tracerName)
+}
+
+// extractTraceContext returns a context carrying the trace context found in the check request headers,
+// envoy uses lower case header names so a map carrier is enough
+func extractTraceContext(ctx context.Context, r *authv3.CheckRequest) context.Context {
+	headers := r.GetAttributes().GetRequest().GetHttp().GetHeaders()
+	if len(headers) == 0 {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier(headers))
+}
+
+// endSpan records the decision and error (if any) on the span and ends it
+func endSpan(span trace.Span, outcome string, err error) {
+	span.SetAttributes(attribute.String("decision", outcome))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	span.End()
+}
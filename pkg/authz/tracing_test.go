@@ -0,0 +1,250 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/go-logr/logr/funcr"
+	authzv1alpha1 "github.com/kyverno/kyverno-envoy-plugin/apis/authz/v1alpha1"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/redact"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func Test_service_Check_tracing(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const parentID = "00f067aa0ba902b7"
+	// accessing a missing header fails at runtime
+	const failing = `object.attributes.request.http.headers["missing"] == "foo" ? envoy.Allowed().Response() : null`
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	svc := &service{
+		provider: staticProvider{
+			compile(t, "none", admissionregistrationv1.Fail, `false ? envoy.Allowed().Response() : null`),
+			compile(t, "failing", admissionregistrationv1.Fail, failing),
+		},
+		tracer: newTracer(provider),
+	}
+	request := &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Headers: map[string]string{
+						"traceparent": "00-" + traceID + "-" + parentID + "-01",
+					},
+				},
+			},
+		},
+	}
+	_, err := svc.Check(context.Background(), request)
+	assert.NoError(t, err)
+	spans := recorder.Ended()
+	assert.Len(t, spans, 3)
+	// policy spans end first
+	none, errored, check := spans[0], spans[1], spans[2]
+	// the check span continues the trace propagated by envoy
+	assert.Equal(t, "Check", check.Name())
+	assert.Equal(t, trace.SpanKindServer, check.SpanKind())
+	assert.Equal(t, traceID, check.SpanContext().TraceID().String())
+	assert.Equal(t, parentID, check.Parent().SpanID().String())
+	assert.True(t, check.Parent().IsRemote())
+	assert.Contains(t, check.Attributes(), attribute.String("decision", "deny"))
+	// policy spans are children of the check span
+	for _, span := range []sdktrace.ReadOnlySpan{none, errored} {
+		assert.Equal(t, "Evaluate", span.Name())
+		assert.Equal(t, check.SpanContext().SpanID(), span.Parent().SpanID())
+	}
+	assert.Contains(t, none.Attributes(), attribute.String("policy.name", "none"))
+	assert.Contains(t, none.Attributes(), attribute.String("decision", "none"))
+	assert.Equal(t, otelcodes.Unset, none.Status().Code)
+	assert.Contains(t, errored.Attributes(), attribute.String("policy.name", "failing"))
+	assert.Contains(t, errored.Attributes(), attribute.String("decision", "error"))
+	assert.Equal(t, otelcodes.Error, errored.Status().Code)
+}
+
+func Test_service_Check_tracingNoop(t *testing.T) {
+	// a service without tracer provider uses a no-op tracer
+	svc := &service{
+		provider: staticProvider{
+			compile(t, "allow", admissionregistrationv1.Fail, `envoy.Allowed().Response()`),
+		},
+	}
+	_, err := svc.Check(context.Background(), &authv3.CheckRequest{})
+	assert.NoError(t, err)
+}
+
+// durationExemplars returns the trace ids of the exemplars recorded by the evaluation latency histogram
+func durationExemplars(t *testing.T, registry *prometheus.Registry) []string {
+	t.Helper()
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+	var out []string
+	for _, family := range families {
+		if family.GetName() != "policy_evaluation_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, bucket := range metric.GetHistogram().GetBucket() {
+				for _, label := range bucket.GetExemplar().GetLabel() {
+					if label.GetName() == "trace_id" {
+						out = append(out, label.GetValue())
+					}
+				}
+			}
+		}
+	}
+	return out
+}
+
+func Test_service_Check_tracingExemplars(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	request := &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Headers: map[string]string{
+						"traceparent": "00-" + traceID + "-00f067aa0ba902b7-01",
+					},
+				},
+			},
+		},
+	}
+	tests := []struct {
+		name     string
+		provider trace.TracerProvider
+		want     []string
+	}{{
+		name:     "tracing",
+		provider: sdktrace.NewTracerProvider(),
+		want:     []string{traceID},
+	}, {
+		name:     "not sampled",
+		provider: sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.NeverSample())),
+	}, {
+		// the propagated trace context is valid but the spans are not recorded
+		name:     "no tracing",
+		provider: noop.NewTracerProvider(),
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+			m, err := metrics.New(registry)
+			assert.NoError(t, err)
+			svc := &service{
+				provider: staticProvider{
+					compile(t, "allow", admissionregistrationv1.Fail, `envoy.Allowed().Response()`),
+				},
+				metrics: m,
+				tracer:  newTracer(tt.provider),
+			}
+			_, err = svc.Check(context.Background(), request)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, durationExemplars(t, registry))
+		})
+	}
+}
+
+func Test_service_Check_tracingRedaction(t *testing.T) {
+	// the missing key error quotes the header values
+	const failing = `{"1": 1}[object.attributes.request.http.headers["authorization"]] + {"1": 1}[object.attributes.request.http.headers["x-api-key"]] == 0 ? envoy.Allowed().Response() : null`
+	redactor, err := redact.New([]redact.Rule{{Header: "x-api-key", Action: redact.ActionMask}}, "")
+	assert.NoError(t, err)
+	recorder := tracetest.NewSpanRecorder()
+	svc := &service{
+		provider: staticProvider{compile(t, "failing", admissionregistrationv1.Fail, failing)},
+		tracer:   newTracer(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))),
+		redactor: redactor,
+	}
+	newRequest := func(authorization string) *authv3.CheckRequest {
+		return &authv3.CheckRequest{
+			Attributes: &authv3.AttributeContext{
+				Request: &authv3.AttributeContext_Request{
+					Http: &authv3.AttributeContext_HttpRequest{
+						Headers: map[string]string{"authorization": authorization, "x-api-key": "s3cret"},
+					},
+				},
+			},
+		}
+	}
+	var logs []string
+	ctx := log.IntoContext(context.Background(), funcr.New(func(_, args string) {
+		logs = append(logs, args)
+	}, funcr.Options{}))
+	for _, authorization := range []string{"Bearer token", "1"} {
+		response, err := svc.Check(ctx, newRequest(authorization))
+		assert.NoError(t, err)
+		assert.NotContains(t, response.GetStatus().GetMessage(), "token")
+		assert.NotContains(t, response.GetStatus().GetMessage(), "s3cret")
+	}
+	// the authorization header, then the api key, are missing keys
+	assert.Len(t, logs, 2)
+	spans := recorder.Ended()
+	assert.Len(t, spans, 4)
+	assert.Contains(t, spans[0].Status().Description, redact.Mask)
+	assert.Contains(t, spans[2].Status().Description, redact.Mask)
+	for _, text := range append(logs, spans[0].Status().Description, spans[2].Status().Description) {
+		assert.NotContains(t, text, "token")
+		assert.NotContains(t, text, "s3cret")
+	}
+	for _, span := range spans {
+		for _, event := range span.Events() {
+			for _, attribute := range event.Attributes {
+				assert.NotContains(t, attribute.Value.Emit(), "token")
+				assert.NotContains(t, attribute.Value.Emit(), "s3cret")
+			}
+		}
+	}
+}
+
+// failingProvider fails to return its policies
+type failingProvider struct {
+	staticProvider
+	err error
+}
+
+func (p failingProvider) CompiledPolicies(context.Context) ([]policy.CompiledPolicy, error) {
+	return nil, p.err
+}
+
+func Test_service_BatchCheck_redaction(t *testing.T) {
+	redactor, err := redact.New([]redact.Rule{{Header: "x-api-key", Action: redact.ActionMask}}, "")
+	assert.NoError(t, err)
+	svc := &service{
+		provider: failingProvider{err: errors.New(`unexpected key "s3cret"`)},
+		redactor: redactor,
+	}
+	newRequest := func(key string) *authv3.CheckRequest {
+		return &authv3.CheckRequest{
+			Attributes: &authv3.AttributeContext{
+				Request: &authv3.AttributeContext_Request{
+					Http: &authv3.AttributeContext_HttpRequest{
+						Headers: map[string]string{"x-api-key": key},
+					},
+				},
+			},
+		}
+	}
+	var logs []string
+	ctx := log.IntoContext(context.Background(), funcr.New(func(_, args string) {
+		logs = append(logs, args)
+	}, funcr.Options{}))
+	// the value is redacted whatever the request of the batch it belongs to
+	_, err = svc.BatchCheck(ctx, &authzv1alpha1.BatchCheckRequest{Requests: []*authv3.CheckRequest{newRequest("other"), newRequest("s3cret")}})
+	assert.Error(t, err)
+	if assert.Len(t, logs, 1) {
+		assert.Contains(t, logs[0], redact.Mask)
+		assert.NotContains(t, logs[0], "s3cret")
+	}
+}
@@ -0,0 +1,262 @@
+package explain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	outputText  = "text"
+	outputJson  = "json"
+	formatJson  = "json"
+	formatProto = "proto"
+)
+
+func Command() *cobra.Command {
+	var policyPaths []string
+	var output string
+	var inputFormat string
+	var defaultDecision string
+	var defaultDenyStatus int32
+	var evaluationMode string
+	command := &cobra.Command{
+		Use:   "explain [check request file]",
+		Short: "Explain the decision taken for a check request",
+		Long:  "Evaluate a captured Envoy check request against policy files like the authz server does and explain, for every policy, whether it was evaluated and what it decided. The request is read from stdin when the file is - or missing.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != outputText && output != outputJson {
+				return fmt.Errorf("invalid output %q, expected %q or %q", output, outputText, outputJson)
+			}
+			if inputFormat != formatJson && inputFormat != formatProto {
+				return fmt.Errorf("invalid input format %q, expected %q or %q", inputFormat, formatJson, formatProto)
+			}
+			if len(policyPaths) == 0 {
+				return fmt.Errorf("at least one policy path is required")
+			}
+			defaults := authz.DefaultDecision{
+				Decision:   authz.Decision(defaultDecision),
+				DenyStatus: defaultDenyStatus,
+			}
+			if err := defaults.Validate(); err != nil {
+				return err
+			}
+			mode := authz.EvaluationMode(evaluationMode)
+			if err := mode.Validate(); err != nil {
+				return err
+			}
+			// the usage is not helpful once the arguments were validated
+			cmd.SilenceUsage = true
+			request, err := readRequest(cmd.InOrStdin(), args, inputFormat)
+			if err != nil {
+				return err
+			}
+			// load policies the same way the authz server does
+			provider, err := policy.NewFileProvider(policy.NewCompiler(), nil, false, policyPaths...)
+			if err != nil {
+				return err
+			}
+			report, err := explain(authz.WithEvaluationMode(cmd.Context(), mode), provider, defaults, request)
+			if err != nil {
+				return err
+			}
+			return writeReport(cmd.OutOrStdout(), output, report)
+		},
+	}
+	command.Flags().StringArrayVar(&policyPaths, "policy-path", nil, "Files, directories or glob patterns to load policies from")
+	command.Flags().StringVarP(&output, "output", "o", outputText, "Output format (text or json)")
+	command.Flags().StringVar(&inputFormat, "input-format", formatJson, "Format of the check request (json, yaml is accepted too, or proto for the binary encoding)")
+	command.Flags().StringVar(&defaultDecision, "default-decision", string(authz.DecisionDeny), "Decision taken when no policy returned a response (Allow or Deny)")
+	command.Flags().Int32Var(&defaultDenyStatus, "default-deny-status", 403, "HTTP status code returned when the default decision denies a request")
+	command.Flags().StringVar(&evaluationMode, "evaluation-mode", string(authz.EvaluationModeEvaluateAll), "How policy responses are combined into a decision (EvaluateAll or FirstMatch)")
+	return command
+}
+
+// readRequest reads the check request from the file given as argument, or stdin
+func readRequest(stdin io.Reader, args []string, format string) (*authv3.CheckRequest, error) {
+	var data []byte
+	var err error
+	if len(args) == 0 || args[0] == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the check request: %w", err)
+	}
+	var request authv3.CheckRequest
+	if format == formatProto {
+		if err := proto.Unmarshal(data, &request); err != nil {
+			return nil, fmt.Errorf("invalid check request: %w", err)
+		}
+		return &request, nil
+	}
+	// json is valid yaml
+	data, err = yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid check request: %w", err)
+	}
+	if err := protojson.Unmarshal(data, &request); err != nil {
+		return nil, fmt.Errorf("invalid check request: %w", err)
+	}
+	return &request, nil
+}
+
+// PolicyResult explains the outcome of a policy
+type PolicyResult struct {
+	Policy string `json:"policy"`
+	Mode   string `json:"mode"`
+	// Evaluated is false when the decision was taken before the policy was evaluated
+	Evaluated bool `json:"evaluated"`
+	// Skipped is the condition that skipped the policy
+	Skipped string `json:"skipped,omitempty"`
+	// Decision is allow, deny, error or none
+	Decision      string `json:"decision,omitempty"`
+	Authorization string `json:"authorization,omitempty"`
+	Status        int32  `json:"status,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// Result is the final decision
+type Result struct {
+	Decision authz.Decision `json:"decision"`
+	Status   int32          `json:"status,omitempty"`
+	// Policy is the policy that took the decision, empty when the default decision applied
+	Policy string `json:"policy,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Report explains the decision taken for a check request
+type Report struct {
+	Policies []PolicyResult `json:"policies"`
+	Result   Result         `json:"result"`
+}
+
+// explain checks the request with the authorization service the servers use and reports the evaluation of every policy
+func explain(ctx context.Context, provider policy.Provider, defaults authz.DefaultDecision, request *authv3.CheckRequest) (Report, error) {
+	ctx, explanation := authz.WithExplanation(ctx)
+	response, err := authz.NewService(provider, defaults, authz.DefaultDecision{}).Check(ctx, request)
+	if err != nil {
+		return Report{}, err
+	}
+	policies, err := provider.CompiledPolicies(ctx)
+	if err != nil {
+		return Report{}, err
+	}
+	evaluated := map[string]authz.PolicyExplanation{}
+	for _, explained := range explanation.Policies() {
+		evaluated[explained.Policy] = explained
+	}
+	var report Report
+	for _, compiled := range policies {
+		result := PolicyResult{
+			Policy: compiled.Name,
+			Mode:   string(compiled.Mode),
+		}
+		if explained, ok := evaluated[compiled.Name]; ok {
+			result.Evaluated = true
+			result.Skipped = explained.Skipped
+			result.Decision = explained.Decision
+			result.Authorization = explained.Authorization
+			result.Status, result.Reason = describeResponse(explained.Response)
+			if explained.Err != nil {
+				result.Error = explained.Err.Error()
+			}
+		}
+		report.Policies = append(report.Policies, result)
+	}
+	report.Result.Decision = authz.DecisionDeny
+	if response.GetStatus().GetCode() == int32(codes.OK) {
+		report.Result.Decision = authz.DecisionAllow
+	}
+	report.Result.Status, report.Result.Reason = describeResponse(response)
+	if attribution := response.GetDynamicMetadata().GetFields()[policy.MetadataKey].GetStructValue(); attribution != nil {
+		report.Result.Policy = attribution.GetFields()[policy.MetadataPolicyKey].GetStringValue()
+	}
+	return report, nil
+}
+
+// describeResponse returns the http status of a denied response and the reason of the decision, if any
+func describeResponse(response *authv3.CheckResponse) (int32, string) {
+	var status int32
+	if response != nil && response.GetStatus().GetCode() != int32(codes.OK) {
+		status = int32(response.GetDeniedResponse().GetStatus().GetCode())
+	}
+	attribution := response.GetDynamicMetadata().GetFields()[policy.MetadataKey].GetStructValue()
+	return status, attribution.GetFields()[policy.MetadataReasonKey].GetStringValue()
+}
+
+func writeReport(out io.Writer, output string, report Report) error {
+	if output == outputJson {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	for _, result := range report.Policies {
+		if _, err := fmt.Fprintf(out, "%s (%s): %s\n", result.Policy, result.Mode, describePolicy(result)); err != nil {
+			return err
+		}
+	}
+	parts := []string{string(report.Result.Decision)}
+	if report.Result.Decision == authz.DecisionDeny {
+		parts = append(parts, fmt.Sprintf("status %d", report.Result.Status))
+	}
+	if report.Result.Policy != "" {
+		parts = append(parts, fmt.Sprintf("policy %s", report.Result.Policy))
+	} else {
+		parts = append(parts, "default decision")
+	}
+	if report.Result.Reason != "" {
+		parts = append(parts, fmt.Sprintf("reason %q", report.Result.Reason))
+	}
+	_, err := fmt.Fprintf(out, "\nDecision: %s\n", strings.Join(parts, ", "))
+	return err
+}
+
+// describePolicy returns a human readable description of a policy result
+func describePolicy(result PolicyResult) string {
+	if !result.Evaluated {
+		return "not evaluated, the decision was already taken"
+	}
+	var parts []string
+	switch {
+	case result.Skipped != "":
+		parts = append(parts, fmt.Sprintf("skipped by %s", result.Skipped))
+	case result.Decision == "none":
+		parts = append(parts, "no decision")
+	default:
+		parts = append(parts, result.Decision)
+	}
+	if result.Status != 0 {
+		parts = append(parts, fmt.Sprintf("status %d", result.Status))
+	}
+	if result.Authorization != "" {
+		parts = append(parts, fmt.Sprintf("returned by %s", result.Authorization))
+	}
+	if result.Reason != "" {
+		parts = append(parts, fmt.Sprintf("reason %q", result.Reason))
+	}
+	if result.Error != "" {
+		// the failure policy ignored the error when the decision is not an error
+		if result.Decision == "error" {
+			parts = append(parts, result.Error)
+		} else {
+			parts = append(parts, fmt.Sprintf("ignored error: %s", result.Error))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
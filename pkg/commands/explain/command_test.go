@@ -0,0 +1,196 @@
+package explain
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const policies = `apiVersion: envoy.kyverno.io/v1alpha1
+kind: AuthorizationPolicy
+metadata:
+  name: admin-only
+spec:
+  matchConditions:
+  - name: admin path
+    expression: object.attributes.request.http.path.startsWith("/admin")
+  authorizations:
+  - expression: envoy.Denied(401).Response()
+---
+apiVersion: envoy.kyverno.io/v1alpha1
+kind: AuthorizationPolicy
+metadata:
+  name: read-only
+spec:
+  authorizations:
+  - expression: >
+      object.attributes.request.http.method == "GET"
+        ? envoy.Allowed().Response()
+        : null
+  - expression: envoy.Denied(405).Response()
+`
+
+// allowAll comes first in priority order and overlaps with the other policies
+const allowAll = `apiVersion: envoy.kyverno.io/v1alpha1
+kind: AuthorizationPolicy
+metadata:
+  name: allow-all
+spec:
+  priority: 10
+  authorizations:
+  - expression: envoy.Allowed().Response()
+`
+
+const get = `{"attributes": {"request": {"http": {"method": "GET", "path": "/"}}}}`
+
+const post = `attributes:
+  request:
+    http:
+      method: POST
+      path: /admin
+`
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func execute(stdin string, args ...string) (string, error) {
+	command := Command()
+	var out bytes.Buffer
+	command.SetIn(strings.NewReader(stdin))
+	command.SetOut(&out)
+	command.SetErr(&out)
+	command.SetArgs(args)
+	err := command.Execute()
+	return out.String(), err
+}
+
+func TestCommand(t *testing.T) {
+	dir := t.TempDir()
+	policy := writeFile(t, dir, "policy.yaml", policies)
+	request := writeFile(t, dir, "request.yaml", post)
+	var binary authv3.CheckRequest
+	assert.NoError(t, protojson.Unmarshal([]byte(get), &binary))
+	data, err := proto.Marshal(&binary)
+	assert.NoError(t, err)
+	binaryRequest := writeFile(t, dir, "request.bin", string(data))
+	tests := []struct {
+		name        string
+		stdin       string
+		args        []string
+		wantErr     string
+		wantContain []string
+	}{{
+		name:  "stdin",
+		stdin: get,
+		args:  []string{"--policy-path", policy},
+		wantContain: []string{
+			"admin-only (Enforce): skipped by spec.matchConditions[0]",
+			"read-only (Enforce): allow, returned by spec.authorizations[0].expression",
+			"Decision: Allow, policy read-only",
+		},
+	}, {
+		name: "file",
+		args: []string{"--policy-path", policy, request},
+		wantContain: []string{
+			"admin-only (Enforce): deny, status 401, returned by spec.authorizations[0].expression",
+			"read-only (Enforce): not evaluated",
+			"Decision: Deny, status 401, policy admin-only",
+		},
+	}, {
+		name: "proto",
+		args: []string{"--policy-path", policy, "--input-format", "proto", binaryRequest},
+		wantContain: []string{
+			"Decision: Allow, policy read-only",
+		},
+	}, {
+		name:  "default decision",
+		stdin: get,
+		args:  []string{"--policy-path", writeFile(t, dir, "admin.yaml", strings.Split(policies, "---")[0]), "--default-deny-status", "404", "-"},
+		wantContain: []string{
+			"admin-only (Enforce): skipped by spec.matchConditions[0]",
+			"Decision: Deny, status 404, default decision",
+		},
+	}, {
+		name:  "first match",
+		stdin: `{"attributes": {"request": {"http": {"method": "GET", "path": "/admin"}}}}`,
+		args:  []string{"--policy-path", writeFile(t, dir, "allow.yaml", allowAll+"---\n"+policies), "--evaluation-mode", "FirstMatch"},
+		wantContain: []string{
+			"allow-all (Enforce): allow, returned by spec.authorizations[0].expression",
+			"admin-only (Enforce): not evaluated",
+			"Decision: Allow, policy allow-all",
+		},
+	}, {
+		name:    "invalid evaluation mode",
+		stdin:   get,
+		args:    []string{"--policy-path", policy, "--evaluation-mode", "LastMatch"},
+		wantErr: `invalid evaluation mode "LastMatch"`,
+	}, {
+		name:    "invalid request",
+		stdin:   `{"attributes": 1}`,
+		args:    []string{"--policy-path", policy},
+		wantErr: "invalid check request",
+	}, {
+		name:    "missing policy path",
+		stdin:   get,
+		wantErr: "at least one policy path is required",
+	}, {
+		name:    "invalid input format",
+		args:    []string{"--policy-path", policy, "--input-format", "xml"},
+		wantErr: `invalid input format "xml"`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := execute(tt.stdin, tt.args...)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			for _, want := range tt.wantContain {
+				assert.Contains(t, out, want)
+			}
+		})
+	}
+}
+
+func TestCommand_json(t *testing.T) {
+	dir := t.TempDir()
+	policy := writeFile(t, dir, "policy.yaml", policies)
+	out, err := execute(`{"attributes": {"request": {"http": {"method": "DELETE", "path": "/"}}}}`, "--policy-path", policy, "--output", "json")
+	assert.NoError(t, err)
+	var report Report
+	assert.NoError(t, json.Unmarshal([]byte(out), &report))
+	assert.Equal(t, Report{
+		Policies: []PolicyResult{{
+			Policy:    "admin-only",
+			Mode:      "Enforce",
+			Evaluated: true,
+			Skipped:   "spec.matchConditions[0]",
+			Decision:  "none",
+		}, {
+			Policy:        "read-only",
+			Mode:          "Enforce",
+			Evaluated:     true,
+			Decision:      "deny",
+			Authorization: "spec.authorizations[1].expression",
+			Status:        405,
+		}},
+		Result: Result{
+			Decision: "Deny",
+			Status:   405,
+			Policy:   "read-only",
+		},
+	}, report)
+}
@@ -0,0 +1,103 @@
+package manifests
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/manifests"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	outputYAML = "yaml"
+	outputJSON = "json"
+)
+
+func Command() *cobra.Command {
+	var namespace string
+	var name string
+	var output string
+	var crdsOnly bool
+	var dataSources bool
+	command := &cobra.Command{
+		Use:   "manifests",
+		Short: "Print the CRDs and RBAC resources the authz server needs",
+		Long:  "Print the AuthorizationPolicy CRD and the minimal service account, roles and bindings the authz server needs, they match the version of the binary. The roles don't grant the permissions required by the k8s CEL library, add the resources read by the policies if they use it.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if output != outputYAML && output != outputJSON {
+				return fmt.Errorf("invalid output %q, expected %q or %q", output, outputYAML, outputJSON)
+			}
+			crds, err := manifests.CRDs()
+			if err != nil {
+				return err
+			}
+			objects := make([]runtime.Object, 0, len(crds))
+			for _, crd := range crds {
+				objects = append(objects, crd)
+			}
+			if !crdsOnly {
+				objects = append(objects, manifests.RBAC(manifests.RBACOptions{
+					Name:        name,
+					Namespace:   namespace,
+					DataSources: dataSources,
+				})...)
+			}
+			unstructured := make([]map[string]any, 0, len(objects))
+			for _, object := range objects {
+				content, err := toUnstructured(object)
+				if err != nil {
+					return err
+				}
+				unstructured = append(unstructured, content)
+			}
+			if output == outputJSON {
+				return printJSON(cmd.OutOrStdout(), unstructured)
+			}
+			return printYAML(cmd.OutOrStdout(), unstructured)
+		},
+	}
+	command.Flags().StringVarP(&namespace, "namespace", "n", "kyverno", "Namespace the authz server runs in, the namespaced RBAC resources are created in it")
+	command.Flags().StringVar(&name, "name", manifests.DefaultName, "Name of the service account, roles and bindings")
+	command.Flags().StringVarP(&output, "output", "o", outputYAML, "Output format (yaml or json), json prints a v1 List")
+	command.Flags().BoolVar(&crdsOnly, "crds-only", false, "Only print the CRDs")
+	command.Flags().BoolVar(&dataSources, "policy-data-sources", false, "Grant the permissions required by the --policy-data-sources flag of the authz server, it lists and watches the ConfigMaps and Secrets of the cluster")
+	return command
+}
+
+// toUnstructured converts the object without the fields set by the API server, the manifests are applied as is
+func toUnstructured(object runtime.Object) (map[string]any, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+	if err != nil {
+		return nil, err
+	}
+	delete(content, "status")
+	if metadata, ok := content["metadata"].(map[string]any); ok {
+		delete(metadata, "creationTimestamp")
+	}
+	return content, nil
+}
+
+// printYAML prints the objects as a multi documents yaml stream, it can be piped to kubectl apply
+func printYAML(out io.Writer, objects []map[string]any) error {
+	for _, object := range objects {
+		content, err := yaml.Marshal(object)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(out, "---\n%s", content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printJSON(out io.Writer, objects []map[string]any) error {
+	list := map[string]any{"apiVersion": "v1", "kind": "List", "items": objects}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(list)
+}
@@ -0,0 +1,88 @@
+package manifests
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func execute(t *testing.T, args ...string) string {
+	t.Helper()
+	command := Command()
+	var out bytes.Buffer
+	command.SetOut(&out)
+	command.SetArgs(args)
+	require.NoError(t, command.Execute())
+	return out.String()
+}
+
+// documents splits a yaml stream into its documents, keyed by kind
+func documents(t *testing.T, stream string) map[string]map[string]any {
+	t.Helper()
+	out := map[string]map[string]any{}
+	for _, document := range strings.Split(stream, "---\n") {
+		if document == "" {
+			continue
+		}
+		var object map[string]any
+		require.NoError(t, yaml.Unmarshal([]byte(document), &object))
+		out[object["kind"].(string)] = object
+	}
+	return out
+}
+
+func TestCommand(t *testing.T) {
+	objects := documents(t, execute(t, "--namespace", "authz"))
+	assert.Len(t, objects, 6)
+	for _, kind := range []string{"ServiceAccount", "Role", "RoleBinding"} {
+		assert.Equal(t, "authz", objects[kind]["metadata"].(map[string]any)["namespace"], kind)
+	}
+	// the manifests are applied as is, they have no server fields
+	assert.NotContains(t, objects["CustomResourceDefinition"], "status")
+	assert.NotContains(t, objects["ClusterRole"]["metadata"], "creationTimestamp")
+	// the CRD has every field of the current spec
+	content, err := yaml.Marshal(objects["CustomResourceDefinition"])
+	require.NoError(t, err)
+	var crd apiextensionsv1.CustomResourceDefinition
+	require.NoError(t, yaml.UnmarshalStrict(content, &crd))
+	require.Len(t, crd.Spec.Versions, 1)
+	assert.Equal(t, v1alpha1.SchemeGroupVersion.Version, crd.Spec.Versions[0].Name)
+	properties := crd.Spec.Versions[0].Schema.OpenAPIV3Schema.Properties["spec"].Properties
+	spec := reflect.TypeOf(v1alpha1.AuthorizationPolicySpec{})
+	for i := range spec.NumField() {
+		name, _, _ := strings.Cut(spec.Field(i).Tag.Get("json"), ",")
+		assert.Contains(t, properties, name)
+	}
+}
+
+func TestCommand_crdsOnly(t *testing.T) {
+	objects := documents(t, execute(t, "--crds-only"))
+	assert.Len(t, objects, 1)
+	assert.Contains(t, objects, "CustomResourceDefinition")
+}
+
+func TestCommand_json(t *testing.T) {
+	var list struct {
+		Kind  string           `json:"kind"`
+		Items []map[string]any `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(execute(t, "-o", "json")), &list))
+	assert.Equal(t, "List", list.Kind)
+	assert.Len(t, list.Items, 6)
+}
+
+func TestCommand_invalidOutput(t *testing.T) {
+	command := Command()
+	command.SetOut(&bytes.Buffer{})
+	command.SetErr(&bytes.Buffer{})
+	command.SetArgs([]string{"-o", "xml"})
+	assert.ErrorContains(t, command.Execute(), `invalid output "xml"`)
+}
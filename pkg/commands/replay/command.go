@@ -0,0 +1,187 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/policytest"
+	"github.com/spf13/cobra"
+)
+
+const (
+	outputText = "text"
+	outputJson = "json"
+)
+
+func Command() *cobra.Command {
+	var policyPaths []string
+	var output string
+	var baselinePath string
+	var defaultDecision string
+	var defaultDenyStatus int32
+	var evaluationMode string
+	command := &cobra.Command{
+		Use:   "replay [corpus directory]",
+		Short: "Replay a corpus of recorded check requests against policies",
+		Long:  "Evaluate the check requests recorded in the files of a directory against policy files like the authz server does, and report the decision taken for every request and their distribution. With a baseline, a report saved with the json output, the command reports the requests whose decision changed and fails if any did.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != outputText && output != outputJson {
+				return fmt.Errorf("invalid output %q, expected %q or %q", output, outputText, outputJson)
+			}
+			if len(policyPaths) == 0 {
+				return fmt.Errorf("at least one policy path is required")
+			}
+			defaults := authz.DefaultDecision{
+				Decision:   authz.Decision(defaultDecision),
+				DenyStatus: defaultDenyStatus,
+			}
+			if err := defaults.Validate(); err != nil {
+				return err
+			}
+			mode := authz.EvaluationMode(evaluationMode)
+			if err := mode.Validate(); err != nil {
+				return err
+			}
+			// the usage is not helpful once the arguments were validated
+			cmd.SilenceUsage = true
+			var baseline *policytest.ReplayReport
+			if baselinePath != "" {
+				report, err := readBaseline(baselinePath)
+				if err != nil {
+					return err
+				}
+				baseline = &report
+			}
+			corpus, err := policytest.LoadCorpus(args[0])
+			if err != nil {
+				return err
+			}
+			// load policies the same way the authz server does
+			provider, err := policy.NewFileProvider(policy.NewCompiler(), nil, false, policyPaths...)
+			if err != nil {
+				return err
+			}
+			report := policytest.Replay(authz.WithEvaluationMode(cmd.Context(), mode), provider, defaults, corpus)
+			if baseline != nil {
+				diff := policytest.CompareReports(*baseline, report)
+				report.Diff = &diff
+			}
+			if err := writeReport(cmd.OutOrStdout(), output, report); err != nil {
+				return err
+			}
+			if report.Diff != nil && report.Diff.Changed > 0 {
+				return fmt.Errorf("%d of %d decisions changed from the baseline", report.Diff.Changed, report.Summary.Total-report.Diff.Added)
+			}
+			return nil
+		},
+	}
+	command.Flags().StringArrayVar(&policyPaths, "policy-path", nil, "Files, directories or glob patterns to load policies from")
+	command.Flags().StringVarP(&output, "output", "o", outputText, "Output format (text or json), the json report can be used as a baseline")
+	command.Flags().StringVar(&baselinePath, "baseline", "", "Report of a previous replay, in json, to compare the decisions with")
+	command.Flags().StringVar(&defaultDecision, "default-decision", string(authz.DecisionDeny), "Decision taken when no policy returned a response (Allow or Deny)")
+	command.Flags().Int32Var(&defaultDenyStatus, "default-deny-status", 403, "HTTP status code returned when the default decision denies a request")
+	command.Flags().StringVar(&evaluationMode, "evaluation-mode", string(authz.EvaluationModeEvaluateAll), "How policy responses are combined into a decision (EvaluateAll or FirstMatch)")
+	return command
+}
+
+func readBaseline(path string) (policytest.ReplayReport, error) {
+	var report policytest.ReplayReport
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return report, fmt.Errorf("failed to read the baseline: %w", err)
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return report, fmt.Errorf("invalid baseline %s: %w", path, err)
+	}
+	return report, nil
+}
+
+func writeReport(out io.Writer, output string, report policytest.ReplayReport) error {
+	if output == outputJson {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	for _, record := range report.Records {
+		if _, err := fmt.Fprintf(out, "%s: %s\n", record.Request, describe(&record)); err != nil {
+			return err
+		}
+	}
+	summary := report.Summary
+	if _, err := fmt.Fprintf(out, "\n%d requests: %d allowed, %d denied, %d errors\n", summary.Total, summary.Allowed, summary.Denied, summary.Errors); err != nil {
+		return err
+	}
+	statuses := make([]int32, 0, len(summary.Statuses))
+	for status := range summary.Statuses {
+		statuses = append(statuses, status)
+	}
+	slices.Sort(statuses)
+	for _, status := range statuses {
+		if _, err := fmt.Fprintf(out, "  status %d: %d\n", status, summary.Statuses[status]); err != nil {
+			return err
+		}
+	}
+	policies := make([]string, 0, len(summary.Policies))
+	for name := range summary.Policies {
+		policies = append(policies, name)
+	}
+	slices.Sort(policies)
+	for _, name := range policies {
+		label := "policy " + name
+		if name == "" {
+			label = "default decision"
+		}
+		if _, err := fmt.Fprintf(out, "  %s: %d\n", label, summary.Policies[name]); err != nil {
+			return err
+		}
+	}
+	if report.Diff == nil {
+		return nil
+	}
+	diff := report.Diff
+	if _, err := fmt.Fprintf(out, "\nBaseline: %d changed (%d new denies, %d new allows), %d added, %d removed\n", diff.Changed, diff.NewDenies, diff.NewAllows, diff.Added, diff.Removed); err != nil {
+		return err
+	}
+	for _, change := range diff.Changes {
+		var line string
+		switch {
+		case change.Baseline == nil:
+			line = fmt.Sprintf("ADDED %s: %s", change.Request, describe(change.Current))
+		case change.Current == nil:
+			line = fmt.Sprintf("REMOVED %s: %s", change.Request, describe(change.Baseline))
+		default:
+			line = fmt.Sprintf("CHANGED %s: %s -> %s", change.Request, describe(change.Baseline), describe(change.Current))
+		}
+		if _, err := fmt.Fprintln(out, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// describe returns a human readable description of a record
+func describe(record *policytest.Record) string {
+	if record.Error != "" {
+		return fmt.Sprintf("error: %s", record.Error)
+	}
+	parts := []string{string(record.Decision)}
+	if record.Decision == authz.DecisionDeny {
+		parts = append(parts, fmt.Sprintf("status %d", record.Status))
+	}
+	if record.Policy != "" {
+		parts = append(parts, fmt.Sprintf("policy %s", record.Policy))
+	} else {
+		parts = append(parts, "default decision")
+	}
+	if record.Reason != "" {
+		parts = append(parts, fmt.Sprintf("reason %q", record.Reason))
+	}
+	return strings.Join(parts, ", ")
+}
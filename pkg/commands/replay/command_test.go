@@ -0,0 +1,153 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/policytest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const policies = `apiVersion: envoy.kyverno.io/v1alpha1
+kind: AuthorizationPolicy
+metadata:
+  name: read-only
+spec:
+  authorizations:
+  - expression: >
+      object.attributes.request.http.method == "GET"
+        ? envoy.Allowed().Response()
+        : null
+`
+
+// strict denies the requests to the admin paths, GET requests included
+const strict = `apiVersion: envoy.kyverno.io/v1alpha1
+kind: AuthorizationPolicy
+metadata:
+  name: admin-only
+spec:
+  matchConditions:
+  - name: admin path
+    expression: object.attributes.request.http.path.startsWith("/admin")
+  authorizations:
+  - expression: envoy.Denied(401).Response()
+---
+` + policies
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func writeCorpus(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	writeFile(t, dir, "get.json", `{"attributes": {"request": {"http": {"method": "GET", "path": "/"}}}}`)
+	writeFile(t, dir, "admin/get.json", `{"attributes": {"request": {"http": {"method": "GET", "path": "/admin"}}}}`)
+	writeFile(t, dir, "post.yaml", "attributes:\n  request:\n    http:\n      method: POST\n      path: /\n")
+	return dir
+}
+
+func execute(args ...string) (string, error) {
+	command := Command()
+	// errors are returned, the output only holds the report
+	var out, errs bytes.Buffer
+	command.SetOut(&out)
+	command.SetErr(&errs)
+	command.SetArgs(args)
+	err := command.Execute()
+	return out.String(), err
+}
+
+func TestCommand(t *testing.T) {
+	dir := t.TempDir()
+	corpus := writeCorpus(t)
+	policy := writeFile(t, dir, "policy.yaml", policies)
+	out, err := execute("--policy-path", policy, corpus)
+	assert.NoError(t, err)
+	assert.Equal(t, `admin/get.json: Allow, policy read-only
+get.json: Allow, policy read-only
+post.yaml: Deny, status 403, default decision
+
+3 requests: 2 allowed, 1 denied, 0 errors
+  status 403: 1
+  default decision: 1
+  policy read-only: 2
+`, out)
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{{
+		name:    "missing policy path",
+		args:    []string{corpus},
+		wantErr: "at least one policy path is required",
+	}, {
+		name:    "missing corpus",
+		args:    []string{"--policy-path", policy},
+		wantErr: "accepts 1 arg(s), received 0",
+	}, {
+		name:    "invalid output",
+		args:    []string{"--policy-path", policy, "-o", "xml", corpus},
+		wantErr: `invalid output "xml"`,
+	}, {
+		name:    "missing baseline",
+		args:    []string{"--policy-path", policy, "--baseline", filepath.Join(dir, "missing.json"), corpus},
+		wantErr: "failed to read the baseline",
+	}, {
+		name:    "invalid baseline",
+		args:    []string{"--policy-path", policy, "--baseline", policy, corpus},
+		wantErr: "invalid baseline",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := execute(tt.args...)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestCommand_baseline(t *testing.T) {
+	dir := t.TempDir()
+	corpus := writeCorpus(t)
+	// save the baseline with the policies of the main branch
+	out, err := execute("--policy-path", writeFile(t, dir, "policy.yaml", policies), "-o", "json", corpus)
+	require.NoError(t, err)
+	baseline := writeFile(t, dir, "baseline.json", out)
+	// the corpus grew since the baseline was saved
+	writeFile(t, corpus, "delete.json", `{"attributes": {"request": {"http": {"method": "DELETE", "path": "/"}}}}`)
+	// the same policies don't change any decision
+	out, err = execute("--policy-path", filepath.Join(dir, "policy.yaml"), "--baseline", baseline, corpus)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "Baseline: 0 changed (0 new denies, 0 new allows), 1 added, 0 removed\nADDED delete.json: Deny, status 403, default decision\n")
+	// the policies of the pull request deny an allowed request
+	strictPolicy := writeFile(t, dir, "strict.yaml", strict)
+	out, err = execute("--policy-path", strictPolicy, "--baseline", baseline, corpus)
+	assert.EqualError(t, err, "1 of 3 decisions changed from the baseline")
+	assert.True(t, strings.HasSuffix(out, `
+Baseline: 1 changed (1 new denies, 0 new allows), 1 added, 0 removed
+CHANGED admin/get.json: Allow, policy read-only -> Deny, status 401, policy admin-only
+ADDED delete.json: Deny, status 403, default decision
+`), out)
+	// the json report carries the diff
+	out, _ = execute("--policy-path", strictPolicy, "--baseline", baseline, "-o", "json", corpus)
+	var report policytest.ReplayReport
+	require.NoError(t, json.Unmarshal([]byte(out), &report))
+	if assert.NotNil(t, report.Diff) {
+		assert.Equal(t, 1, report.Diff.NewDenies)
+		if assert.Len(t, report.Diff.Changes, 2) {
+			assert.Equal(t, "admin/get.json", report.Diff.Changes[0].Request)
+			assert.Equal(t, authz.DecisionAllow, report.Diff.Changes[0].Baseline.Decision)
+			assert.Equal(t, authz.DecisionDeny, report.Diff.Changes[0].Current.Decision)
+		}
+	}
+}
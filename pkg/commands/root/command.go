@@ -1,15 +1,39 @@
 package root
 
 import (
+	"flag"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/commands/explain"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/commands/manifests"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/commands/replay"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/commands/schema"
 	"github.com/kyverno/kyverno-envoy-plugin/pkg/commands/serve"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/commands/test"
 	"github.com/spf13/cobra"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
 
 func Command() *cobra.Command {
+	// logging defaults to development mode, it can be changed with the zap flags
+	opts := zap.Options{
+		Development: true,
+	}
 	root := &cobra.Command{
 		Use:   "kyverno-envoy-plugin",
 		Short: "kyverno-envoy-plugin is a plugin for Envoy",
+		PersistentPreRun: func(_ *cobra.Command, _ []string) {
+			log.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+		},
 	}
+	goflags := flag.NewFlagSet("", flag.ExitOnError)
+	opts.BindFlags(goflags)
+	root.PersistentFlags().AddGoFlagSet(goflags)
 	root.AddCommand(serve.Command())
+	root.AddCommand(test.Command())
+	root.AddCommand(explain.Command())
+	root.AddCommand(replay.Command())
+	root.AddCommand(schema.Command())
+	root.AddCommand(manifests.Command())
 	return root
 }
@@ -0,0 +1,28 @@
+package schema
+
+import (
+	"encoding/json"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/spf13/cobra"
+)
+
+func Command() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "schema",
+		Short: "Print the schema of the policy expressions environment",
+		Long:  "Print, as JSON, the variables, types and CEL libraries available to policy expressions, to generate editor schemas or check field names before compiling policies. The http and k8s libraries are described although the server only registers them when configured.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			// describe every library a server can register, the readers are never called
+			schema, err := policy.NewSchema(policy.WithKubeReader(nil), policy.WithHTTP(nil))
+			if err != nil {
+				return err
+			}
+			encoder := json.NewEncoder(cmd.OutOrStdout())
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(schema)
+		},
+	}
+	return command
+}
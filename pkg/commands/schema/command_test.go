@@ -0,0 +1,30 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommand(t *testing.T) {
+	command := Command()
+	var out bytes.Buffer
+	command.SetOut(&out)
+	command.SetArgs(nil)
+	assert.NoError(t, command.Execute())
+	var schema policy.Schema
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &schema))
+	var names []string
+	for _, variable := range schema.Variables {
+		names = append(names, variable.Name)
+	}
+	for _, name := range []string{policy.ObjectKey, policy.VariablesKey, policy.ContextKey, policy.SourceKey, policy.AuthKey, policy.DestinationKey, policy.RequestKey, policy.ConnectionKey} {
+		assert.Contains(t, names, name)
+	}
+	assert.Contains(t, schema.Libraries, "kyverno.http")
+	assert.Contains(t, schema.Libraries, "kyverno.k8s")
+	assert.NotEmpty(t, schema.Types["envoy.service.auth.v3.CheckRequest"])
+}
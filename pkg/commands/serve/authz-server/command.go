@@ -2,26 +2,160 @@ package authzserver
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"os"
+	goruntime "runtime"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/admin"
 	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz"
+	celhttp "github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/http"
+	celjwt "github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/jwt"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/debug"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/decisionlog"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/jitter"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
 	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
 	"github.com/kyverno/kyverno-envoy-plugin/pkg/probes"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/redact"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/server"
 	"github.com/kyverno/kyverno-envoy-plugin/pkg/signals"
 	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel"
 	"go.uber.org/multierr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/clientcmd"
 	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 )
 
+// costBudgetDecisionNone is the decision of the policies skipped by the cost budget when they take no decision
+const costBudgetDecisionNone = "None"
+
+// missingBodyDecisionEvaluate evaluates the policies reading the body of a request envoy didn't forward against the
+// forwarded body
+const missingBodyDecisionEvaluate = "Evaluate"
+
+// maxBodyDecisionTruncate truncates the bodies exceeding the maximum body size instead of rejecting the requests
+const maxBodyDecisionTruncate = "Truncate"
+
 func Command() *cobra.Command {
 	var probesAddress string
+	var livenessTimeout time.Duration
+	var metricsAddress string
+	var metricsPolicySeriesLimit int
 	var grpcAddress string
 	var grpcNetwork string
+	var grpcCertFile string
+	var grpcKeyFile string
+	var grpcReflection bool
+	var grpcClientCAFile string
+	var grpcAllowedClientSANs []string
+	var grpcTokenFile string
+	var checkWorkers int
+	var checkQueueSize int
+	var httpAddress string
+	var debugAddress string
+	var adminAddress string
+	var adminTokenFile string
+	var httpMaxBodySize int64
+	var shutdownTimeout time.Duration
+	var defaultDecision string
+	var defaultDenyStatus int32
+	var defaultDenyBody string
+	var notReadyDecision string
+	var notReadyDenyStatus int32
+	var notReadyDenyBody string
+	var evaluationConcurrency int
+	var evaluationMode string
+	var policyTimeout time.Duration
+	var policyCostBudget uint64
+	var policyCostBudgetDecision string
+	var policyCostBudgetDenyStatus int32
+	var missingBodyDecision string
+	var missingBodyDenyStatus int32
+	var maxBodySize int64
+	var maxBodyDecision string
+	var maxBodyDenyStatus int32
+	var decisionCacheSize int
+	var correlationHeader string
+	var decisionIDHeader string
+	var observe bool
+	var observeExternalHeader string
+	var policyMaxCost uint64
+	var policyWarmUpTimeout time.Duration
+	var policyAnnotationPrefixes []string
+	var httpAllowedHosts []string
+	var httpTimeout time.Duration
+	var httpCacheTTL time.Duration
+	var jwtIssuers []string
+	var jwksTTL time.Duration
+	var jwksJitter float64
+	var jwksTimeout time.Duration
+	var policyPaths []string
+	var policyValues string
+	var policyValuesEnvPrefix string
+	var policyStrict bool
+	var identitySources string
+	var policyScopeKey string
+	var policySandbox bool
+	var policySelector string
+	var policySyncPageSize int64
+	var policySyncTimeout time.Duration
+	var policyRetryBaseDelay time.Duration
+	var policyRetryMaxDelay time.Duration
+	var policyOrder string
+	var policyCoalesceDelay time.Duration
+	var policyQuota int
+	var policyNamespaceLabel string
+	var policyNamespaceQuota int
+	var policyDeletionGracePeriod time.Duration
+	var breakGlassTTL time.Duration
+	var policyHistory int
+	var policyDataSources bool
+	var policySetLock string
+	var leaderElect bool
+	var leaderElectionID string
+	var leaderElectionNamespace string
+	var policyBundles []string
+	var policyBundleInterval time.Duration
+	var policyBundleJitter float64
 	var kubeConfigOverrides clientcmd.ConfigOverrides
+	var allowTrail bool
+	var decisionLogStdout bool
+	var decisionLogFile string
+	var decisionLogFileMaxSize int
+	var decisionLogFileMaxBackups int
+	var decisionLogKafkaBrokers []string
+	var decisionLogKafkaTopic string
+	var decisionLogOTLPEndpoint string
+	var decisionLogOTLPInsecure bool
+	var decisionLogOTLPBatchSize int
+	var decisionLogOTLPFlushInterval time.Duration
+	var decisionLogBufferSize int
+	var decisionLogRetryAttempts int
+	var decisionLogRetryBackoff time.Duration
+	var decisionLogRetryMaxBackoff time.Duration
+	var decisionLogDeadLetterFile string
+	var decisionLogSubject string
+	var decisionLogFields map[string]string
+	var decisionLogSampleSeed uint64
+	var decisionLogPolicySampleRates map[string]string
+	var decisionLogDecisionSampleRates map[string]string
+	var redactMask []string
+	var redactHash []string
+	var redactSaltFile string
 	command := &cobra.Command{
 		Use:   "authz-server",
 		Short: "Start the Kyverno Authz Server",
@@ -29,74 +163,636 @@ func Command() *cobra.Command {
 			// setup signals aware context
 			return signals.Do(context.Background(), func(ctx context.Context) error {
 				// track errors
-				var httpErr, grpcErr, mgrErr error
+				var httpErr, metricsErr, grpcErr, authzHttpErr, debugErr, adminErr, mgrErr, providerErr, certsErr, decisionLogErr error
 				err := func(ctx context.Context) error {
-					// create a rest config
-					kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-						clientcmd.NewDefaultClientConfigLoadingRules(),
-						&kubeConfigOverrides,
-					)
-					config, err := kubeConfig.ClientConfig()
-					if err != nil {
+					// decision taken when no policy returned a response
+					defaults := authz.DefaultDecision{
+						Decision:   authz.Decision(defaultDecision),
+						DenyStatus: defaultDenyStatus,
+						DenyBody:   defaultDenyBody,
+					}
+					if err := defaults.Validate(); err != nil {
 						return err
 					}
+					// decision taken until the policies are loaded
+					notReady := authz.DefaultDecision{
+						Decision:   authz.Decision(notReadyDecision),
+						DenyStatus: notReadyDenyStatus,
+						DenyBody:   notReadyDenyBody,
+					}
+					if err := notReady.Validate(); err != nil {
+						return fmt.Errorf("invalid not ready decision: %w", err)
+					}
+					// nothing is enforced in observe mode, not even before the policies are loaded
+					if observe {
+						notReady = authz.DefaultDecision{Decision: authz.DecisionAllow}
+					}
+					if err := authz.EvaluationMode(evaluationMode).Validate(); err != nil {
+						return err
+					}
+					// the grpc server serves tls when a certificate is given
+					if (grpcCertFile == "") != (grpcKeyFile == "") {
+						return fmt.Errorf("--grpc-cert-file and --grpc-key-file must be set together")
+					}
+					var certs *server.CertReloader
+					var grpcTLS *tls.Config
+					if grpcCertFile != "" {
+						c, err := server.NewCertReloader(grpcCertFile, grpcKeyFile)
+						if err != nil {
+							return err
+						}
+						certs, grpcTLS = c, c.TLSConfig()
+					}
+					// the grpc server authenticates envoy when a client ca or a token is given
+					if (grpcClientCAFile == "") != (len(grpcAllowedClientSANs) == 0) {
+						return fmt.Errorf("--grpc-client-ca-file and --grpc-allowed-client-sans must be set together")
+					}
+					var authenticator *authz.Authenticator
+					if grpcClientCAFile != "" || grpcTokenFile != "" {
+						authenticator = &authz.Authenticator{SANs: grpcAllowedClientSANs}
+					}
+					if grpcClientCAFile != "" {
+						if grpcTLS == nil {
+							return fmt.Errorf("--grpc-cert-file is required when --grpc-client-ca-file is set")
+						}
+						pool, err := server.LoadCertPool(grpcClientCAFile)
+						if err != nil {
+							return err
+						}
+						// callers authenticated by the token don't need a certificate
+						grpcTLS.ClientCAs, grpcTLS.ClientAuth = pool, tls.VerifyClientCertIfGiven
+					}
+					if grpcTokenFile != "" {
+						token, err := os.ReadFile(grpcTokenFile)
+						if err != nil {
+							return err
+						}
+						authenticator.Token = strings.TrimSpace(string(token))
+						if authenticator.Token == "" {
+							return fmt.Errorf("--grpc-token-file is empty")
+						}
+					}
 					// create a wait group
 					var group wait.Group
 					// wait all tasks in the group are over
 					defer group.Wait()
-					// create a controller manager
-					scheme := runtime.NewScheme()
-					if err := v1alpha1.Install(scheme); err != nil {
+					// create metrics, they share the controller runtime registry
+					if metricsPolicySeriesLimit < 0 {
+						return fmt.Errorf("invalid metrics policy series limit, it must not be negative (limit: %d)", metricsPolicySeriesLimit)
+					}
+					m, err := metrics.New(ctrlmetrics.Registry, metrics.WithPolicySeriesLimit(metricsPolicySeriesLimit))
+					if err != nil {
 						return err
 					}
-					mgr, err := ctrl.NewManager(config, ctrl.Options{
-						Scheme: scheme,
-					})
+					// the check pool is shared by the servers, it bounds the resources used under load
+					if checkWorkers < 0 || checkQueueSize < 0 {
+						return fmt.Errorf("--check-workers and --check-queue-size must not be negative")
+					}
+					if checkWorkers == 0 {
+						checkWorkers = goruntime.GOMAXPROCS(0)
+					}
+					checkPool := authz.NewCheckPool(checkWorkers, checkQueueSize, m)
+					// the cost budget sheds the most expensive policies of a check, skipped policies take no decision by default
+					var costBudget *authz.CostBudget
+					if policyCostBudget > 0 {
+						var skipped *authz.DefaultDecision
+						if policyCostBudgetDecision != costBudgetDecisionNone {
+							// the zero decision denies, it must be explicit
+							if policyCostBudgetDecision == "" {
+								return fmt.Errorf("invalid cost budget decision: expected %q, %q or %q", costBudgetDecisionNone, authz.DecisionAllow, authz.DecisionDeny)
+							}
+							skipped = &authz.DefaultDecision{
+								Decision:   authz.Decision(policyCostBudgetDecision),
+								DenyStatus: policyCostBudgetDenyStatus,
+							}
+							if err := skipped.Validate(); err != nil {
+								return fmt.Errorf("invalid cost budget decision: %w", err)
+							}
+						}
+						costBudget = authz.NewCostBudget(policyCostBudget, skipped)
+					}
+					// policies reading a body envoy didn't forward are evaluated against the forwarded body by default
+					var missingBody *authz.DefaultDecision
+					if missingBodyDecision != missingBodyDecisionEvaluate {
+						// the zero decision denies, it must be explicit
+						if missingBodyDecision == "" {
+							return fmt.Errorf("invalid missing body decision: expected %q, %q or %q", missingBodyDecisionEvaluate, authz.DecisionAllow, authz.DecisionDeny)
+						}
+						missingBody = &authz.DefaultDecision{
+							Decision:   authz.Decision(missingBodyDecision),
+							DenyStatus: missingBodyDenyStatus,
+						}
+						if err := missingBody.Validate(); err != nil {
+							return fmt.Errorf("invalid missing body decision: %w", err)
+						}
+					}
+					// the bodies exceeding the maximum body size are truncated by default
+					var bodyLimit *authz.BodyLimit
+					if maxBodySize > 0 {
+						var reject *authz.DefaultDecision
+						if maxBodyDecision != maxBodyDecisionTruncate {
+							// the zero decision denies, it must be explicit
+							if maxBodyDecision == "" {
+								return fmt.Errorf("invalid max body decision: expected %q, %q or %q", maxBodyDecisionTruncate, authz.DecisionAllow, authz.DecisionDeny)
+							}
+							reject = &authz.DefaultDecision{
+								Decision:   authz.Decision(maxBodyDecision),
+								DenyStatus: maxBodyDenyStatus,
+							}
+							if err := reject.Validate(); err != nil {
+								return fmt.Errorf("invalid max body decision: %w", err)
+							}
+						}
+						bodyLimit = authz.NewBodyLimit(maxBodySize, reject)
+					}
+					// the redactor is shared by the decision log and the servers
+					var rules []redact.Rule
+					for _, value := range redactMask {
+						rules = append(rules, redact.NewRule(value, redact.ActionMask))
+					}
+					for _, value := range redactHash {
+						rules = append(rules, redact.NewRule(value, redact.ActionHash))
+					}
+					var salt []byte
+					if redactSaltFile != "" {
+						if salt, err = os.ReadFile(redactSaltFile); err != nil {
+							return err
+						}
+					}
+					redactor, err := redact.New(rules, strings.TrimSpace(string(salt)))
 					if err != nil {
-						return fmt.Errorf("failed to construct manager: %w", err)
+						return fmt.Errorf("invalid redaction: %w", err)
+					}
+					// create decision log sinks, the records they failed to write are stored in the dead letter file
+					var deadLetter *decisionlog.DeadLetter
+					if decisionLogDeadLetterFile != "" {
+						deadLetter = decisionlog.NewDeadLetter(decisionLogDeadLetterFile, decisionLogFileMaxSize, decisionLogFileMaxBackups, m)
+					}
+					retry := decisionlog.Retry{Attempts: decisionLogRetryAttempts, Backoff: decisionLogRetryBackoff, MaxBackoff: decisionLogRetryMaxBackoff}
+					var sinks []decisionlog.Sink
+					if decisionLogStdout {
+						sinks = append(sinks, decisionlog.NewStdoutSink(os.Stdout))
+					}
+					if decisionLogFile != "" {
+						sinks = append(sinks, decisionlog.NewFileSink(decisionLogFile, decisionLogFileMaxSize, decisionLogFileMaxBackups))
+					}
+					if (len(decisionLogKafkaBrokers) == 0) != (decisionLogKafkaTopic == "") {
+						return fmt.Errorf("--decision-log-kafka-brokers and --decision-log-kafka-topic must be set together")
+					}
+					if decisionLogKafkaTopic != "" {
+						sinks = append(sinks, decisionlog.NewKafkaSink(decisionLogKafkaBrokers, decisionLogKafkaTopic, retry, deadLetter, m))
+					}
+					if decisionLogOTLPEndpoint != "" {
+						if decisionLogOTLPBatchSize <= 0 {
+							return fmt.Errorf("--decision-log-otlp-batch-size must be positive")
+						}
+						creds := credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+						if decisionLogOTLPInsecure {
+							creds = insecure.NewCredentials()
+						}
+						// the client connects lazily, an unavailable collector fails the exports and not the startup
+						conn, err := grpc.NewClient(decisionLogOTLPEndpoint, grpc.WithTransportCredentials(creds))
+						if err != nil {
+							return fmt.Errorf("invalid decision log OTLP endpoint: %w", err)
+						}
+						sinks = append(sinks, decisionlog.NewOTLPSink(conn, decisionLogOTLPBatchSize, decisionLogOTLPFlushInterval, retry, deadLetter, m))
+					}
+					for i, sink := range sinks {
+						sinks[i] = decisionlog.NewRetrySink(sink, retry, deadLetter, m)
+					}
+					var decisionLogger authz.DecisionLogger
+					var decisionLog *decisionlog.Logger
+					if len(sinks) != 0 {
+						extractor, err := decisionlog.NewExtractor(decisionLogSubject, decisionLogFields)
+						if err != nil {
+							return fmt.Errorf("invalid decision log extraction: %w", err)
+						}
+						policyRates, err := decisionlog.ParseSampleRates(decisionLogPolicySampleRates)
+						if err != nil {
+							return fmt.Errorf("invalid decision log policy sample rates: %w", err)
+						}
+						decisionRates, err := decisionlog.ParseSampleRates(decisionLogDecisionSampleRates)
+						if err != nil {
+							return fmt.Errorf("invalid decision log decision sample rates: %w", err)
+						}
+						sampler, err := decisionlog.NewSampler(decisionLogSampleSeed, policyRates, decisionRates)
+						if err != nil {
+							return fmt.Errorf("invalid decision log sampling: %w", err)
+						}
+						decisionLog = decisionlog.NewLogger(extractor, sampler, redactor, decisionLogBufferSize, m, sinks...)
+						decisionLogger = decisionLog
+					}
+					// the decision cache is shared by the servers
+					var decisionCache *authz.DecisionCache
+					if decisionCacheSize > 0 {
+						decisionCache = authz.NewDecisionCache(decisionCacheSize)
+					}
+					// the middlewares process the decisions of both servers
+					var middlewares *authz.DecisionChain
+					if observe || correlationHeader != "" || decisionIDHeader != "" {
+						middlewares = authz.NewDecisionChain()
+					}
+					// observe mode allows the denied requests first, the other middlewares process the enforced decision
+					if observe {
+						middlewares.UseAllowing("observe", authz.ObserveMiddleware(observeExternalHeader))
+					}
+					if correlationHeader != "" {
+						middlewares.Use("correlation-header", authz.CorrelationHeaderMiddleware(correlationHeader))
+					}
+					if decisionIDHeader != "" {
+						middlewares.Use("decision-id-header", authz.DecisionIDHeaderMiddleware(decisionIDHeader))
+					}
+					// create compiler, providers can register additional options
+					config := policy.CompilerConfig{
+						MaxCost:            policyMaxCost,
+						AnnotationPrefixes: policyAnnotationPrefixes,
+						ScopeKey:           policyScopeKey,
+						Sandbox:            policySandbox,
+						HTTPAllowedHosts:   httpAllowedHosts,
+						HTTPOptions:        []celhttp.Option{celhttp.WithTimeout(httpTimeout), celhttp.WithCacheTTL(httpCacheTTL)},
+					}
+					if len(jwtIssuers) != 0 {
+						issuers := make([]celjwt.Issuer, 0, len(jwtIssuers))
+						for _, issuer := range jwtIssuers {
+							issuerURL, jwksURL, _ := strings.Cut(issuer, "=")
+							issuers = append(issuers, celjwt.Issuer{URL: issuerURL, JWKSURL: jwksURL})
+						}
+						keys, err := celjwt.NewKeySet(issuers, celjwt.WithKeySetTTL(jwksTTL), celjwt.WithKeySetJitter(jitter.Factor(jwksJitter)), celjwt.WithKeySetTimeout(jwksTimeout))
+						if err != nil {
+							return err
+						}
+						config.KeySet = keys
+					}
+					// the identity sources are compiled with the libraries of the policies, invalid sources fail every policy
+					if identitySources != "" {
+						sources, err := policy.LoadIdentitySources(identitySources)
+						if err != nil {
+							return err
+						}
+						config.IdentitySources = sources
+					}
+					// the options are built once, the libraries are shared by all the compilers
+					baseOpts := config.Options()
+					if len(config.IdentitySources) != 0 {
+						if errs := policy.ValidateIdentitySources(baseOpts...); len(errs) > 0 {
+							return fmt.Errorf("invalid identity sources %s: %w", identitySources, errs.ToAggregate())
+						}
+					}
+					newCompiler := func(opts ...policy.CompilerOption) policy.Compiler {
+						opts = append(slices.Clone(baseOpts), opts...)
+						compiler := policy.NewInstrumentedCompiler(policy.NewCompiler(opts...), m)
+						// evaluate the compiled policies once so that the first requests don't pay for lazy initializations
+						if policyWarmUpTimeout > 0 {
+							compiler = policy.NewWarmUpCompiler(compiler, policyWarmUpTimeout)
+						}
+						return compiler
 					}
-					// create compiler
-					compiler := policy.NewCompiler()
 					// create provider
-					provider, err := policy.NewKubeProvider(mgr, compiler)
+					var provider policy.Provider
+					var mgr ctrl.Manager
+					var watcher server.Server
+					// render policy files with the template values, if any
+					var template *policy.Template
+					if policyValues != "" || policyValuesEnvPrefix != "" {
+						if len(policyPaths) == 0 && len(policyBundles) == 0 {
+							return fmt.Errorf("--policy-values and --policy-values-env-prefix require --policy-path or --policy-bundle")
+						}
+						values, err := policy.LoadTemplateValues(policyValues, policyValuesEnvPrefix, os.Environ())
+						if err != nil {
+							return err
+						}
+						if template, err = policy.NewTemplate(values); err != nil {
+							return err
+						}
+					}
+					if policyDataSources && (len(policyPaths) != 0 || len(policyBundles) != 0) {
+						return fmt.Errorf("--policy-data-sources can't be used with --policy-path or --policy-bundle")
+					}
+					if len(policyPaths) != 0 {
+						// load policies from files
+						p, err := policy.NewFileProvider(newCompiler(), template, policyStrict, policyPaths...)
+						if err != nil {
+							return err
+						}
+						provider, watcher = p, p
+					} else if len(policyBundles) != 0 {
+						// pull policies from an oci registry, later bundles override the policies of the bundles before them
+						opts := []policy.OCIProviderOption{policy.WithPullInterval(policyBundleInterval), policy.WithPullJitter(jitter.Factor(policyBundleJitter)), policy.WithBundleMetrics(m), policy.WithTemplate(template)}
+						if policyStrict {
+							opts = append(opts, policy.WithStrictDecoding())
+						}
+						p, err := policy.NewLayeredOCIProvider(newCompiler(), policyBundles, opts...)
+						if err != nil {
+							return err
+						}
+						provider, watcher = p, p
+					} else {
+						// create a rest config
+						kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+							clientcmd.NewDefaultClientConfigLoadingRules(),
+							&kubeConfigOverrides,
+						)
+						config, err := kubeConfig.ClientConfig()
+						if err != nil {
+							return err
+						}
+						// create a controller manager
+						scheme := runtime.NewScheme()
+						if err := v1alpha1.Install(scheme); err != nil {
+							return err
+						}
+						// data sources are read and watched with the core types
+						if policyDataSources {
+							if err := corev1.AddToScheme(scheme); err != nil {
+								return err
+							}
+						}
+						mgr, err = ctrl.NewManager(config, ctrl.Options{
+							Scheme: scheme,
+							// let the reconciler finish its current work on shutdown
+							GracefulShutdownTimeout: &shutdownTimeout,
+							// only the leader writes policies status, every replica serves requests
+							LeaderElection:          leaderElect,
+							LeaderElectionID:        leaderElectionID,
+							LeaderElectionNamespace: leaderElectionNamespace,
+							// release the lease on shutdown so that a follower takes over immediately
+							LeaderElectionReleaseOnCancel: true,
+							// metrics are served by our own metrics server
+							Metrics: metricsserver.Options{
+								BindAddress: "0",
+							},
+						})
+						if err != nil {
+							return fmt.Errorf("failed to construct manager: %w", err)
+						}
+						selector, err := labels.Parse(policySelector)
+						if err != nil {
+							return fmt.Errorf("failed to parse policy selector: %w", err)
+						}
+						compare, err := policy.NewComparator(policy.OrderStrategy(policyOrder))
+						if err != nil {
+							return fmt.Errorf("failed to parse policy order: %w", err)
+						}
+						kubeOpts := []policy.KubeProviderOption{policy.WithLabelSelector(selector), policy.WithSyncPageSize(policySyncPageSize), policy.WithCacheSyncTimeout(policySyncTimeout), policy.WithSyncMetrics(m), policy.WithRetryBackoff(policyRetryBaseDelay, policyRetryMaxDelay), policy.WithPolicyOrder(compare), policy.WithUpdateCoalescing(policyCoalesceDelay), policy.WithPolicyQuota(policyQuota), policy.WithNamespaceQuota(policyNamespaceLabel, policyNamespaceQuota), policy.WithDeletionGracePeriod(policyDeletionGracePeriod), policy.WithBreakGlass(breakGlassTTL), policy.WithPolicyHistory(policyHistory)}
+						if leaderElect {
+							kubeOpts = append(kubeOpts, policy.WithLeaderElection())
+						}
+						if policyDataSources {
+							kubeOpts = append(kubeOpts, policy.WithDataSources())
+						}
+						// templated policies with identical specs are compiled once, deleted policies release their compilation
+						compileCache := policy.NewCompileCache()
+						kubeOpts = append(kubeOpts, policy.WithCompileCacheEviction(compileCache))
+						// policies can read resources from the manager cache
+						provider, err = policy.NewKubeProvider(mgr, newCompiler(policy.WithKubeReader(mgr.GetCache()), policy.WithCompileCache(compileCache)), kubeOpts...)
+						if err != nil {
+							return err
+						}
+					}
+					// the authorization servers refuse a policy set that suddenly became empty
+					authzProvider, err := policy.NewLockedProvider(provider, policy.LockMode(policySetLock), m)
 					if err != nil {
-						return err
+						return fmt.Errorf("failed to parse policy set lock: %w", err)
+					}
+					// create debug server
+					var debugHttp server.ServerFunc
+					if debugAddress != "" {
+						debugHttp, err = debug.NewServer(debugAddress, provider)
+						if err != nil {
+							return err
+						}
+					}
+					// create admin server
+					var adminHttp server.ServerFunc
+					if adminAddress != "" {
+						if adminTokenFile == "" {
+							return fmt.Errorf("--admin-token-file is required when --admin-address is set")
+						}
+						token, err := os.ReadFile(adminTokenFile)
+						if err != nil {
+							return err
+						}
+						adminHttp, err = admin.NewServer(adminAddress, provider, strings.TrimSpace(string(token)))
+						if err != nil {
+							return err
+						}
 					}
 					// create a cancellable context
 					ctx, cancel := context.WithCancel(ctx)
-					// start manager
-					group.StartWithContext(ctx, func(ctx context.Context) {
-						// cancel context at the end
-						defer cancel()
-						mgrErr = mgr.Start(ctx)
-					})
-					if !mgr.GetCache().WaitForCacheSync(ctx) {
-						defer cancel()
-						return fmt.Errorf("failed to wait for cache sync")
+					if watcher != nil {
+						// watch policy files or pull the policy bundle
+						group.StartWithContext(ctx, func(ctx context.Context) {
+							// cancel context at the end
+							defer cancel()
+							providerErr = watcher.Run(ctx)
+						})
 					}
+					if decisionLog != nil {
+						// write decision records to the sinks
+						group.StartWithContext(ctx, func(ctx context.Context) {
+							// cancel context at the end
+							defer cancel()
+							// the sinks are closed when the logger stops, they don't write to the dead letter afterwards
+							decisionLogErr = multierr.Combine(decisionLog.Run(ctx), deadLetter.Close())
+						})
+					}
+					if certs != nil {
+						// reload the grpc certificate when it is rotated
+						group.StartWithContext(ctx, func(ctx context.Context) {
+							// cancel context at the end
+							defer cancel()
+							certsErr = certs.Run(ctx)
+						})
+					}
+					if mgr != nil {
+						// start manager, it is not part of the group because it can't stop until its cache synced
+						var startErr error
+						mgrDone := make(chan struct{})
+						go func() {
+							defer close(mgrDone)
+							// cancel context at the end
+							defer cancel()
+							startErr = mgr.Start(ctx)
+						}()
+						// fail fast with the reason the cache can't sync instead of waiting forever
+						if err := provider.(policy.CacheSyncer).WaitForCacheSync(ctx); err != nil {
+							defer cancel()
+							return err
+						}
+						// wait for the manager once it synced
+						defer func() {
+							<-mgrDone
+							mgrErr = startErr
+						}()
+					}
+					// the global tracer provider is a no-op unless registered with otel.SetTracerProvider
+					tracerProvider := otel.GetTracerProvider()
+					// the authorization servers record the latency and errors of the provider, whatever its kind
+					authzProvider = policy.NewInstrumentedProvider(authzProvider, m, tracerProvider)
 					// create http and grpc servers
-					http := probes.NewServer(probesAddress)
-					grpc := authz.NewServer(grpcNetwork, grpcAddress, provider)
+					// the process is alive unless the watchdog detects the provider is deadlocked
+					live := probes.True
+					if livenessTimeout > 0 {
+						live = probes.NewWatchdog(livenessTimeout, func(ctx context.Context) {
+							_, _ = provider.CompiledPolicies(ctx)
+						}).Alive
+					}
+					http := probes.NewServer(probesAddress, live, func() bool {
+						return policy.Ready(context.Background(), provider)
+					})
+					metricsHttp := metrics.NewServer(metricsAddress, ctrlmetrics.Registry)
+					grpc := authz.NewServer(grpcNetwork, grpcAddress, grpcTLS, authzProvider, m, tracerProvider, decisionLogger, redactor, decisionCache, middlewares, defaults, notReady, authz.EvaluationMode(evaluationMode), evaluationConcurrency, policyTimeout, shutdownTimeout, grpcReflection, authenticator, checkPool, costBudget, bodyLimit, missingBody, allowTrail)
 					// run servers
 					group.StartWithContext(ctx, func(ctx context.Context) {
 						// cancel context at the end
 						defer cancel()
 						httpErr = http.Run(ctx)
 					})
+					group.StartWithContext(ctx, func(ctx context.Context) {
+						// cancel context at the end
+						defer cancel()
+						metricsErr = metricsHttp.Run(ctx)
+					})
 					group.StartWithContext(ctx, func(ctx context.Context) {
 						// cancel context at the end
 						defer cancel()
 						grpcErr = grpc.Run(ctx)
 					})
+					if httpAddress != "" {
+						// run http authorization server
+						authzHttp := authz.NewHttpServer(httpAddress, authzProvider, m, tracerProvider, decisionLogger, redactor, decisionCache, middlewares, defaults, notReady, authz.EvaluationMode(evaluationMode), evaluationConcurrency, policyTimeout, httpMaxBodySize, checkPool, costBudget, bodyLimit, missingBody, allowTrail)
+						group.StartWithContext(ctx, func(ctx context.Context) {
+							// cancel context at the end
+							defer cancel()
+							authzHttpErr = authzHttp.Run(ctx)
+						})
+					}
+					if debugHttp != nil {
+						// run debug server
+						group.StartWithContext(ctx, func(ctx context.Context) {
+							// cancel context at the end
+							defer cancel()
+							debugErr = debugHttp.Run(ctx)
+						})
+					}
+					if adminHttp != nil {
+						// run admin server
+						group.StartWithContext(ctx, func(ctx context.Context) {
+							// cancel context at the end
+							defer cancel()
+							adminErr = adminHttp.Run(ctx)
+						})
+					}
 					return nil
 				}(ctx)
-				return multierr.Combine(err, httpErr, grpcErr, mgrErr)
+				return multierr.Combine(err, httpErr, metricsErr, grpcErr, authzHttpErr, debugErr, adminErr, mgrErr, providerErr, certsErr, decisionLogErr)
 			})
 		},
 	}
 	command.Flags().StringVar(&probesAddress, "probes-address", ":9080", "Address to listen on for health checks")
+	command.Flags().DurationVar(&livenessTimeout, "liveness-timeout", 0, "Time the policy provider has to answer a liveness check before the process is considered deadlocked, the liveness check always succeeds if zero")
+	command.Flags().StringVar(&metricsAddress, "metrics-address", ":9082", "Address to listen on for metrics")
+	command.Flags().IntVar(&metricsPolicySeriesLimit, "metrics-policy-series-limit", 1000, "Maximum number of policies whose decisions are recorded in their own policy_decisions_total series, the other policies are recorded in the other series (no limit if zero)")
 	command.Flags().StringVar(&grpcAddress, "grpc-address", ":9081", "Address to listen on")
 	command.Flags().StringVar(&grpcNetwork, "grpc-network", "tcp", "Network to listen on")
+	command.Flags().StringVar(&grpcCertFile, "grpc-cert-file", "", "Certificate file served by the gRPC server, the server uses plaintext if empty (reloaded when the file changes)")
+	command.Flags().StringVar(&grpcKeyFile, "grpc-key-file", "", "Private key file of the gRPC server certificate (reloaded when the file changes)")
+	command.Flags().BoolVar(&grpcReflection, "grpc-reflection", false, "Register the gRPC reflection service, it lets clients like grpcurl list the services (not recommended in production)")
+	command.Flags().StringVar(&grpcClientCAFile, "grpc-client-ca-file", "", "CA certificates file the client certificates presented to the gRPC server are verified against, it requires --grpc-cert-file")
+	command.Flags().StringSliceVar(&grpcAllowedClientSANs, "grpc-allowed-client-sans", nil, "Subject alternative names (DNS names, URIs, emails or IP addresses) of the client certificates allowed to call the gRPC server")
+	command.Flags().StringVar(&grpcTokenFile, "grpc-token-file", "", "File containing a bearer token allowed to call the gRPC server, callers present it in the authorization metadata")
+	command.Flags().StringVar(&httpAddress, "http-address", "", "Address to listen on for HTTP authorization requests (disabled if empty)")
+	command.Flags().StringVar(&debugAddress, "debug-address", "", "Loopback address to listen on for pprof profiles and policies dump (disabled if empty)")
+	command.Flags().StringVar(&adminAddress, "admin-address", "", "Address to listen on for the admin API describing the loaded policies (disabled if empty)")
+	command.Flags().StringVar(&adminTokenFile, "admin-token-file", "", "File containing the bearer token required by the admin API")
+	command.Flags().Int64Var(&httpMaxBodySize, "http-max-body-size", 8192, "Maximum number of request body bytes forwarded to policies by the HTTP authorization server")
+	command.Flags().DurationVar(&shutdownTimeout, "shutdown-timeout", 10*time.Second, "Maximum time to wait for in-flight requests to complete when shutting down")
+	command.Flags().StringVar(&defaultDecision, "default-decision", string(authz.DecisionDeny), "Decision taken when no policy returned a response (Allow or Deny)")
+	command.Flags().Int32Var(&defaultDenyStatus, "default-deny-status", 403, "HTTP status code returned when the default decision denies a request")
+	command.Flags().StringVar(&defaultDenyBody, "default-deny-body", "", "HTTP body returned when the default decision denies a request")
+	command.Flags().StringVar(&notReadyDecision, "not-ready-decision", string(authz.DecisionDeny), "Decision taken until the policies are loaded (Allow or Deny)")
+	command.Flags().Int32Var(&notReadyDenyStatus, "not-ready-deny-status", 503, "HTTP status code returned when the not ready decision denies a request")
+	command.Flags().StringVar(&notReadyDenyBody, "not-ready-deny-body", "", "HTTP body returned when the not ready decision denies a request")
+	command.Flags().IntVar(&checkWorkers, "check-workers", 0, "Maximum number of checks processed concurrently by the authorization servers (GOMAXPROCS if zero)")
+	command.Flags().IntVar(&checkQueueSize, "check-queue-size", 1000, "Number of checks waiting for a worker, checks are rejected with ResourceExhausted when the queue is full")
+	command.Flags().StringVar(&evaluationMode, "evaluation-mode", string(authz.EvaluationModeEvaluateAll), "How policy responses are combined into a decision (EvaluateAll applies deny overrides, FirstMatch returns the response of the first policy returning one)")
+	command.Flags().IntVar(&evaluationConcurrency, "evaluation-concurrency", 1, "Maximum number of policies evaluated concurrently for a request, policies are evaluated sequentially when lower than 2")
+	command.Flags().DurationVar(&policyTimeout, "policy-timeout", 0, "Maximum evaluation time of a policy, a policy timing out obeys its failure policy (no timeout if zero)")
+	command.Flags().Uint64Var(&policyCostBudget, "policy-cost-budget", 0, "Maximum CEL cost of the policies evaluated for a check, a policy whose estimated cost exceeds the budget left is skipped (no budget if zero)")
+	command.Flags().StringVar(&policyCostBudgetDecision, "policy-cost-budget-decision", costBudgetDecisionNone, "Decision taken by the policies skipped by the cost budget (None, Allow or Deny), None skips them like policies taking no decision")
+	command.Flags().Int32Var(&policyCostBudgetDenyStatus, "policy-cost-budget-deny-status", 503, "HTTP status code returned when a policy skipped by the cost budget denies a request")
+	command.Flags().StringVar(&missingBodyDecision, "missing-body-decision", missingBodyDecisionEvaluate, "Decision taken by the policies reading the request body when envoy didn't forward it or truncated it (Evaluate, Allow or Deny), Evaluate evaluates them against the forwarded body")
+	command.Flags().Int32Var(&missingBodyDenyStatus, "missing-body-deny-status", 413, "HTTP status code returned when a policy denies a request whose body envoy didn't forward")
+	command.Flags().Int64Var(&maxBodySize, "max-body-size", 0, "Maximum number of request body bytes the policies are evaluated against, for both authorization servers (no limit if zero)")
+	command.Flags().StringVar(&maxBodyDecision, "max-body-decision", maxBodyDecisionTruncate, "Decision taken for the requests whose body exceeds the maximum body size (Truncate, Allow or Deny), Truncate truncates the body and evaluates the policies")
+	command.Flags().Int32Var(&maxBodyDenyStatus, "max-body-deny-status", 413, "HTTP status code returned when denying a request whose body exceeds the maximum body size")
+	command.Flags().IntVar(&decisionCacheSize, "decision-cache-size", 10000, "Maximum number of decisions cached for the policies declaring a cache key (no caching if zero)")
+	command.Flags().BoolVar(&observe, "observe", false, "Evaluate the policies and log their decisions but allow every request, to validate policies before enforcing them")
+	command.Flags().StringVar(&observeExternalHeader, "observe-external-decision-header", "", "Request header carrying the decision of another authorization system (allow or deny), observe mode logs the decisions disagreeing with it")
+	command.Flags().StringVar(&correlationHeader, "correlation-header", "", "Request header copied to the response sent to the client, whether the request is allowed or denied (x-request-id for example)")
+	command.Flags().StringVar(&decisionIDHeader, "decision-id-header", "", "Response header carrying the decision id to the client, whether the request is allowed or denied (no header if empty)")
+	command.Flags().StringSliceVar(&httpAllowedHosts, "http-allowed-hosts", nil, "Hosts policies can call with the http.Get and http.Post CEL functions, the functions are not available if empty")
+	command.Flags().DurationVar(&httpTimeout, "http-timeout", 2*time.Second, "Maximum duration of a call made by the http.Get and http.Post CEL functions")
+	command.Flags().DurationVar(&httpCacheTTL, "http-cache-ttl", 30*time.Second, "Duration a response returned to the http.Get and http.Post CEL functions is reused for the same call (no caching if zero)")
+	command.Flags().StringSliceVar(&jwtIssuers, "jwt-issuers", nil, "Trusted issuers of the tokens verified by the jwt.Verify CEL function with a single argument, as issuer or issuer=jwks-url (the key set is discovered from the issuer openid configuration if not set)")
+	command.Flags().DurationVar(&jwksTTL, "jwks-ttl", 5*time.Minute, "Duration the keys of a trusted issuer are used before being fetched again")
+	command.Flags().Float64Var(&jwksJitter, "jwks-jitter", 0.1, "Fraction of the jwks ttl the expiry of fetched keys is randomly moved by (no jitter if zero)")
+	command.Flags().DurationVar(&jwksTimeout, "jwks-timeout", 5*time.Second, "Maximum duration of a fetch of the keys of a trusted issuer")
+	command.Flags().DurationVar(&policyWarmUpTimeout, "policy-warm-up-timeout", time.Second, "Maximum duration of the evaluation warming up a compiled policy against a synthetic request, external services are not called (no warm up if zero)")
+	command.Flags().Uint64Var(&policyMaxCost, "policy-max-cost", 0, "Maximum runtime cost of a CEL expression, an expression exceeding it fails and the policy obeys its failure policy (no limit if zero)")
+	command.Flags().StringSliceVar(&policyAnnotationPrefixes, "policy-annotation-prefixes", nil, "Prefixes of the policy annotations added to the decision metadata and records, owner or ticket annotations for example (no annotation if empty)")
+	command.Flags().StringArrayVar(&policyPaths, "policy-path", nil, "Files, directories or glob patterns to load policies from instead of the Kubernetes API server, files are reloaded when they change or the server receives SIGHUP")
+	command.Flags().StringSliceVar(&policyBundles, "policy-bundle", nil, "OCI artifact references to pull policies from instead of the Kubernetes API server, in layer order: a policy overrides the policies with the same name of the bundles before it")
+	command.Flags().DurationVar(&policyBundleInterval, "policy-bundle-interval", time.Minute, "Interval at which the policy bundle is pulled again")
+	command.Flags().Float64Var(&policyBundleJitter, "policy-bundle-jitter", 0.1, "Fraction of the pull interval every pull is randomly moved by, the first periodic pull happens after a random delay (no jitter if zero)")
+	command.Flags().StringVar(&policyValues, "policy-values", "", "Yaml file of the values the policy files and bundle are rendered with as Go templates before they are compiled")
+	command.Flags().StringVar(&policyValuesEnvPrefix, "policy-values-env-prefix", "", "Prefix of the environment variables the policy files and bundle are rendered with, the prefix is removed from the value names and they override the values file")
+	command.Flags().BoolVar(&policySandbox, "policy-sandbox", false, "Reject the policies reading external data, the expressions calling http.Get, http.Post, k8s.Get or jwt.Verify with the trusted issuers key set fail to compile")
+	command.Flags().StringVar(&policyScopeKey, "policy-scope-key", policy.DefaultScopeKey, "Context extension identifying the Envoy listener or filter chain of a request, the scope of policies lists its values")
+	command.Flags().StringVar(&identitySources, "identity-sources", "", "Path to a YAML file listing the sources the identity variable of policies is resolved from, in order")
+	command.Flags().BoolVar(&policyStrict, "policy-strict", false, "Fail to load the policy files and bundle when a policy has fields unknown to its version instead of ignoring them")
+	command.Flags().StringVar(&policySelector, "policy-selector", "", "Label selector to filter the policies loaded from the Kubernetes API server")
+	command.Flags().DurationVar(&policySyncTimeout, "policy-sync-timeout", 2*time.Minute, "Maximum time to wait for the policies loaded from the Kubernetes API server to sync at startup, permission errors fail immediately (no timeout if zero)")
+	command.Flags().Int64Var(&policySyncPageSize, "policy-sync-page-size", 500, "Number of policies listed per request when loading policies from the Kubernetes API server at startup")
+	command.Flags().DurationVar(&policyRetryBaseDelay, "policy-retry-base-delay", 5*time.Millisecond, "Delay before retrying a policy that failed to reconcile with a transient error, doubled on every failure")
+	command.Flags().BoolVar(&leaderElect, "leader-elect", false, "Enable leader election, only the leader updates the policies status when policies are loaded from the Kubernetes API server")
+	command.Flags().StringVar(&leaderElectionID, "leader-election-id", "kyverno-authz-server", "Name of the lease used for leader election")
+	command.Flags().StringVar(&leaderElectionNamespace, "leader-election-namespace", "", "Namespace of the lease used for leader election, defaults to the pod namespace when running in a cluster")
+	command.Flags().StringVar(&policyOrder, "policy-order", string(policy.OrderByPriority), "Order policies loaded from the Kubernetes API server are evaluated in (Priority, Name or EnforceFirst)")
+	command.Flags().DurationVar(&policyCoalesceDelay, "policy-coalesce-delay", 100*time.Millisecond, "Delay updates to the same policy loaded from the Kubernetes API server are coalesced over before the policy is compiled again (no coalescing if zero)")
+	command.Flags().StringVar(&policySetLock, "policy-set-lock", string(policy.LockNone), "Behavior when the policy set suddenly becomes empty because every policy was deleted or failed to compile (None applies the default decision, Retain keeps serving the last non empty set, Deny fails the requests)")
+	command.Flags().IntVar(&policyQuota, "policy-quota", 0, "Maximum number of policies loaded from the Kubernetes API server, the oldest policies are loaded and the others rejected (no limit if zero)")
+	command.Flags().StringVar(&policyNamespaceLabel, "policy-namespace-label", "envoy.kyverno.io/namespace", "Label holding the namespace a policy counts against for the per namespace quota, policies are cluster scoped")
+	command.Flags().IntVar(&policyNamespaceQuota, "policy-namespace-quota", 0, "Maximum number of policies loaded from the Kubernetes API server per namespace, the oldest policies are loaded and the others rejected (no limit if zero)")
+	command.Flags().DurationVar(&policyDeletionGracePeriod, "policy-deletion-grace-period", 0, "Duration a policy deleted from the Kubernetes API server is still evaluated, it is evicted if it isn't recreated meanwhile (evicted immediately if zero)")
+	command.Flags().IntVar(&policyHistory, "policy-history", policy.DefaultPolicyHistory, "Number of successfully compiled generations retained per policy loaded from the Kubernetes API server, the "+policy.PinAnnotation+" annotation pins a policy to one of them (pinning disabled if zero)")
+	command.Flags().DurationVar(&breakGlassTTL, "break-glass-ttl", policy.DefaultBreakGlassTTL, "Duration the break glass of a policy stays active once activated by the "+policy.BreakGlassAnnotation+" annotation, at most 24h (break glass disabled if zero)")
+	command.Flags().BoolVar(&policyDataSources, "policy-data-sources", false, "Resolve and watch the ConfigMaps and Secrets referenced by the data sources of the policies loaded from the Kubernetes API server, the server needs to list and watch them (policies declaring data sources fail to compile if disabled)")
+	command.Flags().DurationVar(&policyRetryMaxDelay, "policy-retry-max-delay", 5*time.Minute, "Maximum delay between retries of a policy that failed to reconcile with a transient error")
+	command.Flags().BoolVar(&allowTrail, "allow-trail", false, "Add the enforced policies that allowed a request to the dynamic metadata and decision record of allow decisions, it adds work to every check")
+	command.Flags().BoolVar(&decisionLogStdout, "decision-log-stdout", false, "Write a decision record to stdout for every checked request")
+	command.Flags().StringVar(&decisionLogFile, "decision-log-file", "", "File to write a decision record to for every checked request (disabled if empty)")
+	command.Flags().IntVar(&decisionLogFileMaxSize, "decision-log-file-max-size", 100, "Size in megabytes the decision log file is rotated at")
+	command.Flags().IntVar(&decisionLogFileMaxBackups, "decision-log-file-max-backups", 5, "Number of rotated decision log files to keep (all of them if zero)")
+	command.Flags().StringSliceVar(&decisionLogKafkaBrokers, "decision-log-kafka-brokers", nil, "Kafka brokers to produce a decision record to for every checked request (disabled if empty)")
+	command.Flags().StringVar(&decisionLogKafkaTopic, "decision-log-kafka-topic", "", "Kafka topic decision records are produced to")
+	command.Flags().StringVar(&decisionLogOTLPEndpoint, "decision-log-otlp-endpoint", "", "OTLP gRPC endpoint (host:port) to export a decision record to as a log record for every checked request (disabled if empty)")
+	command.Flags().BoolVar(&decisionLogOTLPInsecure, "decision-log-otlp-insecure", false, "Connect to the OTLP endpoint without TLS")
+	command.Flags().IntVar(&decisionLogOTLPBatchSize, "decision-log-otlp-batch-size", decisionlog.DefaultOTLPBatchSize, "Maximum number of decision records exported per OTLP request")
+	command.Flags().DurationVar(&decisionLogOTLPFlushInterval, "decision-log-otlp-flush-interval", decisionlog.DefaultOTLPFlushInterval, "Delay after which the decision records waiting for a full batch are exported to the OTLP endpoint (only full batches are exported if zero)")
+	command.Flags().IntVar(&decisionLogBufferSize, "decision-log-buffer-size", decisionlog.DefaultBufferSize, "Number of decision records buffered per sink, records are dropped when the buffer is full")
+	command.Flags().IntVar(&decisionLogRetryAttempts, "decision-log-retry-attempts", 0, "Maximum number of times a sink writes a decision record, including the first write (zero keeps the sink default: one write for stdout, file and OTLP, the Kafka client default otherwise)")
+	command.Flags().DurationVar(&decisionLogRetryBackoff, "decision-log-retry-backoff", 100*time.Millisecond, "Delay before the first retry of a failed decision record write, it doubles on every retry")
+	command.Flags().DurationVar(&decisionLogRetryMaxBackoff, "decision-log-retry-max-backoff", time.Second, "Maximum delay between two retries of a failed decision record write")
+	command.Flags().StringVar(&decisionLogDeadLetterFile, "decision-log-dead-letter-file", "", "File to write the decision records a sink failed to write once its retries are exhausted to, rotated like the decision log file (disabled if empty)")
+	command.Flags().StringVar(&decisionLogSubject, "decision-log-subject", "", "CEL expression evaluated against the check request to identify the subject of a decision record, it must return a string")
+	command.Flags().StringToStringVar(&decisionLogFields, "decision-log-fields", nil, "CEL expressions evaluated against the check request to add fields to decision records, keyed by field name")
+	command.Flags().StringToStringVar(&decisionLogPolicySampleRates, "decision-log-policy-sample-rates", nil, "Fraction of the decisions taken by a policy a decision record is written for, between 0 and 1, keyed by policy name (overrides the decision sample rates)")
+	command.Flags().StringToStringVar(&decisionLogDecisionSampleRates, "decision-log-decision-sample-rates", nil, "Fraction of the decisions a decision record is written for, between 0 and 1, keyed by decision (allow, deny or error)")
+	command.Flags().Uint64Var(&decisionLogSampleSeed, "decision-log-sample-seed", 0, "Seed of the hash sampling decision records by request id, replicas sharing the seed sample the same requests")
+	command.Flags().StringSliceVar(&redactMask, "redact-mask", nil, "Request headers, or CEL paths from the object variable, whose values are masked in decision records, logs and traces (authorization, proxy-authorization and cookie are always masked unless hashed)")
+	command.Flags().StringSliceVar(&redactHash, "redact-hash", nil, "Request headers, or CEL paths from the object variable, whose values are replaced with a salted hash in decision records, logs and traces")
+	command.Flags().StringVar(&redactSaltFile, "redact-salt-file", "", "File containing the salt of the hashed values, required with --redact-hash")
 	clientcmd.BindOverrideFlags(&kubeConfigOverrides, command.Flags(), clientcmd.RecommendedConfigOverrideFlags("kube-"))
 	return command
 }
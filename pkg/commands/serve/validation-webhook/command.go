@@ -3,23 +3,31 @@ package validationwebhook
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
 	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
 	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
 	"github.com/kyverno/kyverno-envoy-plugin/pkg/probes"
 	"github.com/kyverno/kyverno-envoy-plugin/pkg/signals"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/webhook/conversion"
 	"github.com/kyverno/kyverno-envoy-plugin/pkg/webhook/validation"
 	"github.com/spf13/cobra"
 	"go.uber.org/multierr"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/clientcmd"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 )
 
 func Command() *cobra.Command {
 	var probesAddress string
+	var webhookPort int
+	var certDir string
+	var certName string
+	var keyName string
 	var kubeConfigOverrides clientcmd.ConfigOverrides
 	command := &cobra.Command{
 		Use:   "validation-webhook",
@@ -48,23 +56,32 @@ func Command() *cobra.Command {
 					if err := v1alpha1.Install(scheme); err != nil {
 						return err
 					}
+					// the hub version is only used to convert between served versions
+					if err := hub.Install(scheme); err != nil {
+						return err
+					}
 					mgr, err := ctrl.NewManager(config, ctrl.Options{
 						Scheme: scheme,
+						// certificates are reloaded by the webhook server when they change on disk
+						WebhookServer: webhook.NewServer(webhook.Options{
+							Port:     webhookPort,
+							CertDir:  certDir,
+							CertName: certName,
+							KeyName:  keyName,
+						}),
 					})
 					if err != nil {
 						return fmt.Errorf("failed to construct manager: %w", err)
 					}
-					// create compiler
-					compiler := policy.NewCompiler()
+					// create compiler, it's configured like the compilers of the authz server and only type checks the
+					// functions reading external data
+					compiler := policy.NewCompiler(policy.CompilerConfig{TypeCheckOnly: true}.Options()...)
 					// register validation webhook
-					compileFunc := func(policy *v1alpha1.AuthorizationPolicy) field.ErrorList {
-						_, err := compiler.Compile(policy)
-						fmt.Println("validating policy", policy.Name, err)
-						return err
-					}
-					if err := ctrl.NewWebhookManagedBy(mgr).For(&v1alpha1.AuthorizationPolicy{}).WithValidator(validation.NewValidator(compileFunc)).Complete(); err != nil {
+					if err := ctrl.NewWebhookManagedBy(mgr).For(&v1alpha1.AuthorizationPolicy{}).WithValidator(validation.NewValidator(compiler)).Complete(); err != nil {
 						return fmt.Errorf("failed to create webhook: %w", err)
 					}
+					// register conversion webhook, the api server only calls it once the crd serves several versions
+					mgr.GetWebhookServer().Register("/convert", conversion.NewHandler(scheme))
 					// create a cancellable context
 					ctx, cancel := context.WithCancel(ctx)
 					// start manager
@@ -78,7 +95,7 @@ func Command() *cobra.Command {
 						return fmt.Errorf("failed to wait for cache sync")
 					}
 					// create http and grpc servers
-					http := probes.NewServer(probesAddress)
+					http := probes.NewServer(probesAddress, probes.True, probes.True)
 					// run servers
 					group.StartWithContext(ctx, func(ctx context.Context) {
 						// cancel context at the end
@@ -92,6 +109,10 @@ func Command() *cobra.Command {
 		},
 	}
 	command.Flags().StringVar(&probesAddress, "probes-address", ":9080", "Address to listen on for health checks")
+	command.Flags().IntVar(&webhookPort, "webhook-port", webhook.DefaultPort, "Port the webhook server listens on")
+	command.Flags().StringVar(&certDir, "cert-dir", filepath.Join(os.TempDir(), "k8s-webhook-server", "serving-certs"), "Directory containing the webhook server certificate and key")
+	command.Flags().StringVar(&certName, "cert-name", "tls.crt", "Name of the webhook server certificate file in the certificates directory")
+	command.Flags().StringVar(&keyName, "key-name", "tls.key", "Name of the webhook server key file in the certificates directory")
 	clientcmd.BindOverrideFlags(&kubeConfigOverrides, command.Flags(), clientcmd.RecommendedConfigOverrideFlags("kube-"))
 	return command
 }
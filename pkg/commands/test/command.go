@@ -0,0 +1,74 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/spf13/cobra"
+)
+
+const (
+	outputText = "text"
+	outputJson = "json"
+)
+
+func Command() *cobra.Command {
+	var policyPaths []string
+	var output string
+	command := &cobra.Command{
+		Use:   "test [fixtures files...]",
+		Short: "Test policies against sample check requests",
+		Long:  "Compile policies and evaluate the check requests of the fixtures files against them, the command fails if a policy is invalid or a decision doesn't match the expected one.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != outputText && output != outputJson {
+				return fmt.Errorf("invalid output %q, expected %q or %q", output, outputText, outputJson)
+			}
+			if len(policyPaths) == 0 {
+				return fmt.Errorf("at least one policy path is required")
+			}
+			// the usage is not helpful once the arguments were validated
+			cmd.SilenceUsage = true
+			// load policies the same way the authz server does
+			provider, err := policy.NewFileProvider(policy.NewCompiler(), nil, false, policyPaths...)
+			if err != nil {
+				return err
+			}
+			report, err := run(provider, args...)
+			if err != nil {
+				return err
+			}
+			if err := writeReport(cmd.OutOrStdout(), output, report); err != nil {
+				return err
+			}
+			if report.Failed > 0 {
+				return fmt.Errorf("%d of %d tests failed", report.Failed, report.Failed+report.Passed)
+			}
+			return nil
+		},
+	}
+	command.Flags().StringArrayVar(&policyPaths, "policy-path", nil, "Files, directories or glob patterns to load policies from")
+	command.Flags().StringVarP(&output, "output", "o", outputText, "Output format (text or json)")
+	return command
+}
+
+func writeReport(out io.Writer, output string, report Report) error {
+	if output == outputJson {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	for _, result := range report.Results {
+		status := "PASS"
+		if !result.Passed {
+			status = "FAIL"
+		}
+		if _, err := fmt.Fprintf(out, "%s %s: %s (%s)\n", status, result.File, result.Name, describe(result)); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(out, "\n%d passed, %d failed\n", report.Passed, report.Failed)
+	return err
+}
@@ -0,0 +1,149 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const policies = `apiVersion: envoy.kyverno.io/v1alpha1
+kind: AuthorizationPolicy
+metadata:
+  name: read-only
+spec:
+  authorizations:
+  - expression: >
+      object.attributes.request.http.method == "GET"
+        ? envoy.Allowed().Response()
+        : envoy.Denied(405).Response()
+`
+
+const passing = `tests:
+- name: get is allowed
+  input:
+    attributes:
+      request:
+        http:
+          method: GET
+  expect:
+    decision: Allow
+    policy: read-only
+- name: delete is denied
+  input:
+    attributes:
+      request:
+        http:
+          method: DELETE
+  expect:
+    decision: Deny
+    status: 405
+`
+
+const failing = `tests:
+- name: delete is allowed
+  input:
+    attributes:
+      request:
+        http:
+          method: DELETE
+  expect:
+    decision: Allow
+`
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func execute(args ...string) (string, error) {
+	command := Command()
+	var out bytes.Buffer
+	command.SetOut(&out)
+	command.SetErr(&out)
+	command.SetArgs(args)
+	err := command.Execute()
+	return out.String(), err
+}
+
+func TestCommand(t *testing.T) {
+	dir := t.TempDir()
+	policy := writeFile(t, dir, "policy.yaml", policies)
+	invalid := writeFile(t, dir, "invalid.yaml", `apiVersion: envoy.kyverno.io/v1alpha1
+kind: AuthorizationPolicy
+metadata:
+  name: invalid
+spec:
+  authorizations:
+  - expression: envoy.Allowed()
+`)
+	passingFixtures := writeFile(t, dir, "passing.yaml", passing)
+	failingFixtures := writeFile(t, dir, "failing.yaml", failing)
+	tests := []struct {
+		name        string
+		args        []string
+		wantErr     string
+		wantContain []string
+	}{{
+		name:        "passing",
+		args:        []string{"--policy-path", policy, passingFixtures},
+		wantContain: []string{"PASS " + passingFixtures + ": get is allowed (Allow, policy read-only)", "2 passed, 0 failed"},
+	}, {
+		name:        "failing",
+		args:        []string{"--policy-path", policy, passingFixtures, failingFixtures},
+		wantErr:     "1 of 3 tests failed",
+		wantContain: []string{"FAIL " + failingFixtures + ": delete is allowed (expected Allow, got Deny, status 405, policy read-only)", "2 passed, 1 failed"},
+	}, {
+		name:    "invalid policy",
+		args:    []string{"--policy-path", invalid, passingFixtures},
+		wantErr: "failed to compile policy invalid",
+	}, {
+		name:    "missing policy path",
+		args:    []string{passingFixtures},
+		wantErr: "at least one policy path is required",
+	}, {
+		name:    "invalid output",
+		args:    []string{"--policy-path", policy, "--output", "xml", passingFixtures},
+		wantErr: `invalid output "xml"`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := execute(tt.args...)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			for _, want := range tt.wantContain {
+				assert.Contains(t, out, want)
+			}
+		})
+	}
+}
+
+func TestCommand_json(t *testing.T) {
+	dir := t.TempDir()
+	policy := writeFile(t, dir, "policy.yaml", policies)
+	fixtures := writeFile(t, dir, "failing.yaml", failing)
+	out, err := execute("--policy-path", policy, "--output", "json", fixtures)
+	assert.Error(t, err)
+	// the error message comes after the report
+	var report Report
+	assert.NoError(t, json.NewDecoder(bytes.NewBufferString(out)).Decode(&report))
+	assert.Equal(t, Report{
+		Results: []Result{{
+			File:     fixtures,
+			Name:     "delete is allowed",
+			Expected: Expectation{Decision: "Allow"},
+			Decision: "Deny",
+			Status:   405,
+			Policy:   "read-only",
+		}},
+		Failed: 1,
+	}, report)
+}
@@ -0,0 +1,163 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/policytest"
+	"google.golang.org/protobuf/encoding/protojson"
+	"sigs.k8s.io/yaml"
+)
+
+// Fixtures is the content of a fixtures file
+type Fixtures struct {
+	Tests []Fixture `json:"tests"`
+}
+
+// Fixture is a check request and the decision it is expected to produce
+type Fixture struct {
+	// Name identifies the fixture in the report
+	Name string `json:"name"`
+	// Input is the envoy check request, in its json (or yaml) form
+	Input json.RawMessage `json:"input"`
+	// Expect is the expected decision
+	Expect Expectation `json:"expect"`
+}
+
+// Expectation describes the expected decision, empty fields are not checked
+type Expectation struct {
+	// Decision is Allow or Deny
+	Decision authz.Decision `json:"decision"`
+	// Status is the http status code of a denied request
+	Status int32 `json:"status,omitempty"`
+	// Policy is the name of the policy expected to take the decision
+	Policy string `json:"policy,omitempty"`
+}
+
+// Result is the outcome of a fixture
+type Result struct {
+	File     string         `json:"file"`
+	Name     string         `json:"name"`
+	Passed   bool           `json:"passed"`
+	Expected Expectation    `json:"expected"`
+	Decision authz.Decision `json:"decision,omitempty"`
+	Status   int32          `json:"status,omitempty"`
+	Policy   string         `json:"policy,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// Report is the outcome of all fixtures
+type Report struct {
+	Results []Result `json:"results"`
+	Passed  int      `json:"passed"`
+	Failed  int      `json:"failed"`
+}
+
+func loadFixtures(path string) ([]Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fixtures Fixtures
+	if err := yaml.UnmarshalStrict(data, &fixtures); err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", path, err)
+	}
+	return fixtures.Tests, nil
+}
+
+func validateExpectation(expect Expectation) error {
+	switch expect.Decision {
+	case authz.DecisionAllow, authz.DecisionDeny:
+		return nil
+	default:
+		return fmt.Errorf("invalid expected decision %q, expected %q or %q", expect.Decision, authz.DecisionAllow, authz.DecisionDeny)
+	}
+}
+
+func runFixture(provider policy.Provider, file string, fixture Fixture) Result {
+	result := Result{
+		File:     file,
+		Name:     fixture.Name,
+		Expected: fixture.Expect,
+	}
+	if err := validateExpectation(fixture.Expect); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	var input authv3.CheckRequest
+	if len(fixture.Input) > 0 {
+		if err := protojson.Unmarshal(fixture.Input, &input); err != nil {
+			result.Error = fmt.Sprintf("invalid input: %s", err)
+			return result
+		}
+	}
+	decision, err := policytest.Evaluate(provider, &input)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Decision = authz.DecisionDeny
+	if decision.Allowed {
+		result.Decision = authz.DecisionAllow
+	}
+	result.Status = decision.HttpStatus
+	result.Policy = decision.Policy
+	result.Passed = result.Decision == fixture.Expect.Decision &&
+		(fixture.Expect.Status == 0 || fixture.Expect.Status == result.Status) &&
+		(fixture.Expect.Policy == "" || fixture.Expect.Policy == result.Policy)
+	return result
+}
+
+func run(provider policy.Provider, files ...string) (Report, error) {
+	var report Report
+	for _, file := range files {
+		fixtures, err := loadFixtures(file)
+		if err != nil {
+			return report, err
+		}
+		for _, fixture := range fixtures {
+			result := runFixture(provider, file, fixture)
+			if result.Passed {
+				report.Passed++
+			} else {
+				report.Failed++
+			}
+			report.Results = append(report.Results, result)
+		}
+	}
+	return report, nil
+}
+
+// describe returns a human readable description of a result
+func describe(result Result) string {
+	if result.Error != "" {
+		return result.Error
+	}
+	var parts []string
+	parts = append(parts, string(result.Decision))
+	if result.Decision == authz.DecisionDeny {
+		parts = append(parts, fmt.Sprintf("status %d", result.Status))
+	}
+	if result.Policy != "" {
+		parts = append(parts, fmt.Sprintf("policy %s", result.Policy))
+	} else {
+		parts = append(parts, "default decision")
+	}
+	got := strings.Join(parts, ", ")
+	if result.Passed {
+		return got
+	}
+	parts = []string{string(result.Expected.Decision)}
+	if result.Expected.Status != 0 {
+		parts = append(parts, fmt.Sprintf("status %d", result.Expected.Status))
+	}
+	if result.Expected.Policy != "" {
+		parts = append(parts, fmt.Sprintf("policy %s", result.Expected.Policy))
+	}
+	return fmt.Sprintf("expected %s, got %s", strings.Join(parts, ", "), got)
+}
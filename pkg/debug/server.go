@@ -0,0 +1,80 @@
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/server"
+)
+
+// PoliciesResponse is the response of the policies dump endpoint
+type PoliciesResponse struct {
+	Count    int      `json:"count"`
+	Policies []string `json:"policies"`
+}
+
+// NewServer returns a server exposing pprof profiles and the compiled policies,
+// it exposes internals and can only listen on a loopback address
+func NewServer(addr string, provider policy.Provider) (server.ServerFunc, error) {
+	if err := validateAddress(addr); err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) error {
+		// create server
+		s := &http.Server{
+			Addr:    addr,
+			Handler: newHandler(provider),
+		}
+		// run server
+		return server.RunHttp(ctx, s, "", "")
+	}, nil
+}
+
+func newHandler(provider policy.Provider) http.Handler {
+	// create mux
+	mux := http.NewServeMux()
+	// register pprof handlers, the index serves the goroutine, heap and other runtime profiles
+	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	// register policies dump handler
+	mux.HandleFunc("GET /debug/policies", func(w http.ResponseWriter, r *http.Request) {
+		policies, err := provider.CompiledPolicies(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// policies are listed in evaluation order
+		response := PoliciesResponse{
+			Count:    len(policies),
+			Policies: make([]string, 0, len(policies)),
+		}
+		for _, policy := range policies {
+			response.Policies = append(response.Policies, policy.Name)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(response)
+	})
+	return mux
+}
+
+func validateAddress(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("debug server address must be a loopback address, got %s", addr)
+}
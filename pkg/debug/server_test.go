@@ -0,0 +1,76 @@
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/stretchr/testify/assert"
+)
+
+type mutableProvider struct {
+	lock     sync.Mutex
+	policies []policy.CompiledPolicy
+}
+
+func (p *mutableProvider) CompiledPolicies(context.Context) ([]policy.CompiledPolicy, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.policies, nil
+}
+
+func (p *mutableProvider) HasSynced() bool {
+	return true
+}
+
+func (p *mutableProvider) set(names ...string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.policies = nil
+	for _, name := range names {
+		p.policies = append(p.policies, policy.CompiledPolicy{Name: name})
+	}
+}
+
+func Test_handler_policies(t *testing.T) {
+	provider := &mutableProvider{}
+	handler := newHandler(provider)
+	get := func() PoliciesResponse {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/policies", nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		var response PoliciesResponse
+		assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+		return response
+	}
+	assert.Equal(t, PoliciesResponse{Count: 0, Policies: []string{}}, get())
+	provider.set("b", "a")
+	assert.Equal(t, PoliciesResponse{Count: 2, Policies: []string{"b", "a"}}, get())
+	provider.set("a")
+	assert.Equal(t, PoliciesResponse{Count: 1, Policies: []string{"a"}}, get())
+}
+
+func Test_handler_pprof(t *testing.T) {
+	handler := newHandler(&mutableProvider{})
+	for _, profile := range []string{"goroutine", "heap"} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/pprof/"+profile+"?debug=1", nil))
+		assert.Equal(t, http.StatusOK, recorder.Code, profile)
+		assert.NotEmpty(t, recorder.Body.String(), profile)
+	}
+}
+
+func TestNewServer_address(t *testing.T) {
+	for _, addr := range []string{"localhost:9083", "127.0.0.1:9083", "[::1]:9083"} {
+		_, err := NewServer(addr, &mutableProvider{})
+		assert.NoError(t, err, addr)
+	}
+	for _, addr := range []string{":9083", "0.0.0.0:9083", "10.0.0.1:9083", "localhost"} {
+		_, err := NewServer(addr, &mutableProvider{})
+		assert.Error(t, err, addr)
+	}
+}
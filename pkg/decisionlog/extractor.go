@@ -0,0 +1,102 @@
+package decisionlog
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	engine "github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/envoy"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Extractor computes the subject and additional fields of a record from the checked request with CEL expressions,
+// only the data extracted explicitly is written so that personal data can be left out
+type Extractor struct {
+	subject cel.Program
+	fields  map[string]cel.Program
+}
+
+// NewExtractor compiles the subject and fields expressions, the subject expression must return a string.
+// Expressions have access to the check request under the object identifier.
+func NewExtractor(subject string, fields map[string]string) (*Extractor, error) {
+	env, err := engine.NewEnv()
+	if err != nil {
+		return nil, err
+	}
+	env, err = env.Extend(cel.Variable(core.ObjectKey, envoy.CheckRequest))
+	if err != nil {
+		return nil, err
+	}
+	compile := func(expression string) (*cel.Ast, cel.Program, error) {
+		ast, issues := env.Compile(expression)
+		if err := issues.Err(); err != nil {
+			return nil, nil, err
+		}
+		prog, err := env.Program(ast)
+		if err != nil {
+			return nil, nil, err
+		}
+		return ast, prog, nil
+	}
+	extractor := &Extractor{
+		fields: map[string]cel.Program{},
+	}
+	if subject != "" {
+		ast, prog, err := compile(subject)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subject expression: %w", err)
+		}
+		if !ast.OutputType().IsExactType(types.StringType) {
+			return nil, fmt.Errorf("invalid subject expression: output is expected to be of type string")
+		}
+		extractor.subject = prog
+	}
+	for name, expression := range fields {
+		_, prog, err := compile(expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid field %q expression: %w", name, err)
+		}
+		extractor.fields[name] = prog
+	}
+	return extractor, nil
+}
+
+// extract sets the subject and fields of the record, an expression failing to evaluate is left out
+// and its name is returned
+func (e *Extractor) extract(r *authv3.CheckRequest, record *Record) []string {
+	if e == nil {
+		return nil
+	}
+	var failed []string
+	data := map[string]any{core.ObjectKey: r}
+	if e.subject != nil {
+		if out, _, err := e.subject.Eval(data); err == nil {
+			if subject, ok := out.Value().(string); ok {
+				record.Subject = subject
+			}
+		} else {
+			failed = append(failed, "subject")
+		}
+	}
+	for name, field := range e.fields {
+		out, _, err := field.Eval(data)
+		if err == nil {
+			var value any
+			if value, err = out.ConvertToNative(reflect.TypeFor[*structpb.Value]()); err == nil {
+				if record.Fields == nil {
+					record.Fields = map[string]any{}
+				}
+				record.Fields[name] = value.(*structpb.Value).AsInterface()
+				continue
+			}
+		}
+		failed = append(failed, name)
+	}
+	slices.Sort(failed)
+	return failed
+}
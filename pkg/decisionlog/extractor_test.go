@@ -0,0 +1,80 @@
+package decisionlog
+
+import (
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+)
+
+func TestNewExtractor(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		fields  map[string]string
+		wantErr bool
+	}{{
+		name: "empty",
+	}, {
+		name:    "valid",
+		subject: `object.attributes.request.http.headers[?"x-user"].orValue("")`,
+		fields:  map[string]string{"method": "object.attributes.request.http.method"},
+	}, {
+		name:    "subject not a string",
+		subject: `1`,
+		wantErr: true,
+	}, {
+		name:    "invalid subject",
+		subject: `object.`,
+		wantErr: true,
+	}, {
+		name:    "invalid field",
+		fields:  map[string]string{"bad": "unknown.field"},
+		wantErr: true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewExtractor(tt.subject, tt.fields)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestExtractor_extract(t *testing.T) {
+	extractor, err := NewExtractor(`object.attributes.request.http.headers["x-user"]`, map[string]string{
+		"method":  "object.attributes.request.http.method",
+		"tenant":  `object.attributes.request.http.headers["x-tenant"]`,
+		"segment": `object.attributes.request.http.path.split("/")[1]`,
+	})
+	require.NoError(t, err)
+	r := &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Id:      "42",
+					Method:  "GET",
+					Host:    "example.com",
+					Path:    "/api/users?token=secret",
+					Headers: map[string]string{"x-user": "alice"},
+				},
+			},
+		},
+	}
+	record := newRecord(time.Unix(0, 0), r, &authv3.CheckResponse{Status: &status.Status{Code: int32(codes.OK)}}, nil)
+	failed := extractor.extract(r, &record)
+	// missing headers fail the expression, the field is left out
+	assert.Equal(t, []string{"tenant"}, failed)
+	assert.Equal(t, "alice", record.Subject)
+	assert.Equal(t, map[string]any{"method": "GET", "segment": "api"}, record.Fields)
+	assert.Equal(t, "allow", record.Decision)
+	// the query string is not recorded
+	assert.Equal(t, RequestMetadata{ID: "42", Method: "GET", Host: "example.com", Path: "/api/users"}, record.Request)
+}
@@ -0,0 +1,65 @@
+package decisionlog
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaSink produces records as JSON messages keyed by request id
+type kafkaSink struct {
+	writer *kafka.Writer
+}
+
+// NewKafkaSink returns a sink producing records to a Kafka topic, messages are produced asynchronously
+// in batches. The client retries failed batches with the retry settings, the messages of the batches failing
+// once the retries are exhausted are counted as write failures and written to the dead letter.
+func NewKafkaSink(brokers []string, topic string, retry Retry, deadLetter *DeadLetter, metrics *metrics.Metrics) Sink {
+	writer := &kafka.Writer{
+		Addr:            kafka.TCP(brokers...),
+		Topic:           topic,
+		Balancer:        &kafka.Hash{},
+		Async:           true,
+		MaxAttempts:     retry.Attempts,
+		WriteBackoffMin: retry.Backoff,
+		WriteBackoffMax: retry.MaxBackoff,
+	}
+	writer.Completion = func(messages []kafka.Message, err error) {
+		// the client counts the retries since the previous snapshot
+		if retries := writer.Stats().Retries; retries > 0 {
+			metrics.RecordDecisionLogRetries("kafka", int(retries))
+		}
+		if err == nil {
+			return
+		}
+		// the completion runs without a logger, records the dead letter failed to store are only counted
+		// as write failures and not as dead lettered
+		for _, message := range messages {
+			metrics.RecordDecisionLogFailure("kafka")
+			_ = deadLetter.write("kafka", message.Value)
+		}
+	}
+	return &kafkaSink{writer: writer}
+}
+
+func (s *kafkaSink) Name() string {
+	return "kafka"
+}
+
+func (s *kafkaSink) Write(record Record) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	// async writes return immediately, the context only bounds enqueuing the message
+	return s.writer.WriteMessages(context.Background(), kafka.Message{
+		Key:   []byte(record.Request.ID),
+		Value: value,
+	})
+}
+
+func (s *kafkaSink) Close() error {
+	return s.writer.Close()
+}
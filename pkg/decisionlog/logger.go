@@ -0,0 +1,127 @@
+package decisionlog
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/redact"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// DefaultBufferSize is the default number of records buffered per sink
+const DefaultBufferSize = 1024
+
+// Sink writes decision records, Write is never called concurrently
+type Sink interface {
+	// Name identifies the sink in metrics and logs
+	Name() string
+	Write(Record) error
+	// Close flushes the records written so far
+	Close() error
+}
+
+// Logger records decisions to sinks without blocking the request path: every sink has its own bounded
+// buffer, records are dropped when the buffer of a sink is full and the drop is counted.
+type Logger struct {
+	extractor *Extractor
+	sampler   *Sampler
+	redactor  *redact.Redactor
+	sinks     []*bufferedSink
+	metrics   *metrics.Metrics
+	now       func() time.Time
+}
+
+type bufferedSink struct {
+	sink    Sink
+	records chan Record
+}
+
+// NewLogger returns a logger writing to the given sinks, records are buffered until Run writes them.
+// Records left out by the sampler are not written, a nil sampler writes every record.
+// The values redacted by the redactor are redacted before the record and its fields are built.
+func NewLogger(extractor *Extractor, sampler *Sampler, redactor *redact.Redactor, bufferSize int, metrics *metrics.Metrics, sinks ...Sink) *Logger {
+	l := &Logger{
+		extractor: extractor,
+		sampler:   sampler,
+		redactor:  redactor,
+		metrics:   metrics,
+		now:       time.Now,
+	}
+	for _, sink := range sinks {
+		l.sinks = append(l.sinks, &bufferedSink{sink: sink, records: make(chan Record, bufferSize)})
+	}
+	return l
+}
+
+// Log records the decision taken for a request, it never blocks. The record carries the decision id and the span
+// of the context.
+func (l *Logger) Log(ctx context.Context, r *authv3.CheckRequest, response *authv3.CheckResponse, err error) {
+	if l == nil || len(l.sinks) == 0 {
+		return
+	}
+	// errors can quote request values, they are redacted before the request
+	err = l.redactor.Error(r, err)
+	r = l.redactor.Request(r)
+	record := newRecord(l.now(), r, response, err)
+	record.DecisionID = core.DecisionID(ctx)
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.TraceID = span.TraceID().String()
+		record.SpanID = span.SpanID().String()
+	}
+	// the decision is counted by the evaluation metrics whether its record is sampled or not
+	if !l.sampler.sample(&record) {
+		return
+	}
+	record.FailedExtractions = l.extractor.extract(r, &record)
+	for _, sink := range l.sinks {
+		select {
+		case sink.records <- record:
+		default:
+			l.metrics.RecordDecisionLogDrop(sink.sink.Name())
+		}
+	}
+}
+
+// Run writes the buffered records until the context is cancelled, the records buffered at that time
+// are written before the sinks are closed
+func (l *Logger) Run(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("decision-log")
+	var group wait.Group
+	errs := make([]error, len(l.sinks))
+	for i, sink := range l.sinks {
+		group.Start(func() {
+			logger := logger.WithValues("sink", sink.sink.Name())
+			write := func(record Record) {
+				if err := sink.sink.Write(record); err != nil {
+					l.metrics.RecordDecisionLogFailure(sink.sink.Name())
+					logger.Error(err, "failed to write decision record")
+				}
+			}
+			for {
+				select {
+				case record := <-sink.records:
+					write(record)
+				case <-ctx.Done():
+					// flush the buffer, requests still in flight can add records that are dropped
+					for {
+						select {
+						case record := <-sink.records:
+							write(record)
+						default:
+							errs[i] = sink.sink.Close()
+							return
+						}
+					}
+				}
+			}
+		})
+	}
+	group.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,164 @@
+package decisionlog
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/redact"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memorySink records what is written, writes block until unblock is closed
+type memorySink struct {
+	sync.Mutex
+	unblock chan struct{}
+	err     error
+	records []Record
+	closed  bool
+}
+
+func (s *memorySink) Name() string {
+	return "memory"
+}
+
+func (s *memorySink) Write(record Record) error {
+	if s.unblock != nil {
+		<-s.unblock
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.records = append(s.records, record)
+	return s.err
+}
+
+func (s *memorySink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	s.closed = true
+	return nil
+}
+
+func checkRequest(id string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{Id: id},
+			},
+		},
+	}
+}
+
+func TestLogger_overflow(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := metrics.New(registry)
+	require.NoError(t, err)
+	sink := &memorySink{unblock: make(chan struct{})}
+	logger := NewLogger(nil, nil, nil, 2, m, sink)
+	// nothing reads the buffer, records beyond its size are dropped without blocking
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, id := range []string{"1", "2", "3", "4", "5"} {
+			logger.Log(context.Background(), checkRequest(id), nil, errors.New("failed"))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("logging blocked")
+	}
+	expected := `
+# HELP decision_log_dropped_total Number of decision records dropped because the sink buffer was full, partitioned by sink.
+# TYPE decision_log_dropped_total counter
+decision_log_dropped_total{sink="memory"} 3
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "decision_log_dropped_total"))
+	// buffered records are written when the logger stops
+	close(sink.unblock)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, logger.Run(ctx))
+	var ids []string
+	for _, record := range sink.records {
+		ids = append(ids, record.Request.ID)
+	}
+	assert.Equal(t, []string{"1", "2"}, ids)
+	assert.True(t, sink.closed)
+}
+
+func TestLogger_Run(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := metrics.New(registry)
+	require.NoError(t, err)
+	sink := &memorySink{err: errors.New("write failed")}
+	logger := NewLogger(nil, nil, nil, DefaultBufferSize, m, sink)
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		errs <- logger.Run(ctx)
+	}()
+	logger.Log(context.Background(), checkRequest("1"), nil, nil)
+	// write failures are counted
+	assert.Eventually(t, func() bool {
+		return testutil.CollectAndCount(registry, "decision_log_write_failures_total") == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-errs)
+	expected := `
+# HELP decision_log_write_failures_total Number of decision records a sink failed to write, partitioned by sink.
+# TYPE decision_log_write_failures_total counter
+decision_log_write_failures_total{sink="memory"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "decision_log_write_failures_total"))
+	require.Len(t, sink.records, 1)
+	assert.Equal(t, "error", sink.records[0].Decision)
+	assert.True(t, sink.closed)
+}
+
+func TestLogger_nil(t *testing.T) {
+	var logger *Logger
+	assert.NotPanics(t, func() {
+		logger.Log(context.Background(), checkRequest("1"), nil, nil)
+	})
+}
+
+func TestLogger_redaction(t *testing.T) {
+	extractor, err := NewExtractor(`object.attributes.request.http.headers[?"x-user-email"].orValue("")`, map[string]string{
+		"authorization": `object.attributes.request.http.headers[?"authorization"].orValue("")`,
+		"api-key":       `object.attributes.request.http.headers[?"x-api-key"].orValue("")`,
+	})
+	require.NoError(t, err)
+	redactor, err := redact.New([]redact.Rule{
+		{Header: "x-user-email", Action: redact.ActionHash},
+		{Path: `object.attributes.request.http.headers["x-api-key"]`, Action: redact.ActionMask},
+	}, "salt")
+	require.NoError(t, err)
+	sink := &memorySink{}
+	logger := NewLogger(extractor, nil, redactor, DefaultBufferSize, nil, sink)
+	request := checkRequest("1")
+	request.Attributes.Request.Http.Headers = map[string]string{
+		"authorization": "Bearer token",
+		"x-user-email":  "alice@example.com",
+		"x-api-key":     "s3cret",
+	}
+	logger.Log(context.Background(), request, nil, errors.New("invalid api key s3cret for alice@example.com"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, logger.Run(ctx))
+	require.Len(t, sink.records, 1)
+	record := sink.records[0]
+	assert.Regexp(t, `^hash:`, record.Subject)
+	assert.Equal(t, map[string]any{"authorization": redact.Mask, "api-key": redact.Mask}, record.Fields)
+	assert.Equal(t, "invalid api key "+redact.Mask+" for "+record.Subject, record.Error)
+	// the logged request is not modified
+	assert.Equal(t, "Bearer token", request.GetAttributes().GetRequest().GetHttp().GetHeaders()["authorization"])
+}
@@ -0,0 +1,268 @@
+package decisionlog
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+)
+
+// DefaultOTLPBatchSize is the default number of records exported per OTLP request
+const DefaultOTLPBatchSize = 512
+
+// DefaultOTLPFlushInterval is the default delay after which a partial batch is exported
+const DefaultOTLPFlushInterval = time.Second
+
+const (
+	// otlpExportTimeout bounds an export request, including its retries
+	otlpExportTimeout = 10 * time.Second
+	otlpScopeName     = "github.com/kyverno/kyverno-envoy-plugin/pkg/decisionlog"
+	otlpServiceName   = "kyverno-authz-server"
+)
+
+// otlpSink exports records as OTLP log records in batches
+type otlpSink struct {
+	lock       sync.Mutex
+	conn       *grpc.ClientConn
+	client     collogspb.LogsServiceClient
+	batchSize  int
+	batch      []Record
+	retry      Retry
+	deadLetter *DeadLetter
+	metrics    *metrics.Metrics
+	sleep      func(time.Duration)
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// NewOTLPSink returns a sink exporting records as OTLP log records to the logs service of the connection, it closes
+// the connection when closed. Records are exported once a batch is full or once the flush interval elapsed since the
+// previous export (zero only exports full batches). A full batch is exported before the next record is written so a
+// slow collector fills the buffer of the sink and records are dropped, like for the other sinks.
+// Failed exports are retried with the retry settings, the records of the batches failing once the retries are
+// exhausted are counted as write failures and written to the dead letter.
+func NewOTLPSink(conn *grpc.ClientConn, batchSize int, flushInterval time.Duration, retry Retry, deadLetter *DeadLetter, metrics *metrics.Metrics) Sink {
+	s := &otlpSink{
+		conn:       conn,
+		client:     collogspb.NewLogsServiceClient(conn),
+		batchSize:  max(batchSize, 1),
+		retry:      retry,
+		deadLetter: deadLetter,
+		metrics:    metrics,
+		sleep:      time.Sleep,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go s.run(flushInterval)
+	return s
+}
+
+func (s *otlpSink) Name() string {
+	return "otlp"
+}
+
+// Write adds a record to the batch, the batch is exported when full. The export failures are handled by the sink,
+// Write never fails.
+func (s *otlpSink) Write(record Record) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.batch = append(s.batch, record)
+	if len(s.batch) >= s.batchSize {
+		s.flush()
+	}
+	return nil
+}
+
+func (s *otlpSink) Close() error {
+	close(s.stop)
+	<-s.done
+	s.lock.Lock()
+	s.flush()
+	s.lock.Unlock()
+	return s.conn.Close()
+}
+
+// run exports the partial batches every flush interval until the sink is closed
+func (s *otlpSink) run(flushInterval time.Duration) {
+	defer close(s.done)
+	if flushInterval <= 0 {
+		<-s.stop
+		return
+	}
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.lock.Lock()
+			s.flush()
+			s.lock.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// flush exports the batch, it must be called with the lock held
+func (s *otlpSink) flush() {
+	if len(s.batch) == 0 {
+		return
+	}
+	batch := s.batch
+	s.batch = nil
+	response, err := s.export(batch)
+	if err != nil {
+		// the flush runs without a logger, records the dead letter failed to store are only counted as write
+		// failures and not as dead lettered
+		for _, record := range batch {
+			s.metrics.RecordDecisionLogFailure(s.Name())
+			_ = s.deadLetter.writeRecord(s.Name(), record)
+		}
+		return
+	}
+	// the collector doesn't tell which records it rejected, they are only counted
+	for range response.GetPartialSuccess().GetRejectedLogRecords() {
+		s.metrics.RecordDecisionLogFailure(s.Name())
+	}
+}
+
+// export sends a batch to the collector, retrying the failed requests
+func (s *otlpSink) export(batch []Record) (*collogspb.ExportLogsServiceResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), otlpExportTimeout)
+	defer cancel()
+	request := newOTLPRequest(batch)
+	response, err := s.client.Export(ctx, request)
+	backoff := s.retry.Backoff
+	for attempt := 1; err != nil && attempt < s.retry.Attempts && ctx.Err() == nil; attempt++ {
+		s.sleep(backoff)
+		backoff = s.retry.next(backoff)
+		s.metrics.RecordDecisionLogRetries(s.Name(), 1)
+		response, err = s.client.Export(ctx, request)
+	}
+	return response, err
+}
+
+// newOTLPRequest returns the export request of a batch, the records share the resource and scope of the server
+func newOTLPRequest(batch []Record) *collogspb.ExportLogsServiceRequest {
+	records := make([]*logspb.LogRecord, 0, len(batch))
+	for _, record := range batch {
+		records = append(records, newLogRecord(record))
+	}
+	return &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{{
+			Resource: &resourcepb.Resource{
+				Attributes: []*commonpb.KeyValue{stringAttribute("service.name", otlpServiceName)},
+			},
+			ScopeLogs: []*logspb.ScopeLogs{{
+				Scope:      &commonpb.InstrumentationScope{Name: otlpScopeName},
+				LogRecords: records,
+			}},
+		}},
+	}
+}
+
+// newLogRecord returns the log record of a decision record: the decision record is the body, the fields identifying
+// the decision and its policy are attributes and the check span is the trace context
+func newLogRecord(record Record) *logspb.LogRecord {
+	timestamp := uint64(record.Timestamp.UnixNano())
+	logRecord := &logspb.LogRecord{
+		TimeUnixNano:         timestamp,
+		ObservedTimeUnixNano: timestamp,
+		SeverityNumber:       severity(record.Decision),
+		SeverityText:         record.Decision,
+		Body:                 recordBody(record),
+	}
+	// the ids are empty when the request is not traced, they are hex encoded by the record
+	logRecord.TraceId, _ = hex.DecodeString(record.TraceID)
+	logRecord.SpanId, _ = hex.DecodeString(record.SpanID)
+	add := func(key, value string) {
+		if value != "" {
+			logRecord.Attributes = append(logRecord.Attributes, stringAttribute(key, value))
+		}
+	}
+	add("decision", record.Decision)
+	add("decision.id", record.DecisionID)
+	add("policy.name", record.Policy)
+	add("policy.reason", record.Reason)
+	for _, key := range slices.Sorted(maps.Keys(record.Annotations)) {
+		add("policy.annotations."+key, record.Annotations[key])
+	}
+	logRecord.Attributes = append(logRecord.Attributes, intAttribute("rpc.grpc.status_code", int64(record.Code)))
+	if record.HttpStatus != 0 {
+		logRecord.Attributes = append(logRecord.Attributes, intAttribute("http.response.status_code", int64(record.HttpStatus)))
+	}
+	add("http.request.method", record.Request.Method)
+	add("server.address", record.Request.Host)
+	add("url.path", record.Request.Path)
+	add("enduser.id", record.Subject)
+	return logRecord
+}
+
+// severity returns the severity of a decision, denials are warnings and failed checks errors
+func severity(decision string) logspb.SeverityNumber {
+	switch decision {
+	case metrics.DecisionAllow:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_INFO
+	case metrics.DecisionDeny:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_WARN
+	default:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_ERROR
+	}
+}
+
+// recordBody returns the decision record as a map value, keyed like the JSON record of the other sinks
+func recordBody(record Record) *commonpb.AnyValue {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return &commonpb.AnyValue{}
+	}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return &commonpb.AnyValue{}
+	}
+	return anyValue(value)
+}
+
+// anyValue converts a decoded JSON value, JSON numbers are doubles
+func anyValue(value any) *commonpb.AnyValue {
+	switch value := value.(type) {
+	case string:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}
+	case bool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: value}}
+	case float64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: value}}
+	case []any:
+		values := make([]*commonpb.AnyValue, 0, len(value))
+		for _, item := range value {
+			values = append(values, anyValue(item))
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: values}}}
+	case map[string]any:
+		values := make([]*commonpb.KeyValue, 0, len(value))
+		for _, key := range slices.Sorted(maps.Keys(value)) {
+			values = append(values, &commonpb.KeyValue{Key: key, Value: anyValue(value[key])})
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{Values: values}}}
+	default:
+		return &commonpb.AnyValue{}
+	}
+}
+
+func stringAttribute(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func intAttribute(key string, value int64) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: value}}}
+}
@@ -0,0 +1,209 @@
+package decisionlog
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// memoryCollector is an in-memory OTLP logs service, it fails the first exports
+type memoryCollector struct {
+	collogspb.UnimplementedLogsServiceServer
+	sync.Mutex
+	failures int
+	requests []*collogspb.ExportLogsServiceRequest
+}
+
+func (c *memoryCollector) Export(_ context.Context, request *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.failures > 0 {
+		c.failures--
+		return nil, status.Error(codes.Unavailable, "collector unavailable")
+	}
+	c.requests = append(c.requests, request)
+	return &collogspb.ExportLogsServiceResponse{}, nil
+}
+
+// batches returns the number of log records of every export
+func (c *memoryCollector) batches() []int {
+	c.Lock()
+	defer c.Unlock()
+	var batches []int
+	for _, request := range c.requests {
+		batches = append(batches, len(request.GetResourceLogs()[0].GetScopeLogs()[0].GetLogRecords()))
+	}
+	return batches
+}
+
+// newOTLPConn returns a connection to a collector served in memory
+func newOTLPConn(t *testing.T, collector *memoryCollector) *grpc.ClientConn {
+	t.Helper()
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	collogspb.RegisterLogsServiceServer(server, collector)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+	conn, err := grpc.NewClient("passthrough:///collector",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	return conn
+}
+
+func attributes(values []*commonpb.KeyValue) map[string]any {
+	out := map[string]any{}
+	for _, value := range values {
+		switch v := value.GetValue().GetValue().(type) {
+		case *commonpb.AnyValue_StringValue:
+			out[value.GetKey()] = v.StringValue
+		case *commonpb.AnyValue_IntValue:
+			out[value.GetKey()] = v.IntValue
+		}
+	}
+	return out
+}
+
+func TestNewOTLPSink(t *testing.T) {
+	collector := &memoryCollector{}
+	sink := NewOTLPSink(newOTLPConn(t, collector), DefaultOTLPBatchSize, DefaultOTLPFlushInterval, Retry{}, nil, nil)
+	logger := NewLogger(nil, nil, nil, DefaultBufferSize, nil, sink)
+	// the check span of the request is active
+	traceID, _ := trace.TraceIDFromHex("0af7651916cd43dd8448eb211c80319c")
+	spanID, _ := trace.SpanIDFromHex("b7ad6b7169203331")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+	ctx = core.WithDecisionID(ctx, "1")
+	attribution, err := structpb.NewStruct(map[string]any{
+		core.MetadataPolicyKey:      "demo",
+		core.MetadataReasonKey:      "missing team header",
+		core.MetadataAnnotationsKey: map[string]any{"owner.example.com/team": "payments"},
+	})
+	require.NoError(t, err)
+	response := &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(codes.PermissionDenied)},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: &authv3.DeniedHttpResponse{
+			Status: &typev3.HttpStatus{Code: typev3.StatusCode_Forbidden},
+		}},
+		DynamicMetadata: &structpb.Struct{Fields: map[string]*structpb.Value{core.MetadataKey: structpb.NewStructValue(attribution)}},
+	}
+	request := checkRequest("1")
+	request.Attributes.Request.Http.Method = "GET"
+	request.Attributes.Request.Http.Path = "/api?token=secret"
+	logger.Log(ctx, request, response, nil)
+	runCtx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		errs <- logger.Run(runCtx)
+	}()
+	// the partial batch is exported once the flush interval elapsed
+	assert.Eventually(t, func() bool {
+		return len(collector.batches()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-errs)
+	resourceLogs := collector.requests[0].GetResourceLogs()[0]
+	assert.Equal(t, map[string]any{"service.name": "kyverno-authz-server"}, attributes(resourceLogs.GetResource().GetAttributes()))
+	assert.Equal(t, otlpScopeName, resourceLogs.GetScopeLogs()[0].GetScope().GetName())
+	record := resourceLogs.GetScopeLogs()[0].GetLogRecords()[0]
+	assert.Equal(t, traceID[:], record.GetTraceId())
+	assert.Equal(t, spanID[:], record.GetSpanId())
+	assert.Equal(t, logspb.SeverityNumber_SEVERITY_NUMBER_WARN, record.GetSeverityNumber())
+	assert.Equal(t, "deny", record.GetSeverityText())
+	assert.Equal(t, map[string]any{
+		"decision":      "deny",
+		"decision.id":   "1",
+		"policy.name":   "demo",
+		"policy.reason": "missing team header",
+		"policy.annotations.owner.example.com/team": "payments",
+		"rpc.grpc.status_code":                      int64(codes.PermissionDenied),
+		"http.response.status_code":                 int64(403),
+		"http.request.method":                       "GET",
+		"url.path":                                  "/api",
+	}, attributes(record.GetAttributes()))
+	// the body is the decision record
+	body := map[string]*commonpb.AnyValue{}
+	for _, value := range record.GetBody().GetKvlistValue().GetValues() {
+		body[value.GetKey()] = value.GetValue()
+	}
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", body["traceId"].GetStringValue())
+	assert.Equal(t, "demo", body["policy"].GetStringValue())
+	assert.Equal(t, float64(403), body["httpStatus"].GetDoubleValue())
+}
+
+func TestNewOTLPSink_batching(t *testing.T) {
+	collector := &memoryCollector{}
+	// without flush interval only full batches are exported until the sink is closed
+	sink := NewOTLPSink(newOTLPConn(t, collector), 2, 0, Retry{}, nil, nil)
+	for _, id := range []string{"1", "2", "3"} {
+		require.NoError(t, sink.Write(Record{Request: RequestMetadata{ID: id}}))
+	}
+	// a full batch is exported before the write returns
+	assert.Equal(t, []int{2}, collector.batches())
+	require.NoError(t, sink.Close())
+	assert.Equal(t, []int{2, 1}, collector.batches())
+	// a record without trace context has no trace nor span id
+	record := collector.requests[1].GetResourceLogs()[0].GetScopeLogs()[0].GetLogRecords()[0]
+	assert.Empty(t, record.GetTraceId())
+	assert.Empty(t, record.GetSpanId())
+}
+
+func TestNewOTLPSink_deadLetter(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := metrics.New(registry)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "dead-letter.log")
+	deadLetter := NewDeadLetter(path, 1, 1, m)
+	// the first batch fails twice and exhausts its retries, the second one succeeds after a retry
+	collector := &memoryCollector{failures: 3}
+	sink := NewOTLPSink(newOTLPConn(t, collector), 2, 0, Retry{Attempts: 2, Backoff: time.Millisecond}, deadLetter, m)
+	for _, id := range []string{"1", "2", "3", "4"} {
+		require.NoError(t, sink.Write(Record{Request: RequestMetadata{ID: id}}))
+	}
+	require.NoError(t, sink.Close())
+	require.NoError(t, deadLetter.Close())
+	assert.Equal(t, []int{2}, collector.batches())
+	assert.Equal(t, []string{"1", "2"}, readDeadLetter(t, path))
+	expected := `
+# HELP decision_log_dead_lettered_total Number of decision records written to the dead letter file after a sink exhausted its retries, partitioned by sink.
+# TYPE decision_log_dead_lettered_total counter
+decision_log_dead_lettered_total{sink="otlp"} 2
+# HELP decision_log_write_failures_total Number of decision records a sink failed to write, partitioned by sink.
+# TYPE decision_log_write_failures_total counter
+decision_log_write_failures_total{sink="otlp"} 2
+# HELP decision_log_write_retries_total Number of decision record writes retried after a sink failure, partitioned by sink.
+# TYPE decision_log_write_retries_total counter
+decision_log_write_retries_total{sink="otlp"} 2
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "decision_log_dead_lettered_total", "decision_log_write_failures_total", "decision_log_write_retries_total"))
+}
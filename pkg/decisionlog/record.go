@@ -0,0 +1,141 @@
+package decisionlog
+
+import (
+	"strings"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Record is the structured decision record written for every checked request
+type Record struct {
+	// Timestamp is the time the decision was taken
+	Timestamp time.Time `json:"timestamp"`
+	// DecisionID identifies the decision, the check span and the response carry the same id
+	DecisionID string `json:"decisionId,omitempty"`
+	// TraceID and SpanID identify the check span when the request is traced
+	TraceID string `json:"traceId,omitempty"`
+	SpanID  string `json:"spanId,omitempty"`
+	// Decision is allow, deny or error
+	Decision string `json:"decision"`
+	// Mutated is true when the request is allowed with mutations, the allow response changes the request sent
+	// upstream or the response returned to the client
+	Mutated bool `json:"mutated,omitempty"`
+	// Code is the grpc status code returned to envoy
+	Code int32 `json:"code"`
+	// HttpStatus is the http status of the denied response, if any
+	HttpStatus int32 `json:"httpStatus,omitempty"`
+	// Policy is the policy responsible for the decision, empty when the default decision applied
+	Policy string `json:"policy,omitempty"`
+	// Reason is the reason of the decision, if the policy declares one
+	Reason string `json:"reason,omitempty"`
+	// Annotations are the allowlisted annotations of the policy responsible for the decision
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// AllowTrail explains an allow decision, when the server collects allow trails
+	AllowTrail *AllowTrail `json:"allowTrail,omitempty"`
+	// Error is the error that failed the check, if any
+	Error string `json:"error,omitempty"`
+	// Subject is the result of the subject expression, if configured
+	Subject string `json:"subject,omitempty"`
+	// Request describes the checked request, it doesn't contain headers nor the query string
+	Request RequestMetadata `json:"request"`
+	// Fields are the results of the field expressions, if configured
+	Fields map[string]any `json:"fields,omitempty"`
+	// FailedExtractions are the subject or fields whose expression failed to evaluate
+	FailedExtractions []string `json:"failedExtractions,omitempty"`
+}
+
+// AllowTrail lists the enforced policies that allowed a request
+type AllowTrail struct {
+	// Policies are the enforced policies that allowed the request, in evaluation order
+	Policies []AllowingPolicy `json:"policies"`
+	// Evaluated is the number of enforced policies evaluated for the request, none of them denied it
+	Evaluated int `json:"evaluated"`
+}
+
+// AllowingPolicy is a policy that allowed a request
+type AllowingPolicy struct {
+	Policy string `json:"policy"`
+	// Rule is the name of the authorization rule that allowed the request, if it has one
+	Rule   string `json:"rule,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// RequestMetadata describes the checked request
+type RequestMetadata struct {
+	ID       string `json:"id,omitempty"`
+	Method   string `json:"method,omitempty"`
+	Host     string `json:"host,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// newRecord returns the record describing a decision, without the configured extractions
+func newRecord(now time.Time, r *authv3.CheckRequest, response *authv3.CheckResponse, err error) Record {
+	http := r.GetAttributes().GetRequest().GetHttp()
+	// the query string can carry sensitive data, it is excluded like headers
+	path, _, _ := strings.Cut(http.GetPath(), "?")
+	record := Record{
+		Timestamp: now.UTC(),
+		Request: RequestMetadata{
+			ID:       http.GetId(),
+			Method:   http.GetMethod(),
+			Host:     http.GetHost(),
+			Path:     path,
+			Protocol: http.GetProtocol(),
+		},
+	}
+	switch {
+	case err != nil:
+		record.Decision = metrics.DecisionError
+		record.Code = int32(codes.Unknown)
+		record.Error = err.Error()
+	case response == nil:
+		record.Decision = metrics.DecisionError
+		record.Code = int32(codes.Unknown)
+	default:
+		record.Code = response.GetStatus().GetCode()
+		if record.Code == int32(codes.OK) {
+			record.Decision = metrics.DecisionAllow
+			record.Mutated = core.ResponseDecision(response) == core.DecisionAllowWithMutations
+		} else {
+			record.Decision = metrics.DecisionDeny
+			record.HttpStatus = int32(response.GetDeniedResponse().GetStatus().GetCode())
+		}
+		// the attribution is set by the policy that took the decision
+		attribution := response.GetDynamicMetadata().GetFields()[core.MetadataKey].GetStructValue().GetFields()
+		record.Policy = attribution[core.MetadataPolicyKey].GetStringValue()
+		record.Reason = attribution[core.MetadataReasonKey].GetStringValue()
+		for key, value := range attribution[core.MetadataAnnotationsKey].GetStructValue().GetFields() {
+			if record.Annotations == nil {
+				record.Annotations = map[string]string{}
+			}
+			record.Annotations[key] = value.GetStringValue()
+		}
+		if trail, ok := attribution[core.MetadataAllowTrailKey]; ok {
+			record.AllowTrail = newAllowTrail(trail.GetStructValue().GetFields())
+		}
+	}
+	return record
+}
+
+// newAllowTrail returns the allow trail of the attribution of a decision
+func newAllowTrail(fields map[string]*structpb.Value) *AllowTrail {
+	trail := &AllowTrail{
+		Policies:  []AllowingPolicy{},
+		Evaluated: int(fields[core.MetadataAllowTrailEvaluatedKey].GetNumberValue()),
+	}
+	for _, value := range fields[core.MetadataAllowTrailPoliciesKey].GetListValue().GetValues() {
+		policy := value.GetStructValue().GetFields()
+		trail.Policies = append(trail.Policies, AllowingPolicy{
+			Policy: policy[core.MetadataPolicyKey].GetStringValue(),
+			Rule:   policy[core.MetadataRuleKey].GetStringValue(),
+			Reason: policy[core.MetadataReasonKey].GetStringValue(),
+		})
+	}
+	return trail
+}
@@ -0,0 +1,81 @@
+package decisionlog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_newRecord_annotations(t *testing.T) {
+	policy := &hub.AuthorizationPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "demo",
+			Annotations: map[string]string{
+				"owner.example.com/team":  "payments",
+				"internal.example.com/id": "42",
+			},
+		},
+		Spec: hub.AuthorizationPolicySpec{
+			Authorizations: []hub.Authorization{{Expression: `envoy.Denied(403).Response()`}},
+		},
+	}
+	compiled, errs := core.NewCompiler(core.WithAnnotationPrefixes("owner.example.com/")).Compile(policy)
+	require.Empty(t, errs)
+	r := checkRequest("1")
+	response, err := compiled.Evaluate(context.Background(), r)
+	require.NoError(t, err)
+	// the allowlisted annotations of the denying policy are recorded
+	record := newRecord(time.Unix(0, 0), r, response, nil)
+	assert.Equal(t, "demo", record.Policy)
+	assert.Equal(t, map[string]string{"owner.example.com/team": "payments"}, record.Annotations)
+	// the default decision has no policy annotations
+	record = newRecord(time.Unix(0, 0), r, &authv3.CheckResponse{}, nil)
+	assert.Nil(t, record.Annotations)
+}
+
+func Test_newRecord_allowTrail(t *testing.T) {
+	trail, err := structpb.NewStruct(map[string]any{
+		core.MetadataPolicyKey: "allow-read",
+		core.MetadataAllowTrailKey: map[string]any{
+			core.MetadataAllowTrailEvaluatedKey: 3,
+			core.MetadataAllowTrailPoliciesKey: []any{
+				map[string]any{core.MetadataPolicyKey: "allow-read", core.MetadataRuleKey: "read", core.MetadataReasonKey: "reading is allowed"},
+				map[string]any{core.MetadataPolicyKey: "allow-all"},
+			},
+		},
+	})
+	require.NoError(t, err)
+	response := &authv3.CheckResponse{DynamicMetadata: &structpb.Struct{Fields: map[string]*structpb.Value{core.MetadataKey: structpb.NewStructValue(trail)}}}
+	record := newRecord(time.Unix(0, 0), checkRequest("1"), response, nil)
+	assert.Equal(t, &AllowTrail{
+		Evaluated: 3,
+		Policies: []AllowingPolicy{
+			{Policy: "allow-read", Rule: "read", Reason: "reading is allowed"},
+			{Policy: "allow-all"},
+		},
+	}, record.AllowTrail)
+	// decisions without a trail don't record one
+	record = newRecord(time.Unix(0, 0), checkRequest("1"), &authv3.CheckResponse{}, nil)
+	assert.Nil(t, record.AllowTrail)
+}
+
+func Test_newRecord_mutated(t *testing.T) {
+	allowed := func(ok *authv3.OkHttpResponse) *authv3.CheckResponse {
+		return &authv3.CheckResponse{HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: ok}}
+	}
+	// a plain allow isn't mutated
+	record := newRecord(time.Unix(0, 0), checkRequest("1"), allowed(&authv3.OkHttpResponse{}), nil)
+	assert.Equal(t, "allow", record.Decision)
+	assert.False(t, record.Mutated)
+	record = newRecord(time.Unix(0, 0), checkRequest("1"), allowed(&authv3.OkHttpResponse{HeadersToRemove: []string{"authorization"}}), nil)
+	assert.Equal(t, "allow", record.Decision)
+	assert.True(t, record.Mutated)
+}
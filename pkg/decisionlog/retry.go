@@ -0,0 +1,134 @@
+package decisionlog
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Retry configures how the failed writes of a sink are retried, a zero Retry keeps the sink defaults:
+// the stdout and file sinks write a record once, the Kafka sink uses the defaults of its client
+type Retry struct {
+	// Attempts is the maximum number of times a record is written, including the first write
+	Attempts int
+	// Backoff is the delay before the first retry, it doubles on every retry
+	Backoff time.Duration
+	// MaxBackoff caps the delay between two retries, zero means no cap
+	MaxBackoff time.Duration
+}
+
+// next returns the delay following the given one
+func (r Retry) next(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if r.MaxBackoff > 0 && backoff > r.MaxBackoff {
+		return r.MaxBackoff
+	}
+	return backoff
+}
+
+// DeadLetter stores as JSON lines the records sinks failed to write once their retries were exhausted, so that
+// they can be replayed. It is shared by the sinks and safe for concurrent use, a nil DeadLetter drops the records.
+type DeadLetter struct {
+	lock    sync.Mutex
+	file    *lumberjack.Logger
+	metrics *metrics.Metrics
+}
+
+// NewDeadLetter returns a dead letter writing to a file, the file is rotated when it reaches maxSize megabytes
+// and at most maxBackups rotated files are kept (all of them if zero)
+func NewDeadLetter(path string, maxSize int, maxBackups int, metrics *metrics.Metrics) *DeadLetter {
+	return &DeadLetter{
+		file: &lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    maxSize,
+			MaxBackups: maxBackups,
+		},
+		metrics: metrics,
+	}
+}
+
+// write stores a JSON encoded record the sink failed to write
+func (d *DeadLetter) write(sink string, value []byte) error {
+	if d == nil {
+		return nil
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, err := d.file.Write(append(value, '\n')); err != nil {
+		return err
+	}
+	d.metrics.RecordDecisionLogDeadLetter(sink)
+	return nil
+}
+
+// writeRecord stores a record the sink failed to write
+func (d *DeadLetter) writeRecord(sink string, record Record) error {
+	if d == nil {
+		return nil
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return d.write(sink, value)
+}
+
+// Close closes the file, it must be called once the sinks are closed
+func (d *DeadLetter) Close() error {
+	if d == nil {
+		return nil
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.file.Close()
+}
+
+// retrySink retries the writes returning an error
+type retrySink struct {
+	sink       Sink
+	retry      Retry
+	deadLetter *DeadLetter
+	metrics    *metrics.Metrics
+	sleep      func(time.Duration)
+}
+
+// NewRetrySink returns a sink retrying the writes returning an error with an exponential backoff, records are
+// written to the dead letter when the retries are exhausted and the write is counted as failed. Asynchronous
+// sinks handle the failures reported after Write returned themselves, like the Kafka sink does.
+// Retries delay the next records of the sink, not the requests: records are dropped when its buffer is full.
+func NewRetrySink(sink Sink, retry Retry, deadLetter *DeadLetter, metrics *metrics.Metrics) Sink {
+	return &retrySink{
+		sink:       sink,
+		retry:      retry,
+		deadLetter: deadLetter,
+		metrics:    metrics,
+		sleep:      time.Sleep,
+	}
+}
+
+func (s *retrySink) Name() string {
+	return s.sink.Name()
+}
+
+func (s *retrySink) Write(record Record) error {
+	err := s.sink.Write(record)
+	backoff := s.retry.Backoff
+	for attempt := 1; err != nil && attempt < s.retry.Attempts; attempt++ {
+		s.sleep(backoff)
+		backoff = s.retry.next(backoff)
+		s.metrics.RecordDecisionLogRetries(s.Name(), 1)
+		err = s.sink.Write(record)
+	}
+	if err != nil {
+		return errors.Join(err, s.deadLetter.writeRecord(s.Name(), record))
+	}
+	return nil
+}
+
+func (s *retrySink) Close() error {
+	return s.sink.Close()
+}
@@ -0,0 +1,176 @@
+package decisionlog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakySink fails the first writes
+type flakySink struct {
+	memorySink
+	failures int
+}
+
+func (s *flakySink) Write(record Record) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("sink unavailable")
+	}
+	s.records = append(s.records, record)
+	return nil
+}
+
+// readDeadLetter returns the request ids of the dead lettered records
+func readDeadLetter(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var ids []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var record Record
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		ids = append(ids, record.Request.ID)
+	}
+	return ids
+}
+
+func TestRetry_next(t *testing.T) {
+	retry := Retry{Backoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	assert.Equal(t, 200*time.Millisecond, retry.next(100*time.Millisecond))
+	assert.Equal(t, 300*time.Millisecond, retry.next(200*time.Millisecond))
+	// without a cap the delay keeps doubling
+	assert.Equal(t, 8*time.Second, Retry{}.next(4*time.Second))
+}
+
+func TestNewRetrySink(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := metrics.New(registry)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "dead-letter.log")
+	deadLetter := NewDeadLetter(path, 1, 1, m)
+	sink := &flakySink{failures: 2}
+	retrying := NewRetrySink(sink, Retry{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}, deadLetter, m).(*retrySink)
+	var delays []time.Duration
+	retrying.sleep = func(delay time.Duration) { delays = append(delays, delay) }
+	assert.Equal(t, "memory", retrying.Name())
+	// the third attempt succeeds
+	require.NoError(t, retrying.Write(Record{Request: RequestMetadata{ID: "1"}}))
+	assert.Equal(t, []time.Duration{time.Millisecond, 2 * time.Millisecond}, delays)
+	require.Len(t, sink.records, 1)
+	// the retries are exhausted, the record is dead lettered
+	sink.failures = 3
+	assert.ErrorContains(t, retrying.Write(Record{Request: RequestMetadata{ID: "2"}}), "sink unavailable")
+	require.NoError(t, retrying.Close())
+	require.NoError(t, deadLetter.Close())
+	assert.True(t, sink.closed)
+	assert.Equal(t, []string{"2"}, readDeadLetter(t, path))
+	expected := `
+# HELP decision_log_dead_lettered_total Number of decision records written to the dead letter file after a sink exhausted its retries, partitioned by sink.
+# TYPE decision_log_dead_lettered_total counter
+decision_log_dead_lettered_total{sink="memory"} 1
+# HELP decision_log_write_retries_total Number of decision record writes retried after a sink failure, partitioned by sink.
+# TYPE decision_log_write_retries_total counter
+decision_log_write_retries_total{sink="memory"} 4
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "decision_log_dead_lettered_total", "decision_log_write_retries_total"))
+}
+
+func TestNewRetrySink_noDeadLetter(t *testing.T) {
+	sink := &flakySink{failures: 1}
+	// a zero retry writes once and the record is dropped
+	assert.Error(t, NewRetrySink(sink, Retry{}, nil, nil).Write(Record{}))
+	assert.Empty(t, sink.records)
+	var deadLetter *DeadLetter
+	assert.NoError(t, deadLetter.Close())
+}
+
+func TestLogger_deadLetter(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := metrics.New(registry)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "dead-letter.log")
+	deadLetter := NewDeadLetter(path, 1, 1, m)
+	sink := &flakySink{failures: 100}
+	retrying := NewRetrySink(sink, Retry{Attempts: 2, Backoff: time.Millisecond}, deadLetter, m).(*retrySink)
+	// the sink hangs in its first backoff until released
+	var once sync.Once
+	release := make(chan struct{})
+	retrying.sleep = func(time.Duration) { once.Do(func() { <-release }) }
+	logger := NewLogger(nil, nil, nil, DefaultBufferSize, m, retrying)
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		errs <- logger.Run(ctx)
+	}()
+	// logging doesn't wait for the retries
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, id := range []string{"1", "2", "3"} {
+			logger.Log(context.Background(), checkRequest(id), nil, nil)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("logging blocked")
+	}
+	close(release)
+	assert.Eventually(t, func() bool {
+		data, err := os.ReadFile(path)
+		return err == nil && strings.Count(string(data), "\n") == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-errs)
+	require.NoError(t, deadLetter.Close())
+	assert.Equal(t, []string{"1", "2", "3"}, readDeadLetter(t, path))
+	expected := `
+# HELP decision_log_write_failures_total Number of decision records a sink failed to write, partitioned by sink.
+# TYPE decision_log_write_failures_total counter
+decision_log_write_failures_total{sink="memory"} 3
+# HELP decision_log_write_retries_total Number of decision record writes retried after a sink failure, partitioned by sink.
+# TYPE decision_log_write_retries_total counter
+decision_log_write_retries_total{sink="memory"} 3
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "decision_log_write_failures_total", "decision_log_write_retries_total"))
+}
+
+func TestNewKafkaSink_deadLetter(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := metrics.New(registry)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "dead-letter.log")
+	deadLetter := NewDeadLetter(path, 1, 1, m)
+	// nothing listens on the broker address, fetching the partitions of the topic fails
+	kafka := NewKafkaSink([]string{"127.0.0.1:1"}, "decisions", Retry{Attempts: 2, Backoff: time.Millisecond}, deadLetter, m)
+	sink := NewRetrySink(kafka, Retry{Attempts: 2, Backoff: time.Millisecond}, deadLetter, m)
+	assert.Equal(t, "kafka", sink.Name())
+	assert.Error(t, sink.Write(Record{Request: RequestMetadata{ID: "1"}}))
+	require.NoError(t, sink.Close())
+	require.NoError(t, deadLetter.Close())
+	assert.Equal(t, []string{"1"}, readDeadLetter(t, path))
+	expected := `
+# HELP decision_log_dead_lettered_total Number of decision records written to the dead letter file after a sink exhausted its retries, partitioned by sink.
+# TYPE decision_log_dead_lettered_total counter
+decision_log_dead_lettered_total{sink="kafka"} 1
+# HELP decision_log_write_retries_total Number of decision record writes retried after a sink failure, partitioned by sink.
+# TYPE decision_log_write_retries_total counter
+decision_log_write_retries_total{sink="kafka"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "decision_log_dead_lettered_total", "decision_log_write_retries_total"))
+}
@@ -0,0 +1,101 @@
+package decisionlog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+)
+
+// sampleDecisions are the decisions a sample rate can be configured for
+var sampleDecisions = []string{metrics.DecisionAllow, metrics.DecisionDeny, metrics.DecisionError}
+
+// Sampler decides whether the record of a decision is written. Records are sampled by request id, the same
+// request is sampled the same way by every replica sharing the seed. The rate of the policy that took the
+// decision applies first, then the rate of the decision, records are written when no rate applies.
+// A nil Sampler writes every record.
+type Sampler struct {
+	seed      uint64
+	policies  map[string]float64
+	decisions map[string]float64
+}
+
+// NewSampler returns a sampler applying the given rates, keyed by policy name and by decision (allow, deny or error).
+// Rates are between 0 (no record is written) and 1 (every record is written).
+func NewSampler(seed uint64, policies, decisions map[string]float64) (*Sampler, error) {
+	for name, rate := range policies {
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("invalid sample rate %v for policy %q, it must be between 0 and 1", rate, name)
+		}
+	}
+	for decision, rate := range decisions {
+		if !slices.Contains(sampleDecisions, decision) {
+			return nil, fmt.Errorf("unknown decision %q, supported values are %v", decision, sampleDecisions)
+		}
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("invalid sample rate %v for decision %q, it must be between 0 and 1", rate, decision)
+		}
+	}
+	return &Sampler{
+		seed:      seed,
+		policies:  policies,
+		decisions: decisions,
+	}, nil
+}
+
+// ParseSampleRates parses the sample rates given as flags, keyed by policy name or decision
+func ParseSampleRates(rates map[string]string) (map[string]float64, error) {
+	out := make(map[string]float64, len(rates))
+	for key, value := range rates {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sample rate %q for %q: %w", value, key, err)
+		}
+		out[key] = rate
+	}
+	return out, nil
+}
+
+// sample returns true if the record must be written
+func (s *Sampler) sample(record *Record) bool {
+	if s == nil {
+		return true
+	}
+	rate, ok := s.policies[record.Policy]
+	if !ok {
+		if rate, ok = s.decisions[record.Decision]; !ok {
+			return true
+		}
+	}
+	switch rate {
+	case 0:
+		return false
+	case 1:
+		return true
+	}
+	// a record without request id can't be sampled consistently
+	if record.Request.ID == "" {
+		return rand.Float64() < rate
+	}
+	return s.hash(record.Request.ID) < rate
+}
+
+// hash maps a request id to a number uniformly distributed in [0, 1)
+func (s *Sampler) hash(id string) float64 {
+	h := fnv.New64a()
+	_ = binary.Write(h, binary.LittleEndian, s.seed)
+	_, _ = h.Write([]byte(id))
+	// fnv doesn't spread similar ids over the high bits, finalize it like splitmix64
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	// the 53 high bits fill the float mantissa
+	return float64(x>>11) / (1 << 53)
+}
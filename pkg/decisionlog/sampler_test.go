@@ -0,0 +1,157 @@
+package decisionlog
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func sampledRecord(id, policy, decision string) *Record {
+	return &Record{Decision: decision, Policy: policy, Request: RequestMetadata{ID: id}}
+}
+
+func TestNewSampler(t *testing.T) {
+	tests := []struct {
+		name      string
+		policies  map[string]float64
+		decisions map[string]float64
+		wantErr   string
+	}{{
+		name:      "valid",
+		policies:  map[string]float64{"demo": 0.5},
+		decisions: map[string]float64{"allow": 0, "deny": 1, "error": 0.1},
+	}, {
+		name:     "policy rate above 1",
+		policies: map[string]float64{"demo": 1.5},
+		wantErr:  `invalid sample rate 1.5 for policy "demo", it must be between 0 and 1`,
+	}, {
+		name:      "negative decision rate",
+		decisions: map[string]float64{"deny": -0.1},
+		wantErr:   `invalid sample rate -0.1 for decision "deny", it must be between 0 and 1`,
+	}, {
+		name:      "unknown decision",
+		decisions: map[string]float64{"none": 0.1},
+		wantErr:   `unknown decision "none"`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSampler(0, tt.policies, tt.decisions)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestParseSampleRates(t *testing.T) {
+	rates, err := ParseSampleRates(map[string]string{"demo": "0.25", "allow": "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"demo": 0.25, "allow": 1}, rates)
+	_, err = ParseSampleRates(map[string]string{"demo": "half"})
+	assert.ErrorContains(t, err, `invalid sample rate "half" for "demo"`)
+}
+
+func TestSampler_rate(t *testing.T) {
+	const total = 100000
+	for _, rate := range []float64{0.01, 0.1, 0.5, 0.9} {
+		t.Run(strconv.FormatFloat(rate, 'f', -1, 64), func(t *testing.T) {
+			sampler, err := NewSampler(42, nil, map[string]float64{"allow": rate})
+			require.NoError(t, err)
+			sampled := 0
+			// sequential ids are the worst case for the hash
+			for i := range total {
+				if sampler.sample(sampledRecord(strconv.Itoa(i), "demo", "allow")) {
+					sampled++
+				}
+			}
+			assert.InDelta(t, rate, float64(sampled)/total, 0.005)
+		})
+	}
+}
+
+func TestSampler_deterministic(t *testing.T) {
+	sampler, err := NewSampler(42, nil, map[string]float64{"allow": 0.5})
+	require.NoError(t, err)
+	replica, err := NewSampler(42, nil, map[string]float64{"allow": 0.5})
+	require.NoError(t, err)
+	reseeded, err := NewSampler(7, nil, map[string]float64{"allow": 0.5})
+	require.NoError(t, err)
+	differ := 0
+	for i := range 1000 {
+		record := sampledRecord("request-"+strconv.Itoa(i), "demo", "allow")
+		// the same request is always sampled the same way, by every replica sharing the seed
+		sampled := sampler.sample(record)
+		assert.Equal(t, sampled, sampler.sample(record))
+		assert.Equal(t, sampled, replica.sample(record))
+		if sampled != reseeded.sample(record) {
+			differ++
+		}
+	}
+	// another seed samples other requests
+	assert.InDelta(t, 500, differ, 100)
+}
+
+func TestSampler_precedence(t *testing.T) {
+	sampler, err := NewSampler(0, map[string]float64{"noisy": 0, "important": 1}, map[string]float64{"allow": 0})
+	require.NoError(t, err)
+	tests := []struct {
+		name   string
+		record *Record
+		want   bool
+	}{{
+		name:   "policy rate",
+		record: sampledRecord("1", "noisy", "deny"),
+		want:   false,
+	}, {
+		name:   "policy rate overrides the decision rate",
+		record: sampledRecord("1", "important", "allow"),
+		want:   true,
+	}, {
+		name:   "decision rate",
+		record: sampledRecord("1", "other", "allow"),
+		want:   false,
+	}, {
+		name:   "default decision",
+		record: sampledRecord("1", "", "allow"),
+		want:   false,
+	}, {
+		name:   "no rate",
+		record: sampledRecord("1", "other", "deny"),
+		want:   true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, sampler.sample(tt.record))
+		})
+	}
+	// a nil sampler writes every record
+	var disabled *Sampler
+	assert.True(t, disabled.sample(sampledRecord("1", "noisy", "deny")))
+}
+
+func TestLogger_sampling(t *testing.T) {
+	sampler, err := NewSampler(0, map[string]float64{"noisy": 0}, nil)
+	require.NoError(t, err)
+	sink := &memorySink{}
+	logger := NewLogger(nil, sampler, nil, DefaultBufferSize, nil, sink)
+	response := func(policy string) *authv3.CheckResponse {
+		metadata, err := structpb.NewStruct(map[string]any{core.MetadataKey: map[string]any{core.MetadataPolicyKey: policy}})
+		require.NoError(t, err)
+		return &authv3.CheckResponse{Status: &status.Status{}, DynamicMetadata: metadata}
+	}
+	logger.Log(context.Background(), checkRequest("1"), response("noisy"), nil)
+	logger.Log(context.Background(), checkRequest("2"), response("quiet"), nil)
+	// only the records of the quiet policy are buffered
+	require.Len(t, logger.sinks[0].records, 1)
+	record := <-logger.sinks[0].records
+	assert.Equal(t, "quiet", record.Policy)
+}
@@ -0,0 +1,53 @@
+package decisionlog
+
+import (
+	"encoding/json"
+	"io"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// writerSink writes records as JSON lines
+type writerSink struct {
+	name    string
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+// NewStdoutSink returns a sink writing records as JSON lines to the given writer, stdout in practice
+func NewStdoutSink(w io.Writer) Sink {
+	return &writerSink{
+		name:    "stdout",
+		encoder: json.NewEncoder(w),
+	}
+}
+
+// NewFileSink returns a sink writing records as JSON lines to a file, the file is rotated when it reaches
+// maxSize megabytes and at most maxBackups rotated files are kept (all of them if zero)
+func NewFileSink(path string, maxSize int, maxBackups int) Sink {
+	file := &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    maxSize,
+		MaxBackups: maxBackups,
+	}
+	return &writerSink{
+		name:    "file",
+		encoder: json.NewEncoder(file),
+		closer:  file,
+	}
+}
+
+func (s *writerSink) Name() string {
+	return s.name
+}
+
+func (s *writerSink) Write(record Record) error {
+	return s.encoder.Encode(record)
+}
+
+func (s *writerSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
@@ -0,0 +1,75 @@
+package decisionlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStdoutSink(t *testing.T) {
+	var out bytes.Buffer
+	sink := NewStdoutSink(&out)
+	assert.Equal(t, "stdout", sink.Name())
+	records := []Record{{
+		Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Decision:  "allow",
+		Policy:    "demo",
+		Request:   RequestMetadata{ID: "1", Method: "GET", Path: "/"},
+	}, {
+		Timestamp: time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC),
+		Decision:  "deny",
+		Code:      7,
+		Request:   RequestMetadata{ID: "2"},
+	}}
+	for _, record := range records {
+		require.NoError(t, sink.Write(record))
+	}
+	require.NoError(t, sink.Close())
+	// one json document per line
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.JSONEq(t, `{"timestamp":"2024-01-02T03:04:05Z","decision":"allow","code":0,"policy":"demo","request":{"id":"1","method":"GET","path":"/"}}`, lines[0])
+	assert.JSONEq(t, `{"timestamp":"2024-01-02T03:04:06Z","decision":"deny","code":7,"request":{"id":"2"}}`, lines[1])
+}
+
+func TestNewFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "decisions.log")
+	sink := NewFileSink(path, 1, 2)
+	assert.Equal(t, "file", sink.Name())
+	for _, id := range []string{"1", "2", "3"} {
+		require.NoError(t, sink.Write(Record{Decision: "allow", Request: RequestMetadata{ID: id}}))
+	}
+	require.NoError(t, sink.Close())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 3)
+	for i, line := range lines {
+		var record Record
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		assert.Equal(t, []string{"1", "2", "3"}[i], record.Request.ID)
+	}
+}
+
+func TestNewFileSink_rotation(t *testing.T) {
+	dir := t.TempDir()
+	sink := NewFileSink(filepath.Join(dir, "decisions.log"), 1, 1)
+	// every record is ~100KiB, the file reaches the 1MiB limit after 10 records
+	record := Record{Decision: "allow", Reason: strings.Repeat("x", 100*1024)}
+	for range 25 {
+		require.NoError(t, sink.Write(record))
+	}
+	require.NoError(t, sink.Close())
+	// rotated files are removed asynchronously
+	assert.Eventually(t, func() bool {
+		entries, err := os.ReadDir(dir)
+		return err == nil && len(entries) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+}
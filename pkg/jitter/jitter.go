@@ -0,0 +1,44 @@
+// Package jitter spreads periodic refreshes over time, replicas started together would otherwise refresh
+// at the same time and overload the backend they refresh from.
+package jitter
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// Factor is the fraction of an interval a refresh is moved by, in both directions, zero disables jitter
+type Factor float64
+
+// Validate checks the factor is in [0, 1)
+func (f Factor) Validate() error {
+	if f < 0 || f >= 1 {
+		return fmt.Errorf("invalid jitter %v, it must be greater than or equal to 0 and lower than 1", float64(f))
+	}
+	return nil
+}
+
+// Interval returns the interval moved by a random duration of up to factor times the interval in both
+// directions, the intervals are uniformly distributed in [interval*(1-factor), interval*(1+factor)] so
+// their mean is the interval
+func (f Factor) Interval(interval time.Duration) time.Duration {
+	return f.interval(interval, rand.Float64())
+}
+
+func (f Factor) interval(interval time.Duration, random float64) time.Duration {
+	return interval + time.Duration((2*random-1)*float64(f)*float64(interval))
+}
+
+// Offset returns the delay before the first periodic refresh, random in [0, interval) so that replicas
+// started together don't refresh in step, it is the interval when jitter is disabled
+func (f Factor) Offset(interval time.Duration) time.Duration {
+	return f.offset(interval, rand.Float64())
+}
+
+func (f Factor) offset(interval time.Duration, random float64) time.Duration {
+	if f == 0 {
+		return interval
+	}
+	return time.Duration(random * float64(interval))
+}
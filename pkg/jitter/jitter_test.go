@@ -0,0 +1,66 @@
+package jitter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFactor_Validate(t *testing.T) {
+	for _, f := range []Factor{0, 0.1, 0.99} {
+		assert.NoError(t, f.Validate(), f)
+	}
+	for _, f := range []Factor{-0.1, 1, 2} {
+		assert.Error(t, f.Validate(), f)
+	}
+}
+
+func TestFactor_Interval(t *testing.T) {
+	tests := []struct {
+		name   string
+		factor Factor
+	}{{
+		name:   "disabled",
+		factor: 0,
+	}, {
+		name:   "ten percent",
+		factor: 0.1,
+	}, {
+		name:   "half",
+		factor: 0.5,
+	}}
+	const samples = 10000
+	interval := time.Minute
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			low := time.Duration(float64(interval) * (1 - float64(tt.factor)))
+			high := time.Duration(float64(interval) * (1 + float64(tt.factor)))
+			var sum time.Duration
+			for range samples {
+				got := tt.factor.Interval(interval)
+				assert.GreaterOrEqual(t, got, low)
+				assert.LessOrEqual(t, got, high)
+				sum += got
+			}
+			// the mean is the interval, the standard deviation of the mean is below 0.3% of the interval
+			assert.InDelta(t, float64(interval), float64(sum/samples), 0.02*float64(interval))
+		})
+	}
+	// the bounds are reached with the extreme random values
+	assert.Equal(t, 45*time.Second, Factor(0.25).interval(time.Minute, 0))
+	assert.Equal(t, 75*time.Second, Factor(0.25).interval(time.Minute, 1))
+	assert.Equal(t, time.Minute, Factor(0.25).interval(time.Minute, 0.5))
+}
+
+func TestFactor_Offset(t *testing.T) {
+	// replicas don't start refreshing in step
+	for range 1000 {
+		got := Factor(0.1).Offset(time.Minute)
+		assert.GreaterOrEqual(t, got, time.Duration(0))
+		assert.Less(t, got, time.Minute)
+	}
+	assert.Equal(t, 15*time.Second, Factor(0.1).offset(time.Minute, 0.25))
+	// without jitter the first refresh happens after the interval
+	assert.Equal(t, time.Minute, Factor(0).Offset(time.Minute))
+}
@@ -0,0 +1,132 @@
+// Package manifests provides the CRDs and the RBAC resources the authz server needs, they match the version of the
+// binary. The CRDs are the ones generated from the API types, the codegen copies them into this package.
+package manifests
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultName is the name of the RBAC resources, it is the name the Helm chart gives them
+const DefaultName = "kyverno-authz-server"
+
+//go:embed crds/*.yaml
+var crds embed.FS
+
+// CRDs returns the CRDs of the API types, sorted by name
+func CRDs() ([]*apiextensionsv1.CustomResourceDefinition, error) {
+	files, err := fs.Glob(crds, "crds/*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(files)
+	out := make([]*apiextensionsv1.CustomResourceDefinition, 0, len(files))
+	for _, file := range files {
+		content, err := crds.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var crd apiextensionsv1.CustomResourceDefinition
+		if err := yaml.UnmarshalStrict(content, &crd); err != nil {
+			return nil, fmt.Errorf("failed to parse CRD %s: %w", path.Base(file), err)
+		}
+		out = append(out, &crd)
+	}
+	return out, nil
+}
+
+// RBACOptions configures the RBAC resources
+type RBACOptions struct {
+	// Name is the name of the service account, roles and bindings, DefaultName if empty
+	Name string
+	// Namespace is the namespace the server runs in, the service account, the leader election role and its binding
+	// are created in it
+	Namespace string
+	// DataSources grants the permissions required by --policy-data-sources, the server lists and watches the
+	// ConfigMaps and Secrets of the cluster
+	DataSources bool
+}
+
+// ClusterRules are the cluster wide permissions of the server, it watches the policies, writes their status and
+// records events about them
+func ClusterRules(dataSources bool) []rbacv1.PolicyRule {
+	rules := []rbacv1.PolicyRule{{
+		APIGroups: []string{v1alpha1.SchemeGroupVersion.Group},
+		Resources: []string{"authorizationpolicies"},
+		Verbs:     []string{"get", "list", "watch"},
+	}, {
+		APIGroups: []string{v1alpha1.SchemeGroupVersion.Group},
+		Resources: []string{"authorizationpolicies/status"},
+		Verbs:     []string{"get", "patch", "update"},
+	}, {
+		APIGroups: []string{corev1.GroupName},
+		Resources: []string{"events"},
+		Verbs:     []string{"create", "patch"},
+	}}
+	if dataSources {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{corev1.GroupName},
+			Resources: []string{"configmaps", "secrets"},
+			Verbs:     []string{"get", "list", "watch"},
+		})
+	}
+	return rules
+}
+
+// NamespaceRules are the permissions of the server in its namespace, it holds the leader election lease
+func NamespaceRules() []rbacv1.PolicyRule {
+	return []rbacv1.PolicyRule{{
+		APIGroups: []string{coordinationv1.GroupName},
+		Resources: []string{"leases"},
+		Verbs:     []string{"get", "create", "update"},
+	}}
+}
+
+// RBAC returns the service account of the server and the roles and bindings granting it the permissions it needs
+func RBAC(opts RBACOptions) []runtime.Object {
+	name := opts.Name
+	if name == "" {
+		name = DefaultName
+	}
+	subjects := []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: name, Namespace: opts.Namespace}}
+	return []runtime.Object{
+		&corev1.ServiceAccount{
+			TypeMeta:   metav1.TypeMeta{APIVersion: corev1.SchemeGroupVersion.String(), Kind: "ServiceAccount"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: opts.Namespace},
+		},
+		&rbacv1.ClusterRole{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Rules:      ClusterRules(opts.DataSources),
+		},
+		&rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name},
+			Subjects:   subjects,
+		},
+		&rbacv1.Role{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: opts.Namespace},
+			Rules:      NamespaceRules(),
+		},
+		&rbacv1.RoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: opts.Namespace},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: name},
+			Subjects:   subjects,
+		},
+	}
+}
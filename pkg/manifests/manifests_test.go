@@ -0,0 +1,56 @@
+package manifests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+func TestCRDs(t *testing.T) {
+	crds, err := CRDs()
+	require.NoError(t, err)
+	require.Len(t, crds, 1)
+	assert.Equal(t, "authorizationpolicies.envoy.kyverno.io", crds[0].Name)
+	// the embedded CRDs are the generated ones, the codegen copies them
+	generated, err := filepath.Glob("../../.crds/*.yaml")
+	require.NoError(t, err)
+	require.Len(t, generated, len(crds))
+	for _, file := range generated {
+		want, err := os.ReadFile(file)
+		require.NoError(t, err)
+		got, err := os.ReadFile(filepath.Join("crds", filepath.Base(file)))
+		require.NoError(t, err, "run make codegen-crds")
+		assert.Equal(t, string(want), string(got), "run make codegen-crds")
+	}
+}
+
+func TestRBAC(t *testing.T) {
+	objects := RBAC(RBACOptions{Namespace: "authz"})
+	require.Len(t, objects, 5)
+	serviceAccount := objects[0].(*corev1.ServiceAccount)
+	assert.Equal(t, DefaultName, serviceAccount.Name)
+	assert.Equal(t, "authz", serviceAccount.Namespace)
+	clusterRole := objects[1].(*rbacv1.ClusterRole)
+	assert.Equal(t, ClusterRules(false), clusterRole.Rules)
+	// the bindings grant the roles to the service account
+	subject := rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: DefaultName, Namespace: "authz"}
+	assert.Equal(t, []rbacv1.Subject{subject}, objects[2].(*rbacv1.ClusterRoleBinding).Subjects)
+	role := objects[3].(*rbacv1.Role)
+	assert.Equal(t, "authz", role.Namespace)
+	assert.Equal(t, NamespaceRules(), role.Rules)
+	assert.Equal(t, rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: DefaultName}, objects[4].(*rbacv1.RoleBinding).RoleRef)
+	// data sources need to read config maps and secrets
+	objects = RBAC(RBACOptions{Name: "authz", Namespace: "authz", DataSources: true})
+	clusterRole = objects[1].(*rbacv1.ClusterRole)
+	assert.Equal(t, "authz", clusterRole.Name)
+	assert.Contains(t, clusterRole.Rules, rbacv1.PolicyRule{
+		APIGroups: []string{""},
+		Resources: []string{"configmaps", "secrets"},
+		Verbs:     []string{"get", "list", "watch"},
+	})
+}
@@ -0,0 +1,448 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+)
+
+const (
+	QuotaGlobal    = "global"
+	QuotaNamespace = "namespace"
+)
+
+const (
+	DecisionAllow = "allow"
+	DecisionDeny  = "deny"
+	DecisionError = "error"
+	DecisionNone  = "none"
+	// DecisionSkip is the outcome of the policies taking no decision or skipped, in the per policy decisions
+	DecisionSkip = "skip"
+)
+
+// OtherPolicy is the namespace and name of the per policy decisions recorded once the policy series limit is reached
+const OtherPolicy = "other"
+
+// Metrics records policy compilation and evaluation metrics, a nil Metrics records nothing
+type Metrics struct {
+	evaluations     *prometheus.CounterVec
+	duration        *prometheus.HistogramVec
+	compileFailures *prometheus.CounterVec
+	evalTimeouts    *prometheus.CounterVec
+	bundlePulled    *prometheus.GaugeVec
+	bundleFailures  *prometheus.CounterVec
+	syncListed      prometheus.Gauge
+	syncPending     prometheus.Gauge
+	logDropped      *prometheus.CounterVec
+	logFailures     *prometheus.CounterVec
+	logRetries      *prometheus.CounterVec
+	logDeadLettered *prometheus.CounterVec
+	estimatedCost   *prometheus.GaugeVec
+	evaluationCost  *prometheus.HistogramVec
+	decisionCache   *prometheus.CounterVec
+	policySetLocked prometheus.Gauge
+	quotaRejected   *prometheus.CounterVec
+	checkQueue      prometheus.Gauge
+	checkRejected   prometheus.Counter
+	lastReconcile   *prometheus.GaugeVec
+	watchErrors     prometheus.Counter
+	budgetSkipped   *prometheus.CounterVec
+	breakGlass      *prometheus.GaugeVec
+	breakGlassUsed  *prometheus.CounterVec
+	providerLatency prometheus.Histogram
+	providerSize    prometheus.Gauge
+	providerErrors  prometheus.Counter
+	policyDecisions *prometheus.CounterVec
+	policySeries    *policySeries
+}
+
+type Option func(*Metrics)
+
+// WithPolicySeriesLimit caps the number of policies whose decisions are recorded in their own series, the decisions
+// of the policies beyond the limit are recorded in the series of the OtherPolicy. Zero means no limit.
+func WithPolicySeriesLimit(max int) Option {
+	return func(m *Metrics) {
+		m.policySeries.max = max
+	}
+}
+
+func New(registerer prometheus.Registerer, opts ...Option) (*Metrics, error) {
+	m := &Metrics{
+		evaluations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "policy_evaluations_total",
+			Help: "Number of policy evaluations, partitioned by policy, enforcement mode and decision.",
+		}, []string{"policy", "mode", "decision"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "policy_evaluation_duration_seconds",
+			Help:    "Policy evaluation latency in seconds, partitioned by policy.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 2, 15),
+		}, []string{"policy"}),
+		compileFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "policy_compile_failures_total",
+			Help: "Number of policy compilation failures, partitioned by policy.",
+		}, []string{"policy"}),
+		evalTimeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "policy_eval_timeouts_total",
+			Help: "Number of policy evaluations that timed out, partitioned by policy.",
+		}, []string{"policy"}),
+		bundlePulled: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "policy_bundle_last_success_timestamp_seconds",
+			Help: "Unix timestamp of the last successful policy bundle pull, partitioned by bundle reference.",
+		}, []string{"ref"}),
+		bundleFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "policy_bundle_pull_failures_total",
+			Help: "Number of failed policy bundle pulls, partitioned by bundle reference.",
+		}, []string{"ref"}),
+		syncListed: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "policy_initial_sync_listed",
+			Help: "Number of policies listed by the initial sync of the Kubernetes provider.",
+		}),
+		syncPending: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "policy_initial_sync_pending",
+			Help: "Number of policies listed by the initial sync of the Kubernetes provider and not reconciled yet.",
+		}),
+		logDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "decision_log_dropped_total",
+			Help: "Number of decision records dropped because the sink buffer was full, partitioned by sink.",
+		}, []string{"sink"}),
+		logFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "decision_log_write_failures_total",
+			Help: "Number of decision records a sink failed to write, partitioned by sink.",
+		}, []string{"sink"}),
+		logRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "decision_log_write_retries_total",
+			Help: "Number of decision record writes retried after a sink failure, partitioned by sink.",
+		}, []string{"sink"}),
+		logDeadLettered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "decision_log_dead_lettered_total",
+			Help: "Number of decision records written to the dead letter file after a sink exhausted its retries, partitioned by sink.",
+		}, []string{"sink"}),
+		estimatedCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "policy_estimated_cost",
+			Help: "Worst case CEL cost of evaluating every expression of a policy once, as of its last successful compilation, partitioned by policy.",
+		}, []string{"policy"}),
+		evaluationCost: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "policy_evaluation_cost",
+			Help:    "Actual CEL cost of policy evaluations, partitioned by policy.",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 12),
+		}, []string{"policy"}),
+		decisionCache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "policy_decision_cache_requests_total",
+			Help: "Number of decision cache lookups, partitioned by policy and result (hit or miss).",
+		}, []string{"policy", "result"}),
+		policySetLocked: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "policy_set_locked",
+			Help: "Whether the policy set is locked because the provider suddenly had no policies (1) or not (0).",
+		}),
+		quotaRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "policy_quota_rejections_total",
+			Help: "Number of policies rejected because they exceed a quota, partitioned by quota (global or namespace).",
+		}, []string{"quota"}),
+		checkQueue: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "check_queue_depth",
+			Help: "Number of checks waiting for a worker of the check pool.",
+		}),
+		checkRejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "check_rejections_total",
+			Help: "Number of checks rejected because the check queue was full.",
+		}),
+		lastReconcile: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "policy_last_reconcile_timestamp_seconds",
+			Help: "Unix timestamp of the last reconciliation of a policy loaded from the Kubernetes API server, partitioned by policy.",
+		}, []string{"policy"}),
+		watchErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "policy_watch_errors_total",
+			Help: "Number of errors watching policies, the Kubernetes provider lists all policies again after a watch error.",
+		}),
+		budgetSkipped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "policy_skipped_cost_budget_total",
+			Help: "Number of policy evaluations skipped because the cost budget left for the check didn't cover the policy estimated cost, partitioned by policy.",
+		}, []string{"policy"}),
+		breakGlass: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "policy_break_glass_expiry_timestamp_seconds",
+			Help: "Unix timestamp the active break glass of a policy expires at, partitioned by policy. Policies without an active break glass are not reported.",
+		}, []string{"policy"}),
+		breakGlassUsed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "policy_break_glass_requests_total",
+			Help: "Number of requests allowed by the break glass of a policy, partitioned by policy.",
+		}, []string{"policy"}),
+		providerLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "policy_provider_duration_seconds",
+			Help:    "Latency in seconds of the policy provider returning the compiled policies of a check.",
+			Buckets: prometheus.ExponentialBuckets(0.000001, 4, 12),
+		}),
+		providerSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "policy_provider_policies",
+			Help: "Number of compiled policies returned by the policy provider, as of its last successful call.",
+		}),
+		providerErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "policy_provider_errors_total",
+			Help: "Number of policy provider calls that failed to return the compiled policies of a check.",
+		}),
+		policyDecisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "policy_decisions_total",
+			Help: "Number of checks a policy took part in, partitioned by policy namespace, name and outcome.",
+		}, []string{"namespace", "name", "outcome"}),
+		policySeries: newPolicySeries(),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	for _, collector := range []prometheus.Collector{m.evaluations, m.duration, m.compileFailures, m.evalTimeouts, m.bundlePulled, m.bundleFailures, m.syncListed, m.syncPending, m.logDropped, m.logFailures, m.logRetries, m.logDeadLettered, m.estimatedCost, m.evaluationCost, m.decisionCache, m.policySetLocked, m.quotaRejected, m.checkQueue, m.checkRejected, m.lastReconcile, m.watchErrors, m.budgetSkipped, m.breakGlass, m.breakGlassUsed, m.providerLatency, m.providerSize, m.providerErrors, m.policyDecisions} {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// RecordEvaluation records a policy evaluation, the latency links to the trace of the context when it is sampled
+func (m *Metrics) RecordEvaluation(ctx context.Context, policy, mode, decision string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.evaluations.WithLabelValues(policy, mode, decision).Inc()
+	observer := m.duration.WithLabelValues(policy)
+	if exemplar := traceExemplar(ctx); exemplar != nil {
+		observer.(prometheus.ExemplarObserver).ObserveWithExemplar(duration.Seconds(), exemplar)
+		return
+	}
+	observer.Observe(duration.Seconds())
+}
+
+// traceExemplar returns the exemplar labels identifying the trace of the context, nil unless the span is recorded
+// and sampled. Without tracing, spans are not recording even when envoy propagated a trace context.
+func traceExemplar(ctx context.Context) prometheus.Labels {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() || !span.SpanContext().IsSampled() {
+		return nil
+	}
+	return prometheus.Labels{"trace_id": span.SpanContext().TraceID().String()}
+}
+
+func (m *Metrics) RecordCompileFailure(policy string) {
+	if m == nil {
+		return
+	}
+	m.compileFailures.WithLabelValues(policy).Inc()
+}
+
+func (m *Metrics) RecordEvaluationTimeout(policy string) {
+	if m == nil {
+		return
+	}
+	m.evalTimeouts.WithLabelValues(policy).Inc()
+}
+
+func (m *Metrics) RecordBundlePull(ref string, at time.Time) {
+	if m == nil {
+		return
+	}
+	m.bundlePulled.WithLabelValues(ref).Set(float64(at.Unix()))
+}
+
+func (m *Metrics) RecordBundlePullFailure(ref string) {
+	if m == nil {
+		return
+	}
+	m.bundleFailures.WithLabelValues(ref).Inc()
+}
+
+func (m *Metrics) RecordInitialSync(listed, pending int) {
+	if m == nil {
+		return
+	}
+	m.syncListed.Set(float64(listed))
+	m.syncPending.Set(float64(pending))
+}
+
+func (m *Metrics) RecordDecisionLogDrop(sink string) {
+	if m == nil {
+		return
+	}
+	m.logDropped.WithLabelValues(sink).Inc()
+}
+
+func (m *Metrics) RecordDecisionLogFailure(sink string) {
+	if m == nil {
+		return
+	}
+	m.logFailures.WithLabelValues(sink).Inc()
+}
+
+func (m *Metrics) RecordDecisionLogRetries(sink string, retries int) {
+	if m == nil {
+		return
+	}
+	m.logRetries.WithLabelValues(sink).Add(float64(retries))
+}
+
+func (m *Metrics) RecordDecisionLogDeadLetter(sink string) {
+	if m == nil {
+		return
+	}
+	m.logDeadLettered.WithLabelValues(sink).Inc()
+}
+
+func (m *Metrics) RecordEstimatedCost(policy string, cost uint64) {
+	if m == nil {
+		return
+	}
+	m.estimatedCost.WithLabelValues(policy).Set(float64(cost))
+}
+
+func (m *Metrics) RecordEvaluationCost(policy string, cost uint64) {
+	if m == nil {
+		return
+	}
+	m.evaluationCost.WithLabelValues(policy).Observe(float64(cost))
+}
+
+func (m *Metrics) RecordDecisionCache(policy string, hit bool) {
+	if m == nil {
+		return
+	}
+	result := CacheMiss
+	if hit {
+		result = CacheHit
+	}
+	m.decisionCache.WithLabelValues(policy, result).Inc()
+}
+
+func (m *Metrics) RecordPolicySetLocked(locked bool) {
+	if m == nil {
+		return
+	}
+	value := 0.0
+	if locked {
+		value = 1
+	}
+	m.policySetLocked.Set(value)
+}
+
+func (m *Metrics) RecordQuotaRejection(quota string) {
+	if m == nil {
+		return
+	}
+	m.quotaRejected.WithLabelValues(quota).Inc()
+}
+
+func (m *Metrics) RecordCheckQueued(queued bool) {
+	if m == nil {
+		return
+	}
+	if queued {
+		m.checkQueue.Inc()
+	} else {
+		m.checkQueue.Dec()
+	}
+}
+
+func (m *Metrics) RecordCheckRejection() {
+	if m == nil {
+		return
+	}
+	m.checkRejected.Inc()
+}
+
+func (m *Metrics) RecordReconcile(policy string, at time.Time) {
+	if m == nil {
+		return
+	}
+	m.lastReconcile.WithLabelValues(policy).Set(float64(at.Unix()))
+}
+
+// ForgetReconcile removes the reconciliation timestamp of a policy that is not loaded anymore
+func (m *Metrics) ForgetReconcile(policy string) {
+	if m == nil {
+		return
+	}
+	m.lastReconcile.DeleteLabelValues(policy)
+}
+
+func (m *Metrics) RecordWatchError() {
+	if m == nil {
+		return
+	}
+	m.watchErrors.Inc()
+}
+
+func (m *Metrics) RecordCostBudgetSkip(policy string) {
+	if m == nil {
+		return
+	}
+	m.budgetSkipped.WithLabelValues(policy).Inc()
+}
+
+func (m *Metrics) RecordBreakGlass(policy string, expires time.Time) {
+	if m == nil {
+		return
+	}
+	m.breakGlass.WithLabelValues(policy).Set(float64(expires.Unix()))
+}
+
+// ForgetBreakGlass removes the expiry of a break glass that expired or was deactivated
+func (m *Metrics) ForgetBreakGlass(policy string) {
+	if m == nil {
+		return
+	}
+	m.breakGlass.DeleteLabelValues(policy)
+}
+
+func (m *Metrics) RecordBreakGlassRequest(policy string) {
+	if m == nil {
+		return
+	}
+	m.breakGlassUsed.WithLabelValues(policy).Inc()
+}
+
+// RecordProviderCall records a call returning the compiled policies of a check, the latency links to the trace of
+// the context when it is sampled
+func (m *Metrics) RecordProviderCall(ctx context.Context, policies int, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	if exemplar := traceExemplar(ctx); exemplar != nil {
+		m.providerLatency.(prometheus.ExemplarObserver).ObserveWithExemplar(duration.Seconds(), exemplar)
+	} else {
+		m.providerLatency.Observe(duration.Seconds())
+	}
+	if err != nil {
+		m.providerErrors.Inc()
+		return
+	}
+	m.providerSize.Set(float64(policies))
+}
+
+// RecordPolicyDecision records the outcome of a policy for a check, the policies taking no decision are recorded with
+// the skip outcome. The decisions of the policies beyond the policy series limit are recorded in the other series.
+func (m *Metrics) RecordPolicyDecision(namespace, name, outcome string) {
+	if m == nil {
+		return
+	}
+	if outcome == DecisionNone {
+		outcome = DecisionSkip
+	}
+	if !m.policySeries.track(namespace, name) {
+		namespace, name = OtherPolicy, OtherPolicy
+	}
+	m.policyDecisions.WithLabelValues(namespace, name, outcome).Inc()
+}
+
+// ForgetPolicyDecisions removes the decisions of a policy that is not loaded anymore, it frees its series for
+// another policy
+func (m *Metrics) ForgetPolicyDecisions(namespace, name string) {
+	if m == nil {
+		return
+	}
+	if m.policySeries.forget(namespace, name) {
+		m.policyDecisions.DeletePartialMatch(prometheus.Labels{"namespace": namespace, "name": name})
+	}
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"sync"
+)
+
+// policySeries tracks the policies recorded in their own series, the first policies recorded get a series until the
+// limit is reached and keep it until they are forgotten
+type policySeries struct {
+	lock sync.Mutex
+	// max is the maximum number of tracked policies, zero means no limit
+	max      int
+	policies map[policyKey]struct{}
+}
+
+type policyKey struct {
+	namespace string
+	name      string
+}
+
+func newPolicySeries() *policySeries {
+	return &policySeries{policies: map[policyKey]struct{}{}}
+}
+
+// track returns true if the policy is recorded in its own series, it starts tracking the policy if the limit allows
+func (s *policySeries) track(namespace, name string) bool {
+	key := policyKey{namespace: namespace, name: name}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.policies[key]; ok {
+		return true
+	}
+	if s.max > 0 && len(s.policies) >= s.max {
+		return false
+	}
+	s.policies[key] = struct{}{}
+	return true
+}
+
+// forget stops tracking the policy, it returns true if the policy had its own series
+func (s *policySeries) forget(namespace, name string) bool {
+	key := policyKey{namespace: namespace, name: name}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.policies[key]; !ok {
+		return false
+	}
+	delete(s.policies, key)
+	return true
+}
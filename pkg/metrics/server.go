@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/server"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func NewServer(addr string, gatherer prometheus.Gatherer) server.ServerFunc {
+	return func(ctx context.Context) error {
+		// create mux
+		mux := http.NewServeMux()
+		// register metrics handler, exemplars are only exposed to scrapers negotiating the openmetrics format
+		mux.Handle("GET /metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+		// create server
+		s := &http.Server{
+			Addr:    addr,
+			Handler: mux,
+		}
+		// run server
+		return server.RunHttp(ctx, s, "", "")
+	}
+}
@@ -0,0 +1,221 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/go-logr/logr"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// BreakGlassAnnotation activates the break glass of a policy, its value is the RFC 3339 time the break glass
+	// was activated at. While it is active, the policy allows the requests it matches whatever its rules.
+	BreakGlassAnnotation = "envoy.kyverno.io/break-glass"
+	// DefaultBreakGlassTTL is the time a break glass stays active
+	DefaultBreakGlassTTL = time.Hour
+	// maxBreakGlassTTL caps the time a break glass stays active
+	maxBreakGlassTTL = 24 * time.Hour
+)
+
+const (
+	eventReasonBreakGlassActivated = "BreakGlassActivated"
+	eventReasonBreakGlassExpired   = "BreakGlassExpired"
+	eventReasonBreakGlassInvalid   = "BreakGlassInvalid"
+)
+
+// WithBreakGlass lets the BreakGlassAnnotation activate the break glass of policies for the given time, a policy
+// with an active break glass is evaluated before the other policies and allows the requests matched by its scope
+// and conditions. Zero disables break glass, the annotation is ignored.
+func WithBreakGlass(ttl time.Duration) KubeProviderOption {
+	return func(o *kubeProviderOptions) {
+		o.breakGlassTTL = ttl
+	}
+}
+
+// breakGlass is the activation window of the break glass of a policy
+type breakGlass struct {
+	activated time.Time
+	expires   time.Time
+}
+
+// parseBreakGlass returns the break glass window of a policy, nil when the policy is not annotated
+func parseBreakGlass(annotations map[string]string, ttl time.Duration) (*breakGlass, error) {
+	value, ok := annotations[BreakGlassAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	activated, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation, it must be the RFC 3339 time the break glass was activated at: %w", BreakGlassAnnotation, err)
+	}
+	return &breakGlass{activated: activated, expires: activated.Add(ttl)}, nil
+}
+
+// active returns true when the break glass is active at the given time, a nil break glass is never active
+func (b *breakGlass) active(now time.Time) bool {
+	return b != nil && !now.Before(b.activated) && now.Before(b.expires)
+}
+
+// next returns the delay until the break glass is activated or expires, zero once it expired
+func (b *breakGlass) next(now time.Time) time.Duration {
+	switch {
+	case b == nil || !now.Before(b.expires):
+		return 0
+	case now.Before(b.activated):
+		return b.activated.Sub(now)
+	default:
+		return b.expires.Sub(now)
+	}
+}
+
+// breakGlassPolicy returns the policy evaluating its break glass until it expires, it overrides the decisions of the
+// other policies and is enforced whatever the enforcement mode of the policy. Every allowed request is logged and
+// counted. Decisions are not cached, cached decisions of the policy are not returned either.
+// Once expired, the rules of the policy are evaluated again even if the policy was not reconciled since.
+func breakGlassPolicy(compiled CompiledPolicy, expires time.Time, now func() time.Time, logger logr.Logger, metrics *metrics.Metrics) CompiledPolicy {
+	evaluate, rules, mode := compiled.BreakGlass, compiled.Evaluate, compiled.Mode
+	compiled.Evaluate = func(ctx context.Context, r *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+		if !now().Before(expires) {
+			// audit policies never affect the response
+			if mode == hub.EnforcementModeAudit {
+				return nil, nil
+			}
+			return rules(ctx, r)
+		}
+		response, err := evaluate(ctx, r)
+		if response != nil {
+			logger.Info("BREAK GLASS: request allowed", "request", r.GetAttributes().GetRequest().GetHttp().GetId())
+			metrics.RecordBreakGlassRequest(compiled.Name)
+		}
+		return response, err
+	}
+	compiled.Mode = hub.EnforcementModeEnforce
+	compiled.Override = true
+	compiled.Cache = nil
+	return compiled
+}
+
+// breakGlassState is the break glass of an annotated policy
+type breakGlassState struct {
+	// annotation is the value of the annotation the state was computed from
+	annotation string
+	// expires is the time the break glass expires at, zero when it is not active
+	expires time.Time
+}
+
+func (s breakGlassState) active() bool {
+	return !s.expires.IsZero()
+}
+
+// reconcileBreakGlass activates or expires the break glass of a policy, the transitions are logged and recorded as
+// events. The result requeues the policy when its break glass is activated or expires.
+func (r *policyReconciler) reconcileBreakGlass(logger logr.Logger, policy *v1alpha1.AuthorizationPolicy) ctrl.Result {
+	key := client.ObjectKeyFromObject(policy)
+	value, annotated := policy.Annotations[BreakGlassAnnotation]
+	if r.breakGlassTTL == 0 || !annotated {
+		if previous, ok := r.setBreakGlass(key, policy.Name, nil); ok && previous.active() {
+			logger.Info("BREAK GLASS DEACTIVATED")
+			r.breakGlassEvent(policy, corev1.EventTypeNormal, eventReasonBreakGlassExpired, "Break glass deactivated")
+		}
+		return ctrl.Result{}
+	}
+	now := r.now()
+	glass, err := parseBreakGlass(policy.Annotations, r.breakGlassTTL)
+	if err == nil && !r.supportsBreakGlass(key) {
+		glass, err = nil, fmt.Errorf("the policy compiler doesn't support break glass")
+	}
+	state := breakGlassState{annotation: value}
+	if glass.active(now) {
+		state.expires = glass.expires
+	}
+	previous, ok := r.setBreakGlass(key, policy.Name, &state)
+	switch {
+	// report an invalid annotation once
+	case err != nil && (!ok || previous.annotation != value):
+		logger.Error(err, "break glass ignored")
+		r.breakGlassEvent(policy, corev1.EventTypeWarning, eventReasonBreakGlassInvalid, err.Error())
+	case state.active() && !previous.active():
+		logger.Info("BREAK GLASS ACTIVATED, the policy allows the requests it matches", "activated", glass.activated, "expires", glass.expires)
+		r.breakGlassEvent(policy, corev1.EventTypeWarning, eventReasonBreakGlassActivated, fmt.Sprintf("Break glass activated, the policy allows the requests it matches until %s", glass.expires.Format(time.RFC3339)))
+	case !state.active() && previous.active():
+		logger.Info("BREAK GLASS EXPIRED", "expired", previous.expires)
+		r.breakGlassEvent(policy, corev1.EventTypeNormal, eventReasonBreakGlassExpired, "Break glass expired")
+	}
+	return ctrl.Result{RequeueAfter: glass.next(now)}
+}
+
+// requeueFirst returns the result requeuing after the shortest of the delays, zero delays don't requeue
+func requeueFirst(a, b ctrl.Result) ctrl.Result {
+	if a.RequeueAfter == 0 || (b.RequeueAfter != 0 && b.RequeueAfter < a.RequeueAfter) {
+		return b
+	}
+	return a
+}
+
+// breakGlassEvent records a break glass transition, events are recorded by the leader only
+func (r *policyReconciler) breakGlassEvent(policy *v1alpha1.AuthorizationPolicy, eventType, reason, message string) {
+	if r.leader.Load() {
+		r.recorder.Event(policy, eventType, reason, message)
+	}
+}
+
+// supportsBreakGlass returns false when the evaluated policy has no break glass, policies that are not evaluated
+// get one once they compile
+func (r *policyReconciler) supportsBreakGlass(key types.NamespacedName) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	compiled, ok := r.policies[key]
+	return !ok || compiled.BreakGlass != nil
+}
+
+// setBreakGlass records the break glass of a policy, nil when the policy is not annotated. It returns the previous
+// state and false if the policy was not annotated.
+func (r *policyReconciler) setBreakGlass(key types.NamespacedName, name string, state *breakGlassState) (breakGlassState, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	previous, ok := r.breakGlass[key]
+	if state == nil {
+		delete(r.breakGlass, key)
+	} else {
+		r.breakGlass[key] = *state
+	}
+	if state != nil && state.active() {
+		r.metrics.RecordBreakGlass(name, state.expires)
+	} else {
+		r.metrics.ForgetBreakGlass(name)
+	}
+	// the evaluation order changes when a break glass is activated or expires
+	if previous.active() != (state != nil && state.active()) {
+		r.resetSortPolicies()
+	}
+	return previous, ok
+}
+
+// sortPoliciesWithBreakGlass orders the policies with an active break glass first, they override the decisions
+// of the other policies. Disabled policies are left out. It must be called with the lock held.
+func (r *policyReconciler) sortPoliciesWithBreakGlass() []CompiledPolicy {
+	glass := map[types.NamespacedName]CompiledPolicy{}
+	others := map[types.NamespacedName]CompiledPolicy{}
+	for key := range r.policies {
+		// a pinned policy is evaluated with the pinned generation, its break glass included
+		compiled, _ := r.served(key)
+		switch {
+		// a disabled policy is not evaluated, its break glass included
+		case compiled.Disabled:
+		case r.breakGlass[key].active() && compiled.BreakGlass != nil:
+			glass[key] = breakGlassPolicy(compiled, r.breakGlass[key].expires, r.now, r.logger.WithValues("policy", key.String()), r.metrics)
+		default:
+			others[key] = compiled
+		}
+	}
+	return append(mapToSortedSlice(glass, r.compare), mapToSortedSlice(others, r.compare)...)
+}
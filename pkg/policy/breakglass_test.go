@@ -0,0 +1,272 @@
+package policy
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/go-logr/logr"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func newPathRequest(path string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{Path: path},
+			},
+		},
+	}
+}
+
+func Test_parseBreakGlass(t *testing.T) {
+	activated := time.Date(2024, 6, 3, 8, 0, 0, 0, time.UTC)
+	glass, err := parseBreakGlass(map[string]string{BreakGlassAnnotation: "2024-06-03T08:00:00Z"}, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, &breakGlass{activated: activated, expires: activated.Add(time.Hour)}, glass)
+	tests := []struct {
+		name       string
+		now        time.Time
+		wantActive bool
+		wantNext   time.Duration
+	}{{
+		name:     "before the activation",
+		now:      activated.Add(-time.Minute),
+		wantNext: time.Minute,
+	}, {
+		name:       "activated",
+		now:        activated,
+		wantActive: true,
+		wantNext:   time.Hour,
+	}, {
+		name:       "before the expiry",
+		now:        activated.Add(50 * time.Minute),
+		wantActive: true,
+		wantNext:   10 * time.Minute,
+	}, {
+		name: "expired",
+		now:  activated.Add(time.Hour),
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantActive, glass.active(tt.now))
+			assert.Equal(t, tt.wantNext, glass.next(tt.now))
+		})
+	}
+	// policies without the annotation have no break glass
+	glass, err = parseBreakGlass(map[string]string{"owner": "team-a"}, time.Hour)
+	assert.NoError(t, err)
+	assert.False(t, glass.active(activated))
+	assert.Zero(t, glass.next(activated))
+	_, err = parseBreakGlass(map[string]string{BreakGlassAnnotation: "now"}, time.Hour)
+	assert.ErrorContains(t, err, "invalid envoy.kyverno.io/break-glass annotation")
+}
+
+func Test_policyReconciler_Reconcile_breakGlass(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	deny := newPolicy("deny", "envoy.Denied(403).Response()")
+	deny.Spec.Priority = 10
+	api := newPolicy("api", "envoy.Denied(401).Response()")
+	api.Spec.MatchConditions = []admissionregistrationv1.MatchCondition{{Name: "api", Expression: `object.attributes.request.http.path.startsWith("/api")`}}
+	api.Annotations = map[string]string{BreakGlassAnnotation: now.Add(-10 * time.Minute).Format(time.RFC3339)}
+	c := newFakeClient(t, deny, api)
+	registry := prometheus.NewRegistry()
+	m, err := metrics.New(registry)
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(10)
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), recorder)
+	r.metrics = m
+	r.breakGlassTTL = time.Hour
+	r.now = func() time.Time { return now }
+	reconcile(t, r, "deny")
+	// the policy is reconciled again when the break glass expires
+	request := ctrl.Request{NamespacedName: types.NamespacedName{Name: "api"}}
+	result, err := r.Reconcile(ctx, request)
+	require.NoError(t, err)
+	assert.Equal(t, ctrl.Result{RequeueAfter: 50 * time.Minute}, result)
+	events := drainEvents(recorder)
+	if assert.Len(t, events, 1) {
+		assert.Contains(t, events[0], "Warning BreakGlassActivated")
+	}
+	// the break glass overrides the deny of the higher priority policy for the requests it matches
+	policies, err := r.CompiledPolicies(ctx)
+	require.NoError(t, err)
+	if assert.Len(t, policies, 2) {
+		assert.Equal(t, "api", policies[0].Name)
+		assert.True(t, policies[0].Override)
+		assert.Nil(t, policies[0].Cache)
+	}
+	response := core.Evaluate(ctx, policies, newPathRequest("/api/users"))
+	assert.Equal(t, int32(0), response.GetStatus().GetCode())
+	assert.Equal(t, core.BreakGlassReason, response.GetDynamicMetadata().GetFields()[core.MetadataKey].GetStructValue().GetFields()[core.MetadataReasonKey].GetStringValue())
+	response = core.Evaluate(ctx, policies, newPathRequest("/admin"))
+	assert.Equal(t, int32(7), response.GetStatus().GetCode())
+	expected := `
+# HELP policy_break_glass_requests_total Number of requests allowed by the break glass of a policy, partitioned by policy.
+# TYPE policy_break_glass_requests_total counter
+policy_break_glass_requests_total{policy="api"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "policy_break_glass_requests_total"))
+	assert.Equal(t, 1, gatherCount(t, registry, "policy_break_glass_expiry_timestamp_seconds"))
+	statuses := r.Inspect()
+	if assert.Len(t, statuses, 2) {
+		assert.Equal(t, "api", statuses[0].Name)
+		if assert.NotNil(t, statuses[0].BreakGlassExpires) {
+			assert.WithinDuration(t, now.Add(50*time.Minute), *statuses[0].BreakGlassExpires, 0)
+		}
+	}
+	// reconciling again doesn't record the activation twice
+	_, err = r.Reconcile(ctx, request)
+	require.NoError(t, err)
+	assert.Empty(t, drainEvents(recorder))
+	// the break glass expires, the rules of the policy are evaluated again
+	now = now.Add(50 * time.Minute)
+	result, err = r.Reconcile(ctx, request)
+	require.NoError(t, err)
+	assert.Equal(t, ctrl.Result{}, result)
+	assert.Equal(t, []string{"Normal BreakGlassExpired Break glass expired"}, drainEvents(recorder))
+	policies, err = r.CompiledPolicies(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "deny", policies[0].Name)
+	assert.False(t, policies[1].Override)
+	response = core.Evaluate(ctx, policies, newPathRequest("/api/users"))
+	assert.Equal(t, int32(7), response.GetStatus().GetCode())
+	assert.Equal(t, 0, gatherCount(t, registry, "policy_break_glass_expiry_timestamp_seconds"))
+	assert.Nil(t, r.Inspect()[1].BreakGlassExpires)
+}
+
+func Test_policyReconciler_Reconcile_breakGlassMalformed(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	policy := newPolicy("api", "envoy.Denied(401).Response()")
+	policy.Annotations = map[string]string{BreakGlassAnnotation: now.Add(-10 * time.Minute).Format(time.RFC3339)}
+	c := newFakeClient(t, policy)
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	r.breakGlassTTL = time.Hour
+	r.now = func() time.Time { return now }
+	reconcile(t, r, "api")
+	// the previous spec is still evaluated when the policy is malformed, so is its break glass
+	var updated v1alpha1.AuthorizationPolicy
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "api"}, &updated))
+	updated.Spec.Authorizations[0].Expression = ""
+	updated.Generation = 2
+	require.NoError(t, c.Update(ctx, &updated))
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "api"}})
+	require.NoError(t, err)
+	assert.Equal(t, ctrl.Result{RequeueAfter: 50 * time.Minute}, result)
+	policies, err := r.CompiledPolicies(ctx)
+	require.NoError(t, err)
+	response := core.Evaluate(ctx, policies, newPathRequest("/"))
+	assert.Equal(t, int32(0), response.GetStatus().GetCode())
+	// the break glass expires even if the policy is not reconciled again, the rules of the policy are evaluated
+	now = now.Add(50 * time.Minute)
+	response = core.Evaluate(ctx, policies, newPathRequest("/"))
+	assert.Equal(t, int32(7), response.GetStatus().GetCode())
+	assert.Equal(t, typev3.StatusCode_Unauthorized, response.GetDeniedResponse().GetStatus().GetCode())
+}
+
+func Test_policyReconciler_Reconcile_breakGlassAudit(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	policy := newPolicy("audit", "envoy.Denied(403).Response()")
+	policy.Spec.EnforcementMode = v1alpha1.EnforcementModeAudit
+	policy.Annotations = map[string]string{BreakGlassAnnotation: now.Format(time.RFC3339)}
+	c := newFakeClient(t, policy, newPolicy("deny", "envoy.Denied(403).Response()"))
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	r.breakGlassTTL = time.Hour
+	r.now = func() time.Time { return now }
+	reconcile(t, r, "audit")
+	reconcile(t, r, "deny")
+	// the break glass is enforced whatever the enforcement mode of the policy
+	policies, err := r.CompiledPolicies(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, hub.EnforcementModeEnforce, policies[0].Mode)
+	response := core.Evaluate(ctx, policies, newPathRequest("/"))
+	assert.Equal(t, int32(0), response.GetStatus().GetCode())
+}
+
+func Test_policyReconciler_Reconcile_breakGlassIgnored(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation string
+		ttl        time.Duration
+		compiler   Compiler
+		expression string
+		wantEvent  string
+	}{{
+		name:       "invalid annotation",
+		annotation: "yes",
+		ttl:        time.Hour,
+		compiler:   NewCompiler(),
+		wantEvent:  "Warning BreakGlassInvalid invalid envoy.kyverno.io/break-glass annotation",
+	}, {
+		name:       "compiler without break glass",
+		annotation: time.Now().Format(time.RFC3339),
+		ttl:        time.Hour,
+		compiler:   decisionCompiler{},
+		expression: "deny",
+		wantEvent:  "Warning BreakGlassInvalid the policy compiler doesn't support break glass",
+	}, {
+		name:       "disabled",
+		annotation: time.Now().Format(time.RFC3339),
+		compiler:   NewCompiler(),
+	}, {
+		name:       "expired",
+		annotation: time.Now().Add(-2 * time.Hour).Format(time.RFC3339),
+		ttl:        time.Hour,
+		compiler:   NewCompiler(),
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			expression := "envoy.Denied(403).Response()"
+			if tt.expression != "" {
+				expression = tt.expression
+			}
+			policy := newPolicy("policy", expression)
+			policy.Annotations = map[string]string{BreakGlassAnnotation: tt.annotation}
+			c := newFakeClient(t, policy)
+			recorder := record.NewFakeRecorder(10)
+			r := newPolicyReconciler(c, tt.compiler, labels.Everything(), logr.Discard(), recorder)
+			r.breakGlassTTL = tt.ttl
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "policy"}})
+			require.NoError(t, err)
+			assert.Equal(t, ctrl.Result{}, result)
+			events := drainEvents(recorder)
+			if tt.wantEvent == "" {
+				assert.Empty(t, events)
+			} else if assert.Len(t, events, 1) {
+				assert.Contains(t, events[0], tt.wantEvent)
+			}
+			// the invalid annotation is reported once
+			reconcile(t, r, "policy")
+			assert.Empty(t, drainEvents(recorder))
+			policies, err := r.CompiledPolicies(ctx)
+			require.NoError(t, err)
+			assert.False(t, policies[0].Override)
+		})
+	}
+}
+
+func gatherCount(t *testing.T, registry *prometheus.Registry, name string) int {
+	t.Helper()
+	count, err := testutil.GatherAndCount(registry, name)
+	require.NoError(t, err)
+	return count
+}
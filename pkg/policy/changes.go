@@ -0,0 +1,29 @@
+package policy
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// eventReasonChanged is recorded when a policy is compiled again from a different spec
+const eventReasonChanged = "Changed"
+
+// changedFields returns the paths of the spec fields that differ between two specs, in declaration order,
+// the annotations are compared too since they are part of the compiled policy. Nil and empty values are equal.
+func changedFields(previous, current *hub.AuthorizationPolicySpec, previousAnnotations, currentAnnotations map[string]string) []string {
+	var fields []string
+	before, after := reflect.ValueOf(*previous), reflect.ValueOf(*current)
+	for i := range before.NumField() {
+		if !equality.Semantic.DeepEqual(before.Field(i).Interface(), after.Field(i).Interface()) {
+			name, _, _ := strings.Cut(before.Type().Field(i).Tag.Get("json"), ",")
+			fields = append(fields, "spec."+name)
+		}
+	}
+	if !equality.Semantic.DeepEqual(previousAnnotations, currentAnnotations) {
+		fields = append(fields, "metadata.annotations")
+	}
+	return fields
+}
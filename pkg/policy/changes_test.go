@@ -0,0 +1,67 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/stretchr/testify/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/utils/ptr"
+)
+
+func Test_changedFields(t *testing.T) {
+	spec := hub.AuthorizationPolicySpec{
+		FailurePolicy:  ptr.To(admissionregistrationv1.Fail),
+		Authorizations: []hub.Authorization{{Expression: "envoy.Allowed().Response()"}},
+	}
+	tests := []struct {
+		name                string
+		previous            hub.AuthorizationPolicySpec
+		current             func(hub.AuthorizationPolicySpec) hub.AuthorizationPolicySpec
+		previousAnnotations map[string]string
+		currentAnnotations  map[string]string
+		want                []string
+	}{{
+		name:     "same spec",
+		previous: spec,
+		current:  func(spec hub.AuthorizationPolicySpec) hub.AuthorizationPolicySpec { return spec },
+	}, {
+		name:     "nil and empty are equal",
+		previous: spec,
+		current: func(spec hub.AuthorizationPolicySpec) hub.AuthorizationPolicySpec {
+			spec.Variables = []admissionregistrationv1.Variable{}
+			return spec
+		},
+		previousAnnotations: map[string]string{},
+	}, {
+		name:     "authorization",
+		previous: spec,
+		current: func(spec hub.AuthorizationPolicySpec) hub.AuthorizationPolicySpec {
+			spec.Authorizations = []hub.Authorization{{Expression: "envoy.Denied(403).Response()"}}
+			return spec
+		},
+		want: []string{"spec.authorizations"},
+	}, {
+		name:     "several fields",
+		previous: spec,
+		current: func(spec hub.AuthorizationPolicySpec) hub.AuthorizationPolicySpec {
+			spec.FailurePolicy = ptr.To(admissionregistrationv1.Ignore)
+			spec.MatchConditions = []admissionregistrationv1.MatchCondition{{Name: "get", Expression: "true"}}
+			return spec
+		},
+		want: []string{"spec.failurePolicy", "spec.matchConditions"},
+	}, {
+		name:                "annotations",
+		previous:            spec,
+		current:             func(spec hub.AuthorizationPolicySpec) hub.AuthorizationPolicySpec { return spec },
+		previousAnnotations: map[string]string{"owner.example.com/team": "payments"},
+		currentAnnotations:  map[string]string{"owner.example.com/team": "checkout"},
+		want:                []string{"metadata.annotations"},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := tt.current(*tt.previous.DeepCopy())
+			assert.Equal(t, tt.want, changedFields(&tt.previous, &current, tt.previousAnnotations, tt.currentAnnotations))
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package policy
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+// defaultCoalesceDelay is the delay updates to the same policy are coalesced over
+const defaultCoalesceDelay = 100 * time.Millisecond
+
+// coalescingHandler enqueues policy updates after a delay, the updates received for the same policy in the
+// meantime are coalesced in a single reconcile. The delay doesn't restart on every update so that a policy
+// updated continuously is still reconciled every delay. Creations, deletions and generic events are enqueued
+// immediately, they don't come in bursts. A zero delay enqueues updates immediately.
+func coalescingHandler(delay time.Duration) handler.EventHandler {
+	request := func(obj client.Object) ctrl.Request {
+		return ctrl.Request{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}}
+	}
+	return handler.Funcs{
+		CreateFunc: func(_ context.Context, e event.CreateEvent, q workqueue.TypedRateLimitingInterface[ctrl.Request]) {
+			q.Add(request(e.Object))
+		},
+		UpdateFunc: func(_ context.Context, e event.UpdateEvent, q workqueue.TypedRateLimitingInterface[ctrl.Request]) {
+			// the queue keeps the earliest time of a request already waiting, later updates don't delay it more
+			q.AddAfter(request(e.ObjectNew), delay)
+		},
+		DeleteFunc: func(_ context.Context, e event.DeleteEvent, q workqueue.TypedRateLimitingInterface[ctrl.Request]) {
+			q.Add(request(e.Object))
+		},
+		GenericFunc: func(_ context.Context, e event.GenericEvent, q workqueue.TypedRateLimitingInterface[ctrl.Request]) {
+			q.Add(request(e.Object))
+		},
+	}
+}
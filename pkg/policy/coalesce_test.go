@@ -0,0 +1,144 @@
+package policy
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+func newTestQueue(t *testing.T) workqueue.TypedRateLimitingInterface[ctrl.Request] {
+	t.Helper()
+	q := workqueue.NewTypedRateLimitingQueue(newRateLimiter(defaultRetryBaseDelay, defaultRetryMaxDelay))
+	t.Cleanup(q.ShutDown)
+	return q
+}
+
+// work processes the enqueued requests until the queue is shut down
+func work(q workqueue.TypedRateLimitingInterface[ctrl.Request], process func(ctrl.Request)) {
+	for {
+		item, shutdown := q.Get()
+		if shutdown {
+			return
+		}
+		process(item)
+		q.Done(item)
+	}
+}
+
+func Test_coalescingHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		delay        time.Duration
+		wantCompiled func(int) bool
+	}{{
+		name:  "coalesced",
+		delay: time.Second,
+		// the burst results in a single compilation of the last spec
+		wantCompiled: func(count int) bool { return count == 1 },
+	}, {
+		name:  "not coalesced",
+		delay: 0,
+		// the worker keeps up with the burst, specs are compiled as they come
+		wantCompiled: func(count int) bool { return count > 1 },
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := newFakeClient(t, newPolicy("policy", `envoy.Allowed().Response()`))
+			compiler := &countingCompiler{Compiler: NewCompiler()}
+			r := newPolicyReconciler(c, compiler, labels.Everything(), logr.Discard(), record.NewFakeRecorder(100))
+			q := newTestQueue(t)
+			h := coalescingHandler(tt.delay)
+			var lock sync.Mutex
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				work(q, func(request ctrl.Request) {
+					lock.Lock()
+					defer lock.Unlock()
+					_, err := r.Reconcile(ctx, request)
+					assert.NoError(t, err)
+				})
+			}()
+			// a burst of spec updates, the worker reconciles concurrently
+			for generation := int64(2); generation <= 20; generation++ {
+				lock.Lock()
+				var old v1alpha1.AuthorizationPolicy
+				assert.NoError(t, c.Get(ctx, types.NamespacedName{Name: "policy"}, &old))
+				updated := old.DeepCopy()
+				updated.Generation = generation
+				assert.NoError(t, c.Update(ctx, updated))
+				lock.Unlock()
+				h.Update(ctx, event.UpdateEvent{ObjectOld: &old, ObjectNew: updated}, q)
+				time.Sleep(time.Millisecond)
+			}
+			// the last spec is compiled eventually
+			assert.Eventually(t, func() bool {
+				lock.Lock()
+				defer lock.Unlock()
+				statuses := r.Inspect()
+				return len(statuses) == 1 && statuses[0].Generation == 20
+			}, 5*time.Second, 10*time.Millisecond)
+			q.ShutDown()
+			<-done
+			assert.True(t, tt.wantCompiled(compiler.count), "compiled %d times", compiler.count)
+		})
+	}
+}
+
+func Test_coalescingHandler_immediate(t *testing.T) {
+	policy := newPolicy("policy", `envoy.Allowed().Response()`)
+	tests := []struct {
+		name    string
+		delay   time.Duration
+		send    func(h handler.EventHandler, q workqueue.TypedRateLimitingInterface[ctrl.Request])
+		wantLen int
+	}{{
+		name:  "create",
+		delay: time.Hour,
+		send: func(h handler.EventHandler, q workqueue.TypedRateLimitingInterface[ctrl.Request]) {
+			h.Create(context.Background(), event.CreateEvent{Object: policy}, q)
+		},
+		wantLen: 1,
+	}, {
+		name:  "delete",
+		delay: time.Hour,
+		send: func(h handler.EventHandler, q workqueue.TypedRateLimitingInterface[ctrl.Request]) {
+			h.Delete(context.Background(), event.DeleteEvent{Object: policy}, q)
+		},
+		wantLen: 1,
+	}, {
+		name:  "update without delay",
+		delay: 0,
+		send: func(h handler.EventHandler, q workqueue.TypedRateLimitingInterface[ctrl.Request]) {
+			h.Update(context.Background(), event.UpdateEvent{ObjectOld: policy, ObjectNew: policy}, q)
+		},
+		wantLen: 1,
+	}, {
+		name:  "update with delay",
+		delay: time.Hour,
+		send: func(h handler.EventHandler, q workqueue.TypedRateLimitingInterface[ctrl.Request]) {
+			h.Update(context.Background(), event.UpdateEvent{ObjectOld: policy, ObjectNew: policy}, q)
+		},
+		wantLen: 0,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newTestQueue(t)
+			tt.send(coalescingHandler(tt.delay), q)
+			assert.Equal(t, tt.wantLen, q.Len())
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+type compositeProvider struct {
+	providers []Provider
+}
+
+// NewCompositeProvider returns a provider merging the policies of the given providers, the merged
+// policies are ordered by priority, policies with the same priority and name keep the providers order.
+// A failing provider doesn't hide the others, the composite returns the policies of every provider
+// that succeeded together with the errors of the failing ones, callers treating any error as an
+// evaluation failure keep failing closed when part of the policies is missing.
+// The composite has synced once every provider has synced.
+func NewCompositeProvider(providers ...Provider) Provider {
+	return &compositeProvider{
+		providers: providers,
+	}
+}
+
+func (p *compositeProvider) CompiledPolicies(ctx context.Context) ([]CompiledPolicy, error) {
+	var out []CompiledPolicy
+	var errs []error
+	for i, provider := range p.providers {
+		policies, err := provider.CompiledPolicies(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("provider %d: %w", i, err))
+			continue
+		}
+		out = append(out, policies...)
+	}
+	// the providers sorted their own policies, the merged set is sorted again globally
+	slices.SortStableFunc(out, ByPriority)
+	return out, errors.Join(errs...)
+}
+
+func (p *compositeProvider) HasSynced() bool {
+	for _, provider := range p.providers {
+		if !provider.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// Recompile compiles the policies of every provider again with the compiler, it fails if any provider doesn't
+// support recompiling its policies or fails to, the providers recompiled already are finished without committing.
+func (p *compositeProvider) Recompile(compiler Compiler) (func(bool), error) {
+	finishers := make([]func(bool), 0, len(p.providers))
+	finish := func(commit bool) {
+		for _, finish := range finishers {
+			finish(commit)
+		}
+	}
+	for i, provider := range p.providers {
+		recompiler, ok := provider.(Recompiler)
+		if !ok {
+			finish(false)
+			return nil, fmt.Errorf("provider %d doesn't support recompiling policies", i)
+		}
+		finisher, err := recompiler.Recompile(compiler)
+		if err != nil {
+			finish(false)
+			return nil, fmt.Errorf("provider %d: %w", i, err)
+		}
+		finishers = append(finishers, finisher)
+	}
+	return finish, nil
+}
@@ -0,0 +1,119 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/cel-go/cel"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
+)
+
+type failingProvider struct {
+	err error
+}
+
+func (p failingProvider) CompiledPolicies(context.Context) ([]CompiledPolicy, error) {
+	return nil, p.err
+}
+
+func (p failingProvider) HasSynced() bool {
+	return true
+}
+
+func names(policies []CompiledPolicy) []string {
+	var out []string
+	for _, policy := range policies {
+		out = append(out, policy.Name)
+	}
+	return out
+}
+
+func TestNewCompositeProvider(t *testing.T) {
+	baseline := newHubPolicy(t, "baseline", `envoy.Denied(403).Response()`)
+	baseline.Spec.Priority = 100
+	fallback := newHubPolicy(t, "fallback", `envoy.Allowed().Response()`)
+	fallback.Spec.Priority = -100
+	static, err := NewStaticProvider(NewCompiler(), fallback, baseline)
+	assert.NoError(t, err)
+	team := newPolicy("team", `envoy.Allowed().Response()`)
+	other := newPolicy("other", `envoy.Allowed().Response()`)
+	other.Spec.Priority = 200
+	kube := newPolicyReconciler(newFakeClient(t, team, other), NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	provider := NewCompositeProvider(static, kube)
+	// the kube provider didn't sync yet
+	assert.False(t, provider.HasSynced())
+	assert.False(t, Ready(context.Background(), provider))
+	assert.NoError(t, kube.sync(context.Background()))
+	reconcile(t, kube, "team")
+	reconcile(t, kube, "other")
+	assert.True(t, provider.HasSynced())
+	assert.True(t, Ready(context.Background(), provider))
+	// policies of both providers are merged in global priority order
+	policies, err := provider.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"other", "baseline", "team", "fallback"}, names(policies))
+}
+
+func TestNewCompositeProvider_sameName(t *testing.T) {
+	first, err := NewStaticProvider(NewCompiler(), newHubPolicy(t, "policy", `envoy.Allowed().Response().WithMessage("first")`))
+	assert.NoError(t, err)
+	second, err := NewStaticProvider(NewCompiler(), newHubPolicy(t, "policy", `envoy.Allowed().Response().WithMessage("second")`))
+	assert.NoError(t, err)
+	policies, err := NewCompositeProvider(first, second).CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, policies, 2)
+	// ties keep the providers order
+	for i, want := range []string{"first", "second"} {
+		response, err := policies[i].Evaluate(context.Background(), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, want, response.GetStatus().GetMessage())
+	}
+}
+
+func TestNewCompositeProvider_errors(t *testing.T) {
+	static, err := NewStaticProvider(NewCompiler(), newHubPolicy(t, "baseline", `envoy.Allowed().Response()`))
+	assert.NoError(t, err)
+	unavailable := errors.New("unavailable")
+	unreachable := errors.New("unreachable")
+	provider := NewCompositeProvider(failingProvider{err: unavailable}, static, failingProvider{err: unreachable})
+	policies, err := provider.CompiledPolicies(context.Background())
+	// every error is reported, the policies of the healthy providers are still returned
+	assert.ErrorIs(t, err, unavailable)
+	assert.ErrorIs(t, err, unreachable)
+	assert.ErrorContains(t, err, "provider 0: unavailable")
+	assert.ErrorContains(t, err, "provider 2: unreachable")
+	assert.Equal(t, []string{"baseline"}, names(policies))
+	assert.False(t, Ready(context.Background(), provider))
+}
+
+func TestNewCompositeProvider_empty(t *testing.T) {
+	provider := NewCompositeProvider()
+	assert.True(t, provider.HasSynced())
+	policies, err := provider.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, policies)
+}
+
+func TestCompositeProvider_Recompile(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "greet.yaml", greetPolicy)
+	compiler := NewReloadableCompiler([]cel.EnvOption{greetLibrary("hello")})
+	files, err := NewFileProvider(compiler, nil, false, dir)
+	assert.NoError(t, err)
+	static, err := NewStaticProvider(compiler, newHubPolicy(t, "static", `org.greet("world") == "hello world" ? envoy.Allowed().Response() : envoy.Denied(403).Response()`))
+	assert.NoError(t, err)
+	// every provider is recompiled
+	assert.NoError(t, compiler.Reload([]cel.EnvOption{greetLibrary("hi")}, NewCompositeProvider(files, static).(Recompiler)))
+	assert.Equal(t, []int32{int32(codes.PermissionDenied)}, statusCodes(t, files))
+	assert.Equal(t, []int32{int32(codes.PermissionDenied)}, statusCodes(t, static))
+	// a provider that can't be recompiled fails the reload, the other providers keep their policies
+	provider := NewCompositeProvider(files, failingProvider{err: errors.New("unavailable")}).(Recompiler)
+	assert.ErrorContains(t, compiler.Reload([]cel.EnvOption{greetLibrary("hello")}, provider), "provider 1 doesn't support recompiling policies")
+	assert.Equal(t, []int32{int32(codes.PermissionDenied)}, statusCodes(t, files))
+	assert.Len(t, compiler.Libraries(), 1)
+}
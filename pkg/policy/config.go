@@ -0,0 +1,79 @@
+package policy
+
+import (
+	"context"
+	"errors"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/http"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/jwt"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// errTypeCheckOnly is returned by the libraries registered for type checking only
+var errTypeCheckOnly = errors.New("the policy is only type checked, external data can't be read")
+
+// CompilerConfig configures the compilers of the commands. The authz server and the validation webhook build their
+// compilers from it so that the webhook admits the policies the server compiles.
+type CompilerConfig struct {
+	// MaxCost is the maximum runtime cost of every CEL program, see WithMaxCost
+	MaxCost uint64
+	// AnnotationPrefixes are the prefixes of the policy annotations exposed, see WithAnnotationPrefixes
+	AnnotationPrefixes []string
+	// ScopeKey is the context extension the scope of policies is matched against, the default key if empty
+	ScopeKey string
+	// Sandbox rejects the policies reading external data, see WithSandbox
+	Sandbox bool
+	// HTTPAllowedHosts are the hosts the http library calls, the library is only registered if not empty
+	HTTPAllowedHosts []string
+	// HTTPOptions configure the http library
+	HTTPOptions []http.Option
+	// KeySet verifies the tokens of the trusted issuers, the key set library is only registered if set
+	KeySet *jwt.KeySet
+	// IdentitySources resolve the identity variable, see WithIdentitySources
+	IdentitySources []IdentitySource
+	// TypeCheckOnly registers the libraries reading external data that are not configured so that the policies using
+	// them compile, their functions fail when evaluated. It is meant for compilers validating policies they never
+	// evaluate, like the validation webhook.
+	TypeCheckOnly bool
+}
+
+// Options returns the compiler options of the configuration, providers can append their own options
+func (c CompilerConfig) Options() []CompilerOption {
+	opts := []CompilerOption{WithMaxCost(c.MaxCost), WithAnnotationPrefixes(c.AnnotationPrefixes...), WithScopeKey(c.ScopeKey)}
+	// the sandbox applies to every compiler, including the identity sources
+	if c.Sandbox {
+		opts = append(opts, WithSandbox())
+	}
+	// the kubernetes reader is registered by the providers reading policies from the api server
+	if c.TypeCheckOnly {
+		opts = append(opts, WithKubeReader(typeCheckReader{}))
+	}
+	// the http library is shared by all the compilers, they share its cache. Without allowed hosts, every call fails.
+	if len(c.HTTPAllowedHosts) != 0 || c.TypeCheckOnly {
+		opts = append(opts, WithHTTP(c.HTTPAllowedHosts, c.HTTPOptions...))
+	}
+	// the key set is shared by all the compilers, they share the fetched keys
+	keys := c.KeySet
+	if keys == nil && c.TypeCheckOnly {
+		// a key set without issuers trusts no token, creating it without options can't fail
+		keys, _ = jwt.NewKeySet(nil)
+	}
+	if keys != nil {
+		opts = append(opts, WithJWTKeySet(keys))
+	}
+	if len(c.IdentitySources) != 0 {
+		opts = append(opts, WithIdentitySources(c.IdentitySources...))
+	}
+	return opts
+}
+
+// typeCheckReader is the kubernetes reader of the compilers only type checking policies, it never reads resources
+type typeCheckReader struct{}
+
+func (typeCheckReader) Get(context.Context, client.ObjectKey, client.Object, ...client.GetOption) error {
+	return errTypeCheckOnly
+}
+
+func (typeCheckReader) List(context.Context, client.ObjectList, ...client.ListOption) error {
+	return errTypeCheckOnly
+}
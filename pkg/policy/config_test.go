@@ -0,0 +1,54 @@
+package policy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCompilerConfig_TypeCheckOnly(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		// wantErr is the error of the evaluation
+		wantErr string
+	}{{
+		name:       "k8s.Get",
+		expression: `k8s.Get("v1", "ConfigMap", "default", "allowed").data.enabled == "true" ? envoy.Allowed().Response() : envoy.Denied(403).Response()`,
+		wantErr:    errTypeCheckOnly.Error(),
+	}, {
+		name:       "http.Get",
+		expression: `http.Get("https://example.com/allowed").allowed ? envoy.Allowed().Response() : envoy.Denied(403).Response()`,
+		wantErr:    `host "example.com" is not allowed`,
+	}, {
+		name:       "http.Post",
+		expression: `http.Post("https://example.com/check", {"path": "/"}).allowed ? envoy.Allowed().Response() : envoy.Denied(403).Response()`,
+		wantErr:    `host "example.com" is not allowed`,
+	}, {
+		name:       "jwt.Verify with the key set",
+		expression: `jwt.Verify("not-a-token").Valid ? envoy.Allowed().Response() : envoy.Denied(403).Response()`,
+		wantErr:    "failed to verify token",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := &hub.AuthorizationPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "demo"},
+				Spec: hub.AuthorizationPolicySpec{
+					Authorizations: []hub.Authorization{{Expression: tt.expression}},
+				},
+			}
+			// the libraries that are not configured are not registered
+			_, errs := NewCompiler(CompilerConfig{}.Options()...).Compile(policy)
+			assert.NotEmpty(t, errs)
+			compiled, errs := NewCompiler(CompilerConfig{TypeCheckOnly: true}.Options()...).Compile(policy)
+			require.Empty(t, errs)
+			// the functions fail when evaluated
+			_, err := compiled.Evaluate(context.Background(), nil)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
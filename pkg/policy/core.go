@@ -0,0 +1,142 @@
+package policy
+
+import (
+	"github.com/google/cel-go/cel"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/http"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/jwt"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/k8s"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// the compiler lives in the core package, it doesn't depend on controller runtime and builds for wasm targets
+
+const (
+	VariablesKey      = core.VariablesKey
+	ObjectKey         = core.ObjectKey
+	InputKey          = core.InputKey
+	ContextKey        = core.ContextKey
+	SourceKey         = core.SourceKey
+	AuthKey           = core.AuthKey
+	DestinationKey    = core.DestinationKey
+	RequestKey        = core.RequestKey
+	ConnectionKey     = core.ConnectionKey
+	DataKey           = core.DataKey
+	IdentityKey       = core.IdentityKey
+	ResponseKey       = core.ResponseKey
+	FilterMetadataKey = core.FilterMetadataKey
+	NowKey            = core.NowKey
+	MetadataKey       = core.MetadataKey
+	MetadataPolicyKey = core.MetadataPolicyKey
+	MetadataReasonKey = core.MetadataReasonKey
+	DefaultScopeKey   = core.DefaultScopeKey
+	// ResponseMetadataNamespace is the filter metadata namespace carrying the upstream response, see core.ResponseMetadataNamespace
+	ResponseMetadataNamespace = core.ResponseMetadataNamespace
+)
+
+type (
+	PolicyFunc     = core.PolicyFunc
+	CompiledPolicy = core.CompiledPolicy
+	Compiler       = core.Compiler
+	CompilerOption = core.CompilerOption
+	Provider       = core.Provider
+	HeaderUsage    = core.HeaderUsage
+	// EvaluationError is returned when a policy expression failed to evaluate against a request
+	EvaluationError = core.EvaluationError
+	Schema          = core.Schema
+	IdentitySource  = core.IdentitySource
+	CompileCache    = core.CompileCache
+	// ReloadableCompiler is a compiler whose custom libraries can be reloaded, see core.ReloadableCompiler
+	ReloadableCompiler = core.ReloadableCompiler
+	Recompiler         = core.Recompiler
+)
+
+// WithMaxCost sets the maximum runtime cost of every CEL program, see core.WithMaxCost
+func WithMaxCost(maxCost uint64) CompilerOption {
+	return core.WithMaxCost(maxCost)
+}
+
+// WithLibraries registers additional CEL libraries, see core.WithLibraries
+func WithLibraries(libraries ...cel.EnvOption) CompilerOption {
+	return core.WithLibraries(libraries...)
+}
+
+// WithAnnotationPrefixes exposes the policy annotations with one of the prefixes, see core.WithAnnotationPrefixes
+func WithAnnotationPrefixes(prefixes ...string) CompilerOption {
+	return core.WithAnnotationPrefixes(prefixes...)
+}
+
+// WithSandbox rejects the policies reading external data, see core.WithSandbox
+func WithSandbox() CompilerOption {
+	return core.WithSandbox()
+}
+
+// WithScopeKey sets the context extension the scope of policies is matched against, see core.WithScopeKey
+func WithScopeKey(key string) CompilerOption {
+	return core.WithScopeKey(key)
+}
+
+// WithClock sets the clock the now variable is read from, see core.WithClock
+func WithClock(clock clock.PassiveClock) CompilerOption {
+	return core.WithClock(clock)
+}
+
+// WithCompileCache shares the compiled expressions of the policies with identical specs, see core.WithCompileCache
+func WithCompileCache(cache *CompileCache) CompilerOption {
+	return core.WithCompileCache(cache)
+}
+
+// NewReloadableCompiler returns a compiler whose custom libraries can be reloaded, see core.NewReloadableCompiler
+func NewReloadableCompiler(libraries []cel.EnvOption, opts ...CompilerOption) *ReloadableCompiler {
+	return core.NewReloadableCompiler(libraries, opts...)
+}
+
+// NewCompileCache returns an empty compile cache, see core.CompileCache
+func NewCompileCache() *CompileCache {
+	return core.NewCompileCache()
+}
+
+// WithKubeReader registers the k8s.Get function, reading Kubernetes resources from the given reader.
+// The reader is used for every evaluation and should be backed by a cache, like the manager cache.
+func WithKubeReader(reader client.Reader) CompilerOption {
+	return core.WithLibraries(k8s.Lib(reader))
+}
+
+// WithHTTP registers the http.Get and http.Post functions, calling the given hosts only.
+// The option must be created once and shared by the compilers so that they share the response cache.
+func WithHTTP(allowedHosts []string, opts ...http.Option) CompilerOption {
+	return core.WithLibraries(http.Lib(allowedHosts, opts...))
+}
+
+// WithJWTKeySet registers the jwt.Verify overload verifying tokens with the keys of their trusted issuer.
+// The key set must be created once and shared by the compilers so that they share the fetched keys.
+func WithJWTKeySet(keys *jwt.KeySet) CompilerOption {
+	return core.WithLibraries(jwt.KeySetLib(keys))
+}
+
+func NewCompiler(opts ...CompilerOption) Compiler {
+	return core.NewCompiler(opts...)
+}
+
+// NewSchema describes the environment policy expressions are compiled in, see core.NewSchema
+func NewSchema(opts ...CompilerOption) (Schema, error) {
+	return core.NewSchema(opts...)
+}
+
+// ConvertPolicy converts a policy of a served version to the hub version, see core.ConvertPolicy
+func ConvertPolicy(policy runtime.Object) (*hub.AuthorizationPolicy, error) {
+	return core.ConvertPolicy(policy)
+}
+
+// NewStaticProvider returns a provider serving a fixed set of policies, it fails if any of the policies fails to compile
+func NewStaticProvider(compiler Compiler, policies ...*hub.AuthorizationPolicy) (Provider, error) {
+	return core.NewStaticProvider(compiler, policies...)
+}
+
+// MergeHeaderUsage returns the request headers read by any of the policies, see core.MergeHeaderUsage
+func MergeHeaderUsage(policies ...CompiledPolicy) HeaderUsage {
+	return core.MergeHeaderUsage(policies...)
+}
@@ -0,0 +1,165 @@
+package core
+
+import (
+	"fmt"
+	"slices"
+	"time"
+	// the time zones of the activation windows don't depend on the time zones installed in the image
+	_ "time/tzdata"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/clock"
+)
+
+const minutesPerDay = 24 * 60
+
+var weekdays = map[hub.Weekday]time.Weekday{
+	"Sunday":    time.Sunday,
+	"Monday":    time.Monday,
+	"Tuesday":   time.Tuesday,
+	"Wednesday": time.Wednesday,
+	"Thursday":  time.Thursday,
+	"Friday":    time.Friday,
+	"Saturday":  time.Saturday,
+}
+
+// Activation is the compiled activation of a policy, it tells when the policy is evaluated. A nil activation is
+// always active.
+type Activation struct {
+	// from and until bound the activation, zero values don't bound it
+	from, until time.Time
+	location    *time.Location
+	windows     []activationWindow
+	// clock is the clock of the compiler, the now variable is read from it too
+	clock clock.PassiveClock
+}
+
+type activationWindow struct {
+	// days are the days the window starts on, every day when empty
+	days []time.Weekday
+	// start and end are minutes since midnight, a window ending before its start ends on the next day
+	start, end int
+}
+
+func compileActivation(path *field.Path, in *hub.Activation, clock clock.PassiveClock) (*Activation, field.ErrorList) {
+	if in == nil {
+		return nil, nil
+	}
+	var errs field.ErrorList
+	out := Activation{location: time.UTC, clock: clock}
+	if in.From != nil {
+		out.from = in.From.Time
+	}
+	if in.Until != nil {
+		out.until = in.Until.Time
+		if !out.from.IsZero() && !out.until.After(out.from) {
+			errs = append(errs, field.Invalid(path.Child("until"), in.Until.Format(time.RFC3339), "until must be after from"))
+		}
+	}
+	if in.TimeZone != "" {
+		location, err := time.LoadLocation(in.TimeZone)
+		if err != nil {
+			errs = append(errs, field.Invalid(path.Child("timeZone"), in.TimeZone, err.Error()))
+		} else {
+			out.location = location
+		}
+	}
+	for i, window := range in.Windows {
+		path := path.Child("windows").Index(i)
+		compiled, windowErrs := compileActivationWindow(path, window)
+		errs = append(errs, windowErrs...)
+		out.windows = append(out.windows, compiled)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return &out, nil
+}
+
+func compileActivationWindow(path *field.Path, in hub.ActivationWindow) (activationWindow, field.ErrorList) {
+	var errs field.ErrorList
+	var out activationWindow
+	for i, day := range in.Days {
+		weekday, ok := weekdays[day]
+		if !ok {
+			errs = append(errs, field.NotSupported(path.Child("days").Index(i), day, []string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"}))
+			continue
+		}
+		out.days = append(out.days, weekday)
+	}
+	start, err := parseTimeOfDay(in.Start, false)
+	if err != nil {
+		errs = append(errs, field.Invalid(path.Child("start"), in.Start, err.Error()))
+	}
+	end, err := parseTimeOfDay(in.End, true)
+	if err != nil {
+		errs = append(errs, field.Invalid(path.Child("end"), in.End, err.Error()))
+	}
+	if len(errs) == 0 && start == end {
+		errs = append(errs, field.Invalid(path.Child("end"), in.End, "end must differ from start"))
+	}
+	out.start, out.end = start, end
+	return out, errs
+}
+
+// parseTimeOfDay parses a HH:MM time into minutes since midnight, 24:00 is only valid at the end of a window
+func parseTimeOfDay(in string, end bool) (int, error) {
+	if len(in) != 5 || in[2] != ':' || !isDigit(in[0]) || !isDigit(in[1]) || !isDigit(in[3]) || !isDigit(in[4]) {
+		return 0, fmt.Errorf("time must be in the HH:MM format")
+	}
+	hours, minutes := int(in[0]-'0')*10+int(in[1]-'0'), int(in[3]-'0')*10+int(in[4]-'0')
+	if end && hours == 24 && minutes == 0 {
+		return minutesPerDay, nil
+	}
+	if hours > 23 || minutes > 59 {
+		return 0, fmt.Errorf("time must be between 00:00 and 23:59")
+	}
+	return hours*60 + minutes, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Active returns true when the policy is evaluated now, the time is read from the clock of the compiler and the
+// windows are matched against the local time of the activation time zone
+func (a *Activation) Active() bool {
+	if a == nil {
+		return true
+	}
+	now := a.clock.Now()
+	if !a.from.IsZero() && now.Before(a.from) {
+		return false
+	}
+	if !a.until.IsZero() && !now.Before(a.until) {
+		return false
+	}
+	if len(a.windows) == 0 {
+		return true
+	}
+	local := now.In(a.location)
+	day, minute := local.Weekday(), local.Hour()*60+local.Minute()
+	for _, window := range a.windows {
+		if window.contains(day, minute) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains returns true when the minute of the day is in the window, a window ending on the next day contains
+// the end of the day it starts on and the beginning of the next day
+func (w activationWindow) contains(day time.Weekday, minute int) bool {
+	if w.start < w.end {
+		return w.startsOn(day) && minute >= w.start && minute < w.end
+	}
+	if w.startsOn(day) && minute >= w.start {
+		return true
+	}
+	return w.startsOn((day+6)%7) && minute < w.end
+}
+
+func (w activationWindow) startsOn(day time.Weekday) bool {
+	return len(w.days) == 0 || slices.Contains(w.days, day)
+}
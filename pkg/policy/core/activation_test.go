@@ -0,0 +1,202 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/clock"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func mustParseTime(t *testing.T, value string) time.Time {
+	t.Helper()
+	out, err := time.Parse(time.RFC3339, value)
+	require.NoError(t, err)
+	return out
+}
+
+func compileActivationPolicy(t *testing.T, activation *hub.Activation, clock clock.PassiveClock) *Activation {
+	t.Helper()
+	policy := newPolicy("policy", `envoy.Allowed().Response()`)
+	policy.Spec.Activation = activation
+	compiled, errs := NewCompiler(WithClock(clock)).Compile(policy)
+	require.Empty(t, errs)
+	return compiled.Activation
+}
+
+func TestActivation_Active(t *testing.T) {
+	from, until := metav1.NewTime(mustParseTime(t, "2026-03-02T08:00:00Z")), metav1.NewTime(mustParseTime(t, "2026-03-09T08:00:00Z"))
+	// 2026-03-02 is a Monday, daylight saving time starts on 2026-03-29 in Europe/Paris
+	tests := []struct {
+		name       string
+		activation *hub.Activation
+		active     map[string]bool
+	}{{
+		name:       "no activation",
+		activation: nil,
+		active:     map[string]bool{"2000-01-01T00:00:00Z": true},
+	}, {
+		name:       "period",
+		activation: &hub.Activation{From: &from, Until: &until},
+		active: map[string]bool{
+			"2026-03-02T07:59:59Z": false,
+			"2026-03-02T08:00:00Z": true,
+			"2026-03-09T07:59:59Z": true,
+			"2026-03-09T08:00:00Z": false,
+		},
+	}, {
+		name:       "open ended period",
+		activation: &hub.Activation{From: &from},
+		active: map[string]bool{
+			"2026-03-02T07:59:59Z": false,
+			"2030-01-01T00:00:00Z": true,
+		},
+	}, {
+		name: "business hours",
+		activation: &hub.Activation{Windows: []hub.ActivationWindow{{
+			Days:  []hub.Weekday{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday"},
+			Start: "09:00",
+			End:   "17:30",
+		}}},
+		active: map[string]bool{
+			"2026-03-02T08:59:59Z": false,
+			"2026-03-02T09:00:00Z": true,
+			"2026-03-02T17:29:59Z": true,
+			"2026-03-02T17:30:00Z": false,
+			// saturday
+			"2026-03-07T12:00:00Z": false,
+		},
+	}, {
+		name: "time zone",
+		activation: &hub.Activation{TimeZone: "Europe/Paris", Windows: []hub.ActivationWindow{{
+			Start: "09:00",
+			End:   "17:00",
+		}}},
+		active: map[string]bool{
+			// UTC+1 in winter
+			"2026-03-02T07:59:59Z": false,
+			"2026-03-02T08:00:00Z": true,
+			"2026-03-02T15:59:59Z": true,
+			"2026-03-02T16:00:00Z": false,
+			// UTC+2 in summer, the window follows the local time
+			"2026-03-30T06:59:59Z": false,
+			"2026-03-30T07:00:00Z": true,
+			"2026-03-30T14:59:59Z": true,
+			"2026-03-30T15:00:00Z": false,
+		},
+	}, {
+		name: "window ending on the next day",
+		activation: &hub.Activation{Windows: []hub.ActivationWindow{{
+			Days:  []hub.Weekday{"Saturday"},
+			Start: "22:00",
+			End:   "02:00",
+		}}},
+		active: map[string]bool{
+			"2026-03-07T21:59:59Z": false,
+			"2026-03-07T22:00:00Z": true,
+			// sunday morning
+			"2026-03-08T01:59:59Z": true,
+			"2026-03-08T02:00:00Z": false,
+			// the window doesn't start on sunday
+			"2026-03-08T23:00:00Z": false,
+			// nor does it end on saturday morning
+			"2026-03-07T01:00:00Z": false,
+		},
+	}, {
+		name: "window ending at midnight",
+		activation: &hub.Activation{Windows: []hub.ActivationWindow{{
+			Days:  []hub.Weekday{"Monday"},
+			Start: "00:00",
+			End:   "24:00",
+		}}},
+		active: map[string]bool{
+			"2026-03-01T23:59:59Z": false,
+			"2026-03-02T00:00:00Z": true,
+			"2026-03-02T23:59:59Z": true,
+			"2026-03-03T00:00:00Z": false,
+		},
+	}, {
+		name: "overlapping windows",
+		activation: &hub.Activation{Windows: []hub.ActivationWindow{{
+			Start: "09:00",
+			End:   "12:00",
+		}, {
+			Start: "11:00",
+			End:   "14:00",
+		}}},
+		active: map[string]bool{
+			"2026-03-02T08:59:59Z": false,
+			"2026-03-02T11:30:00Z": true,
+			"2026-03-02T13:59:59Z": true,
+			"2026-03-02T14:00:00Z": false,
+		},
+	}, {
+		name: "windows in a period",
+		activation: &hub.Activation{From: &from, Until: &until, Windows: []hub.ActivationWindow{{
+			Start: "07:00",
+			End:   "09:00",
+		}}},
+		active: map[string]bool{
+			// in the window, before the period
+			"2026-03-02T07:30:00Z": false,
+			"2026-03-02T08:30:00Z": true,
+			// in the window, after the period
+			"2026-03-09T08:30:00Z": false,
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := testingclock.NewFakePassiveClock(time.Time{})
+			activation := compileActivationPolicy(t, tt.activation, clock)
+			for now, want := range tt.active {
+				clock.SetTime(mustParseTime(t, now))
+				assert.Equal(t, want, activation.Active(), now)
+			}
+		})
+	}
+}
+
+func Test_compiler_Compile_activation(t *testing.T) {
+	from, until := metav1.NewTime(mustParseTime(t, "2026-03-02T08:00:00Z")), metav1.NewTime(mustParseTime(t, "2026-03-09T08:00:00Z"))
+	tests := []struct {
+		name       string
+		activation *hub.Activation
+		wantErr    string
+	}{{
+		name:       "until before from",
+		activation: &hub.Activation{From: &until, Until: &from},
+		wantErr:    "spec.activation.until: Invalid value: \"2026-03-02T08:00:00Z\": until must be after from",
+	}, {
+		name:       "unknown time zone",
+		activation: &hub.Activation{TimeZone: "Mars/Olympus_Mons"},
+		wantErr:    "spec.activation.timeZone: Invalid value: \"Mars/Olympus_Mons\"",
+	}, {
+		name:       "unknown day",
+		activation: &hub.Activation{Windows: []hub.ActivationWindow{{Days: []hub.Weekday{"Mon"}, Start: "09:00", End: "17:00"}}},
+		wantErr:    "spec.activation.windows[0].days[0]: Unsupported value: \"Mon\"",
+	}, {
+		name:       "invalid start",
+		activation: &hub.Activation{Windows: []hub.ActivationWindow{{Start: "9:00", End: "17:00"}}},
+		wantErr:    "spec.activation.windows[0].start: Invalid value: \"9:00\": time must be in the HH:MM format",
+	}, {
+		name:       "start at midnight",
+		activation: &hub.Activation{Windows: []hub.ActivationWindow{{Start: "24:00", End: "17:00"}}},
+		wantErr:    "spec.activation.windows[0].start: Invalid value: \"24:00\": time must be between 00:00 and 23:59",
+	}, {
+		name:       "empty window",
+		activation: &hub.Activation{Windows: []hub.ActivationWindow{{Start: "09:00", End: "09:00"}}},
+		wantErr:    "spec.activation.windows[0].end: Invalid value: \"09:00\": end must differ from start",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", `envoy.Allowed().Response()`)
+			policy.Spec.Activation = tt.activation
+			_, errs := NewCompiler().Compile(policy)
+			assert.ErrorContains(t, errs.ToAggregate(), tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package core
+
+import (
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/types"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/envoy"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/ip"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// HeaderUsage describes the request headers read by policies
+type HeaderUsage struct {
+	// All is true when the analysis couldn't tell which headers are read, every header must be forwarded
+	All bool `json:"all"`
+	// Names are the lowercased names of the headers read, sorted alphabetically, empty when All is true
+	Names []string `json:"headers"`
+}
+
+// MergeHeaderUsage returns the request headers read by any of the policies
+func MergeHeaderUsage(policies ...CompiledPolicy) HeaderUsage {
+	names := sets.New[string]()
+	for _, policy := range policies {
+		if policy.RequestHeaders.All {
+			return HeaderUsage{All: true, Names: []string{}}
+		}
+		names.Insert(policy.RequestHeaders.Names...)
+	}
+	return HeaderUsage{Names: sets.List(names)}
+}
+
+var (
+	// requestTypes are the types carrying the request headers, directly or not
+	requestTypes = sets.New(
+		"envoy.service.auth.v3.CheckRequest",
+		"envoy.service.auth.v3.AttributeContext",
+		"envoy.service.auth.v3.AttributeContext.Request",
+		httpRequestType,
+	)
+	httpRequestType = "envoy.service.auth.v3.AttributeContext.HttpRequest"
+	// bodyFields are the fields of the http request carrying the body
+	bodyFields = sets.New("body", "raw_body")
+	// requestFunctions are the functions receiving the request and the headers they read, they don't read the body
+	requestFunctions = map[string][]string{
+		"BodyTruncated": {envoy.PartialBodyHeader},
+		"ip.SourceIP":   {},
+		"ip.ClientIP":   {ip.ForwardedForHeader},
+	}
+)
+
+// headerAnalyzer records the request headers read by the expressions compiled in an environment.
+// It is registered as a validator to see every checked expression, it never reports issues and
+// the environment must be used to compile a single policy.
+//
+// The analysis is best effort: headers are recognized when they are accessed with a constant name
+// (`headers['x']`, `headers.x`, `'x' in headers`), any other use of the headers map or of a message
+// carrying it (iterating the headers, indexing with a computed name, passing the request to a function...)
+// is assumed to read every header.
+//
+// The analyzer also records whether the body is read, by selecting it from the http request or by any use of a
+// message carrying it the header analysis can't tell apart.
+type headerAnalyzer struct {
+	all   bool
+	names sets.Set[string]
+	body  bool
+	// identity is true when the identity variable is read, it is resolved by expressions analyzed separately
+	identity bool
+}
+
+func newHeaderAnalyzer() *headerAnalyzer {
+	return &headerAnalyzer{names: sets.New[string]()}
+}
+
+func (a *headerAnalyzer) Name() string {
+	return "kyverno.headers"
+}
+
+func (a *headerAnalyzer) Validate(_ *cel.Env, _ cel.ValidatorConfig, checked *ast.AST, _ *cel.Issues) {
+	for _, expr := range ast.MatchDescendants(ast.NavigateAST(checked), ast.AllMatcher()) {
+		if expr.Kind() == ast.IdentKind && expr.AsIdent() == IdentityKey {
+			a.identity = true
+		}
+		if !a.all {
+			a.visit(expr)
+		}
+		if !a.body {
+			a.visitBody(expr)
+		}
+	}
+}
+
+// merge records the headers of another analysis
+func (a *headerAnalyzer) merge(usage HeaderUsage) {
+	a.all = a.all || usage.All
+	a.names.Insert(usage.Names...)
+}
+
+func (a *headerAnalyzer) usage() HeaderUsage {
+	if a.all {
+		return HeaderUsage{All: true, Names: []string{}}
+	}
+	return HeaderUsage{Names: sets.List(a.names)}
+}
+
+func (a *headerAnalyzer) visit(expr ast.NavigableExpr) {
+	parent, hasParent := expr.Parent()
+	// a message carrying the headers can only be used to select one of its fields,
+	// or as the result of an expression (variables are typed, their uses are analyzed)
+	if isRequestType(expr.Type()) && hasParent {
+		switch parent.Kind() {
+		case ast.SelectKind:
+		case ast.CallKind:
+			// the function reads known headers
+			if names, ok := requestFunctions[parent.AsCall().FunctionName()]; ok {
+				a.names.Insert(names...)
+			} else {
+				a.all = true
+			}
+		default:
+			a.all = true
+		}
+	}
+	if expr.Kind() == ast.IdentKind && expr.AsIdent() == RequestKey {
+		a.visitRequest(expr, parent, hasParent)
+	}
+	if expr.Kind() != ast.SelectKind {
+		return
+	}
+	sel := expr.AsSelect()
+	// presence tests don't read headers values
+	if sel.IsTestOnly() {
+		return
+	}
+	// the only child of a select is its operand
+	if operand := expr.Children()[0]; operand.Type().TypeName() != httpRequestType {
+		return
+	}
+	switch sel.FieldName() {
+	case "header_map":
+		a.all = true
+	case "headers":
+		if name, ok := headerName(expr, parent, hasParent); ok {
+			a.names.Insert(strings.ToLower(name))
+		} else {
+			a.all = true
+		}
+	}
+}
+
+// visitBody records whether the expression reads the request body
+func (a *headerAnalyzer) visitBody(expr ast.NavigableExpr) {
+	parent, hasParent := expr.Parent()
+	if isRequestType(expr.Type()) && hasParent {
+		switch parent.Kind() {
+		case ast.SelectKind:
+		case ast.CallKind:
+			if _, ok := requestFunctions[parent.AsCall().FunctionName()]; !ok {
+				a.body = true
+			}
+		default:
+			a.body = true
+		}
+	}
+	if expr.Kind() != ast.SelectKind {
+		return
+	}
+	// presence tests don't read the body
+	if sel := expr.AsSelect(); !sel.IsTestOnly() && bodyFields.Has(sel.FieldName()) && expr.Children()[0].Type().TypeName() == httpRequestType {
+		a.body = true
+	}
+}
+
+// visitRequest records the headers read from the request variable
+func (a *headerAnalyzer) visitRequest(request, parent ast.NavigableExpr, hasParent bool) {
+	if !hasParent {
+		a.all = true
+		return
+	}
+	switch parent.Kind() {
+	case ast.SelectKind:
+		sel := parent.AsSelect()
+		if sel.IsTestOnly() {
+			return
+		}
+		switch sel.FieldName() {
+		// the grpc field is derived from the content type header
+		case "grpc":
+			a.names.Insert(grpcContentTypeHeader)
+		case "bodyTruncated":
+			a.names.Insert(envoy.PartialBodyHeader)
+		case "headers", "headerValues":
+			grandparent, hasGrandparent := parent.Parent()
+			if name, ok := headerName(parent, grandparent, hasGrandparent); ok {
+				a.names.Insert(strings.ToLower(name))
+			} else {
+				a.all = true
+			}
+		}
+	case ast.CallKind:
+		// request.headerValues('x')
+		call := parent.AsCall()
+		if call.FunctionName() == headerValuesFunctionName && call.IsMemberFunction() && call.Target().ID() == request.ID() && len(call.Args()) == 1 {
+			if name, ok := stringLiteral(call.Args()[0]); ok {
+				a.names.Insert(strings.ToLower(name))
+				return
+			}
+		}
+		a.all = true
+	default:
+		// any other use of the variable may read every header
+		a.all = true
+	}
+}
+
+// headerName returns the constant name of the header read from the headers map
+func headerName(headers, parent ast.NavigableExpr, hasParent bool) (string, bool) {
+	if !hasParent {
+		return "", false
+	}
+	switch parent.Kind() {
+	case ast.SelectKind:
+		// headers.x or has(headers.x)
+		return parent.AsSelect().FieldName(), true
+	case ast.CallKind:
+		call := parent.AsCall()
+		args := call.Args()
+		if len(args) != 2 {
+			return "", false
+		}
+		switch call.FunctionName() {
+		case operators.Index, operators.OptIndex:
+			// headers['x']
+			if args[0].ID() == headers.ID() {
+				return stringLiteral(args[1])
+			}
+		case operators.In:
+			// 'x' in headers
+			if args[1].ID() == headers.ID() {
+				return stringLiteral(args[0])
+			}
+		}
+	}
+	return "", false
+}
+
+func stringLiteral(expr ast.Expr) (string, bool) {
+	if expr.Kind() != ast.LiteralKind {
+		return "", false
+	}
+	value, ok := expr.AsLiteral().(types.String)
+	return string(value), ok
+}
+
+func isRequestType(t *types.Type) bool {
+	return t != nil && t.Kind() == types.StructKind && requestTypes.Has(t.TypeName())
+}
@@ -0,0 +1,187 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/envoy"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/ip"
+	"github.com/stretchr/testify/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+)
+
+func Test_compiler_Compile_requestHeaders(t *testing.T) {
+	const allowed = `envoy.Allowed().Response()`
+	tests := []struct {
+		name   string
+		policy *hub.AuthorizationPolicy
+		want   HeaderUsage
+	}{{
+		name:   "no headers",
+		policy: newPolicy("test", `object.attributes.request.http.path == "/" ? envoy.Allowed().Response() : null`),
+		want:   HeaderUsage{Names: []string{}},
+	}, {
+		name: "constant names",
+		policy: newPolicy("test",
+			`object.attributes.request.http.headers["X-Force-Deny"] == "true" ? envoy.Denied(403).Response() : null`,
+			`"authorization" in object.attributes.request.http.headers ? envoy.Allowed().Response() : null`,
+			`object.attributes.request.http.headers.tenant == "a" ? envoy.Allowed().Response() : null`,
+			`object.attributes.request.http.headers[?"x-user"].orValue("") == "" ? envoy.Allowed().Response() : null`,
+		),
+		want: HeaderUsage{Names: []string{"authorization", "tenant", "x-force-deny", "x-user"}},
+	}, {
+		name:   "presence test",
+		policy: newPolicy("test", `has(object.attributes.request.http.headers) ? envoy.Allowed().Response() : null`),
+		want:   HeaderUsage{Names: []string{}},
+	}, {
+		name: "conditions variables and reason",
+		policy: func() *hub.AuthorizationPolicy {
+			policy := newPolicy("test", `variables.http.headers["x-tenant"] == "a" ? envoy.Allowed().Response() : null`)
+			policy.Spec.MatchConditions = []admissionregistrationv1.MatchCondition{{Name: "admin", Expression: `object.attributes.request.http.headers["x-admin"] != "true"`}}
+			policy.Spec.Variables = []admissionregistrationv1.Variable{{Name: "http", Expression: `object.attributes.request.http`}}
+			policy.Spec.Reason = `object.attributes.request.http.headers["x-request-id"]`
+			return policy
+		}(),
+		want: HeaderUsage{Names: []string{"x-admin", "x-request-id", "x-tenant"}},
+	}, {
+		name:   "body truncated",
+		policy: newPolicy("test", `object.BodyTruncated() ? envoy.Denied(413).Response() : null`),
+		want:   HeaderUsage{Names: []string{envoy.PartialBodyHeader}},
+	}, {
+		name:   "client ip",
+		policy: newPolicy("test", `ip.InCIDR(ip.ClientIP(object, 1), "10.0.0.0/8") && ip.IsPrivate(ip.SourceIP(object)) ? envoy.Allowed().Response() : null`),
+		want:   HeaderUsage{Names: []string{ip.ForwardedForHeader}},
+	}, {
+		name:   "grpc request",
+		policy: newPolicy("test", `has(request.grpc) && request.grpc.method == "Delete" && request.path != "/" ? envoy.Denied(403).Response() : null`),
+		want:   HeaderUsage{Names: []string{"content-type"}},
+	}, {
+		name:   "truncated body",
+		policy: newPolicy("test", `request.bodyTruncated ? envoy.Denied(413).Response() : null`),
+		want:   HeaderUsage{Names: []string{"x-envoy-auth-partial-body"}},
+	}, {
+		name:   "request fields",
+		policy: newPolicy("test", `request.method == "GET" && request.query.limit == ["10"] ? envoy.Allowed().Response() : null`),
+		want:   HeaderUsage{Names: []string{}},
+	}, {
+		name: "request headers",
+		policy: newPolicy("test",
+			`request.headers["X-Tenant"] == "acme" && "authorization" in request.headers ? envoy.Allowed().Response() : null`,
+			`request.headerValues("x-forwarded-for").size() > 2 || has(request.headers.x_debug) ? envoy.Denied(403).Response() : null`,
+		),
+		want: HeaderUsage{Names: []string{"authorization", "x-forwarded-for", "x-tenant", "x_debug"}},
+	}, {
+		name:   "computed request header name",
+		policy: newPolicy("test", `request.headerValues("x-" + request.method) == [] ? envoy.Allowed().Response() : null`),
+		want:   HeaderUsage{All: true, Names: []string{}},
+	}, {
+		name:   "iterate request headers",
+		policy: newPolicy("test", `request.headers.exists(k, k.startsWith("x-")) ? envoy.Allowed().Response() : null`),
+		want:   HeaderUsage{All: true, Names: []string{}},
+	}, {
+		// the request variable carries the headers
+		name:   "whole request",
+		policy: newPolicy("test", `request.exists(k, k == "grpc") ? envoy.Allowed().Response() : null`),
+		want:   HeaderUsage{All: true, Names: []string{}},
+	}, {
+		name:   "computed name",
+		policy: newPolicy("test", `object.attributes.request.http.headers["x-" + object.attributes.request.http.path] == "" ? envoy.Allowed().Response() : null`),
+		want:   HeaderUsage{All: true, Names: []string{}},
+	}, {
+		name:   "iterate headers",
+		policy: newPolicy("test", `object.attributes.request.http.headers.exists(k, k.startsWith("x-")) ? envoy.Allowed().Response() : null`),
+		want:   HeaderUsage{All: true, Names: []string{}},
+	}, {
+		name:   "header map",
+		policy: newPolicy("test", `size(object.attributes.request.http.header_map.headers) > 0 ? envoy.Allowed().Response() : null`),
+		want:   HeaderUsage{All: true, Names: []string{}},
+	}, {
+		name: "headers variable",
+		policy: func() *hub.AuthorizationPolicy {
+			policy := newPolicy("test", `variables.headers["x-tenant"] == "a" ? envoy.Allowed().Response() : null`)
+			policy.Spec.Variables = []admissionregistrationv1.Variable{{Name: "headers", Expression: `object.attributes.request.http.headers`}}
+			return policy
+		}(),
+		want: HeaderUsage{All: true, Names: []string{}},
+	}, {
+		name:   "dynamic request",
+		policy: newPolicy("test", `dyn(object).attributes.request.http.headers["x-tenant"] == "a" ? envoy.Allowed().Response() : null`),
+		want:   HeaderUsage{All: true, Names: []string{}},
+	}, {
+		name: "unused headers",
+		policy: func() *hub.AuthorizationPolicy {
+			policy := newPolicy("test", allowed)
+			policy.Spec.Headers = &hub.Headers{Request: []hub.HeaderMutation{{Name: "x-forwarded-user", Action: hub.HeaderActionRemove}}}
+			return policy
+		}(),
+		want: HeaderUsage{Names: []string{}},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compiled, errs := NewCompiler().Compile(tt.policy)
+			assert.Empty(t, errs)
+			assert.Equal(t, tt.want, compiled.RequestHeaders)
+		})
+	}
+}
+
+func Test_compiler_Compile_readsBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *hub.AuthorizationPolicy
+		want   bool
+	}{{
+		name:   "no body",
+		policy: newPolicy("test", `object.attributes.request.http.headers[?"x-user"].orValue("") != "" ? envoy.Allowed().Response() : null`),
+	}, {
+		name:   "body",
+		policy: newPolicy("test", `object.attributes.request.http.body.contains("admin") ? envoy.Denied(403).Response() : null`),
+		want:   true,
+	}, {
+		name:   "raw body",
+		policy: newPolicy("test", `size(object.attributes.request.http.raw_body) > 1024 ? envoy.Denied(413).Response() : null`),
+		want:   true,
+	}, {
+		name:   "presence test",
+		policy: newPolicy("test", `has(object.attributes.request.http.body) ? envoy.Allowed().Response() : null`),
+	}, {
+		name:   "body truncated",
+		policy: newPolicy("test", `object.BodyTruncated() ? envoy.Denied(413).Response() : null`),
+	}, {
+		name: "condition",
+		policy: func() *hub.AuthorizationPolicy {
+			policy := newPolicy("test", `envoy.Allowed().Response()`)
+			policy.Spec.MatchConditions = []admissionregistrationv1.MatchCondition{{Name: "json", Expression: `object.attributes.request.http.body.startsWith("{")`}}
+			return policy
+		}(),
+		want: true,
+	}, {
+		name: "variable",
+		policy: func() *hub.AuthorizationPolicy {
+			policy := newPolicy("test", `variables.http.body.startsWith("{") ? envoy.Allowed().Response() : null`)
+			policy.Spec.Variables = []admissionregistrationv1.Variable{{Name: "http", Expression: `object.attributes.request.http`}}
+			return policy
+		}(),
+		want: true,
+	}, {
+		name:   "dynamic request",
+		policy: newPolicy("test", `dyn(object).attributes.request.http.path == "/" ? envoy.Allowed().Response() : null`),
+		want:   true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compiled, errs := NewCompiler().Compile(tt.policy)
+			assert.Empty(t, errs)
+			assert.Equal(t, tt.want, compiled.ReadsBody)
+		})
+	}
+}
+
+func TestMergeHeaderUsage(t *testing.T) {
+	a := CompiledPolicy{Name: "a", RequestHeaders: HeaderUsage{Names: []string{"authorization", "x-tenant"}}}
+	b := CompiledPolicy{Name: "b", RequestHeaders: HeaderUsage{Names: []string{"authorization", "x-user"}}}
+	all := CompiledPolicy{Name: "all", RequestHeaders: HeaderUsage{All: true, Names: []string{}}}
+	assert.Equal(t, HeaderUsage{Names: []string{}}, MergeHeaderUsage())
+	assert.Equal(t, HeaderUsage{Names: []string{"authorization", "x-tenant", "x-user"}}, MergeHeaderUsage(a, b))
+	assert.Equal(t, HeaderUsage{All: true, Names: []string{}}, MergeHeaderUsage(a, all, b))
+}
@@ -0,0 +1,49 @@
+package core
+
+import (
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/google/cel-go/common/types"
+)
+
+// jwtAuthnFilter is the metadata namespace the envoy jwt_authn filter writes verified tokens to
+const jwtAuthnFilter = "envoy.filters.http.jwt_authn"
+
+// SourceType is the type of the source variable, it holds the peer identity envoy authenticated:
+//   - principal is the attributes.source.principal, the identity of the mTLS peer certificate (SPIFFE id or subject)
+//   - certificate is the attributes.source.certificate, the URL encoded PEM of the peer certificate when envoy forwards it
+var SourceType = types.NewObjectType("kyverno.source")
+
+// sourceFields are the fields of the source variable
+var sourceFields = []mapField{
+	{name: "principal", celType: types.StringType},
+	{name: "certificate", celType: types.StringType},
+}
+
+// AuthType is the type of the auth variable, it holds the authentication results of upstream envoy filters:
+//   - jwt is the metadata the jwt_authn filter populated, keyed by the payload_in_metadata names
+var AuthType = types.NewMapType(types.StringType, types.DynType)
+
+// authFields are the fields of the auth variable
+var authFields = []mapField{
+	{name: "jwt", celType: types.NewMapType(types.StringType, types.DynType)},
+}
+
+// newSource returns the source variable of a check request, missing fields are empty strings
+func newSource(r *authv3.CheckRequest) map[string]any {
+	source := r.GetAttributes().GetSource()
+	return map[string]any{
+		"principal":   source.GetPrincipal(),
+		"certificate": source.GetCertificate(),
+	}
+}
+
+// newAuth returns the auth variable of a check request, missing fields are empty maps
+func newAuth(r *authv3.CheckRequest) map[string]any {
+	var jwt any = map[string]any{}
+	if metadata := r.GetAttributes().GetMetadataContext().GetFilterMetadata()[jwtAuthnFilter]; metadata != nil {
+		jwt = metadata
+	}
+	return map[string]any{
+		"jwt": jwt,
+	}
+}
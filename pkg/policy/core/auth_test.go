@@ -0,0 +1,142 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func Test_compiler_Compile_source(t *testing.T) {
+	mtls := &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Source: &authv3.AttributeContext_Peer{
+				Principal:   "spiffe://cluster.local/ns/default/sa/frontend",
+				Certificate: "-----BEGIN%20CERTIFICATE-----",
+			},
+		},
+	}
+	tests := []struct {
+		name       string
+		expression string
+		request    *authv3.CheckRequest
+		want       bool
+	}{{
+		name:       "mtls principal",
+		expression: `source.principal == "spiffe://cluster.local/ns/default/sa/frontend"`,
+		request:    mtls,
+		want:       true,
+	}, {
+		name:       "mtls certificate",
+		expression: `source.certificate != ""`,
+		request:    mtls,
+		want:       true,
+	}, {
+		name:       "same as the check request",
+		expression: `source.principal == object.attributes.source.principal`,
+		request:    mtls,
+		want:       true,
+	}, {
+		name:       "no principal",
+		expression: `source.principal == "spiffe://cluster.local/ns/default/sa/frontend"`,
+		request: &authv3.CheckRequest{
+			Attributes: &authv3.AttributeContext{
+				Source: &authv3.AttributeContext_Peer{},
+			},
+		},
+		want: false,
+	}, {
+		name:       "empty request",
+		expression: `source.principal == "" && source.certificate == ""`,
+		request:    &authv3.CheckRequest{},
+		want:       true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", tt.expression+` ? envoy.Allowed().Response() : envoy.Denied(403).Response()`)
+			compiled, errs := NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			response, err := compiled.Evaluate(context.Background(), tt.request)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, response.GetStatus().GetCode() == 0)
+		})
+	}
+}
+
+func Test_compiler_Compile_auth(t *testing.T) {
+	payload, err := structpb.NewStruct(map[string]any{
+		"jwt_payload": map[string]any{
+			"iss":    "https://issuer.example.com",
+			"sub":    "alice",
+			"groups": []any{"admin", "dev"},
+		},
+	})
+	assert.NoError(t, err)
+	other, err := structpb.NewStruct(map[string]any{"tenant": "acme"})
+	assert.NoError(t, err)
+	authenticated := &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			MetadataContext: &corev3.Metadata{
+				FilterMetadata: map[string]*structpb.Struct{
+					"envoy.filters.http.jwt_authn": payload,
+					"envoy.filters.http.lua":       other,
+				},
+			},
+		},
+	}
+	tests := []struct {
+		name       string
+		expression string
+		request    *authv3.CheckRequest
+		want       bool
+	}{{
+		name:       "jwt subject",
+		expression: `auth.jwt.jwt_payload.sub == "alice"`,
+		request:    authenticated,
+		want:       true,
+	}, {
+		name:       "jwt claims",
+		expression: `"admin" in auth.jwt.jwt_payload.groups && auth.jwt.jwt_payload.iss == "https://issuer.example.com"`,
+		request:    authenticated,
+		want:       true,
+	}, {
+		name:       "only jwt_authn metadata",
+		expression: `!("tenant" in auth.jwt)`,
+		request:    authenticated,
+		want:       true,
+	}, {
+		name:       "same as the check request",
+		expression: `auth.jwt == object.attributes.metadata_context.filter_metadata["envoy.filters.http.jwt_authn"]`,
+		request:    authenticated,
+		want:       true,
+	}, {
+		name:       "no jwt_authn metadata",
+		expression: `size(auth.jwt) == 0 && auth.jwt[?"jwt_payload"].sub.orValue("") == ""`,
+		request: &authv3.CheckRequest{
+			Attributes: &authv3.AttributeContext{
+				MetadataContext: &corev3.Metadata{
+					FilterMetadata: map[string]*structpb.Struct{"envoy.filters.http.lua": other},
+				},
+			},
+		},
+		want: true,
+	}, {
+		name:       "empty request",
+		expression: `size(auth.jwt) == 0`,
+		request:    &authv3.CheckRequest{},
+		want:       true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", tt.expression+` ? envoy.Allowed().Response() : envoy.Denied(403).Response()`)
+			compiled, errs := NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			response, err := compiled.Evaluate(context.Background(), tt.request)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, response.GetStatus().GetCode() == 0)
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/envoy"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// BodyRequiredReason is the reason of the decisions taken for the policies reading a request body envoy didn't
+// forward
+const BodyRequiredReason = "request body required"
+
+type bodyRequiredKey struct{}
+
+// WithBodyRequired returns a context where the policies reading the request body don't evaluate their rules when
+// the body of the request is missing (see BodyMissing), the policies matching the request return the response
+// attributed to them with the BodyRequiredReason instead
+func WithBodyRequired(ctx context.Context, response *authv3.CheckResponse) context.Context {
+	return context.WithValue(ctx, bodyRequiredKey{}, response)
+}
+
+func bodyRequired(ctx context.Context) *authv3.CheckResponse {
+	response, _ := ctx.Value(bodyRequiredKey{}).(*authv3.CheckResponse)
+	return response
+}
+
+// BodyMissing returns true when envoy didn't forward the body of the request entirely, the body was truncated or
+// the request has a body and envoy doesn't buffer it
+func BodyMissing(r *authv3.CheckRequest) bool {
+	http := r.GetAttributes().GetRequest().GetHttp()
+	if bodyTruncated(http) {
+		return true
+	}
+	if http.GetBody() != "" || len(http.GetRawBody()) > 0 {
+		return false
+	}
+	if http.GetSize() > 0 {
+		return true
+	}
+	for _, line := range requestHeaderLines(http) {
+		switch strings.ToLower(line[0]) {
+		case "content-length":
+			if length, err := strconv.ParseInt(line[1], 10, 64); err == nil && length > 0 {
+				return true
+			}
+		case "transfer-encoding":
+			// chunked requests have no content length
+			if line[1] != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bodyTruncated returns true when the request is marked with the header envoy sets on the bodies it truncates, the
+// authz server marks the bodies exceeding its body limit the same way
+func bodyTruncated(http *authv3.AttributeContext_HttpRequest) bool {
+	for _, line := range requestHeaderLines(http) {
+		if strings.ToLower(line[0]) == envoy.PartialBodyHeader {
+			return line[1] == "true"
+		}
+	}
+	return false
+}
+
+// bodyRequiredResponse returns the response of a policy matching a request whose body is missing, the decision is
+// attributed to the policy with the body required reason
+func (a attribution) bodyRequiredResponse(response *authv3.CheckResponse) *authv3.CheckResponse {
+	response = proto.Clone(response).(*authv3.CheckResponse)
+	fields := map[string]*structpb.Value{
+		MetadataPolicyKey: structpb.NewStringValue(a.policy),
+		MetadataReasonKey: structpb.NewStringValue(BodyRequiredReason),
+	}
+	if a.annotations != nil {
+		fields[MetadataAnnotationsKey] = structpb.NewStructValue(a.annotations)
+	}
+	if response.DynamicMetadata == nil {
+		response.DynamicMetadata = &structpb.Struct{}
+	}
+	if response.DynamicMetadata.Fields == nil {
+		response.DynamicMetadata.Fields = map[string]*structpb.Value{}
+	}
+	response.DynamicMetadata.Fields[MetadataKey] = structpb.NewStructValue(&structpb.Struct{Fields: fields})
+	return response
+}
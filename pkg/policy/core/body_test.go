@@ -0,0 +1,150 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/envoy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+)
+
+func TestBodyMissing(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		body    string
+		size    int64
+		want    bool
+	}{{
+		name: "no body",
+		size: -1,
+	}, {
+		name:    "forwarded body",
+		headers: map[string]string{"content-length": "2"},
+		body:    "{}",
+		size:    2,
+	}, {
+		name:    "truncated body",
+		headers: map[string]string{"content-length": "4096", envoy.PartialBodyHeader: "true"},
+		body:    "{",
+		size:    4096,
+		want:    true,
+	}, {
+		name: "body not buffered",
+		size: 2,
+		want: true,
+	}, {
+		name:    "content length",
+		headers: map[string]string{"content-length": "2"},
+		want:    true,
+	}, {
+		name:    "empty body",
+		headers: map[string]string{"content-length": "0"},
+	}, {
+		name:    "chunked body",
+		headers: map[string]string{"transfer-encoding": "chunked"},
+		want:    true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := newHttpRequest("POST", "/api")
+			request.Attributes.Request.Http.Body = tt.body
+			request.Attributes.Request.Http.Size = tt.size
+			for key, value := range tt.headers {
+				request.Attributes.Request.Http.Headers[key] = value
+			}
+			assert.Equal(t, tt.want, BodyMissing(request))
+		})
+	}
+}
+
+func TestBodyMissing_headerMap(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  bool
+	}{{
+		name: "no body",
+	}, {
+		name:  "content length",
+		lines: []string{"content-length", "2"},
+		want:  true,
+	}, {
+		name:  "empty body",
+		lines: []string{"content-length", "0"},
+	}, {
+		name:  "chunked body",
+		lines: []string{"transfer-encoding", "chunked"},
+		want:  true,
+	}, {
+		name:  "truncated body",
+		lines: []string{"content-length", "4096", envoy.PartialBodyHeader, "true"},
+		want:  true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := newRawHeadersRequest(tt.lines...)
+			request.Attributes.Request.Http.Size = -1
+			assert.Equal(t, tt.want, BodyMissing(request))
+		})
+	}
+}
+
+func TestWithBodyRequired(t *testing.T) {
+	required := &authv3.CheckResponse{
+		Status: &status.Status{Code: int32(codes.PermissionDenied)},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: &authv3.DeniedHttpResponse{
+			Status: &typev3.HttpStatus{Code: typev3.StatusCode_PayloadTooLarge},
+		}},
+	}
+	readsBody, errs := NewCompiler().Compile(newPolicy("reads-body", `object.attributes.request.http.body == "{}" ? envoy.Allowed().Response() : envoy.Denied(400).Response()`))
+	require.Empty(t, errs)
+	ignoresBody, errs := NewCompiler().Compile(newPolicy("ignores-body", `envoy.Allowed().Response()`))
+	require.Empty(t, errs)
+	missing := newHttpRequest("POST", "/api")
+	missing.Attributes.Request.Http.Headers["content-length"] = "2"
+	forwarded := newHttpRequest("POST", "/api")
+	forwarded.Attributes.Request.Http.Headers["content-length"] = "2"
+	forwarded.Attributes.Request.Http.Body = "{}"
+	ctx := WithBodyRequired(context.Background(), required)
+	t.Run("body missing", func(t *testing.T) {
+		response, err := readsBody.Evaluate(ctx, missing)
+		require.NoError(t, err)
+		assert.Equal(t, typev3.StatusCode_PayloadTooLarge, response.GetDeniedResponse().GetStatus().GetCode())
+		attribution := response.GetDynamicMetadata().GetFields()[MetadataKey].GetStructValue().AsMap()
+		assert.Equal(t, map[string]any{MetadataPolicyKey: "reads-body", MetadataReasonKey: BodyRequiredReason}, attribution)
+		// the configured response is left untouched
+		assert.Nil(t, required.DynamicMetadata)
+	})
+	t.Run("body forwarded", func(t *testing.T) {
+		response, err := readsBody.Evaluate(ctx, forwarded)
+		require.NoError(t, err)
+		assert.Equal(t, int32(codes.OK), response.GetStatus().GetCode())
+	})
+	t.Run("policy ignoring the body", func(t *testing.T) {
+		response, err := ignoresBody.Evaluate(ctx, missing)
+		require.NoError(t, err)
+		assert.Equal(t, int32(codes.OK), response.GetStatus().GetCode())
+	})
+	t.Run("unmatched request", func(t *testing.T) {
+		policy := newPolicy("reads-body", `object.attributes.request.http.body == "{}" ? envoy.Allowed().Response() : null`)
+		policy.Spec.MatchConditions = []admissionregistrationv1.MatchCondition{{Name: "put", Expression: `object.attributes.request.http.method == "PUT"`}}
+		compiled, errs := NewCompiler().Compile(policy)
+		require.Empty(t, errs)
+		response, err := compiled.Evaluate(ctx, missing)
+		require.NoError(t, err)
+		assert.Nil(t, response)
+	})
+	t.Run("not handled by the server", func(t *testing.T) {
+		// the rules are evaluated against the forwarded body
+		response, err := readsBody.Evaluate(context.Background(), missing)
+		require.NoError(t, err)
+		assert.Equal(t, typev3.StatusCode_BadRequest, response.GetDeniedResponse().GetStatus().GetCode())
+	})
+}
@@ -0,0 +1,30 @@
+package core
+
+import (
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// BreakGlassReason is the reason of the decisions taken by the break glass of a policy
+const BreakGlassReason = "break glass"
+
+// breakGlassResponse returns the response allowing a request matched by a policy whose break glass is active,
+// the decision is attributed to the policy with the break glass reason
+func (a attribution) breakGlassResponse() *authv3.CheckResponse {
+	fields := map[string]*structpb.Value{
+		MetadataPolicyKey: structpb.NewStringValue(a.policy),
+		MetadataReasonKey: structpb.NewStringValue(BreakGlassReason),
+	}
+	if a.annotations != nil {
+		fields[MetadataAnnotationsKey] = structpb.NewStructValue(a.annotations)
+	}
+	return &authv3.CheckResponse{
+		Status:       &status.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: &authv3.OkHttpResponse{}},
+		DynamicMetadata: &structpb.Struct{Fields: map[string]*structpb.Value{
+			MetadataKey: structpb.NewStructValue(&structpb.Struct{Fields: fields}),
+		}},
+	}
+}
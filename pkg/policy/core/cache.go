@@ -0,0 +1,163 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/types"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// DecisionCache describes how the decisions of a compiled policy are cached, every compilation returns
+// a new DecisionCache so that decisions cached for a previous version of the policy are never reused.
+// A policy calling volatile functions or reading the now variable only gets a DecisionCache when its key
+// calls the same functions and reads now too.
+type DecisionCache struct {
+	// Key computes the cache key of a request, requests with the same key get the same decision
+	Key func(context.Context, *authv3.CheckRequest) (string, error)
+	// TTL is the duration a decision stays cached
+	TTL time.Duration
+}
+
+// volatileFunctions are the functions whose result depends on external or time varying state, mapped to
+// the minimum number of arguments making a call volatile: decoding a token without a key doesn't check
+// its expiration
+var volatileFunctions = map[string]int{
+	"http.Get":   0,
+	"http.Post":  0,
+	"k8s.Get":    0,
+	"jwt.Decode": 2,
+	"jwt.Verify": 0,
+}
+
+// volatilityAnalyzer records the volatile functions called by the expressions compiled in an environment.
+// It is registered as a validator to see every checked expression, it never reports issues and
+// the environment must be used to compile a single policy.
+type volatilityAnalyzer struct {
+	calls sets.Set[string]
+	// identifiers are the variables computed with volatile functions, mapped to these functions: reading the
+	// variable is calling them
+	identifiers map[string]sets.Set[string]
+}
+
+// volatileVariables are the variables whose value changes over time for the same request, reading them is
+// recorded as a volatile call named after the variable
+var volatileVariables = sets.New(NowKey)
+
+func newVolatilityAnalyzer() *volatilityAnalyzer {
+	identifiers := map[string]sets.Set[string]{}
+	for variable := range volatileVariables {
+		identifiers[variable] = sets.New(variable)
+	}
+	return &volatilityAnalyzer{calls: sets.New[string](), identifiers: identifiers}
+}
+
+func (a *volatilityAnalyzer) Name() string {
+	return "kyverno.volatility"
+}
+
+func (a *volatilityAnalyzer) Validate(_ *cel.Env, _ cel.ValidatorConfig, checked *ast.AST, _ *cel.Issues) {
+	for _, expr := range ast.MatchDescendants(ast.NavigateAST(checked), ast.KindMatcher(ast.CallKind)) {
+		call := expr.AsCall()
+		if args, ok := volatileFunctions[call.FunctionName()]; ok && len(call.Args()) >= args {
+			a.calls.Insert(call.FunctionName())
+		}
+	}
+	for _, expr := range ast.MatchDescendants(ast.NavigateAST(checked), ast.KindMatcher(ast.IdentKind)) {
+		if calls, ok := a.identifiers[expr.AsIdent()]; ok {
+			a.calls = a.calls.Union(calls)
+		}
+	}
+}
+
+// reset returns the calls recorded so far and starts recording from scratch
+func (a *volatilityAnalyzer) reset() sets.Set[string] {
+	calls := a.calls
+	a.calls = sets.New[string]()
+	return calls
+}
+
+type cacheKey struct {
+	key cel.Program
+	ttl time.Duration
+}
+
+// compileCacheKey compiles the cache key, it must be called after every other expression of the policy
+// so that the analyzer can tell the volatile functions called by the policy from the ones called by the key
+func compileCacheKey(env *compileEnv, programOptions []cel.ProgramOption, path *field.Path, volatility *volatilityAnalyzer, cache *hub.DecisionCache) (*cacheKey, field.ErrorList) {
+	if cache == nil {
+		return nil, nil
+	}
+	if cache.TTL.Duration <= 0 {
+		return nil, field.ErrorList{field.Invalid(path.Child("ttl"), cache.TTL.Duration.String(), "ttl must be positive")}
+	}
+	policyCalls := volatility.reset()
+	ast, errs := compileExpression(env, path.Child("key"), cache.Key)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if err := outputTypeError(ast, path.Child("key"), cache.Key, "cache key", types.StringType); err != nil {
+		return nil, field.ErrorList{err}
+	}
+	// a decision depending on external or time varying state can only be cached if the key depends on it too
+	uncaptured := policyCalls.Difference(volatility.calls)
+	if functions := uncaptured.Difference(volatileVariables); functions.Len() > 0 {
+		return nil, field.ErrorList{field.Invalid(path.Child("key"), cache.Key, fmt.Sprintf("the policy calls %s, the key must call the same functions for decisions to be cached", strings.Join(sets.List(functions), ", ")))}
+	}
+	if variables := uncaptured.Intersection(volatileVariables); variables.Len() > 0 {
+		return nil, field.ErrorList{field.Invalid(path.Child("key"), cache.Key, fmt.Sprintf("the policy reads %s, the key must read the same variables for decisions to be cached", strings.Join(sets.List(variables), ", ")))}
+	}
+	prog, err := env.Program(ast, programOptions...)
+	if err != nil {
+		return nil, field.ErrorList{field.Invalid(path.Child("key"), cache.Key, err.Error())}
+	}
+	return &cacheKey{key: prog, ttl: cache.TTL.Duration}, nil
+}
+
+// eval evaluates the cache key against the policy data
+func (k *cacheKey) eval(ctx context.Context, data map[string]any) (string, error) {
+	out, details, err := k.key.ContextEval(ctx, data)
+	recordCost(ctx, details)
+	if err != nil {
+		return "", err
+	}
+	return utils.ConvertToNative[string](out)
+}
+
+type failureKey struct{}
+
+// EvaluationFailure records whether a policy evaluated with a context failed, including the failures
+// ignored by the failure policy
+type EvaluationFailure struct {
+	failed atomic.Bool
+}
+
+// WithEvaluationFailure returns a context recording the failures of the policies evaluated with it
+func WithEvaluationFailure(ctx context.Context) (context.Context, *EvaluationFailure) {
+	failure := &EvaluationFailure{}
+	return context.WithValue(ctx, failureKey{}, failure), failure
+}
+
+// Failed returns true when an evaluation failed, a nil EvaluationFailure returns false
+func (f *EvaluationFailure) Failed() bool {
+	if f == nil {
+		return false
+	}
+	return f.failed.Load()
+}
+
+// recordFailure marks the context failure, if any
+func recordFailure(ctx context.Context) {
+	if failure, ok := ctx.Value(failureKey{}).(*EvaluationFailure); ok {
+		failure.failed.Store(true)
+	}
+}
@@ -0,0 +1,162 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/stretchr/testify/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_compiler_Compile_cache(t *testing.T) {
+	const verify = `jwt.Verify(object.attributes.request.http.headers["authorization"], "secret").Valid ? envoy.Allowed().Response() : null`
+	tests := []struct {
+		name       string
+		expression string
+		variables  []admissionregistrationv1.Variable
+		key        string
+		ttl        time.Duration
+		wantErr    string
+	}{{
+		name:       "key",
+		expression: `envoy.Allowed().Response()`,
+		key:        `object.attributes.request.http.method`,
+		ttl:        time.Minute,
+	}, {
+		name:       "key with variables",
+		expression: `envoy.Allowed().Response()`,
+		key:        `variables.method`,
+		ttl:        time.Minute,
+	}, {
+		name:       "not a string",
+		expression: `envoy.Allowed().Response()`,
+		key:        `size(object.attributes.request.http.method)`,
+		ttl:        time.Minute,
+		wantErr:    "cache key output is expected to be of type string",
+	}, {
+		name:       "ttl not positive",
+		expression: `envoy.Allowed().Response()`,
+		key:        `object.attributes.request.http.method`,
+		wantErr:    "spec.cache.ttl: Invalid value: \"0s\": ttl must be positive",
+	}, {
+		name:       "volatile function not captured",
+		expression: verify,
+		key:        `object.attributes.request.http.method`,
+		ttl:        time.Minute,
+		wantErr:    "the policy calls jwt.Verify, the key must call the same functions for decisions to be cached",
+	}, {
+		name:       "volatile function captured",
+		expression: verify,
+		key:        `string(jwt.Verify(object.attributes.request.http.headers["authorization"], "secret").Valid)`,
+		ttl:        time.Minute,
+	}, {
+		name:       "now not captured",
+		expression: `now.getHours() < 18 ? envoy.Allowed().Response() : null`,
+		key:        `object.attributes.request.http.method`,
+		ttl:        time.Minute,
+		wantErr:    "the policy reads now, the key must read the same variables for decisions to be cached",
+	}, {
+		name:       "now captured",
+		expression: `now.getHours() < 18 ? envoy.Allowed().Response() : null`,
+		key:        `string(now.getHours())`,
+		ttl:        time.Minute,
+	}, {
+		name:       "now read by a variable",
+		expression: `!variables.late ? envoy.Allowed().Response() : null`,
+		variables:  []admissionregistrationv1.Variable{{Name: "late", Expression: `now.getHours() >= 18`}},
+		key:        `object.attributes.request.http.method`,
+		ttl:        time.Minute,
+		wantErr:    "the policy reads now",
+	}, {
+		name:       "unverified decode is not volatile",
+		expression: `jwt.Decode(object.attributes.request.http.headers["authorization"]).Claims.sub == "alice" ? envoy.Allowed().Response() : null`,
+		key:        `object.attributes.request.http.headers["authorization"]`,
+		ttl:        time.Minute,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", tt.expression)
+			policy.Spec.Variables = append([]admissionregistrationv1.Variable{{Name: "method", Expression: `object.attributes.request.http.method`}}, tt.variables...)
+			policy.Spec.Cache = &hub.DecisionCache{Key: tt.key, TTL: metav1.Duration{Duration: tt.ttl}}
+			compiled, errs := NewCompiler().Compile(policy)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, errs.ToAggregate(), tt.wantErr)
+				return
+			}
+			assert.Empty(t, errs)
+			assert.NotNil(t, compiled.Cache)
+			assert.Equal(t, tt.ttl, compiled.Cache.TTL)
+		})
+	}
+}
+
+func Test_compiler_Compile_cacheKey(t *testing.T) {
+	request := func(method string) *authv3.CheckRequest {
+		return &authv3.CheckRequest{
+			Attributes: &authv3.AttributeContext{
+				Request: &authv3.AttributeContext_Request{
+					Http: &authv3.AttributeContext_HttpRequest{
+						Method:  method,
+						Headers: map[string]string{"x-tenant": "acme"},
+					},
+				},
+			},
+		}
+	}
+	policy := newPolicy("policy", `envoy.Allowed().Response()`)
+	policy.Spec.Variables = []admissionregistrationv1.Variable{{Name: "tenant", Expression: `object.attributes.request.http.headers["x-tenant"]`}}
+	policy.Spec.Cache = &hub.DecisionCache{Key: `variables.tenant + "/" + object.attributes.request.http.method`, TTL: metav1.Duration{Duration: time.Minute}}
+	compiled, errs := NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	key, err := compiled.Cache.Key(context.Background(), request("GET"))
+	assert.NoError(t, err)
+	assert.Equal(t, "acme/GET", key)
+	// a new compilation never shares its cache with the previous one
+	recompiled, errs := NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	assert.NotSame(t, compiled.Cache, recompiled.Cache)
+	// a failing key reports the key path
+	policy.Spec.Cache.Key = `object.attributes.request.http.headers["missing"]`
+	compiled, errs = NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	_, err = compiled.Cache.Key(context.Background(), request("GET"))
+	var evalErr *EvaluationError
+	assert.True(t, errors.As(err, &evalErr))
+	assert.Equal(t, "spec.cache.key", evalErr.Field)
+}
+
+func TestWithEvaluationFailure(t *testing.T) {
+	const failing = `object.attributes.request.http.headers["missing"] == "foo" ? envoy.Allowed().Response() : null`
+	ignore := admissionregistrationv1.Ignore
+	tests := []struct {
+		name       string
+		expression string
+		want       bool
+	}{{
+		name:       "success",
+		expression: `envoy.Allowed().Response()`,
+	}, {
+		name:       "ignored failure",
+		expression: failing,
+		want:       true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", tt.expression)
+			policy.Spec.FailurePolicy = &ignore
+			compiled, errs := NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			ctx, failure := WithEvaluationFailure(context.Background())
+			_, err := compiled.Evaluate(ctx, &authv3.CheckRequest{})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, failure.Failed())
+		})
+	}
+	var failure *EvaluationFailure
+	assert.False(t, failure.Failed())
+}
@@ -0,0 +1,154 @@
+package core
+
+import (
+	"context"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/envoy"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
+	"google.golang.org/grpc/codes"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// authorization is a compiled authorization rule
+type authorization struct {
+	name string
+	// path is the path of the rule, the fields of its expressions are children of it
+	path *field.Path
+	// match is nil when the rule applies to every request
+	match cel.Program
+	rule  cel.Program
+	// reason is nil when the policy reason applies
+	reason cel.Program
+}
+
+func compileAuthorizations(env *compileEnv, programOptions []cel.ProgramOption, path *field.Path, rules []hub.Authorization) ([]authorization, field.ErrorList) {
+	out := make([]authorization, 0, len(rules))
+	names := map[string]bool{}
+	for i, rule := range rules {
+		path := path.Index(i)
+		// names identify the rule taking the decision, they can't be ambiguous
+		if rule.Name != "" {
+			if names[rule.Name] {
+				return nil, field.ErrorList{field.Duplicate(path.Child("name"), rule.Name)}
+			}
+			names[rule.Name] = true
+		}
+		compiled := authorization{name: rule.Name, path: path}
+		if rule.Match != "" {
+			ast, errs := compileExpression(env, path.Child("match"), rule.Match)
+			if len(errs) > 0 {
+				return nil, errs
+			}
+			if err := outputTypeError(ast, path.Child("match"), rule.Match, "match", types.BoolType); err != nil {
+				return nil, field.ErrorList{err}
+			}
+			prog, err := env.Program(ast, programOptions...)
+			if err != nil {
+				return nil, field.ErrorList{field.Invalid(path.Child("match"), rule.Match, err.Error())}
+			}
+			compiled.match = prog
+		}
+		ast, errs := compileExpression(env, path.Child("expression"), rule.Expression)
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		if err := outputTypeError(ast, path.Child("expression"), rule.Expression, "rule", envoy.CheckResponse); err != nil {
+			err.Detail += ruleOutputHint(ast.OutputType())
+			return nil, field.ErrorList{err}
+		}
+		prog, err := env.Program(ast, programOptions...)
+		if err != nil {
+			return nil, field.ErrorList{field.Invalid(path.Child("expression"), rule.Expression, err.Error())}
+		}
+		compiled.rule = prog
+		if rule.Reason != "" {
+			ast, errs := compileExpression(env, path.Child("reason"), rule.Reason)
+			if len(errs) > 0 {
+				return nil, errs
+			}
+			if err := outputTypeError(ast, path.Child("reason"), rule.Reason, "reason", types.StringType); err != nil {
+				return nil, field.ErrorList{err}
+			}
+			prog, err := env.Program(ast, programOptions...)
+			if err != nil {
+				return nil, field.ErrorList{field.Invalid(path.Child("reason"), rule.Reason, err.Error())}
+			}
+			compiled.reason = prog
+		}
+		out = append(out, compiled)
+	}
+	return out, nil
+}
+
+// evaluate returns the response of the rule, matched is false when its match condition is false
+func (a authorization) evaluate(ctx context.Context, data map[string]any) (response *authv3.CheckResponse, matched bool, err error) {
+	if a.match != nil {
+		out, details, err := a.match.ContextEval(ctx, data)
+		recordCost(ctx, details)
+		if err != nil {
+			return nil, false, &EvaluationError{Field: a.path.Child("match").String(), Err: err}
+		}
+		matched, err := utils.ConvertToNative[bool](out)
+		if err != nil {
+			return nil, false, &EvaluationError{Field: a.path.Child("match").String(), Err: err}
+		}
+		if !matched {
+			return nil, false, nil
+		}
+	}
+	// evaluate the rule
+	out, details, err := a.rule.ContextEval(ctx, data)
+	recordCost(ctx, details)
+	// check error
+	if err != nil {
+		return nil, true, &EvaluationError{Field: a.path.Child("expression").String(), Err: err}
+	}
+	// evaluation result is nil, no decision
+	if _, ok := out.(types.Null); ok {
+		return nil, true, nil
+	}
+	// try to convert to a check response
+	response, err = utils.ConvertToNative[*authv3.CheckResponse](out)
+	// check error
+	if err != nil {
+		return nil, true, &EvaluationError{Field: a.path.Child("expression").String(), Err: err}
+	}
+	return response, true, nil
+}
+
+// combine evaluates the rules and returns the response taking the policy decision with the rule that returned it,
+// the response is nil when the policy takes no decision
+func combine(ctx context.Context, strategy hub.Combine, rules []authorization, data map[string]any) (*authv3.CheckResponse, authorization, error) {
+	var allowed *authv3.CheckResponse
+	var allowedBy authorization
+	// a matching rule taking no decision prevents AllMustAllow from allowing
+	abstained := false
+	for _, rule := range rules {
+		response, matched, err := rule.evaluate(ctx, data)
+		if err != nil {
+			return nil, authorization{}, err
+		}
+		if response == nil {
+			abstained = abstained || matched
+			continue
+		}
+		if strategy == hub.CombineFirstMatch {
+			return response, rule, nil
+		}
+		// the first deny takes the decision, whatever the strategy
+		if response.GetStatus().GetCode() != int32(codes.OK) {
+			return response, rule, nil
+		}
+		if allowed == nil {
+			allowed, allowedBy = response, rule
+		}
+	}
+	if strategy == hub.CombineAllMustAllow && abstained {
+		return nil, authorization{}, nil
+	}
+	return allowed, allowedBy, nil
+}
@@ -0,0 +1,182 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func Test_compiler_Compile_combine(t *testing.T) {
+	request := &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Method:  "DELETE",
+					Headers: map[string]string{"x-team": "foo"},
+				},
+			},
+		},
+	}
+	allow := hub.Authorization{Name: "team", Expression: `object.attributes.request.http.headers[?"x-team"].orValue("") == "foo" ? envoy.Allowed().Response() : null`}
+	deny := hub.Authorization{Name: "read-only", Reason: `"read only"`, Expression: `request.method != "GET" ? envoy.Denied(405).Response() : null`}
+	allowGet := hub.Authorization{Name: "get", Expression: `envoy.Allowed().Response()`}
+	abstain := hub.Authorization{Name: "none", Expression: `request.method == "GET" ? envoy.Allowed().Response() : null`}
+	unmatched := hub.Authorization{Name: "unmatched", Match: `request.method == "GET"`, Expression: `envoy.Denied(403).Response()`}
+	tests := []struct {
+		name       string
+		combine    hub.Combine
+		rules      []hub.Authorization
+		wantCode   codes.Code
+		wantRule   string
+		wantReason string
+		wantNone   bool
+	}{{
+		name:     "first match allows",
+		rules:    []hub.Authorization{allow, deny},
+		wantCode: codes.OK,
+		wantRule: "team",
+	}, {
+		name:       "first match denies",
+		combine:    hub.CombineFirstMatch,
+		rules:      []hub.Authorization{deny, allow},
+		wantCode:   codes.PermissionDenied,
+		wantRule:   "read-only",
+		wantReason: "read only",
+	}, {
+		name:       "any deny",
+		combine:    hub.CombineAnyDeny,
+		rules:      []hub.Authorization{allow, deny},
+		wantCode:   codes.PermissionDenied,
+		wantRule:   "read-only",
+		wantReason: "read only",
+	}, {
+		name:     "any deny allows",
+		combine:  hub.CombineAnyDeny,
+		rules:    []hub.Authorization{abstain, allow, unmatched},
+		wantCode: codes.OK,
+		wantRule: "team",
+	}, {
+		name:       "all must allow",
+		combine:    hub.CombineAllMustAllow,
+		rules:      []hub.Authorization{allow, deny},
+		wantCode:   codes.PermissionDenied,
+		wantRule:   "read-only",
+		wantReason: "read only",
+	}, {
+		name:     "all must allow allows",
+		combine:  hub.CombineAllMustAllow,
+		rules:    []hub.Authorization{unmatched, allow, allowGet},
+		wantCode: codes.OK,
+		wantRule: "team",
+	}, {
+		name:     "all must allow with a rule taking no decision",
+		combine:  hub.CombineAllMustAllow,
+		rules:    []hub.Authorization{allow, abstain},
+		wantNone: true,
+	}, {
+		name:     "no rule matches",
+		combine:  hub.CombineAllMustAllow,
+		rules:    []hub.Authorization{unmatched},
+		wantNone: true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy")
+			policy.Spec.Combine = tt.combine
+			policy.Spec.Authorizations = tt.rules
+			policy.Spec.Reason = `"policy"`
+			compiled, errs := NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			response, err := compiled.Evaluate(context.Background(), request)
+			assert.NoError(t, err)
+			if tt.wantNone {
+				assert.Nil(t, response)
+				return
+			}
+			assert.Equal(t, int32(tt.wantCode), response.GetStatus().GetCode())
+			attribution := response.GetDynamicMetadata().GetFields()[MetadataKey].GetStructValue().AsMap()
+			assert.Equal(t, tt.wantRule, attribution[MetadataRuleKey])
+			// the reason of the policy applies unless the rule has one
+			wantReason := tt.wantReason
+			if wantReason == "" {
+				wantReason = "policy"
+			}
+			assert.Equal(t, wantReason, attribution[MetadataReasonKey])
+		})
+	}
+}
+
+func Test_compiler_Compile_combineErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		rules     []hub.Authorization
+		wantField string
+		wantType  field.ErrorType
+	}{{
+		name:      "duplicate name",
+		rules:     []hub.Authorization{{Name: "a", Expression: `envoy.Allowed().Response()`}, {Name: "a", Expression: `envoy.Allowed().Response()`}},
+		wantField: "spec.authorizations[1].name",
+		wantType:  field.ErrorTypeDuplicate,
+	}, {
+		name:      "match syntax error",
+		rules:     []hub.Authorization{{Match: `request.method ==`, Expression: `envoy.Allowed().Response()`}},
+		wantField: "spec.authorizations[0].match",
+		wantType:  field.ErrorTypeInvalid,
+	}, {
+		name:      "match output type",
+		rules:     []hub.Authorization{{Match: `request.method`, Expression: `envoy.Allowed().Response()`}},
+		wantField: "spec.authorizations[0].match",
+		wantType:  field.ErrorTypeTypeInvalid,
+	}, {
+		name:      "reason output type",
+		rules:     []hub.Authorization{{Reason: `42`, Expression: `envoy.Allowed().Response()`}},
+		wantField: "spec.authorizations[0].reason",
+		wantType:  field.ErrorTypeTypeInvalid,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy")
+			policy.Spec.Authorizations = tt.rules
+			_, errs := NewCompiler().Compile(policy)
+			if assert.Len(t, errs, 1) {
+				assert.Equal(t, tt.wantField, errs[0].Field)
+				assert.Equal(t, tt.wantType, errs[0].Type)
+			}
+		})
+	}
+}
+
+func Test_compiler_Compile_combineEvaluationErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		rule      hub.Authorization
+		wantField string
+	}{{
+		name:      "match",
+		rule:      hub.Authorization{Match: `object.attributes.request.http.headers["missing"] == "foo"`, Expression: `envoy.Allowed().Response()`},
+		wantField: "spec.authorizations[0].match",
+	}, {
+		name:      "reason",
+		rule:      hub.Authorization{Reason: `object.attributes.request.http.headers["missing"]`, Expression: `envoy.Allowed().Response()`},
+		wantField: "spec.authorizations[0].reason",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy")
+			policy.Spec.Authorizations = []hub.Authorization{tt.rule}
+			compiled, errs := NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			_, err := compiled.Evaluate(context.Background(), &authv3.CheckRequest{})
+			var evalErr *EvaluationError
+			if assert.True(t, errors.As(err, &evalErr)) {
+				assert.Equal(t, tt.wantField, evalErr.Field)
+			}
+		})
+	}
+}
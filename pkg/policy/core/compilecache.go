@@ -0,0 +1,112 @@
+package core
+
+import "sync"
+
+// CompileCache shares the compiled expressions of the policies with identical specs, templated policies only differing
+// by their name or metadata are compiled once. Entries are keyed by the policy spec hash and reference counted by
+// policy name: an entry is dropped when the last policy referencing it is released or compiled with another spec.
+// Specs only share an entry when their expressions differ by formatting, any other string differing doesn't.
+// A policy failing to compile keeps referencing its previous spec. The compilers of a ReloadableCompiler also key the
+// entries by library generation, specs compiled with other libraries are not shared, and the entries of sandboxed
+// compilers are never shared with the others.
+//
+// A cache is safe for concurrent use, it must only be used by compilers created with the same options.
+type CompileCache struct {
+	lock sync.Mutex
+	// entries are the compiled specs by hash
+	entries map[string]*compileCacheEntry
+	// policies are the hashes referenced by policy name
+	policies map[string]string
+}
+
+type compileCacheEntry struct {
+	compiled *compiledSpec
+	refs     int
+}
+
+// NewCompileCache returns an empty compile cache
+func NewCompileCache() *CompileCache {
+	return &CompileCache{
+		entries:  map[string]*compileCacheEntry{},
+		policies: map[string]string{},
+	}
+}
+
+// WithCompileCache shares the compiled expressions of the policies with identical specs through the cache, a nil
+// cache compiles every policy
+func WithCompileCache(cache *CompileCache) CompilerOption {
+	return func(o *compilerOptions) {
+		o.cache = cache
+	}
+}
+
+// get returns the compiled spec with the given hash, nil when it is not cached
+func (c *CompileCache) get(hash string) *compiledSpec {
+	if c == nil {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if entry, ok := c.entries[hash]; ok {
+		return entry.compiled
+	}
+	return nil
+}
+
+// acquire references the compiled spec on behalf of a policy and releases the spec it referenced before, it returns
+// the cached spec when a policy with the same spec was compiled concurrently
+func (c *CompileCache) acquire(policy string, compiled *compiledSpec) *compiledSpec {
+	if c == nil {
+		return compiled
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.entries[compiled.cacheID]
+	if !ok {
+		entry = &compileCacheEntry{compiled: compiled}
+		c.entries[compiled.cacheID] = entry
+	}
+	// the policy already references the spec
+	if previous, ok := c.policies[policy]; ok && previous == compiled.cacheID {
+		return entry.compiled
+	}
+	c.release(policy)
+	entry.refs++
+	c.policies[policy] = compiled.cacheID
+	return entry.compiled
+}
+
+// Release drops the reference of a deleted policy, the compiled spec is dropped with its last reference
+func (c *CompileCache) Release(policy string) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.release(policy)
+}
+
+// release must be called with the lock held
+func (c *CompileCache) release(policy string) {
+	hash, ok := c.policies[policy]
+	if !ok {
+		return
+	}
+	delete(c.policies, policy)
+	if entry := c.entries[hash]; entry != nil {
+		entry.refs--
+		if entry.refs <= 0 {
+			delete(c.entries, hash)
+		}
+	}
+}
+
+// Len returns the number of compiled specs in the cache
+func (c *CompileCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.entries)
+}
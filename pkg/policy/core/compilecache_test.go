@@ -0,0 +1,102 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_compiler_Compile_compileCache(t *testing.T) {
+	cache := NewCompileCache()
+	compiler := NewCompiler(WithCompileCache(cache), WithAnnotationPrefixes("owner"))
+	const expression = `envoy.Denied(403).Response()`
+	a := newPolicy("a", expression)
+	a.Annotations = map[string]string{"owner": "team-a"}
+	a.Spec.Reason = `"denied"`
+	// formatting doesn't change the spec hash, the policies share their compiled expressions
+	b := newPolicy("b", "envoy.Denied(403)\n  .Response()")
+	b.Annotations = map[string]string{"owner": "team-b"}
+	b.Spec.Reason = `'denied'`
+	compiledA, errs := compiler.Compile(a)
+	require.Empty(t, errs)
+	shared := cache.entries[compiledA.Hash].compiled
+	compiledB, errs := compiler.Compile(b)
+	require.Empty(t, errs)
+	assert.Equal(t, 1, cache.Len())
+	if assert.Contains(t, cache.entries, compiledA.Hash) {
+		assert.Same(t, shared, cache.entries[compiledA.Hash].compiled)
+		assert.Equal(t, 2, cache.entries[compiledA.Hash].refs)
+	}
+	// the decisions are attributed to their own policy
+	for name, compiled := range map[string]CompiledPolicy{"a": compiledA, "b": compiledB} {
+		assert.Equal(t, name, compiled.Name)
+		assert.Equal(t, map[string]string{"owner": "team-" + name}, compiled.Annotations)
+		response, err := compiled.Evaluate(context.Background(), newHttpRequest("GET", "/"))
+		require.NoError(t, err)
+		attribution := response.GetDynamicMetadata().GetFields()[MetadataKey].GetStructValue().GetFields()
+		assert.Equal(t, name, attribution[MetadataPolicyKey].GetStringValue())
+		assert.Equal(t, "team-"+name, attribution[MetadataAnnotationsKey].GetStructValue().GetFields()["owner"].GetStringValue())
+		assert.Equal(t, "denied", attribution[MetadataReasonKey].GetStringValue())
+	}
+	// compiling a policy again doesn't reference the spec twice
+	_, errs = compiler.Compile(a)
+	require.Empty(t, errs)
+	assert.Equal(t, 2, cache.entries[compiledA.Hash].refs)
+	// the spec is dropped once the last policy referencing it is released
+	cache.Release("a")
+	assert.Equal(t, 1, cache.Len())
+	cache.Release("b")
+	assert.Equal(t, 0, cache.Len())
+	cache.Release("b")
+	assert.Equal(t, 0, cache.Len())
+}
+
+func Test_compiler_Compile_compileCacheLiterals(t *testing.T) {
+	cache := NewCompileCache()
+	compiler := NewCompiler(WithCompileCache(cache))
+	// the rule names parse as the same CEL expression but they are not expressions, the specs are not shared
+	a := newPolicy("a", `envoy.Denied(403).Response()`)
+	a.Spec.Authorizations[0].Name = "a  +b"
+	b := newPolicy("b", `envoy.Denied(403).Response()`)
+	b.Spec.Authorizations[0].Name = "a+b"
+	compiledA, errs := compiler.Compile(a)
+	require.Empty(t, errs)
+	compiledB, errs := compiler.Compile(b)
+	require.Empty(t, errs)
+	assert.NotEqual(t, compiledA.Hash, compiledB.Hash)
+	assert.Equal(t, 2, cache.Len())
+	// every policy attributes its decisions to its own rule
+	for rule, compiled := range map[string]CompiledPolicy{"a  +b": compiledA, "a+b": compiledB} {
+		response, err := compiled.Evaluate(context.Background(), newHttpRequest("GET", "/"))
+		require.NoError(t, err)
+		attribution := response.GetDynamicMetadata().GetFields()[MetadataKey].GetStructValue().GetFields()
+		assert.Equal(t, rule, attribution[MetadataRuleKey].GetStringValue())
+	}
+}
+
+func Test_compiler_Compile_compileCacheSpecChange(t *testing.T) {
+	cache := NewCompileCache()
+	compiler := NewCompiler(WithCompileCache(cache))
+	policy := newPolicy("policy", `envoy.Allowed().Response()`)
+	before, errs := compiler.Compile(policy)
+	require.Empty(t, errs)
+	// the policy releases its previous spec
+	policy.Spec.Priority = 10
+	after, errs := compiler.Compile(policy)
+	require.Empty(t, errs)
+	assert.NotEqual(t, before.Hash, after.Hash)
+	assert.Equal(t, 1, cache.Len())
+	assert.Contains(t, cache.entries, after.Hash)
+	// a policy failing to compile keeps referencing its previous spec
+	policy.Spec.Authorizations = []hub.Authorization{{Expression: `envoy.Allowed(`}}
+	_, errs = compiler.Compile(policy)
+	assert.NotEmpty(t, errs)
+	assert.Equal(t, 1, cache.Len())
+	// a nil cache compiles every policy
+	var nilCache *CompileCache
+	assert.Equal(t, 0, nilCache.Len())
+	nilCache.Release("policy")
+}
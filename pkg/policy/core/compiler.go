@@ -0,0 +1,601 @@
+package core
+
+import (
+	"context"
+	"fmt"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	engine "github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apiserver/pkg/cel/lazy"
+	"k8s.io/utils/clock"
+)
+
+const (
+	VariablesKey   = "variables"
+	ObjectKey      = "object"
+	ContextKey     = "context"
+	SourceKey      = "source"
+	AuthKey        = "auth"
+	DestinationKey = "destination"
+	RequestKey     = "request"
+	ConnectionKey  = "connection"
+	DataKey        = "data"
+	IdentityKey    = "identity"
+	ResponseKey    = "response"
+	// FilterMetadataKey is the filter metadata variable, not to be confused with the MetadataKey of the decisions
+	FilterMetadataKey = "metadata"
+	// InputKey is the check request under the name OPA-Envoy policies read it from, it is the same value as ObjectKey
+	InputKey = "input"
+	// NowKey is the time the check is evaluated at
+	NowKey = "now"
+)
+
+// PolicyFunc evaluates a policy against a check request, whatever the language the policy is written in.
+//
+// The evaluation contract is the following:
+//   - a nil response and a nil error means the policy didn't take a decision, evaluation continues with the next policy
+//   - a response with an OK status allows the request, any other status denies it. An allow response can carry
+//     mutations in its OkHttpResponse, the headers and query parameters envoy adds to or removes from the request
+//     sent upstream and the headers it adds to the response, the request is then allowed with mutations (see
+//     ResponseDecision). Mutations are only applied when the request is allowed.
+//   - an error means the evaluation failed and denies the request, the failure policy is applied by the
+//     compiler: a policy ignoring failures returns a nil response and a nil error instead. The CEL compiler
+//     returns an *EvaluationError carrying the path of the failing expression.
+//   - the evaluation must stop when the context is done, the deadline is set by the server policy timeout and
+//     the context is cancelled when envoy cancels the check, the CEL compiler interrupts comprehensions and calls
+//     to external services
+//   - the function is called concurrently and must not modify the request, unless the policy is Sequential
+//     it can be evaluated concurrently with other policies
+type PolicyFunc func(context.Context, *authv3.CheckRequest) (*authv3.CheckResponse, error)
+
+// CompiledPolicy is the result of compiling an AuthorizationPolicy
+type CompiledPolicy struct {
+	// Name is the name of the source policy
+	Name string
+	// Namespace is the namespace the source policy belongs to, policies are cluster scoped and providers may set it
+	// from a label of the policy. Empty when the policy belongs to no namespace.
+	Namespace string
+	// Priority is the priority of the source policy
+	Priority int32
+	// Mode is the enforcement mode of the source policy
+	Mode hub.EnforcementMode
+	// Sequential is true when the policy can't be evaluated concurrently with other policies
+	Sequential bool
+	// Override is true when the policy response wins over the responses of other policies, including denies
+	Override bool
+	// Disabled is true when the policy must not be evaluated, providers serve the policies that are not disabled
+	Disabled bool
+	// Activation tells when the policy is evaluated, servers skip the policy outside of it. Nil when the policy
+	// is always evaluated.
+	Activation *Activation
+	// RequestHeaders are the request headers read by the policy expressions
+	RequestHeaders HeaderUsage
+	// ReadsBody is true when the policy expressions may read the request body, servers can tell the policy apart
+	// from policies evaluated without the body
+	ReadsBody bool
+	// EstimatedCost is the worst case CEL cost of evaluating every expression of the policy once
+	EstimatedCost uint64
+	// Hash identifies the behavior of the policy, it only depends on the source policy spec and doesn't change
+	// with its metadata or with the formatting of its expressions
+	Hash string
+	// Cache describes how the policy decisions are cached, nil when they are not
+	Cache *DecisionCache
+	// Annotations are the annotations of the source policy with an allowlisted prefix, they are added to the
+	// attribution of the policy decisions
+	Annotations map[string]string
+	// Evaluate evaluates the policy against a check request
+	Evaluate PolicyFunc
+	// BreakGlass allows the requests matched by the policy scope and conditions without evaluating its rules,
+	// providers evaluate it instead of Evaluate while the break glass of the policy is active. It obeys the
+	// failure policy like Evaluate does, nil when the compiler doesn't support break glass.
+	BreakGlass PolicyFunc
+}
+
+// Compiler turns an AuthorizationPolicy into a CompiledPolicy, the CEL compiler returned by NewCompiler is one
+// implementation, providers accept any implementation and only rely on the PolicyFunc contract.
+type Compiler interface {
+	// Compile compiles the policy, errors are reported with the path of the invalid field and a policy failing
+	// to compile is never evaluated.
+	Compile(*hub.AuthorizationPolicy) (CompiledPolicy, field.ErrorList)
+}
+
+type compilerOptions struct {
+	maxCost            uint64
+	libraries          []cel.EnvOption
+	annotationPrefixes []string
+	identitySources    []IdentitySource
+	scopeKey           string
+	cache              *CompileCache
+	// generation is the library generation of the compilers of a ReloadableCompiler, zero for other compilers
+	generation uint64
+	sandbox    bool
+	clock      clock.PassiveClock
+}
+
+type CompilerOption func(*compilerOptions)
+
+// WithMaxCost sets the maximum runtime cost of every CEL program, evaluating a program that
+// exceeds the limit fails and the policy obeys its failure policy. Zero means no limit.
+func WithMaxCost(maxCost uint64) CompilerOption {
+	return func(o *compilerOptions) {
+		o.maxCost = maxCost
+	}
+}
+
+// WithLibraries registers additional CEL libraries, functions or types available to policy expressions.
+// Libraries are registered in order after the built-in ones, a declaration colliding with an existing
+// overload or variable makes every policy compilation fail.
+func WithLibraries(libraries ...cel.EnvOption) CompilerOption {
+	return func(o *compilerOptions) {
+		o.libraries = append(o.libraries, libraries...)
+	}
+}
+
+// WithAnnotationPrefixes adds the annotations of a policy starting with one of the prefixes to the attribution
+// of its decisions, owner or ticket annotations for example. Other annotations are not exposed.
+func WithAnnotationPrefixes(prefixes ...string) CompilerOption {
+	return func(o *compilerOptions) {
+		o.annotationPrefixes = append(o.annotationPrefixes, prefixes...)
+	}
+}
+
+// WithClock sets the clock the now variable is read from, the real clock by default. Tests inject a fake clock
+// to compare request times deterministically.
+func WithClock(clock clock.PassiveClock) CompilerOption {
+	return func(o *compilerOptions) {
+		o.clock = clock
+	}
+}
+
+// NewCompiler returns the compiler evaluating policies expressions with CEL
+func NewCompiler(opts ...CompilerOption) Compiler {
+	options := compilerOptions{clock: clock.RealClock{}}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return &compiler{
+		options: options,
+	}
+}
+
+type compiler struct {
+	options  compilerOptions
+	identity identityChainOnce
+}
+
+// interruptCheckFrequency is the number of comprehension iterations between two checks of the context,
+// the counter is not shared by nested comprehensions so we check on every iteration
+const interruptCheckFrequency = 1
+
+func (c *compiler) programOptions() []cel.ProgramOption {
+	// let comprehensions be interrupted when the evaluation context is done, track the actual cost of evaluations
+	options := []cel.ProgramOption{cel.InterruptCheckFrequency(interruptCheckFrequency), cel.EvalOptions(cel.OptTrackCost)}
+	// the program options of the libraries come first, the guard wraps the calls they decorate
+	options = append(options, cel.CustomDecorator(guardExternalCalls))
+	if c.options.maxCost != 0 {
+		options = append(options, cel.CostLimit(c.options.maxCost))
+	}
+	return options
+}
+
+func (c *compiler) Compile(policy *hub.AuthorizationPolicy) (CompiledPolicy, field.ErrorList) {
+	base, err := engine.NewEnv(c.options.libraries...)
+	if err != nil {
+		return CompiledPolicy{}, field.ErrorList{field.InternalError(nil, err)}
+	}
+	hash, err := specHash(base, policy.Spec)
+	if err != nil {
+		return CompiledPolicy{}, field.ErrorList{field.InternalError(field.NewPath("spec"), err)}
+	}
+	// policies with identical specs share their compiled expressions, as long as they are compiled with the same
+	// libraries
+	cacheID := hash
+	if c.options.generation != 0 {
+		cacheID = fmt.Sprintf("%d/%s", c.options.generation, hash)
+	}
+	// a spec compiled by a compiler without sandbox may read external data
+	if c.options.sandbox {
+		cacheID = "sandbox/" + cacheID
+	}
+	compiled := c.options.cache.get(cacheID)
+	if compiled == nil {
+		var errs field.ErrorList
+		if compiled, errs = c.compileSpec(base, policy.Spec); len(errs) > 0 {
+			return CompiledPolicy{}, errs
+		}
+		compiled.hash, compiled.cacheID = hash, cacheID
+	}
+	compiled = c.options.cache.acquire(policy.Name, compiled)
+	out := compiled.bind(policy.Name, filterAnnotations(policy.Annotations, c.options.annotationPrefixes))
+	// the shared spec can be the one of a policy disabled or not, it is not hashed
+	out.Disabled = policy.Spec.Disabled
+	return out, nil
+}
+
+// compiledSpec holds the compiled expressions of a policy spec, it doesn't depend on the policy metadata and is
+// shared by the policies with identical specs
+type compiledSpec struct {
+	spec              hub.AuthorizationPolicySpec
+	hash              string
+	cacheID           string
+	identity          *identityChain
+	scope             *scope
+	targetConditions  []cel.Program
+	matchConditions   []cel.Program
+	excludeConditions []cel.Program
+	variables         map[string]cel.Program
+	authorizations    []authorization
+	headers           compiledHeaders
+	deny              denyResponse
+	attribution       attribution
+	rollout           *rollout
+	rateLimit         *rateLimit
+	activation        *Activation
+	cacheKey          *cacheKey
+	requestHeaders    HeaderUsage
+	readsBody         bool
+	estimatedCost     uint64
+	clock             clock.PassiveClock
+}
+
+func (c *compiler) compileSpec(base *cel.Env, spec hub.AuthorizationPolicySpec) (*compiledSpec, field.ErrorList) {
+	var allErrs field.ErrorList
+	identity, errs := c.identity.get(c)
+	if len(errs) > 0 {
+		return nil, append(allErrs, errs...)
+	}
+	provider := engine.NewVariablesProvider(base.CELTypeProvider())
+	analyzer := newHeaderAnalyzer()
+	costs := &costAnalyzer{}
+	volatility := newVolatilityAnalyzer()
+	// the identity is resolved by the identity sources, reading it calls their volatile functions
+	volatility.identifiers[IdentityKey] = identity.volatile
+	env, err := newCompileEnv(base, variableOptions,
+		cel.CustomTypeProvider(newInputTypeProvider(provider)),
+		cel.ASTValidators(c.validators(analyzer, costs, volatility)...),
+	)
+	if err != nil {
+		return nil, append(allErrs, field.InternalError(nil, err))
+	}
+	programOptions := c.programOptions()
+	path := field.NewPath("spec")
+	scope, errs := compileScope(path.Child("scope"), c.options.scopeKey, spec.Scope)
+	if len(errs) > 0 {
+		return nil, append(allErrs, errs...)
+	}
+	targetConditions, errs := compileConditions(env, programOptions, path.Child("targetConditions"), "targetCondition", spec.TargetConditions)
+	if len(errs) > 0 {
+		return nil, append(allErrs, errs...)
+	}
+	matchConditions, errs := compileConditions(env, programOptions, path.Child("matchConditions"), "matchCondition", spec.MatchConditions)
+	if len(errs) > 0 {
+		return nil, append(allErrs, errs...)
+	}
+	excludeConditions, errs := compileConditions(env, programOptions, path.Child("excludeConditions"), "excludeCondition", spec.ExcludeConditions)
+	if len(errs) > 0 {
+		return nil, append(allErrs, errs...)
+	}
+	if errs := compileDataSources(path.Child("data"), spec.Data); len(errs) > 0 {
+		return nil, append(allErrs, errs...)
+	}
+	variables := map[string]cel.Program{}
+	{
+		path := path.Child("variables")
+		for i, variable := range spec.Variables {
+			path := path.Index(i)
+			// a variable can only be defined once, redefining it would change the type seen by the other expressions
+			if _, ok := variables[variable.Name]; ok {
+				return nil, append(allErrs, field.Duplicate(path.Child("name"), variable.Name))
+			}
+			// variables are registered in order, an expression can only reference the variables
+			// defined before it, this rules out cycles at compile time
+			ast, errs := compileExpression(env, path.Child("expression"), variable.Expression)
+			if len(errs) > 0 {
+				return nil, append(allErrs, errs...)
+			}
+			provider.RegisterField(variable.Name, ast.OutputType())
+			prog, err := env.Program(ast, programOptions...)
+			if err != nil {
+				return nil, append(allErrs, field.Invalid(path.Child("expression"), variable.Expression, err.Error()))
+			}
+			variables[variable.Name] = prog
+		}
+	}
+	authorizations, errs := compileAuthorizations(env, programOptions, path.Child("authorizations"), spec.Authorizations)
+	if len(errs) > 0 {
+		return nil, append(allErrs, errs...)
+	}
+	headers, errs := compileHeaders(env, programOptions, path.Child("headers"), spec.Headers)
+	if len(errs) > 0 {
+		return nil, append(allErrs, errs...)
+	}
+	deny, errs := compileDenyResponse(env, programOptions, path.Child("denyResponse"), spec.DenyResponse)
+	if len(errs) > 0 {
+		return nil, append(allErrs, errs...)
+	}
+	// the gRPC status only applies to grpc calls, told apart by their content type header
+	if deny.grpcStatus != nil || deny.grpcMessage != nil {
+		analyzer.names.Insert(grpcContentTypeHeader)
+	}
+	// the identity is resolved from the headers read by the identity sources
+	if analyzer.identity {
+		analyzer.merge(identity.headers)
+		analyzer.body = analyzer.body || identity.body
+	}
+	attribution, errs := compileAttribution(env, programOptions, path.Child("reason"), spec.Reason)
+	if len(errs) > 0 {
+		return nil, append(allErrs, errs...)
+	}
+	// the rollout key is part of the decision, a cache key must capture its volatile calls
+	rollout, errs := compileRollout(env, programOptions, path.Child("rollout"), spec.Rollout)
+	if len(errs) > 0 {
+		return nil, append(allErrs, errs...)
+	}
+	// the rate limit entries are part of the cached responses, a cache key must capture their volatile calls
+	rateLimit, errs := compileRateLimit(env, programOptions, path.Child("rateLimit"), spec.RateLimit)
+	if len(errs) > 0 {
+		return nil, append(allErrs, errs...)
+	}
+	cacheKey, errs := compileCacheKey(env, programOptions, path.Child("cache"), volatility, spec.Cache)
+	if len(errs) > 0 {
+		return nil, append(allErrs, errs...)
+	}
+	activation, errs := compileActivation(path.Child("activation"), spec.Activation, c.options.clock)
+	if len(errs) > 0 {
+		return nil, append(allErrs, errs...)
+	}
+	return &compiledSpec{
+		spec:              spec,
+		identity:          identity,
+		scope:             scope,
+		targetConditions:  targetConditions,
+		matchConditions:   matchConditions,
+		excludeConditions: excludeConditions,
+		variables:         variables,
+		authorizations:    authorizations,
+		headers:           headers,
+		deny:              deny,
+		attribution:       attribution,
+		rollout:           rollout,
+		rateLimit:         rateLimit,
+		activation:        activation,
+		cacheKey:          cacheKey,
+		requestHeaders:    analyzer.usage(),
+		readsBody:         analyzer.body,
+		estimatedCost:     costs.cost(),
+		clock:             c.options.clock,
+	}, nil
+}
+
+// bind returns the compiled policy evaluating the compiled spec on behalf of a policy, its decisions are attributed
+// to the policy and its rollout is seeded with the policy name
+func (s *compiledSpec) bind(name string, annotations map[string]string) CompiledPolicy {
+	spec := s.spec
+	path := field.NewPath("spec")
+	attribution := s.attribution.withPolicy(name, annotations)
+	rollout := s.rollout.withSeed(name)
+	newData := func(ctx context.Context, r *authv3.CheckRequest) map[string]any {
+		vars := lazy.NewMapValue(engine.VariablesType)
+		data := map[string]any{
+			ObjectKey:         r,
+			InputKey:          r,
+			VariablesKey:      vars,
+			ContextKey:        newContext(r),
+			SourceKey:         newSource(r),
+			AuthKey:           newAuth(r),
+			DestinationKey:    newDestination(r),
+			RequestKey:        newRequest(r),
+			ConnectionKey:     newConnection(r),
+			ResponseKey:       newResponse(r),
+			DataKey:           newData(ctx, spec.Data),
+			FilterMetadataKey: newFilterMetadata(r),
+			// the clock is only read by the expressions reading now
+			NowKey: func() ref.Val { return s.now(ctx) },
+			// functions calling external services stop when the evaluation context is done
+			utils.EvaluationContextKey: ctx,
+		}
+		data[IdentityKey] = newIdentity(ctx, s.identity, data)
+		// variables are evaluated lazily, the first time an expression reads them
+		for name, variable := range s.variables {
+			vars.Append(name, func(*lazy.MapValue) ref.Val {
+				out, details, err := variable.ContextEval(ctx, data)
+				recordCost(ctx, details)
+				if out != nil {
+					return out
+				}
+				if err != nil {
+					return types.WrapErr(err)
+				}
+				return nil
+			})
+		}
+		return data
+	}
+	// match returns the evaluation data of the requests matched by the policy, nil when the request is skipped
+	match := func(ctx context.Context, r *authv3.CheckRequest) (map[string]any, error) {
+		// checks of the other phase, skip
+		if checkPhase(r) != spec.GetPhase() {
+			recordSkipped(ctx, path.Child("phase").String())
+			return nil, nil
+		}
+		// requests from other listeners are out of scope, skip
+		if _, ok := s.scope.match(r); !ok {
+			recordSkipped(ctx, path.Child("scope").String())
+			return nil, nil
+		}
+		data := newData(ctx, r)
+		// if any target condition is false, skip
+		if untargeted, err := evalConditions(ctx, path.Child("targetConditions"), s.targetConditions, data, false); err != nil || untargeted {
+			return nil, err
+		}
+		// if any match condition is false, skip
+		if unmatched, err := evalConditions(ctx, path.Child("matchConditions"), s.matchConditions, data, false); err != nil || unmatched {
+			return nil, err
+		}
+		// if any exclude condition is true, skip
+		if excluded, err := evalConditions(ctx, path.Child("excludeConditions"), s.excludeConditions, data, true); err != nil || excluded {
+			return nil, err
+		}
+		return data, nil
+	}
+	eval := func(ctx context.Context, r *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+		data, err := match(ctx, r)
+		if err != nil || data == nil {
+			return nil, err
+		}
+		// the rules are not evaluated against a missing body when the server handles it
+		if s.readsBody {
+			if response := bodyRequired(ctx); response != nil && BodyMissing(r) {
+				return attribution.bodyRequiredResponse(response), nil
+			}
+		}
+		// requests out of the rollout are not matched
+		sampled, err := rollout.sampled(ctx, data)
+		if err != nil {
+			return nil, &EvaluationError{Field: path.Child("rollout", "key").String(), Err: err}
+		}
+		if !sampled {
+			return nil, nil
+		}
+		// the rules are combined into the policy decision
+		response, rule, err := combine(ctx, spec.GetCombine(), s.authorizations, data)
+		if err != nil || response == nil {
+			return nil, err
+		}
+		// apply header mutations
+		if err := s.headers.apply(ctx, response, data); err != nil {
+			return nil, &EvaluationError{Field: path.Child("headers").String(), Err: err}
+		}
+		// apply the deny response template
+		if err := s.deny.apply(ctx, response, data); err != nil {
+			return nil, &EvaluationError{Field: path.Child("denyResponse").String(), Err: err}
+		}
+		// attribute the decision to the policy and the rule, the reason of the rule replaces the reason of the policy
+		if err := attribution.apply(ctx, response, data, rule.name, rule.reason); err != nil {
+			reasonPath := path.Child("reason")
+			if rule.reason != nil {
+				reasonPath = rule.path.Child("reason")
+			}
+			return nil, &EvaluationError{Field: reasonPath.String(), Err: err}
+		}
+		// add the rate limit entries of allowed requests
+		if err := s.rateLimit.apply(ctx, response, data); err != nil {
+			return nil, &EvaluationError{Field: path.Child("rateLimit", "entries").String(), Err: err}
+		}
+		recordAuthorization(ctx, rule.path.Child("expression").String())
+		return response, nil
+	}
+	var cache *DecisionCache
+	if s.cacheKey != nil {
+		cache = &DecisionCache{
+			Key: func(ctx context.Context, r *authv3.CheckRequest) (string, error) {
+				// the policy skips the checks of the other phase, they bypass the cache
+				if phase := checkPhase(r); phase != spec.GetPhase() {
+					return "", fmt.Errorf("the policy is not evaluated in the %s phase", phase)
+				}
+				key, err := s.cacheKey.eval(ctx, newData(ctx, r))
+				if err != nil {
+					return "", &EvaluationError{Field: path.Child("cache", "key").String(), Err: err}
+				}
+				// the decisions taken in and out of the scope are cached apart
+				if s.scope != nil {
+					value, ok := s.scope.match(r)
+					return fmt.Sprintf("%t:%d:%s:%s", ok, len(value), value, key), nil
+				}
+				return key, nil
+			},
+			TTL: s.cacheKey.ttl,
+		}
+	}
+	return CompiledPolicy{
+		Name:     name,
+		Priority: spec.Priority,
+		Mode:     spec.GetEnforcementMode(),
+		// header mutations depend on the evaluation order
+		Sequential:     spec.Sequential || spec.Headers != nil,
+		Override:       spec.Override,
+		Activation:     s.activation,
+		RequestHeaders: s.requestHeaders,
+		ReadsBody:      s.readsBody,
+		EstimatedCost:  s.estimatedCost,
+		Hash:           s.hash,
+		Cache:          cache,
+		Annotations:    annotations,
+		Evaluate:       withFailurePolicy(spec.GetFailurePolicy(), eval),
+		// the break glass allows the matched requests whatever the rollout and the rules
+		BreakGlass: withFailurePolicy(spec.GetFailurePolicy(), func(ctx context.Context, r *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+			data, err := match(ctx, r)
+			if err != nil || data == nil {
+				return nil, err
+			}
+			return attribution.breakGlassResponse(), nil
+		}),
+	}
+}
+
+// withFailurePolicy returns the policy function applying the failure policy to the errors of eval
+func withFailurePolicy(failurePolicy admissionregistrationv1.FailurePolicyType, eval PolicyFunc) PolicyFunc {
+	return func(ctx context.Context, r *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+		response, err := eval(ctx, r)
+		if err != nil {
+			recordFailure(ctx)
+			recordError(ctx, err)
+			if failurePolicy == admissionregistrationv1.Fail {
+				return nil, err
+			}
+		}
+		return response, nil
+	}
+}
+
+func compileConditions(env *compileEnv, programOptions []cel.ProgramOption, path *field.Path, kind string, conditions []admissionregistrationv1.MatchCondition) ([]cel.Program, field.ErrorList) {
+	programs := make([]cel.Program, 0, len(conditions))
+	for i, condition := range conditions {
+		path := path.Index(i)
+		ast, errs := compileExpression(env, path.Child("expression"), condition.Expression)
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		if err := outputTypeError(ast, path.Child("expression"), condition.Expression, kind, types.BoolType); err != nil {
+			return nil, field.ErrorList{err}
+		}
+		prog, err := env.Program(ast, programOptions...)
+		if err != nil {
+			return nil, field.ErrorList{field.Invalid(path.Child("expression"), condition.Expression, err.Error())}
+		}
+		programs = append(programs, prog)
+	}
+	return programs, nil
+}
+
+// evalConditions returns true as soon as a condition evaluates to want, it returns false otherwise
+func evalConditions(ctx context.Context, path *field.Path, conditions []cel.Program, data map[string]any, want bool) (bool, error) {
+	for i, condition := range conditions {
+		// evaluate the condition
+		out, details, err := condition.ContextEval(ctx, data)
+		recordCost(ctx, details)
+		// check error
+		if err != nil {
+			return false, &EvaluationError{Field: path.Index(i).Child("expression").String(), Err: err}
+		}
+		// try to convert to a bool
+		result, err := utils.ConvertToNative[bool](out)
+		// check error
+		if err != nil {
+			return false, &EvaluationError{Field: path.Index(i).Child("expression").String(), Err: err}
+		}
+		// short circuit
+		if result == want {
+			recordSkipped(ctx, path.Index(i).String())
+			return true, nil
+		}
+	}
+	return false, nil
+}
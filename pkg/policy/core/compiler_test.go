@@ -0,0 +1,442 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	nethttp "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/http"
+	"github.com/stretchr/testify/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func newPolicy(name string, expressions ...string) *hub.AuthorizationPolicy {
+	policy := &hub.AuthorizationPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       name,
+			Generation: 1,
+		},
+	}
+	for _, expression := range expressions {
+		policy.Spec.Authorizations = append(policy.Spec.Authorizations, hub.Authorization{Expression: expression})
+	}
+	return policy
+}
+
+func Test_compiler_Compile_failurePolicy(t *testing.T) {
+	// accessing a missing header fails at runtime
+	const failing = `object.attributes.request.http.headers["missing"] == "foo" ? envoy.Allowed().Response() : null`
+	tests := []struct {
+		name          string
+		failurePolicy *admissionregistrationv1.FailurePolicyType
+		wantErr       bool
+	}{{
+		name:          "default",
+		failurePolicy: nil,
+		wantErr:       true,
+	}, {
+		name:          "fail",
+		failurePolicy: ptr.To(admissionregistrationv1.Fail),
+		wantErr:       true,
+	}, {
+		name:          "ignore",
+		failurePolicy: ptr.To(admissionregistrationv1.Ignore),
+		wantErr:       false,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", failing)
+			policy.Spec.FailurePolicy = tt.failurePolicy
+			compiled, errs := NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			response, err := compiled.Evaluate(context.Background(), &authv3.CheckRequest{
+				Attributes: &authv3.AttributeContext{
+					Request: &authv3.AttributeContext_Request{
+						Http: &authv3.AttributeContext_HttpRequest{},
+					},
+				},
+			})
+			assert.Nil(t, response)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_compiler_Compile_errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *hub.AuthorizationPolicy
+	}{{
+		name:   "syntax error",
+		policy: newPolicy("policy", "envoy.Allowed("),
+	}, {
+		name:   "invalid output type",
+		policy: newPolicy("policy", "envoy.Allowed()"),
+	}, {
+		name: "invalid exclude condition output type",
+		policy: func() *hub.AuthorizationPolicy {
+			policy := newPolicy("policy", "envoy.Allowed().Response()")
+			policy.Spec.ExcludeConditions = []admissionregistrationv1.MatchCondition{{Name: "exclude", Expression: "'flop'"}}
+			return policy
+		}(),
+	}, {
+		name: "invalid target condition output type",
+		policy: func() *hub.AuthorizationPolicy {
+			policy := newPolicy("policy", "envoy.Allowed().Response()")
+			policy.Spec.TargetConditions = []admissionregistrationv1.MatchCondition{{Name: "target", Expression: "destination.principal"}}
+			return policy
+		}(),
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compiled, errs := NewCompiler().Compile(tt.policy)
+			assert.Nil(t, compiled.Evaluate)
+			assert.NotEmpty(t, errs)
+		})
+	}
+}
+
+func Test_compiler_Compile_conditions(t *testing.T) {
+	request := &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Method: "GET",
+					Path:   "/health",
+				},
+			},
+		},
+	}
+	// accessing a missing header fails at runtime
+	const failing = `object.attributes.request.http.headers["missing"] == "foo"`
+	tests := []struct {
+		name              string
+		failurePolicy     *admissionregistrationv1.FailurePolicyType
+		matchConditions   []string
+		excludeConditions []string
+		wantResponse      bool
+		wantErr           bool
+	}{{
+		name:         "no conditions",
+		wantResponse: true,
+	}, {
+		name:            "matched",
+		matchConditions: []string{`object.attributes.request.http.method == "GET"`},
+		wantResponse:    true,
+	}, {
+		name:            "not matched",
+		matchConditions: []string{`object.attributes.request.http.method == "GET"`, `object.attributes.request.http.method == "POST"`},
+	}, {
+		name:              "excluded",
+		excludeConditions: []string{`object.attributes.request.http.path == "/health"`},
+	}, {
+		name:              "not excluded",
+		excludeConditions: []string{`object.attributes.request.http.path == "/admin"`},
+		wantResponse:      true,
+	}, {
+		name:              "not matched short circuits exclude",
+		matchConditions:   []string{`false`},
+		excludeConditions: []string{failing},
+	}, {
+		name:              "exclude error with failure policy fail",
+		excludeConditions: []string{failing},
+		wantErr:           true,
+	}, {
+		name:              "exclude error with failure policy ignore",
+		failurePolicy:     ptr.To(admissionregistrationv1.Ignore),
+		excludeConditions: []string{failing},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", "envoy.Allowed().Response()")
+			policy.Spec.FailurePolicy = tt.failurePolicy
+			for i, expression := range tt.matchConditions {
+				policy.Spec.MatchConditions = append(policy.Spec.MatchConditions, admissionregistrationv1.MatchCondition{Name: fmt.Sprint(i), Expression: expression})
+			}
+			for i, expression := range tt.excludeConditions {
+				policy.Spec.ExcludeConditions = append(policy.Spec.ExcludeConditions, admissionregistrationv1.MatchCondition{Name: fmt.Sprint(i), Expression: expression})
+			}
+			compiled, errs := NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			response, err := compiled.Evaluate(context.Background(), request)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantResponse, response != nil)
+		})
+	}
+}
+
+func Test_compiler_Compile_variables(t *testing.T) {
+	tests := []struct {
+		name      string
+		variables []admissionregistrationv1.Variable
+		wantErr   bool
+	}{{
+		name: "reference earlier variable",
+		variables: []admissionregistrationv1.Variable{
+			{Name: "a", Expression: `"foo"`},
+			{Name: "b", Expression: `variables.a + "bar"`},
+		},
+	}, {
+		name: "reference later variable",
+		variables: []admissionregistrationv1.Variable{
+			{Name: "a", Expression: `variables.b + "foo"`},
+			{Name: "b", Expression: `"bar"`},
+		},
+		wantErr: true,
+	}, {
+		name: "self reference",
+		variables: []admissionregistrationv1.Variable{
+			{Name: "a", Expression: `variables.a`},
+		},
+		wantErr: true,
+	}, {
+		name: "cycle",
+		variables: []admissionregistrationv1.Variable{
+			{Name: "a", Expression: `variables.b`},
+			{Name: "b", Expression: `variables.a`},
+		},
+		wantErr: true,
+	}, {
+		name: "duplicate",
+		variables: []admissionregistrationv1.Variable{
+			{Name: "a", Expression: `"foo"`},
+			{Name: "a", Expression: `"bar"`},
+		},
+		wantErr: true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", `variables.b == "foobar" ? envoy.Allowed().Response() : envoy.Denied(403).Response()`)
+			policy.Spec.Variables = tt.variables
+			compiled, errs := NewCompiler().Compile(policy)
+			if tt.wantErr {
+				assert.NotEmpty(t, errs)
+				return
+			}
+			assert.Empty(t, errs)
+			response, err := compiled.Evaluate(context.Background(), &authv3.CheckRequest{})
+			assert.NoError(t, err)
+			assert.Equal(t, int32(0), response.GetStatus().GetCode())
+		})
+	}
+}
+
+func Test_compiler_Compile_enforcementMode(t *testing.T) {
+	tests := []struct {
+		name string
+		mode hub.EnforcementMode
+		want hub.EnforcementMode
+	}{{
+		name: "default",
+		want: hub.EnforcementModeEnforce,
+	}, {
+		name: "enforce",
+		mode: hub.EnforcementModeEnforce,
+		want: hub.EnforcementModeEnforce,
+	}, {
+		name: "audit",
+		mode: hub.EnforcementModeAudit,
+		want: hub.EnforcementModeAudit,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", "envoy.Allowed().Response()")
+			policy.Spec.EnforcementMode = tt.mode
+			compiled, errs := NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			assert.Equal(t, tt.want, compiled.Mode)
+		})
+	}
+}
+
+// expensive returns a policy expression iterating 10^depth times
+func expensive(depth int) string {
+	expression := "0"
+	for i := depth - 1; i >= 0; i-- {
+		expression = fmt.Sprintf("[0, 1, 2, 3, 4, 5, 6, 7, 8, 9].map(x%d, %s)", i, expression)
+	}
+	return expression + `.size() > 0 ? envoy.Allowed().Response() : null`
+}
+
+func Test_compiler_Compile_budgets(t *testing.T) {
+	fail, ignore := admissionregistrationv1.Fail, admissionregistrationv1.Ignore
+	// an already cancelled context interrupts the evaluation
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name          string
+		options       []CompilerOption
+		ctx           context.Context
+		failurePolicy admissionregistrationv1.FailurePolicyType
+		wantErr       string
+		wantResponse  bool
+	}{{
+		name:          "within budgets",
+		options:       []CompilerOption{WithMaxCost(100000)},
+		ctx:           context.Background(),
+		failurePolicy: fail,
+		wantResponse:  true,
+	}, {
+		name:          "cost exceeded with fail",
+		options:       []CompilerOption{WithMaxCost(100)},
+		ctx:           context.Background(),
+		failurePolicy: fail,
+		wantErr:       "cost limit exceeded",
+	}, {
+		name:          "cost exceeded with ignore",
+		options:       []CompilerOption{WithMaxCost(100)},
+		ctx:           context.Background(),
+		failurePolicy: ignore,
+	}, {
+		name:          "interrupted with fail",
+		ctx:           cancelled,
+		failurePolicy: fail,
+		wantErr:       "interrupted",
+	}, {
+		name:          "interrupted with ignore",
+		ctx:           cancelled,
+		failurePolicy: ignore,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", expensive(3))
+			policy.Spec.FailurePolicy = &tt.failurePolicy
+			compiled, errs := NewCompiler(tt.options...).Compile(policy)
+			assert.Empty(t, errs)
+			response, err := compiled.Evaluate(tt.ctx, &authv3.CheckRequest{})
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantResponse, response != nil)
+		})
+	}
+}
+
+func Test_compiler_Compile_cancelled(t *testing.T) {
+	for _, failurePolicy := range []admissionregistrationv1.FailurePolicyType{admissionregistrationv1.Fail, admissionregistrationv1.Ignore} {
+		t.Run(string(failurePolicy), func(t *testing.T) {
+			// iterates ten million times, much longer than the test waits
+			policy := newPolicy("policy", expensive(7))
+			policy.Spec.FailurePolicy = &failurePolicy
+			compiled, errs := NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			ctx, cancel := context.WithCancel(context.Background())
+			// the check is cancelled while the policy is evaluated
+			time.AfterFunc(20*time.Millisecond, cancel)
+			start := time.Now()
+			response, err := compiled.Evaluate(ctx, &authv3.CheckRequest{})
+			assert.Less(t, time.Since(start), time.Second)
+			assert.Nil(t, response)
+			if failurePolicy == admissionregistrationv1.Fail {
+				assert.ErrorContains(t, err, "interrupted")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_compiler_Compile_libraries(t *testing.T) {
+	greet := cel.Function("org.greet",
+		cel.Overload("org_greet_string", []*cel.Type{cel.StringType}, cel.StringType,
+			cel.UnaryBinding(func(value ref.Val) ref.Val {
+				return types.String("hello " + value.(types.String))
+			}),
+		),
+	)
+	tests := []struct {
+		name      string
+		libraries []cel.EnvOption
+		wantErr   bool
+	}{{
+		name:    "not registered",
+		wantErr: true,
+	}, {
+		name:      "registered",
+		libraries: []cel.EnvOption{greet},
+	}, {
+		name: "collision with built-in",
+		libraries: []cel.EnvOption{greet, cel.Function("strings.quote",
+			cel.Overload("org_quote_string", []*cel.Type{cel.StringType}, cel.StringType,
+				cel.UnaryBinding(func(value ref.Val) ref.Val {
+					return value
+				}),
+			),
+		)},
+		wantErr: true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", `org.greet("world") == "hello world" ? envoy.Allowed().Response() : envoy.Denied(403).Response()`)
+			compiled, errs := NewCompiler(WithLibraries(tt.libraries...)).Compile(policy)
+			if tt.wantErr {
+				assert.NotEmpty(t, errs)
+				return
+			}
+			assert.Empty(t, errs)
+			response, err := compiled.Evaluate(context.Background(), &authv3.CheckRequest{})
+			assert.NoError(t, err)
+			assert.Equal(t, int32(0), response.GetStatus().GetCode())
+		})
+	}
+}
+
+func Test_compiler_Compile_evaluationContext(t *testing.T) {
+	// the service answers once the evaluation context is done
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	libraries := WithLibraries(http.Lib([]string{server.Listener.Addr().String()}, http.WithTimeout(time.Minute)))
+	tests := []struct {
+		name          string
+		failurePolicy admissionregistrationv1.FailurePolicyType
+		wantErr       bool
+	}{{
+		name:          "fail",
+		failurePolicy: admissionregistrationv1.Fail,
+		wantErr:       true,
+	}, {
+		name:          "ignore",
+		failurePolicy: admissionregistrationv1.Ignore,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", `http.Get("`+server.URL+`").allowed == true ? envoy.Allowed().Response() : null`)
+			policy.Spec.FailurePolicy = &tt.failurePolicy
+			compiled, errs := NewCompiler(libraries).Compile(policy)
+			assert.Empty(t, errs)
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			response, err := compiled.Evaluate(ctx, &authv3.CheckRequest{})
+			assert.Less(t, time.Since(start), 10*time.Second)
+			assert.Nil(t, response)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, context.DeadlineExceeded)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/url"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/google/cel-go/common/types"
+)
+
+// ConnectionType is the type of the connection variable, it holds the downstream connection attributes:
+//   - tls.enabled is true when the connection uses TLS, envoy sent attributes.tls_session or a local or peer identity
+//   - tls.mutual is true when the peer presented a certificate, envoy sent attributes.source.principal or attributes.source.certificate
+//   - tls.sni is the attributes.tls_session.sni
+//   - tls.peer.principal is the attributes.source.principal
+//   - tls.peer.subject, tls.peer.issuer, tls.peer.dnsNames and tls.peer.uris are parsed from attributes.source.certificate
+//
+// Fields envoy didn't populate are absent rather than empty, policies test them with has().
+var ConnectionType = types.NewMapType(types.StringType, types.DynType)
+
+// connectionFields are the fields of the connection variable, optional fields may be absent
+var connectionFields = []mapField{{
+	name:    "tls",
+	celType: types.NewMapType(types.StringType, types.DynType),
+	fields: []mapField{
+		{name: "enabled", celType: types.BoolType},
+		{name: "mutual", celType: types.BoolType},
+		{name: "sni", celType: types.StringType, optional: true},
+		{name: "peer", celType: types.NewMapType(types.StringType, types.DynType), optional: true, fields: []mapField{
+			{name: "principal", celType: types.StringType, optional: true},
+			{name: "subject", celType: types.StringType, optional: true},
+			{name: "issuer", celType: types.StringType, optional: true},
+			{name: "dnsNames", celType: types.NewListType(types.StringType), optional: true},
+			{name: "uris", celType: types.NewListType(types.StringType), optional: true},
+		}},
+	},
+}}
+
+// newConnection returns the connection variable of a check request
+func newConnection(r *authv3.CheckRequest) map[string]any {
+	attributes := r.GetAttributes()
+	source := attributes.GetSource()
+	mutual := source.GetPrincipal() != "" || source.GetCertificate() != ""
+	tls := map[string]any{
+		// envoy only sends the tls session when include_tls_session is set, the local identity is set for any tls connection
+		"enabled": attributes.GetTlsSession() != nil || attributes.GetDestination().GetPrincipal() != "" || mutual,
+		"mutual":  mutual,
+	}
+	if sni := attributes.GetTlsSession().GetSni(); sni != "" {
+		tls["sni"] = sni
+	}
+	if peer := newPeerCertificate(source); len(peer) != 0 {
+		tls["peer"] = peer
+	}
+	return map[string]any{
+		"tls": tls,
+	}
+}
+
+// newPeerCertificate returns the fields describing the peer certificate, the certificate is parsed
+// only when envoy forwards it and fields it can't be parsed from are absent
+func newPeerCertificate(source *authv3.AttributeContext_Peer) map[string]any {
+	peer := map[string]any{}
+	if principal := source.GetPrincipal(); principal != "" {
+		peer["principal"] = principal
+	}
+	cert := parseCertificate(source.GetCertificate())
+	if cert == nil {
+		return peer
+	}
+	peer["subject"] = cert.Subject.String()
+	peer["issuer"] = cert.Issuer.String()
+	if len(cert.DNSNames) != 0 {
+		peer["dnsNames"] = cert.DNSNames
+	}
+	if len(cert.URIs) != 0 {
+		uris := make([]string, 0, len(cert.URIs))
+		for _, uri := range cert.URIs {
+			uris = append(uris, uri.String())
+		}
+		peer["uris"] = uris
+	}
+	return peer
+}
+
+// parseCertificate parses the url encoded pem certificate forwarded by envoy, it returns nil if it can't
+func parseCertificate(encoded string) *x509.Certificate {
+	if encoded == "" {
+		return nil
+	}
+	// a + is a base64 character, the certificate is not decoded as a query
+	decoded, err := url.PathUnescape(encoded)
+	if err != nil {
+		return nil
+	}
+	block, _ := pem.Decode([]byte(decoded))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil
+	}
+	return cert
+}
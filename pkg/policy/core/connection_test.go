@@ -0,0 +1,141 @@
+package core
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+// newPeerCertificatePEM returns the url encoded pem of a client certificate issued by a test ca, as forwarded by envoy
+func newPeerCertificatePEM(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca", Organization: []string{"kyverno"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	spiffe, err := url.Parse("spiffe://cluster.local/ns/default/sa/frontend")
+	assert.NoError(t, err)
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "frontend"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"frontend.default.svc"},
+		URIs:         []*url.URL{spiffe},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, key)
+	assert.NoError(t, err)
+	return url.PathEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+}
+
+func Test_compiler_Compile_connection(t *testing.T) {
+	mtls := &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Source: &authv3.AttributeContext_Peer{
+				Principal:   "spiffe://cluster.local/ns/default/sa/frontend",
+				Certificate: newPeerCertificatePEM(t),
+			},
+			Destination: &authv3.AttributeContext_Peer{
+				Principal: "spiffe://cluster.local/ns/default/sa/backend",
+			},
+			TlsSession: &authv3.AttributeContext_TLSSession{Sni: "backend.example.com"},
+		},
+	}
+	tests := []struct {
+		name       string
+		expression string
+		request    *authv3.CheckRequest
+		want       bool
+	}{{
+		name:       "mtls",
+		expression: `connection.tls.enabled && connection.tls.mutual && connection.tls.sni == "backend.example.com"`,
+		request:    mtls,
+		want:       true,
+	}, {
+		name:       "mtls peer certificate",
+		expression: `connection.tls.peer.subject == "CN=frontend" && connection.tls.peer.issuer == "CN=test-ca,O=kyverno"`,
+		request:    mtls,
+		want:       true,
+	}, {
+		name:       "mtls peer names",
+		expression: `connection.tls.peer.principal == "spiffe://cluster.local/ns/default/sa/frontend" && connection.tls.peer.dnsNames == ["frontend.default.svc"] && connection.tls.peer.uris == ["spiffe://cluster.local/ns/default/sa/frontend"]`,
+		request:    mtls,
+		want:       true,
+	}, {
+		name:       "mtls without forwarded certificate",
+		expression: `connection.tls.mutual && connection.tls.peer.principal == "spiffe://cluster.local/ns/default/sa/frontend" && !has(connection.tls.peer.subject) && !has(connection.tls.peer.issuer)`,
+		request: &authv3.CheckRequest{
+			Attributes: &authv3.AttributeContext{
+				Source: &authv3.AttributeContext_Peer{Principal: "spiffe://cluster.local/ns/default/sa/frontend"},
+			},
+		},
+		want: true,
+	}, {
+		name:       "invalid certificate",
+		expression: `connection.tls.mutual && !has(connection.tls.peer)`,
+		request: &authv3.CheckRequest{
+			Attributes: &authv3.AttributeContext{
+				Source: &authv3.AttributeContext_Peer{Certificate: "not%20a%20certificate"},
+			},
+		},
+		want: true,
+	}, {
+		name:       "tls without client certificate",
+		expression: `connection.tls.enabled && !connection.tls.mutual && !has(connection.tls.peer) && !has(connection.tls.sni)`,
+		request: &authv3.CheckRequest{
+			Attributes: &authv3.AttributeContext{
+				Destination: &authv3.AttributeContext_Peer{Principal: "spiffe://cluster.local/ns/default/sa/backend"},
+				TlsSession:  &authv3.AttributeContext_TLSSession{},
+			},
+		},
+		want: true,
+	}, {
+		name:       "plaintext",
+		expression: `!connection.tls.enabled && !connection.tls.mutual && !has(connection.tls.sni) && !has(connection.tls.peer)`,
+		request:    &authv3.CheckRequest{Attributes: &authv3.AttributeContext{}},
+		want:       true,
+	}, {
+		name:       "require mtls",
+		expression: `connection.tls.mutual`,
+		request:    &authv3.CheckRequest{},
+		want:       false,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", tt.expression+` ? envoy.Allowed().Response() : envoy.Denied(403).Response()`)
+			compiled, errs := NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			response, err := compiled.Evaluate(context.Background(), tt.request)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, response.GetStatus().GetCode() == 0, fmt.Sprint(response))
+		})
+	}
+}
+
+func Test_compiler_Compile_connectionAbsentField(t *testing.T) {
+	// reading an absent field fails the evaluation instead of comparing an empty string
+	policy := newPolicy("policy", `connection.tls.sni == "" ? envoy.Allowed().Response() : null`)
+	compiled, errs := NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	_, err := compiled.Evaluate(context.Background(), &authv3.CheckRequest{})
+	assert.ErrorContains(t, err, "no such key: sni")
+}
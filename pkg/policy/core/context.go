@@ -0,0 +1,40 @@
+package core
+
+import (
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/google/cel-go/common/types"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// ContextType is the type of the context variable, it holds the per route configuration envoy attached to the request:
+//   - extensions are the attributes.context_extensions, set with the ext_authz per route filter config
+//   - route is the attributes.route_metadata_context.filter_metadata, the route metadata forwarded by envoy
+//   - metadata is the attributes.metadata_context.filter_metadata, the dynamic and connection metadata forwarded by envoy
+var ContextType = types.NewMapType(types.StringType, types.DynType)
+
+// contextFields are the fields of the context variable
+var contextFields = []mapField{
+	{name: "extensions", celType: types.NewMapType(types.StringType, types.StringType)},
+	{name: "route", celType: metadataType},
+	{name: "metadata", celType: metadataType},
+}
+
+// metadataType is the type of envoy filter metadata, the structs are keyed by filter name. It is also the type
+// of the metadata variable, holding the attributes.metadata_context.filter_metadata like context.metadata.
+var metadataType = types.NewMapType(types.StringType, types.NewMapType(types.StringType, types.DynType))
+
+// newContext returns the context variable of a check request, missing fields are empty maps
+func newContext(r *authv3.CheckRequest) map[string]any {
+	attributes := r.GetAttributes()
+	return map[string]any{
+		"extensions": attributes.GetContextExtensions(),
+		"route":      attributes.GetRouteMetadataContext().GetFilterMetadata(),
+		"metadata":   newFilterMetadata(r),
+	}
+}
+
+// newFilterMetadata returns the dynamic and connection metadata forwarded by envoy keyed by filter name,
+// it is empty when envoy didn't forward any. The nested values are read as CEL maps, lists and scalars.
+func newFilterMetadata(r *authv3.CheckRequest) map[string]*structpb.Struct {
+	return r.GetAttributes().GetMetadataContext().GetFilterMetadata()
+}
@@ -0,0 +1,154 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func Test_compiler_Compile_context(t *testing.T) {
+	route, err := structpb.NewStruct(map[string]any{"public": true, "tier": "gold"})
+	assert.NoError(t, err)
+	dynamic, err := structpb.NewStruct(map[string]any{"tenant": "acme"})
+	assert.NoError(t, err)
+	request := &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			ContextExtensions: map[string]string{"public": "true"},
+			RouteMetadataContext: &corev3.Metadata{
+				FilterMetadata: map[string]*structpb.Struct{"envoy.filters.http.ext_authz": route},
+			},
+			MetadataContext: &corev3.Metadata{
+				FilterMetadata: map[string]*structpb.Struct{"envoy.filters.http.jwt_authn": dynamic},
+			},
+		},
+	}
+	tests := []struct {
+		name       string
+		expression string
+		request    *authv3.CheckRequest
+		want       bool
+	}{{
+		name:       "context extension",
+		expression: `context.extensions[?"public"].orValue("") == "true"`,
+		request:    request,
+		want:       true,
+	}, {
+		name:       "missing context extension",
+		expression: `context.extensions[?"private"].orValue("") == "true"`,
+		request:    request,
+		want:       false,
+	}, {
+		name:       "route metadata",
+		expression: `context.route["envoy.filters.http.ext_authz"].public && context.route["envoy.filters.http.ext_authz"].tier == "gold"`,
+		request:    request,
+		want:       true,
+	}, {
+		name:       "dynamic metadata",
+		expression: `context.metadata["envoy.filters.http.jwt_authn"].tenant == "acme"`,
+		request:    request,
+		want:       true,
+	}, {
+		name:       "same as the check request",
+		expression: `context.extensions == object.attributes.context_extensions`,
+		request:    request,
+		want:       true,
+	}, {
+		name:       "empty request",
+		expression: `size(context.extensions) == 0 && size(context.route) == 0 && size(context.metadata) == 0`,
+		request:    &authv3.CheckRequest{},
+		want:       true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", tt.expression+` ? envoy.Allowed().Response() : envoy.Denied(403).Response()`)
+			compiled, errs := NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			response, err := compiled.Evaluate(context.Background(), tt.request)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, response.GetStatus().GetCode() == 0)
+		})
+	}
+}
+
+func Test_compiler_Compile_metadata(t *testing.T) {
+	jwt, err := structpb.NewStruct(map[string]any{
+		"payload": map[string]any{
+			"sub":    "alice",
+			"groups": []any{"admin", "dev"},
+			"org":    map[string]any{"team": map[string]any{"name": "platform", "level": 3}},
+		},
+	})
+	assert.NoError(t, err)
+	ratelimit, err := structpb.NewStruct(map[string]any{"over_limit": false, "remaining": 42})
+	assert.NoError(t, err)
+	request := &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			MetadataContext: &corev3.Metadata{
+				FilterMetadata: map[string]*structpb.Struct{
+					"envoy.filters.http.jwt_authn": jwt,
+					"envoy.filters.http.ratelimit": ratelimit,
+				},
+			},
+		},
+	}
+	tests := []struct {
+		name       string
+		expression string
+		request    *authv3.CheckRequest
+		want       bool
+	}{{
+		name:       "filter metadata",
+		expression: `metadata["envoy.filters.http.jwt_authn"].payload.sub == "alice"`,
+		request:    request,
+		want:       true,
+	}, {
+		name:       "nested struct",
+		expression: `metadata["envoy.filters.http.jwt_authn"].payload.org.team.name == "platform" && metadata["envoy.filters.http.jwt_authn"].payload.org.team.level == 3.0`,
+		request:    request,
+		want:       true,
+	}, {
+		name:       "nested list",
+		expression: `"admin" in metadata["envoy.filters.http.jwt_authn"].payload.groups`,
+		request:    request,
+		want:       true,
+	}, {
+		name:       "another filter",
+		expression: `!metadata["envoy.filters.http.ratelimit"].over_limit && metadata["envoy.filters.http.ratelimit"].remaining > 0.0`,
+		request:    request,
+		want:       true,
+	}, {
+		name:       "absent filter",
+		expression: `metadata[?"envoy.filters.http.rbac"].?shadow_effective_policy_id.orValue("") == "allow-all"`,
+		request:    request,
+		want:       false,
+	}, {
+		name:       "absent field",
+		expression: `has(metadata["envoy.filters.http.jwt_authn"].payload.email)`,
+		request:    request,
+		want:       false,
+	}, {
+		name:       "same as the context metadata",
+		expression: `metadata == context.metadata`,
+		request:    request,
+		want:       true,
+	}, {
+		name:       "empty request",
+		expression: `size(metadata) == 0 && !("envoy.filters.http.jwt_authn" in metadata)`,
+		request:    &authv3.CheckRequest{},
+		want:       true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", tt.expression+` ? envoy.Allowed().Response() : envoy.Denied(403).Response()`)
+			compiled, errs := NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			response, err := compiled.Evaluate(context.Background(), tt.request)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, response.GetStatus().GetCode() == 0)
+		})
+	}
+}
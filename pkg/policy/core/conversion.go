@@ -0,0 +1,25 @@
+package core
+
+import (
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+)
+
+// scheme knows the hub and the served versions, along with the conversions between them
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(hub.Install(scheme))
+	utilruntime.Must(v1alpha1.Install(scheme))
+}
+
+// ConvertPolicy converts a policy of a served version to the hub version the compiler operates on
+func ConvertPolicy(policy runtime.Object) (*hub.AuthorizationPolicy, error) {
+	var out hub.AuthorizationPolicy
+	if err := scheme.Convert(policy, &out, nil); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/types"
+)
+
+const (
+	// estimatedMaxCollectionSize bounds the size of the lists and maps of unknown size when estimating costs,
+	// it matches the default maximum number of request headers accepted by envoy
+	estimatedMaxCollectionSize = 100
+	// estimatedMaxStringSize bounds the size of the strings and bytes of unknown size when estimating costs
+	estimatedMaxStringSize = 4096
+)
+
+// costEstimator bounds the size of the request values, cel assumes unbounded sizes otherwise and the estimate
+// of any expression iterating over the request saturates
+type costEstimator struct{}
+
+func (costEstimator) EstimateSize(element checker.AstNode) *checker.SizeEstimate {
+	if element.Type() == nil {
+		return nil
+	}
+	switch element.Type().Kind() {
+	case types.ListKind, types.MapKind, types.DynKind:
+		return &checker.SizeEstimate{Min: 0, Max: estimatedMaxCollectionSize}
+	case types.StringKind, types.BytesKind:
+		return &checker.SizeEstimate{Min: 0, Max: estimatedMaxStringSize}
+	}
+	return nil
+}
+
+func (costEstimator) EstimateCallCost(string, string, *checker.AstNode, []checker.AstNode) *checker.CallEstimate {
+	return nil
+}
+
+// costAnalyzer sums the estimated cost of the expressions compiled in an environment.
+// It is registered as a validator to see every checked expression, it never reports issues and
+// the environment must be used to compile a single policy.
+type costAnalyzer struct {
+	estimate checker.CostEstimate
+}
+
+func (a *costAnalyzer) Name() string {
+	return "kyverno.cost"
+}
+
+func (a *costAnalyzer) Validate(_ *cel.Env, _ cel.ValidatorConfig, checked *ast.AST, _ *cel.Issues) {
+	estimate, err := checker.Cost(checked, costEstimator{})
+	// the estimate is informative, an expression that can't be estimated is assumed to be unbounded
+	if err != nil {
+		estimate = checker.CostEstimate{Max: ^uint64(0)}
+	}
+	a.estimate = a.estimate.Add(estimate)
+}
+
+// cost returns the worst case cost of evaluating every expression once
+func (a *costAnalyzer) cost() uint64 {
+	return a.estimate.Max
+}
+
+type costKey struct{}
+
+// EvaluationCost accumulates the actual cost of the CEL expressions evaluated with a context
+type EvaluationCost struct {
+	total atomic.Uint64
+}
+
+// WithEvaluationCost returns a context accumulating the actual cost of the policy expressions evaluated with it
+func WithEvaluationCost(ctx context.Context) (context.Context, *EvaluationCost) {
+	cost := &EvaluationCost{}
+	return context.WithValue(ctx, costKey{}, cost), cost
+}
+
+// Total returns the cost accumulated so far, a nil EvaluationCost returns zero
+func (c *EvaluationCost) Total() uint64 {
+	if c == nil {
+		return 0
+	}
+	return c.total.Load()
+}
+
+// recordCost adds the actual cost of an evaluation to the context cost, if any
+func recordCost(ctx context.Context, details *cel.EvalDetails) {
+	if details == nil || details.ActualCost() == nil {
+		return
+	}
+	if cost, ok := ctx.Value(costKey{}).(*EvaluationCost); ok {
+		cost.total.Add(*details.ActualCost())
+	}
+}
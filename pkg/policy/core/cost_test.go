@@ -0,0 +1,56 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/stretchr/testify/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+)
+
+func Test_compiler_Compile_estimatedCost(t *testing.T) {
+	trivial, errs := NewCompiler().Compile(newPolicy("trivial", `object.attributes.request.http.method == "GET" ? envoy.Allowed().Response() : null`))
+	assert.Empty(t, errs)
+	comprehension, errs := NewCompiler().Compile(newPolicy("comprehension",
+		`object.attributes.request.http.headers.all(k, object.attributes.request.http.headers.exists(k2, k2.startsWith(k))) ? envoy.Allowed().Response() : null`,
+	))
+	assert.Empty(t, errs)
+	assert.NotZero(t, trivial.EstimatedCost)
+	assert.Greater(t, comprehension.EstimatedCost, trivial.EstimatedCost)
+	// sizes of the request are bounded, the estimate doesn't saturate
+	assert.Less(t, comprehension.EstimatedCost, ^uint64(0))
+	// every expression of the policy is accounted for
+	conditioned := newPolicy("conditioned", `object.attributes.request.http.method == "GET" ? envoy.Allowed().Response() : null`)
+	conditioned.Spec.MatchConditions = append(conditioned.Spec.MatchConditions, admissionregistrationv1.MatchCondition{Name: "post", Expression: `object.attributes.request.http.method == "POST"`})
+	compiled, errs := NewCompiler().Compile(conditioned)
+	assert.Empty(t, errs)
+	assert.Greater(t, compiled.EstimatedCost, trivial.EstimatedCost)
+}
+
+func Test_compiler_Compile_actualCost(t *testing.T) {
+	policy := newPolicy("policy", `object.attributes.request.http.headers.exists(k, k == "x-user") ? envoy.Allowed().Response() : envoy.Denied(403).Response()`)
+	compiled, errs := NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	small := &authv3.CheckRequest{Attributes: &authv3.AttributeContext{Request: &authv3.AttributeContext_Request{Http: &authv3.AttributeContext_HttpRequest{
+		Headers: map[string]string{"a": "1"},
+	}}}}
+	large := &authv3.CheckRequest{Attributes: &authv3.AttributeContext{Request: &authv3.AttributeContext_Request{Http: &authv3.AttributeContext_HttpRequest{
+		Headers: map[string]string{"a": "1", "b": "2", "c": "3", "d": "4", "e": "5", "f": "6"},
+	}}}}
+	evaluate := func(r *authv3.CheckRequest) uint64 {
+		ctx, cost := WithEvaluationCost(context.Background())
+		_, err := compiled.Evaluate(ctx, r)
+		assert.NoError(t, err)
+		return cost.Total()
+	}
+	smallCost, largeCost := evaluate(small), evaluate(large)
+	assert.NotZero(t, smallCost)
+	assert.Greater(t, largeCost, smallCost)
+	assert.LessOrEqual(t, largeCost, compiled.EstimatedCost)
+	// evaluating without a cost context records nothing
+	_, err := compiled.Evaluate(context.Background(), small)
+	assert.NoError(t, err)
+	var cost *EvaluationCost
+	assert.Zero(t, cost.Total())
+}
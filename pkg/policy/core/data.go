@@ -0,0 +1,86 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apiserver/pkg/cel/lazy"
+)
+
+// DataType is the type of the data variable, it maps the names of the policy data sources to the entries
+// of the referenced ConfigMaps and Secrets
+var DataType = types.NewMapType(types.StringType, types.NewMapType(types.StringType, types.StringType))
+
+// Data are the entries of the data sources of a policy by data source name, a data source referencing a
+// resource that doesn't exist has no entry
+type Data map[string]map[string]string
+
+type dataKey struct{}
+
+// WithData returns a context evaluating a policy with the entries of its data sources, the providers resolving
+// data sources wrap the evaluation of the policies declaring them
+func WithData(ctx context.Context, data Data) context.Context {
+	return context.WithValue(ctx, dataKey{}, data)
+}
+
+// DataSourceResource describes the resource referenced by a data source, `ConfigMap <namespace>/<name>` for example
+func DataSourceResource(source hub.DataSource) string {
+	if source.Secret != nil {
+		return "Secret " + source.Secret.Namespace + "/" + source.Secret.Name
+	}
+	if source.ConfigMap != nil {
+		return "ConfigMap " + source.ConfigMap.Namespace + "/" + source.ConfigMap.Name
+	}
+	return ""
+}
+
+// compileDataSources checks the data sources, names must be unique C identifiers and a data source
+// references exactly one resource
+func compileDataSources(path *field.Path, sources []hub.DataSource) field.ErrorList {
+	var errs field.ErrorList
+	names := sets.New[string]()
+	for i, source := range sources {
+		path := path.Index(i)
+		if names.Has(source.Name) {
+			errs = append(errs, field.Duplicate(path.Child("name"), source.Name))
+		} else if messages := validation.IsCIdentifier(source.Name); len(messages) > 0 {
+			errs = append(errs, field.Invalid(path.Child("name"), source.Name, strings.Join(messages, ", ")))
+		}
+		names.Insert(source.Name)
+		if (source.ConfigMap == nil) == (source.Secret == nil) {
+			errs = append(errs, field.Invalid(path, source.Name, "exactly one of configMap or secret is required"))
+			continue
+		}
+		reference := source.ConfigMap
+		if reference == nil {
+			reference = source.Secret
+		}
+		if reference.Namespace == "" || reference.Name == "" {
+			errs = append(errs, field.Invalid(path, source.Name, "the namespace and name of the resource are required"))
+		}
+	}
+	return errs
+}
+
+// newData returns the data variable of a policy evaluation, reading a data source without entries is an error
+func newData(ctx context.Context, sources []hub.DataSource) *lazy.MapValue {
+	data, _ := ctx.Value(dataKey{}).(Data)
+	value := lazy.NewMapValue(DataType)
+	for _, source := range sources {
+		value.Append(source.Name, func(*lazy.MapValue) ref.Val {
+			entries, ok := data[source.Name]
+			if !ok {
+				return types.WrapErr(fmt.Errorf("data source %s is not available, %s was not found", source.Name, DataSourceResource(source)))
+			}
+			return types.DefaultTypeAdapter.NativeToValue(entries)
+		})
+	}
+	return value
+}
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/utils/ptr"
+)
+
+var allowlistSource = hub.DataSource{
+	Name:      "allowlist",
+	ConfigMap: &hub.DataSourceReference{Namespace: "authz", Name: "allowlist"},
+}
+
+func newDataRequest(host string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{Host: host},
+			},
+		},
+	}
+}
+
+func Test_compiler_Compile_data(t *testing.T) {
+	policy := newPolicy("policy", `object.attributes.request.http.host in data.allowlist.hosts.split(",") ? envoy.Allowed().Response() : envoy.Denied(403).Response()`)
+	policy.Spec.Data = []hub.DataSource{allowlistSource}
+	compiled, errs := NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	// the policy is evaluated with the entries of the context
+	ctx := WithData(context.Background(), Data{"allowlist": {"hosts": "shop.example.com,api.example.com"}})
+	response, err := compiled.Evaluate(ctx, newDataRequest("api.example.com"))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(codes.OK), response.GetStatus().GetCode())
+	ctx = WithData(context.Background(), Data{"allowlist": {"hosts": "shop.example.com"}})
+	response, err = compiled.Evaluate(ctx, newDataRequest("api.example.com"))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(codes.PermissionDenied), response.GetStatus().GetCode())
+	// a missing resource fails the evaluation
+	_, err = compiled.Evaluate(WithData(context.Background(), Data{}), newDataRequest("api.example.com"))
+	assert.ErrorContains(t, err, "data source allowlist is not available, ConfigMap authz/allowlist was not found")
+	_, err = compiled.Evaluate(context.Background(), newDataRequest("api.example.com"))
+	assert.Error(t, err)
+	// unless the policy ignores failures
+	policy.Spec.FailurePolicy = ptr.To(admissionregistrationv1.Ignore)
+	compiled, errs = NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	response, err = compiled.Evaluate(WithData(context.Background(), Data{}), newDataRequest("api.example.com"))
+	assert.NoError(t, err)
+	assert.Nil(t, response)
+}
+
+func Test_compiler_Compile_dataNotRead(t *testing.T) {
+	// a missing resource only fails the evaluations reading it
+	policy := newPolicy("policy", `object.attributes.request.http.host == "public.example.com" ? envoy.Allowed().Response() : ("x" in data.allowlist ? envoy.Allowed().Response() : envoy.Denied(403).Response())`)
+	policy.Spec.Data = []hub.DataSource{allowlistSource}
+	compiled, errs := NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	response, err := compiled.Evaluate(WithData(context.Background(), Data{}), newDataRequest("public.example.com"))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(codes.OK), response.GetStatus().GetCode())
+}
+
+func Test_compiler_Compile_dataInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []hub.DataSource
+		wantErr string
+	}{{
+		name:    "duplicate name",
+		sources: []hub.DataSource{allowlistSource, allowlistSource},
+		wantErr: `spec.data[1].name: Duplicate value: "allowlist"`,
+	}, {
+		name:    "invalid name",
+		sources: []hub.DataSource{{Name: "allow-list", ConfigMap: allowlistSource.ConfigMap}},
+		wantErr: `spec.data[0].name: Invalid value: "allow-list"`,
+	}, {
+		name:    "no reference",
+		sources: []hub.DataSource{{Name: "allowlist"}},
+		wantErr: "exactly one of configMap or secret is required",
+	}, {
+		name:    "both references",
+		sources: []hub.DataSource{{Name: "allowlist", ConfigMap: allowlistSource.ConfigMap, Secret: allowlistSource.ConfigMap}},
+		wantErr: "exactly one of configMap or secret is required",
+	}, {
+		name:    "no namespace",
+		sources: []hub.DataSource{{Name: "keys", Secret: &hub.DataSourceReference{Name: "keys"}}},
+		wantErr: "the namespace and name of the resource are required",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", `envoy.Allowed().Response()`)
+			policy.Spec.Data = tt.sources
+			_, errs := NewCompiler().Compile(policy)
+			assert.ErrorContains(t, errs.ToAggregate(), tt.wantErr)
+		})
+	}
+}
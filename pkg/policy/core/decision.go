@@ -0,0 +1,43 @@
+package core
+
+import (
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/grpc/codes"
+)
+
+// Decision is the kind of decision a check response carries
+type Decision string
+
+const (
+	// DecisionAllow allows the request as is
+	DecisionAllow Decision = "Allow"
+	// DecisionAllowWithMutations allows the request and changes the request sent upstream or the response returned
+	// to the client, its OkHttpResponse adds or removes headers or query parameters
+	DecisionAllowWithMutations Decision = "AllowWithMutations"
+	// DecisionDeny denies the request
+	DecisionDeny Decision = "Deny"
+)
+
+// ResponseDecision returns the decision carried by a policy response, empty when the policy didn't take a decision
+func ResponseDecision(response *authv3.CheckResponse) Decision {
+	switch {
+	case response == nil:
+		return ""
+	case response.GetStatus().GetCode() != int32(codes.OK):
+		return DecisionDeny
+	case Mutates(response.GetOkResponse()):
+		return DecisionAllowWithMutations
+	default:
+		return DecisionAllow
+	}
+}
+
+// Mutates returns true when the allow response changes the request sent upstream or the response returned to the
+// client, envoy applies the mutations of the OkHttpResponse before forwarding the request
+func Mutates(ok *authv3.OkHttpResponse) bool {
+	return len(ok.GetHeaders()) > 0 ||
+		len(ok.GetHeadersToRemove()) > 0 ||
+		len(ok.GetResponseHeadersToAdd()) > 0 ||
+		len(ok.GetQueryParametersToSet()) > 0 ||
+		len(ok.GetQueryParametersToRemove()) > 0
+}
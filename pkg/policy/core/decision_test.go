@@ -0,0 +1,82 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseDecision(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		headers *hub.Headers
+		want    Decision
+	}{{
+		name: "allowed",
+		expr: `envoy.Allowed().Response()`,
+		want: DecisionAllow,
+	}, {
+		name: "header stripped by the expression",
+		expr: `envoy.Allowed().WithoutHeader("authorization").Response()`,
+		want: DecisionAllowWithMutations,
+	}, {
+		name: "header added by the expression",
+		expr: `envoy.Allowed().WithHeader("x-role", "viewer").Response()`,
+		want: DecisionAllowWithMutations,
+	}, {
+		name: "query parameter removed by the expression",
+		expr: `envoy.Allowed().WithoutQueryParam("token").Response()`,
+		want: DecisionAllowWithMutations,
+	}, {
+		name: "response header added by the expression",
+		expr: `envoy.Allowed().WithResponseHeader("x-checked", "true").Response()`,
+		want: DecisionAllowWithMutations,
+	}, {
+		name:    "header mutations of the policy",
+		expr:    `envoy.Allowed().Response()`,
+		headers: &hub.Headers{Request: []hub.HeaderMutation{{Name: "x-user", Action: hub.HeaderActionRemove}}},
+		want:    DecisionAllowWithMutations,
+	}, {
+		name:    "denied",
+		expr:    `envoy.Denied(403).Response()`,
+		headers: &hub.Headers{Request: []hub.HeaderMutation{{Name: "x-user", Action: hub.HeaderActionRemove}}},
+		want:    DecisionDeny,
+	}, {
+		name: "no decision",
+		expr: `request.method == "POST" ? envoy.Allowed().Response() : null`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", tt.expr)
+			policy.Spec.Headers = tt.headers
+			compiled, errs := NewCompiler().Compile(policy)
+			require.Empty(t, errs)
+			response, err := compiled.Evaluate(context.Background(), newHttpRequest("GET", "/api?token=secret"))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, ResponseDecision(response))
+		})
+	}
+}
+
+func TestResponseDecision_mutations(t *testing.T) {
+	// the sensitive header is stripped and the claim downgraded, the request is still allowed
+	policy := newPolicy("policy", `envoy.Allowed().WithoutHeader("authorization").WithHeader("x-role", "viewer").Response()`)
+	policy.Spec.Headers = &hub.Headers{Request: []hub.HeaderMutation{{Name: "x-api-key", Action: hub.HeaderActionRemove}}}
+	compiled, errs := NewCompiler().Compile(policy)
+	require.Empty(t, errs)
+	request := newHttpRequest("GET", "/api")
+	request.Attributes.Request.Http.Headers["authorization"] = "Bearer token"
+	response, err := compiled.Evaluate(context.Background(), request)
+	require.NoError(t, err)
+	assert.Equal(t, DecisionAllowWithMutations, ResponseDecision(response))
+	ok := response.GetOkResponse()
+	assert.Equal(t, []string{"authorization", "x-api-key"}, ok.GetHeadersToRemove())
+	assert.Equal(t, []*corev3.HeaderValueOption{{
+		Header: &corev3.HeaderValue{Key: "x-role", Value: "viewer"},
+	}}, ok.GetHeaders())
+}
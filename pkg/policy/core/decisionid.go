@@ -0,0 +1,19 @@
+package core
+
+import (
+	"context"
+)
+
+type decisionIDKey struct{}
+
+// WithDecisionID returns a context identifying the decision taken for a check, the servers set it before the
+// policies are evaluated so that the logs, traces and responses of the check carry the same id
+func WithDecisionID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, decisionIDKey{}, id)
+}
+
+// DecisionID returns the id of the decision the context was created for, empty if there is none
+func DecisionID(ctx context.Context) string {
+	id, _ := ctx.Value(decisionIDKey{}).(string)
+	return id
+}
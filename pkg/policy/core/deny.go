@@ -0,0 +1,419 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
+	rpccode "google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+type denyResponse struct {
+	status      cel.Program
+	headers     []headerMutation
+	body        cel.Program
+	location    cel.Program
+	contentType cel.Program
+	grpcStatus  cel.Program
+	grpcMessage cel.Program
+}
+
+// contentTypeHeader is the header carrying the content type of the body, envoy uses lower case header names
+const contentTypeHeader = "content-type"
+
+// jsonContentType is the content type of bodies serialized to json
+const jsonContentType = "application/json"
+
+// grpcStatusHeader is the header envoy derives the gRPC status of locally generated responses from
+const grpcStatusHeader = "grpc-status"
+
+// grpcHttpStatuses are the HTTP status codes of gRPC codes, envoy derives the same gRPC codes from them
+var grpcHttpStatuses = map[rpccode.Code]typev3.StatusCode{
+	rpccode.Code_UNAUTHENTICATED:    typev3.StatusCode_Unauthorized,
+	rpccode.Code_PERMISSION_DENIED:  typev3.StatusCode_Forbidden,
+	rpccode.Code_UNIMPLEMENTED:      typev3.StatusCode_NotFound,
+	rpccode.Code_RESOURCE_EXHAUSTED: typev3.StatusCode_TooManyRequests,
+	rpccode.Code_UNAVAILABLE:        typev3.StatusCode_ServiceUnavailable,
+}
+
+// defaultRedirectStatus is the status code of redirects when neither the template nor the rule set a redirect status code
+const defaultRedirectStatus = typev3.StatusCode_Found
+
+// redirectStatuses are the status codes a location can be returned with
+var redirectStatuses = []typev3.StatusCode{
+	typev3.StatusCode_MovedPermanently,
+	typev3.StatusCode_Found,
+	typev3.StatusCode_SeeOther,
+	typev3.StatusCode_TemporaryRedirect,
+	typev3.StatusCode_PermanentRedirect,
+}
+
+func compileDenyResponse(env *compileEnv, programOptions []cel.ProgramOption, path *field.Path, deny *hub.DenyResponse) (denyResponse, field.ErrorList) {
+	var out denyResponse
+	if deny == nil {
+		return out, nil
+	}
+	if deny.Status != "" {
+		path := path.Child("status")
+		// a literal is checked at compile time, other expressions are checked when evaluated
+		if code, err := strconv.Atoi(strings.TrimSpace(deny.Status)); err == nil {
+			if err := validateStatus(int64(code)); err != nil {
+				return out, field.ErrorList{field.Invalid(path, deny.Status, err.Error())}
+			}
+			if deny.Location != "" {
+				if err := validateRedirect(int64(code)); err != nil {
+					return out, field.ErrorList{field.Invalid(path, deny.Status, err.Error())}
+				}
+			}
+		}
+		ast, errs := compileExpression(env, path, deny.Status)
+		if len(errs) > 0 {
+			return out, errs
+		}
+		if err := outputTypeError(ast, path, deny.Status, "status", types.IntType); err != nil {
+			return out, field.ErrorList{err}
+		}
+		prog, err := env.Program(ast, programOptions...)
+		if err != nil {
+			return out, field.ErrorList{field.Invalid(path, deny.Status, err.Error())}
+		}
+		out.status = prog
+	}
+	headers, errs := compileHeaderMutations(env, programOptions, path.Child("headers"), deny.Headers, false)
+	if len(errs) > 0 {
+		return out, errs
+	}
+	out.headers = headers
+	if deny.Body != "" {
+		path := path.Child("body")
+		ast, errs := compileExpression(env, path, deny.Body)
+		if len(errs) > 0 {
+			return out, errs
+		}
+		prog, err := env.Program(ast, programOptions...)
+		if err != nil {
+			return out, field.ErrorList{field.Invalid(path, deny.Body, err.Error())}
+		}
+		out.body = prog
+	}
+	if deny.Location != "" {
+		path := path.Child("location")
+		ast, errs := compileExpression(env, path, deny.Location)
+		if len(errs) > 0 {
+			return out, errs
+		}
+		if err := outputTypeError(ast, path, deny.Location, "location", types.StringType); err != nil {
+			return out, field.ErrorList{err}
+		}
+		prog, err := env.Program(ast, programOptions...)
+		if err != nil {
+			return out, field.ErrorList{field.Invalid(path, deny.Location, err.Error())}
+		}
+		out.location = prog
+	}
+	if deny.ContentType != "" {
+		path := path.Child("contentType")
+		ast, errs := compileExpression(env, path, deny.ContentType)
+		if len(errs) > 0 {
+			return out, errs
+		}
+		if err := outputTypeError(ast, path, deny.ContentType, "content type", types.StringType); err != nil {
+			return out, field.ErrorList{err}
+		}
+		prog, err := env.Program(ast, programOptions...)
+		if err != nil {
+			return out, field.ErrorList{field.Invalid(path, deny.ContentType, err.Error())}
+		}
+		out.contentType = prog
+	}
+	if deny.GrpcStatus != "" {
+		path := path.Child("grpcStatus")
+		ast, errs := compileExpression(env, path, deny.GrpcStatus)
+		if len(errs) > 0 {
+			return out, errs
+		}
+		if err := outputTypeError(ast, path, deny.GrpcStatus, "gRPC status", types.StringType); err != nil {
+			return out, field.ErrorList{err}
+		}
+		// a literal is checked at compile time, other expressions are checked when evaluated
+		if name, ok := stringLiteral(ast.NativeRep().Expr()); ok {
+			if _, err := parseGrpcCode(name); err != nil {
+				return out, field.ErrorList{field.Invalid(path, deny.GrpcStatus, err.Error())}
+			}
+		}
+		prog, err := env.Program(ast, programOptions...)
+		if err != nil {
+			return out, field.ErrorList{field.Invalid(path, deny.GrpcStatus, err.Error())}
+		}
+		out.grpcStatus = prog
+	}
+	if deny.GrpcMessage != "" {
+		path := path.Child("grpcMessage")
+		ast, errs := compileExpression(env, path, deny.GrpcMessage)
+		if len(errs) > 0 {
+			return out, errs
+		}
+		if err := outputTypeError(ast, path, deny.GrpcMessage, "gRPC message", types.StringType); err != nil {
+			return out, field.ErrorList{err}
+		}
+		prog, err := env.Program(ast, programOptions...)
+		if err != nil {
+			return out, field.ErrorList{field.Invalid(path, deny.GrpcMessage, err.Error())}
+		}
+		out.grpcMessage = prog
+	}
+	return out, nil
+}
+
+// validateStatus checks the status code is a status code envoy accepts
+func validateStatus(code int64) error {
+	if _, ok := typev3.StatusCode_name[int32(code)]; !ok || code == 0 || code != int64(int32(code)) {
+		return fmt.Errorf("%d is not a valid HTTP status code", code)
+	}
+	return nil
+}
+
+// validateRedirect checks the status code is a status code a location can be returned with
+func validateRedirect(code int64) error {
+	if !slices.Contains(redirectStatuses, typev3.StatusCode(code)) {
+		return fmt.Errorf("%d is not a redirect status code, a location requires one of %v", code, redirectCodes())
+	}
+	return nil
+}
+
+func redirectCodes() []int32 {
+	codes := make([]int32, 0, len(redirectStatuses))
+	for _, status := range redirectStatuses {
+		codes = append(codes, int32(status))
+	}
+	return codes
+}
+
+// validateLocation checks the location can be sent in a header
+func validateLocation(location string) error {
+	if location == "" {
+		return errors.New("location must not be empty")
+	}
+	// control characters are rejected, they would let the location inject headers
+	if _, err := url.Parse(location); err != nil {
+		return fmt.Errorf("invalid location: %w", err)
+	}
+	return nil
+}
+
+// validateContentType checks the content type is a media type that can be sent in a header
+func validateContentType(contentType string) error {
+	if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		return fmt.Errorf("invalid content type %q: %w", contentType, err)
+	}
+	return nil
+}
+
+// parseGrpcCode returns the gRPC code with the name, a request can't be denied with OK
+func parseGrpcCode(name string) (rpccode.Code, error) {
+	code, ok := rpccode.Code_value[name]
+	if !ok || code == int32(rpccode.Code_OK) {
+		return 0, fmt.Errorf("%q is not a valid gRPC status code, it must be a code name like PERMISSION_DENIED", name)
+	}
+	return rpccode.Code(code), nil
+}
+
+// hasHeader returns true if the headers contain the header, names are case insensitive
+func hasHeader(headers []*corev3.HeaderValueOption, name string) bool {
+	return slices.ContainsFunc(headers, func(header *corev3.HeaderValueOption) bool {
+		return strings.EqualFold(header.GetHeader().GetKey(), name)
+	})
+}
+
+// apply sets the status code, headers, location, body and content type of denied responses,
+// and the gRPC status of denied gRPC requests
+func (d denyResponse) apply(ctx context.Context, response *authv3.CheckResponse, data map[string]any) error {
+	if response.GetStatus().GetCode() == int32(codes.OK) {
+		return nil
+	}
+	if d.status == nil && len(d.headers) == 0 && d.body == nil && d.location == nil && d.contentType == nil && d.grpcStatus == nil && d.grpcMessage == nil {
+		return nil
+	}
+	denied := response.GetDeniedResponse()
+	if denied == nil {
+		denied = &authv3.DeniedHttpResponse{}
+		response.HttpResponse = &authv3.CheckResponse_DeniedResponse{DeniedResponse: denied}
+	}
+	if d.status != nil {
+		out, details, err := d.status.ContextEval(ctx, data)
+		recordCost(ctx, details)
+		if err != nil {
+			return err
+		}
+		code, err := utils.ConvertToNative[int64](out)
+		if err != nil {
+			return err
+		}
+		if err := validateStatus(code); err != nil {
+			return err
+		}
+		if d.location != nil {
+			if err := validateRedirect(code); err != nil {
+				return err
+			}
+		}
+		denied.Status = &typev3.HttpStatus{Code: typev3.StatusCode(code)}
+	} else if d.location != nil && !slices.Contains(redirectStatuses, denied.GetStatus().GetCode()) {
+		// keep the redirect status code set by the rule, if any
+		denied.Status = &typev3.HttpStatus{Code: defaultRedirectStatus}
+	}
+	for _, mutation := range d.headers {
+		header, err := mutation.eval(ctx, data)
+		if err != nil {
+			return err
+		}
+		denied.Headers = append(denied.Headers, header)
+	}
+	if d.location != nil {
+		out, details, err := d.location.ContextEval(ctx, data)
+		recordCost(ctx, details)
+		if err != nil {
+			return err
+		}
+		location, err := utils.ConvertToNative[string](out)
+		if err != nil {
+			return err
+		}
+		if err := validateLocation(location); err != nil {
+			return err
+		}
+		denied.Headers = append(denied.Headers, &corev3.HeaderValueOption{
+			Header:       &corev3.HeaderValue{Key: "location", Value: location},
+			AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+		})
+	}
+	if d.body != nil {
+		out, details, err := d.body.ContextEval(ctx, data)
+		recordCost(ctx, details)
+		if err != nil {
+			return err
+		}
+		body, serialized, err := encodeBody(out)
+		if err != nil {
+			return err
+		}
+		denied.Body = body
+		// the body is json, unless the template says otherwise
+		if serialized && d.contentType == nil && !hasHeader(denied.Headers, contentTypeHeader) {
+			denied.Headers = append(denied.Headers, &corev3.HeaderValueOption{
+				Header:       &corev3.HeaderValue{Key: contentTypeHeader, Value: jsonContentType},
+				AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+			})
+		}
+	}
+	if d.contentType != nil {
+		out, details, err := d.contentType.ContextEval(ctx, data)
+		recordCost(ctx, details)
+		if err != nil {
+			return err
+		}
+		contentType, err := utils.ConvertToNative[string](out)
+		if err != nil {
+			return err
+		}
+		if err := validateContentType(contentType); err != nil {
+			return err
+		}
+		// the content type overwrites the header set by the rule or the template headers
+		denied.Headers = slices.DeleteFunc(denied.Headers, func(header *corev3.HeaderValueOption) bool {
+			return strings.EqualFold(header.GetHeader().GetKey(), contentTypeHeader)
+		})
+		denied.Headers = append(denied.Headers, &corev3.HeaderValueOption{
+			Header:       &corev3.HeaderValue{Key: contentTypeHeader, Value: contentType},
+			AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+		})
+	}
+	if d.grpcStatus != nil || d.grpcMessage != nil {
+		// other requests are denied with the HTTP status
+		if request, _ := data[ObjectKey].(*authv3.CheckRequest); isGrpcCall(request.GetAttributes().GetRequest().GetHttp()) {
+			return d.applyGrpc(ctx, response, denied, data)
+		}
+	}
+	return nil
+}
+
+// applyGrpc sets the gRPC status of denied gRPC requests, envoy replies to gRPC clients with the code
+// of the grpc-status header and sends the body as the gRPC message
+func (d denyResponse) applyGrpc(ctx context.Context, response *authv3.CheckResponse, denied *authv3.DeniedHttpResponse, data map[string]any) error {
+	if d.grpcStatus != nil {
+		out, details, err := d.grpcStatus.ContextEval(ctx, data)
+		recordCost(ctx, details)
+		if err != nil {
+			return err
+		}
+		name, err := utils.ConvertToNative[string](out)
+		if err != nil {
+			return err
+		}
+		code, err := parseGrpcCode(name)
+		if err != nil {
+			return err
+		}
+		response.Status.Code = int32(code)
+		// keep the HTTP status set by the template, if any
+		if status, ok := grpcHttpStatuses[code]; ok && d.status == nil {
+			denied.Status = &typev3.HttpStatus{Code: status}
+		}
+		denied.Headers = slices.DeleteFunc(denied.Headers, func(header *corev3.HeaderValueOption) bool {
+			return strings.EqualFold(header.GetHeader().GetKey(), grpcStatusHeader)
+		})
+		denied.Headers = append(denied.Headers, &corev3.HeaderValueOption{
+			Header:       &corev3.HeaderValue{Key: grpcStatusHeader, Value: strconv.Itoa(int(code))},
+			AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+		})
+	}
+	if d.grpcMessage != nil {
+		out, details, err := d.grpcMessage.ContextEval(ctx, data)
+		recordCost(ctx, details)
+		if err != nil {
+			return err
+		}
+		message, err := utils.ConvertToNative[string](out)
+		if err != nil {
+			return err
+		}
+		response.Status.Message = message
+		denied.Body = message
+	}
+	return nil
+}
+
+// encodeBody returns strings as is and serializes other values to json, serialized is true for json bodies
+func encodeBody(value ref.Val) (body string, serialized bool, err error) {
+	if value, ok := value.(types.String); ok {
+		return string(value), false, nil
+	}
+	native, err := value.ConvertToNative(reflect.TypeOf(&structpb.Value{}))
+	if err != nil {
+		return "", false, fmt.Errorf("failed to serialize body: %w", err)
+	}
+	// encoding/json output is stable, unlike protojson
+	out, err := json.Marshal(native.(*structpb.Value).AsInterface())
+	if err != nil {
+		return "", false, fmt.Errorf("failed to serialize body: %w", err)
+	}
+	return string(out), true, nil
+}
@@ -0,0 +1,373 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/utils/ptr"
+)
+
+func Test_compiler_Compile_denyResponse(t *testing.T) {
+	request := &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Path:    "/orders",
+					Headers: map[string]string{"x-quota": "exceeded"},
+				},
+			},
+		},
+	}
+	tests := []struct {
+		name        string
+		rule        string
+		deny        *hub.DenyResponse
+		wantStatus  typev3.StatusCode
+		wantBody    string
+		wantHeaders map[string]string
+		wantOk      bool
+	}{{
+		name:       "no template",
+		rule:       `envoy.Denied(401).Response()`,
+		wantStatus: typev3.StatusCode_Unauthorized,
+	}, {
+		name:       "empty template",
+		rule:       `envoy.Denied(401).Response()`,
+		deny:       &hub.DenyResponse{},
+		wantStatus: typev3.StatusCode_Unauthorized,
+	}, {
+		name:       "literal status",
+		rule:       `envoy.Denied(403).Response()`,
+		deny:       &hub.DenyResponse{Status: "429"},
+		wantStatus: typev3.StatusCode_TooManyRequests,
+	}, {
+		name:       "status expression",
+		rule:       `envoy.Denied(403).Response()`,
+		deny:       &hub.DenyResponse{Status: `object.attributes.request.http.headers["x-quota"] == "exceeded" ? 429 : 403`},
+		wantStatus: typev3.StatusCode_TooManyRequests,
+	}, {
+		name: "string body",
+		rule: `envoy.Denied(403).Response()`,
+		deny: &hub.DenyResponse{
+			Body: `"access to " + object.attributes.request.http.path + " denied"`,
+		},
+		wantStatus: typev3.StatusCode_Forbidden,
+		wantBody:   "access to /orders denied",
+	}, {
+		name: "problem json",
+		rule: `envoy.Denied(403).Response()`,
+		deny: &hub.DenyResponse{
+			Status: "403",
+			Headers: []hub.HeaderMutation{
+				{Name: "content-type", Expression: `"application/problem+json"`},
+			},
+			Body: `{"type": dyn("about:blank"), "title": dyn("Forbidden"), "status": dyn(403), "instance": dyn(object.attributes.request.http.path)}`,
+		},
+		wantStatus:  typev3.StatusCode_Forbidden,
+		wantBody:    `{"instance":"/orders","status":403,"title":"Forbidden","type":"about:blank"}`,
+		wantHeaders: map[string]string{"content-type": "application/problem+json"},
+	}, {
+		name: "json body",
+		rule: `envoy.Denied(403).Response()`,
+		deny: &hub.DenyResponse{
+			Body: `{"error": "forbidden"}`,
+		},
+		wantStatus:  typev3.StatusCode_Forbidden,
+		wantBody:    `{"error":"forbidden"}`,
+		wantHeaders: map[string]string{"content-type": "application/json"},
+	}, {
+		name: "content type",
+		rule: `envoy.Denied(403).Response()`,
+		deny: &hub.DenyResponse{
+			Body:        `"<h1>Forbidden</h1>"`,
+			ContentType: `"text/html; charset=utf-8"`,
+		},
+		wantStatus:  typev3.StatusCode_Forbidden,
+		wantBody:    "<h1>Forbidden</h1>",
+		wantHeaders: map[string]string{"content-type": "text/html; charset=utf-8"},
+	}, {
+		name: "content type overwrites the content type header",
+		rule: `envoy.Denied(403).Response()`,
+		deny: &hub.DenyResponse{
+			Headers: []hub.HeaderMutation{
+				{Name: "Content-Type", Expression: `"text/plain"`},
+				{Name: "x-reason", Expression: `"quota"`},
+			},
+			Body:        `{"error": "forbidden"}`,
+			ContentType: `"application/problem+json"`,
+		},
+		wantStatus:  typev3.StatusCode_Forbidden,
+		wantBody:    `{"error":"forbidden"}`,
+		wantHeaders: map[string]string{"content-type": "application/problem+json", "x-reason": "quota"},
+	}, {
+		name:        "literal location",
+		rule:        `envoy.Denied(401).Response()`,
+		deny:        &hub.DenyResponse{Location: `"https://login.example.com"`},
+		wantStatus:  typev3.StatusCode_Found,
+		wantHeaders: map[string]string{"location": "https://login.example.com"},
+	}, {
+		name:        "location with status",
+		rule:        `envoy.Denied(401).Response()`,
+		deny:        &hub.DenyResponse{Status: "307", Location: `"https://login.example.com"`},
+		wantStatus:  typev3.StatusCode_TemporaryRedirect,
+		wantHeaders: map[string]string{"location": "https://login.example.com"},
+	}, {
+		name:        "location keeps the rule redirect status",
+		rule:        `envoy.Denied(303).Response()`,
+		deny:        &hub.DenyResponse{Location: `"https://login.example.com"`},
+		wantStatus:  typev3.StatusCode_SeeOther,
+		wantHeaders: map[string]string{"location": "https://login.example.com"},
+	}, {
+		name:   "allowed responses are not changed",
+		rule:   `envoy.Allowed().Response()`,
+		deny:   &hub.DenyResponse{Status: "429", Body: `"denied"`},
+		wantOk: true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", tt.rule)
+			policy.Spec.DenyResponse = tt.deny
+			compiled, errs := NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			response, err := compiled.Evaluate(context.Background(), request)
+			assert.NoError(t, err)
+			if tt.wantOk {
+				assert.Nil(t, response.GetDeniedResponse())
+				return
+			}
+			denied := response.GetDeniedResponse()
+			assert.NotNil(t, denied)
+			assert.Equal(t, tt.wantStatus, denied.GetStatus().GetCode())
+			assert.Equal(t, tt.wantBody, denied.GetBody())
+			headers := map[string]string{}
+			for _, header := range denied.GetHeaders() {
+				headers[header.GetHeader().GetKey()] = header.GetHeader().GetValue()
+			}
+			if tt.wantHeaders == nil {
+				tt.wantHeaders = map[string]string{}
+			}
+			assert.Equal(t, tt.wantHeaders, headers)
+		})
+	}
+}
+
+func Test_compiler_Compile_denyResponse_invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		deny *hub.DenyResponse
+	}{{
+		name: "invalid literal status",
+		deny: &hub.DenyResponse{Status: "999"},
+	}, {
+		name: "status not an int",
+		deny: &hub.DenyResponse{Status: `"429"`},
+	}, {
+		name: "remove header",
+		deny: &hub.DenyResponse{Headers: []hub.HeaderMutation{{Name: "x-foo", Action: hub.HeaderActionRemove}}},
+	}, {
+		name: "invalid body",
+		deny: &hub.DenyResponse{Body: `{"foo":`},
+	}, {
+		name: "location with a status that is not a redirect",
+		deny: &hub.DenyResponse{Status: "403", Location: `"https://login.example.com"`},
+	}, {
+		name: "location not a string",
+		deny: &hub.DenyResponse{Location: `302`},
+	}, {
+		name: "content type not a string",
+		deny: &hub.DenyResponse{ContentType: `42`},
+	}, {
+		name: "invalid literal gRPC status",
+		deny: &hub.DenyResponse{GrpcStatus: `'FORBIDDEN'`},
+	}, {
+		name: "OK gRPC status",
+		deny: &hub.DenyResponse{GrpcStatus: `'OK'`},
+	}, {
+		name: "gRPC status not a string",
+		deny: &hub.DenyResponse{GrpcStatus: `7`},
+	}, {
+		name: "gRPC message not a string",
+		deny: &hub.DenyResponse{GrpcMessage: `{"error": "forbidden"}`},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", `envoy.Denied(403).Response()`)
+			policy.Spec.DenyResponse = tt.deny
+			_, errs := NewCompiler().Compile(policy)
+			assert.NotEmpty(t, errs)
+		})
+	}
+}
+
+func Test_compiler_Compile_denyResponse_runtimeStatus(t *testing.T) {
+	// a computed status code is validated when evaluated and obeys the failure policy
+	policy := newPolicy("policy", `envoy.Denied(403).Response()`)
+	policy.Spec.DenyResponse = &hub.DenyResponse{Status: `size(object.attributes.request.http.path) + 1000`}
+	compiled, errs := NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	_, err := compiled.Evaluate(context.Background(), &authv3.CheckRequest{})
+	assert.Error(t, err)
+	policy.Spec.FailurePolicy = ptr.To(admissionregistrationv1.Ignore)
+	compiled, errs = NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	response, err := compiled.Evaluate(context.Background(), &authv3.CheckRequest{})
+	assert.NoError(t, err)
+	assert.Nil(t, response)
+}
+
+func Test_compiler_Compile_denyResponse_redirect(t *testing.T) {
+	// unauthenticated requests to protected routes are redirected to the login page
+	policy := newPolicy("login",
+		`!object.attributes.request.http.path.startsWith("/app/") || "authorization" in object.attributes.request.http.headers ? envoy.Allowed().Response() : envoy.Denied(401).Response()`,
+	)
+	policy.Spec.DenyResponse = &hub.DenyResponse{
+		Status:   "302",
+		Location: `"https://login.example.com/?redirect_uri=" + base64.encode(bytes("https://" + object.attributes.request.http.host + object.attributes.request.http.path))`,
+	}
+	compiled, errs := NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	newRequest := func(path string, headers map[string]string) *authv3.CheckRequest {
+		return &authv3.CheckRequest{
+			Attributes: &authv3.AttributeContext{
+				Request: &authv3.AttributeContext_Request{
+					Http: &authv3.AttributeContext_HttpRequest{
+						Host:    "shop.example.com",
+						Path:    path,
+						Headers: headers,
+					},
+				},
+			},
+		}
+	}
+	// unauthenticated request to a protected route
+	response, err := compiled.Evaluate(context.Background(), newRequest("/app/orders", map[string]string{}))
+	assert.NoError(t, err)
+	denied := response.GetDeniedResponse()
+	assert.NotNil(t, denied)
+	assert.Equal(t, typev3.StatusCode_Found, denied.GetStatus().GetCode())
+	assert.Len(t, denied.GetHeaders(), 1)
+	assert.Equal(t, "location", denied.GetHeaders()[0].GetHeader().GetKey())
+	assert.Equal(t, "https://login.example.com/?redirect_uri=aHR0cHM6Ly9zaG9wLmV4YW1wbGUuY29tL2FwcC9vcmRlcnM=", denied.GetHeaders()[0].GetHeader().GetValue())
+	// authenticated request
+	response, err = compiled.Evaluate(context.Background(), newRequest("/app/orders", map[string]string{"authorization": "Bearer token"}))
+	assert.NoError(t, err)
+	assert.Nil(t, response.GetDeniedResponse())
+	// public route
+	response, err = compiled.Evaluate(context.Background(), newRequest("/public", map[string]string{}))
+	assert.NoError(t, err)
+	assert.Nil(t, response.GetDeniedResponse())
+}
+
+func Test_compiler_Compile_denyResponse_runtimeLocation(t *testing.T) {
+	tests := []struct {
+		name string
+		deny *hub.DenyResponse
+	}{{
+		name: "computed status that is not a redirect",
+		deny: &hub.DenyResponse{Status: `size(object.attributes.request.http.path) + 403`, Location: `"https://login.example.com"`},
+	}, {
+		name: "empty location",
+		deny: &hub.DenyResponse{Location: `object.attributes.request.http.path`},
+	}, {
+		name: "header injection",
+		deny: &hub.DenyResponse{Location: `"https://login.example.com\r\nset-cookie: session=stolen"`},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", `envoy.Denied(401).Response()`)
+			policy.Spec.DenyResponse = tt.deny
+			compiled, errs := NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			_, err := compiled.Evaluate(context.Background(), &authv3.CheckRequest{})
+			var evalErr *EvaluationError
+			assert.ErrorAs(t, err, &evalErr)
+			assert.Equal(t, "spec.denyResponse", evalErr.Field)
+		})
+	}
+}
+
+func Test_compiler_Compile_denyResponse_runtimeContentType(t *testing.T) {
+	// a computed content type is validated when evaluated, it can't inject headers
+	policy := newPolicy("policy", `envoy.Denied(403).Response()`)
+	policy.Spec.DenyResponse = &hub.DenyResponse{ContentType: `"text/plain\r\nx-injected: true"`}
+	compiled, errs := NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	_, err := compiled.Evaluate(context.Background(), &authv3.CheckRequest{})
+	assert.ErrorContains(t, err, "invalid content type")
+}
+
+func Test_compiler_Compile_denyResponse_grpc(t *testing.T) {
+	policy := newPolicy("policy", `envoy.Denied(403).Response()`)
+	policy.Spec.DenyResponse = &hub.DenyResponse{
+		Body:        `"forbidden"`,
+		GrpcStatus:  `object.attributes.request.http.headers[?"authorization"].hasValue() ? 'PERMISSION_DENIED' : 'UNAUTHENTICATED'`,
+		GrpcMessage: `"calls to " + object.attributes.request.http.path + " require a token"`,
+	}
+	compiled, errs := NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	// the content type telling grpc calls apart is forwarded
+	assert.Contains(t, compiled.RequestHeaders.Names, "content-type")
+	newRequest := func(contentType string) *authv3.CheckRequest {
+		return &authv3.CheckRequest{
+			Attributes: &authv3.AttributeContext{
+				Request: &authv3.AttributeContext_Request{
+					Http: &authv3.AttributeContext_HttpRequest{
+						Path:    "/orders.v1.Orders/Get",
+						Headers: map[string]string{"content-type": contentType},
+					},
+				},
+			},
+		}
+	}
+	// a grpc call is denied with the gRPC status
+	response, err := compiled.Evaluate(context.Background(), newRequest("application/grpc+proto"))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(codes.Unauthenticated), response.GetStatus().GetCode())
+	assert.Equal(t, "calls to /orders.v1.Orders/Get require a token", response.GetStatus().GetMessage())
+	denied := response.GetDeniedResponse()
+	assert.Equal(t, typev3.StatusCode_Unauthorized, denied.GetStatus().GetCode())
+	assert.Equal(t, "calls to /orders.v1.Orders/Get require a token", denied.GetBody())
+	assert.Len(t, denied.GetHeaders(), 1)
+	assert.Equal(t, "grpc-status", denied.GetHeaders()[0].GetHeader().GetKey())
+	assert.Equal(t, "16", denied.GetHeaders()[0].GetHeader().GetValue())
+	// an http request is denied with the HTTP status
+	response, err = compiled.Evaluate(context.Background(), newRequest("application/json"))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(codes.PermissionDenied), response.GetStatus().GetCode())
+	denied = response.GetDeniedResponse()
+	assert.Equal(t, typev3.StatusCode_Forbidden, denied.GetStatus().GetCode())
+	assert.Equal(t, "forbidden", denied.GetBody())
+	assert.Empty(t, denied.GetHeaders())
+	// the HTTP status of the template is kept
+	policy.Spec.DenyResponse.Status = "429"
+	compiled, errs = NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	response, err = compiled.Evaluate(context.Background(), newRequest("application/grpc"))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(codes.Unauthenticated), response.GetStatus().GetCode())
+	assert.Equal(t, typev3.StatusCode_TooManyRequests, response.GetDeniedResponse().GetStatus().GetCode())
+}
+
+func Test_compiler_Compile_denyResponse_runtimeGrpcStatus(t *testing.T) {
+	// a computed gRPC status is validated when evaluated
+	policy := newPolicy("policy", `envoy.Denied(403).Response()`)
+	policy.Spec.DenyResponse = &hub.DenyResponse{GrpcStatus: `object.attributes.request.http.headers["x-code"]`}
+	compiled, errs := NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	_, err := compiled.Evaluate(context.Background(), &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Headers: map[string]string{"content-type": "application/grpc", "x-code": "OK"},
+				},
+			},
+		},
+	})
+	assert.ErrorContains(t, err, `"OK" is not a valid gRPC status code`)
+}
@@ -0,0 +1,33 @@
+package core
+
+import (
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/google/cel-go/common/types"
+)
+
+// DestinationType is the type of the destination variable, it holds the identity of the workload envoy forwards the request to:
+//   - address is the ip of the attributes.destination.address socket address, empty for other addresses
+//   - port is the port of the attributes.destination.address socket address, zero for other addresses
+//   - principal is the attributes.destination.principal, the identity of the local mTLS certificate (SPIFFE id or subject)
+//   - service is the attributes.destination.service, the canonical service name of the workload
+var DestinationType = types.NewObjectType("kyverno.destination")
+
+// destinationFields are the fields of the destination variable
+var destinationFields = []mapField{
+	{name: "address", celType: types.StringType},
+	{name: "port", celType: types.IntType},
+	{name: "principal", celType: types.StringType},
+	{name: "service", celType: types.StringType},
+}
+
+// newDestination returns the destination variable of a check request, missing fields are zero values
+func newDestination(r *authv3.CheckRequest) map[string]any {
+	destination := r.GetAttributes().GetDestination()
+	socket := destination.GetAddress().GetSocketAddress()
+	return map[string]any{
+		"address":   socket.GetAddress(),
+		"port":      int64(socket.GetPortValue()),
+		"principal": destination.GetPrincipal(),
+		"service":   destination.GetService(),
+	}
+}
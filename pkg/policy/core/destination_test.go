@@ -0,0 +1,154 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/stretchr/testify/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/utils/ptr"
+)
+
+func newDestinationRequest(principal string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Destination: &authv3.AttributeContext_Peer{
+				Address: &corev3.Address{
+					Address: &corev3.Address_SocketAddress{
+						SocketAddress: &corev3.SocketAddress{Address: "10.0.0.2", PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: 8080}},
+					},
+				},
+				Principal: principal,
+				Service:   "payments",
+			},
+		},
+	}
+}
+
+func Test_compiler_Compile_destination(t *testing.T) {
+	request := newDestinationRequest("spiffe://cluster.local/ns/payments/sa/api")
+	tests := []struct {
+		name       string
+		expression string
+		request    *authv3.CheckRequest
+		want       bool
+	}{{
+		name:       "address and port",
+		expression: `destination.address == "10.0.0.2" && destination.port == 8080`,
+		request:    request,
+		want:       true,
+	}, {
+		name:       "principal",
+		expression: `destination.principal == "spiffe://cluster.local/ns/payments/sa/api"`,
+		request:    request,
+		want:       true,
+	}, {
+		name:       "service",
+		expression: `destination.service == "payments"`,
+		request:    request,
+		want:       true,
+	}, {
+		name:       "same as the check request",
+		expression: `destination.principal == object.attributes.destination.principal`,
+		request:    request,
+		want:       true,
+	}, {
+		name:       "empty request",
+		expression: `destination.address == "" && destination.port == 0 && destination.principal == "" && destination.service == ""`,
+		request:    &authv3.CheckRequest{},
+		want:       true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", tt.expression+` ? envoy.Allowed().Response() : envoy.Denied(403).Response()`)
+			compiled, errs := NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			response, err := compiled.Evaluate(context.Background(), tt.request)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, response.GetStatus().GetCode() == 0)
+		})
+	}
+}
+
+func Test_compiler_Compile_targetConditions(t *testing.T) {
+	// the policy targets the workloads of the payments namespace in the cluster.local trust domain
+	targetConditions := []string{
+		`spiffe.TrustDomain(destination.principal) == "cluster.local"`,
+		`spiffe.Path(destination.principal).startsWith("/ns/payments/")`,
+	}
+	tests := []struct {
+		name             string
+		failurePolicy    *admissionregistrationv1.FailurePolicyType
+		targetConditions []string
+		matchConditions  []string
+		request          *authv3.CheckRequest
+		wantResponse     bool
+		wantErr          bool
+	}{{
+		name:             "targeted workload",
+		targetConditions: targetConditions,
+		request:          newDestinationRequest("spiffe://cluster.local/ns/payments/sa/api"),
+		wantResponse:     true,
+	}, {
+		name:             "other namespace",
+		targetConditions: targetConditions,
+		request:          newDestinationRequest("spiffe://cluster.local/ns/default/sa/api"),
+	}, {
+		name:             "other trust domain",
+		targetConditions: targetConditions,
+		request:          newDestinationRequest("spiffe://example.org/ns/payments/sa/api"),
+	}, {
+		name:             "not a spiffe id",
+		targetConditions: targetConditions,
+		request:          newDestinationRequest("CN=payments"),
+	}, {
+		name:             "no destination principal",
+		targetConditions: targetConditions,
+		request:          &authv3.CheckRequest{},
+	}, {
+		name:         "no target conditions",
+		request:      &authv3.CheckRequest{},
+		wantResponse: true,
+	}, {
+		name:             "not targeted short circuits match",
+		targetConditions: targetConditions,
+		matchConditions:  []string{`object.attributes.request.http.headers["missing"] == "foo"`},
+		request:          newDestinationRequest("spiffe://cluster.local/ns/default/sa/api"),
+	}, {
+		name:             "target error with failure policy fail",
+		targetConditions: []string{`object.attributes.request.http.headers["missing"] == "foo"`},
+		request:          newDestinationRequest("spiffe://cluster.local/ns/payments/sa/api"),
+		wantErr:          true,
+	}, {
+		name:             "target error with failure policy ignore",
+		failurePolicy:    ptr.To(admissionregistrationv1.Ignore),
+		targetConditions: []string{`object.attributes.request.http.headers["missing"] == "foo"`},
+		request:          newDestinationRequest("spiffe://cluster.local/ns/payments/sa/api"),
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", "envoy.Denied(403).Response()")
+			policy.Spec.FailurePolicy = tt.failurePolicy
+			for i, expression := range tt.targetConditions {
+				policy.Spec.TargetConditions = append(policy.Spec.TargetConditions, admissionregistrationv1.MatchCondition{Name: fmt.Sprint(i), Expression: expression})
+			}
+			for i, expression := range tt.matchConditions {
+				policy.Spec.MatchConditions = append(policy.Spec.MatchConditions, admissionregistrationv1.MatchCondition{Name: fmt.Sprint(i), Expression: expression})
+			}
+			compiled, errs := NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			response, err := compiled.Evaluate(context.Background(), tt.request)
+			if tt.wantErr {
+				var evalErr *EvaluationError
+				assert.ErrorAs(t, err, &evalErr)
+				assert.Equal(t, "spec.targetConditions[0].expression", evalErr.Field)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantResponse, response != nil)
+		})
+	}
+}
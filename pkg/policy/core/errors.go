@@ -0,0 +1,61 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// compile errors are reported with the path of the invalid expression, the error type tells why it is invalid:
+//   - field.ErrorTypeInvalid when the expression can't be parsed
+//   - field.ErrorTypeTypeInvalid when the expression doesn't type check, or its output type is not the expected one
+
+// compileExpression parses and type checks an expression, it reports syntax and type errors distinctly
+func compileExpression(env *compileEnv, path *field.Path, expression string) (*cel.Ast, field.ErrorList) {
+	parsed, issues := env.Parse(expression)
+	if err := issues.Err(); err != nil {
+		return nil, field.ErrorList{field.Invalid(path, expression, err.Error())}
+	}
+	checked, err := env.checkExpression(parsed, expression)
+	if err != nil {
+		return nil, field.ErrorList{field.TypeInvalid(path, expression, err.Error())}
+	}
+	return checked, nil
+}
+
+// outputTypeError returns the error reported when the output type of an expression is not the expected one,
+// nil otherwise. The output type is checked at compile time, an expression can't produce another type at runtime.
+func outputTypeError(ast *cel.Ast, path *field.Path, expression, kind string, want *types.Type) *field.Error {
+	if ast.OutputType().IsExactType(want) {
+		return nil
+	}
+	return field.TypeInvalid(path, expression, fmt.Sprintf("%s output is expected to be of type %s, got %s", kind, want, ast.OutputType()))
+}
+
+// ruleOutputHint tells how a rule returning another type than a check response takes a decision
+func ruleOutputHint(got *types.Type) string {
+	if got.IsExactType(types.BoolType) {
+		return ", a condition takes a decision with a conditional expression: <condition> ? envoy.Allowed().Response() : envoy.Denied(403).Response()"
+	}
+	return ", rules return envoy.Allowed().Response() or envoy.Denied(<status>).Response(), or null when they take no decision"
+}
+
+// EvaluationError is returned by a PolicyFunc when an expression failed to evaluate against a request,
+// unlike compile errors it depends on the request and the policy failure policy applies.
+type EvaluationError struct {
+	// Field is the path of the expression that failed to evaluate
+	Field string
+	// Err is the evaluation error
+	Err error
+}
+
+// Error returns the evaluation error message, it doesn't include the field as the message ends up in the response denying the request.
+func (e *EvaluationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *EvaluationError) Unwrap() error {
+	return e.Err
+}
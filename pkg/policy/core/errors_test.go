@@ -0,0 +1,184 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/stretchr/testify/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
+)
+
+func Test_compiler_Compile_errorTypes(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    *hub.AuthorizationPolicy
+		wantField string
+		wantType  field.ErrorType
+	}{{
+		name:      "syntax error",
+		policy:    newPolicy("policy", `envoy.Allowed(`),
+		wantField: "spec.authorizations[0].expression",
+		wantType:  field.ErrorTypeInvalid,
+	}, {
+		name:      "undeclared reference",
+		policy:    newPolicy("policy", `envoy.Allowed().Response().WithMessage(missing)`),
+		wantField: "spec.authorizations[0].expression",
+		wantType:  field.ErrorTypeTypeInvalid,
+	}, {
+		name:      "no matching overload",
+		policy:    newPolicy("policy", `envoy.Denied("403").Response()`),
+		wantField: "spec.authorizations[0].expression",
+		wantType:  field.ErrorTypeTypeInvalid,
+	}, {
+		name:      "unexpected output type",
+		policy:    newPolicy("policy", `"allowed"`),
+		wantField: "spec.authorizations[0].expression",
+		wantType:  field.ErrorTypeTypeInvalid,
+	}, {
+		name: "unexpected condition output type",
+		policy: func() *hub.AuthorizationPolicy {
+			policy := newPolicy("policy", `envoy.Allowed().Response()`)
+			policy.Spec.MatchConditions = []admissionregistrationv1.MatchCondition{{Name: "string", Expression: `"true"`}}
+			return policy
+		}(),
+		wantField: "spec.matchConditions[0].expression",
+		wantType:  field.ErrorTypeTypeInvalid,
+	}, {
+		name: "variable syntax error",
+		policy: func() *hub.AuthorizationPolicy {
+			policy := newPolicy("policy", `envoy.Allowed().Response()`)
+			policy.Spec.Variables = []admissionregistrationv1.Variable{{Name: "broken", Expression: `1 +`}}
+			return policy
+		}(),
+		wantField: "spec.variables[0].expression",
+		wantType:  field.ErrorTypeInvalid,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := NewCompiler().Compile(tt.policy)
+			assert.Len(t, errs, 1)
+			assert.Equal(t, tt.wantField, errs[0].Field)
+			assert.Equal(t, tt.wantType, errs[0].Type)
+		})
+	}
+}
+
+func Test_compiler_Compile_evaluationError(t *testing.T) {
+	// accessing a missing header type checks but fails at runtime
+	const missing = `object.attributes.request.http.headers["missing"] == "foo"`
+	request := &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{},
+			},
+		},
+	}
+	tests := []struct {
+		name      string
+		policy    func() *hub.AuthorizationPolicy
+		wantField string
+	}{{
+		name: "authorization",
+		policy: func() *hub.AuthorizationPolicy {
+			return newPolicy("policy", missing+` ? envoy.Allowed().Response() : null`)
+		},
+		wantField: "spec.authorizations[0].expression",
+	}, {
+		name: "second authorization",
+		policy: func() *hub.AuthorizationPolicy {
+			return newPolicy("policy", `false ? envoy.Allowed().Response() : null`, missing+` ? envoy.Allowed().Response() : null`)
+		},
+		wantField: "spec.authorizations[1].expression",
+	}, {
+		name: "match condition",
+		policy: func() *hub.AuthorizationPolicy {
+			policy := newPolicy("policy", `envoy.Allowed().Response()`)
+			policy.Spec.MatchConditions = []admissionregistrationv1.MatchCondition{{Name: "always", Expression: `true`}, {Name: "missing", Expression: missing}}
+			return policy
+		},
+		wantField: "spec.matchConditions[1].expression",
+	}, {
+		name: "variable",
+		policy: func() *hub.AuthorizationPolicy {
+			policy := newPolicy("policy", `variables.missing ? envoy.Allowed().Response() : null`)
+			policy.Spec.Variables = []admissionregistrationv1.Variable{{Name: "missing", Expression: missing}}
+			return policy
+		},
+		// variables are evaluated lazily, the error is reported by the expression referencing them
+		wantField: "spec.authorizations[0].expression",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := tt.policy()
+			compiled, errs := NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			response, err := compiled.Evaluate(context.Background(), request)
+			assert.Nil(t, response)
+			var evalErr *EvaluationError
+			assert.True(t, errors.As(err, &evalErr))
+			assert.Equal(t, tt.wantField, evalErr.Field)
+			assert.Contains(t, err.Error(), "no such key: missing")
+			// ignored failures don't return errors
+			policy.Spec.FailurePolicy = ptr.To(admissionregistrationv1.Ignore)
+			compiled, errs = NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			response, err = compiled.Evaluate(context.Background(), request)
+			assert.Nil(t, response)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func Test_compiler_Compile_outputType(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		wantErr    string
+	}{{
+		name:       "response",
+		expression: `envoy.Allowed().Response()`,
+	}, {
+		name:       "conditional response",
+		expression: `request.method == "GET" ? envoy.Allowed().Response() : envoy.Denied(403).Response()`,
+	}, {
+		name:       "no decision",
+		expression: `request.method == "GET" ? envoy.Allowed().Response() : null`,
+	}, {
+		name:       "string",
+		expression: `request.method == "GET" ? "allowed" : "denied"`,
+		wantErr:    "rule output is expected to be of type envoy.service.auth.v3.CheckResponse, got string, rules return envoy.Allowed().Response() or envoy.Denied(<status>).Response(), or null when they take no decision",
+	}, {
+		name:       "map",
+		expression: `{"allowed": true}`,
+		wantErr:    "rule output is expected to be of type envoy.service.auth.v3.CheckResponse, got map(string, bool)",
+	}, {
+		name:       "bool",
+		expression: `request.method == "GET"`,
+		wantErr:    "rule output is expected to be of type envoy.service.auth.v3.CheckResponse, got bool, a condition takes a decision with a conditional expression: <condition> ? envoy.Allowed().Response() : envoy.Denied(403).Response()",
+	}, {
+		name:       "ok response",
+		expression: `envoy.Allowed()`,
+		wantErr:    "rule output is expected to be of type envoy.service.auth.v3.CheckResponse, got envoy.service.auth.v3.OkHttpResponse",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compiled, errs := NewCompiler().Compile(newPolicy("policy", tt.expression))
+			if tt.wantErr == "" {
+				assert.Empty(t, errs)
+				assert.NotNil(t, compiled.Evaluate)
+				return
+			}
+			assert.Nil(t, compiled.Evaluate)
+			if assert.Len(t, errs, 1) {
+				assert.Equal(t, field.ErrorTypeTypeInvalid, errs[0].Type)
+				assert.Equal(t, "spec.authorizations[0].expression", errs[0].Field)
+				assert.Contains(t, errs[0].Detail, tt.wantErr)
+			}
+		})
+	}
+}
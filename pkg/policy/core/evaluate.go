@@ -0,0 +1,74 @@
+package core
+
+import (
+	"context"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+)
+
+// Evaluate evaluates policies sequentially in the given order and resolves their responses like the authorization
+// servers do: the first override policy returning a response decides, otherwise a deny wins over an allow and the
+// header mutations of the allows are merged, see MergeAllows.
+// Audit policies never affect the response and an evaluation error denies the request. It returns nil when
+// no policy returned a response, without metrics nor tracing.
+func Evaluate(ctx context.Context, policies []CompiledPolicy, r *authv3.CheckRequest) *authv3.CheckResponse {
+	// the policies share the tokens and documents parsed from the request
+	ctx, _ = utils.WithMemo(ctx)
+	var allowed []*authv3.CheckResponse
+	var denied *authv3.CheckResponse
+	for _, policy := range policies {
+		// once denied, only override policies can change the decision
+		if denied != nil && !policy.Override {
+			continue
+		}
+		// policies outside of their activation take no decision
+		if !policy.Activation.Active() {
+			continue
+		}
+		response, err := policy.Evaluate(ctx, r)
+		// audit policies never affect the response
+		if policy.Mode == hub.EnforcementModeAudit {
+			continue
+		}
+		// policies with failurePolicy=Ignore don't return errors,
+		// an error means failurePolicy=Fail so we deny the request
+		if err != nil {
+			response = Failed(err)
+		}
+		if response == nil {
+			continue
+		}
+		if policy.Override {
+			return response
+		}
+		if response.GetStatus().GetCode() != int32(codes.OK) {
+			denied = response
+		} else {
+			allowed = append(allowed, response)
+		}
+	}
+	if denied != nil {
+		return denied
+	}
+	return MergeAllows(allowed)
+}
+
+// Failed returns the response denying a request when a policy evaluation failed
+func Failed(err error) *authv3.CheckResponse {
+	return &authv3.CheckResponse{
+		Status: &status.Status{
+			Code:    int32(codes.PermissionDenied),
+			Message: err.Error(),
+		},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status: &typev3.HttpStatus{Code: typev3.StatusCode_Forbidden},
+			},
+		},
+	}
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	testingclock "k8s.io/utils/clock/testing"
+	"k8s.io/utils/ptr"
+)
+
+func TestEvaluate(t *testing.T) {
+	const (
+		allow = `envoy.Allowed().Response()`
+		deny  = `envoy.Denied(401).Response()`
+		none  = `false ? envoy.Allowed().Response() : null`
+		fail  = `object.attributes.request.http.headers["missing"] == "" ? envoy.Allowed().Response() : null`
+	)
+	audit := func(policy *hub.AuthorizationPolicy) *hub.AuthorizationPolicy {
+		policy.Spec.EnforcementMode = hub.EnforcementModeAudit
+		return policy
+	}
+	override := func(policy *hub.AuthorizationPolicy) *hub.AuthorizationPolicy {
+		policy.Spec.Override = true
+		return policy
+	}
+	// the policies are evaluated on a monday at noon
+	clock := testingclock.NewFakePassiveClock(time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC))
+	window := func(start, end string) func(*hub.AuthorizationPolicy) *hub.AuthorizationPolicy {
+		return func(policy *hub.AuthorizationPolicy) *hub.AuthorizationPolicy {
+			policy.Spec.Activation = &hub.Activation{Windows: []hub.ActivationWindow{{Start: start, End: end}}}
+			return policy
+		}
+	}
+	tests := []struct {
+		name     string
+		policies []*hub.AuthorizationPolicy
+		want     *codes.Code
+	}{{
+		name:     "no policy",
+		policies: nil,
+	}, {
+		name:     "no response",
+		policies: []*hub.AuthorizationPolicy{newPolicy("a", none)},
+	}, {
+		name:     "allow",
+		policies: []*hub.AuthorizationPolicy{newPolicy("a", none), newPolicy("b", allow)},
+		want:     ptr.To(codes.OK),
+	}, {
+		name:     "deny wins over allow",
+		policies: []*hub.AuthorizationPolicy{newPolicy("a", allow), newPolicy("b", deny)},
+		want:     ptr.To(codes.PermissionDenied),
+	}, {
+		name:     "audit policies are ignored",
+		policies: []*hub.AuthorizationPolicy{audit(newPolicy("a", deny)), newPolicy("b", allow)},
+		want:     ptr.To(codes.OK),
+	}, {
+		name:     "error denies",
+		policies: []*hub.AuthorizationPolicy{newPolicy("a", allow), newPolicy("b", fail)},
+		want:     ptr.To(codes.PermissionDenied),
+	}, {
+		name:     "override wins over deny",
+		policies: []*hub.AuthorizationPolicy{newPolicy("a", deny), override(newPolicy("b", allow))},
+		want:     ptr.To(codes.OK),
+	}, {
+		name:     "active policy",
+		policies: []*hub.AuthorizationPolicy{window("09:00", "17:00")(newPolicy("a", deny)), newPolicy("b", allow)},
+		want:     ptr.To(codes.PermissionDenied),
+	}, {
+		name:     "inactive policy takes no decision",
+		policies: []*hub.AuthorizationPolicy{window("18:00", "06:00")(newPolicy("a", deny)), newPolicy("b", allow)},
+		want:     ptr.To(codes.OK),
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compiled, err := CompilePolicies(NewCompiler(WithClock(clock)), tt.policies)
+			assert.NoError(t, err)
+			response := Evaluate(context.Background(), compiled, &authv3.CheckRequest{})
+			if tt.want == nil {
+				assert.Nil(t, response)
+				return
+			}
+			assert.NotNil(t, response)
+			assert.Equal(t, int32(*tt.want), response.GetStatus().GetCode())
+		})
+	}
+}
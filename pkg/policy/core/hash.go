@@ -0,0 +1,105 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+
+	"github.com/google/cel-go/cel"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+)
+
+// specHash returns a hash of the policy spec that changes when the behavior of the policy changes, the metadata
+// of the policy and whether it is disabled are not hashed. The spec is hashed as JSON with its defaults applied and
+// its CEL expressions in their unparsed form: whitespace, comments and quoting in expressions don't change the hash.
+// Other strings, like names, scopes and durations, are hashed as is.
+func specHash(env *cel.Env, spec hub.AuthorizationPolicySpec) (string, error) {
+	// the expressions are rewritten in a copy, the slices of the spec are shared with the caller
+	spec = *spec.DeepCopy()
+	// unset fields and their defaults behave the same
+	failurePolicy := spec.GetFailurePolicy()
+	spec.FailurePolicy = &failurePolicy
+	spec.EnforcementMode = spec.GetEnforcementMode()
+	spec.Combine = spec.GetCombine()
+	spec.Phase = spec.GetPhase()
+	// disabling a policy doesn't change what it does once enabled
+	spec.Disabled = false
+	// the scope is a set
+	spec.Scope = slices.Sorted(slices.Values(spec.Scope))
+	canonicalExpressions(env, &spec)
+	// structs are marshalled in the order of their fields
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// canonicalExpressions replaces the CEL expressions of the spec with their unparsed form
+func canonicalExpressions(env *cel.Env, spec *hub.AuthorizationPolicySpec) {
+	canonical := func(expression *string) {
+		*expression = canonicalExpression(env, *expression)
+	}
+	for _, conditions := range [][]admissionregistrationv1.MatchCondition{spec.TargetConditions, spec.MatchConditions, spec.ExcludeConditions} {
+		for i := range conditions {
+			canonical(&conditions[i].Expression)
+		}
+	}
+	for i := range spec.Variables {
+		canonical(&spec.Variables[i].Expression)
+	}
+	for i := range spec.Authorizations {
+		canonical(&spec.Authorizations[i].Match)
+		canonical(&spec.Authorizations[i].Reason)
+		canonical(&spec.Authorizations[i].Expression)
+	}
+	mutations := func(mutations []hub.HeaderMutation) {
+		for i := range mutations {
+			canonical(&mutations[i].Expression)
+		}
+	}
+	if headers := spec.Headers; headers != nil {
+		mutations(headers.Request)
+		mutations(headers.Response)
+		canonical(&headers.RequestMap)
+		canonical(&headers.ResponseMap)
+	}
+	if deny := spec.DenyResponse; deny != nil {
+		canonical(&deny.Status)
+		mutations(deny.Headers)
+		canonical(&deny.Body)
+		canonical(&deny.Location)
+		canonical(&deny.ContentType)
+		canonical(&deny.GrpcStatus)
+		canonical(&deny.GrpcMessage)
+	}
+	canonical(&spec.Reason)
+	if spec.Cache != nil {
+		canonical(&spec.Cache.Key)
+	}
+	if spec.Rollout != nil {
+		canonical(&spec.Rollout.Key)
+	}
+	if spec.RateLimit != nil {
+		canonical(&spec.RateLimit.Entries)
+	}
+}
+
+// canonicalExpression returns the unparsed form of a CEL expression, empty strings and expressions that don't parse
+// are returned as is
+func canonicalExpression(env *cel.Env, expression string) string {
+	if expression == "" {
+		return expression
+	}
+	ast, issues := env.Parse(expression)
+	if issues.Err() != nil {
+		return expression
+	}
+	if unparsed, err := cel.AstToString(ast); err == nil {
+		return unparsed
+	}
+	return expression
+}
@@ -0,0 +1,193 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/yaml"
+)
+
+const hashedPolicy = `
+metadata:
+  name: policy
+spec:
+  priority: 10
+  scope: [ingress, egress]
+  variables:
+  - name: user
+    expression: object.attributes.request.http.headers[?"x-user"].orValue("")
+  authorizations:
+  - match: variables.user == "alice"
+    expression: envoy.Allowed().Response()
+  - expression: envoy.Denied(403).Response()
+  cache:
+    key: variables.user
+    ttl: 1m
+`
+
+func hashPolicy(t *testing.T, document string, mutate func(*hub.AuthorizationPolicy)) string {
+	t.Helper()
+	var policy hub.AuthorizationPolicy
+	require.NoError(t, yaml.UnmarshalStrict([]byte(document), &policy))
+	if mutate != nil {
+		mutate(&policy)
+	}
+	compiled, errs := NewCompiler().Compile(&policy)
+	require.Empty(t, errs)
+	return compiled.Hash
+}
+
+func Test_compiler_Compile_hash(t *testing.T) {
+	want := hashPolicy(t, hashedPolicy, nil)
+	assert.Regexp(t, `^sha256:[0-9a-f]{64}$`, want)
+	tests := []struct {
+		name     string
+		document string
+		mutate   func(*hub.AuthorizationPolicy)
+		changed  bool
+	}{{
+		name: "reordered keys",
+		document: `
+spec:
+  cache:
+    ttl: 1m
+    key: variables.user
+  authorizations:
+  - expression: envoy.Allowed().Response()
+    match: variables.user == "alice"
+  - expression: envoy.Denied(403).Response()
+  variables:
+  - expression: object.attributes.request.http.headers[?"x-user"].orValue("")
+    name: user
+  scope: [ingress, egress]
+  priority: 10
+metadata:
+  name: policy
+`,
+	}, {
+		name:     "reordered scope",
+		document: hashedPolicy,
+		mutate:   func(p *hub.AuthorizationPolicy) { p.Spec.Scope = []string{"egress", "ingress"} },
+	}, {
+		name: "formatting and comments",
+		document: `
+metadata:
+  name: policy
+spec:
+  priority: 10
+  scope: [ingress, egress]
+  variables:
+  - name: user
+    expression: |
+      // the user set by the gateway
+      object.attributes.request.http.headers[?'x-user'].orValue( "" )
+  authorizations:
+  - match: |
+      variables.user   ==   "alice"
+    expression: envoy.Allowed().Response() // allow alice
+  - expression: |
+      envoy
+        .Denied(403)
+        .Response()
+  cache:
+    key: variables.user
+    ttl: 60s
+`,
+	}, {
+		name:     "metadata",
+		document: hashedPolicy,
+		mutate: func(p *hub.AuthorizationPolicy) {
+			p.Name = "renamed"
+			p.Generation = 7
+			p.Labels = map[string]string{"app": "demo"}
+			p.Annotations = map[string]string{"owner": "team-a", "description": "allows alice"}
+		},
+	}, {
+		name:     "disabled",
+		document: hashedPolicy,
+		mutate:   func(p *hub.AuthorizationPolicy) { p.Spec.Disabled = true },
+	}, {
+		name:     "explicit defaults",
+		document: hashedPolicy,
+		mutate: func(p *hub.AuthorizationPolicy) {
+			p.Spec.FailurePolicy = ptr.To(admissionregistrationv1.Fail)
+			p.Spec.EnforcementMode = hub.EnforcementModeEnforce
+			p.Spec.Combine = hub.CombineFirstMatch
+		},
+	}, {
+		name:     "rule",
+		document: hashedPolicy,
+		mutate:   func(p *hub.AuthorizationPolicy) { p.Spec.Authorizations[1].Expression = "envoy.Denied(401).Response()" },
+		changed:  true,
+	}, {
+		name:     "match",
+		document: hashedPolicy,
+		mutate:   func(p *hub.AuthorizationPolicy) { p.Spec.Authorizations[0].Match = `variables.user == "bob"` },
+		changed:  true,
+	}, {
+		name:     "rule order",
+		document: hashedPolicy,
+		mutate: func(p *hub.AuthorizationPolicy) {
+			a := p.Spec.Authorizations
+			a[0], a[1] = a[1], a[0]
+		},
+		changed: true,
+	}, {
+		name:     "priority",
+		document: hashedPolicy,
+		mutate:   func(p *hub.AuthorizationPolicy) { p.Spec.Priority = 20 },
+		changed:  true,
+	}, {
+		name:     "mode",
+		document: hashedPolicy,
+		mutate:   func(p *hub.AuthorizationPolicy) { p.Spec.EnforcementMode = hub.EnforcementModeAudit },
+		changed:  true,
+	}, {
+		name:     "string literal",
+		document: hashedPolicy,
+		mutate: func(p *hub.AuthorizationPolicy) {
+			p.Spec.Authorizations[0].Match = `variables.user == "alice "`
+		},
+		changed: true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := hashPolicy(t, tt.document, tt.mutate)
+			if tt.changed {
+				assert.NotEqual(t, want, got)
+			} else {
+				assert.Equal(t, want, got)
+			}
+		})
+	}
+}
+
+func Test_compiler_Compile_hashLiterals(t *testing.T) {
+	// strings that are not expressions are hashed as is, even when they parse as CEL
+	tests := []struct {
+		name   string
+		first  func(*hub.AuthorizationPolicy)
+		second func(*hub.AuthorizationPolicy)
+	}{{
+		name:   "rule name",
+		first:  func(p *hub.AuthorizationPolicy) { p.Spec.Authorizations[0].Name = "a  +b" },
+		second: func(p *hub.AuthorizationPolicy) { p.Spec.Authorizations[0].Name = "a+b" },
+	}, {
+		name:   "scope quoting",
+		first:  func(p *hub.AuthorizationPolicy) { p.Spec.Scope = []string{`'x'`} },
+		second: func(p *hub.AuthorizationPolicy) { p.Spec.Scope = []string{`"x"`} },
+	}, {
+		name:   "scope whitespace",
+		first:  func(p *hub.AuthorizationPolicy) { p.Spec.Scope = []string{"ingress"} },
+		second: func(p *hub.AuthorizationPolicy) { p.Spec.Scope = []string{" ingress"} },
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NotEqual(t, hashPolicy(t, hashedPolicy, tt.first), hashPolicy(t, hashedPolicy, tt.second))
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
+	"google.golang.org/grpc/codes"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+type headerMutation struct {
+	name   string
+	action hub.HeaderAction
+	value  cel.Program
+}
+
+type compiledHeaders struct {
+	request  []headerMutation
+	response []headerMutation
+	// requestMap and responseMap are nil when the policy doesn't compute headers
+	requestMap  cel.Program
+	responseMap cel.Program
+}
+
+// headersType is the type of the expressions computing headers
+var headersType = types.NewMapType(types.StringType, types.StringType)
+
+func compileHeaders(env *compileEnv, programOptions []cel.ProgramOption, path *field.Path, headers *hub.Headers) (compiledHeaders, field.ErrorList) {
+	var out compiledHeaders
+	if headers == nil {
+		return out, nil
+	}
+	request, errs := compileHeaderMutations(env, programOptions, path.Child("request"), headers.Request, true)
+	if len(errs) > 0 {
+		return out, errs
+	}
+	response, errs := compileHeaderMutations(env, programOptions, path.Child("response"), headers.Response, false)
+	if len(errs) > 0 {
+		return out, errs
+	}
+	requestMap, errs := compileHeadersMap(env, programOptions, path.Child("requestMap"), headers.RequestMap)
+	if len(errs) > 0 {
+		return out, errs
+	}
+	responseMap, errs := compileHeadersMap(env, programOptions, path.Child("responseMap"), headers.ResponseMap)
+	if len(errs) > 0 {
+		return out, errs
+	}
+	out.request = request
+	out.response = response
+	out.requestMap = requestMap
+	out.responseMap = responseMap
+	return out, nil
+}
+
+func compileHeadersMap(env *compileEnv, programOptions []cel.ProgramOption, path *field.Path, expression string) (cel.Program, field.ErrorList) {
+	if expression == "" {
+		return nil, nil
+	}
+	ast, errs := compileExpression(env, path, expression)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if err := outputTypeError(ast, path, expression, "headers map", headersType); err != nil {
+		return nil, field.ErrorList{err}
+	}
+	prog, err := env.Program(ast, programOptions...)
+	if err != nil {
+		return nil, field.ErrorList{field.Invalid(path, expression, err.Error())}
+	}
+	return prog, nil
+}
+
+func compileHeaderMutations(env *compileEnv, programOptions []cel.ProgramOption, path *field.Path, mutations []hub.HeaderMutation, allowRemove bool) ([]headerMutation, field.ErrorList) {
+	out := make([]headerMutation, 0, len(mutations))
+	for i, mutation := range mutations {
+		path := path.Index(i)
+		if mutation.Name == "" {
+			return nil, field.ErrorList{field.Required(path.Child("name"), "header name is required")}
+		}
+		action := mutation.GetAction()
+		switch action {
+		case hub.HeaderActionSet, hub.HeaderActionAppend:
+		case hub.HeaderActionRemove:
+			if !allowRemove {
+				return nil, field.ErrorList{field.NotSupported(path.Child("action"), action, []hub.HeaderAction{hub.HeaderActionSet, hub.HeaderActionAppend})}
+			}
+			// nothing to compile
+			out = append(out, headerMutation{name: mutation.Name, action: action})
+			continue
+		default:
+			return nil, field.ErrorList{field.NotSupported(path.Child("action"), action, []hub.HeaderAction{hub.HeaderActionSet, hub.HeaderActionAppend, hub.HeaderActionRemove})}
+		}
+		ast, errs := compileExpression(env, path.Child("expression"), mutation.Expression)
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		if err := outputTypeError(ast, path.Child("expression"), mutation.Expression, "header", types.StringType); err != nil {
+			return nil, field.ErrorList{err}
+		}
+		prog, err := env.Program(ast, programOptions...)
+		if err != nil {
+			return nil, field.ErrorList{field.Invalid(path.Child("expression"), mutation.Expression, err.Error())}
+		}
+		out = append(out, headerMutation{name: mutation.Name, action: action, value: prog})
+	}
+	return out, nil
+}
+
+// apply adds the header mutations to the response, request mutations are applied
+// to allowed responses and response mutations are applied to denied responses
+func (h compiledHeaders) apply(ctx context.Context, response *authv3.CheckResponse, data map[string]any) error {
+	if response.GetStatus().GetCode() == int32(codes.OK) {
+		if len(h.request) == 0 && h.requestMap == nil {
+			return nil
+		}
+		ok := response.GetOkResponse()
+		if ok == nil {
+			ok = &authv3.OkHttpResponse{}
+			response.HttpResponse = &authv3.CheckResponse_OkResponse{OkResponse: ok}
+		}
+		for _, mutation := range h.request {
+			if mutation.action == hub.HeaderActionRemove {
+				ok.HeadersToRemove = append(ok.HeadersToRemove, mutation.name)
+				continue
+			}
+			header, err := mutation.eval(ctx, data)
+			if err != nil {
+				return err
+			}
+			ok.Headers = append(ok.Headers, header)
+		}
+		computed, err := evalHeadersMap(ctx, h.requestMap, h.request, data)
+		if err != nil {
+			return err
+		}
+		ok.Headers = append(ok.Headers, computed...)
+		return nil
+	}
+	if len(h.response) == 0 && h.responseMap == nil {
+		return nil
+	}
+	denied := response.GetDeniedResponse()
+	if denied == nil {
+		denied = &authv3.DeniedHttpResponse{}
+		response.HttpResponse = &authv3.CheckResponse_DeniedResponse{DeniedResponse: denied}
+	}
+	for _, mutation := range h.response {
+		header, err := mutation.eval(ctx, data)
+		if err != nil {
+			return err
+		}
+		denied.Headers = append(denied.Headers, header)
+	}
+	computed, err := evalHeadersMap(ctx, h.responseMap, h.response, data)
+	if err != nil {
+		return err
+	}
+	denied.Headers = append(denied.Headers, computed...)
+	return nil
+}
+
+// evalHeadersMap returns the headers computed by the map expression sorted by name, the headers mutated by the
+// static mutations are skipped so that the mutations take precedence (header names are case insensitive)
+func evalHeadersMap(ctx context.Context, prog cel.Program, mutations []headerMutation, data map[string]any) ([]*corev3.HeaderValueOption, error) {
+	if prog == nil {
+		return nil, nil
+	}
+	out, details, err := prog.ContextEval(ctx, data)
+	recordCost(ctx, details)
+	if err != nil {
+		return nil, err
+	}
+	values, err := utils.ConvertToNative[map[string]string](out)
+	if err != nil {
+		return nil, err
+	}
+	static := sets.New[string]()
+	for _, mutation := range mutations {
+		static.Insert(strings.ToLower(mutation.name))
+	}
+	headers := make([]*corev3.HeaderValueOption, 0, len(values))
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		if name == "" {
+			return nil, fmt.Errorf("computed header names can't be empty")
+		}
+		if static.Has(strings.ToLower(name)) {
+			continue
+		}
+		headers = append(headers, &corev3.HeaderValueOption{
+			Header:       &corev3.HeaderValue{Key: name, Value: values[name]},
+			AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+		})
+	}
+	return headers, nil
+}
+
+func (m headerMutation) eval(ctx context.Context, data map[string]any) (*corev3.HeaderValueOption, error) {
+	out, details, err := m.value.ContextEval(ctx, data)
+	recordCost(ctx, details)
+	if err != nil {
+		return nil, err
+	}
+	value, err := utils.ConvertToNative[string](out)
+	if err != nil {
+		return nil, err
+	}
+	action := corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD
+	if m.action == hub.HeaderActionAppend {
+		action = corev3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD
+	}
+	return &corev3.HeaderValueOption{
+		Header:       &corev3.HeaderValue{Key: m.name, Value: value},
+		AppendAction: action,
+	}, nil
+}
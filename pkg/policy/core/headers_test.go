@@ -0,0 +1,190 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func Test_compiler_Compile_headers(t *testing.T) {
+	request := &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Headers: map[string]string{"x-user": "alice"},
+				},
+			},
+		},
+	}
+	headers := &hub.Headers{
+		Request: []hub.HeaderMutation{
+			{Name: "x-auth-subject", Expression: `object.attributes.request.http.headers["x-user"]`},
+			{Name: "x-trace", Action: hub.HeaderActionAppend, Expression: `"kyverno"`},
+			{Name: "x-user", Action: hub.HeaderActionRemove},
+		},
+		Response: []hub.HeaderMutation{
+			{Name: "www-authenticate", Expression: `"Bearer realm=" + object.attributes.request.http.headers["x-user"]`},
+		},
+	}
+	t.Run("allowed", func(t *testing.T) {
+		policy := newPolicy("policy", `envoy.Allowed().Response()`)
+		policy.Spec.Headers = headers
+		compiled, errs := NewCompiler().Compile(policy)
+		assert.Empty(t, errs)
+		response, err := compiled.Evaluate(context.Background(), request)
+		assert.NoError(t, err)
+		ok := response.GetOkResponse()
+		assert.Equal(t, []*corev3.HeaderValueOption{{
+			Header:       &corev3.HeaderValue{Key: "x-auth-subject", Value: "alice"},
+			AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+		}, {
+			Header:       &corev3.HeaderValue{Key: "x-trace", Value: "kyverno"},
+			AppendAction: corev3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD,
+		}}, ok.GetHeaders())
+		assert.Equal(t, []string{"x-user"}, ok.GetHeadersToRemove())
+	})
+	t.Run("denied", func(t *testing.T) {
+		policy := newPolicy("policy", `envoy.Denied(401).Response()`)
+		policy.Spec.Headers = headers
+		compiled, errs := NewCompiler().Compile(policy)
+		assert.Empty(t, errs)
+		response, err := compiled.Evaluate(context.Background(), request)
+		assert.NoError(t, err)
+		assert.Equal(t, []*corev3.HeaderValueOption{{
+			Header:       &corev3.HeaderValue{Key: "www-authenticate", Value: "Bearer realm=alice"},
+			AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+		}}, response.GetDeniedResponse().GetHeaders())
+	})
+	t.Run("no decision", func(t *testing.T) {
+		policy := newPolicy("policy", `false ? envoy.Allowed().Response() : null`)
+		policy.Spec.Headers = headers
+		compiled, errs := NewCompiler().Compile(policy)
+		assert.Empty(t, errs)
+		response, err := compiled.Evaluate(context.Background(), request)
+		assert.NoError(t, err)
+		assert.Nil(t, response)
+	})
+}
+
+func Test_compiler_Compile_headersMap(t *testing.T) {
+	request := &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Headers: map[string]string{"x-user": "alice", "x-tenant": "acme"},
+				},
+			},
+		},
+	}
+	headers := &hub.Headers{
+		Request: []hub.HeaderMutation{
+			{Name: "x-auth-subject", Expression: `object.attributes.request.http.headers["x-user"]`},
+		},
+		// header names are computed from the request, the static mutation takes precedence
+		RequestMap: `{
+			"x-" + object.attributes.request.http.headers["x-tenant"] + "-user": object.attributes.request.http.headers["x-user"],
+			"x-auth-tenant": object.attributes.request.http.headers["x-tenant"],
+			"X-Auth-Subject": "overridden"
+		}`,
+		ResponseMap: `{"x-denied-" + object.attributes.request.http.headers["x-tenant"]: "true"}`,
+	}
+	t.Run("allowed", func(t *testing.T) {
+		policy := newPolicy("policy", `envoy.Allowed().Response()`)
+		policy.Spec.Headers = headers
+		compiled, errs := NewCompiler().Compile(policy)
+		assert.Empty(t, errs)
+		response, err := compiled.Evaluate(context.Background(), request)
+		assert.NoError(t, err)
+		assert.Equal(t, []*corev3.HeaderValueOption{{
+			Header:       &corev3.HeaderValue{Key: "x-auth-subject", Value: "alice"},
+			AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+		}, {
+			Header:       &corev3.HeaderValue{Key: "x-acme-user", Value: "alice"},
+			AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+		}, {
+			Header:       &corev3.HeaderValue{Key: "x-auth-tenant", Value: "acme"},
+			AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+		}}, response.GetOkResponse().GetHeaders())
+	})
+	t.Run("denied", func(t *testing.T) {
+		policy := newPolicy("policy", `envoy.Denied(403).Response()`)
+		policy.Spec.Headers = headers
+		compiled, errs := NewCompiler().Compile(policy)
+		assert.Empty(t, errs)
+		response, err := compiled.Evaluate(context.Background(), request)
+		assert.NoError(t, err)
+		assert.Equal(t, []*corev3.HeaderValueOption{{
+			Header:       &corev3.HeaderValue{Key: "x-denied-acme", Value: "true"},
+			AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+		}}, response.GetDeniedResponse().GetHeaders())
+	})
+	t.Run("empty name", func(t *testing.T) {
+		policy := newPolicy("policy", `envoy.Allowed().Response()`)
+		policy.Spec.Headers = &hub.Headers{RequestMap: `{object.attributes.request.http.headers[?"x-missing"].orValue(""): "foo"}`}
+		compiled, errs := NewCompiler().Compile(policy)
+		assert.Empty(t, errs)
+		_, err := compiled.Evaluate(context.Background(), request)
+		assert.ErrorContains(t, err, "computed header names can't be empty")
+	})
+}
+
+func Test_compiler_Compile_headers_errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers *hub.Headers
+	}{{
+		name: "missing name",
+		headers: &hub.Headers{
+			Request: []hub.HeaderMutation{{Expression: `"foo"`}},
+		},
+	}, {
+		name: "invalid output type",
+		headers: &hub.Headers{
+			Request: []hub.HeaderMutation{{Name: "x-foo", Expression: `1`}},
+		},
+	}, {
+		name: "remove response header",
+		headers: &hub.Headers{
+			Response: []hub.HeaderMutation{{Name: "x-foo", Action: hub.HeaderActionRemove}},
+		},
+	}, {
+		name: "request map of int values",
+		headers: &hub.Headers{
+			RequestMap: `{"x-foo": 1}`,
+		},
+	}, {
+		name: "response map of a list",
+		headers: &hub.Headers{
+			ResponseMap: `["x-foo"]`,
+		},
+	}, {
+		name: "request map of dynamic values",
+		headers: &hub.Headers{
+			RequestMap: `{"x-foo": "bar", "x-bar": 1}`,
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", `envoy.Allowed().Response()`)
+			policy.Spec.Headers = tt.headers
+			_, errs := NewCompiler().Compile(policy)
+			assert.NotEmpty(t, errs)
+		})
+	}
+}
+
+func Test_compiler_Compile_headersMap_typeError(t *testing.T) {
+	policy := newPolicy("policy", `envoy.Allowed().Response()`)
+	policy.Spec.Headers = &hub.Headers{RequestMap: `{"x-foo": 1}`}
+	_, errs := NewCompiler().Compile(policy)
+	if assert.Len(t, errs, 1) {
+		assert.Equal(t, "spec.headers.requestMap", errs[0].Field)
+		assert.Equal(t, field.ErrorTypeTypeInvalid, errs[0].Type)
+		assert.Contains(t, errs[0].Detail, "headers map output is expected to be of type map(string, string), got map(string, int)")
+	}
+}
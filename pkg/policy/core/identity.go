@@ -0,0 +1,227 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	engine "github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apiserver/pkg/cel/lazy"
+)
+
+// IdentityType is the type of the identity variable, it holds the caller identity resolved by the identity sources:
+//   - subject is the subject of the first source resolving one, empty when no source did
+//   - groups are the groups returned by the same source, empty when it has no groups expression
+//   - source is the name of the source the identity was resolved by, empty when no source did
+var IdentityType = types.NewMapType(types.StringType, types.DynType)
+
+// identityFields are the fields of the identity variable
+var identityFields = []mapField{
+	{name: "subject", celType: types.StringType},
+	{name: "groups", celType: types.NewListType(types.StringType)},
+	{name: "source", celType: types.StringType},
+}
+
+// IdentitySource resolves the caller identity from the request, the expressions are compiled in the policy
+// expressions environment without the variables, data and identity variables
+type IdentitySource struct {
+	// Name identifies the source in the identity variable
+	Name string `json:"name"`
+	// Subject is the expression returning the subject, the source doesn't resolve the identity when it returns
+	// an empty string and the next source is tried
+	Subject string `json:"subject"`
+	// Groups is the optional expression returning the groups of the subject as a list of strings
+	Groups string `json:"groups,omitempty"`
+}
+
+// WithIdentitySources resolves the identity variable of policy expressions by trying the sources in order,
+// the first source returning a non-empty subject wins. The identity is resolved once per check request.
+func WithIdentitySources(sources ...IdentitySource) CompilerOption {
+	return func(o *compilerOptions) {
+		o.identitySources = append(o.identitySources, sources...)
+	}
+}
+
+// ValidateIdentitySources compiles the identity sources of the options, the errors are the ones every
+// policy compilation would report
+func ValidateIdentitySources(opts ...CompilerOption) field.ErrorList {
+	var options compilerOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	_, errs := compileIdentityChain(&compiler{options: options})
+	return errs
+}
+
+type identitySource struct {
+	name    string
+	path    *field.Path
+	subject cel.Program
+	groups  cel.Program
+}
+
+// identityChain resolves the identity variable, it is compiled once per compiler so that the policies
+// evaluating the same request share the resolved identity
+type identityChain struct {
+	sources []identitySource
+	// headers are the request headers read by the sources, they are read by any policy using the identity
+	headers HeaderUsage
+	// body is true when the sources read the request body
+	body bool
+	// volatile are the volatile functions called by the sources, a policy reading the identity calls them
+	volatile sets.Set[string]
+}
+
+// identityChainOnce compiles the identity chain of a compiler the first time a policy is compiled
+type identityChainOnce struct {
+	once  sync.Once
+	chain *identityChain
+	errs  field.ErrorList
+}
+
+func (o *identityChainOnce) get(c *compiler) (*identityChain, field.ErrorList) {
+	o.once.Do(func() {
+		o.chain, o.errs = compileIdentityChain(c)
+	})
+	return o.chain, o.errs
+}
+
+// identityVariables are the variables the identity sources can't read, they are evaluated per policy
+var identityVariables = []string{VariablesKey, DataKey, IdentityKey}
+
+func compileIdentityChain(c *compiler) (*identityChain, field.ErrorList) {
+	base, err := engine.NewEnv(c.options.libraries...)
+	if err != nil {
+		return nil, field.ErrorList{field.InternalError(nil, err)}
+	}
+	declarations := func(legacy bool) []cel.EnvOption {
+		var options []cel.EnvOption
+		for _, variable := range variables {
+			if !slices.Contains(identityVariables, variable.name) {
+				options = append(options, cel.Variable(variable.name, variable.declaredType(legacy)))
+			}
+		}
+		return options
+	}
+	analyzer := newHeaderAnalyzer()
+	volatility := newVolatilityAnalyzer()
+	env, err := newCompileEnv(base, declarations, cel.CustomTypeProvider(newInputTypeProvider(base.CELTypeProvider())), cel.ASTValidators(c.validators(analyzer, volatility)...))
+	if err != nil {
+		return nil, field.ErrorList{field.InternalError(nil, err)}
+	}
+	programOptions := c.programOptions()
+	chain := &identityChain{}
+	path := field.NewPath("identity", "sources")
+	names := map[string]bool{}
+	for i, source := range c.options.identitySources {
+		path := path.Index(i)
+		if source.Name == "" {
+			return nil, field.ErrorList{field.Required(path.Child("name"), "an identity source must have a name")}
+		}
+		if names[source.Name] {
+			return nil, field.ErrorList{field.Duplicate(path.Child("name"), source.Name)}
+		}
+		names[source.Name] = true
+		compiled := identitySource{name: source.Name, path: path}
+		ast, errs := compileExpression(env, path.Child("subject"), source.Subject)
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		if !ast.OutputType().IsExactType(types.DynType) {
+			if err := outputTypeError(ast, path.Child("subject"), source.Subject, "identity subject", types.StringType); err != nil {
+				return nil, field.ErrorList{err}
+			}
+		}
+		if compiled.subject, err = env.Program(ast, programOptions...); err != nil {
+			return nil, field.ErrorList{field.Invalid(path.Child("subject"), source.Subject, err.Error())}
+		}
+		if source.Groups != "" {
+			ast, errs := compileExpression(env, path.Child("groups"), source.Groups)
+			if len(errs) > 0 {
+				return nil, errs
+			}
+			// the elements of dynamic lists are checked when the identity is resolved
+			switch out := ast.OutputType(); {
+			case out.IsExactType(types.DynType), out.IsExactType(types.NewListType(types.DynType)):
+			default:
+				if err := outputTypeError(ast, path.Child("groups"), source.Groups, "identity groups", types.NewListType(types.StringType)); err != nil {
+					return nil, field.ErrorList{err}
+				}
+			}
+			if compiled.groups, err = env.Program(ast, programOptions...); err != nil {
+				return nil, field.ErrorList{field.Invalid(path.Child("groups"), source.Groups, err.Error())}
+			}
+		}
+		chain.sources = append(chain.sources, compiled)
+	}
+	chain.headers = analyzer.usage()
+	chain.body = analyzer.body
+	chain.volatile = volatility.calls
+	return chain, nil
+}
+
+// resolve tries the sources in order and returns the identity of the first one returning a non-empty subject,
+// errors are prefixed with the path of the failing expression
+func (c *identityChain) resolve(ctx context.Context, data map[string]any) (map[string]any, error) {
+	for _, source := range c.sources {
+		out, details, err := source.subject.ContextEval(ctx, data)
+		recordCost(ctx, details)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", source.path.Child("subject"), err)
+		}
+		subject, err := utils.ConvertToNative[string](out)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", source.path.Child("subject"), err)
+		}
+		if subject == "" {
+			continue
+		}
+		groups := []string{}
+		if source.groups != nil {
+			out, details, err := source.groups.ContextEval(ctx, data)
+			recordCost(ctx, details)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", source.path.Child("groups"), err)
+			}
+			if groups, err = utils.ConvertToNative[[]string](out); err != nil {
+				return nil, fmt.Errorf("%s: %w", source.path.Child("groups"), err)
+			}
+		}
+		return map[string]any{"subject": subject, "groups": groups, "source": source.name}, nil
+	}
+	return map[string]any{"subject": "", "groups": []string{}, "source": ""}, nil
+}
+
+// newIdentity returns the identity variable of a policy evaluation, the identity is resolved the first time
+// an expression reads it and shared by the policies evaluating the same request
+func newIdentity(ctx context.Context, chain *identityChain, data map[string]any) *lazy.MapValue {
+	key := fmt.Sprintf("identity:%p", chain)
+	resolved := func() ref.Val {
+		return utils.MemoizedValue(ctx, key, func() ref.Val {
+			identity, err := chain.resolve(ctx, data)
+			if err != nil {
+				return types.WrapErr(fmt.Errorf("failed to resolve the identity, %w", err))
+			}
+			return types.DefaultTypeAdapter.NativeToValue(identity)
+		})
+	}
+	value := lazy.NewMapValue(IdentityType)
+	for _, f := range identityFields {
+		value.Append(f.name, func(*lazy.MapValue) ref.Val {
+			identity := resolved()
+			if types.IsError(identity) {
+				return identity
+			}
+			return identity.(traits.Mapper).Get(types.String(f.name))
+		})
+	}
+	return value
+}
@@ -0,0 +1,241 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// identitySources resolve the identity from the verified token, then from the header set by the gateway
+var identitySources = WithIdentitySources(IdentitySource{
+	Name:    "jwt",
+	Subject: `auth.jwt.?jwt_payload.?sub.orValue("")`,
+	Groups:  `auth.jwt.?jwt_payload.?groups.orValue([])`,
+}, IdentitySource{
+	Name:    "header",
+	Subject: `object.attributes.request.http.headers[?"x-user"].orValue("")`,
+	Groups:  `object.attributes.request.http.headers[?"x-groups"].orValue("").split(",").filter(g, g != "")`,
+})
+
+func newTokenRequest(t *testing.T, sub string, groups ...any) *authv3.CheckRequest {
+	t.Helper()
+	payload, err := structpb.NewStruct(map[string]any{
+		"jwt_payload": map[string]any{"sub": sub, "groups": groups},
+	})
+	assert.NoError(t, err)
+	request := newHttpRequest("GET", "/")
+	request.Attributes.MetadataContext = &corev3.Metadata{
+		FilterMetadata: map[string]*structpb.Struct{jwtAuthnFilter: payload},
+	}
+	return request
+}
+
+func Test_compiler_Compile_identity(t *testing.T) {
+	token := newTokenRequest(t, "alice", "admin")
+	token.Attributes.Request.Http.Headers["x-user"] = "bob"
+	header := newUserRequest("bob")
+	header.Attributes.Request.Http.Headers["x-groups"] = "dev,ops"
+	anonymous := newTokenRequest(t, "")
+	anonymous.Attributes.Request.Http.Headers["x-user"] = "bob"
+	tests := []struct {
+		name    string
+		request *authv3.CheckRequest
+		want    string
+	}{{
+		name:    "token wins over the header",
+		request: token,
+		want:    "jwt:alice:[admin]",
+	}, {
+		name:    "header when there is no token",
+		request: header,
+		want:    "header:bob:[dev ops]",
+	}, {
+		name:    "header when the token has no subject",
+		request: anonymous,
+		want:    "header:bob:[]",
+	}, {
+		name:    "no source resolves the identity",
+		request: newHttpRequest("GET", "/"),
+		want:    "::[]",
+	}, {
+		name:    "empty request",
+		request: &authv3.CheckRequest{},
+		want:    "::[]",
+	}}
+	policy := newPolicy("policy", `envoy.Denied(403).Response().WithMessage(identity.source + ":" + identity.subject + ":[" + identity.groups.join(" ") + "]")`)
+	compiled, errs := NewCompiler(identitySources).Compile(policy)
+	assert.Empty(t, errs)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := compiled.Evaluate(context.Background(), tt.request)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, response.GetStatus().GetMessage())
+		})
+	}
+}
+
+func Test_compiler_Compile_identityPolicy(t *testing.T) {
+	policy := newPolicy("policy", `identity.subject != "" && "admin" in identity.groups ? envoy.Allowed().Response() : envoy.Denied(403).Response()`)
+	compiled, errs := NewCompiler(identitySources).Compile(policy)
+	assert.Empty(t, errs)
+	// the headers read by the sources are read by the policy
+	assert.Equal(t, HeaderUsage{Names: []string{"x-groups", "x-user"}}, compiled.RequestHeaders)
+	for request, want := range map[*authv3.CheckRequest]codes.Code{
+		newTokenRequest(t, "alice", "admin"): codes.OK,
+		newTokenRequest(t, "alice", "dev"):   codes.PermissionDenied,
+		newHttpRequest("GET", "/"):           codes.PermissionDenied,
+	} {
+		response, err := compiled.Evaluate(context.Background(), request)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(want), response.GetStatus().GetCode())
+	}
+	// policies not reading the identity don't read the headers of the sources
+	compiled, errs = NewCompiler(identitySources).Compile(newPolicy("policy", `envoy.Allowed().Response()`))
+	assert.Empty(t, errs)
+	assert.Equal(t, HeaderUsage{Names: []string{}}, compiled.RequestHeaders)
+}
+
+func Test_compiler_Compile_identityNoSources(t *testing.T) {
+	policy := newPolicy("policy", `identity.subject == "" && identity.source == "" && size(identity.groups) == 0 ? envoy.Allowed().Response() : envoy.Denied(403).Response()`)
+	compiled, errs := NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	response, err := compiled.Evaluate(context.Background(), newUserRequest("bob"))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(codes.OK), response.GetStatus().GetCode())
+}
+
+func Test_compiler_Compile_identityShared(t *testing.T) {
+	compiler := NewCompiler(identitySources)
+	first, errs := compiler.Compile(newPolicy("first", `identity.subject == "bob" ? envoy.Allowed().Response() : null`))
+	assert.Empty(t, errs)
+	second, errs := compiler.Compile(newPolicy("second", `identity.source == "header" ? envoy.Allowed().Response() : null`))
+	assert.Empty(t, errs)
+	// the policies evaluating the same request resolve the identity once
+	ctx, memo := utils.WithMemo(context.Background())
+	for _, policy := range []CompiledPolicy{first, second} {
+		response, err := policy.Evaluate(ctx, newUserRequest("bob"))
+		assert.NoError(t, err)
+		assert.Equal(t, int32(codes.OK), response.GetStatus().GetCode())
+	}
+	assert.Equal(t, 1, memo.Len())
+}
+
+func Test_compiler_Compile_identityCache(t *testing.T) {
+	// the source verifies the token, the identity is volatile
+	sources := WithIdentitySources(IdentitySource{
+		Name:    "token",
+		Subject: `jwt.Verify(object.attributes.request.http.headers[?"authorization"].orValue(""), "secret").Claims.?sub.orValue("")`,
+	})
+	tests := []struct {
+		name       string
+		expression string
+		key        string
+		wantErr    string
+	}{{
+		name:       "identity not captured",
+		expression: `identity.subject == "alice" ? envoy.Allowed().Response() : null`,
+		key:        `object.attributes.request.http.method`,
+		wantErr:    "the policy calls jwt.Verify, the key must call the same functions for decisions to be cached",
+	}, {
+		name:       "identity captured",
+		expression: `identity.subject == "alice" ? envoy.Allowed().Response() : null`,
+		key:        `string(identity.subject)`,
+	}, {
+		name:       "identity not read",
+		expression: `envoy.Allowed().Response()`,
+		key:        `object.attributes.request.http.method`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", tt.expression)
+			policy.Spec.Cache = &hub.DecisionCache{Key: tt.key, TTL: metav1.Duration{Duration: time.Minute}}
+			_, errs := NewCompiler(sources).Compile(policy)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, errs.ToAggregate(), tt.wantErr)
+				return
+			}
+			assert.Empty(t, errs)
+		})
+	}
+}
+
+func Test_compiler_Compile_identityEvaluationError(t *testing.T) {
+	sources := WithIdentitySources(IdentitySource{Name: "header", Subject: `object.attributes.request.http.headers["x-user"]`})
+	compiled, errs := NewCompiler(sources).Compile(newPolicy("policy", `identity.subject == "bob" ? envoy.Allowed().Response() : null`))
+	assert.Empty(t, errs)
+	_, err := compiled.Evaluate(context.Background(), newHttpRequest("GET", "/"))
+	var evalErr *EvaluationError
+	if assert.True(t, errors.As(err, &evalErr)) {
+		assert.Equal(t, "spec.authorizations[0].expression", evalErr.Field)
+		assert.ErrorContains(t, err, "failed to resolve the identity, identity.sources[0].subject")
+	}
+}
+
+func TestValidateIdentitySources(t *testing.T) {
+	tests := []struct {
+		name      string
+		sources   []IdentitySource
+		wantField string
+		wantType  field.ErrorType
+	}{{
+		name:    "valid",
+		sources: []IdentitySource{{Name: "header", Subject: `request.headers[?"x-user"].orValue("")`, Groups: `["dev"]`}},
+	}, {
+		name:      "missing name",
+		sources:   []IdentitySource{{Subject: `"alice"`}},
+		wantField: "identity.sources[0].name",
+		wantType:  field.ErrorTypeRequired,
+	}, {
+		name:      "duplicate name",
+		sources:   []IdentitySource{{Name: "a", Subject: `"alice"`}, {Name: "a", Subject: `"bob"`}},
+		wantField: "identity.sources[1].name",
+		wantType:  field.ErrorTypeDuplicate,
+	}, {
+		name:      "subject syntax error",
+		sources:   []IdentitySource{{Name: "a", Subject: `request.`}},
+		wantField: "identity.sources[0].subject",
+		wantType:  field.ErrorTypeInvalid,
+	}, {
+		name:      "subject output type",
+		sources:   []IdentitySource{{Name: "a", Subject: `42`}},
+		wantField: "identity.sources[0].subject",
+		wantType:  field.ErrorTypeTypeInvalid,
+	}, {
+		name:      "groups output type",
+		sources:   []IdentitySource{{Name: "a", Subject: `"alice"`, Groups: `"admin"`}},
+		wantField: "identity.sources[0].groups",
+		wantType:  field.ErrorTypeTypeInvalid,
+	}, {
+		name:      "sources can't read the identity",
+		sources:   []IdentitySource{{Name: "a", Subject: `identity.subject`}},
+		wantField: "identity.sources[0].subject",
+		wantType:  field.ErrorTypeTypeInvalid,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := ValidateIdentitySources(WithIdentitySources(tt.sources...))
+			if tt.wantField == "" {
+				assert.Empty(t, errs)
+				return
+			}
+			if assert.Len(t, errs, 1) {
+				assert.Equal(t, tt.wantField, errs[0].Field)
+				assert.Equal(t, tt.wantType, errs[0].Type)
+			}
+			// every policy compilation reports the error
+			_, errs = NewCompiler(WithIdentitySources(tt.sources...)).Compile(newPolicy("policy", `envoy.Allowed().Response()`))
+			assert.Len(t, errs, 1)
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+// legacyInputType is the type the request, source and destination variables had before they declared their
+// fields, expressions accessing them as maps are compiled against it
+var legacyInputType = types.NewMapType(types.StringType, types.DynType)
+
+// inputTypes maps the declared input types to their fields, they are derived from the fields of the variables
+var inputTypes = func() map[string][]mapField {
+	out := map[string][]mapField{}
+	var register func(celType *types.Type, fields []mapField)
+	register = func(celType *types.Type, fields []mapField) {
+		// the message types and the variables type declare their fields themselves
+		if celType.Kind() != types.StructKind || len(fields) == 0 {
+			return
+		}
+		out[celType.TypeName()] = fields
+		for _, field := range fields {
+			register(field.celType, field.fields)
+		}
+	}
+	for _, variable := range variables {
+		register(variable.celType, variable.fields)
+	}
+	return out
+}()
+
+// isInputType returns true for the declared input types, their values are maps at runtime
+func isInputType(celType *types.Type) bool {
+	_, ok := inputTypes[celType.TypeName()]
+	return ok && celType.Kind() == types.StructKind
+}
+
+// inputTypeProvider declares the input types on top of an inner provider, like the variables provider declares the
+// variables type. The fields of the input types are read from the maps the variables are bound to.
+type inputTypeProvider struct {
+	types.Provider
+}
+
+func newInputTypeProvider(inner types.Provider) types.Provider {
+	return &inputTypeProvider{Provider: inner}
+}
+
+func (p *inputTypeProvider) FindStructType(structType string) (*types.Type, bool) {
+	if _, ok := inputTypes[structType]; ok {
+		return types.NewTypeTypeWithParam(types.NewObjectType(structType)), true
+	}
+	return p.Provider.FindStructType(structType)
+}
+
+func (p *inputTypeProvider) FindStructFieldNames(structType string) ([]string, bool) {
+	if fields, ok := inputTypes[structType]; ok {
+		names := make([]string, 0, len(fields))
+		for _, field := range fields {
+			names = append(names, field.name)
+		}
+		return names, true
+	}
+	return p.Provider.FindStructFieldNames(structType)
+}
+
+func (p *inputTypeProvider) FindStructFieldType(structType, fieldName string) (*types.FieldType, bool) {
+	if fields, ok := inputTypes[structType]; ok {
+		for _, field := range fields {
+			if field.name == fieldName {
+				return &types.FieldType{Type: field.celType}, true
+			}
+		}
+		return nil, false
+	}
+	return p.Provider.FindStructFieldType(structType, fieldName)
+}
+
+func (p *inputTypeProvider) NewValue(structType string, fields map[string]ref.Val) ref.Val {
+	if _, ok := inputTypes[structType]; ok {
+		return types.NewErr("type %s can't be created", structType)
+	}
+	return p.Provider.NewValue(structType, fields)
+}
+
+// compileEnv is the environment policy expressions are compiled in. Expressions are type checked against the
+// declared input types, the expressions accessing the inputs as maps (indexing, iterating or testing the keys of
+// request for example) are compiled against the legacy map types instead, as they used to be.
+type compileEnv struct {
+	*cel.Env
+	legacy *cel.Env
+}
+
+// newCompileEnv extends the base environment with the variables, the options apply to both environments
+func newCompileEnv(base *cel.Env, declarations func(legacy bool) []cel.EnvOption, opts ...cel.EnvOption) (*compileEnv, error) {
+	env, err := base.Extend(append(declarations(false), opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	legacy, err := base.Extend(append(declarations(true), opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &compileEnv{Env: env, legacy: legacy}, nil
+}
+
+// undefinedFieldPrefix prefixes the message of the checker errors selecting a field a type doesn't declare
+const undefinedFieldPrefix = "undefined field '"
+
+// checkExpression type checks a parsed expression, a typo in a field of the inputs is never compiled against the
+// legacy map types
+func (e *compileEnv) checkExpression(parsed *cel.Ast, expression string) (*cel.Ast, error) {
+	checked, issues := e.Check(parsed)
+	if issues.Err() == nil {
+		return checked, nil
+	}
+	var undefined bool
+	var hints []string
+	for _, err := range issues.Errors() {
+		if strings.HasPrefix(err.Message, undefinedFieldPrefix) {
+			undefined = true
+			if hint, ok := undefinedFieldHint(parsed, err.ExprID); ok {
+				hints = append(hints, hint)
+			}
+		}
+	}
+	if undefined || e.legacy == nil {
+		return nil, withHints(issues.Err(), hints)
+	}
+	// the legacy environment checks an expression parsed again, the checker rewrites the expressions it resolves
+	if legacy, legacyIssues := e.legacy.Compile(expression); legacyIssues.Err() == nil {
+		return legacy, nil
+	}
+	return nil, issues.Err()
+}
+
+func withHints(err error, hints []string) error {
+	if len(hints) == 0 {
+		return err
+	}
+	return fmt.Errorf("%w\n%s", err, strings.Join(hints, "\n"))
+}
+
+// undefinedFieldHint tells the fields of the input type a select expression reads an undeclared field of, false
+// when the operand is not an input
+func undefinedFieldHint(parsed *cel.Ast, id int64) (string, bool) {
+	expr, ok := findExpr(ast.NavigateAST(parsed.NativeRep()), id)
+	if !ok || expr.Kind() != ast.SelectKind {
+		return "", false
+	}
+	name, fields, ok := inputFields(expr.AsSelect().Operand())
+	if !ok {
+		return "", false
+	}
+	field := expr.AsSelect().FieldName()
+	names := make([]string, 0, len(fields))
+	for _, f := range fields {
+		names = append(names, f.name)
+	}
+	hint := fmt.Sprintf("%s has no field %q", name, field)
+	if closest, ok := closestName(field, names); ok {
+		hint += fmt.Sprintf(", did you mean %q?", closest)
+	}
+	return hint + fmt.Sprintf(" The fields of %s are %s.", name, strings.Join(names, ", ")), true
+}
+
+// inputFields returns the path and fields of an expression evaluating to an input, like request or request.grpc
+func inputFields(expr ast.Expr) (string, []mapField, bool) {
+	switch expr.Kind() {
+	case ast.IdentKind:
+		for _, variable := range variables {
+			if variable.name == expr.AsIdent() && isInputType(variable.celType) {
+				return variable.name, variable.fields, true
+			}
+		}
+	case ast.SelectKind:
+		name, fields, ok := inputFields(expr.AsSelect().Operand())
+		if !ok {
+			return "", nil, false
+		}
+		for _, field := range fields {
+			if field.name == expr.AsSelect().FieldName() && isInputType(field.celType) {
+				return name + "." + field.name, field.fields, true
+			}
+		}
+	}
+	return "", nil, false
+}
+
+func findExpr(expr ast.NavigableExpr, id int64) (ast.NavigableExpr, bool) {
+	if expr.ID() == id {
+		return expr, true
+	}
+	for _, child := range expr.Children() {
+		if found, ok := findExpr(child, id); ok {
+			return found, true
+		}
+	}
+	return nil, false
+}
+
+// closestName returns the name closest to the field, if any is at most two edits away
+func closestName(field string, names []string) (string, bool) {
+	best, bestDistance := "", 3
+	for _, name := range names {
+		if distance := editDistance(strings.ToLower(field), strings.ToLower(name)); distance < bestDistance {
+			best, bestDistance = name, distance
+		}
+	}
+	return best, best != ""
+}
+
+// editDistance is the levenshtein distance of two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_compiler_Compile_inputTypes(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		wantErr    []string
+	}{{
+		name:       "request field",
+		expression: `request.path == "/api"`,
+	}, {
+		name:       "request field typo",
+		expression: `request.pathh == "/api"`,
+		wantErr:    []string{`undefined field 'pathh'`, `request has no field "pathh", did you mean "path"?`},
+	}, {
+		name:       "nested field typo",
+		expression: `has(request.grpc) && request.grpc.servce == "acme.users.v1.UserService"`,
+		wantErr:    []string{`undefined field 'servce'`, `request.grpc has no field "servce", did you mean "service"? The fields of request.grpc are service, method.`},
+	}, {
+		name:       "source field typo",
+		expression: `source.principall == ""`,
+		wantErr:    []string{`source has no field "principall", did you mean "principal"?`},
+	}, {
+		name:       "destination field typo",
+		expression: `destination.portt == 8443`,
+		wantErr:    []string{`destination has no field "portt", did you mean "port"?`},
+	}, {
+		name:       "unknown field",
+		expression: `request.tenant == "acme"`,
+		wantErr:    []string{`request has no field "tenant" The fields of request are`},
+	}, {
+		name:       "optional field",
+		expression: `!has(request.grpc)`,
+	}, {
+		name:       "map index",
+		expression: `request["path"] == "/api" && "method" in request`,
+	}, {
+		name:       "map macros",
+		expression: `request.exists(k, k == "host") && size(request) > 0`,
+	}, {
+		name:       "dynamic access",
+		expression: `dyn(request).path == "/api"`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", fmt.Sprintf(`%s ? envoy.Allowed().Response() : envoy.Denied(403).Response()`, tt.expression))
+			compiled, errs := NewCompiler().Compile(policy)
+			if len(tt.wantErr) > 0 {
+				require.NotEmpty(t, errs)
+				for _, want := range tt.wantErr {
+					assert.ErrorContains(t, errs.ToAggregate(), want)
+				}
+				return
+			}
+			require.Empty(t, errs)
+			response, err := compiled.Evaluate(context.Background(), newHttpRequest("GET", "/api"))
+			require.NoError(t, err)
+			assert.Equal(t, int32(0), response.GetStatus().GetCode(), fmt.Sprint(response))
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package core
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
+	"google.golang.org/protobuf/types/known/structpb"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	// MetadataKey is the dynamic metadata key holding the attribution of a decision
+	MetadataKey = "kyverno"
+	// MetadataPolicyKey is the name of the policy responsible for a decision
+	MetadataPolicyKey = "policy"
+	// MetadataReasonKey is the reason of a decision, as computed by the policy
+	MetadataReasonKey = "reason"
+	// MetadataRuleKey is the name of the authorization rule responsible for a decision, when it has one
+	MetadataRuleKey = "rule"
+	// MetadataAnnotationsKey holds the allowlisted annotations of the policy responsible for a decision
+	MetadataAnnotationsKey = "annotations"
+	// MetadataRateLimitKey holds the rate limit descriptor entries computed by the policy allowing a request
+	MetadataRateLimitKey = "rateLimit"
+	// MetadataAllowTrailKey holds the allow trail of an allow decision, when the server collects it
+	MetadataAllowTrailKey = "allowTrail"
+	// MetadataAllowTrailPoliciesKey lists the enforced policies that allowed the request, with their rule and reason
+	MetadataAllowTrailPoliciesKey = "policies"
+	// MetadataAllowTrailEvaluatedKey is the number of enforced policies evaluated, none of them denied the request
+	MetadataAllowTrailEvaluatedKey = "evaluated"
+)
+
+type attribution struct {
+	policy      string
+	annotations *structpb.Struct
+	reason      cel.Program
+}
+
+// filterAnnotations returns the annotations starting with one of the prefixes, nil if there are none
+func filterAnnotations(annotations map[string]string, prefixes []string) map[string]string {
+	var out map[string]string
+	for key, value := range annotations {
+		if slices.ContainsFunc(prefixes, func(prefix string) bool { return strings.HasPrefix(key, prefix) }) {
+			if out == nil {
+				out = map[string]string{}
+			}
+			out[key] = value
+		}
+	}
+	return out
+}
+
+func compileAttribution(env *compileEnv, programOptions []cel.ProgramOption, path *field.Path, reason string) (attribution, field.ErrorList) {
+	var out attribution
+	if reason == "" {
+		return out, nil
+	}
+	ast, errs := compileExpression(env, path, reason)
+	if len(errs) > 0 {
+		return out, errs
+	}
+	if err := outputTypeError(ast, path, reason, "reason", types.StringType); err != nil {
+		return out, field.ErrorList{err}
+	}
+	prog, err := env.Program(ast, programOptions...)
+	if err != nil {
+		return out, field.ErrorList{field.Invalid(path, reason, err.Error())}
+	}
+	out.reason = prog
+	return out, nil
+}
+
+// withPolicy returns the attribution of the decisions of a policy, the annotations are converted once and every
+// decision shares them
+func (a attribution) withPolicy(policy string, annotations map[string]string) attribution {
+	a.policy = policy
+	if len(annotations) != 0 {
+		fields := make(map[string]*structpb.Value, len(annotations))
+		for key, value := range annotations {
+			fields[key] = structpb.NewStringValue(value)
+		}
+		a.annotations = &structpb.Struct{Fields: fields}
+	}
+	return a
+}
+
+// apply adds the policy name, annotations and reason to the response dynamic metadata with the name of the rule
+// that returned the response, metadata returned by the authorization rule under other keys is preserved.
+// The reason of the rule replaces the reason of the policy when it is not nil.
+func (a attribution) apply(ctx context.Context, response *authv3.CheckResponse, data map[string]any, rule string, ruleReason cel.Program) error {
+	fields := map[string]*structpb.Value{
+		MetadataPolicyKey: structpb.NewStringValue(a.policy),
+	}
+	if rule != "" {
+		fields[MetadataRuleKey] = structpb.NewStringValue(rule)
+	}
+	if a.annotations != nil {
+		fields[MetadataAnnotationsKey] = structpb.NewStructValue(a.annotations)
+	}
+	reason := a.reason
+	if ruleReason != nil {
+		reason = ruleReason
+	}
+	if reason != nil {
+		out, details, err := reason.ContextEval(ctx, data)
+		recordCost(ctx, details)
+		if err != nil {
+			return err
+		}
+		value, err := utils.ConvertToNative[string](out)
+		if err != nil {
+			return err
+		}
+		fields[MetadataReasonKey] = structpb.NewStringValue(value)
+	}
+	if response.DynamicMetadata == nil {
+		response.DynamicMetadata = &structpb.Struct{}
+	}
+	if response.DynamicMetadata.Fields == nil {
+		response.DynamicMetadata.Fields = map[string]*structpb.Value{}
+	}
+	response.DynamicMetadata.Fields[MetadataKey] = structpb.NewStructValue(&structpb.Struct{Fields: fields})
+	return nil
+}
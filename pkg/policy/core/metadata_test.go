@@ -0,0 +1,134 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_compiler_Compile_reason(t *testing.T) {
+	request := &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Method: "DELETE",
+				},
+			},
+		},
+	}
+	tests := []struct {
+		name         string
+		expression   string
+		reason       string
+		wantMetadata map[string]any
+		wantErr      bool
+	}{{
+		name:       "allowed without reason",
+		expression: `envoy.Allowed().Response()`,
+		wantMetadata: map[string]any{
+			MetadataKey: map[string]any{MetadataPolicyKey: "policy"},
+		},
+	}, {
+		name:       "denied with reason",
+		expression: `envoy.Denied(403).Response()`,
+		reason:     `"method " + object.attributes.request.http.method + " is not allowed"`,
+		wantMetadata: map[string]any{
+			MetadataKey: map[string]any{MetadataPolicyKey: "policy", MetadataReasonKey: "method DELETE is not allowed"},
+		},
+	}, {
+		name:       "rule metadata is preserved",
+		expression: `envoy.Allowed().Response().WithMetadata({"foo": "bar"})`,
+		reason:     `"allowed"`,
+		wantMetadata: map[string]any{
+			"foo":       "bar",
+			MetadataKey: map[string]any{MetadataPolicyKey: "policy", MetadataReasonKey: "allowed"},
+		},
+	}, {
+		name:       "reason error",
+		expression: `envoy.Allowed().Response()`,
+		reason:     `object.attributes.request.http.headers["missing"]`,
+		wantErr:    true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", tt.expression)
+			policy.Spec.Reason = tt.reason
+			compiled, errs := NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			response, err := compiled.Evaluate(context.Background(), request)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantMetadata, response.GetDynamicMetadata().AsMap())
+		})
+	}
+}
+
+func Test_compiler_Compile_reasonErrors(t *testing.T) {
+	for _, reason := range []string{`"foo" +`, `42`} {
+		policy := newPolicy("policy", `envoy.Allowed().Response()`)
+		policy.Spec.Reason = reason
+		_, errs := NewCompiler().Compile(policy)
+		assert.NotEmpty(t, errs, reason)
+	}
+}
+
+func Test_compiler_Compile_annotations(t *testing.T) {
+	annotations := map[string]string{
+		"owner.example.com/team":  "payments",
+		"owner.example.com/slack": "#payments-oncall",
+		"ticket":                  "PAY-123",
+		"internal.example.com/id": "42",
+	}
+	tests := []struct {
+		name         string
+		prefixes     []string
+		wantMetadata map[string]any
+		want         map[string]string
+	}{{
+		name:     "allowlisted prefixes",
+		prefixes: []string{"owner.example.com/", "ticket"},
+		wantMetadata: map[string]any{
+			MetadataKey: map[string]any{
+				MetadataPolicyKey: "policy",
+				MetadataAnnotationsKey: map[string]any{
+					"owner.example.com/team":  "payments",
+					"owner.example.com/slack": "#payments-oncall",
+					"ticket":                  "PAY-123",
+				},
+			},
+		},
+		want: map[string]string{
+			"owner.example.com/team":  "payments",
+			"owner.example.com/slack": "#payments-oncall",
+			"ticket":                  "PAY-123",
+		},
+	}, {
+		name:     "no matching annotation",
+		prefixes: []string{"audit.example.com/"},
+		wantMetadata: map[string]any{
+			MetadataKey: map[string]any{MetadataPolicyKey: "policy"},
+		},
+	}, {
+		name: "no prefix",
+		wantMetadata: map[string]any{
+			MetadataKey: map[string]any{MetadataPolicyKey: "policy"},
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", `envoy.Denied(403).Response()`)
+			policy.Annotations = annotations
+			compiled, errs := NewCompiler(WithAnnotationPrefixes(tt.prefixes...)).Compile(policy)
+			assert.Empty(t, errs)
+			assert.Equal(t, tt.want, compiled.Annotations)
+			response, err := compiled.Evaluate(context.Background(), &authv3.CheckRequest{})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantMetadata, response.GetDynamicMetadata().AsMap())
+		})
+	}
+}
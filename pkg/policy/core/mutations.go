@@ -0,0 +1,141 @@
+package core
+
+import (
+	"slices"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/protobuf/proto"
+)
+
+// MergeAllows returns the allow response of the policies allowing a request, the allows are given in priority
+// order. The first allow decides, its attribution and query parameter mutations are returned, and the header
+// mutations of every allow are merged into it: a policy coming first in priority order overrides the mutations
+// of the policies coming after it. The response is copied when mutations are merged, policy responses can be
+// shared by the decision cache.
+func MergeAllows(allows []*authv3.CheckResponse) *authv3.CheckResponse {
+	switch len(allows) {
+	case 0:
+		return nil
+	case 1:
+		return allows[0]
+	}
+	request, response := newHeaderMerge(), newHeaderMerge()
+	// the policies are applied from the last one in priority order to the first one, the first one writes last
+	for _, allow := range slices.Backward(allows) {
+		ok := allow.GetOkResponse()
+		request.apply(ok.GetHeaders())
+		request.remove(ok.GetHeadersToRemove())
+		response.apply(ok.GetResponseHeadersToAdd())
+	}
+	out := proto.Clone(allows[0]).(*authv3.CheckResponse)
+	ok := out.GetOkResponse()
+	if ok == nil {
+		ok = &authv3.OkHttpResponse{}
+		out.HttpResponse = &authv3.CheckResponse_OkResponse{OkResponse: ok}
+	}
+	ok.Headers, ok.HeadersToRemove = request.result()
+	ok.ResponseHeadersToAdd, _ = response.result()
+	return out
+}
+
+// headerMerge folds the header mutations of several responses into the mutations envoy applies, header names
+// are compared case insensitively
+type headerMerge struct {
+	// order is the order header names were first mutated in
+	order   []string
+	headers map[string]*mergedHeader
+}
+
+type mergedHeader struct {
+	// removed is true when the header is removed, it has no options then
+	removed bool
+	// present is true when the header exists once the options are applied, whatever the original header
+	present bool
+	options []*corev3.HeaderValueOption
+}
+
+func newHeaderMerge() *headerMerge {
+	return &headerMerge{headers: map[string]*mergedHeader{}}
+}
+
+func (m *headerMerge) header(name string) *mergedHeader {
+	key := strings.ToLower(name)
+	header, ok := m.headers[key]
+	if !ok {
+		header = &mergedHeader{}
+		m.headers[key] = header
+		m.order = append(m.order, key)
+	}
+	return header
+}
+
+// apply applies the options in order: a set overwrites the options applied before, an append accumulates and
+// the conditional actions are resolved when the options applied before tell whether the header exists
+func (m *headerMerge) apply(options []*corev3.HeaderValueOption) {
+	for _, option := range options {
+		if option.GetHeader().GetKey() == "" {
+			continue
+		}
+		option = normalizeHeaderOption(option)
+		header := m.header(option.Header.Key)
+		action := option.AppendAction
+		switch {
+		case action == corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD:
+			header.options = nil
+		case action == corev3.HeaderValueOption_ADD_IF_ABSENT && header.present:
+			continue
+		case action == corev3.HeaderValueOption_OVERWRITE_IF_EXISTS && header.present:
+			header.options, option.AppendAction = nil, corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD
+		case action == corev3.HeaderValueOption_OVERWRITE_IF_EXISTS && header.removed:
+			continue
+		case header.removed:
+			// the header doesn't exist anymore, adding or appending a value sets it
+			option.AppendAction = corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD
+		}
+		header.removed = false
+		header.present = header.present || option.AppendAction != corev3.HeaderValueOption_OVERWRITE_IF_EXISTS
+		header.options = append(header.options, option)
+	}
+}
+
+// remove removes the headers, envoy removes headers after applying the options of a response
+func (m *headerMerge) remove(names []string) {
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		header := m.header(name)
+		header.removed, header.present, header.options = true, false, nil
+	}
+}
+
+// result returns the merged options and the removed headers, in the order the headers were first mutated in
+func (m *headerMerge) result() ([]*corev3.HeaderValueOption, []string) {
+	var options []*corev3.HeaderValueOption
+	var removed []string
+	for _, key := range m.order {
+		header := m.headers[key]
+		if header.removed {
+			removed = append(removed, key)
+		}
+		options = append(options, header.options...)
+	}
+	return options, removed
+}
+
+// normalizeHeaderOption returns a copy of the option using the append action, the deprecated append field takes
+// precedence over the append action like envoy does
+func normalizeHeaderOption(option *corev3.HeaderValueOption) *corev3.HeaderValueOption {
+	out := proto.Clone(option).(*corev3.HeaderValueOption)
+	if appends := out.GetAppend(); appends != nil {
+		out.Append = nil
+		if appends.GetValue() {
+			out.AppendAction = corev3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD
+		} else {
+			out.AppendAction = corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD
+		}
+	}
+	return out
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// formatOptions formats header options as name=value(action)
+func formatOptions(options []*corev3.HeaderValueOption) []string {
+	var out []string
+	for _, option := range options {
+		out = append(out, fmt.Sprintf("%s=%s(%s)", option.GetHeader().GetKey(), option.GetHeader().GetValue(), option.GetAppendAction()))
+	}
+	return out
+}
+
+func TestMergeAllows(t *testing.T) {
+	set := func(name, value string) hub.HeaderMutation {
+		return hub.HeaderMutation{Name: name, Expression: fmt.Sprintf("%q", value)}
+	}
+	add := func(name, value string) hub.HeaderMutation {
+		return hub.HeaderMutation{Name: name, Expression: fmt.Sprintf("%q", value), Action: hub.HeaderActionAppend}
+	}
+	remove := func(name string) hub.HeaderMutation {
+		return hub.HeaderMutation{Name: name, Action: hub.HeaderActionRemove}
+	}
+	tests := []struct {
+		name string
+		// policies are the request header mutations of the allowing policies, in priority order
+		policies    [][]hub.HeaderMutation
+		want        []string
+		wantRemoved []string
+	}{{
+		name:     "higher priority set wins",
+		policies: [][]hub.HeaderMutation{{set("x-role", "admin")}, {set("x-role", "viewer")}},
+		want:     []string{"x-role=admin(OVERWRITE_IF_EXISTS_OR_ADD)"},
+	}, {
+		name:     "appends accumulate in reverse priority order",
+		policies: [][]hub.HeaderMutation{{add("x-tag", "a")}, {add("x-tag", "b")}, {add("x-tag", "c")}},
+		want:     []string{"x-tag=c(APPEND_IF_EXISTS_OR_ADD)", "x-tag=b(APPEND_IF_EXISTS_OR_ADD)", "x-tag=a(APPEND_IF_EXISTS_OR_ADD)"},
+	}, {
+		name:     "higher priority set discards lower priority appends",
+		policies: [][]hub.HeaderMutation{{set("x-tag", "a")}, {add("x-tag", "b")}},
+		want:     []string{"x-tag=a(OVERWRITE_IF_EXISTS_OR_ADD)"},
+	}, {
+		name:     "higher priority append accumulates on a lower priority set",
+		policies: [][]hub.HeaderMutation{{add("x-tag", "a")}, {set("x-tag", "b")}},
+		want:     []string{"x-tag=b(OVERWRITE_IF_EXISTS_OR_ADD)", "x-tag=a(APPEND_IF_EXISTS_OR_ADD)"},
+	}, {
+		name:        "higher priority remove wins",
+		policies:    [][]hub.HeaderMutation{{remove("X-User")}, {set("x-user", "alice")}, {add("x-user", "bob")}},
+		wantRemoved: []string{"x-user"},
+	}, {
+		name:     "higher priority append sets a header removed with a lower priority",
+		policies: [][]hub.HeaderMutation{{add("x-user", "alice")}, {remove("x-user")}},
+		want:     []string{"x-user=alice(OVERWRITE_IF_EXISTS_OR_ADD)"},
+	}, {
+		name:     "header names are case insensitive",
+		policies: [][]hub.HeaderMutation{{set("X-Role", "admin")}, {set("x-role", "viewer")}},
+		want:     []string{"X-Role=admin(OVERWRITE_IF_EXISTS_OR_ADD)"},
+	}, {
+		name:        "different headers are kept",
+		policies:    [][]hub.HeaderMutation{{set("x-role", "admin"), remove("authorization")}, {set("x-tenant", "acme")}},
+		want:        []string{"x-tenant=acme(OVERWRITE_IF_EXISTS_OR_ADD)", "x-role=admin(OVERWRITE_IF_EXISTS_OR_ADD)"},
+		wantRemoved: []string{"authorization"},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var allows []*authv3.CheckResponse
+			for i, mutations := range tt.policies {
+				policy := newPolicy(fmt.Sprintf("policy-%d", i), `envoy.Allowed().Response()`)
+				policy.Spec.Headers = &hub.Headers{Request: mutations}
+				compiled, errs := NewCompiler().Compile(policy)
+				require.Empty(t, errs)
+				response, err := compiled.Evaluate(context.Background(), newHttpRequest("GET", "/api"))
+				require.NoError(t, err)
+				allows = append(allows, response)
+			}
+			// merging is deterministic
+			for range 3 {
+				merged := MergeAllows(allows)
+				assert.Equal(t, tt.want, formatOptions(merged.GetOkResponse().GetHeaders()))
+				assert.Equal(t, tt.wantRemoved, merged.GetOkResponse().GetHeadersToRemove())
+				// the attribution of the first allow is kept
+				assert.Equal(t, "policy-0", merged.GetDynamicMetadata().GetFields()[MetadataKey].GetStructValue().GetFields()[MetadataPolicyKey].GetStringValue())
+			}
+		})
+	}
+}
+
+func TestMergeAllows_responseHeaders(t *testing.T) {
+	first := &authv3.CheckResponse{HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: &authv3.OkHttpResponse{
+		ResponseHeadersToAdd: []*corev3.HeaderValueOption{
+			{Header: &corev3.HeaderValue{Key: "x-checked", Value: "first"}, Append: wrapperspb.Bool(false)},
+			{Header: &corev3.HeaderValue{Key: "x-cache", Value: "first"}, AppendAction: corev3.HeaderValueOption_ADD_IF_ABSENT},
+		},
+	}}}
+	second := &authv3.CheckResponse{HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: &authv3.OkHttpResponse{
+		ResponseHeadersToAdd: []*corev3.HeaderValueOption{
+			{Header: &corev3.HeaderValue{Key: "x-checked", Value: "second"}},
+			{Header: &corev3.HeaderValue{Key: "x-cache", Value: "second"}},
+		},
+	}}}
+	merged := MergeAllows([]*authv3.CheckResponse{first, second})
+	// the deprecated append field is converted, a header added if absent is skipped once a value was appended
+	assert.Equal(t, []string{"x-checked=first(OVERWRITE_IF_EXISTS_OR_ADD)", "x-cache=second(APPEND_IF_EXISTS_OR_ADD)"}, formatOptions(merged.GetOkResponse().GetResponseHeadersToAdd()))
+	// the merged responses are left untouched
+	assert.Len(t, first.GetOkResponse().GetResponseHeadersToAdd(), 2)
+	assert.NotNil(t, first.GetOkResponse().GetResponseHeadersToAdd()[0].GetAppend())
+	// a single allow is returned as is
+	assert.Same(t, first, MergeAllows([]*authv3.CheckResponse{first}))
+	assert.Nil(t, MergeAllows(nil))
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	sigsjson "sigs.k8s.io/json"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+var policyKind = v1alpha1.SchemeGroupVersion.WithKind("AuthorizationPolicy")
+
+type Provider interface {
+	CompiledPolicies(context.Context) ([]CompiledPolicy, error)
+	// HasSynced returns true once the provider loaded the policies it is expected to serve,
+	// until then the policies returned by the provider may be incomplete or empty
+	HasSynced() bool
+}
+
+// CompilePolicies compiles policies in evaluation order, it fails if any of the policies fails to compile.
+// Disabled policies are compiled but they are not returned.
+func CompilePolicies(compiler Compiler, policies []*hub.AuthorizationPolicy) ([]CompiledPolicy, error) {
+	slices.SortFunc(policies, func(a, b *hub.AuthorizationPolicy) int {
+		return ComparePolicies(a.Spec.Priority, a.Name, b.Spec.Priority, b.Name)
+	})
+	var errs []error
+	out := make([]CompiledPolicy, 0, len(policies))
+	for _, policy := range policies {
+		compiled, allErrs := compiler.Compile(policy)
+		if len(allErrs) > 0 {
+			errs = append(errs, fmt.Errorf("failed to compile policy %s: %w", policy.Name, allErrs.ToAggregate()))
+			continue
+		}
+		if policy.Spec.Disabled {
+			continue
+		}
+		out = append(out, compiled)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return out, nil
+}
+
+// ComparePolicies orders policies by priority (descending), ties are broken by name
+func ComparePolicies(aPriority int32, aName string, bPriority int32, bName string) int {
+	if c := cmp.Compare(bPriority, aPriority); c != 0 {
+		return c
+	}
+	return strings.Compare(aName, bName)
+}
+
+// DecodePolicies decodes the policies of a multi document yaml stream and converts them to the hub version,
+// documents that are not policies are skipped
+func DecodePolicies(r io.Reader) ([]*hub.AuthorizationPolicy, error) {
+	return decodePolicies(r, false)
+}
+
+// DecodePoliciesStrict decodes the policies like DecodePolicies, a policy with fields unknown to its version
+// (a misspelled field for example) is an error instead of being silently ignored
+func DecodePoliciesStrict(r io.Reader) ([]*hub.AuthorizationPolicy, error) {
+	return decodePolicies(r, true)
+}
+
+// UnknownFields returns the fields of a policy json or yaml document unknown to the policy version,
+// they are not part of the decoded policy
+func UnknownFields(document []byte) ([]string, error) {
+	data, err := sigsyaml.YAMLToJSON(document)
+	if err != nil {
+		return nil, err
+	}
+	var policy v1alpha1.AuthorizationPolicy
+	strictErrs, err := sigsjson.UnmarshalStrict(data, &policy, sigsjson.DisallowUnknownFields)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(strictErrs))
+	for _, err := range strictErrs {
+		out = append(out, err.Error())
+	}
+	return out, nil
+}
+
+func decodePolicies(r io.Reader, strict bool) ([]*hub.AuthorizationPolicy, error) {
+	var policies []*hub.AuthorizationPolicy
+	reader := yaml.NewYAMLReader(bufio.NewReader(r))
+	for {
+		document, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return policies, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var meta metav1.TypeMeta
+		if err := sigsyaml.Unmarshal(document, &meta); err != nil {
+			return nil, err
+		}
+		// skip empty and non policy documents
+		if meta.GroupVersionKind() != policyKind {
+			continue
+		}
+		var policy v1alpha1.AuthorizationPolicy
+		if err := sigsyaml.Unmarshal(document, &policy); err != nil {
+			return nil, err
+		}
+		if strict {
+			unknown, err := UnknownFields(document)
+			if err != nil {
+				return nil, err
+			}
+			if len(unknown) > 0 {
+				return nil, fmt.Errorf("policy %s has fields unknown to %s: %s", policy.Name, meta.APIVersion, strings.Join(unknown, ", "))
+			}
+		}
+		converted, err := ConvertPolicy(&policy)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, converted)
+	}
+}
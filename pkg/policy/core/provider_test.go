@@ -0,0 +1,82 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/stretchr/testify/assert"
+)
+
+const misspelledPolicy = `
+apiVersion: envoy.kyverno.io/v1alpha1
+kind: AuthorizationPolicy
+metadata:
+  name: misspelled
+spec:
+  priorty: 10
+  authorizations:
+  - expression: envoy.Allowed().Response()
+    reasn: '"allowed"'
+`
+
+func TestDecodePoliciesStrict(t *testing.T) {
+	// unknown fields are ignored by default
+	policies, err := DecodePolicies(strings.NewReader(misspelledPolicy))
+	assert.NoError(t, err)
+	if assert.Len(t, policies, 1) {
+		assert.Zero(t, policies[0].Spec.Priority)
+	}
+	// and fail strict decoding
+	_, err = DecodePoliciesStrict(strings.NewReader(misspelledPolicy))
+	assert.EqualError(t, err, `policy misspelled has fields unknown to envoy.kyverno.io/v1alpha1: unknown field "spec.authorizations[0].reasn", unknown field "spec.priorty"`)
+}
+
+func TestUnknownFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		want     []string
+		wantErr  bool
+	}{{
+		name:     "known fields",
+		document: `{"apiVersion":"envoy.kyverno.io/v1alpha1","kind":"AuthorizationPolicy","metadata":{"name":"policy"},"spec":{"priority":10}}`,
+		want:     []string{},
+	}, {
+		name:     "unknown fields",
+		document: misspelledPolicy,
+		want:     []string{`unknown field "spec.authorizations[0].reasn"`, `unknown field "spec.priorty"`},
+	}, {
+		name:     "not a policy",
+		document: `[]`,
+		wantErr:  true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UnknownFields([]byte(tt.document))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCompilePolicies_disabled(t *testing.T) {
+	enabled := newPolicy("enabled", `envoy.Allowed().Response()`)
+	disabled := newPolicy("disabled", `envoy.Denied(403).Response()`)
+	disabled.Spec.Disabled = true
+	// disabled policies are not returned
+	compiled, err := CompilePolicies(NewCompiler(), []*hub.AuthorizationPolicy{enabled, disabled})
+	assert.NoError(t, err)
+	if assert.Len(t, compiled, 1) {
+		assert.Equal(t, "enabled", compiled[0].Name)
+	}
+	// but they must compile
+	invalid := newPolicy("invalid", `envoy.Denied(`)
+	invalid.Spec.Disabled = true
+	_, err = CompilePolicies(NewCompiler(), []*hub.AuthorizationPolicy{enabled, invalid})
+	assert.ErrorContains(t, err, "failed to compile policy invalid")
+}
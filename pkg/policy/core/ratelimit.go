@@ -0,0 +1,100 @@
+package core
+
+import (
+	"context"
+	"fmt"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// rateLimitEntriesType is the type of the expressions computing rate limit descriptor entries
+var rateLimitEntriesType = types.NewListType(types.NewMapType(types.StringType, types.StringType))
+
+const (
+	rateLimitEntryKey   = "key"
+	rateLimitEntryValue = "value"
+)
+
+type rateLimit struct {
+	entries cel.Program
+}
+
+func compileRateLimit(env *compileEnv, programOptions []cel.ProgramOption, path *field.Path, in *hub.RateLimit) (*rateLimit, field.ErrorList) {
+	if in == nil {
+		return nil, nil
+	}
+	path = path.Child("entries")
+	ast, errs := compileExpression(env, path, in.Entries)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if !isRateLimitEntriesType(ast.OutputType()) {
+		return nil, field.ErrorList{field.TypeInvalid(path, in.Entries, fmt.Sprintf("rate limit entries output is expected to be of type %s, got %s", rateLimitEntriesType, ast.OutputType()))}
+	}
+	prog, err := env.Program(ast, programOptions...)
+	if err != nil {
+		return nil, field.ErrorList{field.Invalid(path, in.Entries, err.Error())}
+	}
+	return &rateLimit{entries: prog}, nil
+}
+
+// isRateLimitEntriesType returns true for lists of maps, dynamic values are checked when evaluated
+func isRateLimitEntriesType(t *types.Type) bool {
+	switch t.Kind() {
+	case types.DynKind:
+		return true
+	case types.ListKind:
+		kind := t.Parameters()[0].Kind()
+		return kind == types.MapKind || kind == types.DynKind
+	}
+	return false
+}
+
+// apply adds the rate limit descriptor entries to the attribution of an allow response, the attribution must be
+// applied first. Denied requests don't reach the rate limit filter, their responses are left untouched.
+func (r *rateLimit) apply(ctx context.Context, response *authv3.CheckResponse, data map[string]any) error {
+	if r == nil || response.GetStatus().GetCode() != int32(codes.OK) {
+		return nil
+	}
+	out, details, err := r.entries.ContextEval(ctx, data)
+	recordCost(ctx, details)
+	if err != nil {
+		return err
+	}
+	entries, err := utils.ConvertToNative[[]map[string]string](out)
+	if err != nil {
+		return err
+	}
+	fields := make(map[string]*structpb.Value, len(entries))
+	seen := sets.New[string]()
+	for i, entry := range entries {
+		key, ok := entry[rateLimitEntryKey]
+		if !ok || key == "" {
+			return fmt.Errorf("rate limit entry %d has no key", i)
+		}
+		for name := range entry {
+			if name != rateLimitEntryKey && name != rateLimitEntryValue {
+				return fmt.Errorf("rate limit entry %q has an unknown field %q, entries have a key and a value", key, name)
+			}
+		}
+		if seen.Has(key) {
+			return fmt.Errorf("duplicate rate limit entry %q", key)
+		}
+		seen.Insert(key)
+		// envoy doesn't rate limit by empty values, the default value of the rate limit action applies instead
+		if value := entry[rateLimitEntryValue]; value != "" {
+			fields[key] = structpb.NewStringValue(value)
+		}
+	}
+	attribution := response.DynamicMetadata.Fields[MetadataKey].GetStructValue()
+	attribution.Fields[MetadataRateLimitKey] = structpb.NewStructValue(&structpb.Struct{Fields: fields})
+	return nil
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_compiler_Compile_rateLimit(t *testing.T) {
+	const allowGet = `object.attributes.request.http.method == "GET" ? envoy.Allowed().Response() : envoy.Denied(403).Response()`
+	tests := []struct {
+		name    string
+		entries string
+		method  string
+		// want is the rate limit metadata, nil when the response has none
+		want    map[string]any
+		wantErr string
+	}{{
+		name:    "entries computed from the request",
+		entries: `[{"key": "subject", "value": object.attributes.request.http.headers[?"x-user"].orValue("")}, {"key": "path", "value": string(request.path)}]`,
+		method:  "GET",
+		want:    map[string]any{"subject": "alice", "path": "/api"},
+	}, {
+		name:    "empty values are left out",
+		entries: `[{"key": "subject", "value": object.attributes.request.http.headers[?"x-missing"].orValue("")}, {"key": "method", "value": string(request.method)}]`,
+		method:  "GET",
+		want:    map[string]any{"method": "GET"},
+	}, {
+		name:    "no entries",
+		entries: `[]`,
+		method:  "GET",
+		want:    map[string]any{},
+	}, {
+		name:    "denied requests have no entries",
+		entries: `[{"key": "subject", "value": "alice"}]`,
+		method:  "POST",
+	}, {
+		name:    "duplicate key",
+		entries: `[{"key": "subject", "value": "alice"}, {"key": "subject", "value": "bob"}]`,
+		method:  "GET",
+		wantErr: `duplicate rate limit entry "subject"`,
+	}, {
+		name:    "missing key",
+		entries: `[{"value": "alice"}]`,
+		method:  "GET",
+		wantErr: "rate limit entry 0 has no key",
+	}, {
+		name:    "unknown field",
+		entries: `[{"key": "subject", "value": "alice", "unit": "minute"}]`,
+		method:  "GET",
+		wantErr: `rate limit entry "subject" has an unknown field "unit"`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", allowGet)
+			policy.Spec.RateLimit = &hub.RateLimit{Entries: tt.entries}
+			compiled, errs := NewCompiler().Compile(policy)
+			require.Empty(t, errs)
+			request := newHttpRequest(tt.method, "/api")
+			request.Attributes.Request.Http.Headers["x-user"] = "alice"
+			response, err := compiled.Evaluate(context.Background(), request)
+			if tt.wantErr != "" {
+				var evalErr *EvaluationError
+				if assert.ErrorAs(t, err, &evalErr) {
+					assert.Equal(t, "spec.rateLimit.entries", evalErr.Field)
+				}
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			attribution := response.GetDynamicMetadata().GetFields()[MetadataKey].GetStructValue().AsMap()
+			// the entries don't replace the attribution
+			assert.Equal(t, "policy", attribution[MetadataPolicyKey])
+			if tt.want == nil {
+				assert.NotContains(t, attribution, MetadataRateLimitKey)
+			} else {
+				assert.Equal(t, tt.want, attribution[MetadataRateLimitKey])
+			}
+		})
+	}
+}
+
+func Test_compiler_Compile_rateLimitInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries string
+		wantErr string
+	}{{
+		name:    "map instead of a list",
+		entries: `{"subject": "alice"}`,
+		wantErr: "spec.rateLimit.entries: Invalid value: \"{\\\"subject\\\": \\\"alice\\\"}\": rate limit entries output is expected to be of type list(map(string, string)), got map(string, string)",
+	}, {
+		name:    "list of strings",
+		entries: `["subject"]`,
+		wantErr: "got list(string)",
+	}, {
+		name:    "invalid expression",
+		entries: `[{"key": "subject", "value": unknown}]`,
+		wantErr: "spec.rateLimit.entries: Invalid value",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", `envoy.Allowed().Response()`)
+			policy.Spec.RateLimit = &hub.RateLimit{Entries: tt.entries}
+			_, errs := NewCompiler().Compile(policy)
+			assert.ErrorContains(t, errs.ToAggregate(), tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	engine "github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Recompiler is implemented by the providers whose loaded policies can be compiled again with another compiler
+type Recompiler interface {
+	// Recompile compiles the loaded policies with the compiler without changing the policies the provider serves,
+	// it fails if any policy fails to compile. The provider doesn't compile policies until finish is called, with
+	// true to serve the recompiled policies or with false to keep the previous ones.
+	Recompile(compiler Compiler) (finish func(commit bool), err error)
+}
+
+// ReloadableCompiler is a CEL compiler whose custom libraries can be replaced without restarting the server, the
+// policies loaded by the providers are compiled again with the new libraries by Reload.
+type ReloadableCompiler struct {
+	// reload serializes the reloads
+	reload  sync.Mutex
+	lock    sync.RWMutex
+	options []CompilerOption
+	// libraries and generation are the libraries the current compiler was created with and their generation
+	libraries  []cel.EnvOption
+	generation uint64
+	current    Compiler
+}
+
+// NewReloadableCompiler returns a compiler registering the libraries after the built-in ones, like WithLibraries
+// does, the options apply to every compiler created on reload
+func NewReloadableCompiler(libraries []cel.EnvOption, opts ...CompilerOption) *ReloadableCompiler {
+	c := &ReloadableCompiler{options: opts}
+	c.libraries, c.generation, c.current = slices.Clone(libraries), 1, c.newCompiler(libraries, 1)
+	return c
+}
+
+func (c *ReloadableCompiler) newCompiler(libraries []cel.EnvOption, generation uint64) Compiler {
+	return NewCompiler(append(slices.Clone(c.options), WithLibraries(libraries...), func(o *compilerOptions) {
+		o.generation = generation
+	})...)
+}
+
+// Compile compiles the policy with the current libraries
+func (c *ReloadableCompiler) Compile(policy *hub.AuthorizationPolicy) (CompiledPolicy, field.ErrorList) {
+	c.lock.RLock()
+	current := c.current
+	c.lock.RUnlock()
+	return current.Compile(policy)
+}
+
+// Libraries returns the custom libraries policies are compiled with
+func (c *ReloadableCompiler) Libraries() []cel.EnvOption {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return slices.Clone(c.libraries)
+}
+
+// Reload replaces the custom libraries and compiles the policies loaded by the providers again, the providers serve
+// the recompiled policies at once when every policy of every provider compiled with the new libraries. The
+// compiler and the providers are left unchanged otherwise, they never evaluate policies compiled with different
+// libraries. The providers must compile their policies with this compiler.
+func (c *ReloadableCompiler) Reload(libraries []cel.EnvOption, providers ...Recompiler) error {
+	c.reload.Lock()
+	defer c.reload.Unlock()
+	// an environment failing to build fails every compilation, report it once
+	if _, err := engine.NewEnv(libraries...); err != nil {
+		return fmt.Errorf("failed to create the CEL environment: %w", err)
+	}
+	c.lock.RLock()
+	generation := c.generation + 1
+	c.lock.RUnlock()
+	candidate := c.newCompiler(libraries, generation)
+	// the providers wait for their ongoing compilations to complete and don't compile policies until they are finished
+	finishers := make([]func(bool), 0, len(providers))
+	finish := func(commit bool) {
+		for _, finish := range finishers {
+			finish(commit)
+		}
+	}
+	var errs []error
+	for i, provider := range providers {
+		finisher, err := provider.Recompile(candidate)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("provider %d: %w", i, err))
+			continue
+		}
+		finishers = append(finishers, finisher)
+	}
+	if len(errs) > 0 {
+		finish(false)
+		return errors.Join(errs...)
+	}
+	// the policies compiled once the providers are finished use the new libraries
+	c.lock.Lock()
+	c.libraries, c.generation, c.current = slices.Clone(libraries), generation, candidate
+	c.lock.Unlock()
+	finish(true)
+	return nil
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func greetLibrary(greeting string) cel.EnvOption {
+	return cel.Function("org.greet",
+		cel.Overload("org_greet_string", []*cel.Type{cel.StringType}, cel.StringType,
+			cel.UnaryBinding(func(value ref.Val) ref.Val {
+				return types.String(greeting + " " + string(value.(types.String)))
+			}),
+		),
+	)
+}
+
+var farewellLibrary = cel.Function("org.farewell",
+	cel.Overload("org_farewell_string", []*cel.Type{cel.StringType}, cel.StringType,
+		cel.UnaryBinding(func(value ref.Val) ref.Val {
+			return types.String("goodbye " + value.(types.String))
+		}),
+	),
+)
+
+// recordingRecompiler records how the reloads finished it
+type recordingRecompiler struct {
+	err      error
+	finished []bool
+}
+
+func (r *recordingRecompiler) Recompile(Compiler) (func(bool), error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return func(commit bool) {
+		r.finished = append(r.finished, commit)
+	}, nil
+}
+
+func evaluateStatus(t *testing.T, provider Provider) []int32 {
+	t.Helper()
+	policies, err := provider.CompiledPolicies(context.Background())
+	require.NoError(t, err)
+	var out []int32
+	for _, policy := range policies {
+		response, err := policy.Evaluate(context.Background(), newHttpRequest("GET", "/"))
+		require.NoError(t, err)
+		out = append(out, response.GetStatus().GetCode())
+	}
+	return out
+}
+
+func TestReloadableCompiler_Reload(t *testing.T) {
+	const greets = `org.greet("world") == "hello world" ? envoy.Allowed().Response() : envoy.Denied(403).Response()`
+	const farewells = `org.farewell("world") == "goodbye world" ? envoy.Allowed().Response() : envoy.Denied(403).Response()`
+	// the specs compiled with the previous libraries aren't reused from the cache
+	compiler := NewReloadableCompiler([]cel.EnvOption{greetLibrary("hello")}, WithCompileCache(NewCompileCache()))
+	provider, err := NewStaticProvider(compiler, newPolicy("greets", greets))
+	require.NoError(t, err)
+	assert.Equal(t, []int32{0}, evaluateStatus(t, provider))
+	// the function isn't registered yet
+	_, errs := compiler.Compile(newPolicy("farewells", farewells))
+	require.NotEmpty(t, errs)
+	require.NoError(t, compiler.Reload([]cel.EnvOption{greetLibrary("hi"), farewellLibrary}, provider.(Recompiler)))
+	// the loaded policy was compiled with the new libraries
+	assert.Equal(t, []int32{int32(codes.PermissionDenied)}, evaluateStatus(t, provider))
+	compiled, errs := compiler.Compile(newPolicy("farewells", farewells))
+	require.Empty(t, errs)
+	response, err := compiled.Evaluate(context.Background(), newHttpRequest("GET", "/"))
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), response.GetStatus().GetCode())
+	assert.Len(t, compiler.Libraries(), 2)
+}
+
+func TestReloadableCompiler_Reload_failure(t *testing.T) {
+	const greets = `org.greet("world") == "hello world" ? envoy.Allowed().Response() : envoy.Denied(403).Response()`
+	tests := []struct {
+		name      string
+		libraries []cel.EnvOption
+		// failing makes a provider fail to recompile, it is appended to the providers
+		failing error
+		wantErr string
+		// wantFinished are the finishes of the provider recompiled first
+		wantFinished []bool
+	}{{
+		name:         "policy failing to compile",
+		libraries:    []cel.EnvOption{farewellLibrary},
+		wantErr:      "failed to compile policy greets",
+		wantFinished: []bool{false},
+	}, {
+		name:         "provider failing",
+		libraries:    []cel.EnvOption{greetLibrary("hi")},
+		failing:      errors.New("unavailable"),
+		wantErr:      "provider 2: unavailable",
+		wantFinished: []bool{false},
+	}, {
+		name: "invalid libraries",
+		// collides with a built-in function
+		libraries: []cel.EnvOption{cel.Function("strings.quote",
+			cel.Overload("org_quote_string", []*cel.Type{cel.StringType}, cel.StringType,
+				cel.UnaryBinding(func(value ref.Val) ref.Val {
+					return value
+				}),
+			),
+		)},
+		wantErr: "failed to create the CEL environment",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compiler := NewReloadableCompiler([]cel.EnvOption{greetLibrary("hello")})
+			provider, err := NewStaticProvider(compiler, newPolicy("greets", greets))
+			require.NoError(t, err)
+			recorder := &recordingRecompiler{}
+			providers := []Recompiler{recorder, provider.(Recompiler)}
+			if tt.failing != nil {
+				providers = append(providers, &recordingRecompiler{err: tt.failing})
+			}
+			err = compiler.Reload(tt.libraries, providers...)
+			assert.ErrorContains(t, err, tt.wantErr)
+			// the providers keep the previous policies
+			assert.Equal(t, []int32{0}, evaluateStatus(t, provider))
+			assert.Equal(t, tt.wantFinished, recorder.finished)
+			// the compiler keeps the previous libraries
+			assert.Len(t, compiler.Libraries(), 1)
+			compiled, errs := compiler.Compile(newPolicy("greets", greets))
+			require.Empty(t, errs)
+			response, err := compiled.Evaluate(context.Background(), newHttpRequest("GET", "/"))
+			require.NoError(t, err)
+			assert.Equal(t, int32(0), response.GetStatus().GetCode())
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package core
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
+)
+
+// RequestType is the type of the request variable, it holds typed accessors derived from the attributes.request.http attributes:
+//   - method is the http method
+//   - path is the percent decoded path, without the query string
+//   - rawPath is the path as sent by the client, without the query string
+//   - query maps the query parameters to their percent decoded values, in order, a repeated key has several values
+//   - scheme is the url scheme
+//   - host is the http host (the authority pseudo header), it may include a port
+//   - grpc is present when the request is a grpc call (the content type is application/grpc or a variant of it),
+//     grpc.service and grpc.method are parsed from a path of the form /<service>/<method>
+//   - headers maps the lowercase header names to their value, the values of a repeated header are joined with a comma
+//   - headerValues maps the lowercase header names to their list of values, it is read with request.headerValues(name)
+//   - bodyTruncated is true when the forwarded body was truncated, by envoy or by the server body limit
+//   - size is the request size in bytes envoy reported, it is absent when envoy reported it as unknown
+//   - time is the time envoy received the request, it is absent when envoy didn't report it
+//
+// The headers are read from the headers map envoy sends, or from the header map when the ext_authz filter encodes
+// raw headers, both representations give the same headers and headerValues.
+//
+// The type declares the fields, expressions selecting another field fail to compile.
+var RequestType = types.NewObjectType("kyverno.request")
+
+// requestGrpcType is the type of the grpc field of the request variable
+var requestGrpcType = types.NewObjectType("kyverno.request.grpc")
+
+// requestFields are the fields of the request variable
+var requestFields = []mapField{
+	{name: "method", celType: types.StringType},
+	{name: "path", celType: types.StringType},
+	{name: "rawPath", celType: types.StringType},
+	{name: "query", celType: types.NewMapType(types.StringType, types.NewListType(types.StringType))},
+	{name: "scheme", celType: types.StringType},
+	{name: "host", celType: types.StringType},
+	{name: "grpc", celType: requestGrpcType, optional: true, fields: []mapField{
+		{name: "service", celType: types.StringType},
+		{name: "method", celType: types.StringType},
+	}},
+	{name: "headers", celType: types.NewMapType(types.StringType, types.StringType)},
+	{name: "headerValues", celType: headerValuesType},
+	{name: "bodyTruncated", celType: types.BoolType},
+	{name: "size", celType: types.IntType, optional: true},
+	{name: "time", celType: types.TimestampType, optional: true},
+}
+
+// headerValuesType is the type of the headerValues field of the request and response variables
+var headerValuesType = types.NewMapType(types.StringType, types.NewListType(types.StringType))
+
+const headerValuesFunctionName = "headerValues"
+
+// headerValuesFunction declares the headerValues member function of the request and response variables, it
+// returns the values of a header by case insensitive name, or an empty list when the header is absent
+var headerValuesFunction = cel.Function(headerValuesFunctionName,
+	cel.MemberOverload("map_header_values_string",
+		[]*cel.Type{types.NewMapType(types.StringType, types.DynType), types.StringType},
+		types.NewListType(types.StringType),
+		cel.BinaryBinding(headerValues),
+	),
+	cel.MemberOverload("request_header_values_string",
+		[]*cel.Type{RequestType, types.StringType},
+		types.NewListType(types.StringType),
+		cel.BinaryBinding(headerValues),
+	),
+	// the request variable is a map at runtime, the binding checks the receiver instead of the type guards
+	decls.DisableTypeGuards(true),
+)
+
+func headerValues(receiver, name ref.Val) ref.Val {
+	none := types.NewStringList(types.DefaultTypeAdapter, []string{})
+	variable, ok := receiver.(traits.Mapper)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(receiver)
+	}
+	key, ok := name.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(name)
+	}
+	values, found := variable.Find(types.String("headerValues"))
+	if !found {
+		return none
+	}
+	headers, ok := values.(traits.Mapper)
+	if !ok {
+		return none
+	}
+	if values, found := headers.Find(types.String(strings.ToLower(string(key)))); found {
+		return values
+	}
+	return none
+}
+
+// setCookieHeader can't be split on commas, the cookie expiration dates hold commas
+const setCookieHeader = "set-cookie"
+
+// splitHeaderValue splits a header field value in its comma separated elements, like RFC 9110 lists. Commas in
+// quoted strings don't separate elements, elements are trimmed and the empty ones are dropped. The set-cookie
+// header is not split, each field line is a value.
+func splitHeaderValue(name, value string) []string {
+	if name == setCookieHeader {
+		return []string{value}
+	}
+	var values []string
+	var quoted, escaped bool
+	start := 0
+	for i := 0; i <= len(value); i++ {
+		if i < len(value) {
+			c := value[i]
+			switch {
+			case escaped:
+				escaped = false
+				continue
+			case quoted && c == '\\':
+				escaped = true
+				continue
+			case c == '"':
+				quoted = !quoted
+				continue
+			case c != ',' || quoted:
+				continue
+			}
+		}
+		if element := strings.Trim(value[start:i], " \t"); element != "" {
+			values = append(values, element)
+		}
+		start = i + 1
+	}
+	return values
+}
+
+// newHeaders returns the headers and headerValues fields from the field lines of the headers, in order
+func newHeaders(lines [][2]string) (map[string]string, map[string][]string) {
+	headers := map[string]string{}
+	values := map[string][]string{}
+	for _, line := range lines {
+		name := strings.ToLower(line[0])
+		// envoy joins the values of a repeated header with a comma
+		if value, ok := headers[name]; ok {
+			headers[name] = value + "," + line[1]
+		} else {
+			headers[name] = line[1]
+		}
+		values[name] = append(values[name], splitHeaderValue(name, line[1])...)
+	}
+	for name := range headers {
+		if values[name] == nil {
+			values[name] = []string{}
+		}
+	}
+	return headers, values
+}
+
+// requestHeaderLines returns the field lines of the request headers. The header map is populated instead of the
+// headers map when the ext_authz filter encodes raw headers, it has a field line per occurrence of a header.
+func requestHeaderLines(http *authv3.AttributeContext_HttpRequest) [][2]string {
+	var lines [][2]string
+	if raw := http.GetHeaderMap().GetHeaders(); len(raw) > 0 {
+		for _, header := range raw {
+			value := header.GetValue()
+			if header.GetRawValue() != nil {
+				value = string(header.GetRawValue())
+			}
+			lines = append(lines, [2]string{header.GetKey(), value})
+		}
+		return lines
+	}
+	for name, value := range http.GetHeaders() {
+		lines = append(lines, [2]string{name, value})
+	}
+	return lines
+}
+
+// grpcContentTypeHeader is the header telling grpc calls apart, envoy lowercases header names
+const grpcContentTypeHeader = "content-type"
+
+// grpcContentType prefixes the content type of grpc calls, the variants add a suffix (application/grpc+proto, application/grpc-web)
+const grpcContentType = "application/grpc"
+
+// isGrpcCall returns true if the request is a grpc call
+func isGrpcCall(http *authv3.AttributeContext_HttpRequest) bool {
+	return strings.HasPrefix(http.GetHeaders()[grpcContentTypeHeader], grpcContentType)
+}
+
+// newRequestGrpc returns the grpc field of the request variable, it is nil when the request is not a grpc call
+func newRequestGrpc(http *authv3.AttributeContext_HttpRequest, rawPath string) map[string]any {
+	if !isGrpcCall(http) {
+		return nil
+	}
+	// a path that isn't of the form /<service>/<method> has an empty service and method
+	var service, method string
+	if trimmed, ok := strings.CutPrefix(rawPath, "/"); ok {
+		if s, m, ok := strings.Cut(trimmed, "/"); ok && s != "" && m != "" && !strings.Contains(m, "/") {
+			service, method = s, m
+		}
+	}
+	return map[string]any{
+		"service": service,
+		"method":  method,
+	}
+}
+
+// newRequest returns the request variable of a check request, missing fields are zero values
+func newRequest(r *authv3.CheckRequest) map[string]any {
+	http := r.GetAttributes().GetRequest().GetHttp()
+	rawPath, rawQuery, _ := strings.Cut(http.GetPath(), "?")
+	// the fragment is not sent by clients, if any it is not part of the query
+	rawQuery, _, _ = strings.Cut(rawQuery, "#")
+	// a path that can't be decoded is kept as is
+	path, err := url.PathUnescape(rawPath)
+	if err != nil {
+		path = rawPath
+	}
+	// invalid parameters are skipped, the valid ones are kept
+	query, _ := url.ParseQuery(rawQuery)
+	request := map[string]any{
+		"method":  http.GetMethod(),
+		"path":    path,
+		"rawPath": rawPath,
+		"query":   map[string][]string(query),
+		"scheme":  http.GetScheme(),
+		"host":    http.GetHost(),
+	}
+	request["headers"], request["headerValues"] = newHeaders(requestHeaderLines(http))
+	request["bodyTruncated"] = bodyTruncated(http)
+	// envoy reports an unknown size as -1
+	if http.GetSize() >= 0 {
+		request["size"] = http.GetSize()
+	}
+	if time := r.GetAttributes().GetRequest().GetTime(); time != nil {
+		request["time"] = time.AsTime()
+	}
+	if grpc := newRequestGrpc(http, rawPath); grpc != nil {
+		request["grpc"] = grpc
+	}
+	return request
+}
+
+// nowMemoKey stores the now variable of a check in the memo of the check
+const nowMemoKey = "kyverno.now"
+
+// now returns the now variable, the clock is read once per check so that the expressions of every policy evaluating
+// the check compare against the same time
+func (s *compiledSpec) now(ctx context.Context) ref.Val {
+	return utils.MemoizedValue(ctx, nowMemoKey, func() ref.Val {
+		return types.Timestamp{Time: s.clock.Now()}
+	})
+}
@@ -0,0 +1,437 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func newHttpRequest(method, path string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Method:  method,
+					Path:    path,
+					Scheme:  "https",
+					Host:    "api.example.com:8443",
+					Headers: map[string]string{":path": path, "x-tenant": "acme"},
+				},
+			},
+		},
+	}
+}
+
+func newGrpcRequest(path, contentType string) *authv3.CheckRequest {
+	request := newHttpRequest("POST", path)
+	request.Attributes.Request.Http.Protocol = "HTTP/2"
+	request.Attributes.Request.Http.Headers["content-type"] = contentType
+	return request
+}
+
+func Test_compiler_Compile_request(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		request    *authv3.CheckRequest
+		want       bool
+	}{{
+		name:       "method, scheme and host",
+		expression: `request.method == "POST" && request.scheme == "https" && request.host == "api.example.com:8443"`,
+		request:    newHttpRequest("POST", "/"),
+		want:       true,
+	}, {
+		name:       "path without query",
+		expression: `request.path == "/api/users" && request.rawPath == "/api/users"`,
+		request:    newHttpRequest("GET", "/api/users?limit=10"),
+		want:       true,
+	}, {
+		name:       "encoded path",
+		expression: `request.path == "/files/my report/a/b.txt" && request.rawPath == "/files/my%20report/a%2Fb.txt"`,
+		request:    newHttpRequest("GET", "/files/my%20report/a%2Fb.txt"),
+		want:       true,
+	}, {
+		name:       "invalid encoding keeps the raw path",
+		expression: `request.path == "/files/100%" && request.rawPath == "/files/100%"`,
+		request:    newHttpRequest("GET", "/files/100%"),
+		want:       true,
+	}, {
+		name:       "query",
+		expression: `request.query.limit == ["10"] && request.query.sort == ["name"]`,
+		request:    newHttpRequest("GET", "/api/users?limit=10&sort=name"),
+		want:       true,
+	}, {
+		name:       "repeated keys",
+		expression: `request.query.tag == ["a", "b", "c"]`,
+		request:    newHttpRequest("GET", "/api/users?tag=a&tag=b&tag=c"),
+		want:       true,
+	}, {
+		name:       "decoded keys and values",
+		expression: `request.query["first name"] == ["Jane Doe"] && request.query.q == ["a&b=c"]`,
+		request:    newHttpRequest("GET", "/search?first%20name=Jane+Doe&q=a%26b%3Dc"),
+		want:       true,
+	}, {
+		name:       "empty values",
+		expression: `request.query.flag == [""] && request.query.empty == [""]`,
+		request:    newHttpRequest("GET", "/search?flag&empty="),
+		want:       true,
+	}, {
+		name:       "invalid parameters are skipped",
+		expression: `request.query == {"valid": ["yes"]}`,
+		request:    newHttpRequest("GET", "/search?bad=%zz&valid=yes"),
+		want:       true,
+	}, {
+		name:       "fragment is not part of the query",
+		expression: `request.query == {"page": ["2"]}`,
+		request:    newHttpRequest("GET", "/docs?page=2#section"),
+		want:       true,
+	}, {
+		name:       "missing query",
+		expression: `request.query == {} && !("limit" in request.query)`,
+		request:    newHttpRequest("GET", "/api/users"),
+		want:       true,
+	}, {
+		name:       "raw headers are still available",
+		expression: `object.attributes.request.http.headers["x-tenant"] == "acme" && object.attributes.request.http.headers[":path"] == "/api/users?limit=10"`,
+		request:    newHttpRequest("GET", "/api/users?limit=10"),
+		want:       true,
+	}, {
+		name:       "grpc service and method",
+		expression: `request.grpc.service == "acme.users.v1.UserService" && request.grpc.method == "GetUser" && request.path == "/acme.users.v1.UserService/GetUser"`,
+		request:    newGrpcRequest("/acme.users.v1.UserService/GetUser", "application/grpc"),
+		want:       true,
+	}, {
+		name:       "grpc content type variant",
+		expression: `request.grpc.service == "acme.users.v1.UserService" && request.grpc.method == "GetUser"`,
+		request:    newGrpcRequest("/acme.users.v1.UserService/GetUser", "application/grpc+proto"),
+		want:       true,
+	}, {
+		name:       "grpc malformed path",
+		expression: `request.grpc == {"service": "", "method": ""}`,
+		request:    newGrpcRequest("/acme.users.v1.UserService/GetUser/extra", "application/grpc"),
+		want:       true,
+	}, {
+		name:       "http request is not grpc",
+		expression: `!has(request.grpc)`,
+		request:    newHttpRequest("POST", "/acme.users.v1.UserService/GetUser"),
+		want:       true,
+	}, {
+		name:       "empty request",
+		expression: `request.method == "" && request.path == "" && request.query == {} && request.scheme == "" && request.host == ""`,
+		request:    &authv3.CheckRequest{},
+		want:       true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", tt.expression+` ? envoy.Allowed().Response() : envoy.Denied(403).Response()`)
+			compiled, errs := NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			response, err := compiled.Evaluate(context.Background(), tt.request)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, response.GetStatus().GetCode() == 0, fmt.Sprint(response))
+		})
+	}
+}
+
+// newRawHeadersRequest returns a request whose headers are encoded like the ext_authz filter with raw headers
+// encoding sends them, in the header map with a field line per occurrence
+func newRawHeadersRequest(lines ...string) *authv3.CheckRequest {
+	request := newHttpRequest("GET", "/")
+	request.Attributes.Request.Http.Headers = nil
+	request.Attributes.Request.Http.HeaderMap = &corev3.HeaderMap{}
+	for i := 0; i+1 < len(lines); i += 2 {
+		request.Attributes.Request.Http.HeaderMap.Headers = append(request.Attributes.Request.Http.HeaderMap.Headers,
+			&corev3.HeaderValue{Key: lines[i], RawValue: []byte(lines[i+1])},
+		)
+	}
+	return request
+}
+
+func Test_newRequest_headers(t *testing.T) {
+	tests := []struct {
+		name       string
+		request    *authv3.CheckRequest
+		wantJoined map[string]string
+		wantValues map[string][]string
+	}{{
+		name: "merged headers",
+		request: func() *authv3.CheckRequest {
+			request := newHttpRequest("GET", "/")
+			// envoy merges the field lines of a repeated header
+			request.Attributes.Request.Http.Headers = map[string]string{
+				"x-forwarded-for": "203.0.113.7, 10.0.0.1,10.0.0.2",
+				"accept":          `text/html, application/json;q="0.9, or less"`,
+				"cookie":          "id=a; theme=dark",
+				"x-empty":         "",
+			}
+			return request
+		}(),
+		wantJoined: map[string]string{
+			"x-forwarded-for": "203.0.113.7, 10.0.0.1,10.0.0.2",
+			"accept":          `text/html, application/json;q="0.9, or less"`,
+			"cookie":          "id=a; theme=dark",
+			"x-empty":         "",
+		},
+		wantValues: map[string][]string{
+			"x-forwarded-for": {"203.0.113.7", "10.0.0.1", "10.0.0.2"},
+			"accept":          {"text/html", `application/json;q="0.9, or less"`},
+			"cookie":          {"id=a; theme=dark"},
+			"x-empty":         {},
+		},
+	}, {
+		name: "raw headers",
+		request: newRawHeadersRequest(
+			"x-forwarded-for", "203.0.113.7, 10.0.0.1",
+			"X-Forwarded-For", "10.0.0.2",
+			"set-cookie", "id=a; Expires=Wed, 21 Oct 2026 07:28:00 GMT",
+			"set-cookie", "theme=dark",
+		),
+		wantJoined: map[string]string{
+			"x-forwarded-for": "203.0.113.7, 10.0.0.1,10.0.0.2",
+			"set-cookie":      "id=a; Expires=Wed, 21 Oct 2026 07:28:00 GMT,theme=dark",
+		},
+		wantValues: map[string][]string{
+			"x-forwarded-for": {"203.0.113.7", "10.0.0.1", "10.0.0.2"},
+			"set-cookie":      {"id=a; Expires=Wed, 21 Oct 2026 07:28:00 GMT", "theme=dark"},
+		},
+	}, {
+		name:       "no headers",
+		request:    &authv3.CheckRequest{},
+		wantJoined: map[string]string{},
+		wantValues: map[string][]string{},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := newRequest(tt.request)
+			assert.Equal(t, tt.wantJoined, request["headers"])
+			assert.Equal(t, tt.wantValues, request["headerValues"])
+		})
+	}
+}
+
+func Test_compiler_Compile_request_headerValues(t *testing.T) {
+	merged := newHttpRequest("GET", "/")
+	merged.Attributes.Request.Http.Headers["x-forwarded-for"] = "203.0.113.7, 10.0.0.1"
+	raw := newRawHeadersRequest("x-forwarded-for", "203.0.113.7", "x-forwarded-for", "10.0.0.1", "x-tenant", "acme")
+	tests := []struct {
+		name       string
+		expression string
+	}{{
+		name:       "joined value",
+		expression: `request.headers["x-forwarded-for"] in ["203.0.113.7, 10.0.0.1", "203.0.113.7,10.0.0.1"]`,
+	}, {
+		name:       "values",
+		expression: `request.headerValues("x-forwarded-for") == ["203.0.113.7", "10.0.0.1"]`,
+	}, {
+		name:       "case insensitive name",
+		expression: `request.headerValues("X-Forwarded-For")[0] == "203.0.113.7" && request.headerValues("X-Tenant") == ["acme"]`,
+	}, {
+		name:       "missing header",
+		expression: `request.headerValues("authorization") == [] && !("authorization" in request.headers)`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", tt.expression+` ? envoy.Allowed().Response() : envoy.Denied(403).Response()`)
+			compiled, errs := NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			// the merged and raw encodings of the headers are read the same
+			for _, request := range []*authv3.CheckRequest{merged, raw} {
+				response, err := compiled.Evaluate(context.Background(), request)
+				assert.NoError(t, err)
+				assert.Equal(t, int32(0), response.GetStatus().GetCode(), fmt.Sprint(response))
+			}
+		})
+	}
+}
+
+func Test_compiler_Compile_request_headers(t *testing.T) {
+	// the request accessors are not headers, reading them doesn't require forwarding headers
+	policy := newPolicy("policy", `request.method == "GET" && request.query.limit == ["10"] ? envoy.Allowed().Response() : null`)
+	compiled, errs := NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	assert.Equal(t, HeaderUsage{Names: []string{}}, compiled.RequestHeaders)
+}
+
+func Test_compiler_Compile_request_grpcMethod(t *testing.T) {
+	// deny a grpc method, the other methods and the http requests are allowed
+	policy := newPolicy("policy", `has(request.grpc) && request.grpc.service == "acme.users.v1.UserService" && request.grpc.method == "DeleteUser" ? envoy.Denied(403).Response() : envoy.Allowed().Response()`)
+	compiled, errs := NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	tests := []struct {
+		name    string
+		request *authv3.CheckRequest
+		want    int32
+	}{{
+		name:    "denied method",
+		request: newGrpcRequest("/acme.users.v1.UserService/DeleteUser", "application/grpc"),
+		want:    7,
+	}, {
+		name:    "allowed method",
+		request: newGrpcRequest("/acme.users.v1.UserService/GetUser", "application/grpc"),
+		want:    0,
+	}, {
+		name:    "http request with the same path",
+		request: newHttpRequest("POST", "/acme.users.v1.UserService/DeleteUser"),
+		want:    0,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := compiled.Evaluate(context.Background(), tt.request)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, response.GetStatus().GetCode())
+		})
+	}
+}
+
+func Test_compiler_Compile_input(t *testing.T) {
+	request := newHttpRequest("POST", "/api/users?limit=10")
+	request.Attributes.Request.Http.Body = `{"name":"jane"}`
+	request.Attributes.Source = &authv3.AttributeContext_Peer{Principal: "spiffe://cluster.local/ns/default/sa/frontend"}
+	request.Attributes.ContextExtensions = map[string]string{"tenant": "acme"}
+	tests := []struct {
+		name       string
+		expression string
+	}{{
+		name:       "method",
+		expression: `input.attributes.request.http.method == object.attributes.request.http.method && input.attributes.request.http.method == request.method`,
+	}, {
+		name:       "path",
+		expression: `input.attributes.request.http.path == object.attributes.request.http.path && input.attributes.request.http.path == "/api/users?limit=10"`,
+	}, {
+		name:       "host and scheme",
+		expression: `input.attributes.request.http.host == request.host && input.attributes.request.http.scheme == request.scheme`,
+	}, {
+		name:       "headers",
+		expression: `input.attributes.request.http.headers == object.attributes.request.http.headers && input.attributes.request.http.headers["x-tenant"] == "acme"`,
+	}, {
+		name:       "body",
+		expression: `input.attributes.request.http.body == object.attributes.request.http.body && json.Parse(input.attributes.request.http.body).name == "jane"`,
+	}, {
+		name:       "source",
+		expression: `input.attributes.source.principal == source.principal`,
+	}, {
+		name:       "context extensions",
+		expression: `input.attributes.context_extensions == object.attributes.context_extensions`,
+	}, {
+		name:       "whole request",
+		expression: `input == object`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", tt.expression+` ? envoy.Allowed().Response() : envoy.Denied(403).Response()`)
+			compiled, errs := NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			response, err := compiled.Evaluate(context.Background(), request)
+			assert.NoError(t, err)
+			assert.Equal(t, int32(0), response.GetStatus().GetCode(), fmt.Sprint(response))
+		})
+	}
+}
+
+func Test_compiler_Compile_input_headers(t *testing.T) {
+	// the headers read through input are forwarded like the ones read through object
+	policy := newPolicy("policy", `input.attributes.request.http.headers[?"x-tenant"].orValue("") == "acme" ? envoy.Allowed().Response() : null`)
+	compiled, errs := NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	assert.Equal(t, HeaderUsage{Names: []string{"x-tenant"}}, compiled.RequestHeaders)
+}
+
+func Test_compiler_Compile_request_sizeTime(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	clock := testingclock.NewFakePassiveClock(now)
+	// newRequest returns a request of the given size received at the given time, a zero time isn't reported
+	newRequest := func(size int64, received time.Time) *authv3.CheckRequest {
+		request := newHttpRequest("POST", "/upload")
+		request.Attributes.Request.Http.Size = size
+		if !received.IsZero() {
+			request.Attributes.Request.Time = timestamppb.New(received)
+		}
+		return request
+	}
+	tests := []struct {
+		name       string
+		expression string
+		request    *authv3.CheckRequest
+		want       bool
+	}{{
+		name:       "size below the limit",
+		expression: `request.size <= 1024`,
+		request:    newRequest(512, time.Time{}),
+		want:       true,
+	}, {
+		name:       "size above the limit",
+		expression: `request.size <= 1024`,
+		request:    newRequest(2048, time.Time{}),
+	}, {
+		name:       "unknown size",
+		expression: `!has(request.size)`,
+		request:    newRequest(-1, time.Time{}),
+		want:       true,
+	}, {
+		name:       "empty body",
+		expression: `request.size == 0`,
+		request:    newRequest(0, time.Time{}),
+		want:       true,
+	}, {
+		name:       "recent request",
+		expression: `now - request.time < duration("5s")`,
+		request:    newRequest(0, now.Add(-time.Second)),
+		want:       true,
+	}, {
+		name:       "stale request",
+		expression: `now - request.time < duration("5s")`,
+		request:    newRequest(0, now.Add(-time.Minute)),
+	}, {
+		name:       "unreported time",
+		expression: `!has(request.time) && request.?time.orValue(now) == now`,
+		request:    newRequest(0, time.Time{}),
+		want:       true,
+	}, {
+		name:       "now",
+		expression: `now == timestamp("2024-01-02T03:04:05Z") && now.getHours() == 3`,
+		request:    newRequest(0, time.Time{}),
+		want:       true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", tt.expression+` ? envoy.Allowed().Response() : envoy.Denied(403).Response()`)
+			compiled, errs := NewCompiler(WithClock(clock)).Compile(policy)
+			require.Empty(t, errs)
+			response, err := compiled.Evaluate(context.Background(), tt.request)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, response.GetStatus().GetCode() == 0, fmt.Sprint(response))
+		})
+	}
+}
+
+func Test_compiler_Compile_request_nowShared(t *testing.T) {
+	clock := testingclock.NewFakeClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	compiler := NewCompiler(WithClock(clock))
+	first, errs := compiler.Compile(newPolicy("first", `now == timestamp("2024-01-02T03:04:05Z") ? envoy.Allowed().Response() : envoy.Denied(403).Response()`))
+	require.Empty(t, errs)
+	second, errs := compiler.Compile(newPolicy("second", `now == timestamp("2024-01-02T03:04:05Z") ? envoy.Allowed().Response() : envoy.Denied(403).Response()`))
+	require.Empty(t, errs)
+	// the policies evaluating the same check read the clock once
+	ctx, _ := utils.WithMemo(context.Background())
+	request := newHttpRequest("GET", "/")
+	response, err := first.Evaluate(ctx, request)
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), response.GetStatus().GetCode())
+	clock.Step(time.Second)
+	response, err = second.Evaluate(ctx, request)
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), response.GetStatus().GetCode())
+	// another check reads the clock again
+	response, err = second.Evaluate(context.Background(), request)
+	require.NoError(t, err)
+	assert.Equal(t, int32(7), response.GetStatus().GetCode())
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"slices"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/google/cel-go/common/types"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// ResponseMetadataNamespace is the filter metadata namespace carrying the upstream response of a response phase
+// check, a check request is in the response phase when its metadata context has this namespace. The namespace holds:
+//   - status, the status code of the upstream response
+//   - headers, the headers of the upstream response as a struct of strings, a repeated header is a list of strings
+//     with a value per field line
+const ResponseMetadataNamespace = "envoy.kyverno.io/response"
+
+// ResponseType is the type of the response variable, it holds the upstream response of a response phase check:
+//   - status is the status code of the upstream response, zero in the request phase
+//   - headers are the headers of the upstream response keyed by lowercase name, empty in the request phase
+//   - headerValues are the values of the headers of the upstream response, read with response.headerValues(name)
+//
+// The headers and headerValues follow the rules of the request variable ones.
+var ResponseType = types.NewMapType(types.StringType, types.DynType)
+
+// responseFields are the fields of the response variable
+var responseFields = []mapField{
+	{name: "status", celType: types.IntType},
+	{name: "headers", celType: types.NewMapType(types.StringType, types.StringType)},
+	{name: "headerValues", celType: headerValuesType},
+}
+
+// checkPhase returns the phase of a check request
+func checkPhase(r *authv3.CheckRequest) hub.Phase {
+	if _, ok := r.GetAttributes().GetMetadataContext().GetFilterMetadata()[ResponseMetadataNamespace]; ok {
+		return hub.PhaseResponse
+	}
+	return hub.PhaseRequest
+}
+
+// newResponse returns the response variable of a check request, values that are not strings are skipped
+func newResponse(r *authv3.CheckRequest) map[string]any {
+	fields := r.GetAttributes().GetMetadataContext().GetFilterMetadata()[ResponseMetadataNamespace].GetFields()
+	headers := fields["headers"].GetStructValue().GetFields()
+	// sort the names, the field lines of names differing by case are joined in a stable order
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var lines [][2]string
+	for _, name := range names {
+		switch value := headers[name].GetKind().(type) {
+		case *structpb.Value_StringValue:
+			lines = append(lines, [2]string{name, value.StringValue})
+		case *structpb.Value_ListValue:
+			for _, value := range value.ListValue.GetValues() {
+				if value, ok := value.GetKind().(*structpb.Value_StringValue); ok {
+					lines = append(lines, [2]string{name, value.StringValue})
+				}
+			}
+		}
+	}
+	response := map[string]any{
+		"status": int64(fields["status"].GetNumberValue()),
+	}
+	response["headers"], response["headerValues"] = newHeaders(lines)
+	return response
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func newResponseRequest(t *testing.T, status int, headers map[string]any) *authv3.CheckRequest {
+	t.Helper()
+	metadata, err := structpb.NewStruct(map[string]any{"status": status, "headers": headers})
+	require.NoError(t, err)
+	request := newHttpRequest("GET", "/")
+	request.Attributes.MetadataContext = &corev3.Metadata{
+		FilterMetadata: map[string]*structpb.Struct{ResponseMetadataNamespace: metadata},
+	}
+	return request
+}
+
+func Test_newResponse(t *testing.T) {
+	request := newResponseRequest(t, 503, map[string]any{
+		"Content-Type": "text/plain",
+		"x-retries":    2,
+		"set-cookie":   []any{"id=a; Expires=Wed, 21 Oct 2026 07:28:00 GMT", "theme=dark"},
+	})
+	assert.Equal(t, hub.PhaseResponse, checkPhase(request))
+	// header names are lowercased, values that are not strings are skipped
+	assert.Equal(t, map[string]any{
+		"status": int64(503),
+		"headers": map[string]string{
+			"content-type": "text/plain",
+			"set-cookie":   "id=a; Expires=Wed, 21 Oct 2026 07:28:00 GMT,theme=dark",
+		},
+		"headerValues": map[string][]string{
+			"content-type": {"text/plain"},
+			"set-cookie":   {"id=a; Expires=Wed, 21 Oct 2026 07:28:00 GMT", "theme=dark"},
+		},
+	}, newResponse(request))
+	// request phase checks have an empty response
+	assert.Equal(t, hub.PhaseRequest, checkPhase(newHttpRequest("GET", "/")))
+	assert.Equal(t, map[string]any{
+		"status":       int64(0),
+		"headers":      map[string]string{},
+		"headerValues": map[string][]string{},
+	}, newResponse(newHttpRequest("GET", "/")))
+}
+
+func Test_compiler_Compile_phase(t *testing.T) {
+	tests := []struct {
+		name        string
+		phase       hub.Phase
+		request     *authv3.CheckRequest
+		wantCode    codes.Code
+		wantSkipped bool
+	}{{
+		name:     "request policy, request phase",
+		request:  newHttpRequest("GET", "/"),
+		wantCode: codes.PermissionDenied,
+	}, {
+		name:        "request policy, response phase",
+		request:     newResponseRequest(t, 200, nil),
+		wantSkipped: true,
+	}, {
+		name:        "response policy, request phase",
+		phase:       hub.PhaseResponse,
+		request:     newHttpRequest("GET", "/"),
+		wantSkipped: true,
+	}, {
+		name:     "response policy, response phase",
+		phase:    hub.PhaseResponse,
+		request:  newResponseRequest(t, 200, nil),
+		wantCode: codes.PermissionDenied,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", `envoy.Denied(403).Response()`)
+			policy.Spec.Phase = tt.phase
+			compiled, errs := NewCompiler().Compile(policy)
+			assert.Empty(t, errs)
+			ctx, trace := WithEvaluationTrace(context.Background())
+			response, err := compiled.Evaluate(ctx, tt.request)
+			assert.NoError(t, err)
+			if tt.wantSkipped {
+				assert.Nil(t, response)
+				assert.Equal(t, "spec.phase", trace.Skipped)
+			} else {
+				assert.Equal(t, int32(tt.wantCode), response.GetStatus().GetCode())
+			}
+		})
+	}
+}
+
+func Test_compiler_Compile_response(t *testing.T) {
+	policy := newPolicy("policy",
+		`response.status >= 500 ? envoy.Denied(502).Response() : null`,
+		`"x-internal-token" in response.headers ? envoy.Allowed().WithoutHeader("x-internal-token").Response() : null`,
+	)
+	policy.Spec.Phase = hub.PhaseResponse
+	compiled, errs := NewCompiler().Compile(policy)
+	require.Empty(t, errs)
+	// the upstream failure is hidden
+	response, err := compiled.Evaluate(context.Background(), newResponseRequest(t, 503, nil))
+	require.NoError(t, err)
+	assert.Equal(t, int32(codes.PermissionDenied), response.GetStatus().GetCode())
+	assert.EqualValues(t, 502, response.GetDeniedResponse().GetStatus().GetCode())
+	// the internal header is removed from the upstream response
+	response, err = compiled.Evaluate(context.Background(), newResponseRequest(t, 200, map[string]any{"X-Internal-Token": "secret"}))
+	require.NoError(t, err)
+	assert.Equal(t, int32(codes.OK), response.GetStatus().GetCode())
+	assert.Equal(t, []string{"x-internal-token"}, response.GetOkResponse().GetHeadersToRemove())
+	// no rule matches
+	response, err = compiled.Evaluate(context.Background(), newResponseRequest(t, 200, nil))
+	require.NoError(t, err)
+	assert.Nil(t, response)
+}
+
+func Test_compiler_Compile_response_headerValues(t *testing.T) {
+	// deny the responses setting a session cookie without the secure attribute
+	policy := newPolicy("policy",
+		`response.headerValues("Set-Cookie").exists(c, c.startsWith("session=") && !c.contains("Secure")) ? envoy.Denied(502).Response() : null`,
+	)
+	policy.Spec.Phase = hub.PhaseResponse
+	compiled, errs := NewCompiler().Compile(policy)
+	require.Empty(t, errs)
+	response, err := compiled.Evaluate(context.Background(), newResponseRequest(t, 200, map[string]any{
+		"Set-Cookie": []any{"theme=dark; Expires=Wed, 21 Oct 2026 07:28:00 GMT", "session=abc; HttpOnly"},
+	}))
+	require.NoError(t, err)
+	assert.EqualValues(t, 502, response.GetDeniedResponse().GetStatus().GetCode())
+	response, err = compiled.Evaluate(context.Background(), newResponseRequest(t, 200, map[string]any{
+		"Set-Cookie": []any{"theme=dark; Expires=Wed, 21 Oct 2026 07:28:00 GMT", "session=abc; HttpOnly; Secure"},
+	}))
+	require.NoError(t, err)
+	assert.Nil(t, response)
+}
+
+func Test_compiler_Compile_phaseCache(t *testing.T) {
+	policy := newPolicy("policy", `envoy.Denied(403).Response()`)
+	policy.Spec.Phase = hub.PhaseResponse
+	policy.Spec.Cache = &hub.DecisionCache{Key: `object.attributes.request.http.path`}
+	policy.Spec.Cache.TTL.Duration = time.Minute
+	compiled, errs := NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	_, err := compiled.Cache.Key(context.Background(), newResponseRequest(t, 200, nil))
+	assert.NoError(t, err)
+	// the checks of the other phase are not cached
+	_, err = compiled.Cache.Key(context.Background(), newHttpRequest("GET", "/"))
+	assert.ErrorContains(t, err, "the policy is not evaluated in the Request phase")
+}
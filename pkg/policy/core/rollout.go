@@ -0,0 +1,75 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+type rollout struct {
+	key        cel.Program
+	percentage uint64
+	// seed is the policy name, policies rolled out to the same percentage don't sample the same requests
+	seed string
+}
+
+func compileRollout(env *compileEnv, programOptions []cel.ProgramOption, path *field.Path, in *hub.Rollout) (*rollout, field.ErrorList) {
+	if in == nil {
+		return nil, nil
+	}
+	if in.Percentage < 0 || in.Percentage > 100 {
+		return nil, field.ErrorList{field.Invalid(path.Child("percentage"), in.Percentage, "percentage must be between 0 and 100")}
+	}
+	ast, errs := compileExpression(env, path.Child("key"), in.Key)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if err := outputTypeError(ast, path.Child("key"), in.Key, "rollout key", types.StringType); err != nil {
+		return nil, field.ErrorList{err}
+	}
+	prog, err := env.Program(ast, programOptions...)
+	if err != nil {
+		return nil, field.ErrorList{field.Invalid(path.Child("key"), in.Key, err.Error())}
+	}
+	return &rollout{key: prog, percentage: uint64(in.Percentage)}, nil
+}
+
+// withSeed returns the rollout seeded with the policy name, nil if the rollout is nil
+func (r *rollout) withSeed(seed string) *rollout {
+	if r == nil {
+		return nil
+	}
+	out := *r
+	out.seed = seed
+	return &out
+}
+
+// bucket maps a key to one of the 10000 buckets requests are sampled by, a request is in the rollout when
+// its bucket is lower than the percentage of the buckets so raising the percentage keeps the sampled keys
+func (r *rollout) bucket(key string) uint64 {
+	sum := sha256.Sum256([]byte(r.seed + "\x00" + key))
+	return binary.BigEndian.Uint64(sum[:8]) % 10000
+}
+
+// sampled returns true when the request is in the rollout, a nil rollout samples every request
+func (r *rollout) sampled(ctx context.Context, data map[string]any) (bool, error) {
+	if r == nil {
+		return true, nil
+	}
+	out, details, err := r.key.ContextEval(ctx, data)
+	recordCost(ctx, details)
+	if err != nil {
+		return false, err
+	}
+	key, err := utils.ConvertToNative[string](out)
+	if err != nil {
+		return false, err
+	}
+	return r.bucket(key) < r.percentage*100, nil
+}
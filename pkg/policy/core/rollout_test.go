@@ -0,0 +1,130 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+)
+
+func newUserRequest(user string) *authv3.CheckRequest {
+	request := newHttpRequest("GET", "/")
+	request.Attributes.Request.Http.Headers["x-user"] = user
+	return request
+}
+
+func newRolloutPolicy(name string, percentage int32) *hub.AuthorizationPolicy {
+	policy := newPolicy(name, `envoy.Denied(403).Response()`)
+	policy.Spec.Rollout = &hub.Rollout{Percentage: percentage, Key: `object.attributes.request.http.headers[?"x-user"].orValue("")`}
+	return policy
+}
+
+// denied returns the users denied by the policy among the first n users
+func denied(t *testing.T, policy *hub.AuthorizationPolicy, n int) map[string]bool {
+	t.Helper()
+	compiled, errs := NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	out := map[string]bool{}
+	for i := range n {
+		user := strconv.Itoa(i)
+		response, err := compiled.Evaluate(context.Background(), newUserRequest(user))
+		assert.NoError(t, err)
+		if response != nil {
+			assert.Equal(t, int32(codes.PermissionDenied), response.GetStatus().GetCode())
+			out[user] = true
+		}
+	}
+	return out
+}
+
+func Test_compiler_Compile_rollout(t *testing.T) {
+	const requests = 10000
+	tests := []struct {
+		name       string
+		percentage int32
+	}{{
+		name:       "none",
+		percentage: 0,
+	}, {
+		name:       "ten percent",
+		percentage: 10,
+	}, {
+		name:       "half",
+		percentage: 50,
+	}, {
+		name:       "ninety percent",
+		percentage: 90,
+	}, {
+		name:       "all",
+		percentage: 100,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate := float64(len(denied(t, newRolloutPolicy("policy", tt.percentage), requests))) / requests
+			assert.InDelta(t, float64(tt.percentage)/100, rate, 0.02)
+		})
+	}
+}
+
+func Test_compiler_Compile_rolloutStable(t *testing.T) {
+	const requests = 2000
+	ten := denied(t, newRolloutPolicy("policy", 10), requests)
+	// the same keys are sampled by every evaluation and every compilation
+	assert.Equal(t, ten, denied(t, newRolloutPolicy("policy", 10), requests))
+	// raising the percentage keeps the sampled keys
+	fifty := denied(t, newRolloutPolicy("policy", 50), requests)
+	for user := range ten {
+		assert.True(t, fifty[user], user)
+	}
+	// policies don't sample the same keys
+	assert.NotEqual(t, ten, denied(t, newRolloutPolicy("other", 10), requests))
+}
+
+func Test_compiler_Compile_rolloutErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		rollout hub.Rollout
+		wantErr string
+	}{{
+		name:    "percentage too high",
+		rollout: hub.Rollout{Percentage: 101, Key: `request.path`},
+		wantErr: "spec.rollout.percentage: Invalid value: 101: percentage must be between 0 and 100",
+	}, {
+		name:    "negative percentage",
+		rollout: hub.Rollout{Percentage: -1, Key: `request.path`},
+		wantErr: "spec.rollout.percentage: Invalid value: -1: percentage must be between 0 and 100",
+	}, {
+		name:    "not a string",
+		rollout: hub.Rollout{Percentage: 10, Key: `size(request.path)`},
+		wantErr: "rollout key output is expected to be of type string",
+	}, {
+		name:    "syntax error",
+		rollout: hub.Rollout{Percentage: 10, Key: `request.`},
+		wantErr: "spec.rollout.key",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", `envoy.Denied(403).Response()`)
+			policy.Spec.Rollout = &tt.rollout
+			_, errs := NewCompiler().Compile(policy)
+			assert.ErrorContains(t, errs.ToAggregate(), tt.wantErr)
+		})
+	}
+}
+
+func Test_compiler_Compile_rolloutEvaluationError(t *testing.T) {
+	policy := newPolicy("policy", `envoy.Denied(403).Response()`)
+	policy.Spec.Rollout = &hub.Rollout{Percentage: 50, Key: `object.attributes.request.http.headers["x-user"]`}
+	compiled, errs := NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	_, err := compiled.Evaluate(context.Background(), &authv3.CheckRequest{})
+	var evalErr *EvaluationError
+	if assert.True(t, errors.As(err, &evalErr)) {
+		assert.Equal(t, "spec.rollout.key", evalErr.Field)
+	}
+}
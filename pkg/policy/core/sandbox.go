@@ -0,0 +1,47 @@
+package core
+
+import (
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+)
+
+// sandboxedFunctions are the functions reading external data, mapped to the number of arguments of the calls
+// reading it or to -1 when every call does: verifying a token with the key it is given doesn't fetch a key set
+var sandboxedFunctions = map[string]int{
+	"http.Get":   -1,
+	"http.Post":  -1,
+	"k8s.Get":    -1,
+	"jwt.Verify": 1,
+}
+
+// WithSandbox rejects the policies reading external data, the expressions calling http.Get, http.Post, k8s.Get
+// or jwt.Verify with the key set of the trusted issuers fail to compile. It applies to the identity sources too.
+func WithSandbox() CompilerOption {
+	return func(o *compilerOptions) {
+		o.sandbox = true
+	}
+}
+
+// sandboxValidator reports the calls to the functions reading external data as compilation errors
+type sandboxValidator struct{}
+
+func (sandboxValidator) Name() string {
+	return "kyverno.sandbox"
+}
+
+func (sandboxValidator) Validate(_ *cel.Env, _ cel.ValidatorConfig, checked *ast.AST, issues *cel.Issues) {
+	for _, expr := range ast.MatchDescendants(ast.NavigateAST(checked), ast.KindMatcher(ast.CallKind)) {
+		call := expr.AsCall()
+		if args, ok := sandboxedFunctions[call.FunctionName()]; ok && (args < 0 || len(call.Args()) == args) {
+			issues.ReportErrorAtID(expr.ID(), "%s reads external data, it is not available in sandbox mode", call.FunctionName())
+		}
+	}
+}
+
+// validators returns the validators of the expressions compiled by the compiler, in addition to the analyzers
+func (c *compiler) validators(analyzers ...cel.ASTValidator) []cel.ASTValidator {
+	if c.options.sandbox {
+		return append(analyzers, sandboxValidator{})
+	}
+	return analyzers
+}
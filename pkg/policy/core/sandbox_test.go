@@ -0,0 +1,80 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/http"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_compiler_Compile_sandbox(t *testing.T) {
+	keys, err := jwt.NewKeySet(nil)
+	require.NoError(t, err)
+	libraries := WithLibraries(http.Lib([]string{"example.com"}), jwt.KeySetLib(keys))
+	tests := []struct {
+		name       string
+		expression string
+		// wantErr is the sandbox error, the expression compiles without sandbox
+		wantErr string
+	}{{
+		name:       "http get",
+		expression: `http.Get("https://example.com/allowed").allowed ? envoy.Allowed().Response() : null`,
+		wantErr:    "http.Get reads external data, it is not available in sandbox mode",
+	}, {
+		name:       "http post",
+		expression: `http.Post("https://example.com/check", {"path": request.path}).allowed ? envoy.Allowed().Response() : null`,
+		wantErr:    "http.Post reads external data, it is not available in sandbox mode",
+	}, {
+		name:       "call in a comprehension",
+		expression: `["a", "b"].exists(x, http.Get("https://example.com/" + x).allowed) ? envoy.Allowed().Response() : null`,
+		wantErr:    "http.Get reads external data",
+	}, {
+		name:       "token verified with the key set",
+		expression: `jwt.Verify(object.attributes.request.http.headers[?"authorization"].orValue("")).Valid ? envoy.Allowed().Response() : null`,
+		wantErr:    "jwt.Verify reads external data",
+	}, {
+		name:       "token verified with a key",
+		expression: `jwt.Verify(object.attributes.request.http.headers[?"authorization"].orValue(""), "secret").Valid ? envoy.Allowed().Response() : null`,
+	}, {
+		name:       "no external data",
+		expression: `envoy.Allowed().Response()`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", tt.expression)
+			_, errs := NewCompiler(libraries).Compile(policy)
+			require.Empty(t, errs)
+			_, errs = NewCompiler(libraries, WithSandbox()).Compile(policy)
+			if tt.wantErr == "" {
+				assert.Empty(t, errs)
+				return
+			}
+			assert.ErrorContains(t, errs.ToAggregate(), "spec.authorizations[0].expression")
+			assert.ErrorContains(t, errs.ToAggregate(), tt.wantErr)
+		})
+	}
+}
+
+func Test_compiler_Compile_sandboxIdentitySources(t *testing.T) {
+	libraries := WithLibraries(http.Lib([]string{"example.com"}))
+	sources := WithIdentitySources(IdentitySource{Name: "remote", Subject: `http.Get("https://example.com/whoami").subject`})
+	policy := newPolicy("policy", `envoy.Allowed().Response()`)
+	_, errs := NewCompiler(libraries, sources).Compile(policy)
+	require.Empty(t, errs)
+	_, errs = NewCompiler(libraries, sources, WithSandbox()).Compile(policy)
+	assert.ErrorContains(t, errs.ToAggregate(), "identity.sources[0].subject")
+	assert.ErrorContains(t, errs.ToAggregate(), "http.Get reads external data, it is not available in sandbox mode")
+}
+
+func Test_compiler_Compile_sandboxCache(t *testing.T) {
+	cache := NewCompileCache()
+	libraries := WithLibraries(http.Lib([]string{"example.com"}))
+	policy := newPolicy("policy", `http.Get("https://example.com/allowed").allowed ? envoy.Allowed().Response() : null`)
+	_, errs := NewCompiler(libraries, WithCompileCache(cache)).Compile(policy)
+	require.Empty(t, errs)
+	// the spec compiled without sandbox isn't reused
+	_, errs = NewCompiler(libraries, WithCompileCache(cache), WithSandbox()).Compile(policy)
+	assert.NotEmpty(t, errs)
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"slices"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	engine "github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/envoy"
+)
+
+// variable declares a variable of the policy expressions environment
+type variable struct {
+	name    string
+	celType *types.Type
+	// fields are the keys of map variables, they are not checked by CEL
+	fields []mapField
+}
+
+// mapField declares a key of a map variable
+type mapField struct {
+	name    string
+	celType *types.Type
+	// optional fields are absent rather than empty when envoy didn't populate them
+	optional bool
+	fields   []mapField
+}
+
+// variables are declared in the environment policy expressions are compiled in, the schema is derived from them
+var variables = []variable{
+	{name: ObjectKey, celType: envoy.CheckRequest},
+	{name: VariablesKey, celType: engine.VariablesType},
+	{name: ContextKey, celType: ContextType, fields: contextFields},
+	{name: SourceKey, celType: SourceType, fields: sourceFields},
+	{name: AuthKey, celType: AuthType, fields: authFields},
+	{name: DestinationKey, celType: DestinationType, fields: destinationFields},
+	{name: RequestKey, celType: RequestType, fields: requestFields},
+	{name: ConnectionKey, celType: ConnectionType, fields: connectionFields},
+	{name: DataKey, celType: DataType},
+	{name: FilterMetadataKey, celType: metadataType},
+	{name: InputKey, celType: envoy.CheckRequest},
+	{name: IdentityKey, celType: IdentityType, fields: identityFields},
+	{name: ResponseKey, celType: ResponseType, fields: responseFields},
+	{name: NowKey, celType: types.TimestampType},
+}
+
+// variableOptions declares the variables in an environment, the legacy environment declares the input types as maps
+func variableOptions(legacy bool) []cel.EnvOption {
+	options := make([]cel.EnvOption, 0, len(variables))
+	for _, variable := range variables {
+		options = append(options, cel.Variable(variable.name, variable.declaredType(legacy)))
+	}
+	// the member functions of the variables
+	return append(options, headerValuesFunction)
+}
+
+func (v variable) declaredType(legacy bool) *types.Type {
+	if legacy && isInputType(v.celType) {
+		return legacyInputType
+	}
+	return v.celType
+}
+
+// SchemaField describes a field of a variable or of a message type
+type SchemaField struct {
+	Name string `json:"name"`
+	// Type is the CEL type of the field
+	Type string `json:"type"`
+	// Optional fields are absent when envoy didn't populate them, expressions test them with has()
+	Optional bool `json:"optional,omitempty"`
+	// Fields are the fields of map values
+	Fields []SchemaField `json:"fields,omitempty"`
+}
+
+// SchemaVariable describes a variable available to policy expressions
+type SchemaVariable struct {
+	Name string `json:"name"`
+	// Type is the CEL type of the variable, the fields of message types are described by Schema.Types
+	Type string `json:"type"`
+	// Fields are the fields of map variables
+	Fields []SchemaField `json:"fields,omitempty"`
+}
+
+// Schema describes the environment policy expressions are compiled in, it is meant for editor tooling
+type Schema struct {
+	Variables []SchemaVariable `json:"variables"`
+	// Types maps the message types reachable from the variables to their fields
+	Types map[string][]SchemaField `json:"types"`
+	// Libraries are the CEL libraries registered in the environment
+	Libraries []string `json:"libraries"`
+}
+
+// NewSchema describes the environment of the compiler created with the same options, the variables
+// object type has no fields, the fields of the variables object are declared by every policy
+func NewSchema(opts ...CompilerOption) (Schema, error) {
+	var options compilerOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	// the environment is built like the compiler builds it
+	base, err := engine.NewEnv(options.libraries...)
+	if err != nil {
+		return Schema{}, err
+	}
+	env, err := base.Extend(append(variableOptions(false), cel.CustomTypeProvider(newInputTypeProvider(engine.NewVariablesProvider(base.CELTypeProvider()))))...)
+	if err != nil {
+		return Schema{}, err
+	}
+	schema := Schema{
+		Types:     map[string][]SchemaField{},
+		Libraries: env.Libraries(),
+	}
+	slices.Sort(schema.Libraries)
+	provider := env.CELTypeProvider()
+	for _, variable := range variables {
+		schema.Variables = append(schema.Variables, SchemaVariable{
+			Name:   variable.name,
+			Type:   variable.celType.String(),
+			Fields: schemaFields(variable.fields),
+		})
+		describeType(provider, variable.celType, schema.Types)
+	}
+	return schema, nil
+}
+
+func schemaFields(fields []mapField) []SchemaField {
+	var out []SchemaField
+	for _, f := range fields {
+		out = append(out, SchemaField{
+			Name:     f.name,
+			Type:     f.celType.String(),
+			Optional: f.optional,
+			Fields:   schemaFields(f.fields),
+		})
+	}
+	return out
+}
+
+// describeType adds the fields of the message type and the message types reachable from it to the described types
+func describeType(provider types.Provider, celType *types.Type, described map[string][]SchemaField) {
+	for _, parameter := range celType.Parameters() {
+		describeType(provider, parameter, described)
+	}
+	if celType.Kind() != types.StructKind {
+		return
+	}
+	name := celType.TypeName()
+	if _, ok := described[name]; ok {
+		return
+	}
+	names, ok := provider.FindStructFieldNames(name)
+	// register the type before its fields, messages can be recursive
+	described[name] = []SchemaField{}
+	if !ok {
+		return
+	}
+	slices.Sort(names)
+	fields := make([]SchemaField, 0, len(names))
+	for _, fieldName := range names {
+		fieldType, ok := provider.FindStructFieldType(name, fieldName)
+		if !ok {
+			continue
+		}
+		fields = append(fields, SchemaField{Name: fieldName, Type: fieldType.Type.String()})
+		describeType(provider, fieldType.Type, described)
+	}
+	described[name] = fields
+}
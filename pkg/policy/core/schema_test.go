@@ -0,0 +1,117 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/http"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/structpb"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+)
+
+func TestNewSchema(t *testing.T) {
+	schema, err := NewSchema()
+	assert.NoError(t, err)
+	// every declared variable is described
+	var names []string
+	for _, variable := range schema.Variables {
+		names = append(names, variable.Name)
+	}
+	for _, variable := range variables {
+		assert.Contains(t, names, variable.name)
+	}
+	// every described variable is declared in the compiler environment
+	policy := newPolicy("policy", "envoy.Allowed().Response()")
+	for _, variable := range schema.Variables {
+		policy.Spec.MatchConditions = append(policy.Spec.MatchConditions, admissionregistrationv1.MatchCondition{
+			Name:       variable.Name,
+			Expression: fmt.Sprintf("type(%s) == type(%s)", variable.Name, variable.Name),
+		})
+	}
+	_, errs := NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	// message types are described with their fields
+	assert.Equal(t, "envoy.service.auth.v3.CheckRequest", schema.Variables[0].Type)
+	assert.Equal(t, []SchemaField{{Name: "attributes", Type: "envoy.service.auth.v3.AttributeContext"}}, schema.Types["envoy.service.auth.v3.CheckRequest"])
+	assert.Contains(t, schema.Types["envoy.service.auth.v3.AttributeContext.HttpRequest"], SchemaField{Name: "headers", Type: "map(string, string)"})
+	assert.Contains(t, schema.Libraries, "kyverno.jwt")
+	assert.True(t, slices.IsSorted(schema.Libraries))
+}
+
+func TestNewSchema_libraries(t *testing.T) {
+	schema, err := NewSchema()
+	assert.NoError(t, err)
+	assert.NotContains(t, schema.Libraries, "kyverno.http")
+	// libraries registered with the compiler options are described
+	schema, err = NewSchema(WithLibraries(http.Lib(nil)))
+	assert.NoError(t, err)
+	assert.Contains(t, schema.Libraries, "kyverno.http")
+}
+
+// assertFields asserts the keys of a map variable are the declared fields, absent optional fields are tolerated
+func assertFields(t *testing.T, path string, fields []SchemaField, value map[string]any) {
+	t.Helper()
+	declared := map[string]SchemaField{}
+	for _, field := range fields {
+		declared[field.Name] = field
+		if _, ok := value[field.Name]; !ok {
+			assert.True(t, field.Optional, "%s.%s is declared but missing", path, field.Name)
+		}
+	}
+	for key, value := range value {
+		field, ok := declared[key]
+		if !assert.True(t, ok, "%s.%s is not declared", path, key) {
+			continue
+		}
+		if nested, ok := value.(map[string]any); ok && len(field.Fields) != 0 {
+			assertFields(t, path+"."+key, field.Fields, nested)
+		}
+	}
+}
+
+func TestNewSchema_fields(t *testing.T) {
+	schema, err := NewSchema()
+	assert.NoError(t, err)
+	metadata, err := structpb.NewStruct(map[string]any{"issuer": "acme"})
+	assert.NoError(t, err)
+	// a request populating every field
+	request := newHttpRequest("GET", "/api?page=1")
+	attributes := request.Attributes
+	attributes.ContextExtensions = map[string]string{"tenant": "acme"}
+	attributes.MetadataContext = &corev3.Metadata{FilterMetadata: map[string]*structpb.Struct{jwtAuthnFilter: metadata}}
+	attributes.RouteMetadataContext = &corev3.Metadata{FilterMetadata: map[string]*structpb.Struct{"route": metadata}}
+	attributes.TlsSession = &authv3.AttributeContext_TLSSession{Sni: "api.example.com"}
+	attributes.Source = &authv3.AttributeContext_Peer{Principal: "spiffe://cluster.local/ns/default/sa/frontend", Certificate: newPeerCertificatePEM(t)}
+	attributes.Destination = &authv3.AttributeContext_Peer{
+		Principal: "spiffe://cluster.local/ns/default/sa/backend",
+		Service:   "backend",
+		Address:   &corev3.Address{Address: &corev3.Address_SocketAddress{SocketAddress: &corev3.SocketAddress{Address: "10.0.0.1", PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: 8080}}}},
+	}
+	values := map[string]map[string]any{
+		ContextKey:     newContext(request),
+		SourceKey:      newSource(request),
+		AuthKey:        newAuth(request),
+		DestinationKey: newDestination(request),
+		RequestKey:     newRequest(request),
+		ConnectionKey:  newConnection(request),
+	}
+	identity, err := (&identityChain{}).resolve(context.Background(), nil)
+	assert.NoError(t, err)
+	values[IdentityKey] = identity
+	values[ResponseKey] = newResponse(request)
+	for _, variable := range schema.Variables {
+		value, ok := values[variable.Name]
+		if !ok {
+			assert.Empty(t, variable.Fields, variable.Name)
+			continue
+		}
+		assertFields(t, variable.Name, variable.Fields, value)
+	}
+	// optional fields are absent from an empty request
+	assertFields(t, ConnectionKey, schema.Variables[7].Fields, newConnection(&authv3.CheckRequest{}))
+}
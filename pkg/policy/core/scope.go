@@ -0,0 +1,54 @@
+package core
+
+import (
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// DefaultScopeKey is the context extension the scope of policies is matched against, unless the compiler is
+// created with WithScopeKey
+const DefaultScopeKey = "listener"
+
+// WithScopeKey sets the context extension identifying the listener or filter chain of a request, the scope of
+// a policy lists the values of this context extension the policy applies to
+func WithScopeKey(key string) CompilerOption {
+	return func(o *compilerOptions) {
+		o.scopeKey = key
+	}
+}
+
+type scope struct {
+	key    string
+	values sets.Set[string]
+}
+
+func compileScope(path *field.Path, key string, values []string) (*scope, field.ErrorList) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	if key == "" {
+		key = DefaultScopeKey
+	}
+	out := &scope{key: key, values: sets.New[string]()}
+	for i, value := range values {
+		if value == "" {
+			return nil, field.ErrorList{field.Invalid(path.Index(i), value, "scope values can't be empty")}
+		}
+		if out.values.Has(value) {
+			return nil, field.ErrorList{field.Duplicate(path.Index(i), value)}
+		}
+		out.values.Insert(value)
+	}
+	return out, nil
+}
+
+// match returns the context extension value of the request and true when the policy applies to the request,
+// a nil scope applies to every request
+func (s *scope) match(r *authv3.CheckRequest) (string, bool) {
+	if s == nil {
+		return "", true
+	}
+	value, ok := r.GetAttributes().GetContextExtensions()[s.key]
+	return value, ok && s.values.Has(value)
+}
@@ -0,0 +1,130 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func newListenerRequest(key, listener string) *authv3.CheckRequest {
+	request := newHttpRequest("GET", "/")
+	request.Attributes.ContextExtensions = map[string]string{key: listener}
+	return request
+}
+
+func Test_compiler_Compile_scope(t *testing.T) {
+	tests := []struct {
+		name     string
+		scopeKey string
+		scope    []string
+		request  *authv3.CheckRequest
+		want     bool
+	}{{
+		name:    "no scope",
+		request: newHttpRequest("GET", "/"),
+		want:    true,
+	}, {
+		name:    "listener in scope",
+		scope:   []string{"public", "partners"},
+		request: newListenerRequest(DefaultScopeKey, "partners"),
+		want:    true,
+	}, {
+		name:    "listener out of scope",
+		scope:   []string{"public"},
+		request: newListenerRequest(DefaultScopeKey, "internal"),
+	}, {
+		name:    "request without listener",
+		scope:   []string{"public"},
+		request: newHttpRequest("GET", "/"),
+	}, {
+		name:    "empty request",
+		scope:   []string{"public"},
+		request: &authv3.CheckRequest{},
+	}, {
+		name:     "configured key",
+		scopeKey: "filter-chain",
+		scope:    []string{"public"},
+		request:  newListenerRequest("filter-chain", "public"),
+		want:     true,
+	}, {
+		name:     "default key ignored with a configured key",
+		scopeKey: "filter-chain",
+		scope:    []string{"public"},
+		request:  newListenerRequest(DefaultScopeKey, "public"),
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", `envoy.Denied(403).Response()`)
+			policy.Spec.Scope = tt.scope
+			var opts []CompilerOption
+			if tt.scopeKey != "" {
+				opts = append(opts, WithScopeKey(tt.scopeKey))
+			}
+			compiled, errs := NewCompiler(opts...).Compile(policy)
+			assert.Empty(t, errs)
+			ctx, trace := WithEvaluationTrace(context.Background())
+			response, err := compiled.Evaluate(ctx, tt.request)
+			assert.NoError(t, err)
+			if tt.want {
+				assert.Equal(t, int32(codes.PermissionDenied), response.GetStatus().GetCode())
+			} else {
+				assert.Nil(t, response)
+				assert.Equal(t, "spec.scope", trace.Skipped)
+			}
+		})
+	}
+}
+
+func Test_compiler_Compile_scopeErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		scope     []string
+		wantField string
+		wantType  field.ErrorType
+	}{{
+		name:      "empty value",
+		scope:     []string{"public", ""},
+		wantField: "spec.scope[1]",
+		wantType:  field.ErrorTypeInvalid,
+	}, {
+		name:      "duplicate value",
+		scope:     []string{"public", "public"},
+		wantField: "spec.scope[1]",
+		wantType:  field.ErrorTypeDuplicate,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newPolicy("policy", `envoy.Allowed().Response()`)
+			policy.Spec.Scope = tt.scope
+			_, errs := NewCompiler().Compile(policy)
+			if assert.Len(t, errs, 1) {
+				assert.Equal(t, tt.wantField, errs[0].Field)
+				assert.Equal(t, tt.wantType, errs[0].Type)
+			}
+		})
+	}
+}
+
+func Test_compiler_Compile_scopeCache(t *testing.T) {
+	policy := newPolicy("policy", `envoy.Denied(403).Response()`)
+	policy.Spec.Scope = []string{"public"}
+	policy.Spec.Cache = &hub.DecisionCache{Key: `object.attributes.request.http.path`}
+	policy.Spec.Cache.TTL.Duration = time.Minute
+	compiled, errs := NewCompiler().Compile(policy)
+	assert.Empty(t, errs)
+	// requests in and out of the scope don't share their cached decisions
+	in, err := compiled.Cache.Key(context.Background(), newListenerRequest(DefaultScopeKey, "public"))
+	assert.NoError(t, err)
+	out, err := compiled.Cache.Key(context.Background(), newListenerRequest(DefaultScopeKey, "internal"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, in, out)
+	missing, err := compiled.Cache.Key(context.Background(), newHttpRequest("GET", "/"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, out, missing)
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+)
+
+type staticProvider struct {
+	lock     sync.RWMutex
+	sources  []*hub.AuthorizationPolicy
+	policies []CompiledPolicy
+}
+
+// NewStaticProvider returns a provider serving a fixed set of policies, it fails if any of the policies fails to compile
+func NewStaticProvider(compiler Compiler, policies ...*hub.AuthorizationPolicy) (Provider, error) {
+	// don't reorder the caller slice
+	sources := slices.Clone(policies)
+	compiled, err := CompilePolicies(compiler, sources)
+	if err != nil {
+		return nil, err
+	}
+	return &staticProvider{
+		sources:  sources,
+		policies: compiled,
+	}, nil
+}
+
+func (p *staticProvider) CompiledPolicies(context.Context) ([]CompiledPolicy, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.policies, nil
+}
+
+func (p *staticProvider) HasSynced() bool {
+	return true
+}
+
+// Recompile compiles the policies of the provider with the compiler, see Recompiler
+func (p *staticProvider) Recompile(compiler Compiler) (func(bool), error) {
+	compiled, err := CompilePolicies(compiler, p.sources)
+	if err != nil {
+		return nil, err
+	}
+	return func(commit bool) {
+		if commit {
+			p.lock.Lock()
+			defer p.lock.Unlock()
+			p.policies = compiled
+		}
+	}, nil
+}
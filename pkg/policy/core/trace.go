@@ -0,0 +1,51 @@
+package core
+
+import (
+	"context"
+)
+
+type traceKey struct{}
+
+// EvaluationTrace records the path a policy evaluation took, it explains why the policy returned a response or not
+type EvaluationTrace struct {
+	// Skipped is the path of the condition that skipped the policy, empty when the policy wasn't skipped
+	Skipped string
+	// Authorization is the path of the authorization that returned the response, empty when none did
+	Authorization string
+	// Err is the error that failed the evaluation, including errors ignored by the failure policy
+	Err error
+}
+
+// WithEvaluationTrace returns a context recording the path of the policy evaluation done with it,
+// a trace must not be shared by concurrent evaluations
+func WithEvaluationTrace(ctx context.Context) (context.Context, *EvaluationTrace) {
+	trace := &EvaluationTrace{}
+	return context.WithValue(ctx, traceKey{}, trace), trace
+}
+
+// traceFromContext returns the trace of the context, nil if there is none
+func traceFromContext(ctx context.Context) *EvaluationTrace {
+	trace, _ := ctx.Value(traceKey{}).(*EvaluationTrace)
+	return trace
+}
+
+// recordSkipped records the condition that skipped the policy, if the context has a trace
+func recordSkipped(ctx context.Context, path string) {
+	if trace := traceFromContext(ctx); trace != nil {
+		trace.Skipped = path
+	}
+}
+
+// recordAuthorization records the authorization that returned the response, if the context has a trace
+func recordAuthorization(ctx context.Context, path string) {
+	if trace := traceFromContext(ctx); trace != nil {
+		trace.Authorization = path
+	}
+}
+
+// recordError records the error that failed the evaluation, if the context has a trace
+func recordError(ctx context.Context, err error) {
+	if trace := traceFromContext(ctx); trace != nil {
+		trace.Err = err
+	}
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/stretchr/testify/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/utils/ptr"
+)
+
+func TestWithEvaluationTrace(t *testing.T) {
+	tests := []struct {
+		name              string
+		policy            func() *hub.AuthorizationPolicy
+		wantSkipped       string
+		wantAuthorization string
+		wantErr           string
+	}{{
+		name: "second authorization",
+		policy: func() *hub.AuthorizationPolicy {
+			return newPolicy("policy", `false ? envoy.Allowed().Response() : null`, `envoy.Allowed().Response()`)
+		},
+		wantAuthorization: "spec.authorizations[1].expression",
+	}, {
+		name: "no response",
+		policy: func() *hub.AuthorizationPolicy {
+			return newPolicy("policy", `false ? envoy.Allowed().Response() : null`)
+		},
+	}, {
+		name: "match condition",
+		policy: func() *hub.AuthorizationPolicy {
+			policy := newPolicy("policy", `envoy.Allowed().Response()`)
+			policy.Spec.MatchConditions = []admissionregistrationv1.MatchCondition{{Name: "true", Expression: "true"}, {Name: "false", Expression: "false"}}
+			return policy
+		},
+		wantSkipped: "spec.matchConditions[1]",
+	}, {
+		name: "exclude condition",
+		policy: func() *hub.AuthorizationPolicy {
+			policy := newPolicy("policy", `envoy.Allowed().Response()`)
+			policy.Spec.ExcludeConditions = []admissionregistrationv1.MatchCondition{{Name: "true", Expression: "true"}}
+			return policy
+		},
+		wantSkipped: "spec.excludeConditions[0]",
+	}, {
+		name: "ignored failure",
+		policy: func() *hub.AuthorizationPolicy {
+			policy := newPolicy("policy", `object.attributes.request.http.headers["missing"] == "foo" ? envoy.Allowed().Response() : null`)
+			policy.Spec.FailurePolicy = ptr.To(admissionregistrationv1.Ignore)
+			return policy
+		},
+		wantErr: "no such key: missing",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compiled, errs := NewCompiler().Compile(tt.policy())
+			assert.Empty(t, errs)
+			ctx, trace := WithEvaluationTrace(context.Background())
+			_, err := compiled.Evaluate(ctx, &authv3.CheckRequest{})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantSkipped, trace.Skipped)
+			assert.Equal(t, tt.wantAuthorization, trace.Authorization)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, trace.Err, tt.wantErr)
+			} else {
+				assert.NoError(t, trace.Err)
+			}
+		})
+	}
+}
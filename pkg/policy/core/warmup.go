@@ -0,0 +1,78 @@
+package core
+
+import (
+	"context"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/utils"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// externalFunctions are the functions calling external services, or starting to watch resources for k8s.Get,
+// they fail without calling them when a policy is warmed up
+var externalFunctions = sets.New("http.Get", "http.Post", "k8s.Get", "jwt.Verify")
+
+type warmUpKey struct{}
+
+// warmingUp returns true if the context is the one of a warm up evaluation
+func warmingUp(ctx context.Context) bool {
+	warm, _ := ctx.Value(warmUpKey{}).(bool)
+	return warm
+}
+
+// guardExternalCalls decorates the calls to external functions so that they fail during warm up evaluations,
+// it must be registered after the decorators of the libraries so that it wraps the calls they replaced
+func guardExternalCalls(i interpreter.Interpretable) (interpreter.Interpretable, error) {
+	call, ok := i.(interpreter.InterpretableCall)
+	if !ok || !externalFunctions.Has(call.Function()) {
+		return i, nil
+	}
+	return &guardedCall{InterpretableCall: call}, nil
+}
+
+type guardedCall struct {
+	interpreter.InterpretableCall
+}
+
+func (c *guardedCall) Eval(activation interpreter.Activation) ref.Val {
+	if warmingUp(utils.ContextFrom(activation)) {
+		return types.NewErr("%s is not called when warming up a policy", c.Function())
+	}
+	return c.InterpretableCall.Eval(activation)
+}
+
+// warmUpRequest is a minimal check request, empty messages are set so that evaluating the accessors of the
+// request initializes their types
+func warmUpRequest() *authv3.CheckRequest {
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Source:      &authv3.AttributeContext_Peer{},
+			Destination: &authv3.AttributeContext_Peer{},
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Method:  "GET",
+					Path:    "/",
+					Headers: map[string]string{":method": "GET", ":path": "/"},
+				},
+			},
+		},
+	}
+}
+
+// WarmUp evaluates the policy and its cache key once against a synthetic request, the initializations
+// happening on the first evaluation (protobuf reflection of the request types, type conversions...) are not
+// paid by the first request checked with the policy. The decision and errors are discarded and functions
+// calling external services fail without calling them, the programs are planned when the policy is compiled.
+func WarmUp(ctx context.Context, policy CompiledPolicy) {
+	ctx = context.WithValue(ctx, warmUpKey{}, true)
+	request := warmUpRequest()
+	if policy.Cache != nil {
+		_, _ = policy.Cache.Key(ctx, request)
+	}
+	if policy.Evaluate != nil {
+		_, _ = policy.Evaluate(ctx, request)
+	}
+}
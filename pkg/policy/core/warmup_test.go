@@ -0,0 +1,73 @@
+package core
+
+import (
+	"context"
+	nethttp "net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz/cel/libs/http"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+)
+
+func TestWarmUp(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte(`{"allowed": true}`))
+	}))
+	defer server.Close()
+	libraries := WithLibraries(http.Lib([]string{server.Listener.Addr().String()}, http.WithTimeout(time.Minute)))
+	policy := newPolicy("policy", `http.Get("`+server.URL+`").allowed == true ? envoy.Allowed().Response() : null`)
+	policy.Spec.Cache = &hub.DecisionCache{Key: `string(http.Get("` + server.URL + `").allowed)`}
+	policy.Spec.Cache.TTL.Duration = time.Minute
+	compiled, errs := NewCompiler(libraries).Compile(policy)
+	assert.Empty(t, errs)
+	// warming up calls neither the policy nor the cache key service
+	WarmUp(context.Background(), compiled)
+	assert.Zero(t, calls.Load())
+	// requests call the service
+	response, err := compiled.Evaluate(context.Background(), newHttpRequest("GET", "/"))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(codes.OK), response.GetStatus().GetCode())
+	assert.Equal(t, int32(1), calls.Load())
+	key, err := compiled.Cache.Key(context.Background(), newHttpRequest("GET", "/"))
+	assert.NoError(t, err)
+	assert.Equal(t, "true", key)
+}
+
+func TestWarmUp_noEvaluate(t *testing.T) {
+	assert.NotPanics(t, func() { WarmUp(context.Background(), CompiledPolicy{}) })
+}
+
+func BenchmarkWarmUp_firstRequest(b *testing.B) {
+	request := newHttpRequest("GET", "/api/users?limit=10")
+	policy := newPolicy("policy", `request.path.startsWith("/api") && object.attributes.request.http.headers[?"x-tenant"].orValue("") == "acme" ? envoy.Allowed().Response() : envoy.Denied(403).Response()`)
+	for _, warm := range []bool{false, true} {
+		name := "cold"
+		if warm {
+			name = "warmed"
+		}
+		b.Run(name, func(b *testing.B) {
+			for range b.N {
+				// only the first request checked with a freshly compiled policy is measured
+				b.StopTimer()
+				compiled, errs := NewCompiler().Compile(policy)
+				if len(errs) > 0 {
+					b.Fatal(errs)
+				}
+				if warm {
+					WarmUp(context.Background(), compiled)
+				}
+				b.StartTimer()
+				if _, err := compiled.Evaluate(context.Background(), request); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package core
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuild_wasm(t *testing.T) {
+	if testing.Short() {
+		t.Skip("building for wasm targets is slow")
+	}
+	tests := []struct {
+		name   string
+		goos   string
+		pkg    string
+		output string
+	}{{
+		name: "core wasip1",
+		goos: "wasip1",
+		pkg:  ".",
+	}, {
+		name: "core js",
+		goos: "js",
+		pkg:  ".",
+	}, {
+		name:   "entrypoint wasip1",
+		goos:   "wasip1",
+		pkg:    "../../../wasm",
+		output: os.DevNull,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := []string{"build"}
+			if tt.output != "" {
+				args = append(args, "-o", tt.output)
+			}
+			cmd := exec.Command("go", append(args, tt.pkg)...)
+			cmd.Env = append(os.Environ(), "GOOS="+tt.goos, "GOARCH=wasm")
+			out, err := cmd.CombinedOutput()
+			assert.NoError(t, err, string(out))
+		})
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	// the core must not depend on controller runtime nor on the servers
+	out, err := exec.Command("go", "list", "-deps", ".").Output()
+	assert.NoError(t, err)
+	for _, dep := range strings.Split(string(out), "\n") {
+		assert.False(t, strings.HasPrefix(dep, "sigs.k8s.io/controller-runtime"), dep)
+		assert.NotContains(t, []string{
+			"github.com/kyverno/kyverno-envoy-plugin/pkg/authz",
+			"github.com/kyverno/kyverno-envoy-plugin/pkg/server",
+			"github.com/kyverno/kyverno-envoy-plugin/pkg/policy",
+		}, dep)
+	}
+}
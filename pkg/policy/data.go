@@ -0,0 +1,182 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/go-logr/logr"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+const (
+	// dataSourceIndex indexes the policies by the resources their data sources reference
+	dataSourceIndex = "spec.data"
+	configMapKind   = "ConfigMap"
+	secretKind      = "Secret"
+)
+
+// dataSourceKey is the index key of a resource referenced by data sources
+func dataSourceKey(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// indexDataSources returns the index keys of the resources referenced by the data sources of a policy
+func indexDataSources(obj client.Object) []string {
+	policy, ok := obj.(*v1alpha1.AuthorizationPolicy)
+	if !ok {
+		return nil
+	}
+	var keys []string
+	for _, source := range policy.Spec.Data {
+		if source.ConfigMap != nil {
+			keys = append(keys, dataSourceKey(configMapKind, source.ConfigMap.Namespace, source.ConfigMap.Name))
+		}
+		if source.Secret != nil {
+			keys = append(keys, dataSourceKey(secretKind, source.Secret.Namespace, source.Secret.Name))
+		}
+	}
+	return keys
+}
+
+// dependentPolicies returns the policies whose data sources reference a resource of the kind,
+// they are reconciled again when the resource is created, updated or deleted
+func (r *policyReconciler) dependentPolicies(kind string) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []ctrl.Request {
+		var list v1alpha1.AuthorizationPolicyList
+		key := dataSourceKey(kind, obj.GetNamespace(), obj.GetName())
+		if err := r.client.List(ctx, &list, client.MatchingFields{dataSourceIndex: key}); err != nil {
+			r.logger.Error(err, "failed to list the policies referencing a data source", "resource", key)
+			return nil
+		}
+		requests := make([]ctrl.Request, 0, len(list.Items))
+		for i := range list.Items {
+			requests = append(requests, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&list.Items[i])})
+		}
+		return requests
+	}
+}
+
+// resolveData reads the entries of the data sources, a data source referencing a resource that doesn't exist
+// has no entries and the evaluations reading it fail
+func (r *policyReconciler) resolveData(ctx context.Context, logger logr.Logger, sources []hub.DataSource) (core.Data, error) {
+	data := core.Data{}
+	for _, source := range sources {
+		var entries map[string]string
+		var err error
+		switch {
+		case source.ConfigMap != nil:
+			var configMap corev1.ConfigMap
+			err = r.client.Get(ctx, types.NamespacedName{Namespace: source.ConfigMap.Namespace, Name: source.ConfigMap.Name}, &configMap)
+			entries = make(map[string]string, len(configMap.Data)+len(configMap.BinaryData))
+			for key, value := range configMap.Data {
+				entries[key] = value
+			}
+			for key, value := range configMap.BinaryData {
+				entries[key] = string(value)
+			}
+		case source.Secret != nil:
+			var secret corev1.Secret
+			err = r.client.Get(ctx, types.NamespacedName{Namespace: source.Secret.Namespace, Name: source.Secret.Name}, &secret)
+			entries = make(map[string]string, len(secret.Data))
+			for key, value := range secret.Data {
+				entries[key] = string(value)
+			}
+		default:
+			// the policy fails to compile
+			continue
+		}
+		if errors.IsNotFound(err) {
+			logger.Info("data source resource not found", "dataSource", source.Name, "resource", core.DataSourceResource(source))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		data[source.Name] = entries
+	}
+	return data, nil
+}
+
+// compile compiles the policy, a policy declaring data sources fails to compile unless the reconciler resolves them.
+// A compiler panicking on an unexpected policy fails the compilation instead of crashing the server.
+func (r *policyReconciler) compile(policy *hub.AuthorizationPolicy) (compiled CompiledPolicy, errs field.ErrorList) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			compiled, errs = CompiledPolicy{}, field.ErrorList{field.InternalError(field.NewPath("spec"), fmt.Errorf("compiler panicked: %v", recovered))}
+		}
+	}()
+	if len(policy.Spec.Data) > 0 && !r.dataSources {
+		return CompiledPolicy{}, field.ErrorList{field.Forbidden(field.NewPath("spec", "data"), "data sources are disabled")}
+	}
+	return r.compiler.Compile(policy)
+}
+
+// dataBinding is a compiled policy declaring data sources and the entries it is evaluated with
+type dataBinding struct {
+	compiled CompiledPolicy
+	data     core.Data
+}
+
+// bindData returns the policy evaluated with the entries of its data sources. The decision cache is replaced,
+// decisions taken with other entries are not returned.
+func bindData(compiled CompiledPolicy, data core.Data) CompiledPolicy {
+	evaluate := compiled.Evaluate
+	compiled.Evaluate = func(ctx context.Context, r *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+		return evaluate(core.WithData(ctx, data), r)
+	}
+	if breakGlass := compiled.BreakGlass; breakGlass != nil {
+		compiled.BreakGlass = func(ctx context.Context, r *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+			return breakGlass(core.WithData(ctx, data), r)
+		}
+	}
+	if compiled.Cache != nil {
+		cache := *compiled.Cache
+		key := cache.Key
+		cache.Key = func(ctx context.Context, r *authv3.CheckRequest) (string, error) {
+			return key(core.WithData(ctx, data), r)
+		}
+		compiled.Cache = &cache
+	}
+	return compiled
+}
+
+// bind returns the compiled policy evaluated with the entries of its data sources and records them,
+// policies without data sources are returned as is
+func (r *policyReconciler) bind(key types.NamespacedName, spec *hub.AuthorizationPolicySpec, compiled CompiledPolicy, data core.Data) CompiledPolicy {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(spec.Data) == 0 {
+		delete(r.bindings, key)
+		return compiled
+	}
+	r.bindings[key] = dataBinding{compiled: compiled, data: data}
+	return bindData(compiled, data)
+}
+
+// refreshData evaluates the policy with new entries of its data sources without compiling it again,
+// it returns false if the entries didn't change
+func (r *policyReconciler) refreshData(key types.NamespacedName, data core.Data) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	binding, ok := r.bindings[key]
+	if _, loaded := r.policies[key]; !ok || !loaded || reflect.DeepEqual(binding.data, data) {
+		return false
+	}
+	binding.data = data
+	r.bindings[key] = binding
+	r.policies[key] = bindData(binding.compiled, data)
+	r.recordRevision(key, r.versions[key].generation, r.policies[key])
+	r.resetSortPolicies()
+	return true
+}
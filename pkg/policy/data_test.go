@@ -0,0 +1,185 @@
+package policy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/go-logr/logr"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newDataClient(t *testing.T, objects ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	assert.NoError(t, v1alpha1.Install(scheme))
+	assert.NoError(t, corev1.AddToScheme(scheme))
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objects...).
+		WithStatusSubresource(&v1alpha1.AuthorizationPolicy{}).
+		WithIndex(&v1alpha1.AuthorizationPolicy{}, dataSourceIndex, indexDataSources).
+		Build()
+}
+
+// newDataPolicy returns a policy allowing the hosts listed in the allowlist ConfigMap
+func newDataPolicy(name string) *v1alpha1.AuthorizationPolicy {
+	policy := newPolicy(name, `object.attributes.request.http.host in data.allowlist.hosts.split(",") ? envoy.Allowed().Response() : envoy.Denied(403).Response()`)
+	policy.Spec.Data = []v1alpha1.DataSource{{
+		Name:      "allowlist",
+		ConfigMap: &v1alpha1.DataSourceReference{Namespace: "authz", Name: "allowlist"},
+	}}
+	return policy
+}
+
+func newAllowlist(hosts string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "authz", Name: "allowlist"},
+		Data:       map[string]string{"hosts": hosts},
+	}
+}
+
+// decide evaluates the only loaded policy against a request to the host
+func decide(t *testing.T, r *policyReconciler, host string) (*authv3.CheckResponse, error) {
+	t.Helper()
+	policies, err := r.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, policies, 1)
+	return policies[0].Evaluate(context.Background(), &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{Host: host},
+			},
+		},
+	})
+}
+
+func Test_policyReconciler_Reconcile_data(t *testing.T) {
+	allowlist := newAllowlist("shop.example.com")
+	c := newDataClient(t, newDataPolicy("policy"), allowlist)
+	compiler := &countingCompiler{Compiler: NewCompiler()}
+	r := newPolicyReconciler(c, compiler, labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	r.dataSources = true
+	reconcile(t, r, "policy")
+	response, err := decide(t, r, "api.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(codes.PermissionDenied), response.GetStatus().GetCode())
+	// the ConfigMap is updated, the policy is reconciled again by the watch
+	allowlist.Data["hosts"] = "shop.example.com,api.example.com"
+	assert.NoError(t, c.Update(context.Background(), allowlist))
+	requests := r.dependentPolicies(configMapKind)(context.Background(), allowlist)
+	assert.Equal(t, []ctrl.Request{{NamespacedName: types.NamespacedName{Name: "policy"}}}, requests)
+	reconcile(t, r, "policy")
+	response, err = decide(t, r, "api.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(codes.OK), response.GetStatus().GetCode())
+	// the policy is not compiled again
+	assert.Equal(t, 1, compiler.count)
+	// the ConfigMap is deleted, the policy fails
+	assert.NoError(t, c.Delete(context.Background(), allowlist))
+	reconcile(t, r, "policy")
+	_, err = decide(t, r, "api.example.com")
+	assert.ErrorContains(t, err, "data source allowlist is not available, ConfigMap authz/allowlist was not found")
+	// unless it ignores failures
+	var policy v1alpha1.AuthorizationPolicy
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "policy"}, &policy))
+	policy.Spec.FailurePolicy = ptr.To(admissionregistrationv1.Ignore)
+	policy.Generation = 2
+	assert.NoError(t, c.Update(context.Background(), &policy))
+	reconcile(t, r, "policy")
+	response, err = decide(t, r, "api.example.com")
+	assert.NoError(t, err)
+	assert.Nil(t, response)
+	// the ConfigMap is created again
+	assert.NoError(t, c.Create(context.Background(), newAllowlist("api.example.com")))
+	reconcile(t, r, "policy")
+	response, err = decide(t, r, "api.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(codes.OK), response.GetStatus().GetCode())
+}
+
+func Test_policyReconciler_Reconcile_dataSecret(t *testing.T) {
+	policy := newPolicy("policy", `object.attributes.request.http.headers[?"x-api-key"].orValue("") == data.keys.apiKey ? envoy.Allowed().Response() : envoy.Denied(401).Response()`)
+	policy.Spec.Data = []v1alpha1.DataSource{{
+		Name:   "keys",
+		Secret: &v1alpha1.DataSourceReference{Namespace: "authz", Name: "keys"},
+	}}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "authz", Name: "keys"},
+		Data:       map[string][]byte{"apiKey": []byte("s3cr3t")},
+	}
+	c := newDataClient(t, policy, secret)
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	r.dataSources = true
+	reconcile(t, r, "policy")
+	policies, err := r.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	response, err := policies[0].Evaluate(context.Background(), &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{Headers: map[string]string{"x-api-key": "s3cr3t"}},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(codes.OK), response.GetStatus().GetCode())
+	assert.Equal(t, []ctrl.Request{{NamespacedName: types.NamespacedName{Name: "policy"}}}, r.dependentPolicies(secretKind)(context.Background(), secret))
+	// a ConfigMap with the same name is not referenced
+	assert.Empty(t, r.dependentPolicies(configMapKind)(context.Background(), &corev1.ConfigMap{ObjectMeta: secret.ObjectMeta}))
+}
+
+func Test_policyReconciler_Reconcile_dataCache(t *testing.T) {
+	policy := newDataPolicy("policy")
+	policy.Spec.Cache = &v1alpha1.DecisionCache{Key: "object.attributes.request.http.host", TTL: metav1.Duration{Duration: time.Minute}}
+	allowlist := newAllowlist("shop.example.com")
+	c := newDataClient(t, policy, allowlist)
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	r.dataSources = true
+	reconcile(t, r, "policy")
+	policies, err := r.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	cache := policies[0].Cache
+	// new entries replace the decision cache, decisions taken with the previous entries are not returned
+	allowlist.Data["hosts"] = "api.example.com"
+	assert.NoError(t, c.Update(context.Background(), allowlist))
+	reconcile(t, r, "policy")
+	policies, err = r.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.NotSame(t, cache, policies[0].Cache)
+	// the same entries keep it
+	cache = policies[0].Cache
+	reconcile(t, r, "policy")
+	policies, err = r.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Same(t, cache, policies[0].Cache)
+}
+
+func Test_policyReconciler_Reconcile_dataDisabled(t *testing.T) {
+	c := newDataClient(t, newDataPolicy("policy"), newAllowlist("shop.example.com"))
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	reconcile(t, r, "policy")
+	policies, err := r.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, policies)
+	var policy v1alpha1.AuthorizationPolicy
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "policy"}, &policy))
+	condition := meta.FindStatusCondition(policy.Status.Conditions, v1alpha1.ConditionReady)
+	assert.NotNil(t, condition)
+	assert.Equal(t, v1alpha1.ReasonCompilationFailed, condition.Reason)
+	assert.Contains(t, condition.Message, "data sources are disabled")
+}
@@ -0,0 +1,44 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// electionRunnable is a manager runnable declaring whether it only runs on the elected replica,
+// runnables that don't declare it only run on the leader when the manager uses leader election
+type electionRunnable struct {
+	needLeaderElection bool
+	run                func(context.Context) error
+}
+
+func (r electionRunnable) Start(ctx context.Context) error {
+	return r.run(ctx)
+}
+
+func (r electionRunnable) NeedLeaderElection() bool {
+	return r.needLeaderElection
+}
+
+// promote is called when the replica is elected, followers compiled policies without writing
+// their status so every policy is reconciled again now that the replica can write it
+func (r *policyReconciler) promote(ctx context.Context, reader client.Reader, promotions chan<- event.GenericEvent) error {
+	r.logger.Info("elected as leader, reconciling policies status")
+	r.leader.Store(true)
+	var list v1alpha1.AuthorizationPolicyList
+	if err := reader.List(ctx, &list, client.MatchingLabelsSelector{Selector: r.selector}); err != nil {
+		return fmt.Errorf("failed to list policies: %w", err)
+	}
+	for i := range list.Items {
+		select {
+		case promotions <- event.GenericEvent{Object: &list.Items[i]}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return nil
+}
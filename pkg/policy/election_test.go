@@ -0,0 +1,81 @@
+package policy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func Test_policyReconciler_promote(t *testing.T) {
+	selector, err := labels.Parse("team=foo")
+	assert.NoError(t, err)
+	valid := newPolicy("valid", "envoy.Allowed().Response()")
+	valid.Labels = map[string]string{"team": "foo"}
+	invalid := newPolicy("invalid", "envoy.Allowed()")
+	invalid.Labels = map[string]string{"team": "foo"}
+	other := newPolicy("other", "envoy.Allowed().Response()")
+	other.Labels = map[string]string{"team": "bar"}
+	c := newFakeClient(t, valid, invalid, other)
+	recorder := record.NewFakeRecorder(10)
+	r := newPolicyReconciler(c, NewCompiler(), selector, logr.Discard(), recorder)
+	r.leader.Store(false)
+	ready := func(name string) *bool {
+		var policy v1alpha1.AuthorizationPolicy
+		assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: name}, &policy))
+		condition := meta.FindStatusCondition(policy.Status.Conditions, v1alpha1.ConditionReady)
+		if condition == nil {
+			return nil
+		}
+		ready := condition.Status == "True"
+		return &ready
+	}
+	// followers compile policies but don't write status nor record events
+	reconcile(t, r, "valid")
+	reconcile(t, r, "invalid")
+	policies, err := r.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, policies, 1)
+	assert.Nil(t, ready("valid"))
+	assert.Nil(t, ready("invalid"))
+	assert.Empty(t, drainEvents(recorder))
+	// once elected, the policies matching the selector are reconciled again
+	promotions := make(chan event.GenericEvent, 10)
+	assert.NoError(t, r.promote(context.Background(), c, promotions))
+	close(promotions)
+	var names []string
+	for promotion := range promotions {
+		names = append(names, promotion.Object.GetName())
+	}
+	assert.ElementsMatch(t, []string{"valid", "invalid"}, names)
+	for _, name := range names {
+		reconcile(t, r, name)
+	}
+	assert.True(t, *ready("valid"))
+	assert.False(t, *ready("invalid"))
+	events := drainEvents(recorder)
+	assert.Len(t, events, 1)
+	assert.Contains(t, events[0], "Warning CompileFailed")
+	// the policies were compiled once
+	policies, err = r.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, policies, 1)
+}
+
+func Test_policyReconciler_promote_cancelled(t *testing.T) {
+	c := newFakeClient(t, newPolicy("policy", "envoy.Allowed().Response()"))
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	r.leader.Store(false)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// nobody reads the promotions, the replica is shutting down
+	assert.NoError(t, r.promote(ctx, c, make(chan event.GenericEvent)))
+	assert.True(t, r.leader.Load())
+}
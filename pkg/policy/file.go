@@ -0,0 +1,333 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// FileProvider serves the policies of files and directories, it reloads them when they change
+type FileProvider struct {
+	compiler Compiler
+	template *Template
+	strict   bool
+	paths    []string
+	// compiling is held while policies are compiled and swapped, a reload of the compiler libraries doesn't
+	// interleave with a reload of the files
+	compiling sync.Mutex
+	lock      *sync.RWMutex
+	// sources are the policies the served policies were compiled from
+	sources  []*hub.AuthorizationPolicy
+	policies []CompiledPolicy
+	err      error
+}
+
+// NewFileProvider returns a provider loading the policies of the files, the files are rendered with the template
+// before they are decoded, the template is optional. Strict providers fail to load policies with unknown fields.
+func NewFileProvider(compiler Compiler, template *Template, strict bool, paths ...string) (*FileProvider, error) {
+	p := &FileProvider{
+		compiler: compiler,
+		template: template,
+		strict:   strict,
+		paths:    paths,
+		lock:     &sync.RWMutex{},
+	}
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *FileProvider) CompiledPolicies(ctx context.Context) ([]CompiledPolicy, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.policies, nil
+}
+
+// HasSynced returns true, policies are loaded when the provider is created
+func (p *FileProvider) HasSynced() bool {
+	return true
+}
+
+// Run watches the policy files and recompiles them when they change or the process receives SIGHUP,
+// until the context is cancelled. The previous policies are kept when the files fail to compile.
+func (p *FileProvider) Run(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("policies")
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	// config management tools signal the process once they updated the files
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var group wait.Group
+	defer group.Wait()
+	group.StartWithContext(ctx, func(ctx context.Context) {
+		p.reloadOn(ctx, signals)
+	})
+	// watch the directories containing our files, editors and config management tools
+	// often replace files instead of writing them in place
+	if err := p.watch(watcher); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			// chmod alone doesn't change the content
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			// a directory created in a watched directory holds policies too, it is watched before the
+			// policies are loaded so that the files written into it are not missed
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := p.watch(watcher); err != nil {
+						logger.Error(err, "failed to watch policy directory", "dir", event.Name)
+					}
+				}
+			}
+			if err := p.reload(); err != nil {
+				logger.Error(err, "failed to reload policies, keeping the previous policies", "file", event.Name)
+			} else {
+				logger.Info("reloaded policies", "file", event.Name)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logger.Error(err, "policy watcher error")
+		}
+	}
+}
+
+// reloadOn reloads the policy files every time a signal is received, until the context is cancelled
+func (p *FileProvider) reloadOn(ctx context.Context, signals <-chan os.Signal) {
+	logger := log.FromContext(ctx).WithName("policies")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-signals:
+			if err := p.reload(); err != nil {
+				logger.Error(err, "failed to reload policies, keeping the previous policies", "signal", sig.String())
+			} else {
+				logger.Info("reloaded policies", "signal", sig.String())
+			}
+		}
+	}
+}
+
+// reload recompiles the policy files and swaps the policies only if they all compile,
+// the previous policies are kept otherwise
+func (p *FileProvider) reload() error {
+	p.compiling.Lock()
+	defer p.compiling.Unlock()
+	sources, policies, err := p.compile()
+	if err != nil {
+		return err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.sources, p.policies, p.err = sources, policies, nil
+	return nil
+}
+
+// load compiles the policy files when the provider is created, the provider fails to serve policies if they don't
+// compile
+func (p *FileProvider) load() error {
+	p.compiling.Lock()
+	defer p.compiling.Unlock()
+	sources, policies, err := p.compile()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.err = err
+	if err == nil {
+		p.sources, p.policies = sources, policies
+	}
+	return err
+}
+
+func (p *FileProvider) compile() ([]*hub.AuthorizationPolicy, []CompiledPolicy, error) {
+	files, err := resolveFiles(p.paths...)
+	if err != nil {
+		return nil, nil, err
+	}
+	var policies []*hub.AuthorizationPolicy
+	for _, file := range files {
+		loaded, err := loadFile(file, p.template, p.strict)
+		if err != nil {
+			return nil, nil, err
+		}
+		policies = append(policies, loaded...)
+	}
+	compiled, err := core.CompilePolicies(p.compiler, policies)
+	if err != nil {
+		return nil, nil, err
+	}
+	return policies, compiled, nil
+}
+
+// Recompile compiles the policies loaded from the files again with the compiler, the files aren't read again.
+// The files are not reloaded until finish is called, see core.Recompiler.
+func (p *FileProvider) Recompile(compiler Compiler) (func(bool), error) {
+	p.compiling.Lock()
+	p.lock.RLock()
+	sources := slices.Clone(p.sources)
+	p.lock.RUnlock()
+	policies, err := core.CompilePolicies(compiler, sources)
+	if err != nil {
+		p.compiling.Unlock()
+		return nil, err
+	}
+	return func(commit bool) {
+		defer p.compiling.Unlock()
+		if commit {
+			p.lock.Lock()
+			defer p.lock.Unlock()
+			p.policies = policies
+		}
+	}, nil
+}
+
+// watch adds the directories containing our files to the watcher, the directories already watched are kept
+func (p *FileProvider) watch(watcher *fsnotify.Watcher) error {
+	dirs, err := p.watchedDirs()
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+func (p *FileProvider) watchedDirs() ([]string, error) {
+	var dirs []string
+	for _, path := range p.paths {
+		if isGlob(path) {
+			dirs = append(dirs, filepath.Dir(path))
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			dirs = append(dirs, filepath.Dir(path))
+			continue
+		}
+		if err := filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				dirs = append(dirs, path)
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	slices.Sort(dirs)
+	return slices.Compact(dirs), nil
+}
+
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+func isPolicyFile(path string) bool {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+func resolveFiles(paths ...string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		if isGlob(path) {
+			matches, err := filepath.Glob(path)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, matches...)
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		if err := filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && isPolicyFile(path) {
+				files = append(files, path)
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	slices.Sort(files)
+	return slices.Compact(files), nil
+}
+
+func loadFile(path string, template *Template, strict bool) ([]*hub.AuthorizationPolicy, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	rendered, err := template.render(path, file)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := decodePolicies(rendered, strict)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", path, err)
+	}
+	return policies, nil
+}
+
+// decodePolicies decodes the policies of a rendered document, a strict decoding fails on unknown fields
+func decodePolicies(r io.Reader, strict bool) ([]*hub.AuthorizationPolicy, error) {
+	if strict {
+		return core.DecodePoliciesStrict(r)
+	}
+	return core.DecodePolicies(r)
+}
@@ -0,0 +1,320 @@
+package policy
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	allowPolicy = `
+apiVersion: envoy.kyverno.io/v1alpha1
+kind: AuthorizationPolicy
+metadata:
+  name: allow
+spec:
+  authorizations:
+  - expression: envoy.Allowed().Response()
+`
+	denyPolicy = `
+apiVersion: envoy.kyverno.io/v1alpha1
+kind: AuthorizationPolicy
+metadata:
+  name: deny
+spec:
+  authorizations:
+  - expression: envoy.Denied(403).Response()
+`
+	configMap = `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: not-a-policy
+data:
+  foo: bar
+`
+	invalidPolicy = `
+apiVersion: envoy.kyverno.io/v1alpha1
+kind: AuthorizationPolicy
+metadata:
+  name: invalid
+spec:
+  authorizations:
+  - expression: envoy.Allowed()
+`
+	greetPolicy = `
+apiVersion: envoy.kyverno.io/v1alpha1
+kind: AuthorizationPolicy
+metadata:
+  name: greet
+spec:
+  authorizations:
+  - expression: 'org.greet("world") == "hello world" ? envoy.Allowed().Response() : envoy.Denied(403).Response()'
+`
+	misspelledPolicy = `
+apiVersion: envoy.kyverno.io/v1alpha1
+kind: AuthorizationPolicy
+metadata:
+  name: misspelled
+spec:
+  priorty: 10
+  authorizations:
+  - expression: envoy.Allowed().Response()
+`
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestNewFileProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		paths   func(string) []string
+		want    int
+		wantErr bool
+	}{{
+		name: "multi document file",
+		files: map[string]string{
+			"policies.yaml": allowPolicy + "---" + configMap + "---" + denyPolicy,
+		},
+		paths: func(dir string) []string { return []string{filepath.Join(dir, "policies.yaml")} },
+		want:  2,
+	}, {
+		name: "directory",
+		files: map[string]string{
+			"allow.yaml": allowPolicy,
+			"deny.yml":   denyPolicy,
+			"README.md":  "not a policy",
+		},
+		paths: func(dir string) []string { return []string{dir} },
+		want:  2,
+	}, {
+		name: "glob",
+		files: map[string]string{
+			"allow.yaml": allowPolicy,
+			"deny.yml":   denyPolicy,
+		},
+		paths: func(dir string) []string { return []string{filepath.Join(dir, "*.yaml")} },
+		want:  1,
+	}, {
+		name: "compilation error",
+		files: map[string]string{
+			"allow.yaml":   allowPolicy,
+			"invalid.yaml": invalidPolicy,
+		},
+		paths:   func(dir string) []string { return []string{dir} },
+		wantErr: true,
+	}, {
+		name:    "missing file",
+		paths:   func(dir string) []string { return []string{filepath.Join(dir, "missing.yaml")} },
+		wantErr: true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				writeFile(t, dir, name, content)
+			}
+			provider, err := NewFileProvider(NewCompiler(), nil, false, tt.paths(dir)...)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			policies, err := provider.CompiledPolicies(context.Background())
+			assert.NoError(t, err)
+			assert.Len(t, policies, tt.want)
+		})
+	}
+}
+
+func TestNewFileProvider_strict(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "misspelled.yaml", misspelledPolicy)
+	// the unknown field is ignored by default
+	provider, err := NewFileProvider(NewCompiler(), nil, false, dir)
+	assert.NoError(t, err)
+	policies, err := provider.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, policies, 1)
+	// and fails to load strict providers
+	_, err = NewFileProvider(NewCompiler(), nil, true, dir)
+	assert.ErrorContains(t, err, `policy misspelled has fields unknown to envoy.kyverno.io/v1alpha1: unknown field "spec.priorty"`)
+}
+
+func TestFileProvider_load(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "allow.yaml", allowPolicy)
+	provider, err := NewFileProvider(NewCompiler(), nil, false, dir)
+	assert.NoError(t, err)
+	policies, err := provider.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, policies, 1)
+	// add a policy
+	writeFile(t, dir, "deny.yaml", denyPolicy)
+	assert.NoError(t, provider.load())
+	policies, err = provider.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, policies, 2)
+	// break a policy, the error is surfaced
+	writeFile(t, dir, "deny.yaml", invalidPolicy)
+	assert.Error(t, provider.load())
+	_, err = provider.CompiledPolicies(context.Background())
+	assert.Error(t, err)
+}
+
+func TestFileProvider_reloadOn(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "allow.yaml", allowPolicy)
+	provider, err := NewFileProvider(NewCompiler(), nil, false, dir)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	go provider.reloadOn(ctx, signals)
+	// an added file is picked up when the process receives SIGHUP
+	writeFile(t, dir, "deny.yaml", denyPolicy)
+	process, err := os.FindProcess(os.Getpid())
+	assert.NoError(t, err)
+	assert.NoError(t, process.Signal(syscall.SIGHUP))
+	assert.Eventually(t, func() bool {
+		policies, err := provider.CompiledPolicies(context.Background())
+		return err == nil && len(policies) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	// a broken file leaves the previous policies intact
+	writeFile(t, dir, "deny.yaml", invalidPolicy)
+	assert.Error(t, provider.reload())
+	policies, err := provider.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, policies, 2)
+	// a reload recovers from a failed load
+	assert.Error(t, provider.load())
+	writeFile(t, dir, "deny.yaml", denyPolicy)
+	assert.NoError(t, provider.reload())
+	policies, err = provider.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, policies, 2)
+}
+
+// runProvider watches the files of the provider until the test ends
+func runProvider(t *testing.T, provider *FileProvider) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		errs <- provider.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-errs)
+	})
+}
+
+func TestFileProvider_Run_createdDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "allow.yaml", allowPolicy)
+	provider, err := NewFileProvider(NewCompiler(), nil, false, dir)
+	require.NoError(t, err)
+	runProvider(t, provider)
+	// give the watcher the time to watch the directory
+	time.Sleep(100 * time.Millisecond)
+	// a directory created after the provider started is watched too
+	sub := filepath.Join(dir, "sub")
+	require.NoError(t, os.Mkdir(sub, 0o700))
+	assert.Eventually(t, func() bool {
+		writeFile(t, sub, "deny.yaml", denyPolicy)
+		policies, err := provider.CompiledPolicies(context.Background())
+		return err == nil && len(policies) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	// a file changed in the created directory is reloaded
+	writeFile(t, sub, "deny.yaml", allowPolicy)
+	assert.Eventually(t, func() bool {
+		return slices.Equal([]int32{int32(codes.OK), int32(codes.OK)}, statusCodes(t, provider))
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestFileProvider_Run_brokenFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "allow.yaml", allowPolicy)
+	provider, err := NewFileProvider(NewCompiler(), nil, false, dir)
+	require.NoError(t, err)
+	runProvider(t, provider)
+	time.Sleep(100 * time.Millisecond)
+	writeFile(t, dir, "deny.yaml", denyPolicy)
+	assert.Eventually(t, func() bool {
+		policies, err := provider.CompiledPolicies(context.Background())
+		return err == nil && len(policies) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	// a broken file leaves the previous policies served
+	writeFile(t, dir, "deny.yaml", invalidPolicy)
+	assert.Never(t, func() bool {
+		policies, err := provider.CompiledPolicies(context.Background())
+		return err != nil || len(policies) != 2
+	}, 500*time.Millisecond, 10*time.Millisecond)
+	// the fixed file is picked up
+	writeFile(t, dir, "deny.yaml", allowPolicy)
+	assert.Eventually(t, func() bool {
+		return slices.Equal([]int32{int32(codes.OK), int32(codes.OK)}, statusCodes(t, provider))
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func greetLibrary(greeting string) cel.EnvOption {
+	return cel.Function("org.greet",
+		cel.Overload("org_greet_string", []*cel.Type{cel.StringType}, cel.StringType,
+			cel.UnaryBinding(func(value ref.Val) ref.Val {
+				return types.String(greeting + " " + string(value.(types.String)))
+			}),
+		),
+	)
+}
+
+func statusCodes(t *testing.T, provider Provider) []int32 {
+	t.Helper()
+	policies, err := provider.CompiledPolicies(context.Background())
+	require.NoError(t, err)
+	var out []int32
+	for _, policy := range policies {
+		response, err := policy.Evaluate(context.Background(), nil)
+		require.NoError(t, err)
+		out = append(out, response.GetStatus().GetCode())
+	}
+	return out
+}
+
+func TestFileProvider_Recompile(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "greet.yaml", greetPolicy)
+	compiler := NewReloadableCompiler([]cel.EnvOption{greetLibrary("hello")})
+	provider, err := NewFileProvider(compiler, nil, false, dir)
+	require.NoError(t, err)
+	assert.Equal(t, []int32{int32(codes.OK)}, statusCodes(t, provider))
+	// the policies are compiled with the new libraries
+	require.NoError(t, compiler.Reload([]cel.EnvOption{greetLibrary("hi")}, provider))
+	assert.Equal(t, []int32{int32(codes.PermissionDenied)}, statusCodes(t, provider))
+	// a library removing a function the policies use fails, the policies are kept
+	assert.ErrorContains(t, compiler.Reload(nil, provider), "failed to compile policy greet")
+	assert.Equal(t, []int32{int32(codes.PermissionDenied)}, statusCodes(t, provider))
+	// the files are compiled with the current libraries
+	writeFile(t, dir, "allow.yaml", allowPolicy)
+	require.NoError(t, provider.reload())
+	assert.Equal(t, []int32{int32(codes.OK), int32(codes.PermissionDenied)}, statusCodes(t, provider))
+}
@@ -0,0 +1,39 @@
+package policy
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+)
+
+// identityConfig is the file the identity sources are loaded from
+type identityConfig struct {
+	Sources []IdentitySource `json:"sources"`
+}
+
+// LoadIdentitySources reads the identity sources from a YAML file listing them under sources, in the order
+// they are tried. Unknown fields are rejected so that a misspelled expression doesn't silently disable a source.
+func LoadIdentitySources(path string) ([]IdentitySource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config identityConfig
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse identity sources %s: %w", path, err)
+	}
+	return config.Sources, nil
+}
+
+// WithIdentitySources resolves the identity variable from the sources, see core.WithIdentitySources
+func WithIdentitySources(sources ...IdentitySource) CompilerOption {
+	return core.WithIdentitySources(sources...)
+}
+
+// ValidateIdentitySources compiles the identity sources of the options, see core.ValidateIdentitySources
+func ValidateIdentitySources(opts ...CompilerOption) field.ErrorList {
+	return core.ValidateIdentitySources(opts...)
+}
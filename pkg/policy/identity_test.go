@@ -0,0 +1,30 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadIdentitySources(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "identity.yaml", `sources:
+- name: jwt
+  subject: auth.jwt.?payload.?sub.orValue("")
+  groups: auth.jwt.?payload.?groups.orValue([])
+- name: header
+  subject: request.headers[?"x-user"].orValue("")
+`)
+	sources, err := LoadIdentitySources(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []IdentitySource{
+		{Name: "jwt", Subject: `auth.jwt.?payload.?sub.orValue("")`, Groups: `auth.jwt.?payload.?groups.orValue([])`},
+		{Name: "header", Subject: `request.headers[?"x-user"].orValue("")`},
+	}, sources)
+	assert.Empty(t, ValidateIdentitySources(WithIdentitySources(sources...)))
+	// unknown fields are rejected
+	_, err = LoadIdentitySources(writeFile(t, dir, "unknown.yaml", "sources:\n- name: header\n  subjet: request.path\n"))
+	assert.ErrorContains(t, err, "failed to parse identity sources")
+	_, err = LoadIdentitySources(dir + "/missing.yaml")
+	assert.Error(t, err)
+}
@@ -0,0 +1,78 @@
+package policy
+
+import (
+	"context"
+	"time"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const tracerName = "github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+
+type instrumentedCompiler struct {
+	inner   Compiler
+	metrics *metrics.Metrics
+}
+
+// NewInstrumentedCompiler returns a compiler recording compilation failures and estimated costs of the inner compiler
+func NewInstrumentedCompiler(inner Compiler, metrics *metrics.Metrics) Compiler {
+	return &instrumentedCompiler{
+		inner:   inner,
+		metrics: metrics,
+	}
+}
+
+func (c *instrumentedCompiler) Compile(policy *hub.AuthorizationPolicy) (CompiledPolicy, field.ErrorList) {
+	compiled, errs := c.inner.Compile(policy)
+	if len(errs) > 0 {
+		c.metrics.RecordCompileFailure(policy.Name)
+	} else {
+		c.metrics.RecordEstimatedCost(policy.Name, compiled.EstimatedCost)
+	}
+	return compiled, errs
+}
+
+type instrumentedProvider struct {
+	inner   Provider
+	metrics *metrics.Metrics
+	tracer  trace.Tracer
+}
+
+// NewInstrumentedProvider returns a provider recording the latency, the number of policies and the errors of the
+// inner provider, whatever its kind. Every call is traced with a CompiledPolicies span, child of the span of the
+// context. The policies and errors of the inner provider are returned as is.
+func NewInstrumentedProvider(inner Provider, metrics *metrics.Metrics, tracerProvider trace.TracerProvider) Provider {
+	if tracerProvider == nil {
+		tracerProvider = noop.NewTracerProvider()
+	}
+	return &instrumentedProvider{
+		inner:   inner,
+		metrics: metrics,
+		tracer:  tracerProvider.Tracer(tracerName),
+	}
+}
+
+func (p *instrumentedProvider) CompiledPolicies(ctx context.Context) ([]CompiledPolicy, error) {
+	ctx, span := p.tracer.Start(ctx, "CompiledPolicies")
+	defer span.End()
+	start := time.Now()
+	policies, err := p.inner.CompiledPolicies(ctx)
+	p.metrics.RecordProviderCall(ctx, len(policies), time.Since(start), err)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	} else {
+		span.SetAttributes(attribute.Int("policy.count", len(policies)))
+	}
+	return policies, err
+}
+
+func (p *instrumentedProvider) HasSynced() bool {
+	return p.inner.HasSynced()
+}
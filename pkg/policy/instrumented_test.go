@@ -0,0 +1,95 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func Test_instrumentedCompiler_Compile(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := metrics.New(registry)
+	assert.NoError(t, err)
+	compiler := NewInstrumentedCompiler(NewCompiler(), m)
+	compiled, errs := compiler.Compile(newHubPolicy(t, "valid", "envoy.Allowed().Response()"))
+	assert.Empty(t, errs)
+	_, errs = compiler.Compile(newHubPolicy(t, "invalid", "envoy.Allowed()"))
+	assert.NotEmpty(t, errs)
+	assert.Equal(t, 1, testutil.CollectAndCount(registry, "policy_compile_failures_total"))
+	// only policies compiling successfully have an estimated cost
+	expected := fmt.Sprintf(`
+# HELP policy_estimated_cost Worst case CEL cost of evaluating every expression of a policy once, as of its last successful compilation, partitioned by policy.
+# TYPE policy_estimated_cost gauge
+policy_estimated_cost{policy="valid"} %d
+`, compiled.EstimatedCost)
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "policy_estimated_cost"))
+}
+
+// switchingProvider returns its policies or its error, the tests switch between them
+type switchingProvider struct {
+	policies []CompiledPolicy
+	err      error
+}
+
+func (p *switchingProvider) CompiledPolicies(context.Context) ([]CompiledPolicy, error) {
+	return p.policies, p.err
+}
+
+func (p *switchingProvider) HasSynced() bool {
+	return p.err == nil
+}
+
+func Test_instrumentedProvider_CompiledPolicies(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := metrics.New(registry)
+	require.NoError(t, err)
+	recorder := tracetest.NewSpanRecorder()
+	inner := &switchingProvider{policies: []CompiledPolicy{{Name: "first"}, {Name: "second"}}}
+	provider := NewInstrumentedProvider(inner, m, sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	// the result of the inner provider is passed through
+	policies, err := provider.CompiledPolicies(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, inner.policies, policies)
+	assert.True(t, provider.HasSynced())
+	failure := errors.New("cache not synced")
+	inner.err = failure
+	policies, err = provider.CompiledPolicies(context.Background())
+	assert.ErrorIs(t, err, failure)
+	assert.Equal(t, inner.policies, policies)
+	assert.False(t, provider.HasSynced())
+	// every call is observed, the number of policies is the one of the last successful call
+	assert.Equal(t, 1, testutil.CollectAndCount(registry, "policy_provider_duration_seconds"))
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP policy_provider_errors_total Number of policy provider calls that failed to return the compiled policies of a check.
+# TYPE policy_provider_errors_total counter
+policy_provider_errors_total 1
+# HELP policy_provider_policies Number of compiled policies returned by the policy provider, as of its last successful call.
+# TYPE policy_provider_policies gauge
+policy_provider_policies 2
+`), "policy_provider_errors_total", "policy_provider_policies"))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() == "policy_provider_duration_seconds" {
+			assert.Equal(t, uint64(2), family.GetMetric()[0].GetHistogram().GetSampleCount())
+		}
+	}
+	// every call is traced
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "CompiledPolicies", spans[0].Name())
+	assert.Equal(t, otelcodes.Unset, spans[0].Status().Code)
+	assert.Equal(t, otelcodes.Error, spans[1].Status().Code)
+	assert.Equal(t, "cache not synced", spans[1].Status().Description)
+}
@@ -0,0 +1,118 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// LockMode names the behavior of a provider when its policy set suddenly becomes empty
+type LockMode string
+
+const (
+	// LockNone serves the policies of the provider as is, an empty set applies the default decision
+	LockNone LockMode = "None"
+	// LockRetain keeps serving the last non empty policy set when the provider suddenly has no policies
+	LockRetain LockMode = "Retain"
+	// LockDeny fails the requests when the provider suddenly has no policies
+	LockDeny LockMode = "Deny"
+)
+
+// LockModes are the supported lock modes
+var LockModes = []LockMode{LockNone, LockRetain, LockDeny}
+
+// ErrPolicySetEmptied is returned by a provider locked in deny mode when its policy set suddenly became empty
+var ErrPolicySetEmptied = errors.New("the policy set became empty, refusing to serve requests without policies")
+
+type lockedProvider struct {
+	inner   Provider
+	mode    LockMode
+	metrics *metrics.Metrics
+	lock    sync.RWMutex
+	last    []CompiledPolicy
+	locked  bool
+}
+
+// NewLockedProvider returns a provider refusing the transition of the inner provider from a non empty to an
+// empty policy set, a deploy breaking or removing every policy doesn't leave requests to the default decision.
+// In retain mode the last non empty set is served, in deny mode CompiledPolicies fails so that requests fail
+// closed. A provider that never had policies is not locked, the lock is released as soon as the inner provider
+// has policies again. Errors of the inner provider are returned as is.
+func NewLockedProvider(inner Provider, mode LockMode, metrics *metrics.Metrics) (Provider, error) {
+	switch mode {
+	case LockNone:
+		return inner, nil
+	case LockRetain, LockDeny:
+		return &lockedProvider{
+			inner:   inner,
+			mode:    mode,
+			metrics: metrics,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown policy set lock mode %q, supported values are %v", mode, LockModes)
+	}
+}
+
+func (p *lockedProvider) CompiledPolicies(ctx context.Context) ([]CompiledPolicy, error) {
+	policies, err := p.inner.CompiledPolicies(ctx)
+	if err != nil {
+		return policies, err
+	}
+	// the common case, providers return the same slice until their policies change
+	if len(policies) != 0 {
+		p.update(ctx, policies)
+		return policies, nil
+	}
+	last := p.lockEmpty(ctx)
+	if last == nil {
+		return policies, nil
+	}
+	if p.mode == LockDeny {
+		return nil, ErrPolicySetEmptied
+	}
+	return last, nil
+}
+
+// update records the non empty policy set returned by the inner provider and releases the lock
+func (p *lockedProvider) update(ctx context.Context, policies []CompiledPolicy) {
+	p.lock.RLock()
+	same := !p.locked && len(p.last) == len(policies) && &p.last[0] == &policies[0]
+	p.lock.RUnlock()
+	if same {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.locked {
+		log.FromContext(ctx).Info("policy set lock released", "policies", len(policies))
+		p.locked = false
+		p.metrics.RecordPolicySetLocked(false)
+	}
+	p.last = policies
+}
+
+// lockEmpty returns the last non empty policy set, nil if there was none, and locks the set the first time
+func (p *lockedProvider) lockEmpty(ctx context.Context) []CompiledPolicy {
+	p.lock.RLock()
+	last, locked := p.last, p.locked
+	p.lock.RUnlock()
+	if last == nil || locked {
+		return last
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !p.locked {
+		log.FromContext(ctx).Info("the policy set became empty, policy set locked", "mode", p.mode, "policies", len(p.last))
+		p.locked = true
+		p.metrics.RecordPolicySetLocked(true)
+	}
+	return p.last
+}
+
+func (p *lockedProvider) HasSynced() bool {
+	return p.inner.HasSynced()
+}
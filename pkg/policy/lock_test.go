@@ -0,0 +1,192 @@
+package policy
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
+)
+
+// compilingProvider compiles its policies every time they are set, broken policies are dropped
+type compilingProvider struct {
+	compiler Compiler
+	lock     sync.Mutex
+	policies []CompiledPolicy
+	err      error
+}
+
+func (p *compilingProvider) set(policies ...*hub.AuthorizationPolicy) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.policies = nil
+	for _, policy := range policies {
+		if compiled, errs := p.compiler.Compile(policy); len(errs) == 0 {
+			p.policies = append(p.policies, compiled)
+		}
+	}
+}
+
+func (p *compilingProvider) fail(err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.err = err
+}
+
+func (p *compilingProvider) CompiledPolicies(context.Context) ([]CompiledPolicy, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.policies, p.err
+}
+
+func (p *compilingProvider) HasSynced() bool {
+	return true
+}
+
+func lockedPolicies(t *testing.T, provider Provider) ([]string, error) {
+	t.Helper()
+	policies, err := provider.CompiledPolicies(context.Background())
+	return names(policies), err
+}
+
+func assertLocked(t *testing.T, registry *prometheus.Registry, locked string) {
+	t.Helper()
+	expected := `
+# HELP policy_set_locked Whether the policy set is locked because the provider suddenly had no policies (1) or not (0).
+# TYPE policy_set_locked gauge
+policy_set_locked ` + locked + `
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "policy_set_locked"))
+}
+
+func TestNewLockedProvider(t *testing.T) {
+	inner := failingProvider{}
+	// no lock, the inner provider is served as is
+	provider, err := NewLockedProvider(inner, LockNone, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, inner, provider)
+	_, err = NewLockedProvider(inner, "Forever", nil)
+	assert.ErrorContains(t, err, `unknown policy set lock mode "Forever"`)
+}
+
+func TestNewLockedProvider_allPoliciesDeleted(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    LockMode
+		want    []string
+		wantErr error
+	}{{
+		name: "none",
+		mode: LockNone,
+	}, {
+		name: "retain",
+		mode: LockRetain,
+		// the last good set is the set before the last policy was deleted
+		want: []string{"allow"},
+	}, {
+		name:    "deny",
+		mode:    LockDeny,
+		wantErr: ErrPolicySetEmptied,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+			m, err := metrics.New(registry)
+			assert.NoError(t, err)
+			allow, deny := newPolicy("allow", "envoy.Allowed().Response()"), newPolicy("deny", "envoy.Denied(403).Response()")
+			c := newFakeClient(t, allow, deny)
+			r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+			provider, err := NewLockedProvider(r, tt.mode, m)
+			assert.NoError(t, err)
+			reconcile(t, r, "allow")
+			reconcile(t, r, "deny")
+			got, err := lockedPolicies(t, provider)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"allow", "deny"}, got)
+			// deleting one policy is a legitimate change
+			assert.NoError(t, c.Delete(context.Background(), deny))
+			reconcile(t, r, "deny")
+			got, err = lockedPolicies(t, provider)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"allow"}, got)
+			// deleting every policy is refused
+			assert.NoError(t, c.Delete(context.Background(), allow))
+			reconcile(t, r, "allow")
+			got, err = lockedPolicies(t, provider)
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.want, got)
+			if tt.mode != LockNone {
+				assertLocked(t, registry, "1")
+			}
+			// a new policy releases the lock
+			assert.NoError(t, c.Create(context.Background(), newPolicy("new", "envoy.Allowed().Response()")))
+			reconcile(t, r, "new")
+			got, err = lockedPolicies(t, provider)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"new"}, got)
+			if tt.mode != LockNone {
+				assertLocked(t, registry, "0")
+			}
+		})
+	}
+}
+
+func TestNewLockedProvider_allPoliciesBroke(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    LockMode
+		want    []string
+		wantErr error
+	}{{
+		name: "none",
+		mode: LockNone,
+	}, {
+		name: "retain",
+		mode: LockRetain,
+		want: []string{"allow"},
+	}, {
+		name:    "deny",
+		mode:    LockDeny,
+		wantErr: ErrPolicySetEmptied,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &compilingProvider{compiler: NewCompiler()}
+			provider, err := NewLockedProvider(inner, tt.mode, nil)
+			assert.NoError(t, err)
+			// a provider that never had policies is not locked
+			got, err := lockedPolicies(t, provider)
+			assert.NoError(t, err)
+			assert.Empty(t, got)
+			inner.set(newHubPolicy(t, "allow", "envoy.Allowed().Response()"))
+			got, err = lockedPolicies(t, provider)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"allow"}, got)
+			// the bad deploy breaks every policy
+			inner.set(newHubPolicy(t, "allow", "envoy.Allowed()"), newHubPolicy(t, "deny", "envoy.Denied()"))
+			for range 2 {
+				got, err = lockedPolicies(t, provider)
+				assert.Equal(t, tt.wantErr, err)
+				assert.Equal(t, tt.want, got)
+			}
+			// errors of the inner provider are returned as is
+			inner.fail(assert.AnError)
+			_, err = lockedPolicies(t, provider)
+			assert.Equal(t, assert.AnError, err)
+			// the fixed deploy releases the lock
+			inner.fail(nil)
+			inner.set(newHubPolicy(t, "fixed", "envoy.Allowed().Response()"))
+			got, err = lockedPolicies(t, provider)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"fixed"}, got)
+		})
+	}
+}
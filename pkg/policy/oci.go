@@ -0,0 +1,392 @@
+package policy
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/jitter"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var errBundleNotPulled = errors.New("policy bundle was not pulled yet")
+
+type ociProviderOptions struct {
+	interval time.Duration
+	jitter   jitter.Factor
+	keychain authn.Keychain
+	metrics  *metrics.Metrics
+	remote   []remote.Option
+	template *Template
+	strict   bool
+}
+
+type OCIProviderOption func(*ociProviderOptions)
+
+// WithPullInterval sets the interval at which the bundle is pulled again, defaults to one minute
+func WithPullInterval(interval time.Duration) OCIProviderOption {
+	return func(o *ociProviderOptions) {
+		o.interval = interval
+	}
+}
+
+// WithPullJitter spreads the pulls of replicas started together, every interval is moved by up to
+// factor times the interval and the first periodic pull happens after a random delay, defaults to no jitter
+func WithPullJitter(factor jitter.Factor) OCIProviderOption {
+	return func(o *ociProviderOptions) {
+		o.jitter = factor
+	}
+}
+
+// WithKeychain sets the keychain used to authenticate against the registry,
+// defaults to the docker config and credential helpers
+func WithKeychain(keychain authn.Keychain) OCIProviderOption {
+	return func(o *ociProviderOptions) {
+		o.keychain = keychain
+	}
+}
+
+// WithBundleMetrics records pull successes and failures
+func WithBundleMetrics(metrics *metrics.Metrics) OCIProviderOption {
+	return func(o *ociProviderOptions) {
+		o.metrics = metrics
+	}
+}
+
+// WithTemplate renders the files of the bundle with the template before they are decoded
+func WithTemplate(template *Template) OCIProviderOption {
+	return func(o *ociProviderOptions) {
+		o.template = template
+	}
+}
+
+// WithStrictDecoding fails to load the bundle when a policy has fields unknown to its version
+func WithStrictDecoding() OCIProviderOption {
+	return func(o *ociProviderOptions) {
+		o.strict = true
+	}
+}
+
+// WithRemoteOptions sets additional options used when talking to the registry
+func WithRemoteOptions(options ...remote.Option) OCIProviderOption {
+	return func(o *ociProviderOptions) {
+		o.remote = append(o.remote, options...)
+	}
+}
+
+type ociProvider struct {
+	compiler Compiler
+	bundles  []*ociBundle
+	options  ociProviderOptions
+	lock     sync.RWMutex
+	policies []CompiledPolicy
+	synced   atomic.Bool
+}
+
+// ociBundle is a bundle of a layered provider, with the policies of the digest it was last pulled at
+type ociBundle struct {
+	ref      name.Reference
+	digest   v1.Hash
+	policies []*hub.AuthorizationPolicy
+}
+
+// pullError is a pull failure of a bundle
+type pullError struct {
+	ref name.Reference
+	err error
+}
+
+func (e *pullError) Error() string {
+	return fmt.Sprintf("failed to pull policy bundle %s: %s", e.ref, e.err)
+}
+
+func (e *pullError) Unwrap() error {
+	return e.err
+}
+
+// NewOCIProvider returns a provider serving the policies bundled in an OCI artifact,
+// the artifact is pulled when the provider runs and periodically after that.
+// Every layer of the artifact is either a tar archive of yaml files or a yaml document.
+func NewOCIProvider(compiler Compiler, ref string, opts ...OCIProviderOption) (*ociProvider, error) {
+	return NewLayeredOCIProvider(compiler, []string{ref}, opts...)
+}
+
+// NewLayeredOCIProvider returns a provider serving the policies of several OCI artifacts layered in order, a policy
+// of a bundle overrides the policies with the same name of the bundles before it, a vendor baseline followed by local
+// overrides for example. The bundles are pulled together, the policies are served once every bundle was pulled and
+// the merged policies compiled, they are ordered by priority whatever their bundle.
+func NewLayeredOCIProvider(compiler Compiler, refs []string, opts ...OCIProviderOption) (*ociProvider, error) {
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("at least one policy bundle reference is required")
+	}
+	bundles := make([]*ociBundle, 0, len(refs))
+	for _, ref := range refs {
+		parsed, err := name.ParseReference(ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse policy bundle reference: %w", err)
+		}
+		bundles = append(bundles, &ociBundle{ref: parsed})
+	}
+	options := ociProviderOptions{
+		interval: time.Minute,
+		keychain: authn.DefaultKeychain,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if err := options.jitter.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy bundle pull jitter: %w", err)
+	}
+	return &ociProvider{
+		compiler: compiler,
+		bundles:  bundles,
+		options:  options,
+	}, nil
+}
+
+func (p *ociProvider) CompiledPolicies(ctx context.Context) ([]CompiledPolicy, error) {
+	if !p.HasSynced() {
+		return nil, errBundleNotPulled
+	}
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.policies, nil
+}
+
+// HasSynced returns true once the bundles were pulled and compiled successfully
+func (p *ociProvider) HasSynced() bool {
+	return p.synced.Load()
+}
+
+// Run pulls the bundles periodically until the context is cancelled,
+// the last good set of policies is kept when a pull fails.
+func (p *ociProvider) Run(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("policies").WithValues("refs", p.refs())
+	// the bundles are pulled right away, the periodic pulls start after a random offset
+	timer := time.NewTimer(p.options.jitter.Offset(p.options.interval))
+	defer timer.Stop()
+	for {
+		changed, err := p.pull(logr.NewContext(ctx, logger))
+		// don't report pulls interrupted by the shutdown
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			// a bundle that doesn't compile may be fixed by any of the bundles
+			var pullErr *pullError
+			if errors.As(err, &pullErr) {
+				p.options.metrics.RecordBundlePullFailure(pullErr.ref.String())
+			} else {
+				for _, bundle := range p.bundles {
+					p.options.metrics.RecordBundlePullFailure(bundle.ref.String())
+				}
+			}
+			logger.Error(err, "failed to pull policy bundle")
+		} else {
+			now := time.Now()
+			for _, bundle := range p.bundles {
+				p.options.metrics.RecordBundlePull(bundle.ref.String(), now)
+			}
+			if changed {
+				logger.Info("pulled policy bundle", "digests", p.currentDigests())
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+			timer.Reset(p.options.jitter.Interval(p.options.interval))
+		}
+	}
+}
+
+func (p *ociProvider) refs() []string {
+	refs := make([]string, 0, len(p.bundles))
+	for _, bundle := range p.bundles {
+		refs = append(refs, bundle.ref.String())
+	}
+	return refs
+}
+
+func (p *ociProvider) currentDigests() []string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	digests := make([]string, 0, len(p.bundles))
+	for _, bundle := range p.bundles {
+		digests = append(digests, bundle.digest.String())
+	}
+	return digests
+}
+
+// pull fetches the bundles and compiles their merged policies if the digest of any bundle changed, the bundles
+// and the served policies are only updated when every bundle was pulled and the merged policies compiled
+func (p *ociProvider) pull(ctx context.Context) (bool, error) {
+	options := append([]remote.Option{remote.WithContext(ctx), remote.WithAuthFromKeychain(p.options.keychain)}, p.options.remote...)
+	pulled := make([]ociBundle, 0, len(p.bundles))
+	changed := !p.HasSynced()
+	p.lock.RLock()
+	for _, bundle := range p.bundles {
+		pulled = append(pulled, *bundle)
+	}
+	p.lock.RUnlock()
+	for i := range pulled {
+		bundle := &pulled[i]
+		descriptor, err := remote.Get(bundle.ref, options...)
+		if err != nil {
+			return false, &pullError{ref: bundle.ref, err: err}
+		}
+		// the policies of a bundle are only loaded again when its digest changed
+		if p.HasSynced() && descriptor.Digest == bundle.digest {
+			continue
+		}
+		image, err := descriptor.Image()
+		if err != nil {
+			return false, &pullError{ref: bundle.ref, err: err}
+		}
+		policies, err := loadImage(image, p.options.template, p.options.strict)
+		if err != nil {
+			return false, &pullError{ref: bundle.ref, err: err}
+		}
+		bundle.digest, bundle.policies, changed = descriptor.Digest, policies, true
+	}
+	if !changed {
+		return false, nil
+	}
+	compiled, err := core.CompilePolicies(p.compiler, mergeBundles(log.FromContext(ctx), pulled))
+	if err != nil {
+		return false, err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for i, bundle := range pulled {
+		*p.bundles[i] = bundle
+	}
+	p.policies = compiled
+	p.synced.Store(true)
+	return true, nil
+}
+
+// mergeBundles returns the policies of the bundles, a policy overrides the policies with the same name of the
+// bundles before it. Policies with the same name in a single bundle are all kept, like with a single bundle.
+func mergeBundles(logger logr.Logger, bundles []ociBundle) []*hub.AuthorizationPolicy {
+	if len(bundles) == 1 {
+		return slices.Clone(bundles[0].policies)
+	}
+	// the bundle of the policies by name
+	layers := map[string]int{}
+	for i, bundle := range bundles {
+		for _, policy := range bundle.policies {
+			if layer, ok := layers[policy.Name]; ok && layer != i {
+				logger.Info("policy overridden by a later bundle", "policy", policy.Name, "bundle", bundles[layer].ref.String(), "override", bundle.ref.String())
+			}
+			layers[policy.Name] = i
+		}
+	}
+	var merged []*hub.AuthorizationPolicy
+	for i, bundle := range bundles {
+		for _, policy := range bundle.policies {
+			if layers[policy.Name] == i {
+				merged = append(merged, policy)
+			}
+		}
+	}
+	return merged
+}
+
+func loadImage(image v1.Image, template *Template, strict bool) ([]*hub.AuthorizationPolicy, error) {
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	var policies []*hub.AuthorizationPolicy
+	for _, layer := range layers {
+		loaded, err := loadLayer(layer, template, strict)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, loaded...)
+	}
+	return policies, nil
+}
+
+func loadLayer(layer v1.Layer, template *Template, strict bool) ([]*hub.AuthorizationPolicy, error) {
+	digest, err := layer.Digest()
+	if err != nil {
+		return nil, err
+	}
+	// use the raw blob, artifacts are not necessarily compressed
+	blob, err := layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+	policies, err := decodeBlob(blob, template, strict)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load layer %s: %w", digest, err)
+	}
+	return policies, nil
+}
+
+// decodeBlob decodes policies from a (possibly gzipped) tar archive or yaml document, every file is rendered with the template
+func decodeBlob(r io.Reader, template *Template, strict bool) ([]*hub.AuthorizationPolicy, error) {
+	reader := bufio.NewReader(r)
+	if magic, _ := reader.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = bufio.NewReader(gz)
+	}
+	// tar archives have a magic string at offset 257
+	if header, _ := reader.Peek(262); len(header) == 262 && string(header[257:262]) == "ustar" {
+		return decodeTar(tar.NewReader(reader), template, strict)
+	}
+	rendered, err := template.render("layer", reader)
+	if err != nil {
+		return nil, err
+	}
+	return decodePolicies(rendered, strict)
+}
+
+func decodeTar(archive *tar.Reader, template *Template, strict bool) ([]*hub.AuthorizationPolicy, error) {
+	var policies []*hub.AuthorizationPolicy
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return policies, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg || !isPolicyFile(header.Name) {
+			continue
+		}
+		rendered, err := template.render(header.Name, archive)
+		if err != nil {
+			return nil, err
+		}
+		loaded, err := decodePolicies(rendered, strict)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", header.Name, err)
+		}
+		policies = append(policies, loaded...)
+	}
+}
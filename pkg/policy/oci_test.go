@@ -0,0 +1,274 @@
+package policy
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	stdlog "log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+)
+
+func ociPolicy(name, expression string) string {
+	return `apiVersion: envoy.kyverno.io/v1alpha1
+kind: AuthorizationPolicy
+metadata:
+  name: ` + name + `
+spec:
+  authorizations:
+  - expression: '` + expression + `'
+`
+}
+
+func yamlLayer(content string) v1.Layer {
+	return static.NewLayer([]byte(content), types.MediaType("application/yaml"))
+}
+
+func tarLayer(t *testing.T, files map[string]string) v1.Layer {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	for name, content := range files {
+		assert.NoError(t, writer.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := writer.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, writer.Close())
+	layer, err := tarball.LayerFromReader(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	return layer
+}
+
+func pushBundle(t *testing.T, ref string, layers ...v1.Layer) {
+	t.Helper()
+	image, err := mutate.AppendLayers(empty.Image, layers...)
+	assert.NoError(t, err)
+	parsed, err := name.ParseReference(ref)
+	assert.NoError(t, err)
+	assert.NoError(t, remote.Write(parsed, image))
+}
+
+func policyNames(policies []CompiledPolicy) []string {
+	var names []string
+	for _, policy := range policies {
+		names = append(names, policy.Name)
+	}
+	return names
+}
+
+func Test_ociProvider(t *testing.T) {
+	const allow = `envoy.Allowed().Response()`
+	server := httptest.NewServer(registry.New(registry.Logger(stdlog.New(io.Discard, "", 0))))
+	defer server.Close()
+	ref := strings.TrimPrefix(server.URL, "http://") + "/policies:latest"
+	ctx := context.Background()
+	provider, err := NewOCIProvider(NewCompiler(), ref, WithKeychain(authn.NewMultiKeychain()))
+	assert.NoError(t, err)
+	// nothing is served before the first pull
+	assert.False(t, provider.HasSynced())
+	_, err = provider.CompiledPolicies(ctx)
+	assert.ErrorIs(t, err, errBundleNotPulled)
+	// the bundle doesn't exist yet
+	_, err = provider.pull(ctx)
+	assert.Error(t, err)
+	assert.False(t, provider.HasSynced())
+	// push a bundle with yaml and tar layers
+	pushBundle(t, ref,
+		yamlLayer(ociPolicy("a", allow)),
+		tarLayer(t, map[string]string{
+			"policies/b.yaml": ociPolicy("b", allow) + "---\n" + ociPolicy("c", allow),
+			"README.md":       "not a policy",
+		}),
+	)
+	changed, err := provider.pull(ctx)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.True(t, provider.HasSynced())
+	policies, err := provider.CompiledPolicies(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, policyNames(policies))
+	// the same digest is not compiled again
+	changed, err = provider.pull(ctx)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	// a new digest is picked up
+	pushBundle(t, ref, yamlLayer(ociPolicy("d", allow)))
+	changed, err = provider.pull(ctx)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	policies, err = provider.CompiledPolicies(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d"}, policyNames(policies))
+	// the last good policies are kept when the bundle doesn't compile
+	pushBundle(t, ref, yamlLayer(ociPolicy("e", `envoy.Allowed(`)))
+	_, err = provider.pull(ctx)
+	assert.ErrorContains(t, err, "failed to compile policy e")
+	policies, err = provider.CompiledPolicies(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d"}, policyNames(policies))
+	// and when the registry is unreachable
+	server.Close()
+	_, err = provider.pull(ctx)
+	assert.Error(t, err)
+	policies, err = provider.CompiledPolicies(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d"}, policyNames(policies))
+}
+
+func Test_ociProvider_Run(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(stdlog.New(io.Discard, "", 0))))
+	defer server.Close()
+	ref := strings.TrimPrefix(server.URL, "http://") + "/policies:latest"
+	pushBundle(t, ref, yamlLayer(ociPolicy("a", `envoy.Allowed().Response()`)))
+	registerer := prometheus.NewRegistry()
+	m, err := metrics.New(registerer)
+	assert.NoError(t, err)
+	provider, err := NewOCIProvider(NewCompiler(), ref,
+		WithKeychain(authn.NewMultiKeychain()),
+		WithPullInterval(10*time.Millisecond),
+		WithPullJitter(0.5),
+		WithBundleMetrics(m),
+	)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- provider.Run(ctx)
+	}()
+	assert.Eventually(t, provider.HasSynced, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	assert.NoError(t, <-done)
+	assert.Equal(t, 1, testutil.CollectAndCount(registerer, "policy_bundle_last_success_timestamp_seconds"))
+	assert.Equal(t, 0, testutil.CollectAndCount(registerer, "policy_bundle_pull_failures_total"))
+	// the jitter can't move pulls by the whole interval
+	_, err = NewOCIProvider(NewCompiler(), ref, WithPullJitter(1))
+	assert.ErrorContains(t, err, "invalid policy bundle pull jitter")
+}
+
+func Test_ociProvider_strict(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(stdlog.New(io.Discard, "", 0))))
+	defer server.Close()
+	ref := strings.TrimPrefix(server.URL, "http://") + "/policies:latest"
+	pushBundle(t, ref, tarLayer(t, map[string]string{"policies/misspelled.yaml": misspelledPolicy}))
+	// the unknown field is ignored by default
+	provider, err := NewOCIProvider(NewCompiler(), ref, WithKeychain(authn.NewMultiKeychain()))
+	assert.NoError(t, err)
+	_, err = provider.pull(context.Background())
+	assert.NoError(t, err)
+	// and fails the pull of strict providers
+	provider, err = NewOCIProvider(NewCompiler(), ref, WithKeychain(authn.NewMultiKeychain()), WithStrictDecoding())
+	assert.NoError(t, err)
+	_, err = provider.pull(context.Background())
+	assert.ErrorContains(t, err, `policy misspelled has fields unknown to envoy.kyverno.io/v1alpha1: unknown field "spec.priorty"`)
+	assert.False(t, provider.HasSynced())
+}
+
+func Test_ociProvider_layered(t *testing.T) {
+	const allow = `envoy.Allowed().Response()`
+	const deny = `envoy.Denied(403).Response()`
+	server := httptest.NewServer(registry.New(registry.Logger(stdlog.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	baseline, overrides := host+"/baseline:latest", host+"/overrides:latest"
+	ctx := context.Background()
+	provider, err := NewLayeredOCIProvider(NewCompiler(), []string{baseline, overrides}, WithKeychain(authn.NewMultiKeychain()))
+	assert.NoError(t, err)
+	pushBundle(t, baseline, yamlLayer(ociPolicy("admin", deny)+"---\n"+ociPolicy("health", allow)))
+	// nothing is served until every bundle was pulled
+	_, err = provider.pull(ctx)
+	assert.ErrorContains(t, err, "failed to pull policy bundle "+overrides)
+	assert.False(t, provider.HasSynced())
+	// the overrides relax a baseline policy and add another one
+	pushBundle(t, overrides, yamlLayer(ociPolicy("admin", allow)+"---\n"+ociPolicy("team", allow)))
+	changed, err := provider.pull(ctx)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	policies, err := provider.CompiledPolicies(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"admin", "health", "team"}, policyNames(policies))
+	response, err := policies[0].Evaluate(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(codes.OK), response.GetStatus().GetCode())
+	// unchanged bundles are not compiled again
+	changed, err = provider.pull(ctx)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	// a change of the baseline is merged with the overrides
+	pushBundle(t, baseline, yamlLayer(ociPolicy("admin", deny)))
+	changed, err = provider.pull(ctx)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	policies, err = provider.CompiledPolicies(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"admin", "team"}, policyNames(policies))
+	// a failing bundle keeps the last good set of every bundle
+	pushBundle(t, overrides, yamlLayer(ociPolicy("team", `envoy.Allowed(`)))
+	_, err = provider.pull(ctx)
+	assert.ErrorContains(t, err, "failed to compile policy team")
+	policies, err = provider.CompiledPolicies(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"admin", "team"}, policyNames(policies))
+	// reference parsing and empty layer lists fail
+	_, err = NewLayeredOCIProvider(NewCompiler(), nil)
+	assert.ErrorContains(t, err, "at least one policy bundle reference is required")
+	_, err = NewLayeredOCIProvider(NewCompiler(), []string{baseline, "Invalid:Ref:"})
+	assert.ErrorContains(t, err, "failed to parse policy bundle reference")
+}
+
+func Test_mergeBundles(t *testing.T) {
+	policy := func(name string, priority int32) *hub.AuthorizationPolicy {
+		out := newHubPolicy(t, name, `envoy.Allowed().Response()`)
+		out.Spec.Priority = priority
+		return out
+	}
+	bundle := func(ref string, policies ...*hub.AuthorizationPolicy) ociBundle {
+		parsed, err := name.ParseReference(ref)
+		assert.NoError(t, err)
+		return ociBundle{ref: parsed, policies: policies}
+	}
+	var logs []string
+	logger := funcr.New(func(_, args string) {
+		logs = append(logs, args)
+	}, funcr.Options{})
+	merged := mergeBundles(logger, []ociBundle{
+		bundle("example.com/vendor", policy("a", 0), policy("b", 10), policy("c", 0)),
+		bundle("example.com/platform", policy("b", -10), policy("d", 0)),
+		bundle("example.com/team", policy("a", 5)),
+	})
+	// the last bundle wins, the merged policies are sorted by priority when compiled
+	var names []string
+	for _, policy := range merged {
+		names = append(names, fmt.Sprintf("%s/%d", policy.Name, policy.Spec.Priority))
+	}
+	assert.Equal(t, []string{"c/0", "b/-10", "d/0", "a/5"}, names)
+	compiled, err := core.CompilePolicies(NewCompiler(), merged)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "c", "d", "b"}, policyNames(compiled))
+	// every conflict is logged with the bundles involved
+	assert.Len(t, logs, 2)
+	assert.Contains(t, logs[0], `"policy"="b" "bundle"="example.com/vendor" "override"="example.com/platform"`)
+	assert.Contains(t, logs[1], `"policy"="a" "bundle"="example.com/vendor" "override"="example.com/team"`)
+}
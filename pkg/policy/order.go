@@ -0,0 +1,72 @@
+package policy
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+)
+
+// PolicyComparator orders policies, it returns a negative number when a is evaluated before b.
+// The comparator sees the policy name, priority and enforcement mode, it is never used to
+// compare a policy with itself and ties are broken by name.
+type PolicyComparator func(a, b CompiledPolicy) int
+
+// OrderStrategy names a built-in policy order
+type OrderStrategy string
+
+const (
+	// OrderByPriority evaluates policies by descending priority, ties are broken by name
+	OrderByPriority OrderStrategy = "Priority"
+	// OrderByName evaluates policies in alphabetical order of their names, priorities are ignored
+	OrderByName OrderStrategy = "Name"
+	// OrderEnforceFirst evaluates enforced policies before audit policies, then by priority
+	OrderEnforceFirst OrderStrategy = "EnforceFirst"
+)
+
+// OrderStrategies are the built-in policy orders
+var OrderStrategies = []OrderStrategy{OrderByPriority, OrderByName, OrderEnforceFirst}
+
+// ByPriority orders policies by descending priority, ties are broken by name
+func ByPriority(a, b CompiledPolicy) int {
+	return core.ComparePolicies(a.Priority, a.Name, b.Priority, b.Name)
+}
+
+// ByName orders policies in alphabetical order of their names
+func ByName(a, b CompiledPolicy) int {
+	return strings.Compare(a.Name, b.Name)
+}
+
+// EnforceFirst orders enforced policies before audit policies, then by priority
+func EnforceFirst(a, b CompiledPolicy) int {
+	audit := func(policy CompiledPolicy) bool {
+		return policy.Mode == hub.EnforcementModeAudit
+	}
+	if c := cmp.Compare(boolToInt(audit(a)), boolToInt(audit(b))); c != 0 {
+		return c
+	}
+	return ByPriority(a, b)
+}
+
+// NewComparator returns the comparator implementing a built-in policy order
+func NewComparator(strategy OrderStrategy) (PolicyComparator, error) {
+	switch strategy {
+	case OrderByPriority:
+		return ByPriority, nil
+	case OrderByName:
+		return ByName, nil
+	case OrderEnforceFirst:
+		return EnforceFirst, nil
+	default:
+		return nil, fmt.Errorf("unknown policy order %q, supported values are %v", strategy, OrderStrategies)
+	}
+}
+
+func boolToInt(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,106 @@
+package policy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestNewComparator(t *testing.T) {
+	policies := map[types.NamespacedName]CompiledPolicy{
+		{Name: "a"}: {Name: "a", Priority: 0, Mode: hub.EnforcementModeEnforce},
+		{Name: "b"}: {Name: "b", Priority: 10, Mode: hub.EnforcementModeAudit},
+		{Name: "c"}: {Name: "c", Priority: -5, Mode: hub.EnforcementModeEnforce},
+		{Name: "d"}: {Name: "d", Priority: 10, Mode: hub.EnforcementModeEnforce},
+		{Name: "e"}: {Name: "e", Priority: 0, Mode: hub.EnforcementModeAudit},
+	}
+	tests := []struct {
+		strategy OrderStrategy
+		want     []string
+		wantErr  bool
+	}{{
+		strategy: OrderByPriority,
+		want:     []string{"b", "d", "a", "e", "c"},
+	}, {
+		strategy: OrderByName,
+		want:     []string{"a", "b", "c", "d", "e"},
+	}, {
+		strategy: OrderEnforceFirst,
+		want:     []string{"d", "a", "c", "b", "e"},
+	}, {
+		strategy: "Random",
+		wantErr:  true,
+	}, {
+		strategy: "",
+		wantErr:  true,
+	}}
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			compare, err := NewComparator(tt.strategy)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, compare)
+				return
+			}
+			assert.NoError(t, err)
+			got := []string{}
+			for _, policy := range mapToSortedSlice(policies, compare) {
+				got = append(got, policy.Name)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_mapToSortedSlice_ties(t *testing.T) {
+	// a comparator considering every policy equal falls back to the policy key
+	policies := map[types.NamespacedName]CompiledPolicy{
+		{Name: "c"}: {Name: "c"},
+		{Name: "a"}: {Name: "a"},
+		{Name: "b"}: {Name: "b"},
+	}
+	got := []string{}
+	for _, policy := range mapToSortedSlice(policies, func(CompiledPolicy, CompiledPolicy) int { return 0 }) {
+		got = append(got, policy.Name)
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, got)
+}
+
+func Test_policyReconciler_CompiledPolicies_order(t *testing.T) {
+	audit := newPolicy("audit", `envoy.Allowed().Response()`)
+	audit.Spec.Priority = 100
+	audit.Spec.EnforcementMode = v1alpha1.EnforcementModeAudit
+	enforce := newPolicy("enforce", `envoy.Allowed().Response()`)
+	c := newFakeClient(t, audit, enforce)
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	r.compare = EnforceFirst
+	names := func() []string {
+		policies, err := r.CompiledPolicies(context.Background())
+		assert.NoError(t, err)
+		out := []string{}
+		for _, policy := range policies {
+			out = append(out, policy.Name)
+		}
+		return out
+	}
+	reconcile(t, r, "audit")
+	reconcile(t, r, "enforce")
+	assert.Equal(t, []string{"enforce", "audit"}, names())
+	assert.Equal(t, "enforce", r.Inspect()[0].Name)
+	// enforce the audit policy, order must be recomputed after reconcile
+	var policy v1alpha1.AuthorizationPolicy
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "audit"}, &policy))
+	policy.Spec.EnforcementMode = v1alpha1.EnforcementModeEnforce
+	policy.Generation = 2
+	assert.NoError(t, c.Update(context.Background(), &policy))
+	reconcile(t, r, "audit")
+	assert.Equal(t, []string{"audit", "enforce"}, names())
+	assert.Equal(t, "audit", r.Inspect()[0].Name)
+}
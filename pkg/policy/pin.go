@@ -0,0 +1,165 @@
+package policy
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PinAnnotation pins the evaluated version of a policy, its value is one of the retained generations of the policy.
+// While it is set, the provider evaluates the policy compiled from that generation whatever the current spec.
+const PinAnnotation = "envoy.kyverno.io/pinned-generation"
+
+// DefaultPolicyHistory is the number of successfully compiled generations retained per policy
+const DefaultPolicyHistory = 5
+
+const (
+	eventReasonPinned     = "PolicyPinned"
+	eventReasonUnpinned   = "PolicyUnpinned"
+	eventReasonPinInvalid = "PinInvalid"
+)
+
+// WithPolicyHistory retains the last successfully compiled generations of every policy, the PinAnnotation pins a
+// policy to one of them. Zero disables pinning, the annotation is ignored.
+func WithPolicyHistory(size int) KubeProviderOption {
+	return func(o *kubeProviderOptions) {
+		o.historySize = size
+	}
+}
+
+// policyRevision is a successfully compiled generation of a policy
+type policyRevision struct {
+	generation int64
+	compiled   CompiledPolicy
+}
+
+// pinState is the pin of an annotated policy
+type pinState struct {
+	// annotation is the value of the annotation the state was computed from
+	annotation string
+	// generation is the pinned generation, zero when the annotation is invalid
+	generation int64
+}
+
+// recordRevision retains the compiled generation of a policy, the oldest generations are dropped once the history
+// is full but the pinned generation is always retained. It must be called with the lock held.
+func (r *policyReconciler) recordRevision(key types.NamespacedName, generation int64, compiled CompiledPolicy) {
+	if r.historySize == 0 {
+		return
+	}
+	// a generation compiled again replaces its revision, its annotations or data changed
+	history := slices.DeleteFunc(r.history[key], func(revision policyRevision) bool {
+		return revision.generation == generation
+	})
+	history = append(history, policyRevision{generation: generation, compiled: compiled})
+	pinned := r.pins[key].generation
+	for i := 0; len(history) > r.historySize && i < len(history); {
+		if history[i].generation == pinned {
+			i++
+			continue
+		}
+		history = slices.Delete(history, i, i+1)
+	}
+	r.history[key] = history
+}
+
+// revision returns the retained revision of a generation. It must be called with the lock held.
+func (r *policyReconciler) revision(key types.NamespacedName, generation int64) (policyRevision, bool) {
+	for _, revision := range r.history[key] {
+		if revision.generation == generation {
+			return revision, true
+		}
+	}
+	return policyRevision{}, false
+}
+
+// served returns the policy the provider evaluates, the pinned revision if any. It must be called with the lock held.
+func (r *policyReconciler) served(key types.NamespacedName) (CompiledPolicy, bool) {
+	compiled, ok := r.policies[key]
+	if !ok {
+		return CompiledPolicy{}, false
+	}
+	if generation := r.pins[key].generation; generation != 0 {
+		if revision, ok := r.revision(key, generation); ok {
+			return revision.compiled, true
+		}
+	}
+	return compiled, true
+}
+
+// reconcilePin pins or unpins a policy from its annotation, the transitions are logged and recorded as events
+func (r *policyReconciler) reconcilePin(logger logr.Logger, policy *v1alpha1.AuthorizationPolicy) {
+	key := client.ObjectKeyFromObject(policy)
+	value, annotated := policy.Annotations[PinAnnotation]
+	if r.historySize == 0 || !annotated {
+		if previous, ok := r.setPin(key, nil); ok && previous.generation != 0 {
+			logger.Info("policy unpinned", "generation", previous.generation)
+			r.pinEvent(policy, corev1.EventTypeNormal, eventReasonUnpinned, fmt.Sprintf("Policy unpinned from generation %d", previous.generation))
+		}
+		return
+	}
+	state := pinState{annotation: value}
+	generation, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || generation <= 0 {
+		err = fmt.Errorf("invalid %s annotation, it must be a generation of the policy: %q", PinAnnotation, value)
+	} else if retained := r.retained(key); !slices.Contains(retained, generation) {
+		err = fmt.Errorf("generation %d of the policy is not retained, the retained generations are %v", generation, retained)
+	} else {
+		state.generation = generation
+	}
+	previous, ok := r.setPin(key, &state)
+	switch {
+	// report an invalid annotation once, the policy is evaluated unpinned
+	case err != nil && (!ok || previous.annotation != value):
+		logger.Error(err, "pin ignored")
+		r.pinEvent(policy, corev1.EventTypeWarning, eventReasonPinInvalid, err.Error())
+	case state.generation != 0 && previous.generation != state.generation:
+		logger.Info("POLICY PINNED, newer generations are not evaluated until the pin is removed", "generation", state.generation)
+		r.pinEvent(policy, corev1.EventTypeWarning, eventReasonPinned, fmt.Sprintf("Policy pinned to generation %d, newer generations are not evaluated until the %s annotation is removed", state.generation, PinAnnotation))
+	case state.generation == 0 && previous.generation != 0:
+		logger.Info("policy unpinned", "generation", previous.generation)
+		r.pinEvent(policy, corev1.EventTypeNormal, eventReasonUnpinned, fmt.Sprintf("Policy unpinned from generation %d", previous.generation))
+	}
+}
+
+// retained returns the retained generations of a policy, oldest first
+func (r *policyReconciler) retained(key types.NamespacedName) []int64 {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	generations := make([]int64, 0, len(r.history[key]))
+	for _, revision := range r.history[key] {
+		generations = append(generations, revision.generation)
+	}
+	return generations
+}
+
+// setPin records the pin of a policy, nil when the policy is not annotated. It returns the previous state and false
+// if the policy was not annotated.
+func (r *policyReconciler) setPin(key types.NamespacedName, state *pinState) (pinState, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	previous, ok := r.pins[key]
+	if state == nil {
+		delete(r.pins, key)
+	} else {
+		r.pins[key] = *state
+	}
+	// the evaluated revision changes when a policy is pinned or unpinned
+	if state == nil || previous.generation != state.generation {
+		r.resetSortPolicies()
+	}
+	return previous, ok
+}
+
+// pinEvent records a pin transition, events are recorded by the leader only
+func (r *policyReconciler) pinEvent(policy *v1alpha1.AuthorizationPolicy, eventType, reason, message string) {
+	if r.leader.Load() {
+		r.recorder.Event(policy, eventType, reason, message)
+	}
+}
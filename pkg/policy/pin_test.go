@@ -0,0 +1,117 @@
+package policy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_policyReconciler_Reconcile_pin(t *testing.T) {
+	ctx := context.Background()
+	c := newFakeClient(t, newPolicy("policy", "envoy.Allowed().Response()"))
+	recorder := record.NewFakeRecorder(10)
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), recorder)
+	r.historySize = 2
+	// code returns the status code of the decision taken by the evaluated policies
+	code := func() int32 {
+		policies, err := r.CompiledPolicies(ctx)
+		require.NoError(t, err)
+		return core.Evaluate(ctx, policies, newPathRequest("/")).GetStatus().GetCode()
+	}
+	update := func(mutate func(*v1alpha1.AuthorizationPolicy)) {
+		var policy v1alpha1.AuthorizationPolicy
+		require.NoError(t, c.Get(ctx, types.NamespacedName{Name: "policy"}, &policy))
+		mutate(&policy)
+		require.NoError(t, c.Update(ctx, &policy))
+		reconcile(t, r, "policy")
+	}
+	setExpression := func(generation int64, expression string) func(*v1alpha1.AuthorizationPolicy) {
+		return func(policy *v1alpha1.AuthorizationPolicy) {
+			policy.Spec.Authorizations[0].Expression = expression
+			policy.Generation = generation
+		}
+	}
+	setPin := func(value string) func(*v1alpha1.AuthorizationPolicy) {
+		return func(policy *v1alpha1.AuthorizationPolicy) {
+			if value == "" {
+				delete(policy.Annotations, PinAnnotation)
+				return
+			}
+			if policy.Annotations == nil {
+				policy.Annotations = map[string]string{}
+			}
+			policy.Annotations[PinAnnotation] = value
+		}
+	}
+	reconcile(t, r, "policy")
+	update(setExpression(2, "envoy.Denied(403).Response()"))
+	assert.Equal(t, int32(7), code())
+	drainEvents(recorder)
+	// the policy is pinned to the first generation
+	update(setPin("1"))
+	assert.Equal(t, int32(0), code())
+	events := drainEvents(recorder)
+	if assert.Len(t, events, 1) {
+		assert.Contains(t, events[0], "Warning PolicyPinned Policy pinned to generation 1")
+	}
+	statuses := r.Inspect()
+	if assert.Len(t, statuses, 1) {
+		assert.Equal(t, int64(1), statuses[0].PinnedGeneration)
+		assert.Equal(t, int64(2), statuses[0].Generation)
+	}
+	// newer generations don't override the pin, the pinned generation is retained whatever the history size
+	update(setExpression(3, "envoy.Denied(401).Response()"))
+	update(setExpression(4, "envoy.Denied(404).Response()"))
+	update(setExpression(5, "envoy.Denied("))
+	assert.Equal(t, int32(0), code())
+	assert.Equal(t, []int64{1, 4}, r.retained(types.NamespacedName{Name: "policy"}))
+	for _, event := range drainEvents(recorder) {
+		assert.NotContains(t, event, "Pin")
+	}
+	// the pin is cleared, the last compiled generation is evaluated
+	update(setPin(""))
+	assert.Equal(t, int32(7), code())
+	events = drainEvents(recorder)
+	if assert.Len(t, events, 1) {
+		assert.Contains(t, events[0], "Normal PolicyUnpinned Policy unpinned from generation 1")
+	}
+	assert.Zero(t, r.Inspect()[0].PinnedGeneration)
+	// a generation that is not retained can't be pinned, it is reported once
+	update(setPin("2"))
+	assert.Equal(t, int32(7), code())
+	reconcile(t, r, "policy")
+	events = drainEvents(recorder)
+	if assert.Len(t, events, 1) {
+		assert.Contains(t, events[0], "Warning PinInvalid generation 2 of the policy is not retained, the retained generations are [1 4]")
+	}
+	update(setPin("latest"))
+	events = drainEvents(recorder)
+	if assert.Len(t, events, 1) {
+		assert.Contains(t, events[0], `invalid envoy.kyverno.io/pinned-generation annotation, it must be a generation of the policy: "latest"`)
+	}
+}
+
+func Test_policyReconciler_Reconcile_pinDisabled(t *testing.T) {
+	ctx := context.Background()
+	policy := newPolicy("policy", "envoy.Denied(403).Response()")
+	policy.Annotations = map[string]string{PinAnnotation: "1"}
+	c := newFakeClient(t, policy)
+	recorder := record.NewFakeRecorder(10)
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), recorder)
+	reconcile(t, r, "policy")
+	// without history the annotation is ignored
+	assert.Empty(t, drainEvents(recorder))
+	assert.Zero(t, r.Inspect()[0].PinnedGeneration)
+	assert.Empty(t, r.retained(types.NamespacedName{Name: "policy"}))
+	policies, err := r.CompiledPolicies(ctx)
+	require.NoError(t, err)
+	assert.Len(t, policies, 1)
+}
@@ -0,0 +1,48 @@
+package policytest_test
+
+import (
+	"fmt"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/policytest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func request(method, path string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Method: method,
+					Path:   path,
+				},
+			},
+		},
+	}
+}
+
+func ExampleEvaluate() {
+	provider, err := policytest.NewStaticProvider(&v1alpha1.AuthorizationPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "read-only"},
+		Spec: v1alpha1.AuthorizationPolicySpec{
+			Authorizations: []v1alpha1.Authorization{{
+				Expression: `object.attributes.request.http.method == "GET" ? envoy.Allowed().Response() : envoy.Denied(405).Response()`,
+			}},
+			Reason: `"method " + object.attributes.request.http.method`,
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	for _, r := range []*authv3.CheckRequest{request("GET", "/books"), request("DELETE", "/books/1")} {
+		decision, err := policytest.Evaluate(provider, r)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(r.Attributes.Request.Http.Method, decision.Allowed, decision.HttpStatus, decision.Policy, decision.Reason)
+	}
+	// Output:
+	// GET true 0 read-only method GET
+	// DELETE false 405 read-only method DELETE
+}
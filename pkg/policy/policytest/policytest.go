@@ -0,0 +1,73 @@
+// Package policytest helps unit testing authorization policies without a cluster or a server,
+// policies are compiled and evaluated with the same code the Kyverno Authz Server uses at runtime.
+package policytest
+
+import (
+	"context"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"google.golang.org/grpc/codes"
+)
+
+// Decision is the outcome of evaluating a check request
+type Decision struct {
+	// Allowed is true when the request is allowed
+	Allowed bool
+	// HttpStatus is the http status code returned to the client when the request is denied
+	HttpStatus int32
+	// Policy is the name of the policy that took the decision, empty for the default decision
+	Policy string
+	// Reason is the reason computed by the policy that took the decision, if any
+	Reason string
+	// Response is the raw response returned to envoy
+	Response *authv3.CheckResponse
+}
+
+// NewStaticProvider compiles the given policies with the default compiler,
+// it fails if any of the policies fails to compile
+func NewStaticProvider(policies ...*v1alpha1.AuthorizationPolicy) (policy.Provider, error) {
+	converted := make([]*hub.AuthorizationPolicy, 0, len(policies))
+	for _, p := range policies {
+		c, err := policy.ConvertPolicy(p)
+		if err != nil {
+			return nil, err
+		}
+		converted = append(converted, c)
+	}
+	return policy.NewStaticProvider(policy.NewCompiler(), converted...)
+}
+
+// Evaluate evaluates the check request against the provider policies,
+// requests no policy took a decision for are denied like the server does by default
+func Evaluate(provider policy.Provider, input *authv3.CheckRequest) (Decision, error) {
+	return EvaluateWithDefault(provider, input, authz.DefaultDecision{})
+}
+
+// EvaluateWithDefault is like Evaluate but uses the given default decision
+func EvaluateWithDefault(provider policy.Provider, input *authv3.CheckRequest, defaultDecision authz.DefaultDecision) (Decision, error) {
+	return evaluate(context.Background(), authz.NewService(provider, defaultDecision, authz.DefaultDecision{}), input)
+}
+
+// evaluate checks the request with the authorization service the servers use
+func evaluate(ctx context.Context, service authv3.AuthorizationServer, input *authv3.CheckRequest) (Decision, error) {
+	response, err := service.Check(ctx, input)
+	if err != nil {
+		return Decision{}, err
+	}
+	decision := Decision{
+		Allowed:  response.GetStatus().GetCode() == int32(codes.OK),
+		Response: response,
+	}
+	if !decision.Allowed {
+		decision.HttpStatus = int32(response.GetDeniedResponse().GetStatus().GetCode())
+	}
+	if attribution := response.GetDynamicMetadata().GetFields()[policy.MetadataKey].GetStructValue(); attribution != nil {
+		decision.Policy = attribution.GetFields()[policy.MetadataPolicyKey].GetStringValue()
+		decision.Reason = attribution.GetFields()[policy.MetadataReasonKey].GetStringValue()
+	}
+	return decision, nil
+}
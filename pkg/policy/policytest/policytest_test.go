@@ -0,0 +1,79 @@
+package policytest
+
+import (
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newPolicy(name string, priority int32, expression string) *v1alpha1.AuthorizationPolicy {
+	return &v1alpha1.AuthorizationPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1alpha1.AuthorizationPolicySpec{
+			Priority:       priority,
+			Authorizations: []v1alpha1.Authorization{{Expression: expression}},
+		},
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	input := &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Headers: map[string]string{"x-role": "guest"},
+				},
+			},
+		},
+	}
+	tests := []struct {
+		name            string
+		policies        []*v1alpha1.AuthorizationPolicy
+		defaultDecision authz.DefaultDecision
+		want            Decision
+		wantErr         bool
+	}{{
+		name:     "allowed",
+		policies: []*v1alpha1.AuthorizationPolicy{newPolicy("allow", 0, `envoy.Allowed().Response()`)},
+		want:     Decision{Allowed: true, Policy: "allow"},
+	}, {
+		name: "deny overrides allow",
+		policies: []*v1alpha1.AuthorizationPolicy{
+			newPolicy("allow", 10, `envoy.Allowed().Response()`),
+			newPolicy("deny-guests", 0, `object.attributes.request.http.headers["x-role"] == "guest" ? envoy.Denied(403).Response() : null`),
+		},
+		want: Decision{HttpStatus: 403, Policy: "deny-guests"},
+	}, {
+		name:     "default decision",
+		policies: []*v1alpha1.AuthorizationPolicy{newPolicy("none", 0, `false ? envoy.Allowed().Response() : null`)},
+		want:     Decision{HttpStatus: 403},
+	}, {
+		name:            "custom default decision",
+		policies:        []*v1alpha1.AuthorizationPolicy{newPolicy("none", 0, `false ? envoy.Allowed().Response() : null`)},
+		defaultDecision: authz.DefaultDecision{Decision: authz.DecisionAllow},
+		want:            Decision{Allowed: true},
+	}, {
+		name:     "compilation error",
+		policies: []*v1alpha1.AuthorizationPolicy{newPolicy("invalid", 0, `envoy.Allowed()`)},
+		wantErr:  true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, err := NewStaticProvider(tt.policies...)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			decision, err := EvaluateWithDefault(provider, input, tt.defaultDecision)
+			assert.NoError(t, err)
+			assert.NotNil(t, decision.Response)
+			decision.Response = nil
+			assert.Equal(t, tt.want, decision)
+		})
+	}
+}
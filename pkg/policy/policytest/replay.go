@@ -0,0 +1,208 @@
+package policytest
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"sigs.k8s.io/yaml"
+)
+
+// Record is the decision taken for a request of a corpus
+type Record struct {
+	// Request identifies the request, it is the path of its file relative to the corpus directory
+	Request  string         `json:"request"`
+	Decision authz.Decision `json:"decision,omitempty"`
+	// Status is the http status code of a denied request
+	Status int32  `json:"status,omitempty"`
+	Policy string `json:"policy,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// Error is set when the request failed to be checked, the record has no decision
+	Error string `json:"error,omitempty"`
+}
+
+// Summary is the distribution of the decisions taken for a corpus
+type Summary struct {
+	Total   int `json:"total"`
+	Allowed int `json:"allowed"`
+	Denied  int `json:"denied"`
+	Errors  int `json:"errors"`
+	// Statuses counts the denied requests by http status code
+	Statuses map[int32]int `json:"statuses,omitempty"`
+	// Policies counts the decisions by policy, the default decision is counted under an empty name
+	Policies map[string]int `json:"policies,omitempty"`
+}
+
+// ReplayReport is the outcome of replaying a corpus, a report saved as JSON is the baseline of later replays
+type ReplayReport struct {
+	Summary Summary  `json:"summary"`
+	Records []Record `json:"records"`
+	// Diff compares the records with a baseline, nil when there is no baseline
+	Diff *Diff `json:"diff,omitempty"`
+}
+
+// Change is a request whose decision changed from the baseline
+type Change struct {
+	Request string `json:"request"`
+	// Baseline is the record of the baseline, nil for a request added to the corpus
+	Baseline *Record `json:"baseline,omitempty"`
+	// Current is the record of the replay, nil for a request removed from the corpus
+	Current *Record `json:"current,omitempty"`
+}
+
+// Diff compares the decisions of a replay with the ones of a baseline, decisions are compared by their decision,
+// status code, deciding policy and error. Reasons are not compared.
+type Diff struct {
+	// Changed is the number of requests of both reports whose decision changed
+	Changed int `json:"changed"`
+	// NewDenies is the number of requests allowed by the baseline and denied by the replay
+	NewDenies int `json:"newDenies"`
+	// NewAllows is the number of requests denied by the baseline and allowed by the replay
+	NewAllows int `json:"newAllows"`
+	Added     int `json:"added"`
+	Removed   int `json:"removed"`
+	// Changes are the changed, added and removed requests ordered by request
+	Changes []Change `json:"changes,omitempty"`
+}
+
+// LoadCorpus reads the check requests recorded in the files of a directory and its subdirectories, keyed by the
+// path of their file relative to the directory. Files ending with .json, .yaml or .yml hold a request in its JSON
+// (or YAML) form, files ending with .pb its binary encoding. Other files are ignored.
+func LoadCorpus(dir string) (map[string]*authv3.CheckRequest, error) {
+	corpus := map[string]*authv3.CheckRequest{}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		ext := filepath.Ext(path)
+		if ext != ".json" && ext != ".yaml" && ext != ".yml" && ext != ".pb" {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var request authv3.CheckRequest
+		if ext == ".pb" {
+			err = proto.Unmarshal(data, &request)
+		} else if data, err = yaml.YAMLToJSON(data); err == nil {
+			// json is valid yaml
+			err = protojson.Unmarshal(data, &request)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid check request %s: %w", path, err)
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		corpus[filepath.ToSlash(name)] = &request
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return corpus, nil
+}
+
+// Replay evaluates every request of the corpus against the provider policies, like the server does, and reports
+// the decision taken for each of them ordered by request
+func Replay(ctx context.Context, provider policy.Provider, defaultDecision authz.DefaultDecision, corpus map[string]*authv3.CheckRequest) ReplayReport {
+	service := authz.NewService(provider, defaultDecision, authz.DefaultDecision{})
+	names := make([]string, 0, len(corpus))
+	for name := range corpus {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	report := ReplayReport{Records: []Record{}}
+	for _, name := range names {
+		record := Record{Request: name}
+		decision, err := evaluate(ctx, service, corpus[name])
+		if err != nil {
+			record.Error = err.Error()
+		} else {
+			record.Decision = authz.DecisionDeny
+			if decision.Allowed {
+				record.Decision = authz.DecisionAllow
+			}
+			record.Status = decision.HttpStatus
+			record.Policy = decision.Policy
+			record.Reason = decision.Reason
+		}
+		report.Records = append(report.Records, record)
+	}
+	report.Summary = summarize(report.Records)
+	return report
+}
+
+// summarize returns the distribution of the decisions of the records
+func summarize(records []Record) Summary {
+	summary := Summary{
+		Total:    len(records),
+		Statuses: map[int32]int{},
+		Policies: map[string]int{},
+	}
+	for _, record := range records {
+		switch {
+		case record.Error != "":
+			summary.Errors++
+			continue
+		case record.Decision == authz.DecisionAllow:
+			summary.Allowed++
+		default:
+			summary.Denied++
+			summary.Statuses[record.Status]++
+		}
+		summary.Policies[record.Policy]++
+	}
+	return summary
+}
+
+// CompareReports returns the differences between the records of a baseline and the ones of a replay
+func CompareReports(baseline, current ReplayReport) Diff {
+	records := make(map[string]*Record, len(baseline.Records))
+	for i := range baseline.Records {
+		records[baseline.Records[i].Request] = &baseline.Records[i]
+	}
+	var diff Diff
+	for i := range current.Records {
+		record := &current.Records[i]
+		before, ok := records[record.Request]
+		delete(records, record.Request)
+		switch {
+		case !ok:
+			diff.Added++
+		case changed(*before, *record):
+			diff.Changed++
+			if before.Decision == authz.DecisionAllow && record.Decision == authz.DecisionDeny {
+				diff.NewDenies++
+			}
+			if before.Decision == authz.DecisionDeny && record.Decision == authz.DecisionAllow {
+				diff.NewAllows++
+			}
+		default:
+			continue
+		}
+		diff.Changes = append(diff.Changes, Change{Request: record.Request, Baseline: before, Current: record})
+	}
+	// the remaining baseline records were removed from the corpus
+	for name, before := range records {
+		diff.Removed++
+		diff.Changes = append(diff.Changes, Change{Request: name, Baseline: before})
+	}
+	sort.Slice(diff.Changes, func(i, j int) bool { return diff.Changes[i].Request < diff.Changes[j].Request })
+	return diff
+}
+
+// changed returns true when the records have another decision, status code, deciding policy or error
+func changed(a, b Record) bool {
+	return a.Decision != b.Decision || a.Status != b.Status || a.Policy != b.Policy || a.Error != b.Error
+}
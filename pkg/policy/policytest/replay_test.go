@@ -0,0 +1,108 @@
+package policytest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func writeCorpus(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+	return dir
+}
+
+func TestLoadCorpus(t *testing.T) {
+	data, err := proto.Marshal(&authv3.CheckRequest{Attributes: &authv3.AttributeContext{Request: &authv3.AttributeContext_Request{Http: &authv3.AttributeContext_HttpRequest{Method: "PUT"}}}})
+	require.NoError(t, err)
+	dir := writeCorpus(t, map[string]string{
+		"get.json":       `{"attributes": {"request": {"http": {"method": "GET"}}}}`,
+		"admin/del.yaml": "attributes:\n  request:\n    http:\n      method: DELETE\n",
+		"put.pb":         string(data),
+		"README.md":      "captured from the staging taps",
+	})
+	corpus, err := LoadCorpus(dir)
+	require.NoError(t, err)
+	methods := map[string]string{}
+	for name, request := range corpus {
+		methods[name] = request.GetAttributes().GetRequest().GetHttp().GetMethod()
+	}
+	assert.Equal(t, map[string]string{"get.json": "GET", "admin/del.yaml": "DELETE", "put.pb": "PUT"}, methods)
+	_, err = LoadCorpus(writeCorpus(t, map[string]string{"invalid.json": `{"attributes": 1}`}))
+	assert.ErrorContains(t, err, "invalid check request")
+}
+
+func TestReplay(t *testing.T) {
+	provider, err := NewStaticProvider(
+		newPolicy("deny-delete", 0, `object.attributes.request.http.method == "DELETE" ? envoy.Denied(405).Response() : null`),
+		newPolicy("allow-get", 0, `object.attributes.request.http.method == "GET" ? envoy.Allowed().Response() : null`),
+	)
+	require.NoError(t, err)
+	corpus, err := LoadCorpus(writeCorpus(t, map[string]string{
+		"get.json":    `{"attributes": {"request": {"http": {"method": "GET"}}}}`,
+		"delete.json": `{"attributes": {"request": {"http": {"method": "DELETE"}}}}`,
+		"post.json":   `{"attributes": {"request": {"http": {"method": "POST"}}}}`,
+	}))
+	require.NoError(t, err)
+	report := Replay(context.Background(), provider, authz.DefaultDecision{}, corpus)
+	assert.Equal(t, []Record{
+		{Request: "delete.json", Decision: authz.DecisionDeny, Status: 405, Policy: "deny-delete"},
+		{Request: "get.json", Decision: authz.DecisionAllow, Policy: "allow-get"},
+		{Request: "post.json", Decision: authz.DecisionDeny, Status: 403},
+	}, report.Records)
+	assert.Equal(t, Summary{
+		Total:    3,
+		Allowed:  1,
+		Denied:   2,
+		Statuses: map[int32]int{403: 1, 405: 1},
+		Policies: map[string]int{"": 1, "allow-get": 1, "deny-delete": 1},
+	}, report.Summary)
+	assert.Nil(t, report.Diff)
+}
+
+func TestCompareReports(t *testing.T) {
+	baseline := ReplayReport{Records: []Record{
+		{Request: "a.json", Decision: authz.DecisionAllow, Policy: "allow"},
+		{Request: "b.json", Decision: authz.DecisionDeny, Status: 403},
+		{Request: "c.json", Decision: authz.DecisionDeny, Status: 403, Policy: "deny"},
+		{Request: "d.json", Decision: authz.DecisionAllow, Policy: "allow", Reason: "before"},
+		{Request: "removed.json", Decision: authz.DecisionAllow},
+	}}
+	current := ReplayReport{Records: []Record{
+		{Request: "a.json", Decision: authz.DecisionDeny, Status: 403, Policy: "deny"},
+		{Request: "added.json", Decision: authz.DecisionAllow},
+		{Request: "b.json", Decision: authz.DecisionAllow, Policy: "allow"},
+		{Request: "c.json", Decision: authz.DecisionDeny, Status: 401, Policy: "deny"},
+		// reasons are not compared
+		{Request: "d.json", Decision: authz.DecisionAllow, Policy: "allow", Reason: "after"},
+	}}
+	diff := CompareReports(baseline, current)
+	assert.Equal(t, Diff{
+		Changed:   3,
+		NewDenies: 1,
+		NewAllows: 1,
+		Added:     1,
+		Removed:   1,
+		Changes: []Change{
+			{Request: "a.json", Baseline: &baseline.Records[0], Current: &current.Records[0]},
+			{Request: "added.json", Current: &current.Records[1]},
+			{Request: "b.json", Baseline: &baseline.Records[1], Current: &current.Records[2]},
+			{Request: "c.json", Baseline: &baseline.Records[2], Current: &current.Records[3]},
+			{Request: "removed.json", Baseline: &baseline.Records[4]},
+		},
+	}, diff)
+	// a report doesn't differ from itself
+	assert.Equal(t, Diff{}, CompareReports(current, current))
+}
@@ -1,75 +1,954 @@
 package policy
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/go-logr/logr"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
 	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	ctrlreconcile "sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
-type Provider interface {
-	CompiledPolicies(context.Context) ([]PolicyFunc, error)
+const (
+	eventReasonCompileFailed = "CompileFailed"
+	eventReasonCompiled      = "Compiled"
+	eventReasonMalformed     = "Malformed"
+	// defaultSyncPageSize is the number of policies listed per request by the initial sync
+	defaultSyncPageSize = 500
+)
+
+var compiledCondition = metav1.Condition{
+	Type:    v1alpha1.ConditionReady,
+	Status:  metav1.ConditionTrue,
+	Reason:  v1alpha1.ReasonCompiled,
+	Message: "Policy compiled successfully",
+}
+
+var disabledCondition = metav1.Condition{
+	Type:    v1alpha1.ConditionReady,
+	Status:  metav1.ConditionFalse,
+	Reason:  v1alpha1.ReasonDisabled,
+	Message: "Policy compiled successfully and disabled",
+}
+
+// readyCondition returns the ready condition of a policy whose spec compiled
+func readyCondition(spec *hub.AuthorizationPolicySpec) metav1.Condition {
+	if spec.Disabled {
+		return disabledCondition
+	}
+	return compiledCondition
+}
+
+// PolicyStatus describes a policy known to a provider
+type PolicyStatus struct {
+	// Name is the policy name
+	Name string `json:"name"`
+	// Priority is the policy priority, as of the evaluated spec
+	Priority int32 `json:"priority"`
+	// Mode is the policy enforcement mode, as of the evaluated spec
+	Mode hub.EnforcementMode `json:"mode"`
+	// Active is true when the policy is evaluated, a policy failing to compile
+	// stays active with its previous spec if it compiled before
+	Active bool `json:"active"`
+	// Disabled is true when the evaluated spec is disabled, the policy is compiled but not active
+	Disabled bool `json:"disabled,omitempty"`
+	// Compiled is false when the last observed spec failed to compile
+	Compiled bool `json:"compiled"`
+	// Rejected is true when the last observed spec was not compiled because the policy exceeds a quota
+	Rejected bool `json:"rejected"`
+	// Error is the compilation error of the last observed spec, if any
+	Error string `json:"error,omitempty"`
+	// EstimatedCost is the worst case CEL cost of the evaluated spec, zero when the policy is not active
+	EstimatedCost uint64 `json:"estimatedCost"`
+	// Hash identifies the behavior of the evaluated spec, empty when the policy is not active
+	Hash string `json:"hash,omitempty"`
+	// BreakGlassExpires is the time the active break glass of the policy expires at, nil when it is not active
+	BreakGlassExpires *time.Time `json:"breakGlassExpires,omitempty"`
+	// PinnedGeneration is the generation the policy is pinned to, zero when it is not pinned
+	PinnedGeneration int64 `json:"pinnedGeneration,omitempty"`
+	// Generation is the last observed generation
+	Generation int64 `json:"generation"`
+	// ResourceVersion is the last observed resource version
+	ResourceVersion string `json:"resourceVersion"`
+	// LastReconciled is the time the policy was last reconciled, nil when the provider doesn't reconcile policies
+	LastReconciled *time.Time `json:"lastReconciled,omitempty"`
+}
+
+// Inspector is implemented by providers able to describe the policies they observed,
+// including the policies that failed to compile
+type Inspector interface {
+	// Inspect returns the policies status in evaluation order
+	Inspect() []PolicyStatus
+}
+
+// Ready returns true once the provider has synced and produced policies successfully
+func Ready(ctx context.Context, provider Provider) bool {
+	if !provider.HasSynced() {
+		return false
+	}
+	_, err := provider.CompiledPolicies(ctx)
+	return err == nil
+}
+
+type KubeProviderOption func(*kubeProviderOptions)
+
+type kubeProviderOptions struct {
+	selector labels.Selector
+	pageSize int64
+	metrics  *metrics.Metrics
+	// retryBaseDelay and retryMaxDelay bound the backoff of policies failing to reconcile
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
+	compare        PolicyComparator
+	leaderElection bool
+	coalesceDelay  time.Duration
+	// cacheSyncTimeout bounds the time waiting for the cache to sync at startup
+	cacheSyncTimeout time.Duration
+	maxPolicies      int
+	namespaceLabel   string
+	namespaceQuota   int
+	// deletionGracePeriod delays the eviction of deleted policies
+	deletionGracePeriod time.Duration
+	dataSources         bool
+	breakGlassTTL       time.Duration
+	historySize         int
+	compileCache        *CompileCache
+}
+
+// WithLabelSelector restricts the policies loaded by the provider to the ones matching the selector.
+func WithLabelSelector(selector labels.Selector) KubeProviderOption {
+	return func(o *kubeProviderOptions) {
+		o.selector = selector
+	}
+}
+
+// WithSyncPageSize sets the number of policies listed per request by the initial sync.
+func WithSyncPageSize(pageSize int64) KubeProviderOption {
+	return func(o *kubeProviderOptions) {
+		o.pageSize = pageSize
+	}
 }
 
-func NewKubeProvider(mgr ctrl.Manager, compiler Compiler) (Provider, error) {
-	r := newPolicyReconciler(mgr.GetClient(), compiler)
-	if err := ctrl.NewControllerManagedBy(mgr).For(&v1alpha1.AuthorizationPolicy{}).Complete(r); err != nil {
+// WithSyncMetrics records the initial sync progress, the time policies were last reconciled and the errors watching policies
+func WithSyncMetrics(metrics *metrics.Metrics) KubeProviderOption {
+	return func(o *kubeProviderOptions) {
+		o.metrics = metrics
+	}
+}
+
+// WithRetryBackoff sets the delays between retries of a policy failing to reconcile with a transient error,
+// the delay starts at base and doubles on every failure up to max.
+func WithRetryBackoff(base, max time.Duration) KubeProviderOption {
+	return func(o *kubeProviderOptions) {
+		o.retryBaseDelay = base
+		o.retryMaxDelay = max
+	}
+}
+
+// WithPolicyOrder sets the order policies are evaluated in, policies are ordered by priority by default.
+func WithPolicyOrder(compare PolicyComparator) KubeProviderOption {
+	return func(o *kubeProviderOptions) {
+		o.compare = compare
+	}
+}
+
+// WithLeaderElection must be set when the manager uses leader election. Every replica watches and compiles
+// policies to serve requests, only the leader updates the policies status and records events.
+func WithLeaderElection() KubeProviderOption {
+	return func(o *kubeProviderOptions) {
+		o.leaderElection = true
+	}
+}
+
+// WithUpdateCoalescing sets the delay updates to the same policy are coalesced over, a burst of updates
+// results in a single reconcile. Defaults to 100ms, zero reconciles every update.
+func WithUpdateCoalescing(delay time.Duration) KubeProviderOption {
+	return func(o *kubeProviderOptions) {
+		o.coalesceDelay = delay
+	}
+}
+
+// WithCacheSyncTimeout sets the maximum time WaitForCacheSync waits for the cache to sync, it fails with the
+// last watch error when the cache didn't sync in time. Defaults to 2 minutes, zero waits forever.
+// Authorization errors watching policies fail immediately whatever the timeout.
+func WithCacheSyncTimeout(timeout time.Duration) KubeProviderOption {
+	return func(o *kubeProviderOptions) {
+		o.cacheSyncTimeout = timeout
+	}
+}
+
+// WithDeletionGracePeriod keeps evaluating a deleted policy for the grace period, it is only evicted if it isn't recreated
+// meanwhile. It avoids a window without the policy when it is pruned and applied again, by a GitOps resync for example.
+// Defaults to zero, deleted policies are evicted immediately.
+func WithDeletionGracePeriod(period time.Duration) KubeProviderOption {
+	return func(o *kubeProviderOptions) {
+		o.deletionGracePeriod = period
+	}
+}
+
+// WithDataSources resolves the ConfigMaps and Secrets referenced by the data sources of the policies, and watches them
+// to evaluate the policies with their current entries. The manager scheme must register the core types and the
+// manager needs to list and watch ConfigMaps and Secrets. Without it, policies declaring data sources fail to compile.
+func WithDataSources() KubeProviderOption {
+	return func(o *kubeProviderOptions) {
+		o.dataSources = true
+	}
+}
+
+// WithCompileCacheEviction releases the policies evicted by the provider from the compile cache, it must be the cache
+// the compiler shares the compiled expressions of the policies with identical specs through, see WithCompileCache.
+func WithCompileCacheEviction(cache *CompileCache) KubeProviderOption {
+	return func(o *kubeProviderOptions) {
+		o.compileCache = cache
+	}
+}
+
+func NewKubeProvider(mgr ctrl.Manager, compiler Compiler, opts ...KubeProviderOption) (Provider, error) {
+	options := kubeProviderOptions{
+		selector:       labels.Everything(),
+		pageSize:       defaultSyncPageSize,
+		retryBaseDelay: defaultRetryBaseDelay,
+		retryMaxDelay:  defaultRetryMaxDelay,
+		compare:        ByPriority,
+		coalesceDelay:  defaultCoalesceDelay,
+		// the informer retries forever, a cache that can't sync would block the startup
+		cacheSyncTimeout: defaultCacheSyncTimeout,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.retryBaseDelay <= 0 || options.retryMaxDelay < options.retryBaseDelay {
+		return nil, fmt.Errorf("invalid retry backoff, base delay must be positive and not greater than max delay (base: %s, max: %s)", options.retryBaseDelay, options.retryMaxDelay)
+	}
+	if options.coalesceDelay < 0 {
+		return nil, fmt.Errorf("invalid update coalescing delay, it must not be negative (delay: %s)", options.coalesceDelay)
+	}
+	if options.compare == nil {
+		return nil, fmt.Errorf("invalid policy order, comparator must not be nil")
+	}
+	if options.cacheSyncTimeout < 0 {
+		return nil, fmt.Errorf("invalid cache sync timeout, it must not be negative (timeout: %s)", options.cacheSyncTimeout)
+	}
+	if options.deletionGracePeriod < 0 {
+		return nil, fmt.Errorf("invalid deletion grace period, it must not be negative (period: %s)", options.deletionGracePeriod)
+	}
+	if options.breakGlassTTL < 0 || options.breakGlassTTL > maxBreakGlassTTL {
+		return nil, fmt.Errorf("invalid break glass ttl, it must not be negative nor exceed %s (ttl: %s)", maxBreakGlassTTL, options.breakGlassTTL)
+	}
+	if options.maxPolicies < 0 || options.namespaceQuota < 0 {
+		return nil, fmt.Errorf("invalid policy quota, it must not be negative (max: %d, per namespace: %d)", options.maxPolicies, options.namespaceQuota)
+	}
+	if options.historySize < 0 {
+		return nil, fmt.Errorf("invalid policy history, it must not be negative (size: %d)", options.historySize)
+	}
+	if options.namespaceQuota > 0 {
+		if errs := validation.IsQualifiedName(options.namespaceLabel); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid namespace label %q: %s", options.namespaceLabel, strings.Join(errs, ", "))
+		}
+	}
+	// report the errors watching policies, the informer is created but not started yet
+	informer, err := mgr.GetCache().GetInformer(context.Background(), &v1alpha1.AuthorizationPolicy{}, cache.BlockUntilSynced(false))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the %s informer: %w", policiesResource, err)
+	}
+	r := newPolicyReconciler(mgr.GetClient(), compiler, options.selector, mgr.GetLogger().WithName("policies"), mgr.GetEventRecorderFor("kyverno-authz-server"))
+	// the initial sync lists policies from the api server page by page, it doesn't load them in memory at once
+	r.lister = mgr.GetAPIReader()
+	r.pageSize = options.pageSize
+	r.metrics = options.metrics
+	r.compare = options.compare
+	r.maxPolicies = options.maxPolicies
+	r.namespaceLabel = options.namespaceLabel
+	r.namespaceQuota = options.namespaceQuota
+	r.deletionGracePeriod = options.deletionGracePeriod
+	r.dataSources = options.dataSources
+	r.breakGlassTTL = options.breakGlassTTL
+	r.historySize = options.historySize
+	r.compileCache = options.compileCache
+	r.syncWatcher = newSyncWatcher(mgr.GetCache(), options.cacheSyncTimeout, options.metrics)
+	if err := r.syncWatcher.watch(informer); err != nil {
+		return nil, err
+	}
+	// followers don't write status until they are elected
+	r.leader.Store(!options.leaderElection)
+	// every replica reconciles policies, promoted replicas reconcile all policies again to write their status
+	promotions := make(chan event.GenericEvent)
+	// bursts of updates to the same policy are coalesced
+	controllerBuilder := ctrl.NewControllerManagedBy(mgr).Named("authorizationpolicy").
+		Watches(&v1alpha1.AuthorizationPolicy{}, coalescingHandler(options.coalesceDelay), builder.WithPredicates(selectorPredicate(options.selector))).
+		WatchesRawSource(source.Channel(promotions, &handler.EnqueueRequestForObject{}))
+	// the policies referencing a ConfigMap or a Secret are reconciled again when it changes
+	if options.dataSources {
+		if err := mgr.GetFieldIndexer().IndexField(context.Background(), &v1alpha1.AuthorizationPolicy{}, dataSourceIndex, indexDataSources); err != nil {
+			return nil, fmt.Errorf("failed to index the policies data sources: %w", err)
+		}
+		controllerBuilder = controllerBuilder.
+			Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.dependentPolicies(configMapKind))).
+			Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.dependentPolicies(secretKind)))
+	}
+	if err := controllerBuilder.
+		WithOptions(controller.Options{
+			RateLimiter:        newRateLimiter(options.retryBaseDelay, options.retryMaxDelay),
+			NeedLeaderElection: ptr.To(false),
+		}).
+		Complete(r); err != nil {
 		return nil, fmt.Errorf("failed to construct manager: %w", err)
 	}
+	// flag the provider as synced once the policies in the cache at startup were reconciled
+	if err := mgr.Add(electionRunnable{needLeaderElection: false, run: func(ctx context.Context) error {
+		if !mgr.GetCache().WaitForCacheSync(ctx) {
+			return nil
+		}
+		return r.sync(ctx)
+	}}); err != nil {
+		return nil, fmt.Errorf("failed to add sync runnable: %w", err)
+	}
+	if options.leaderElection {
+		if err := mgr.Add(electionRunnable{needLeaderElection: true, run: func(ctx context.Context) error {
+			if !mgr.GetCache().WaitForCacheSync(ctx) {
+				return nil
+			}
+			return r.promote(ctx, mgr.GetClient(), promotions)
+		}}); err != nil {
+			return nil, fmt.Errorf("failed to add leader election runnable: %w", err)
+		}
+	}
 	return r, nil
 }
 
+func selectorPredicate(selector labels.Selector) predicate.Funcs {
+	matches := func(obj client.Object) bool {
+		return selector.Matches(labels.Set(obj.GetLabels()))
+	}
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return matches(e.Object)
+		},
+		// let updates through if the old object matched, the reconciler
+		// needs to evict policies that don't match anymore
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return matches(e.ObjectOld) || matches(e.ObjectNew)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return matches(e.Object)
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return matches(e.Object)
+		},
+	}
+}
+
+// policyVersion identifies the spec a policy was compiled from, the generation is used instead
+// of the resource version because it doesn't change on status or metadata only updates.
+// The annotations are part of the compiled policy, they don't change the generation.
+type policyVersion struct {
+	uid         types.UID
+	generation  int64
+	annotations string
+	namespace   string
+}
+
+// annotationsVersion returns a digest of the annotations, it changes when any annotation changes
+func annotationsVersion(annotations map[string]string) string {
+	hash := sha256.New()
+	for _, key := range slices.Sorted(maps.Keys(annotations)) {
+		// keys can't contain a zero byte, the encoding is unambiguous
+		fmt.Fprintf(hash, "%s\x00%d:%s", key, len(annotations[key]), annotations[key])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
 type policyReconciler struct {
-	client   client.Client
-	compiler Compiler
-	lock     *sync.RWMutex
-	policies map[types.NamespacedName]PolicyFunc
+	client       client.Client
+	logger       logr.Logger
+	recorder     record.EventRecorder
+	compiler     Compiler
+	selector     labels.Selector
+	lock         *sync.RWMutex
+	policies     map[types.NamespacedName]CompiledPolicy
+	versions     map[types.NamespacedName]policyVersion
+	specs        map[types.NamespacedName]*hub.AuthorizationPolicySpec
+	statuses     map[types.NamespacedName]PolicyStatus
+	sortPolicies func() []CompiledPolicy
+	// compare orders the policies returned by sortPolicies
+	compare PolicyComparator
+	// lister is used by the initial sync to list policies metadata, pageSize policies at a time
+	lister   client.Reader
+	pageSize int64
+	metrics  *metrics.Metrics
+	// reconciled are the policies reconciled before the initial sync listed all policies, nil once listed
+	reconciled sets.Set[types.NamespacedName]
+	// pending are the policies listed by the initial sync and not reconciled yet, nil until the sync starts
+	pending sets.Set[types.NamespacedName]
+	// listed is the number of policies listed by the initial sync
+	listed int
+	synced atomic.Bool
+	// leader is true when the reconciler writes the policies status and records events
+	leader atomic.Bool
+	// syncWatcher reports the errors preventing the manager cache from syncing
+	syncWatcher *syncWatcher
+	// maxPolicies and namespaceQuota cap the number of policies loaded, in total and per namespace label value, zero means no limit
+	maxPolicies    int
+	namespaceLabel string
+	namespaceQuota int
+	// deletionGracePeriod delays the eviction of deleted policies, deleted records when the deletion of a policy was observed
+	deletionGracePeriod time.Duration
+	deleted             map[types.NamespacedName]time.Time
+	now                 func() time.Time
+	// dataSources is true when the data sources of the policies are resolved, bindings are the compiled
+	// policies declaring data sources and the entries they are evaluated with
+	dataSources bool
+	bindings    map[types.NamespacedName]dataBinding
+	// breakGlassTTL is the time a break glass stays active, zero disables break glass. breakGlass are the
+	// policies annotated with a break glass, active or not.
+	breakGlassTTL time.Duration
+	breakGlass    map[types.NamespacedName]breakGlassState
+	// historySize is the number of compiled generations retained per policy, zero disables pinning. history are
+	// the retained revisions of the policies, oldest first, and pins the policies annotated with a pin.
+	historySize int
+	history     map[types.NamespacedName][]policyRevision
+	pins        map[types.NamespacedName]pinState
+	// compileCache is released when policies are evicted, nil when the compiler doesn't share compilations
+	compileCache *CompileCache
+}
+
+func newPolicyReconciler(client client.Client, compiler Compiler, selector labels.Selector, logger logr.Logger, recorder record.EventRecorder) *policyReconciler {
+	r := &policyReconciler{
+		client:     client,
+		logger:     logger,
+		recorder:   recorder,
+		compiler:   compiler,
+		selector:   selector,
+		lock:       &sync.RWMutex{},
+		policies:   map[types.NamespacedName]CompiledPolicy{},
+		versions:   map[types.NamespacedName]policyVersion{},
+		specs:      map[types.NamespacedName]*hub.AuthorizationPolicySpec{},
+		statuses:   map[types.NamespacedName]PolicyStatus{},
+		lister:     client,
+		pageSize:   defaultSyncPageSize,
+		reconciled: sets.New[types.NamespacedName](),
+		compare:    ByPriority,
+		deleted:    map[types.NamespacedName]time.Time{},
+		now:        time.Now,
+		bindings:   map[types.NamespacedName]dataBinding{},
+		breakGlass: map[types.NamespacedName]breakGlassState{},
+		history:    map[types.NamespacedName][]policyRevision{},
+		pins:       map[types.NamespacedName]pinState{},
+	}
+	r.resetSortPolicies()
+	r.leader.Store(true)
+	return r
+}
+
+// resetSortPolicies must be called with the lock held every time the policies map changes
+func (r *policyReconciler) resetSortPolicies() {
+	r.sortPolicies = sync.OnceValue(func() []CompiledPolicy {
+		return r.sortPoliciesWithBreakGlass()
+	})
+}
+
+// set replaces the evaluated policy and the spec it was compiled from, it returns the spec fields that changed
+// or false if there was no previous policy
+func (r *policyReconciler) set(key types.NamespacedName, version policyVersion, spec *hub.AuthorizationPolicySpec, compiled CompiledPolicy) ([]string, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var changes []string
+	previous, replaced := r.specs[key]
+	if replaced {
+		changes = changedFields(previous, spec, r.policies[key].Annotations, compiled.Annotations)
+	}
+	// the decisions of a policy moving to another namespace are recorded in a new series
+	if loaded, ok := r.policies[key]; ok && loaded.Namespace != compiled.Namespace {
+		r.metrics.ForgetPolicyDecisions(loaded.Namespace, key.Name)
+	}
+	r.policies[key] = compiled
+	r.versions[key] = version
+	r.specs[key] = spec
+	r.recordRevision(key, version.generation, compiled)
+	r.resetSortPolicies()
+	return changes, replaced
+}
+
+func (r *policyReconciler) evict(key types.NamespacedName) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if loaded, ok := r.policies[key]; ok {
+		r.metrics.ForgetPolicyDecisions(loaded.Namespace, key.Name)
+	}
+	delete(r.policies, key)
+	delete(r.versions, key)
+	delete(r.specs, key)
+	delete(r.statuses, key)
+	delete(r.deleted, key)
+	delete(r.bindings, key)
+	delete(r.breakGlass, key)
+	delete(r.history, key)
+	delete(r.pins, key)
+	r.resetSortPolicies()
+	r.compileCache.Release(key.Name)
+	r.metrics.ForgetReconcile(key.Name)
+	r.metrics.ForgetBreakGlass(key.Name)
+}
+
+// gracefulDeletion returns how long a deleted policy is still evaluated, zero if it must be evicted now.
+// The policy stays in the map untouched meanwhile, the sorted policies don't change until it is evicted.
+func (r *policyReconciler) gracefulDeletion(key types.NamespacedName) time.Duration {
+	if r.deletionGracePeriod == 0 {
+		return 0
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	// only evaluated policies are worth keeping
+	if _, ok := r.policies[key]; !ok {
+		return 0
+	}
+	now := r.now()
+	deleted, ok := r.deleted[key]
+	if !ok {
+		deleted = now
+		r.deleted[key] = now
+	}
+	return max(deleted.Add(r.deletionGracePeriod).Sub(now), 0)
 }
 
-func newPolicyReconciler(client client.Client, compiler Compiler) *policyReconciler {
-	return &policyReconciler{
-		client:   client,
-		compiler: compiler,
-		lock:     &sync.RWMutex{},
-		policies: map[types.NamespacedName]PolicyFunc{},
+// recreated forgets the deletion of a policy that exists again, a new deletion restarts the grace period
+func (r *policyReconciler) recreated(key types.NamespacedName) {
+	if r.deletionGracePeriod == 0 {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.deleted, key)
+}
+
+// observe records the status of the last observed policy spec, err is the compilation error if any
+func (r *policyReconciler) observe(key types.NamespacedName, policy *hub.AuthorizationPolicy, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	now := r.now()
+	status := PolicyStatus{
+		Name:            policy.Name,
+		Priority:        policy.Spec.Priority,
+		Mode:            policy.Spec.GetEnforcementMode(),
+		Compiled:        err == nil,
+		Generation:      policy.Generation,
+		ResourceVersion: policy.ResourceVersion,
+		LastReconciled:  &now,
+	}
+	// report the spec that is evaluated, it can be a previous spec or a pinned generation
+	if compiled, ok := r.served(key); ok {
+		status.Active = !compiled.Disabled
+		status.Disabled = compiled.Disabled
+		status.Priority = compiled.Priority
+		status.Mode = compiled.Mode
+		status.EstimatedCost = compiled.EstimatedCost
+		status.Hash = compiled.Hash
+		if state := r.breakGlass[key]; state.active() && compiled.BreakGlass != nil {
+			status.BreakGlassExpires = ptr.To(state.expires)
+		}
+		if _, ok := r.revision(key, r.pins[key].generation); ok {
+			status.PinnedGeneration = r.pins[key].generation
+		}
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	if _, ok := err.(*quotaError); ok {
+		status.Rejected = true
+	}
+	r.statuses[key] = status
+	r.metrics.RecordReconcile(policy.Name, now)
+}
+
+// compiled returns true if the policy was already compiled from the same spec
+func (r *policyReconciler) compiled(key types.NamespacedName, version policyVersion) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	cached, ok := r.versions[key]
+	return ok && cached == version
+}
+
+// sync lists the policies known once the cache synced, the reconciler is synced when all of them were reconciled.
+// Policies are listed page by page and only their metadata is fetched, the reconciler compiles them meanwhile.
+func (r *policyReconciler) sync(ctx context.Context) error {
+	var next string
+	for {
+		// the kind is overwritten by the list call, a new list is needed for every page
+		var list metav1.PartialObjectMetadataList
+		list.SetGroupVersionKind(v1alpha1.SchemeGroupVersion.WithKind("AuthorizationPolicyList"))
+		if err := r.lister.List(ctx, &list, client.MatchingLabelsSelector{Selector: r.selector}, client.Limit(r.pageSize), client.Continue(next)); err != nil {
+			return fmt.Errorf("failed to list policies: %w", err)
+		}
+		r.syncPage(list.Items)
+		if next = list.Continue; next == "" {
+			break
+		}
+	}
+	// all policies were listed
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.reconciled = nil
+	r.synced.Store(r.pending.Len() == 0)
+	return nil
+}
+
+// syncPage marks the listed policies not reconciled yet as pending
+func (r *policyReconciler) syncPage(items []metav1.PartialObjectMetadata) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.pending == nil {
+		r.pending = sets.New[types.NamespacedName]()
+	}
+	for i := range items {
+		if key := client.ObjectKeyFromObject(&items[i]); !r.reconciled.Has(key) {
+			r.pending.Insert(key)
+		}
+	}
+	r.listed += len(items)
+	r.metrics.RecordInitialSync(r.listed, r.pending.Len())
+}
+
+func (r *policyReconciler) markReconciled(key types.NamespacedName) {
+	if r.synced.Load() {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	// the sync didn't list all policies yet, remember the policy was reconciled
+	if r.reconciled != nil {
+		r.reconciled.Insert(key)
+	}
+	// the sync didn't start yet
+	if r.pending == nil {
+		return
+	}
+	r.pending.Delete(key)
+	r.metrics.RecordInitialSync(r.listed, r.pending.Len())
+	if r.reconciled == nil && r.pending.Len() == 0 {
+		r.synced.Store(true)
 	}
 }
 
 func (r *policyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	result, err := r.reconcile(ctx, req)
+	if err == nil {
+		r.markReconciled(req.NamespacedName)
+		return result, nil
+	}
+	// retrying can't succeed, the error is logged by the controller and the policy is dropped,
+	// it doesn't hold the sync and is reconciled again on the next change
+	if permanent(err) {
+		r.markReconciled(req.NamespacedName)
+		return result, ctrlreconcile.TerminalError(err)
+	}
+	// transient errors are retried with backoff, the policy holds the sync until then
+	return result, err
+}
+
+func (r *policyReconciler) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := r.logger.WithValues("policy", req.NamespacedName.String())
+	logger.V(1).Info("reconciling policy")
 	var policy v1alpha1.AuthorizationPolicy
 	err := r.client.Get(ctx, req.NamespacedName, &policy)
 	if errors.IsNotFound(err) {
-		r.lock.Lock()
-		defer r.lock.Unlock()
-		delete(r.policies, req.NamespacedName)
+		// the policy is checked again once the grace period is over, it is evicted unless it was recreated
+		if remaining := r.gracefulDeletion(req.NamespacedName); remaining > 0 {
+			logger.Info("policy deleted, evicting it after the grace period", "remaining", remaining)
+			return ctrl.Result{RequeueAfter: remaining}, nil
+		}
+		logger.Info("policy deleted")
+		r.evict(req.NamespacedName)
 		return ctrl.Result{}, nil
 	}
 	if err != nil {
 		return ctrl.Result{}, err
 	}
-	compiled, errs := r.compiler.Compile(&policy)
+	r.recreated(req.NamespacedName)
+	// evict the policy if it doesn't match the selector (anymore)
+	if !r.selector.Matches(labels.Set(policy.Labels)) {
+		logger.V(1).Info("policy doesn't match the selector")
+		r.evict(req.NamespacedName)
+		return ctrl.Result{}, nil
+	}
+	version := policyVersion{
+		uid:         policy.UID,
+		generation:  policy.Generation,
+		annotations: annotationsVersion(policy.Annotations),
+		namespace:   r.policyNamespace(&policy),
+	}
+	// the compiler operates on the hub version, the status is written to the served version
+	converted, err := ConvertPolicy(&policy)
+	if err != nil {
+		return r.reconcileBreakGlass(logger, &policy), err
+	}
+	// policies missing fields the compiler relies on are not compiled, they are reconciled again on the next change
+	if errs := validateShape(&policy); len(errs) > 0 {
+		logger.Error(errs.ToAggregate(), "malformed policy", "generation", policy.Generation)
+		message := errs.ToAggregate().Error()
+		previous := meta.FindStatusCondition(policy.Status.Conditions, v1alpha1.ConditionReady)
+		// don't record the same rejection again on every requeue
+		if r.leader.Load() && (previous == nil || previous.Reason != v1alpha1.ReasonMalformed || previous.Message != message) {
+			r.recorder.Event(&policy, corev1.EventTypeWarning, eventReasonMalformed, message)
+		}
+		// like a compilation failure, the previous spec is still evaluated and so is its break glass
+		result := r.reconcileBreakGlass(logger, &policy)
+		r.observe(req.NamespacedName, converted, errs.ToAggregate())
+		return result, r.updateStatus(ctx, &policy, metav1.Condition{
+			Type:    v1alpha1.ConditionReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  v1alpha1.ReasonMalformed,
+			Message: message,
+		})
+	}
+	// policies exceeding a quota are not compiled, they are checked again once older policies may have been deleted
+	exceeded, err := r.exceededQuota(ctx, &policy)
+	if err != nil {
+		return r.reconcileBreakGlass(logger, &policy), err
+	}
+	if exceeded != nil {
+		logger.Info("policy exceeds a quota", "quota", exceeded.quota, "error", exceeded.Error())
+		message := exceeded.Error()
+		previous := meta.FindStatusCondition(policy.Status.Conditions, v1alpha1.ConditionReady)
+		// don't record the same rejection again on every requeue
+		if r.leader.Load() && (previous == nil || previous.Reason != v1alpha1.ReasonQuotaExceeded || previous.Message != message) {
+			r.recorder.Event(&policy, corev1.EventTypeWarning, eventReasonQuotaExceeded, message)
+		}
+		r.reject(req.NamespacedName, exceeded)
+		// a break glass expiring before the retry must not wait for it
+		result := requeueFirst(ctrl.Result{RequeueAfter: quotaRetryDelay}, r.reconcileBreakGlass(logger, &policy))
+		r.observe(req.NamespacedName, converted, exceeded)
+		return result, r.updateStatus(ctx, &policy, metav1.Condition{
+			Type:    v1alpha1.ConditionReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  v1alpha1.ReasonQuotaExceeded,
+			Message: message,
+		})
+	}
+	// the entries of the data sources are read on every reconcile, the policy is reconciled again when they change
+	var data core.Data
+	if len(converted.Spec.Data) > 0 && r.dataSources {
+		data, err = r.resolveData(ctx, logger, converted.Spec.Data)
+		if err != nil {
+			return r.reconcileBreakGlass(logger, &policy), err
+		}
+	}
+	// the spec didn't change, no need to compile again
+	if r.compiled(req.NamespacedName, version) {
+		if r.refreshData(req.NamespacedName, data) {
+			logger.Info("policy data changed")
+		}
+		r.reconcilePin(logger, &policy)
+		result := r.reconcileBreakGlass(logger, &policy)
+		r.observe(req.NamespacedName, converted, nil)
+		return result, r.updateStatus(ctx, &policy, readyCondition(&converted.Spec))
+	}
+	// the current status tells whether the previous compilation failed, even across restarts
+	failed := meta.FindStatusCondition(policy.Status.Conditions, v1alpha1.ConditionReady)
+	if failed != nil && failed.Reason != v1alpha1.ReasonCompilationFailed {
+		failed = nil
+	}
+	compiled, errs := r.compile(converted)
 	if len(errs) > 0 {
-		fmt.Println(errs)
+		logger.Error(errs.ToAggregate(), "failed to compile policy", "generation", policy.Generation)
+		message := errs.ToAggregate().Error()
+		// don't record the same failure again on every requeue
+		if r.leader.Load() && (failed == nil || failed.Message != message) {
+			r.recorder.Event(&policy, corev1.EventTypeWarning, eventReasonCompileFailed, message)
+		}
+		// the break glass applies to the previous spec if it is still evaluated
+		r.reconcilePin(logger, &policy)
+		result := r.reconcileBreakGlass(logger, &policy)
+		r.observe(req.NamespacedName, converted, errs.ToAggregate())
 		// No need to retry it
-		return ctrl.Result{}, nil
+		return result, r.updateStatus(ctx, &policy, metav1.Condition{
+			Type:    v1alpha1.ConditionReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  v1alpha1.ReasonCompilationFailed,
+			Message: message,
+		})
 	}
-	r.lock.Lock()
-	defer r.lock.Unlock()
-	r.policies[req.NamespacedName] = compiled
-	return ctrl.Result{}, nil
+	// only record the recovery, successful compilations are the common case
+	if r.leader.Load() && failed != nil {
+		r.recorder.Event(&policy, corev1.EventTypeNormal, eventReasonCompiled, "Policy compiled successfully")
+	}
+	compiled = r.bind(req.NamespacedName, &converted.Spec, compiled, data)
+	// any compiler implementation can be disabled
+	compiled.Disabled = converted.Spec.Disabled
+	compiled.Namespace = version.namespace
+	// report the changes of the evaluated policy, compiling an identical spec again (after a recreation for example) is not a change
+	switch changes, replaced := r.set(req.NamespacedName, version, &converted.Spec, compiled); {
+	case !replaced:
+		logger.Info("policy compiled", "generation", policy.Generation)
+	case len(changes) == 0:
+		logger.V(1).Info("policy compiled without change", "generation", policy.Generation)
+	default:
+		logger.Info("policy changed", "generation", policy.Generation, "fields", changes)
+		if r.leader.Load() {
+			r.recorder.Event(&policy, corev1.EventTypeNormal, eventReasonChanged, "Policy changed: "+strings.Join(changes, ", "))
+		}
+	}
+	r.reconcilePin(logger, &policy)
+	result := r.reconcileBreakGlass(logger, &policy)
+	r.observe(req.NamespacedName, converted, nil)
+	return result, r.updateStatus(ctx, &policy, readyCondition(&converted.Spec))
+}
+
+func (r *policyReconciler) updateStatus(ctx context.Context, policy *v1alpha1.AuthorizationPolicy, condition metav1.Condition) error {
+	// track the generation the condition was computed for
+	condition.ObservedGeneration = policy.Generation
+	// only the leader writes the status, a promoted replica reconciles all policies again
+	if !r.leader.Load() {
+		return nil
+	}
+	// report the cost and hash of the evaluated spec, it can be a previous spec
+	cost, hash := r.evaluated(client.ObjectKeyFromObject(policy))
+	stale := false
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		// the status is computed again from the latest version after a conflict
+		if stale {
+			if err := r.client.Get(ctx, client.ObjectKeyFromObject(policy), policy); err != nil {
+				return err
+			}
+		}
+		stale = true
+		base := policy.DeepCopy()
+		changed := meta.SetStatusCondition(&policy.Status.Conditions, condition)
+		if setUnknownFieldsCondition(policy) {
+			changed = true
+		}
+		if policy.Status.EstimatedCost != cost {
+			policy.Status.EstimatedCost = cost
+			changed = true
+		}
+		if policy.Status.Hash != hash {
+			policy.Status.Hash = hash
+			changed = true
+		}
+		// nothing to do if the status didn't change
+		if !changed {
+			return nil
+		}
+		// a merge patch of the status subresource doesn't carry the resource version,
+		// spec changes made since the policy was read are neither rejected nor overwritten
+		return r.client.Status().Patch(ctx, policy, client.MergeFrom(base))
+	})
+}
+
+// setUnknownFieldsCondition reports the fields of the last applied configuration unknown to the policy version,
+// the API server prunes them so the policy can only be checked against the configuration kubectl applied.
+// The condition is removed when the policy has no such configuration, it returns true if the conditions changed.
+func setUnknownFieldsCondition(policy *v1alpha1.AuthorizationPolicy) bool {
+	applied, ok := policy.Annotations[corev1.LastAppliedConfigAnnotation]
+	if !ok {
+		return meta.RemoveStatusCondition(&policy.Status.Conditions, v1alpha1.ConditionUnknownFields)
+	}
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionUnknownFields,
+		Status:             metav1.ConditionFalse,
+		Reason:             v1alpha1.ReasonNoUnknownFields,
+		ObservedGeneration: policy.Generation,
+	}
+	// an annotation that can't be decoded is not the policy configuration, there's nothing to report
+	unknown, err := core.UnknownFields([]byte(applied))
+	if err != nil {
+		return meta.RemoveStatusCondition(&policy.Status.Conditions, v1alpha1.ConditionUnknownFields)
+	}
+	if len(unknown) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = v1alpha1.ReasonUnknownFieldsFound
+		condition.Message = "Fields ignored by the API server: " + strings.Join(unknown, ", ")
+	}
+	return meta.SetStatusCondition(&policy.Status.Conditions, condition)
+}
+
+// evaluated returns the estimated cost and the hash of the evaluated policy, zero values when the policy is not active
+func (r *policyReconciler) evaluated(key types.NamespacedName) (int64, string) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	compiled := r.policies[key]
+	// the api field is signed, saturated estimates are clamped
+	return int64(min(compiled.EstimatedCost, math.MaxInt64)), compiled.Hash
+}
+
+func (r *policyReconciler) HasSynced() bool {
+	return r.synced.Load()
 }
 
-func (r *policyReconciler) CompiledPolicies(ctx context.Context) ([]PolicyFunc, error) {
+// Inspect returns the status of the observed policies in evaluation order
+func (r *policyReconciler) Inspect() []PolicyStatus {
 	r.lock.RLock()
 	defer r.lock.RUnlock()
-	out := make([]PolicyFunc, 0, len(r.policies))
-	for _, policy := range r.policies {
-		out = append(out, policy)
+	out := make([]PolicyStatus, 0, len(r.statuses))
+	for _, status := range r.statuses {
+		out = append(out, status)
+	}
+	// report policies in evaluation order, policies with an active break glass first
+	slices.SortFunc(out, func(a, b PolicyStatus) int {
+		if c := cmp.Compare(boolToInt(b.BreakGlassExpires != nil), boolToInt(a.BreakGlassExpires != nil)); c != 0 {
+			return c
+		}
+		if c := r.compare(CompiledPolicy{Name: a.Name, Priority: a.Priority, Mode: a.Mode}, CompiledPolicy{Name: b.Name, Priority: b.Priority, Mode: b.Mode}); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return out
+}
+
+func (r *policyReconciler) CompiledPolicies(ctx context.Context) ([]CompiledPolicy, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.sortPolicies(), nil
+}
+
+// mapToSortedSlice returns the compiled policies ordered by the comparator, ties are broken by name
+func mapToSortedSlice(policies map[types.NamespacedName]CompiledPolicy, compare PolicyComparator) []CompiledPolicy {
+	keys := make([]types.NamespacedName, 0, len(policies))
+	for key := range policies {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b types.NamespacedName) int {
+		if c := compare(policies[a], policies[b]); c != 0 {
+			return c
+		}
+		return strings.Compare(a.String(), b.String())
+	})
+	out := make([]CompiledPolicy, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, policies[key])
 	}
-	return out, nil
+	return out
 }
@@ -0,0 +1,1073 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func newFakeClient(t *testing.T, objects ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	assert.NoError(t, v1alpha1.Install(scheme))
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objects...).
+		WithStatusSubresource(&v1alpha1.AuthorizationPolicy{}).
+		Build()
+}
+
+func newPolicy(name string, expressions ...string) *v1alpha1.AuthorizationPolicy {
+	policy := &v1alpha1.AuthorizationPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       name,
+			Generation: 1,
+		},
+	}
+	for _, expression := range expressions {
+		policy.Spec.Authorizations = append(policy.Spec.Authorizations, v1alpha1.Authorization{Expression: expression})
+	}
+	return policy
+}
+
+// newHubPolicy returns the hub version of newPolicy, as given to compilers
+func newHubPolicy(t *testing.T, name string, expressions ...string) *hub.AuthorizationPolicy {
+	t.Helper()
+	policy, err := ConvertPolicy(newPolicy(name, expressions...))
+	assert.NoError(t, err)
+	return policy
+}
+
+func reconcile(t *testing.T, r *policyReconciler, name string) {
+	t.Helper()
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: name}})
+	assert.NoError(t, err)
+}
+
+func Test_policyReconciler_Reconcile(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       *v1alpha1.AuthorizationPolicy
+		wantStatus   metav1.ConditionStatus
+		wantReason   string
+		wantPolicies int
+	}{{
+		name:         "valid",
+		policy:       newPolicy("valid", "envoy.Allowed().Response()"),
+		wantStatus:   metav1.ConditionTrue,
+		wantReason:   v1alpha1.ReasonCompiled,
+		wantPolicies: 1,
+	}, {
+		name:         "invalid",
+		policy:       newPolicy("invalid", "envoy.Allowed()"),
+		wantStatus:   metav1.ConditionFalse,
+		wantReason:   v1alpha1.ReasonCompilationFailed,
+		wantPolicies: 0,
+	}, {
+		name:         "malformed",
+		policy:       newPolicy("malformed", ""),
+		wantStatus:   metav1.ConditionFalse,
+		wantReason:   v1alpha1.ReasonMalformed,
+		wantPolicies: 0,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newFakeClient(t, tt.policy)
+			r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+			reconcile(t, r, tt.policy.Name)
+			var policy v1alpha1.AuthorizationPolicy
+			assert.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(tt.policy), &policy))
+			condition := meta.FindStatusCondition(policy.Status.Conditions, v1alpha1.ConditionReady)
+			assert.NotNil(t, condition)
+			assert.Equal(t, tt.wantStatus, condition.Status)
+			assert.Equal(t, tt.wantReason, condition.Reason)
+			assert.Equal(t, policy.Generation, condition.ObservedGeneration)
+			policies, err := r.CompiledPolicies(context.Background())
+			assert.NoError(t, err)
+			assert.Len(t, policies, tt.wantPolicies)
+		})
+	}
+}
+
+func Test_policyReconciler_Reconcile_recovery(t *testing.T) {
+	c := newFakeClient(t, newPolicy("policy", "envoy.Allowed()"))
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	reconcile(t, r, "policy")
+	// fix the policy
+	var policy v1alpha1.AuthorizationPolicy
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "policy"}, &policy))
+	policy.Spec.Authorizations[0].Expression = "envoy.Allowed().Response()"
+	policy.Generation = 2
+	assert.NoError(t, c.Update(context.Background(), &policy))
+	reconcile(t, r, "policy")
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "policy"}, &policy))
+	condition := meta.FindStatusCondition(policy.Status.Conditions, v1alpha1.ConditionReady)
+	assert.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, int64(2), condition.ObservedGeneration)
+}
+
+func Test_policyReconciler_Reconcile_estimatedCost(t *testing.T) {
+	c := newFakeClient(t, newPolicy("policy", "envoy.Allowed().Response()"))
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	reconcile(t, r, "policy")
+	compiled, errs := NewCompiler().Compile(newHubPolicy(t, "policy", "envoy.Allowed().Response()"))
+	assert.Empty(t, errs)
+	var policy v1alpha1.AuthorizationPolicy
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "policy"}, &policy))
+	assert.NotZero(t, policy.Status.EstimatedCost)
+	assert.Equal(t, int64(compiled.EstimatedCost), policy.Status.EstimatedCost)
+	// a more expensive spec failing to compile keeps the cost of the evaluated spec
+	policy.Spec.Authorizations[0].Expression = `object.attributes.request.http.headers.all(k, k.startsWith("x-")) ? envoy.Allowed()`
+	policy.Generation = 2
+	assert.NoError(t, c.Update(context.Background(), &policy))
+	reconcile(t, r, "policy")
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "policy"}, &policy))
+	assert.Equal(t, int64(compiled.EstimatedCost), policy.Status.EstimatedCost)
+	// the fixed spec reports its own cost
+	policy.Spec.Authorizations[0].Expression = `object.attributes.request.http.headers.all(k, k.startsWith("x-")) ? envoy.Allowed().Response() : null`
+	policy.Generation = 3
+	assert.NoError(t, c.Update(context.Background(), &policy))
+	reconcile(t, r, "policy")
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "policy"}, &policy))
+	assert.Greater(t, policy.Status.EstimatedCost, int64(compiled.EstimatedCost))
+}
+
+func Test_policyReconciler_Reconcile_hash(t *testing.T) {
+	c := newFakeClient(t, newPolicy("policy", "envoy.Allowed().Response()"))
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	reconcile(t, r, "policy")
+	compiled, errs := NewCompiler().Compile(newHubPolicy(t, "policy", "envoy.Allowed().Response()"))
+	assert.Empty(t, errs)
+	var policy v1alpha1.AuthorizationPolicy
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "policy"}, &policy))
+	assert.NotEmpty(t, policy.Status.Hash)
+	assert.Equal(t, compiled.Hash, policy.Status.Hash)
+	// metadata changes keep the hash
+	policy.Annotations = map[string]string{"owner": "team-a"}
+	policy.Labels = map[string]string{"app": "demo"}
+	assert.NoError(t, c.Update(context.Background(), &policy))
+	reconcile(t, r, "policy")
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "policy"}, &policy))
+	assert.Equal(t, compiled.Hash, policy.Status.Hash)
+	// a spec failing to compile keeps the hash of the evaluated spec
+	policy.Spec.Authorizations[0].Expression = "envoy.Denied(403"
+	policy.Generation = 2
+	assert.NoError(t, c.Update(context.Background(), &policy))
+	reconcile(t, r, "policy")
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "policy"}, &policy))
+	assert.Equal(t, compiled.Hash, policy.Status.Hash)
+	// a rule change changes the hash
+	policy.Spec.Authorizations[0].Expression = "envoy.Denied(403).Response()"
+	policy.Generation = 3
+	assert.NoError(t, c.Update(context.Background(), &policy))
+	reconcile(t, r, "policy")
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "policy"}, &policy))
+	assert.NotEmpty(t, policy.Status.Hash)
+	assert.NotEqual(t, compiled.Hash, policy.Status.Hash)
+}
+
+func Test_policyReconciler_Reconcile_disabled(t *testing.T) {
+	ctx := context.Background()
+	disabled := newPolicy("deny", "envoy.Denied(403).Response()")
+	disabled.Spec.Disabled = true
+	c := newFakeClient(t, disabled, newPolicy("allow", "envoy.Allowed().Response()"))
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	reconcile(t, r, "deny")
+	reconcile(t, r, "allow")
+	names := func() []string {
+		policies, err := r.CompiledPolicies(ctx)
+		assert.NoError(t, err)
+		var out []string
+		for _, policy := range policies {
+			out = append(out, policy.Name)
+		}
+		return out
+	}
+	condition := func() *metav1.Condition {
+		var policy v1alpha1.AuthorizationPolicy
+		assert.NoError(t, c.Get(ctx, types.NamespacedName{Name: "deny"}, &policy))
+		return meta.FindStatusCondition(policy.Status.Conditions, v1alpha1.ConditionReady)
+	}
+	// the disabled policy is compiled but not evaluated
+	assert.Equal(t, []string{"allow"}, names())
+	if condition := condition(); assert.NotNil(t, condition) {
+		assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		assert.Equal(t, v1alpha1.ReasonDisabled, condition.Reason)
+	}
+	status := r.Inspect()[1]
+	assert.Equal(t, "deny", status.Name)
+	assert.False(t, status.Active)
+	assert.True(t, status.Disabled)
+	assert.True(t, status.Compiled)
+	// compilation errors of a disabled policy are reported
+	var policy v1alpha1.AuthorizationPolicy
+	assert.NoError(t, c.Get(ctx, types.NamespacedName{Name: "deny"}, &policy))
+	policy.Spec.Authorizations[0].Expression = "envoy.Denied(403"
+	policy.Generation = 2
+	assert.NoError(t, c.Update(ctx, &policy))
+	reconcile(t, r, "deny")
+	assert.Equal(t, []string{"allow"}, names())
+	if condition := condition(); assert.NotNil(t, condition) {
+		assert.Equal(t, v1alpha1.ReasonCompilationFailed, condition.Reason)
+	}
+	// the policy is evaluated again once enabled
+	assert.NoError(t, c.Get(ctx, types.NamespacedName{Name: "deny"}, &policy))
+	policy.Spec.Authorizations[0].Expression = "envoy.Denied(403).Response()"
+	policy.Spec.Disabled = false
+	policy.Generation = 3
+	assert.NoError(t, c.Update(ctx, &policy))
+	reconcile(t, r, "deny")
+	assert.ElementsMatch(t, []string{"allow", "deny"}, names())
+	if condition := condition(); assert.NotNil(t, condition) {
+		assert.Equal(t, metav1.ConditionTrue, condition.Status)
+		assert.Equal(t, v1alpha1.ReasonCompiled, condition.Reason)
+	}
+	status = r.Inspect()[1]
+	assert.True(t, status.Active)
+	assert.False(t, status.Disabled)
+}
+
+func Test_policyReconciler_Reconcile_unknownFields(t *testing.T) {
+	policy := newPolicy("policy", "envoy.Allowed().Response()")
+	policy.Annotations = map[string]string{
+		corev1.LastAppliedConfigAnnotation: `{"apiVersion":"envoy.kyverno.io/v1alpha1","kind":"AuthorizationPolicy","metadata":{"name":"policy"},"spec":{"priorty":10,"authorizations":[{"expression":"envoy.Allowed().Response()"}]}}`,
+	}
+	c := newFakeClient(t, policy)
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	condition := func() *metav1.Condition {
+		t.Helper()
+		reconcile(t, r, "policy")
+		var policy v1alpha1.AuthorizationPolicy
+		assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "policy"}, &policy))
+		// the policy compiles without the pruned fields
+		assert.True(t, meta.IsStatusConditionTrue(policy.Status.Conditions, v1alpha1.ConditionReady))
+		return meta.FindStatusCondition(policy.Status.Conditions, v1alpha1.ConditionUnknownFields)
+	}
+	update := func(annotations map[string]string) {
+		t.Helper()
+		var policy v1alpha1.AuthorizationPolicy
+		assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "policy"}, &policy))
+		policy.Annotations = annotations
+		assert.NoError(t, c.Update(context.Background(), &policy))
+	}
+	// the misspelled field is reported
+	if got := condition(); assert.NotNil(t, got) {
+		assert.Equal(t, metav1.ConditionTrue, got.Status)
+		assert.Equal(t, v1alpha1.ReasonUnknownFieldsFound, got.Reason)
+		assert.Equal(t, `Fields ignored by the API server: unknown field "spec.priorty"`, got.Message)
+	}
+	// the fixed configuration clears it
+	update(map[string]string{
+		corev1.LastAppliedConfigAnnotation: `{"apiVersion":"envoy.kyverno.io/v1alpha1","kind":"AuthorizationPolicy","metadata":{"name":"policy"},"spec":{"priority":10,"authorizations":[{"expression":"envoy.Allowed().Response()"}]}}`,
+	})
+	if got := condition(); assert.NotNil(t, got) {
+		assert.Equal(t, metav1.ConditionFalse, got.Status)
+		assert.Equal(t, v1alpha1.ReasonNoUnknownFields, got.Reason)
+	}
+	// policies not applied by kubectl can't be checked
+	update(nil)
+	assert.Nil(t, condition())
+}
+
+func Test_policyReconciler_Reconcile_statusRace(t *testing.T) {
+	tests := []struct {
+		name      string
+		conflicts int
+	}{{
+		name: "spec updated before the status write",
+	}, {
+		name:      "status write conflicting",
+		conflicts: 2,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			assert.NoError(t, v1alpha1.Install(scheme))
+			patches := 0
+			c := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(newPolicy("policy", "envoy.Allowed().Response()")).
+				WithStatusSubresource(&v1alpha1.AuthorizationPolicy{}).
+				WithInterceptorFuncs(interceptor.Funcs{
+					SubResourcePatch: func(ctx context.Context, c client.Client, subResource string, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+						patches++
+						// the user edits the spec after the reconciler read the policy
+						if patches == 1 {
+							var policy v1alpha1.AuthorizationPolicy
+							assert.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(obj), &policy))
+							policy.Spec.Reason = `"edited"`
+							policy.Generation = 2
+							assert.NoError(t, c.Update(ctx, &policy))
+						}
+						if patches <= tt.conflicts {
+							return errors.NewConflict(v1alpha1.Resource("authorizationpolicies"), obj.GetName(), fmt.Errorf("conflict"))
+						}
+						return c.SubResource(subResource).Patch(ctx, obj, patch, opts...)
+					},
+				}).
+				Build()
+			r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+			reconcile(t, r, "policy")
+			assert.Equal(t, tt.conflicts+1, patches)
+			// both the spec edit and the status land
+			var policy v1alpha1.AuthorizationPolicy
+			assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "policy"}, &policy))
+			assert.Equal(t, `"edited"`, policy.Spec.Reason)
+			condition := meta.FindStatusCondition(policy.Status.Conditions, v1alpha1.ConditionReady)
+			if assert.NotNil(t, condition) {
+				assert.Equal(t, metav1.ConditionTrue, condition.Status)
+				// the condition was computed for the generation that was compiled
+				assert.Equal(t, int64(1), condition.ObservedGeneration)
+			}
+			assert.NotZero(t, policy.Status.EstimatedCost)
+		})
+	}
+}
+
+func drainEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func Test_policyReconciler_Reconcile_events(t *testing.T) {
+	c := newFakeClient(t, newPolicy("policy", "envoy.Allowed()"))
+	recorder := record.NewFakeRecorder(10)
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), recorder)
+	reconcile(t, r, "policy")
+	events := drainEvents(recorder)
+	assert.Len(t, events, 1)
+	assert.Contains(t, events[0], "Warning CompileFailed")
+	// the same failure is not recorded twice
+	reconcile(t, r, "policy")
+	assert.Empty(t, drainEvents(recorder))
+	// fix the policy
+	var policy v1alpha1.AuthorizationPolicy
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "policy"}, &policy))
+	policy.Spec.Authorizations[0].Expression = "envoy.Allowed().Response()"
+	policy.Generation = 2
+	assert.NoError(t, c.Update(context.Background(), &policy))
+	reconcile(t, r, "policy")
+	assert.Equal(t, []string{"Normal Compiled Policy compiled successfully"}, drainEvents(recorder))
+	// successful compilations are not recorded
+	reconcile(t, r, "policy")
+	assert.Empty(t, drainEvents(recorder))
+}
+
+func Test_policyReconciler_Reconcile_malformed(t *testing.T) {
+	c := newFakeClient(t, newPolicy("policy", "envoy.Allowed().Response()"))
+	recorder := record.NewFakeRecorder(10)
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), recorder)
+	reconcile(t, r, "policy")
+	// a policy the API server accepted with a mismatching CRD
+	var policy v1alpha1.AuthorizationPolicy
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "policy"}, &policy))
+	policy.Spec.Data = []v1alpha1.DataSource{{
+		Name:      "tenants",
+		ConfigMap: &v1alpha1.DataSourceReference{Namespace: "default", Name: "tenants"},
+		Secret:    &v1alpha1.DataSourceReference{Namespace: "default", Name: "tenants"},
+	}}
+	policy.Spec.Headers = &v1alpha1.Headers{Request: []v1alpha1.HeaderMutation{{Name: "x-tenant"}}}
+	policy.Generation = 2
+	assert.NoError(t, c.Update(context.Background(), &policy))
+	assert.NotPanics(t, func() { reconcile(t, r, "policy") })
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "policy"}, &policy))
+	condition := meta.FindStatusCondition(policy.Status.Conditions, v1alpha1.ConditionReady)
+	assert.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Equal(t, v1alpha1.ReasonMalformed, condition.Reason)
+	assert.Equal(t, int64(2), condition.ObservedGeneration)
+	assert.Contains(t, condition.Message, `spec.data[0]: Invalid value: "tenants": exactly one of configMap or secret is required`)
+	assert.Contains(t, condition.Message, "spec.headers.request[0].expression: Required value")
+	events := drainEvents(recorder)
+	assert.Len(t, events, 1)
+	assert.Contains(t, events[0], "Warning Malformed")
+	// the same rejection is not recorded twice
+	reconcile(t, r, "policy")
+	assert.Empty(t, drainEvents(recorder))
+	// like a compilation failure, the previous spec is still evaluated
+	policies, err := r.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, policies, 1)
+	statuses := r.Inspect()
+	assert.Len(t, statuses, 1)
+	assert.False(t, statuses[0].Compiled)
+}
+
+func Test_policyReconciler_Reconcile_selector(t *testing.T) {
+	selector, err := labels.Parse("team=foo")
+	assert.NoError(t, err)
+	matching := newPolicy("matching", "envoy.Allowed().Response()")
+	matching.Labels = map[string]string{"team": "foo"}
+	other := newPolicy("other", "envoy.Allowed().Response()")
+	other.Labels = map[string]string{"team": "bar"}
+	c := newFakeClient(t, matching, other)
+	r := newPolicyReconciler(c, NewCompiler(), selector, logr.Discard(), record.NewFakeRecorder(10))
+	reconcile(t, r, "matching")
+	reconcile(t, r, "other")
+	policies, err := r.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, policies, 1)
+	// change labels so that the policy doesn't match anymore
+	var policy v1alpha1.AuthorizationPolicy
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "matching"}, &policy))
+	policy.Labels["team"] = "bar"
+	assert.NoError(t, c.Update(context.Background(), &policy))
+	reconcile(t, r, "matching")
+	policies, err = r.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, policies, 0)
+}
+
+func Test_selectorPredicate(t *testing.T) {
+	selector, err := labels.Parse("team=foo")
+	assert.NoError(t, err)
+	matching := newPolicy("matching")
+	matching.Labels = map[string]string{"team": "foo"}
+	other := newPolicy("other")
+	predicate := selectorPredicate(selector)
+	assert.True(t, predicate.Create(event.CreateEvent{Object: matching}))
+	assert.False(t, predicate.Create(event.CreateEvent{Object: other}))
+	assert.True(t, predicate.Update(event.UpdateEvent{ObjectOld: matching, ObjectNew: other}))
+	assert.True(t, predicate.Update(event.UpdateEvent{ObjectOld: other, ObjectNew: matching}))
+	assert.False(t, predicate.Update(event.UpdateEvent{ObjectOld: other, ObjectNew: other}))
+	assert.True(t, predicate.Delete(event.DeleteEvent{Object: matching}))
+	assert.False(t, predicate.Delete(event.DeleteEvent{Object: other}))
+}
+
+func Test_mapToSortedSlice(t *testing.T) {
+	compile := func(name string, priority int32) CompiledPolicy {
+		policy := newHubPolicy(t, name, `envoy.Denied(403).Response().WithMessage("`+name+`")`)
+		policy.Spec.Priority = priority
+		compiled, errs := NewCompiler().Compile(policy)
+		assert.Empty(t, errs)
+		return compiled
+	}
+	tests := []struct {
+		name     string
+		policies map[types.NamespacedName]CompiledPolicy
+		want     []string
+	}{{
+		name: "empty",
+		want: []string{},
+	}, {
+		name: "by priority",
+		policies: map[types.NamespacedName]CompiledPolicy{
+			{Name: "a"}: compile("a", 0),
+			{Name: "b"}: compile("b", 10),
+			{Name: "c"}: compile("c", -5),
+		},
+		want: []string{"b", "a", "c"},
+	}, {
+		name: "equal priority",
+		policies: map[types.NamespacedName]CompiledPolicy{
+			{Name: "c"}: compile("c", 1),
+			{Name: "a"}: compile("a", 1),
+			{Name: "b"}: compile("b", 1),
+			{Name: "d"}: compile("d", 2),
+		},
+		want: []string{"d", "a", "b", "c"},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, policy := range mapToSortedSlice(tt.policies, ByPriority) {
+				response, err := policy.Evaluate(context.Background(), &authv3.CheckRequest{})
+				assert.NoError(t, err)
+				got = append(got, response.Status.Message)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_policyReconciler_CompiledPolicies_priority(t *testing.T) {
+	low := newPolicy("low", `envoy.Allowed().Response().WithMessage("low")`)
+	high := newPolicy("high", `envoy.Denied(403).Response().WithMessage("high")`)
+	high.Spec.Priority = 100
+	c := newFakeClient(t, low, high)
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	reconcile(t, r, "low")
+	reconcile(t, r, "high")
+	policies, err := r.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, policies, 2)
+	response, err := policies[0].Evaluate(context.Background(), &authv3.CheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "high", response.Status.Message)
+	// lower the priority, order must be recomputed after reconcile
+	var policy v1alpha1.AuthorizationPolicy
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "high"}, &policy))
+	policy.Spec.Priority = -100
+	policy.Generation = 2
+	assert.NoError(t, c.Update(context.Background(), &policy))
+	reconcile(t, r, "high")
+	policies, err = r.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	response, err = policies[0].Evaluate(context.Background(), &authv3.CheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "low", response.Status.Message)
+}
+
+func TestReady(t *testing.T) {
+	r := newPolicyReconciler(newFakeClient(t), NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	assert.False(t, Ready(context.Background(), r))
+	// the cache synced
+	assert.NoError(t, r.sync(context.Background()))
+	assert.True(t, Ready(context.Background(), r))
+}
+
+func Test_policyReconciler_sync(t *testing.T) {
+	selector, err := labels.Parse("team=foo")
+	assert.NoError(t, err)
+	label := func(policy *v1alpha1.AuthorizationPolicy, team string) *v1alpha1.AuthorizationPolicy {
+		policy.Labels = map[string]string{"team": team}
+		return policy
+	}
+	t.Run("empty", func(t *testing.T) {
+		// no policy, the provider is legitimately empty once synced
+		r := newPolicyReconciler(newFakeClient(t), NewCompiler(), selector, logr.Discard(), record.NewFakeRecorder(10))
+		assert.False(t, r.HasSynced())
+		assert.NoError(t, r.sync(context.Background()))
+		assert.True(t, r.HasSynced())
+		policies, err := r.CompiledPolicies(context.Background())
+		assert.NoError(t, err)
+		assert.Empty(t, policies)
+	})
+	t.Run("pending policies", func(t *testing.T) {
+		c := newFakeClient(t,
+			label(newPolicy("a", "envoy.Allowed().Response()"), "foo"),
+			label(newPolicy("b", "envoy.Allowed()"), "foo"),
+			label(newPolicy("other", "envoy.Allowed().Response()"), "bar"),
+		)
+		r := newPolicyReconciler(c, NewCompiler(), selector, logr.Discard(), record.NewFakeRecorder(10))
+		// reconciled before the cache sync
+		reconcile(t, r, "a")
+		assert.NoError(t, r.sync(context.Background()))
+		assert.False(t, r.HasSynced())
+		// a policy failing to compile doesn't hold the sync, policies not matching the selector are ignored
+		reconcile(t, r, "b")
+		assert.True(t, r.HasSynced())
+		policies, err := r.CompiledPolicies(context.Background())
+		assert.NoError(t, err)
+		assert.Len(t, policies, 1)
+	})
+	t.Run("deleted before reconcile", func(t *testing.T) {
+		policy := label(newPolicy("a", "envoy.Allowed().Response()"), "foo")
+		c := newFakeClient(t, policy)
+		r := newPolicyReconciler(c, NewCompiler(), selector, logr.Discard(), record.NewFakeRecorder(10))
+		assert.NoError(t, r.sync(context.Background()))
+		assert.False(t, r.HasSynced())
+		assert.NoError(t, c.Delete(context.Background(), policy))
+		reconcile(t, r, "a")
+		assert.True(t, r.HasSynced())
+	})
+}
+
+func Test_policyReconciler_Reconcile_cache(t *testing.T) {
+	c := newFakeClient(t, newPolicy("policy", "envoy.Allowed().Response()"))
+	compiler := &countingCompiler{Compiler: NewCompiler()}
+	r := newPolicyReconciler(c, compiler, labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	reconcile(t, r, "policy")
+	assert.Equal(t, 1, compiler.count)
+	// status and metadata updates don't change the generation
+	var policy v1alpha1.AuthorizationPolicy
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "policy"}, &policy))
+	policy.Labels = map[string]string{"foo": "bar"}
+	assert.NoError(t, c.Update(context.Background(), &policy))
+	reconcile(t, r, "policy")
+	assert.Equal(t, 1, compiler.count)
+	// spec updates change the generation
+	policy.Generation = 2
+	assert.NoError(t, c.Update(context.Background(), &policy))
+	reconcile(t, r, "policy")
+	assert.Equal(t, 2, compiler.count)
+	// deleting the policy invalidates the cache
+	assert.NoError(t, c.Delete(context.Background(), &policy))
+	reconcile(t, r, "policy")
+	assert.Empty(t, r.versions)
+}
+
+type countingCompiler struct {
+	Compiler
+	count int
+}
+
+func (c *countingCompiler) Compile(policy *hub.AuthorizationPolicy) (CompiledPolicy, field.ErrorList) {
+	c.count++
+	return c.Compiler.Compile(policy)
+}
+
+func Benchmark_policyReconciler_Reconcile(b *testing.B) {
+	policy := newPolicy("policy", `object.attributes.request.http.method == "GET" ? envoy.Allowed().Response() : envoy.Denied(403).Response()`)
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.Install(scheme); err != nil {
+		b.Fatal(err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(policy).WithStatusSubresource(policy).Build()
+	request := ctrl.Request{NamespacedName: types.NamespacedName{Name: policy.Name}}
+	b.Run("unchanged", func(b *testing.B) {
+		r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+		for range b.N {
+			if _, err := r.Reconcile(context.Background(), request); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("changed", func(b *testing.B) {
+		r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+		for range b.N {
+			// forget the compiled policy to force compilation
+			r.evict(request.NamespacedName)
+			if _, err := r.Reconcile(context.Background(), request); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// pagingLister simulates the api server pagination, the fake client ignores limit and continue options
+type pagingLister struct {
+	client.Reader
+	calls int
+}
+
+func (l *pagingLister) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	l.calls++
+	var options client.ListOptions
+	options.ApplyOptions(opts)
+	if err := l.Reader.List(ctx, list, client.MatchingLabelsSelector{Selector: options.LabelSelector}); err != nil {
+		return err
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+	offset := 0
+	if options.Continue != "" {
+		if offset, err = strconv.Atoi(options.Continue); err != nil {
+			return err
+		}
+	}
+	end := min(offset+int(options.Limit), len(items))
+	next := ""
+	if end < len(items) {
+		next = strconv.Itoa(end)
+	}
+	list.SetContinue(next)
+	return meta.SetList(list, items[offset:end])
+}
+
+func Test_policyReconciler_sync_paginated(t *testing.T) {
+	const count = 1000
+	objects := make([]client.Object, 0, count)
+	for i := range count {
+		objects = append(objects, newPolicy(fmt.Sprintf("policy-%04d", i), "envoy.Allowed().Response()"))
+	}
+	c := newFakeClient(t, objects...)
+	registry := prometheus.NewRegistry()
+	m, err := metrics.New(registry)
+	assert.NoError(t, err)
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	lister := &pagingLister{Reader: c}
+	r.lister, r.pageSize, r.metrics = lister, 100, m
+	// the first half is reconciled before the sync
+	for i := range count / 2 {
+		reconcile(t, r, fmt.Sprintf("policy-%04d", i))
+	}
+	assert.NoError(t, r.sync(context.Background()))
+	assert.Equal(t, count/100, lister.calls)
+	assert.False(t, r.HasSynced())
+	expected := `
+# HELP policy_initial_sync_listed Number of policies listed by the initial sync of the Kubernetes provider.
+# TYPE policy_initial_sync_listed gauge
+policy_initial_sync_listed 1000
+# HELP policy_initial_sync_pending Number of policies listed by the initial sync of the Kubernetes provider and not reconciled yet.
+# TYPE policy_initial_sync_pending gauge
+policy_initial_sync_pending 500
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "policy_initial_sync_listed", "policy_initial_sync_pending"))
+	// the policies map is populated as policies are reconciled
+	for i := count / 2; i < count; i++ {
+		assert.False(t, r.HasSynced())
+		reconcile(t, r, fmt.Sprintf("policy-%04d", i))
+	}
+	assert.True(t, r.HasSynced())
+	policies, err := r.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, policies, count)
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(strings.Replace(expected, "pending 500", "pending 0", 1)), "policy_initial_sync_listed", "policy_initial_sync_pending"))
+}
+
+func Test_policyReconciler_sync_reconciledWhileListing(t *testing.T) {
+	c := newFakeClient(t, newPolicy("a", "envoy.Allowed().Response()"), newPolicy("b", "envoy.Allowed().Response()"))
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	// a is listed in the first page and reconciled before the second page is listed
+	r.syncPage([]metav1.PartialObjectMetadata{{ObjectMeta: metav1.ObjectMeta{Name: "a"}}})
+	reconcile(t, r, "a")
+	reconcile(t, r, "b")
+	// readiness waits for the listing to complete
+	assert.False(t, r.HasSynced())
+	r.syncPage([]metav1.PartialObjectMetadata{{ObjectMeta: metav1.ObjectMeta{Name: "b"}}})
+	assert.False(t, r.HasSynced())
+	assert.NoError(t, r.sync(context.Background()))
+	assert.True(t, r.HasSynced())
+}
+
+func Test_policyReconciler_Inspect(t *testing.T) {
+	c := newFakeClient(t, newPolicy("a", "envoy.Allowed().Response()"), newPolicy("b", "envoy.Allowed().Response()"))
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	var _ Inspector = r
+	assert.Empty(t, r.Inspect())
+	reconcile(t, r, "a")
+	reconcile(t, r, "b")
+	// the status update changed the resource version, the next reconciliation observes it
+	reconcile(t, r, "a")
+	var a v1alpha1.AuthorizationPolicy
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "a"}, &a))
+	compiled, errs := NewCompiler().Compile(newHubPolicy(t, "a", "envoy.Allowed().Response()"))
+	assert.Empty(t, errs)
+	statuses := r.Inspect()
+	assert.Len(t, statuses, 2)
+	assert.Equal(t, PolicyStatus{Name: "a", Mode: hub.EnforcementModeEnforce, Active: true, Compiled: true, EstimatedCost: compiled.EstimatedCost, Hash: compiled.Hash, Generation: 1, ResourceVersion: a.ResourceVersion, LastReconciled: &now}, statuses[0])
+	// a spec failing to compile keeps the previous spec active
+	var b v1alpha1.AuthorizationPolicy
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "b"}, &b))
+	b.Spec.Priority = 10
+	b.Spec.Authorizations[0].Expression = "envoy.Allowed("
+	b.Generation = 2
+	assert.NoError(t, c.Update(context.Background(), &b))
+	reconcile(t, r, "b")
+	statuses = r.Inspect()
+	assert.Equal(t, "b", statuses[1].Name)
+	assert.True(t, statuses[1].Active)
+	assert.False(t, statuses[1].Compiled)
+	assert.NotEmpty(t, statuses[1].Error)
+	assert.Equal(t, int32(0), statuses[1].Priority)
+	assert.Equal(t, compiled.EstimatedCost, statuses[1].EstimatedCost)
+	assert.Equal(t, compiled.Hash, statuses[1].Hash)
+	assert.Equal(t, int64(2), statuses[1].Generation)
+	// deleted policies are not reported anymore
+	assert.NoError(t, c.Delete(context.Background(), &a))
+	reconcile(t, r, "a")
+	statuses = r.Inspect()
+	assert.Len(t, statuses, 1)
+	assert.Equal(t, "b", statuses[0].Name)
+}
+
+func Test_policyReconciler_Reconcile_lastReconciled(t *testing.T) {
+	c := newFakeClient(t, newPolicy("policy", "envoy.Allowed().Response()"))
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	registry := prometheus.NewRegistry()
+	m, err := metrics.New(registry)
+	assert.NoError(t, err)
+	r.metrics = m
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	reconcile(t, r, "policy")
+	var policy v1alpha1.AuthorizationPolicy
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "policy"}, &policy))
+	// the status written by the first reconciliation changed the resource version
+	assert.Equal(t, &now, r.Inspect()[0].LastReconciled)
+	assert.NotEqual(t, policy.ResourceVersion, r.Inspect()[0].ResourceVersion)
+	// every reconciliation records the time and the observed resource version, even when the spec didn't change
+	now = now.Add(time.Minute)
+	reconcile(t, r, "policy")
+	assert.Equal(t, &now, r.Inspect()[0].LastReconciled)
+	assert.Equal(t, policy.ResourceVersion, r.Inspect()[0].ResourceVersion)
+	expected := `
+# HELP policy_last_reconcile_timestamp_seconds Unix timestamp of the last reconciliation of a policy loaded from the Kubernetes API server, partitioned by policy.
+# TYPE policy_last_reconcile_timestamp_seconds gauge
+policy_last_reconcile_timestamp_seconds{policy="policy"} 1.70406726e+09
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "policy_last_reconcile_timestamp_seconds"))
+	// evicted policies don't report a timestamp anymore
+	assert.NoError(t, c.Delete(context.Background(), &policy))
+	reconcile(t, r, "policy")
+	assert.Equal(t, 0, testutil.CollectAndCount(registry, "policy_last_reconcile_timestamp_seconds"))
+}
+
+func Test_policyReconciler_Reconcile_compileCache(t *testing.T) {
+	ctx := context.Background()
+	a := newPolicy("a", "envoy.Denied(403).Response()")
+	b := newPolicy("b", "envoy.Denied(403).Response()")
+	c := newFakeClient(t, a, b, newPolicy("c", "envoy.Allowed().Response()"))
+	cache := NewCompileCache()
+	r := newPolicyReconciler(c, NewCompiler(WithCompileCache(cache)), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	r.compileCache = cache
+	for _, name := range []string{"a", "b", "c"} {
+		reconcile(t, r, name)
+	}
+	// the policies with identical specs are compiled once
+	assert.Equal(t, 2, cache.Len())
+	policies, err := r.CompiledPolicies(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, policies, 3) {
+		assert.Equal(t, policies[0].Hash, policies[1].Hash)
+	}
+	// the compilation is kept while a policy references it
+	assert.NoError(t, c.Delete(ctx, a))
+	reconcile(t, r, "a")
+	assert.Equal(t, 2, cache.Len())
+	policies, err = r.CompiledPolicies(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, policies, 2) {
+		response, err := policies[0].Evaluate(ctx, newPathRequest("/"))
+		assert.NoError(t, err)
+		assert.Equal(t, int32(7), response.GetStatus().GetCode())
+	}
+	// the last policy referencing it is deleted
+	assert.NoError(t, c.Delete(ctx, b))
+	reconcile(t, r, "b")
+	assert.Equal(t, 1, cache.Len())
+}
+
+// decisionCompiler is a compiler that doesn't use CEL, authorizations are either `allow` or `deny`
+type decisionCompiler struct{}
+
+func (decisionCompiler) Compile(policy *hub.AuthorizationPolicy) (CompiledPolicy, field.ErrorList) {
+	path := field.NewPath("spec", "authorizations")
+	var decisions []int32
+	for i, rule := range policy.Spec.Authorizations {
+		switch rule.Expression {
+		case "allow":
+			decisions = append(decisions, 0)
+		case "deny":
+			decisions = append(decisions, 7)
+		default:
+			return CompiledPolicy{}, field.ErrorList{field.NotSupported(path.Index(i).Child("expression"), rule.Expression, []string{"allow", "deny"})}
+		}
+	}
+	return CompiledPolicy{
+		Name:     policy.Name,
+		Priority: policy.Spec.Priority,
+		Mode:     policy.Spec.GetEnforcementMode(),
+		Evaluate: func(ctx context.Context, r *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			// the first rule decides
+			if len(decisions) == 0 {
+				return nil, nil
+			}
+			return &authv3.CheckResponse{Status: &status.Status{Code: decisions[0]}}, nil
+		},
+	}, nil
+}
+
+func Test_policyReconciler_Reconcile_compiler(t *testing.T) {
+	c := newFakeClient(t, newPolicy("allow", "allow"), newPolicy("deny", "deny"), newPolicy("none"), newPolicy("invalid", "envoy.Allowed().Response()"))
+	r := newPolicyReconciler(c, decisionCompiler{}, labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	for _, name := range []string{"allow", "deny", "none", "invalid"} {
+		reconcile(t, r, name)
+	}
+	// the policy failing to compile is not served and its status reports the compiler error
+	var invalid v1alpha1.AuthorizationPolicy
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "invalid"}, &invalid))
+	condition := meta.FindStatusCondition(invalid.Status.Conditions, v1alpha1.ConditionReady)
+	assert.NotNil(t, condition)
+	assert.Equal(t, v1alpha1.ReasonCompilationFailed, condition.Reason)
+	assert.Contains(t, condition.Message, `Unsupported value: "envoy.Allowed().Response()"`)
+	policies, err := r.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	got := map[string]*authv3.CheckResponse{}
+	for _, policy := range policies {
+		response, err := policy.Evaluate(context.Background(), &authv3.CheckRequest{})
+		assert.NoError(t, err)
+		got[policy.Name] = response
+	}
+	assert.Len(t, got, 3)
+	assert.Equal(t, int32(0), got["allow"].GetStatus().GetCode())
+	assert.Equal(t, int32(7), got["deny"].GetStatus().GetCode())
+	assert.Nil(t, got["none"])
+}
+
+func Test_policyReconciler_Reconcile_deletionGracePeriod(t *testing.T) {
+	tests := []struct {
+		name         string
+		recreate     bool
+		elapsed      time.Duration
+		wantPolicies []string
+		wantResult   ctrl.Result
+	}{{
+		name:         "within the grace period",
+		elapsed:      20 * time.Second,
+		wantPolicies: []string{"policy"},
+		wantResult:   ctrl.Result{RequeueAfter: 40 * time.Second},
+	}, {
+		name:         "beyond the grace period",
+		elapsed:      time.Minute,
+		wantPolicies: nil,
+		wantResult:   ctrl.Result{},
+	}, {
+		name:         "recreated within the grace period",
+		recreate:     true,
+		elapsed:      20 * time.Second,
+		wantPolicies: []string{"policy"},
+		wantResult:   ctrl.Result{},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			original := newPolicy("policy", "envoy.Allowed().Response()")
+			original.UID = "original"
+			c := newFakeClient(t, original)
+			now := time.Now()
+			r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+			r.deletionGracePeriod = time.Minute
+			r.now = func() time.Time { return now }
+			reconcile(t, r, "policy")
+			before, err := r.CompiledPolicies(ctx)
+			assert.NoError(t, err)
+			assert.NoError(t, c.Delete(ctx, original))
+			request := ctrl.Request{NamespacedName: types.NamespacedName{Name: "policy"}}
+			result, err := r.Reconcile(ctx, request)
+			assert.NoError(t, err)
+			assert.Equal(t, ctrl.Result{RequeueAfter: time.Minute}, result)
+			// the deleted policy is still evaluated, the sorted policies are not computed again
+			after, err := r.CompiledPolicies(ctx)
+			assert.NoError(t, err)
+			assert.Same(t, &before[0], &after[0])
+			now = now.Add(tt.elapsed)
+			if tt.recreate {
+				recreated := newPolicy("policy", "envoy.Denied(403).Response()")
+				recreated.UID = "recreated"
+				assert.NoError(t, c.Create(ctx, recreated))
+			}
+			result, err = r.Reconcile(ctx, request)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantResult, result)
+			policies, err := r.CompiledPolicies(ctx)
+			assert.NoError(t, err)
+			var names []string
+			for _, policy := range policies {
+				names = append(names, policy.Name)
+			}
+			assert.Equal(t, tt.wantPolicies, names)
+			if !tt.recreate {
+				return
+			}
+			// the recreated spec is evaluated
+			response, err := policies[0].Evaluate(ctx, &authv3.CheckRequest{})
+			assert.NoError(t, err)
+			assert.Equal(t, int32(7), response.GetStatus().GetCode())
+			// deleting the recreated policy starts a new grace period
+			assert.NoError(t, c.Delete(ctx, newPolicy("policy")))
+			result, err = r.Reconcile(ctx, request)
+			assert.NoError(t, err)
+			assert.Equal(t, ctrl.Result{RequeueAfter: time.Minute}, result)
+		})
+	}
+}
+
+func Test_policyReconciler_Reconcile_deletionGracePeriodNotCompiled(t *testing.T) {
+	c := newFakeClient(t, newPolicy("policy", "envoy.Allowed()"))
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	r.deletionGracePeriod = time.Minute
+	reconcile(t, r, "policy")
+	assert.NoError(t, c.Delete(context.Background(), newPolicy("policy")))
+	// a policy that isn't evaluated is evicted immediately
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "policy"}})
+	assert.NoError(t, err)
+	assert.Equal(t, ctrl.Result{}, result)
+	assert.Empty(t, r.Inspect())
+}
+
+func Test_policyReconciler_Reconcile_annotations(t *testing.T) {
+	policy := newPolicy("demo", "envoy.Allowed().Response()")
+	policy.Annotations = map[string]string{"owner.example.com/team": "payments"}
+	c := newFakeClient(t, policy)
+	r := newPolicyReconciler(c, NewCompiler(WithAnnotationPrefixes("owner.example.com/")), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	reconcile(t, r, "demo")
+	policies, err := r.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"owner.example.com/team": "payments"}, policies[0].Annotations)
+	// annotations don't change the generation, the policy is compiled again when they change
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "demo"}, policy))
+	policy.Annotations["owner.example.com/team"] = "checkout"
+	assert.NoError(t, c.Update(context.Background(), policy))
+	reconcile(t, r, "demo")
+	policies, err = r.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"owner.example.com/team": "checkout"}, policies[0].Annotations)
+}
+
+func Test_policyReconciler_Reconcile_changes(t *testing.T) {
+	c := newFakeClient(t, newPolicy("policy", "envoy.Allowed().Response()"))
+	recorder := record.NewFakeRecorder(10)
+	var logs []string
+	logger := funcr.New(func(prefix, args string) {
+		logs = append(logs, args)
+	}, funcr.Options{})
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logger, recorder)
+	reconcile(t, r, "policy")
+	assert.Empty(t, drainEvents(recorder))
+	// a status only update is not a change
+	var policy v1alpha1.AuthorizationPolicy
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "policy"}, &policy))
+	policy.Status.Conditions = nil
+	assert.NoError(t, c.Status().Update(context.Background(), &policy))
+	logs = nil
+	reconcile(t, r, "policy")
+	assert.Empty(t, drainEvents(recorder))
+	for _, log := range logs {
+		assert.NotContains(t, log, "policy changed")
+	}
+	// a new generation of the same spec is not a change either
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "policy"}, &policy))
+	policy.Generation = 2
+	assert.NoError(t, c.Update(context.Background(), &policy))
+	logs = nil
+	reconcile(t, r, "policy")
+	assert.Empty(t, drainEvents(recorder))
+	for _, log := range logs {
+		assert.NotContains(t, log, "policy changed")
+	}
+	// a rule change is reported with the changed fields
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "policy"}, &policy))
+	policy.Spec.Authorizations[0].Expression = "envoy.Denied(403).Response()"
+	policy.Spec.Priority = 10
+	policy.Generation = 3
+	assert.NoError(t, c.Update(context.Background(), &policy))
+	logs = nil
+	reconcile(t, r, "policy")
+	assert.Equal(t, []string{"Normal Changed Policy changed: spec.priority, spec.authorizations"}, drainEvents(recorder))
+	assert.Contains(t, strings.Join(logs, "\n"), `"msg"="policy changed" "policy"="/policy" "generation"=3 "fields"=["spec.priority" "spec.authorizations"]`)
+}
@@ -0,0 +1,124 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	eventReasonQuotaExceeded = "QuotaExceeded"
+	// quotaRetryDelay is the delay before checking again the quota of a rejected policy, a slot frees up when an older policy is deleted
+	quotaRetryDelay = time.Minute
+)
+
+// WithPolicyQuota caps the number of policies loaded by the provider, zero means no limit.
+func WithPolicyQuota(max int) KubeProviderOption {
+	return func(o *kubeProviderOptions) {
+		o.maxPolicies = max
+	}
+}
+
+// WithNamespaceQuota caps the number of policies loaded per namespace, zero means no limit. Policies are cluster scoped,
+// the namespace of a policy is the value of the label, policies without the label are only subject to WithPolicyQuota.
+func WithNamespaceQuota(label string, max int) KubeProviderOption {
+	return func(o *kubeProviderOptions) {
+		o.namespaceLabel = label
+		o.namespaceQuota = max
+	}
+}
+
+// policyNamespace returns the namespace of a policy, the value of the namespace label. Policies without the label
+// belong to no namespace.
+func (r *policyReconciler) policyNamespace(policy *v1alpha1.AuthorizationPolicy) string {
+	if r.namespaceLabel == "" {
+		return ""
+	}
+	return policy.Labels[r.namespaceLabel]
+}
+
+// quotaError is returned when loading a policy would exceed a quota
+type quotaError struct {
+	quota     string
+	limit     int
+	namespace string
+}
+
+func (e *quotaError) Error() string {
+	if e.quota == metrics.QuotaNamespace {
+		return fmt.Sprintf("the quota of %d policies for namespace %q is exceeded", e.limit, e.namespace)
+	}
+	return fmt.Sprintf("the quota of %d policies is exceeded", e.limit)
+}
+
+// exceededQuota returns the quota the policy exceeds, if any. Quotas are granted to the oldest policies, ranked by creation
+// timestamp then name, whatever the order policies are reconciled in: a new policy never evicts a loaded policy.
+func (r *policyReconciler) exceededQuota(ctx context.Context, policy *v1alpha1.AuthorizationPolicy) (*quotaError, error) {
+	if r.maxPolicies > 0 {
+		older, err := r.countOlder(ctx, policy, r.selector)
+		if err != nil {
+			return nil, err
+		}
+		if older >= r.maxPolicies {
+			return &quotaError{quota: metrics.QuotaGlobal, limit: r.maxPolicies}, nil
+		}
+	}
+	namespace, ok := policy.Labels[r.namespaceLabel]
+	if r.namespaceQuota <= 0 || !ok {
+		return nil, nil
+	}
+	requirement, err := labels.NewRequirement(r.namespaceLabel, selection.Equals, []string{namespace})
+	if err != nil {
+		return nil, err
+	}
+	older, err := r.countOlder(ctx, policy, r.selector.Add(*requirement))
+	if err != nil {
+		return nil, err
+	}
+	if older >= r.namespaceQuota {
+		return &quotaError{quota: metrics.QuotaNamespace, limit: r.namespaceQuota, namespace: namespace}, nil
+	}
+	return nil, nil
+}
+
+// countOlder returns the number of policies matching the selector older than the policy, policies failing to compile count too
+func (r *policyReconciler) countOlder(ctx context.Context, policy *v1alpha1.AuthorizationPolicy, selector labels.Selector) (int, error) {
+	var list v1alpha1.AuthorizationPolicyList
+	// the policies are only compared, no need to copy them out of the cache
+	if err := r.client.List(ctx, &list, client.MatchingLabelsSelector{Selector: selector}, client.UnsafeDisableDeepCopy); err != nil {
+		return 0, fmt.Errorf("failed to list policies: %w", err)
+	}
+	older := 0
+	for i := range list.Items {
+		item := &list.Items[i]
+		if item.CreationTimestamp.Before(&policy.CreationTimestamp) || (item.CreationTimestamp.Equal(&policy.CreationTimestamp) && item.Name < policy.Name) {
+			older++
+		}
+	}
+	return older, nil
+}
+
+// reject unloads the policy, it counts the rejection if the policy wasn't rejected already
+func (r *policyReconciler) reject(key types.NamespacedName, err *quotaError) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	// a loaded policy is rejected when it moves to a namespace whose quota is exceeded
+	if loaded, ok := r.policies[key]; ok {
+		r.metrics.ForgetPolicyDecisions(loaded.Namespace, key.Name)
+		delete(r.policies, key)
+		delete(r.versions, key)
+		delete(r.bindings, key)
+		r.resetSortPolicies()
+		r.compileCache.Release(key.Name)
+	}
+	if !r.statuses[key].Rejected {
+		r.metrics.RecordQuotaRejection(err.quota)
+	}
+}
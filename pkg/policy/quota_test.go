@@ -0,0 +1,169 @@
+package policy
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// newQuotaPolicy returns a policy created age minutes ago in the namespace, no namespace label if empty
+func newQuotaPolicy(name, namespace string, age int) *v1alpha1.AuthorizationPolicy {
+	policy := newPolicy(name, "envoy.Allowed().Response()")
+	policy.CreationTimestamp = metav1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Add(-time.Duration(age) * time.Minute))
+	if namespace != "" {
+		policy.Labels = map[string]string{"envoy.kyverno.io/namespace": namespace}
+	}
+	return policy
+}
+
+func reconcileQuota(t *testing.T, r *policyReconciler, name string) ctrl.Result {
+	t.Helper()
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: name}})
+	assert.NoError(t, err)
+	return result
+}
+
+func loadedPolicies(t *testing.T, r *policyReconciler) []string {
+	t.Helper()
+	policies, err := r.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	var names []string
+	for _, policy := range policies {
+		names = append(names, policy.Name)
+	}
+	return names
+}
+
+func assertRejected(t *testing.T, c client.Client, name, message string) {
+	t.Helper()
+	var policy v1alpha1.AuthorizationPolicy
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: name}, &policy))
+	condition := meta.FindStatusCondition(policy.Status.Conditions, v1alpha1.ConditionReady)
+	assert.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Equal(t, v1alpha1.ReasonQuotaExceeded, condition.Reason)
+	assert.Equal(t, message, condition.Message)
+}
+
+func Test_policyReconciler_quota(t *testing.T) {
+	c := newFakeClient(t, newQuotaPolicy("a", "", 3), newQuotaPolicy("b", "", 2), newQuotaPolicy("c", "", 1))
+	recorder := record.NewFakeRecorder(10)
+	registry := prometheus.NewRegistry()
+	m, err := metrics.New(registry)
+	assert.NoError(t, err)
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), recorder)
+	r.metrics = m
+	r.maxPolicies = 2
+	// the newest policy is rejected even when it is reconciled first
+	for _, name := range []string{"c", "b", "a"} {
+		reconcileQuota(t, r, name)
+	}
+	assert.Equal(t, []string{"a", "b"}, loadedPolicies(t, r))
+	assertRejected(t, c, "c", "the quota of 2 policies is exceeded")
+	assert.Equal(t, []string{"Warning QuotaExceeded the quota of 2 policies is exceeded"}, drainEvents(recorder))
+	statuses := r.Inspect()
+	assert.Len(t, statuses, 3)
+	assert.True(t, statuses[2].Rejected)
+	assert.False(t, statuses[2].Active)
+	// the rejected policy is checked again later, the rejection is recorded once
+	assert.Equal(t, ctrl.Result{RequeueAfter: quotaRetryDelay}, reconcileQuota(t, r, "c"))
+	assert.Empty(t, drainEvents(recorder))
+	expected := `
+# HELP policy_quota_rejections_total Number of policies rejected because they exceed a quota, partitioned by quota (global or namespace).
+# TYPE policy_quota_rejections_total counter
+policy_quota_rejections_total{quota="global"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "policy_quota_rejections_total"))
+	// deleting an older policy frees up a slot
+	assert.NoError(t, c.Delete(context.Background(), newQuotaPolicy("a", "", 3)))
+	reconcileQuota(t, r, "a")
+	assert.Equal(t, ctrl.Result{}, reconcileQuota(t, r, "c"))
+	assert.Equal(t, []string{"b", "c"}, loadedPolicies(t, r))
+}
+
+func Test_policyReconciler_namespaceQuota(t *testing.T) {
+	c := newFakeClient(t,
+		newQuotaPolicy("a-1", "team-a", 3),
+		newQuotaPolicy("a-2", "team-a", 2),
+		newQuotaPolicy("a-3", "team-a", 1),
+		newQuotaPolicy("b-1", "team-b", 1),
+		newQuotaPolicy("shared", "", 0),
+	)
+	registry := prometheus.NewRegistry()
+	m, err := metrics.New(registry)
+	assert.NoError(t, err)
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	r.metrics = m
+	r.namespaceLabel = "envoy.kyverno.io/namespace"
+	r.namespaceQuota = 2
+	for _, name := range []string{"a-3", "a-2", "a-1", "b-1", "shared"} {
+		reconcileQuota(t, r, name)
+	}
+	// other namespaces and policies without namespace are not affected
+	assert.Equal(t, []string{"a-1", "a-2", "b-1", "shared"}, loadedPolicies(t, r))
+	assertRejected(t, c, "a-3", `the quota of 2 policies for namespace "team-a" is exceeded`)
+	// a loaded policy moving to a namespace whose quota is exceeded is rejected
+	var policy v1alpha1.AuthorizationPolicy
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "b-1"}, &policy))
+	policy.Labels["envoy.kyverno.io/namespace"] = "team-a"
+	assert.NoError(t, c.Update(context.Background(), &policy))
+	reconcileQuota(t, r, "b-1")
+	assert.Equal(t, []string{"a-1", "a-2", "shared"}, loadedPolicies(t, r))
+	assertRejected(t, c, "b-1", `the quota of 2 policies for namespace "team-a" is exceeded`)
+	expected := `
+# HELP policy_quota_rejections_total Number of policies rejected because they exceed a quota, partitioned by quota (global or namespace).
+# TYPE policy_quota_rejections_total counter
+policy_quota_rejections_total{quota="namespace"} 2
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "policy_quota_rejections_total"))
+}
+
+func Test_policyReconciler_policyNamespace(t *testing.T) {
+	c := newFakeClient(t,
+		newQuotaPolicy("a-1", "team-a", 1),
+		newQuotaPolicy("shared", "", 0),
+	)
+	registry := prometheus.NewRegistry()
+	m, err := metrics.New(registry)
+	assert.NoError(t, err)
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	r.metrics = m
+	r.namespaceLabel = "envoy.kyverno.io/namespace"
+	namespaces := func() map[string]string {
+		policies, err := r.CompiledPolicies(context.Background())
+		assert.NoError(t, err)
+		out := map[string]string{}
+		for _, policy := range policies {
+			out[policy.Name] = policy.Namespace
+		}
+		return out
+	}
+	reconcileQuota(t, r, "a-1")
+	reconcileQuota(t, r, "shared")
+	// the namespace is the label value, without quota
+	assert.Equal(t, map[string]string{"a-1": "team-a", "shared": ""}, namespaces())
+	m.RecordPolicyDecision("team-a", "a-1", metrics.DecisionAllow)
+	// a policy moving to another namespace is compiled again, its decisions are recorded in a new series
+	var policy v1alpha1.AuthorizationPolicy
+	assert.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "a-1"}, &policy))
+	policy.Labels["envoy.kyverno.io/namespace"] = "team-b"
+	assert.NoError(t, c.Update(context.Background(), &policy))
+	reconcileQuota(t, r, "a-1")
+	assert.Equal(t, map[string]string{"a-1": "team-b", "shared": ""}, namespaces())
+	assert.Equal(t, 0, testutil.CollectAndCount(registry, "policy_decisions_total"))
+}
@@ -0,0 +1,47 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// defaultRetryBaseDelay is the delay before retrying a policy the first time it failed to reconcile
+	defaultRetryBaseDelay = 5 * time.Millisecond
+	// defaultRetryMaxDelay caps the delay between retries of a policy failing to reconcile
+	defaultRetryMaxDelay = 5 * time.Minute
+)
+
+// newRateLimiter returns a rate limiter doubling the delay between retries of a policy,
+// from base up to max, the delay is reset once the policy reconciles successfully
+func newRateLimiter(base, max time.Duration) workqueue.TypedRateLimiter[ctrl.Request] {
+	return workqueue.NewTypedItemExponentialFailureRateLimiter[ctrl.Request](base, max)
+}
+
+// permanent returns true if retrying can't succeed, the request or the object is malformed.
+// Other errors (timeouts, conflicts, throttling, unavailable api server...) are transient.
+func permanent(err error) bool {
+	switch {
+	case apierrors.IsBadRequest(err),
+		apierrors.IsInvalid(err),
+		apierrors.IsNotAcceptable(err),
+		apierrors.IsUnsupportedMediaType(err),
+		apierrors.IsMethodNotSupported(err),
+		apierrors.IsRequestEntityTooLargeError(err):
+		return true
+	case runtime.IsNotRegisteredError(err),
+		runtime.IsMissingKind(err),
+		runtime.IsMissingVersion(err),
+		runtime.IsStrictDecodingError(err):
+		return true
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return errors.As(err, &syntaxErr) || errors.As(err, &typeErr)
+}
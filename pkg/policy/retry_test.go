@@ -0,0 +1,163 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	ctrlreconcile "sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func Test_permanent(t *testing.T) {
+	var syntaxErr error = &json.SyntaxError{}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{{
+		name: "timeout",
+		err:  apierrors.NewTimeoutError("timeout", 1),
+	}, {
+		name: "server timeout",
+		err:  apierrors.NewServerTimeout(policiesResource, "get", 1),
+	}, {
+		name: "conflict",
+		err:  apierrors.NewConflict(policiesResource, "demo", errors.New("conflict")),
+	}, {
+		name: "too many requests",
+		err:  apierrors.NewTooManyRequests("throttled", 1),
+	}, {
+		name: "service unavailable",
+		err:  apierrors.NewServiceUnavailable("unavailable"),
+	}, {
+		name: "network",
+		err:  errors.New("connection refused"),
+	}, {
+		name: "bad request",
+		err:  apierrors.NewBadRequest("malformed"),
+		want: true,
+	}, {
+		name: "invalid",
+		err:  apierrors.NewInvalid(v1alpha1.SchemeGroupVersion.WithKind("AuthorizationPolicy").GroupKind(), "demo", field.ErrorList{field.Required(field.NewPath("spec"), "")}),
+		want: true,
+	}, {
+		name: "not registered",
+		err:  runtime.NewNotRegisteredErrForKind("test", schema.GroupVersionKind{Kind: "Unknown"}),
+		want: true,
+	}, {
+		name: "decoding",
+		err:  fmt.Errorf("failed to decode: %w", syntaxErr),
+		want: true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, permanent(tt.err))
+		})
+	}
+}
+
+func Test_newRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(10*time.Millisecond, 50*time.Millisecond)
+	request := ctrl.Request{NamespacedName: types.NamespacedName{Name: "demo"}}
+	var got []time.Duration
+	for range 5 {
+		got = append(got, limiter.When(request))
+	}
+	assert.Equal(t, []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond}, got)
+	assert.Equal(t, 5, limiter.NumRequeues(request))
+	// the backoff is reset once the policy reconciled
+	limiter.Forget(request)
+	assert.Equal(t, 10*time.Millisecond, limiter.When(request))
+}
+
+func newFailingClient(t *testing.T, get func(attempt int) error, objects ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	assert.NoError(t, v1alpha1.Install(scheme))
+	attempt := 0
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objects...).
+		WithStatusSubresource(&v1alpha1.AuthorizationPolicy{}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				attempt++
+				if err := get(attempt); err != nil {
+					return err
+				}
+				return c.Get(ctx, key, obj, opts...)
+			},
+		}).
+		Build()
+}
+
+func Test_policyReconciler_Reconcile_transient(t *testing.T) {
+	// the api server times out twice, conflicts once, then recovers
+	failures := []error{
+		apierrors.NewTimeoutError("timeout", 1),
+		apierrors.NewServerTimeout(policiesResource, "get", 1),
+		apierrors.NewConflict(policiesResource, "demo", errors.New("conflict")),
+	}
+	c := newFailingClient(t, func(attempt int) error {
+		if attempt <= len(failures) {
+			return failures[attempt-1]
+		}
+		return nil
+	}, newPolicy("demo", "envoy.Allowed().Response()"))
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	assert.NoError(t, r.sync(context.Background()))
+	assert.False(t, r.HasSynced())
+	limiter := newRateLimiter(time.Millisecond, time.Second)
+	request := ctrl.Request{NamespacedName: types.NamespacedName{Name: "demo"}}
+	// retry until the policy reconciles, like the controller does
+	var delays []time.Duration
+	for {
+		_, err := r.Reconcile(context.Background(), request)
+		if err == nil {
+			limiter.Forget(request)
+			break
+		}
+		assert.False(t, errors.Is(err, ctrlreconcile.TerminalError(nil)))
+		// the policy holds the sync until it reconciled
+		assert.False(t, r.HasSynced())
+		delays = append(delays, limiter.When(request))
+	}
+	assert.Equal(t, []time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond}, delays)
+	assert.True(t, r.HasSynced())
+	policies, err := r.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, policies, 1)
+}
+
+func Test_policyReconciler_Reconcile_permanent(t *testing.T) {
+	c := newFailingClient(t, func(int) error {
+		return apierrors.NewBadRequest("malformed policy")
+	}, newPolicy("demo", "envoy.Allowed().Response()"))
+	r := newPolicyReconciler(c, NewCompiler(), labels.Everything(), logr.Discard(), record.NewFakeRecorder(10))
+	assert.NoError(t, r.sync(context.Background()))
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "demo"}})
+	// the controller doesn't requeue terminal errors
+	assert.True(t, errors.Is(err, ctrlreconcile.TerminalError(nil)))
+	// the policy is dropped, it doesn't hold the sync
+	assert.True(t, r.HasSynced())
+	policies, err := r.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, policies)
+}
@@ -0,0 +1,114 @@
+package policy
+
+import (
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const policyKind = "AuthorizationPolicy"
+
+// validateShape checks that a policy fetched from the API server has the shape the compiler expects. The API server
+// validates policies against the installed CRD, a CRD older or newer than the server, during an upgrade for example,
+// can let through policies missing fields the compiler relies on.
+func validateShape(policy *v1alpha1.AuthorizationPolicy) field.ErrorList {
+	var errs field.ErrorList
+	// objects read from the cache usually have no type meta, it is only checked when set
+	if apiVersion := policy.APIVersion; apiVersion != "" && apiVersion != v1alpha1.SchemeGroupVersion.String() {
+		errs = append(errs, field.Invalid(field.NewPath("apiVersion"), apiVersion, "expected "+v1alpha1.SchemeGroupVersion.String()))
+	}
+	if kind := policy.Kind; kind != "" && kind != policyKind {
+		errs = append(errs, field.Invalid(field.NewPath("kind"), kind, "expected "+policyKind))
+	}
+	spec := &policy.Spec
+	path := field.NewPath("spec")
+	for i, authorization := range spec.Authorizations {
+		if authorization.Expression == "" {
+			errs = append(errs, field.Required(path.Child("authorizations").Index(i).Child("expression"), ""))
+		}
+	}
+	errs = append(errs, validateConditionsShape(path.Child("targetConditions"), spec.TargetConditions)...)
+	errs = append(errs, validateConditionsShape(path.Child("matchConditions"), spec.MatchConditions)...)
+	errs = append(errs, validateConditionsShape(path.Child("excludeConditions"), spec.ExcludeConditions)...)
+	for i, variable := range spec.Variables {
+		path := path.Child("variables").Index(i)
+		if variable.Name == "" {
+			errs = append(errs, field.Required(path.Child("name"), ""))
+		}
+		if variable.Expression == "" {
+			errs = append(errs, field.Required(path.Child("expression"), ""))
+		}
+	}
+	for i, source := range spec.Data {
+		path := path.Child("data").Index(i)
+		if source.Name == "" {
+			errs = append(errs, field.Required(path.Child("name"), ""))
+		}
+		switch {
+		case source.ConfigMap == nil && source.Secret == nil:
+			errs = append(errs, field.Required(path, "exactly one of configMap or secret is required"))
+		case source.ConfigMap != nil && source.Secret != nil:
+			errs = append(errs, field.Invalid(path, source.Name, "exactly one of configMap or secret is required"))
+		case source.ConfigMap != nil:
+			errs = append(errs, validateReferenceShape(path.Child("configMap"), source.ConfigMap)...)
+		default:
+			errs = append(errs, validateReferenceShape(path.Child("secret"), source.Secret)...)
+		}
+	}
+	if spec.Headers != nil {
+		errs = append(errs, validateMutationsShape(path.Child("headers", "request"), spec.Headers.Request)...)
+		errs = append(errs, validateMutationsShape(path.Child("headers", "response"), spec.Headers.Response)...)
+	}
+	if spec.DenyResponse != nil {
+		errs = append(errs, validateMutationsShape(path.Child("denyResponse", "headers"), spec.DenyResponse.Headers)...)
+	}
+	if spec.Cache != nil && spec.Cache.Key == "" {
+		errs = append(errs, field.Required(path.Child("cache", "key"), ""))
+	}
+	if spec.Rollout != nil && spec.Rollout.Key == "" {
+		errs = append(errs, field.Required(path.Child("rollout", "key"), ""))
+	}
+	if spec.RateLimit != nil && spec.RateLimit.Entries == "" {
+		errs = append(errs, field.Required(path.Child("rateLimit", "entries"), ""))
+	}
+	return errs
+}
+
+func validateConditionsShape(path *field.Path, conditions []admissionregistrationv1.MatchCondition) field.ErrorList {
+	var errs field.ErrorList
+	for i, condition := range conditions {
+		path := path.Index(i)
+		if condition.Name == "" {
+			errs = append(errs, field.Required(path.Child("name"), ""))
+		}
+		if condition.Expression == "" {
+			errs = append(errs, field.Required(path.Child("expression"), ""))
+		}
+	}
+	return errs
+}
+
+func validateReferenceShape(path *field.Path, reference *v1alpha1.DataSourceReference) field.ErrorList {
+	var errs field.ErrorList
+	if reference.Namespace == "" {
+		errs = append(errs, field.Required(path.Child("namespace"), ""))
+	}
+	if reference.Name == "" {
+		errs = append(errs, field.Required(path.Child("name"), ""))
+	}
+	return errs
+}
+
+func validateMutationsShape(path *field.Path, mutations []v1alpha1.HeaderMutation) field.ErrorList {
+	var errs field.ErrorList
+	for i, mutation := range mutations {
+		path := path.Index(i)
+		if mutation.Name == "" {
+			errs = append(errs, field.Required(path.Child("name"), ""))
+		}
+		if mutation.Action != v1alpha1.HeaderActionRemove && mutation.Expression == "" {
+			errs = append(errs, field.Required(path.Child("expression"), "expression is required unless the action is Remove"))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,155 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/metrics"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+)
+
+// defaultCacheSyncTimeout is the maximum time to wait for the manager cache to sync
+const defaultCacheSyncTimeout = 2 * time.Minute
+
+// policiesResource is the resource watched by the provider
+var policiesResource = v1alpha1.SchemeGroupVersion.WithResource("authorizationpolicies").GroupResource()
+
+// CacheSyncer is implemented by providers loading policies through a cache
+type CacheSyncer interface {
+	// WaitForCacheSync blocks until the cache synced, it fails with a descriptive error when the cache
+	// can't sync instead of blocking forever
+	WaitForCacheSync(context.Context) error
+}
+
+// WatchStatus describes the errors watching policies. The informer lists all policies again after a watch error,
+// the policies that changed meanwhile are reconciled once the list completes.
+type WatchStatus struct {
+	// Errors is the number of watch errors since the server started
+	Errors int `json:"errors"`
+	// LastError is the last watch error, if any
+	LastError string `json:"lastError,omitempty"`
+	// LastErrorTime is the time of the last watch error, if any
+	LastErrorTime *time.Time `json:"lastErrorTime,omitempty"`
+}
+
+// WatchReporter is implemented by providers watching policies
+type WatchReporter interface {
+	// WatchStatus returns the errors watching policies
+	WatchStatus() WatchStatus
+}
+
+// syncWatcher records the errors of the policies informer while the cache syncs. The informer retries
+// failed list and watch calls forever, authorization errors won't go away on their own so they make
+// the sync fail immediately, other errors are reported if the cache didn't sync before the timeout.
+// Errors are still recorded once the cache synced, they are reported by the watch status.
+type syncWatcher struct {
+	cache    cache.Cache
+	timeout  time.Duration
+	metrics  *metrics.Metrics
+	lock     sync.Mutex
+	last     error
+	lastTime time.Time
+	count    int
+	fatal    error
+	done     chan struct{}
+	now      func() time.Time
+}
+
+func newSyncWatcher(cache cache.Cache, timeout time.Duration, metrics *metrics.Metrics) *syncWatcher {
+	return &syncWatcher{cache: cache, timeout: timeout, metrics: metrics, done: make(chan struct{}), now: time.Now}
+}
+
+// handle is the watch error handler of the policies informer
+func (w *syncWatcher) handle(r *toolscache.Reflector, err error) {
+	toolscache.DefaultWatchErrorHandler(r, err)
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.last = err
+	w.lastTime = w.now()
+	w.count++
+	w.metrics.RecordWatchError()
+	if w.fatal == nil && (apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err)) {
+		w.fatal = err
+		close(w.done)
+	}
+}
+
+func (w *syncWatcher) errors() (last error, fatal error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.last, w.fatal
+}
+
+func (w *syncWatcher) status() WatchStatus {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	status := WatchStatus{Errors: w.count}
+	if w.last != nil {
+		status.LastError = w.last.Error()
+		status.LastErrorTime = &w.lastTime
+	}
+	return status
+}
+
+// watch registers the error handler on the policies informer, it must be called before the cache starts
+func (w *syncWatcher) watch(informer cache.Informer) error {
+	setter, ok := informer.(interface {
+		SetWatchErrorHandler(toolscache.WatchErrorHandler) error
+	})
+	if !ok {
+		return fmt.Errorf("the %s informer doesn't support watch error handlers", policiesResource)
+	}
+	return setter.SetWatchErrorHandler(w.handle)
+}
+
+// waitForCacheSync waits for the cache to sync
+func (w *syncWatcher) waitForCacheSync(ctx context.Context) error {
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if w.timeout > 0 {
+		waitCtx, cancel = context.WithTimeout(ctx, w.timeout)
+		defer cancel()
+	}
+	synced := make(chan bool, 1)
+	go func() {
+		synced <- w.cache.WaitForCacheSync(waitCtx)
+	}()
+	select {
+	case ok := <-synced:
+		if ok {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("failed to wait for cache sync: %w", err)
+		}
+		last, _ := w.errors()
+		if last == nil {
+			return fmt.Errorf("timed out after %s waiting for the cache to sync", w.timeout)
+		}
+		return fmt.Errorf("timed out after %s waiting for the cache to sync, failed to watch %s: %w", w.timeout, policiesResource, last)
+	case <-w.done:
+		_, fatal := w.errors()
+		return fmt.Errorf("failed to watch %s, check the permissions granted to the service account: %w", policiesResource, fatal)
+	}
+}
+
+// WaitForCacheSync blocks until the manager cache synced, the manager itself can't stop until it did
+func (r *policyReconciler) WaitForCacheSync(ctx context.Context) error {
+	if r.syncWatcher == nil {
+		return nil
+	}
+	return r.syncWatcher.waitForCacheSync(ctx)
+}
+
+// WatchStatus returns the errors watching policies
+func (r *policyReconciler) WatchStatus() WatchStatus {
+	if r.syncWatcher == nil {
+		return WatchStatus{}
+	}
+	return r.syncWatcher.status()
+}
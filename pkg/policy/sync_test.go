@@ -0,0 +1,140 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/config"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+)
+
+// newAPIServer serves the discovery of the policies api, listing policies returns the given error status
+func newAPIServer(t *testing.T, status *apierrors.StatusError) *httptest.Server {
+	t.Helper()
+	write := func(w http.ResponseWriter, code int, body any) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		assert.NoError(t, json.NewEncoder(w).Encode(body))
+	}
+	version := v1alpha1.SchemeGroupVersion.String()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api", func(w http.ResponseWriter, _ *http.Request) {
+		write(w, http.StatusOK, &metav1.APIVersions{TypeMeta: metav1.TypeMeta{Kind: "APIVersions"}, Versions: []string{"v1"}})
+	})
+	mux.HandleFunc("/apis", func(w http.ResponseWriter, _ *http.Request) {
+		groupVersion := metav1.GroupVersionForDiscovery{GroupVersion: version, Version: v1alpha1.SchemeGroupVersion.Version}
+		write(w, http.StatusOK, &metav1.APIGroupList{
+			TypeMeta: metav1.TypeMeta{Kind: "APIGroupList", APIVersion: "v1"},
+			Groups: []metav1.APIGroup{{
+				Name:             v1alpha1.SchemeGroupVersion.Group,
+				Versions:         []metav1.GroupVersionForDiscovery{groupVersion},
+				PreferredVersion: groupVersion,
+			}},
+		})
+	})
+	mux.HandleFunc("/apis/"+version, func(w http.ResponseWriter, _ *http.Request) {
+		write(w, http.StatusOK, &metav1.APIResourceList{
+			TypeMeta:     metav1.TypeMeta{Kind: "APIResourceList", APIVersion: "v1"},
+			GroupVersion: version,
+			APIResources: []metav1.APIResource{{
+				Name:         policiesResource.Resource,
+				SingularName: "authorizationpolicy",
+				Kind:         "AuthorizationPolicy",
+				Verbs:        metav1.Verbs{"get", "list", "watch"},
+			}},
+		})
+	})
+	mux.HandleFunc("/apis/"+version+"/"+policiesResource.Resource, func(w http.ResponseWriter, _ *http.Request) {
+		body := status.Status()
+		body.TypeMeta = metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}
+		write(w, int(body.Code), &body)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNewKubeProvider_cacheSyncFailure(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  *apierrors.StatusError
+		timeout time.Duration
+		wantErr string
+	}{{
+		name:    "forbidden",
+		status:  apierrors.NewForbidden(policiesResource, "", nil),
+		wantErr: "failed to watch authorizationpolicies.envoy.kyverno.io, check the permissions granted to the service account",
+	}, {
+		name:    "unauthorized",
+		status:  apierrors.NewUnauthorized("invalid token"),
+		wantErr: "failed to watch authorizationpolicies.envoy.kyverno.io, check the permissions granted to the service account",
+	}, {
+		name:    "timeout",
+		status:  apierrors.NewInternalError(assert.AnError),
+		timeout: 500 * time.Millisecond,
+		wantErr: "timed out after 500ms waiting for the cache to sync, failed to watch authorizationpolicies.envoy.kyverno.io",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newAPIServer(t, tt.status)
+			scheme := runtime.NewScheme()
+			assert.NoError(t, v1alpha1.Install(scheme))
+			mgr, err := ctrl.NewManager(&rest.Config{Host: server.URL}, ctrl.Options{
+				Scheme:  scheme,
+				Metrics: metricsserver.Options{BindAddress: "0"},
+				// every test case registers the same controller
+				Controller: config.Controller{SkipNameValidation: ptr.To(true)},
+			})
+			assert.NoError(t, err)
+			opts := []KubeProviderOption{}
+			if tt.timeout != 0 {
+				opts = append(opts, WithCacheSyncTimeout(tt.timeout))
+			}
+			provider, err := NewKubeProvider(mgr, NewCompiler(), opts...)
+			assert.NoError(t, err)
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			// the manager doesn't start its runnables until the cache synced, only the cache is needed
+			cacheErr := make(chan error, 1)
+			go func() {
+				cacheErr <- mgr.GetCache().Start(ctx)
+			}()
+			// waiting for the cache fails instead of blocking forever
+			err = provider.(CacheSyncer).WaitForCacheSync(ctx)
+			assert.ErrorContains(t, err, tt.wantErr)
+			assert.NoError(t, ctx.Err())
+			assert.False(t, provider.HasSynced())
+			// the errors are reported by the watch status
+			watch := provider.(WatchReporter).WatchStatus()
+			assert.Positive(t, watch.Errors)
+			assert.NotEmpty(t, watch.LastError)
+			assert.NotNil(t, watch.LastErrorTime)
+			cancel()
+			assert.NoError(t, <-cacheErr)
+		})
+	}
+}
+
+func TestNewKubeProvider_invalidCacheSyncTimeout(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, v1alpha1.Install(scheme))
+	mgr, err := ctrl.NewManager(&rest.Config{Host: "http://127.0.0.1:0"}, ctrl.Options{
+		Scheme:  scheme,
+		Metrics: metricsserver.Options{BindAddress: "0"},
+	})
+	assert.NoError(t, err)
+	_, err = NewKubeProvider(mgr, NewCompiler(), WithCacheSyncTimeout(-time.Second))
+	assert.ErrorContains(t, err, "invalid cache sync timeout")
+}
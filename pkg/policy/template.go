@@ -0,0 +1,110 @@
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// Template renders policy manifests with parameters before they are decoded, it parameterizes any field of
+// the manifests and not only CEL expressions. Values are plain strings, they must not contain control characters
+// so that a value can't add lines to a manifest. A nil Template leaves the manifests untouched.
+type Template struct {
+	values map[string]string
+}
+
+// NewTemplate returns a template rendering manifests with the values, names must be C identifiers
+func NewTemplate(values map[string]string) (*Template, error) {
+	for name, value := range values {
+		if errs := validation.IsCIdentifier(name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid template value name %q: %s", name, strings.Join(errs, ", "))
+		}
+		if strings.ContainsFunc(value, unicode.IsControl) {
+			return nil, fmt.Errorf("invalid template value %q, it must not contain control characters", name)
+		}
+	}
+	return &Template{values: maps.Clone(values)}, nil
+}
+
+// LoadTemplateValues reads the values of a yaml file mapping names to scalar values, and overrides them
+// with the environment variables starting with the prefix, the prefix is removed from their names.
+// The file is optional when path is empty, environment variables are ignored when the prefix is empty.
+func LoadTemplateValues(path string, envPrefix string, environ []string) (map[string]string, error) {
+	values := map[string]string{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var raw map[string]any
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("failed to parse template values %s: %w", path, err)
+		}
+		for name, value := range raw {
+			switch value := value.(type) {
+			case string:
+				values[name] = value
+			case bool, float64:
+				values[name] = fmt.Sprint(value)
+			default:
+				return nil, fmt.Errorf("invalid template value %q in %s, it must be a string, a number or a boolean", name, path)
+			}
+		}
+	}
+	if envPrefix != "" {
+		for _, variable := range environ {
+			name, value, _ := strings.Cut(variable, "=")
+			if name, ok := strings.CutPrefix(name, envPrefix); ok && name != "" {
+				values[name] = value
+			}
+		}
+	}
+	return values, nil
+}
+
+// render executes the manifests as a Go template, referencing a missing value is an error unless a default is given
+func (t *Template) render(name string, r io.Reader) (io.Reader, error) {
+	if t == nil {
+		return r, nil
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(template.FuncMap{
+		"value": t.value,
+		"quote": strconv.Quote,
+	}).Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, t.values); err != nil {
+		return nil, fmt.Errorf("failed to render template %s: %w", name, err)
+	}
+	return &out, nil
+}
+
+// value returns the named value, or the default when the value is missing and a default is given
+func (t *Template) value(name string, defaults ...string) (string, error) {
+	if value, ok := t.values[name]; ok {
+		return value, nil
+	}
+	switch len(defaults) {
+	case 0:
+		return "", fmt.Errorf("missing value %q", name)
+	case 1:
+		return defaults[0], nil
+	default:
+		return "", fmt.Errorf("value %q has more than one default", name)
+	}
+}
@@ -0,0 +1,197 @@
+package policy
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+)
+
+const templatedPolicy = `
+apiVersion: envoy.kyverno.io/v1alpha1
+kind: AuthorizationPolicy
+metadata:
+  name: {{ .cluster }}-hosts
+spec:
+  failurePolicy: {{ value "failurePolicy" "Fail" }}
+  authorizations:
+  - expression: >
+      object.attributes.request.http.host == {{ quote .domain }}
+        ? envoy.Allowed().Response()
+        : envoy.Denied(403).Response()
+`
+
+func TestTemplate_render(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		values   map[string]string
+		want     string
+		wantErr  string
+	}{{
+		name:     "value",
+		template: `name: {{ .cluster }}`,
+		values:   map[string]string{"cluster": "prod"},
+		want:     `name: prod`,
+	}, {
+		name:     "value function",
+		template: `name: {{ value "cluster" }}`,
+		values:   map[string]string{"cluster": "prod"},
+		want:     `name: prod`,
+	}, {
+		name:     "quoted value",
+		template: `expression: host == {{ quote .domain }}`,
+		values:   map[string]string{"domain": `example.com" || true || "`},
+		want:     `expression: host == "example.com\" || true || \""`,
+	}, {
+		name:     "missing value",
+		template: `name: {{ .cluster }}`,
+		wantErr:  `map has no entry for key "cluster"`,
+	}, {
+		name:     "missing value function",
+		template: `name: {{ value "cluster" }}`,
+		wantErr:  `missing value "cluster"`,
+	}, {
+		name:     "default",
+		template: `name: {{ value "cluster" "dev" }}`,
+		want:     `name: dev`,
+	}, {
+		name:     "value overrides the default",
+		template: `name: {{ value "cluster" "dev" }}`,
+		values:   map[string]string{"cluster": "prod"},
+		want:     `name: prod`,
+	}, {
+		name:     "more than one default",
+		template: `name: {{ value "cluster" "dev" "test" }}`,
+		wantErr:  `value "cluster" has more than one default`,
+	}, {
+		name:     "invalid template",
+		template: `name: {{ .cluster`,
+		wantErr:  "failed to parse template policy.yaml",
+	}, {
+		name:     "no action",
+		template: allowPolicy,
+		want:     allowPolicy,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template, err := NewTemplate(tt.values)
+			assert.NoError(t, err)
+			rendered, err := template.render("policy.yaml", strings.NewReader(tt.template))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			got, err := io.ReadAll(rendered)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+	// a nil template leaves the manifests untouched
+	var template *Template
+	rendered, err := template.render("policy.yaml", strings.NewReader(`name: {{ .cluster }}`))
+	assert.NoError(t, err)
+	got, err := io.ReadAll(rendered)
+	assert.NoError(t, err)
+	assert.Equal(t, `name: {{ .cluster }}`, string(got))
+}
+
+func TestNewTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  map[string]string
+		wantErr string
+	}{{
+		name:   "valid",
+		values: map[string]string{"cluster": "prod", "allowed_domain": "example.com"},
+	}, {
+		name:    "invalid name",
+		values:  map[string]string{"allowed-domain": "example.com"},
+		wantErr: `invalid template value name "allowed-domain"`,
+	}, {
+		name:    "new line",
+		values:  map[string]string{"cluster": "prod\n  failurePolicy: Ignore"},
+		wantErr: `invalid template value "cluster", it must not contain control characters`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewTemplate(tt.values)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestLoadTemplateValues(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "values.yaml", "cluster: prod\nreplicas: 3\nstrict: true\n")
+	environ := []string{"AUTHZ_cluster=staging", "AUTHZ_domain=example.com", "AUTHZ_=ignored", "HOME=/root"}
+	// environment variables override the file
+	values, err := LoadTemplateValues(path, "AUTHZ_", environ)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"cluster": "staging", "replicas": "3", "strict": "true", "domain": "example.com"}, values)
+	// both sources are optional
+	values, err = LoadTemplateValues(path, "", environ)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"cluster": "prod", "replicas": "3", "strict": "true"}, values)
+	values, err = LoadTemplateValues("", "AUTHZ_", environ)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"cluster": "staging", "domain": "example.com"}, values)
+	// values must be scalars
+	_, err = LoadTemplateValues(writeFile(t, dir, "nested.yaml", "cluster:\n  name: prod\n"), "", nil)
+	assert.ErrorContains(t, err, `invalid template value "cluster"`)
+	_, err = LoadTemplateValues(writeFile(t, dir, "invalid.yaml", "cluster: [prod"), "", nil)
+	assert.ErrorContains(t, err, "failed to parse template values")
+	_, err = LoadTemplateValues(dir+"/missing.yaml", "", nil)
+	assert.Error(t, err)
+}
+
+func TestNewFileProvider_template(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "policy.yaml", templatedPolicy)
+	template, err := NewTemplate(map[string]string{"cluster": "prod", "domain": "shop.example.com"})
+	assert.NoError(t, err)
+	// the rendered policy compiles
+	provider, err := NewFileProvider(NewCompiler(), template, false, dir)
+	assert.NoError(t, err)
+	policies, err := provider.CompiledPolicies(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, policies, 1)
+	assert.Equal(t, "prod-hosts", policies[0].Name)
+	for host, want := range map[string]codes.Code{"shop.example.com": codes.OK, "admin.example.com": codes.PermissionDenied} {
+		response, err := policies[0].Evaluate(context.Background(), &authv3.CheckRequest{
+			Attributes: &authv3.AttributeContext{
+				Request: &authv3.AttributeContext_Request{
+					Http: &authv3.AttributeContext_HttpRequest{Host: host},
+				},
+			},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(want), response.GetStatus().GetCode(), host)
+	}
+	// a missing value fails to load the files
+	template, err = NewTemplate(map[string]string{"cluster": "prod"})
+	assert.NoError(t, err)
+	_, err = NewFileProvider(NewCompiler(), template, false, dir)
+	assert.ErrorContains(t, err, `map has no entry for key "domain"`)
+	// without template the manifest isn't valid yaml
+	_, err = NewFileProvider(NewCompiler(), nil, false, dir)
+	assert.Error(t, err)
+}
+
+func Test_decodeBlob_template(t *testing.T) {
+	template, err := NewTemplate(map[string]string{"cluster": "prod", "domain": "shop.example.com"})
+	assert.NoError(t, err)
+	policies, err := decodeBlob(strings.NewReader(templatedPolicy), template, false)
+	assert.NoError(t, err)
+	assert.Len(t, policies, 1)
+	assert.Equal(t, "prod-hosts", policies[0].Name)
+}
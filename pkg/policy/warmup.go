@@ -0,0 +1,34 @@
+package policy
+
+import (
+	"context"
+	"time"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+type warmUpCompiler struct {
+	inner   Compiler
+	timeout time.Duration
+}
+
+// NewWarmUpCompiler returns a compiler warming up the policies compiled by the inner compiler, see core.WarmUp.
+// The warm up evaluation is bounded by the timeout, policies are served once warmed up.
+func NewWarmUpCompiler(inner Compiler, timeout time.Duration) Compiler {
+	return &warmUpCompiler{
+		inner:   inner,
+		timeout: timeout,
+	}
+}
+
+func (c *warmUpCompiler) Compile(policy *hub.AuthorizationPolicy) (CompiledPolicy, field.ErrorList) {
+	compiled, errs := c.inner.Compile(policy)
+	if len(errs) == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		defer cancel()
+		core.WarmUp(ctx, compiled)
+	}
+	return compiled, errs
+}
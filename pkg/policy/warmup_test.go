@@ -0,0 +1,46 @@
+package policy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+type evaluationCountingCompiler struct {
+	evaluations int
+	deadline    bool
+}
+
+func (c *evaluationCountingCompiler) Compile(policy *hub.AuthorizationPolicy) (CompiledPolicy, field.ErrorList) {
+	if len(policy.Spec.Authorizations) == 0 {
+		return CompiledPolicy{}, field.ErrorList{field.Required(field.NewPath("spec", "authorizations"), "")}
+	}
+	return CompiledPolicy{
+		Name: policy.Name,
+		Evaluate: func(ctx context.Context, _ *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+			c.evaluations++
+			_, c.deadline = ctx.Deadline()
+			return nil, nil
+		},
+	}, nil
+}
+
+func Test_warmUpCompiler_Compile(t *testing.T) {
+	inner := &evaluationCountingCompiler{}
+	compiler := NewWarmUpCompiler(inner, time.Second)
+	// the compiled policy is evaluated once with a deadline
+	compiled, errs := compiler.Compile(newHubPolicy(t, "valid", "envoy.Allowed().Response()"))
+	assert.Empty(t, errs)
+	assert.Equal(t, "valid", compiled.Name)
+	assert.Equal(t, 1, inner.evaluations)
+	assert.True(t, inner.deadline)
+	// policies failing to compile are not evaluated
+	_, errs = compiler.Compile(newHubPolicy(t, "invalid"))
+	assert.NotEmpty(t, errs)
+	assert.Equal(t, 1, inner.evaluations)
+}
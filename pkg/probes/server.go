@@ -8,20 +8,27 @@ import (
 	"github.com/kyverno/kyverno-envoy-plugin/pkg/server/handlers"
 )
 
-func NewServer(addr string) server.ServerFunc {
+// NewServer returns a server answering the liveness checks on /livez and the readiness checks on /readyz.
+// A failing liveness check gets the process restarted, it must only fail when the process can't recover,
+// a failing readiness check only stops the traffic until it succeeds again.
+func NewServer(addr string, live func() bool, ready func() bool) server.ServerFunc {
 	return func(ctx context.Context) error {
-		// create mux
-		mux := http.NewServeMux()
-		// register health check
-		mux.Handle("GET /livez", handlers.Healthy(True))
-		// register ready check
-		mux.Handle("GET /readyz", handlers.Ready(True))
 		// create server
 		s := &http.Server{
 			Addr:    addr,
-			Handler: mux,
+			Handler: newHandler(live, ready),
 		}
 		// run server
 		return server.RunHttp(ctx, s, "", "")
 	}
 }
+
+func newHandler(live func() bool, ready func() bool) http.Handler {
+	// create mux
+	mux := http.NewServeMux()
+	// register health check
+	mux.Handle("GET /livez", handlers.Healthy(live))
+	// register ready check
+	mux.Handle("GET /readyz", handlers.Ready(ready))
+	return mux
+}
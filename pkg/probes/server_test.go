@@ -0,0 +1,67 @@
+package probes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func probe(t *testing.T, handler http.Handler, path string) int {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	return recorder.Code
+}
+
+func Test_newHandler(t *testing.T) {
+	var synced, failing atomic.Bool
+	handler := newHandler(True, func() bool {
+		return synced.Load() && !failing.Load()
+	})
+	// not ready until the provider synced, the process is alive
+	assert.Equal(t, http.StatusInternalServerError, probe(t, handler, "/readyz"))
+	assert.Equal(t, http.StatusOK, probe(t, handler, "/livez"))
+	synced.Store(true)
+	assert.Equal(t, http.StatusOK, probe(t, handler, "/readyz"))
+	// reconciles failing and recovering only change the readiness
+	for range 3 {
+		failing.Store(true)
+		assert.Equal(t, http.StatusInternalServerError, probe(t, handler, "/readyz"))
+		assert.Equal(t, http.StatusOK, probe(t, handler, "/livez"))
+		failing.Store(false)
+		assert.Equal(t, http.StatusOK, probe(t, handler, "/readyz"))
+		assert.Equal(t, http.StatusOK, probe(t, handler, "/livez"))
+	}
+}
+
+func TestWatchdog_Alive(t *testing.T) {
+	block := make(chan struct{})
+	var blocked atomic.Bool
+	var calls atomic.Int32
+	watchdog := NewWatchdog(50*time.Millisecond, func(ctx context.Context) {
+		calls.Add(1)
+		if blocked.Load() {
+			<-block
+		}
+	})
+	handler := newHandler(watchdog.Alive, True)
+	assert.Equal(t, http.StatusOK, probe(t, handler, "/livez"))
+	assert.Equal(t, http.StatusOK, probe(t, handler, "/livez"))
+	// a deadlocked check fails the liveness
+	blocked.Store(true)
+	assert.Equal(t, http.StatusInternalServerError, probe(t, handler, "/livez"))
+	// the stuck check is not started again
+	assert.Equal(t, http.StatusInternalServerError, probe(t, handler, "/livez"))
+	assert.Equal(t, int32(3), calls.Load())
+	// the liveness recovers once the check returns
+	blocked.Store(false)
+	close(block)
+	assert.Eventually(t, func() bool {
+		return probe(t, handler, "/livez") == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+}
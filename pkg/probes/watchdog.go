@@ -0,0 +1,66 @@
+package probes
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Watchdog is a liveness check failing when a check doesn't return within a timeout, the check of a
+// deadlocked component never returns. A single check runs at a time, a stuck check is not started again.
+type Watchdog struct {
+	timeout time.Duration
+	check   func(context.Context)
+	lock    sync.Mutex
+	// done is closed when the running check returns, nil when no check runs
+	done    chan struct{}
+	started time.Time
+}
+
+// NewWatchdog returns a watchdog running the check on every liveness check, the check is given a context
+// cancelled after the timeout but it is only considered stuck when it doesn't return
+func NewWatchdog(timeout time.Duration, check func(context.Context)) *Watchdog {
+	return &Watchdog{
+		timeout: timeout,
+		check:   check,
+	}
+}
+
+// Alive runs the check and returns false if it didn't return within the timeout,
+// or if the previous check is still running since longer than the timeout
+func (w *Watchdog) Alive() bool {
+	w.lock.Lock()
+	done := w.done
+	if done == nil {
+		done = make(chan struct{})
+		w.done, w.started = done, time.Now()
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+			defer cancel()
+			w.check(ctx)
+			w.lock.Lock()
+			defer w.lock.Unlock()
+			w.done = nil
+			close(done)
+		}()
+	}
+	// the previous check may already be running for some time
+	remaining := w.timeout - time.Since(w.started)
+	w.lock.Unlock()
+	if remaining <= 0 {
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	}
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
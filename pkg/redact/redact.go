@@ -0,0 +1,275 @@
+// Package redact masks or hashes sensitive values of the checked requests, tokens and personal data found in
+// headers for example, before they are written to decision logs, structured logs and trace attributes.
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/types"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Mask replaces the masked values
+const Mask = "[REDACTED]"
+
+// hashPrefix prefixes the hashed values, the hash is the same for the same value and salt
+const hashPrefix = "hash:"
+
+// Action is how a value is redacted
+type Action string
+
+const (
+	// ActionMask replaces the value with Mask
+	ActionMask Action = "Mask"
+	// ActionHash replaces the value with a salted hash of the value, records of the same value can be correlated
+	ActionHash Action = "Hash"
+)
+
+// DefaultHeaders are the headers masked when no rule redacts them, they carry credentials
+var DefaultHeaders = []string{"authorization", "proxy-authorization", "cookie"}
+
+// Rule redacts a request header or a string field of the check request
+type Rule struct {
+	// Header is the name of a request header, it is the same as the object.attributes.request.http.headers["<name>"] path
+	Header string
+	// Path is a CEL path selecting a string field of the check request from the object variable,
+	// object.attributes.request.http.body or object.attributes.request.http.headers["x-api-key"] for example
+	Path string
+	// Action is how the value is redacted
+	Action Action
+}
+
+// step selects a field of a message or the value of a map key
+type step struct {
+	field string
+	key   string
+	isKey bool
+}
+
+type compiledRule struct {
+	steps  []step
+	action Action
+}
+
+// Redactor redacts the values selected by its rules, a nil redactor redacts nothing
+type Redactor struct {
+	rules []compiledRule
+	salt  []byte
+}
+
+// New returns a redactor applying the rules, the default headers are masked unless a rule redacts them.
+// The salt is required to hash values.
+func New(rules []Rule, salt string) (*Redactor, error) {
+	r := &Redactor{salt: []byte(salt)}
+	headers := map[string]bool{}
+	for _, rule := range rules {
+		if rule.Action != ActionMask && rule.Action != ActionHash {
+			return nil, fmt.Errorf("invalid redaction action %q, it must be %s or %s", rule.Action, ActionMask, ActionHash)
+		}
+		if rule.Action == ActionHash && salt == "" {
+			return nil, errors.New("a salt is required to hash redacted values")
+		}
+		if (rule.Header == "") == (rule.Path == "") {
+			return nil, errors.New("exactly one of the header or path of a redaction rule is required")
+		}
+		var steps []step
+		if rule.Header != "" {
+			name := strings.ToLower(rule.Header)
+			headers[name] = true
+			steps = headerSteps(name)
+		} else {
+			var err error
+			if steps, err = parsePath(rule.Path); err != nil {
+				return nil, fmt.Errorf("invalid redaction path %q: %w", rule.Path, err)
+			}
+		}
+		r.rules = append(r.rules, compiledRule{steps: steps, action: rule.Action})
+	}
+	for _, name := range DefaultHeaders {
+		if !headers[name] {
+			r.rules = append(r.rules, compiledRule{steps: headerSteps(name), action: ActionMask})
+		}
+	}
+	return r, nil
+}
+
+// NewRule returns a rule redacting the path when the value starts with the object variable, the header otherwise
+func NewRule(value string, action Action) Rule {
+	if strings.HasPrefix(value, core.ObjectKey+".") {
+		return Rule{Path: value, Action: action}
+	}
+	return Rule{Header: value, Action: action}
+}
+
+func headerSteps(name string) []step {
+	return []step{{field: "attributes"}, {field: "request"}, {field: "http"}, {field: "headers"}, {key: name, isKey: true}}
+}
+
+// parsePath parses a CEL path made of field selections and string map keys from the object variable
+func parsePath(path string) ([]step, error) {
+	env, err := cel.NewEnv()
+	if err != nil {
+		return nil, err
+	}
+	parsed, issues := env.Parse(path)
+	if err := issues.Err(); err != nil {
+		return nil, err
+	}
+	var steps []step
+	expr := parsed.NativeRep().Expr()
+	for {
+		switch expr.Kind() {
+		case ast.IdentKind:
+			if expr.AsIdent() != core.ObjectKey {
+				return nil, fmt.Errorf("the path must start with %s", core.ObjectKey)
+			}
+			if len(steps) == 0 {
+				return nil, errors.New("the path must select a field")
+			}
+			// the steps were collected from the last one
+			for i, j := 0, len(steps)-1; i < j; i, j = i+1, j-1 {
+				steps[i], steps[j] = steps[j], steps[i]
+			}
+			return steps, nil
+		case ast.SelectKind:
+			if expr.AsSelect().IsTestOnly() {
+				return nil, errors.New("presence tests are not paths")
+			}
+			steps = append(steps, step{field: expr.AsSelect().FieldName()})
+			expr = expr.AsSelect().Operand()
+		case ast.CallKind:
+			call := expr.AsCall()
+			if call.FunctionName() != operators.Index || len(call.Args()) != 2 || call.Args()[1].Kind() != ast.LiteralKind {
+				return nil, errors.New("only field selections and string map keys are supported")
+			}
+			key, ok := call.Args()[1].AsLiteral().(types.String)
+			if !ok {
+				return nil, errors.New("only field selections and string map keys are supported")
+			}
+			steps = append(steps, step{key: string(key), isKey: true})
+			expr = call.Args()[0]
+		default:
+			return nil, errors.New("only field selections and string map keys are supported")
+		}
+	}
+}
+
+// redact returns the redacted value
+func (r *Redactor) redact(action Action, value string) string {
+	if action == ActionHash {
+		mac := hmac.New(sha256.New, r.salt)
+		mac.Write([]byte(value))
+		return hashPrefix + hex.EncodeToString(mac.Sum(nil)[:16])
+	}
+	return Mask
+}
+
+// visit calls the function with every string value selected by the rule, the value can be replaced
+func visit(message protoreflect.Message, steps []step, fn func(string) string) {
+	if len(steps) == 0 || steps[0].isKey {
+		return
+	}
+	field := message.Descriptor().Fields().ByName(protoreflect.Name(steps[0].field))
+	if field == nil || !message.Has(field) {
+		return
+	}
+	rest := steps[1:]
+	switch {
+	case field.IsMap():
+		if len(rest) != 1 || !rest[0].isKey || field.MapKey().Kind() != protoreflect.StringKind || field.MapValue().Kind() != protoreflect.StringKind {
+			return
+		}
+		entries := message.Mutable(field).Map()
+		key := protoreflect.ValueOfString(rest[0].key).MapKey()
+		if entries.Has(key) {
+			entries.Set(key, protoreflect.ValueOfString(fn(entries.Get(key).String())))
+		}
+	case field.IsList():
+	case field.Kind() == protoreflect.MessageKind:
+		visit(message.Mutable(field).Message(), rest, fn)
+	case field.Kind() == protoreflect.StringKind && len(rest) == 0:
+		message.Set(field, protoreflect.ValueOfString(fn(message.Get(field).String())))
+	case field.Kind() == protoreflect.BytesKind && len(rest) == 0:
+		message.Set(field, protoreflect.ValueOfBytes([]byte(fn(string(message.Get(field).Bytes())))))
+	}
+}
+
+// Request returns a copy of the request with the redacted values replaced, the request is returned as is by
+// a nil redactor
+func (r *Redactor) Request(request *authv3.CheckRequest) *authv3.CheckRequest {
+	if r == nil || request == nil {
+		return request
+	}
+	out := proto.Clone(request).(*authv3.CheckRequest)
+	for _, rule := range r.rules {
+		visit(out.ProtoReflect(), rule.steps, func(value string) string {
+			return r.redact(rule.action, value)
+		})
+	}
+	return out
+}
+
+// String replaces the redacted values of the request found in the text, an error message for example.
+// The credentials of a value of the form "<scheme> <credentials>", like the authorization header, are
+// replaced too as they are often written without their scheme.
+func (r *Redactor) String(request *authv3.CheckRequest, text string) string {
+	if r == nil || request == nil || text == "" {
+		return text
+	}
+	var replacements []string
+	for _, rule := range r.rules {
+		// visiting sets the values, they are read from a copy so the request can be read concurrently
+		visit(proto.Clone(request).ProtoReflect(), rule.steps, func(value string) string {
+			if value != "" {
+				redacted := r.redact(rule.action, value)
+				replacements = append(replacements, value, redacted)
+				if _, credentials, ok := strings.Cut(value, " "); ok && credentials != "" {
+					replacements = append(replacements, credentials, redacted)
+				}
+			}
+			return value
+		})
+	}
+	if len(replacements) == 0 {
+		return text
+	}
+	return strings.NewReplacer(replacements...).Replace(text)
+}
+
+// Error returns an error whose message has the redacted values of the request replaced,
+// the error is returned as is if it doesn't contain any
+func (r *Redactor) Error(request *authv3.CheckRequest, err error) error {
+	if r == nil || err == nil {
+		return err
+	}
+	message := err.Error()
+	if redacted := r.String(request, message); redacted != message {
+		return &redactedError{message: redacted, err: err}
+	}
+	return err
+}
+
+// redactedError keeps the redacted error in the chain so that errors.Is and errors.As still work
+type redactedError struct {
+	message string
+	err     error
+}
+
+func (e *redactedError) Error() string {
+	return e.message
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// CertReloader serves a certificate and key pair read from files, the pair is read again when the files change
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+}
+
+// NewCertReloader loads the certificate and key pair, it fails if the pair is invalid
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, it is meant to be used as tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// TLSConfig returns a server tls config serving the current certificate
+func (r *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+}
+
+// Run watches the certificate and key files and reloads them when they change, until the context is cancelled.
+// The current pair is kept when the new one is invalid, a rotation can write the files one after the other.
+func (r *CertReloader) Run(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("certs").WithValues("cert", r.certFile, "key", r.keyFile)
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	// watch the directories, kubernetes updates mounted secrets by swapping a symlink
+	dirs := []string{filepath.Dir(r.certFile), filepath.Dir(r.keyFile)}
+	slices.Sort(dirs)
+	for _, dir := range slices.Compact(dirs) {
+		if err := watcher.Add(dir); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			// chmod alone doesn't change the content
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			if err := r.reload(); err != nil {
+				logger.Error(err, "failed to reload certificate, keeping the current one", "file", event.Name)
+			} else {
+				logger.Info("reloaded certificate", "file", event.Name)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logger.Error(err, "certificate watcher error")
+		}
+	}
+}
+
+// LoadCertPool returns a pool of the PEM encoded certificates read from the file, it fails if the file has none
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in %s", file)
+	}
+	return pool, nil
+}
+
+func (r *CertReloader) reload() error {
+	// the key must match the certificate
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+	if cert.Leaf == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		cert.Leaf = leaf
+	}
+	// only swap when the certificate changed
+	if current := r.cert.Load(); current != nil && current.Leaf.Equal(cert.Leaf) {
+		return nil
+	}
+	r.cert.Store(&cert)
+	return nil
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert writes a self signed certificate and its key, files are renamed in place to simulate a rotation
+func writeCert(t *testing.T, certFile, keyFile, name string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{name},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	write := func(file string, block *pem.Block) {
+		tmp := file + ".tmp"
+		require.NoError(t, os.WriteFile(tmp, pem.EncodeToMemory(block), 0o600))
+		require.NoError(t, os.Rename(tmp, file))
+	}
+	write(keyFile, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	write(certFile, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// servedName returns the common name of the certificate served by the listener
+func servedName(t *testing.T, addr string) string {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true}) //nolint:gosec
+	require.NoError(t, err)
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	writeCert(t, certFile, keyFile, "first")
+	reloader, err := NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+	// serve tls with the reloader
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", reloader.TLSConfig())
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				_ = conn.(*tls.Conn).Handshake()
+			}(conn)
+		}
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- reloader.Run(ctx)
+	}()
+	addr := listener.Addr().String()
+	assert.Equal(t, "first", servedName(t, addr))
+	// rotate the certificate
+	writeCert(t, certFile, keyFile, "second")
+	assert.Eventually(t, func() bool {
+		return servedName(t, addr) == "second"
+	}, 5*time.Second, 10*time.Millisecond)
+	// an invalid pair keeps the current certificate
+	require.NoError(t, os.WriteFile(certFile, []byte("invalid"), 0o600))
+	assert.Never(t, func() bool {
+		return servedName(t, addr) != "second"
+	}, 200*time.Millisecond, 10*time.Millisecond)
+	// stop watching
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestNewCertReloader_invalid(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	// missing files
+	_, err := NewCertReloader(certFile, keyFile)
+	assert.Error(t, err)
+	// the key doesn't match the certificate
+	writeCert(t, certFile, keyFile, "first")
+	otherKey := filepath.Join(dir, "other.key")
+	writeCert(t, filepath.Join(dir, "other.crt"), otherKey, "other")
+	_, err = NewCertReloader(certFile, otherKey)
+	assert.Error(t, err)
+}
+
+func TestLoadCertPool(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "ca.crt")
+	keyFile := filepath.Join(dir, "ca.key")
+	// missing file
+	_, err := LoadCertPool(certFile)
+	assert.Error(t, err)
+	// a file without certificate
+	empty := filepath.Join(dir, "empty.crt")
+	require.NoError(t, os.WriteFile(empty, nil, 0o600))
+	_, err = LoadCertPool(empty)
+	assert.ErrorContains(t, err, "no certificate found")
+	writeCert(t, certFile, keyFile, "ca")
+	pool, err := LoadCertPool(certFile)
+	assert.NoError(t, err)
+	assert.NotNil(t, pool)
+}
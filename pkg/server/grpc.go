@@ -2,32 +2,68 @@ package server
 
 import (
 	"context"
-	"fmt"
 	"net"
+	"time"
 
+	"go.uber.org/multierr"
 	"google.golang.org/grpc"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-func RunGrpc(ctx context.Context, server *grpc.Server, listener net.Listener) error {
-	defer fmt.Println("GRPC Server stopped")
-	// create a wait group
-	var group wait.Group
-	// wait all tasks in the group are over
-	defer group.Wait()
-	// create a cancellable context
-	ctx, cancel := context.WithCancel(ctx)
-	// cancel context at the end
-	defer cancel()
-	// shutdown server when context is cancelled
-	group.StartWithContext(ctx, func(ctx context.Context) {
-		// wait context cancelled
-		<-ctx.Done()
-		fmt.Println("GRPC Server shutting down...")
-		// gracefully shutdown server
-		server.GracefulStop()
-	})
-	fmt.Printf("GRPC Server starting at %s...\n", listener.Addr())
-	// serve
-	return server.Serve(listener)
+type GrpcServer struct {
+	*grpc.Server
+}
+
+// Shutdown stops accepting new streams and waits for in-flight calls to complete,
+// if the context is done first the remaining calls are cancelled
+func (s GrpcServer) Shutdown(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		s.GracefulStop()
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		// force stop, this unblocks graceful stop
+		s.Stop()
+		<-stopped
+		return ctx.Err()
+	}
+}
+
+func RunGrpc(ctx context.Context, server *grpc.Server, listener net.Listener, shutdownTimeout time.Duration) error {
+	logger := log.FromContext(ctx).WithValues("address", listener.Addr().String())
+	defer logger.Info("GRPC Server stopped")
+	// track shutdown error
+	var shutdownErr error
+	// track serve error
+	serveErr := func(ctx context.Context) error {
+		// create a wait group
+		var group wait.Group
+		// wait all tasks in the group are over
+		defer group.Wait()
+		// create a cancellable context
+		ctx, cancel := context.WithCancel(ctx)
+		// cancel context at the end
+		defer cancel()
+		// shutdown server when context is cancelled
+		group.StartWithContext(ctx, func(ctx context.Context) {
+			// wait context cancelled
+			<-ctx.Done()
+			logger.Info("GRPC Server shutting down...")
+			// create a context with timeout
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			// gracefully shutdown server
+			shutdownErr = GrpcServer{server}.Shutdown(ctx)
+		})
+		logger.Info("GRPC Server starting...")
+		// serve
+		return server.Serve(listener)
+	}(ctx)
+	// return error if any
+	return multierr.Combine(serveErr, shutdownErr)
 }
@@ -0,0 +1,94 @@
+package conversion
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// NewHandler returns the handler of the conversion webhook, the scheme must know the hub and the served versions.
+// Objects are converted to the hub version, then from the hub to the desired version: served versions never
+// convert to each other directly and adding a served version only requires conversions to and from the hub.
+func NewHandler(scheme *runtime.Scheme) http.Handler {
+	return &handler{
+		scheme:  scheme,
+		decoder: serializer.NewCodecFactory(scheme).UniversalDeserializer(),
+	}
+}
+
+type handler struct {
+	scheme  *runtime.Scheme
+	decoder runtime.Decoder
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger := log.FromContext(r.Context())
+	var review apiextensionsv1.ConversionReview
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil || review.Request == nil {
+		http.Error(w, "invalid conversion review", http.StatusBadRequest)
+		return
+	}
+	response, err := h.convert(review.Request)
+	if err != nil {
+		logger.Error(err, "failed to convert", "uid", review.Request.UID, "desiredAPIVersion", review.Request.DesiredAPIVersion)
+		response = &apiextensionsv1.ConversionResponse{
+			Result: metav1.Status{
+				Status:  metav1.StatusFailure,
+				Message: err.Error(),
+			},
+		}
+	}
+	response.UID = review.Request.UID
+	review.Request = nil
+	review.Response = response
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&review); err != nil {
+		logger.Error(err, "failed to write conversion response")
+	}
+}
+
+// convert converts every object of the request, the request fails if any of the objects fails to convert
+func (h *handler) convert(request *apiextensionsv1.ConversionRequest) (*apiextensionsv1.ConversionResponse, error) {
+	desired, err := schema.ParseGroupVersion(request.DesiredAPIVersion)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]runtime.RawExtension, 0, len(request.Objects))
+	for _, raw := range request.Objects {
+		src, gvk, err := h.decoder.Decode(raw.Raw, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		internal, err := h.scheme.New(hub.SchemeGroupVersion.WithKind(gvk.Kind))
+		if err != nil {
+			return nil, err
+		}
+		if err := h.scheme.Convert(src, internal, nil); err != nil {
+			return nil, fmt.Errorf("failed to convert %s to the hub version: %w", gvk, err)
+		}
+		dst, err := h.scheme.New(desired.WithKind(gvk.Kind))
+		if err != nil {
+			return nil, err
+		}
+		if err := h.scheme.Convert(internal, dst, nil); err != nil {
+			return nil, fmt.Errorf("failed to convert %s from the hub version: %w", desired.WithKind(gvk.Kind), err)
+		}
+		// conversions don't set the type meta
+		dst.GetObjectKind().SetGroupVersionKind(desired.WithKind(gvk.Kind))
+		objects = append(objects, runtime.RawExtension{Object: dst})
+	}
+	return &apiextensionsv1.ConversionResponse{
+		ConvertedObjects: objects,
+		Result: metav1.Status{
+			Status: metav1.StatusSuccess,
+		},
+	}, nil
+}
@@ -0,0 +1,120 @@
+package conversion
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const policy = `{
+  "apiVersion": "envoy.kyverno.io/v1alpha1",
+  "kind": "AuthorizationPolicy",
+  "metadata": {"name": "demo", "labels": {"team": "foo"}, "creationTimestamp": null},
+  "spec": {
+    "priority": 10,
+    "failurePolicy": "Ignore",
+    "variables": [{"name": "allowed", "expression": "true"}],
+    "authorizations": [{"expression": "envoy.Allowed().Response()"}],
+    "headers": {"request": [{"name": "x-team", "action": "Set", "expression": "'foo'"}]},
+    "denyResponse": {"status": "429"},
+    "reason": "'demo'"
+  },
+  "status": {"conditions": [{"type": "Ready", "status": "True", "reason": "Compiled", "message": "", "lastTransitionTime": "2024-01-02T03:04:05Z"}]}
+}`
+
+func review(t *testing.T, handler http.Handler, desiredAPIVersion string, objects ...string) apiextensionsv1.ConversionReview {
+	t.Helper()
+	request := apiextensionsv1.ConversionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apiextensions.k8s.io/v1", Kind: "ConversionReview"},
+		Request: &apiextensionsv1.ConversionRequest{
+			UID:               "42",
+			DesiredAPIVersion: desiredAPIVersion,
+		},
+	}
+	for _, object := range objects {
+		request.Request.Objects = append(request.Request.Objects, runtime.RawExtension{Raw: []byte(object)})
+	}
+	body, err := json.Marshal(request)
+	require.NoError(t, err)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/convert", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var response apiextensionsv1.ConversionReview
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	require.NotNil(t, response.Response)
+	assert.Equal(t, "42", string(response.Response.UID))
+	assert.Nil(t, response.Request)
+	return response
+}
+
+func newScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	require.NoError(t, hub.Install(scheme))
+	require.NoError(t, v1alpha1.Install(scheme))
+	return scheme
+}
+
+func TestNewHandler(t *testing.T) {
+	handler := NewHandler(newScheme(t))
+	// converting through the hub is lossless
+	response := review(t, handler, "envoy.kyverno.io/v1alpha1", policy, policy)
+	assert.Equal(t, metav1.StatusSuccess, response.Response.Result.Status)
+	require.Len(t, response.Response.ConvertedObjects, 2)
+	for _, object := range response.Response.ConvertedObjects {
+		assert.JSONEq(t, policy, string(object.Raw))
+	}
+}
+
+func TestNewHandler_failure(t *testing.T) {
+	handler := NewHandler(newScheme(t))
+	tests := []struct {
+		name              string
+		desiredAPIVersion string
+		object            string
+		wantMessage       string
+	}{{
+		name:              "unknown desired version",
+		desiredAPIVersion: "envoy.kyverno.io/v1",
+		object:            policy,
+		wantMessage:       `no kind "AuthorizationPolicy" is registered for version "envoy.kyverno.io/v1"`,
+	}, {
+		name:              "unknown source version",
+		desiredAPIVersion: "envoy.kyverno.io/v1alpha1",
+		object:            strings.Replace(policy, "envoy.kyverno.io/v1alpha1", "envoy.kyverno.io/v1", 1),
+		wantMessage:       `no kind "AuthorizationPolicy" is registered for version "envoy.kyverno.io/v1"`,
+	}, {
+		name:              "invalid object",
+		desiredAPIVersion: "envoy.kyverno.io/v1alpha1",
+		object:            `{"apiVersion": "envoy.kyverno.io/v1alpha1", "kind": "AuthorizationPolicy", "spec": "invalid"}`,
+		wantMessage:       "cannot unmarshal string",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := review(t, handler, tt.desiredAPIVersion, tt.object)
+			assert.Equal(t, metav1.StatusFailure, response.Response.Result.Status)
+			assert.Contains(t, response.Response.Result.Message, tt.wantMessage)
+			assert.Empty(t, response.Response.ConvertedObjects)
+		})
+	}
+}
+
+func TestNewHandler_invalidReview(t *testing.T) {
+	handler := NewHandler(newScheme(t))
+	for _, body := range []string{`not json`, `{}`} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/convert", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, body)
+	}
+}
@@ -4,48 +4,50 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
-func NewValidator(compile func(*v1alpha1.AuthorizationPolicy) field.ErrorList) *validator {
+// NewValidator returns a validator rejecting policies that don't compile,
+// it should be given the same compiler as the authz server so that validation and runtime don't drift
+func NewValidator(compiler policy.Compiler) *validator {
 	return &validator{
-		compile: compile,
+		compiler: compiler,
 	}
 }
 
 type validator struct {
-	compile func(*v1alpha1.AuthorizationPolicy) field.ErrorList
+	compiler policy.Compiler
 }
 
 func (v *validator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
-	policy, ok := obj.(*v1alpha1.AuthorizationPolicy)
-	if !ok {
-		return nil, fmt.Errorf("expected an AuthorizationPolicy object but got %T", obj)
-	}
-	return nil, v.validate(policy)
+	return nil, v.validate(ctx, obj)
 }
 
 func (v *validator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
-	policy, ok := newObj.(*v1alpha1.AuthorizationPolicy)
-	if !ok {
-		return nil, fmt.Errorf("expected an AuthorizationPolicy object but got %T", newObj)
-	}
-	return nil, v.validate(policy)
+	return nil, v.validate(ctx, newObj)
 }
 
 func (*validator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
 	return nil, nil
 }
 
-func (v *validator) validate(policy *v1alpha1.AuthorizationPolicy) error {
-	if allErrs := v.compile(policy); len(allErrs) > 0 {
+// validate compiles the hub version of the policy, every served version is validated the same way
+func (v *validator) validate(ctx context.Context, obj runtime.Object) error {
+	converted, err := policy.ConvertPolicy(obj)
+	if err != nil {
+		return fmt.Errorf("expected an AuthorizationPolicy object but got %T: %w", obj, err)
+	}
+	_, allErrs := v.compiler.Compile(converted)
+	log.FromContext(ctx).Info("validating policy", "name", converted.Name, "errors", allErrs.ToAggregate())
+	if len(allErrs) > 0 {
 		return apierrors.NewInvalid(
-			v1alpha1.SchemeGroupVersion.WithKind("AuthorizationPolicy").GroupKind(),
-			policy.Name,
+			hub.SchemeGroupVersion.WithKind("AuthorizationPolicy").GroupKind(),
+			converted.Name,
 			allErrs,
 		)
 	}
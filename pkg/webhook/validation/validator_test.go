@@ -0,0 +1,65 @@
+package validation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kyverno/kyverno-envoy-plugin/apis/v1alpha1"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_validator(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		wantErr    string
+	}{{
+		name:       "valid",
+		expression: `envoy.Allowed().Response()`,
+	}, {
+		name:       "invalid cel expression",
+		expression: `envoy.Allowed(`,
+		wantErr:    `AuthorizationPolicy.envoy.kyverno.io "demo" is invalid: spec.authorizations[0].expression: Invalid value: "envoy.Allowed(": ERROR: <input>:1:15: Syntax error: mismatched input '<EOF>'`,
+	}, {
+		name:       "wrong output type",
+		expression: `"allowed"`,
+		wantErr:    `AuthorizationPolicy.envoy.kyverno.io "demo" is invalid: spec.authorizations[0].expression: Invalid value: "\"allowed\"": rule output is expected to be of type envoy.service.auth.v3.CheckResponse`,
+	}, {
+		name:       "k8s.Get",
+		expression: `k8s.Get("v1", "ConfigMap", "default", "allowed").data.enabled == "true" ? envoy.Allowed().Response() : envoy.Denied(403).Response()`,
+	}, {
+		name:       "http.Get",
+		expression: `http.Get("https://example.com/allowed").allowed ? envoy.Allowed().Response() : envoy.Denied(403).Response()`,
+	}, {
+		name:       "http.Post",
+		expression: `http.Post("https://example.com/check", {"path": object.attributes.request.http.path}).allowed ? envoy.Allowed().Response() : envoy.Denied(403).Response()`,
+	}, {
+		name:       "jwt.Verify with the key set",
+		expression: `jwt.Verify(object.attributes.request.http.headers[?"authorization"].orValue("")).Valid ? envoy.Allowed().Response() : envoy.Denied(401).Response()`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the compiler of the validation webhook command
+			v := NewValidator(policy.NewCompiler(policy.CompilerConfig{TypeCheckOnly: true}.Options()...))
+			obj := &v1alpha1.AuthorizationPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "demo"},
+				Spec: v1alpha1.AuthorizationPolicySpec{
+					Authorizations: []v1alpha1.Authorization{{Expression: tt.expression}},
+				},
+			}
+			_, createErr := v.ValidateCreate(context.Background(), obj)
+			_, updateErr := v.ValidateUpdate(context.Background(), obj, obj)
+			for _, err := range []error{createErr, updateErr} {
+				if tt.wantErr == "" {
+					assert.NoError(t, err)
+				} else {
+					assert.True(t, apierrors.IsInvalid(err))
+					assert.ErrorContains(t, err, tt.wantErr)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+//go:build conformance
+
+// Package conformance checks the decisions of the authz server end to end, through a real envoy configured for
+// ext_authz. The requests and expected outcomes are read from testdata/cases.yaml and the policies from
+// testdata/policies, run it with:
+//
+//	go test -tags conformance ./tests/conformance/...
+//
+// Envoy is run from the ENVOY_BIN binary, the envoy binary of the PATH or the ENVOY_IMAGE docker image.
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/authz"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/yaml"
+)
+
+// testCase is a request sent through envoy and the outcome expected from the policies
+type testCase struct {
+	Name    string `json:"name"`
+	Request struct {
+		Method  string            `json:"method"`
+		Path    string            `json:"path"`
+		Headers map[string]string `json:"headers,omitempty"`
+		Body    string            `json:"body,omitempty"`
+	} `json:"request"`
+	Expect struct {
+		Status int `json:"status"`
+		// Body is the body of a denied response, it is not checked when empty
+		Body string `json:"body,omitempty"`
+		// UpstreamHeaders are headers the upstream must receive, an empty value means the header must be absent
+		UpstreamHeaders map[string]string `json:"upstreamHeaders,omitempty"`
+	} `json:"expect"`
+}
+
+func loadCases(t *testing.T, path string) []testCase {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var cases []testCase
+	require.NoError(t, yaml.UnmarshalStrict(data, &cases))
+	require.NotEmpty(t, cases)
+	return cases
+}
+
+// freePort returns a port nothing listens on, envoy and the authz server listen on the ports picked by the test
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// waitFor polls the condition until it is true, the test fails once the timeout expired
+func waitFor(t *testing.T, timeout time.Duration, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// startAuthzServer runs the grpc authz server with the policies of the directory, the policies are loaded once
+func startAuthzServer(t *testing.T, dir string) int {
+	t.Helper()
+	provider, err := policy.NewFileProvider(policy.NewCompiler(), nil, true, dir)
+	require.NoError(t, err)
+	port := freePort(t)
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		errs <- authz.NewServer("tcp", addr, nil, provider, nil, nil, nil, nil, nil, nil, authz.DefaultDecision{}, authz.DefaultDecision{}, "", 0, 0, 5*time.Second, false, nil, nil, nil, nil, nil, false).Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-errs)
+	})
+	waitFor(t, 10*time.Second, "the authz server", func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	})
+	return port
+}
+
+// newUpstream returns the server envoy forwards the allowed requests to, it responds with the headers it received
+func newUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers := map[string]string{}
+		for name := range r.Header {
+			headers[strings.ToLower(name)] = r.Header.Get(name)
+		}
+		w.Header().Set("content-type", "application/json")
+		_ = json.NewEncoder(w).Encode(headers)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestConformance(t *testing.T) {
+	cases := loadCases(t, "testdata/cases.yaml")
+	upstream := newUpstream(t)
+	ports := envoyPorts{
+		AdminPort:    freePort(t),
+		ListenerPort: freePort(t),
+		AuthzPort:    startAuthzServer(t, "testdata/policies"),
+		UpstreamPort: upstream.Listener.Addr().(*net.TCPAddr).Port,
+	}
+	startEnvoy(t, "testdata/envoy.yaml", ports)
+	client := &http.Client{Timeout: 5 * time.Second}
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			url := "http://" + net.JoinHostPort("127.0.0.1", strconv.Itoa(ports.ListenerPort)) + tc.Request.Path
+			request, err := http.NewRequest(tc.Request.Method, url, strings.NewReader(tc.Request.Body))
+			require.NoError(t, err)
+			for name, value := range tc.Request.Headers {
+				request.Header.Set(name, value)
+			}
+			response, err := client.Do(request)
+			require.NoError(t, err)
+			defer response.Body.Close()
+			body, err := io.ReadAll(response.Body)
+			require.NoError(t, err)
+			require.Equal(t, tc.Expect.Status, response.StatusCode, string(body))
+			if tc.Expect.Body != "" {
+				assert.Equal(t, tc.Expect.Body, string(body))
+			}
+			if len(tc.Expect.UpstreamHeaders) == 0 {
+				return
+			}
+			// the body of an allowed request is the headers the upstream received
+			var received map[string]string
+			require.NoError(t, json.Unmarshal(body, &received), string(body))
+			for name, want := range tc.Expect.UpstreamHeaders {
+				got, ok := received[name]
+				if want == "" {
+					assert.False(t, ok, "the upstream received the %s header", name)
+				} else {
+					assert.Equal(t, want, got, name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+//go:build conformance
+
+package conformance
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// defaultEnvoyImage is the image run with docker when no envoy binary is found
+const defaultEnvoyImage = "envoyproxy/envoy:v1.31-latest"
+
+// envoyPorts are the ports the envoy configuration is rendered with
+type envoyPorts struct {
+	AdminPort    int
+	ListenerPort int
+	AuthzPort    int
+	UpstreamPort int
+}
+
+// syncBuffer collects the output of envoy, it is written by the process and read by the test
+type syncBuffer struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.String()
+}
+
+// envoyCommand returns the command running envoy with the configuration of the directory. The binary of ENVOY_BIN
+// is preferred, then the envoy binary of the PATH and the ENVOY_IMAGE image run with docker. Docker shares the host
+// network so that envoy reaches the servers of the test, it requires a linux host.
+func envoyCommand(t *testing.T, dir string) *exec.Cmd {
+	t.Helper()
+	config := filepath.Join(dir, "envoy.yaml")
+	args := []string{"--config-path", config, "--log-level", "warn", "--disable-hot-restart"}
+	if bin := os.Getenv("ENVOY_BIN"); bin != "" {
+		return exec.Command(bin, args...)
+	}
+	if bin, err := exec.LookPath("envoy"); err == nil {
+		return exec.Command(bin, args...)
+	}
+	docker, err := exec.LookPath("docker")
+	if err != nil {
+		t.Fatal("envoy is required, set ENVOY_BIN or install envoy or docker")
+	}
+	image := os.Getenv("ENVOY_IMAGE")
+	if image == "" {
+		image = defaultEnvoyImage
+	}
+	name := fmt.Sprintf("kyverno-conformance-%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		_ = exec.Command(docker, "rm", "--force", name).Run()
+	})
+	return exec.Command(docker, append([]string{
+		"run", "--rm", "--name", name, "--network", "host", "--volume", dir + ":" + dir + ":ro", image,
+	}, args...)...)
+}
+
+// startEnvoy renders the configuration template with the ports and runs envoy until the test ends, it returns once
+// envoy is ready. The output of envoy is logged when the test fails.
+func startEnvoy(t *testing.T, path string, ports envoyPorts) {
+	t.Helper()
+	tmpl, err := template.ParseFiles(path)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	file, err := os.Create(filepath.Join(dir, "envoy.yaml"))
+	require.NoError(t, err)
+	require.NoError(t, tmpl.Execute(file, ports))
+	require.NoError(t, file.Close())
+	cmd := envoyCommand(t, dir)
+	output := &syncBuffer{}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	require.NoError(t, cmd.Start())
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		_ = cmd.Wait()
+	}()
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		<-exited
+		if t.Failed() {
+			t.Logf("envoy output:\n%s", output.String())
+		}
+	})
+	// pulling the image can take a while
+	ready := "http://" + net.JoinHostPort("127.0.0.1", strconv.Itoa(ports.AdminPort)) + "/ready"
+	waitFor(t, 2*time.Minute, "envoy", func() bool {
+		select {
+		case <-exited:
+			t.Fatalf("envoy exited:\n%s", output.String())
+		default:
+		}
+		response, err := http.Get(ready)
+		if err != nil {
+			return false
+		}
+		response.Body.Close()
+		return response.StatusCode == http.StatusOK
+	})
+}
@@ -0,0 +1,87 @@
+//go:build wasip1
+
+// Command wasm evaluates policies in process, without the authorization servers. It builds for the wasip1 target:
+//
+//	GOOS=wasip1 GOARCH=wasm go build -o kyverno-authz.wasm ./wasm
+//
+// Policies are read from the files given as arguments, check requests are read from stdin and the
+// corresponding check responses are written to stdout, one json document per line.
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/kyverno/kyverno-envoy-plugin/apis/hub"
+	"github.com/kyverno/kyverno-envoy-plugin/pkg/policy/core"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// noDecision is returned when no policy returned a response, like the servers default decision
+var noDecision = &authv3.CheckResponse{
+	Status: &status.Status{
+		Code: int32(codes.PermissionDenied),
+	},
+	HttpResponse: &authv3.CheckResponse_DeniedResponse{
+		DeniedResponse: &authv3.DeniedHttpResponse{
+			Status: &typev3.HttpStatus{Code: typev3.StatusCode_Forbidden},
+		},
+	},
+}
+
+func main() {
+	if err := run(context.Background(), os.Args[1:]...); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, paths ...string) error {
+	// load and compile policies
+	var policies []*hub.AuthorizationPolicy
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		loaded, err := core.DecodePolicies(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", path, err)
+		}
+		policies = append(policies, loaded...)
+	}
+	provider, err := core.NewStaticProvider(core.NewCompiler(), policies...)
+	if err != nil {
+		return err
+	}
+	compiled, err := provider.CompiledPolicies(ctx)
+	if err != nil {
+		return err
+	}
+	// evaluate check requests
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var request authv3.CheckRequest
+		if err := protojson.Unmarshal(scanner.Bytes(), &request); err != nil {
+			return fmt.Errorf("failed to decode check request: %w", err)
+		}
+		response := core.Evaluate(ctx, compiled, &request)
+		if response == nil {
+			response = noDecision
+		}
+		out, err := protojson.Marshal(response)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	}
+	return scanner.Err()
+}